@@ -588,6 +588,36 @@ let
     # Authentik ForwardAuth for apps protected by proxy outpost
     # This calls Authentik's embedded outpost to authenticate users and set X-Authentik-* headers
     ++ optionals authentikEnabled [
+      # Drop client-sent identity headers before authentik-forward-auth.
+      # Requests matching a proxy provider's skipPathRegex pass the outpost
+      # without it setting X-Authentik-*, and nothing in front of
+      # auth-manager sets the other proxies' headers, so whatever the client
+      # sent would otherwise reach auth-manager and the backend as the
+      # user's identity. Keep in sync with auth-manager's identity package,
+      # whose tests check this list.
+      { "authentik-strip-identity" = {
+          headers.customRequestHeaders = {
+            "X-Authentik-Username" = "";
+            "X-Authentik-Groups" = "";
+            "X-Authentik-Email" = "";
+            "X-Authentik-Name" = "";
+            "X-Authentik-Uid" = "";
+            "X-Authentik-Jwt" = "";
+            "X-Pomerium-Claim-Email" = "";
+            "X-Pomerium-Claim-Preferred-Username" = "";
+            "X-Pomerium-Claim-User" = "";
+            "X-Pomerium-Claim-Name" = "";
+            "X-Pomerium-Claim-Groups" = "";
+            "X-Auth-Request-Email" = "";
+            "X-Auth-Request-User" = "";
+            "X-Auth-Request-Name" = "";
+            "X-Auth-Request-Groups" = "";
+            "X-Forwarded-Email" = "";
+            "X-Forwarded-User" = "";
+            "Remote-User" = "";
+          };
+        };
+      }
       { "authentik-forward-auth" = {
           forwardAuth = {
             address = "http://127.0.0.1:${authentikPortStr}/outpost.goauthentik.io/auth/traefik";
@@ -890,7 +920,7 @@ let
         # SSO middleware chain: Authentik forward-auth sets X-Authentik-* headers, then auth-manager creates MM session
         ssoMiddlewares =
           if authManagerEnabled && authentikEnabled
-          then [ "authentik-forward-auth" "mattermost-auth" ]
+          then [ "authentik-strip-identity" "authentik-forward-auth" "mattermost-auth" ]
           else [];
         baseMiddlewares = defaultSecurityMiddlewares ++ ssoMiddlewares ++ [ "mattermost-strip-prefix" "mattermost-buffering" ];
       in [
@@ -907,7 +937,7 @@ let
       let
         ssoMiddlewares =
           if authManagerEnabled && authentikEnabled
-          then [ "authentik-forward-auth" "mattermost-auth" ]
+          then [ "authentik-strip-identity" "authentik-forward-auth" "mattermost-auth" ]
           else [];
         baseMiddlewares = defaultSecurityMiddlewares ++ ssoMiddlewares ++ [ "mattermost-strip-prefix" "mattermost-buffering" ];
       in [
@@ -924,7 +954,7 @@ let
       let
         ssoMiddlewares =
           if authManagerEnabled && authentikEnabled
-          then [ "authentik-forward-auth" "mattermost-auth" ]
+          then [ "authentik-strip-identity" "authentik-forward-auth" "mattermost-auth" ]
           else [];
         baseMiddlewares = defaultSecurityMiddlewares ++ ssoMiddlewares ++ [ "mattermost-strip-prefix" "mattermost-buffering" ];
       in [
@@ -941,7 +971,7 @@ let
         # SSO middleware chain: Authentik forward-auth sets X-Authentik-* headers, then auth-manager provisions n8n user
         ssoMiddlewares =
           if authManagerEnabled && authentikEnabled
-          then [ "authentik-forward-auth" "n8n-auth" ]
+          then [ "authentik-strip-identity" "authentik-forward-auth" "n8n-auth" ]
           else [];
         baseMiddlewares = defaultSecurityMiddlewares ++ ssoMiddlewares ++ [ "n8n-strip-prefix" "n8n-headers" "n8n-buffering" ];
       in [
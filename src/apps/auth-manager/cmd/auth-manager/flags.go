@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/config"
+)
+
+// bindFlags registers flags for the major settings on fs, defaulting to the
+// values already in cfg so that flags override the environment. Secrets have
+// no flags: command lines are visible to every local user.
+func bindFlags(fs *flag.FlagSet, cfg *config.Config) {
+	fs.StringVar(&cfg.ListenAddr, "listen-addr", cfg.ListenAddr, "HTTP listen address (AUTH_MANAGER_LISTEN_ADDR)")
+	fs.StringVar(&cfg.MattermostURL, "mattermost-url", cfg.MattermostURL, "public Mattermost URL (AUTH_MANAGER_MATTERMOST_URL)")
+	fs.StringVar(&cfg.MattermostInternalURL, "mattermost-internal-url", cfg.MattermostInternalURL, "internal Mattermost API URL (AUTH_MANAGER_MATTERMOST_INTERNAL_URL)")
+	fs.StringVar(&cfg.DatabaseURL, "database-url", cfg.DatabaseURL, "shadow store database URL (AUTH_MANAGER_DATABASE_URL)")
+	fs.StringVar(&cfg.AuthentikURL, "authentik-url", cfg.AuthentikURL, "Authentik API URL for reconciliation (AUTH_MANAGER_AUTHENTIK_URL)")
+	fs.DurationVar(&cfg.ReconcileInterval, "reconcile-interval", cfg.ReconcileInterval, "background reconcile interval, 0 to disable (AUTH_MANAGER_RECONCILE_INTERVAL)")
+	fs.StringVar(&cfg.N8NInternalURL, "n8n-internal-url", cfg.N8NInternalURL, "internal n8n API URL (AUTH_MANAGER_N8N_INTERNAL_URL)")
+	fs.BoolVar(&cfg.N8NEnabled, "n8n", cfg.N8NEnabled, "provision n8n accounts (AUTH_MANAGER_N8N_ENABLED)")
+	fs.BoolVar(&cfg.GitLabEnabled, "gitlab", cfg.GitLabEnabled, "provision GitLab accounts (AUTH_MANAGER_GITLAB_ENABLED)")
+	fs.BoolVar(&cfg.GrafanaEnabled, "grafana", cfg.GrafanaEnabled, "provision Grafana accounts (AUTH_MANAGER_GRAFANA_ENABLED)")
+	fs.BoolVar(&cfg.DryRun, "dry-run", cfg.DryRun, "log writes instead of making them (AUTH_MANAGER_DRY_RUN)")
+	fs.StringVar(&cfg.LogFormat, "log-format", cfg.LogFormat, "log format, text or json (AUTH_MANAGER_LOG_FORMAT)")
+	fs.StringVar(&cfg.LogLevel, "log-level", cfg.LogLevel, "log level, e.g. debug or warn (AUTH_MANAGER_LOG_LEVEL)")
+}
+
+// modeFlags are the flags that print something and exit instead of serving.
+var modeFlags = []string{"version", "check-config", "print-config-json"}
+
+// reloadConfig reads the configuration again for a reload, with the same
+// flags the process started with still overriding the environment.
+func reloadConfig() config.Config {
+	cfg := config.FromEnv()
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	bindFlags(fs, &cfg)
+	for _, name := range modeFlags {
+		fs.Bool(name, false, "")
+	}
+	_ = fs.Parse(os.Args[1:]) // parsed once already at startup
+	return cfg
+}
+
+// checkConfig prints cfg's redacted settings to w and its problems to errw,
+// returning the process exit code.
+func checkConfig(w, errw io.Writer, cfg config.Config) int {
+	for _, f := range configFields(cfg.Redacted()) {
+		fmt.Fprintf(w, "%-34s %v\n", f.name, f.value)
+	}
+	err := cfg.Validate()
+	if err == nil {
+		fmt.Fprintln(errw, "configuration OK")
+		return 0
+	}
+	for _, line := range strings.Split(err.Error(), "\n") {
+		fmt.Fprintln(errw, "error:", line)
+	}
+	return 1
+}
+
+// printConfigJSON writes cfg's redacted settings to w as a JSON object.
+func printConfigJSON(w io.Writer, cfg config.Config) error {
+	fields := map[string]any{}
+	for _, f := range configFields(cfg.Redacted()) {
+		fields[f.name] = f.value
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(fields)
+}
+
+type configField struct {
+	name  string
+	value any
+}
+
+// configFields lists cfg's fields in declaration order, with durations as
+// strings so they read as configured rather than as nanoseconds.
+func configFields(cfg config.Config) []configField {
+	v := reflect.ValueOf(cfg)
+	fields := make([]configField, 0, v.NumField())
+	for i := 0; i < v.NumField(); i++ {
+		value := v.Field(i).Interface()
+		if d, ok := value.(time.Duration); ok {
+			value = d.String()
+		}
+		fields = append(fields, configField{name: v.Type().Field(i).Name, value: value})
+	}
+	return fields
+}
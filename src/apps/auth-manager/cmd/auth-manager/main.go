@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
 	"log/slog"
 	"os"
 	"os/signal"
@@ -10,21 +12,73 @@ import (
 
 	"github.com/rave-org/rave/apps/auth-manager/internal/config"
 	"github.com/rave-org/rave/apps/auth-manager/internal/server"
+	"github.com/rave-org/rave/apps/auth-manager/internal/version"
 )
 
 func main() {
 	cfg := config.FromEnv()
+	bindFlags(flag.CommandLine, &cfg)
+	showVersion := flag.Bool("version", false, "print the version and exit")
+	check := flag.Bool("check-config", false, "validate the configuration, print it with secrets masked, and exit")
+	printJSON := flag.Bool("print-config-json", false, "print the configuration as JSON with secrets masked, and exit")
+	flag.Parse()
+
+	switch {
+	case *showVersion:
+		fmt.Println(version.Get())
+		return
+	case *check:
+		os.Exit(checkConfig(os.Stdout, os.Stderr, cfg))
+	case *printJSON:
+		if err := printConfigJSON(os.Stdout, cfg); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	if err := cfg.Validate(); err != nil {
-		slog.Error("invalid configuration", "err", err)
+		// Validate joins every problem it finds; log each on its own line.
+		errs := []error{err}
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			errs = joined.Unwrap()
+		}
+		for _, err := range errs {
+			slog.Error("invalid configuration", "err", err)
+		}
 		os.Exit(1)
 	}
 
-	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo}))
-	srv := server.New(cfg, nil, logger)
+	level, _ := cfg.SlogLevel()
+	opts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler = slog.NewTextHandler(os.Stdout, opts)
+	if cfg.LogFormat == "json" {
+		handler = slog.NewJSONHandler(os.Stdout, opts)
+	}
+	logger := slog.New(handler)
+	build := version.Get()
+	logger.Info("starting auth-manager", "version", build.Version, "commit", build.Commit, "date", build.Date, "go", build.GoVersion)
+	srv, err := server.New(cfg, nil, logger)
+	if err != nil {
+		logger.Error("create server", "err", err)
+		os.Exit(1)
+	}
+
+	srv.SetConfigSource(reloadConfig)
 
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			logger.Info("SIGHUP received, reloading configuration")
+			// Reload logs its own outcome.
+			_, _ = srv.Reload()
+		}
+	}()
+
 	go func() {
 		if err := srv.Start(); err != nil {
 			logger.Error("server exited", "err", err)
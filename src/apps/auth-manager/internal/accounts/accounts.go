@@ -0,0 +1,38 @@
+// Package accounts holds the helpers the downstream clients share for
+// filling in the accounts they create, and for matching them up by email.
+package accounts
+
+import (
+	"crypto/rand"
+	"strings"
+)
+
+// Alphabets for RandomPassword. Symbols suits services that require a
+// symbol in passwords; Alphanumeric suits those that reject some.
+const (
+	Alphanumeric = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	Symbols      = Alphanumeric + "!@#$%^&*()"
+)
+
+// SplitName splits a display name into a first name and the rest, with
+// surrounding and repeated whitespace dropped.
+func SplitName(full string) (first, last string) {
+	parts := strings.Fields(full)
+	if len(parts) == 0 {
+		return "", ""
+	}
+	return parts[0], strings.Join(parts[1:], " ")
+}
+
+// RandomPassword returns n characters drawn from alphabet. Accounts get one
+// when they are only ever signed into through the auth proxy.
+func RandomPassword(n int, alphabet string) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	for i := range buf {
+		buf[i] = alphabet[int(buf[i])%len(alphabet)]
+	}
+	return string(buf), nil
+}
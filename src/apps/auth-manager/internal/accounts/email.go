@@ -0,0 +1,135 @@
+package accounts
+
+import (
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// NormalizeEmail returns the canonical form of an email address, under
+// which every spelling of it compares equal: trimmed, lowercased, and with
+// an internationalized domain in its punycode (xn--) form. Authentik keeps
+// addresses as they were typed while Mattermost lowercases them, so
+// everything that keys users by email goes through this first.
+func NormalizeEmail(email string) string {
+	email = strings.TrimSpace(email)
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return norm.NFC.String(strings.ToLower(email))
+	}
+	return norm.NFC.String(strings.ToLower(email[:at])) + "@" + NormalizeDomain(email[at+1:])
+}
+
+// NormalizeDomain lowercases domain and converts internationalized labels to
+// their punycode (xn--) form, so Unicode and ASCII spellings compare equal.
+// A leading "*." is kept. Labels are only lowercased and NFC-normalized, not
+// mapped by UTS #46 as IDNA lookup does, so spellings that differ in more
+// than case and composition, such as fullwidth letters or "ß" against
+// "ss", don't compare equal.
+func NormalizeDomain(domain string) string {
+	domain = strings.TrimSuffix(strings.TrimSpace(domain), ".")
+	labels := strings.Split(norm.NFC.String(strings.ToLower(domain)), ".")
+	for i, label := range labels {
+		if label != "*" && !isASCII(label) {
+			labels[i] = "xn--" + punycode(label)
+		}
+	}
+	return strings.Join(labels, ".")
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+// punycode encodes label per RFC 3492, without the xn-- prefix.
+func punycode(label string) string {
+	const (
+		base        = 36
+		tmin        = 1
+		tmax        = 26
+		initialN    = 128
+		initialBias = 72
+	)
+	runes := []rune(label)
+	var out []byte
+	for _, r := range runes {
+		if r < 0x80 {
+			out = append(out, byte(r))
+		}
+	}
+	basic := len(out)
+	handled := basic
+	if basic > 0 {
+		out = append(out, '-')
+	}
+
+	n, delta, bias := rune(initialN), 0, initialBias
+	for handled < len(runes) {
+		next := rune(0x10FFFF)
+		for _, r := range runes {
+			if r >= n && r < next {
+				next = r
+			}
+		}
+		delta += int(next-n) * (handled + 1)
+		n = next
+		for _, r := range runes {
+			if r < n {
+				delta++
+			}
+			if r != n {
+				continue
+			}
+			q := delta
+			for k := base; ; k += base {
+				t := min(max(k-bias, tmin), tmax)
+				if q < t {
+					break
+				}
+				out = append(out, punycodeDigit(t+(q-t)%(base-t)))
+				q = (q - t) / (base - t)
+			}
+			out = append(out, punycodeDigit(q))
+			bias = punycodeAdapt(delta, handled+1, handled == basic)
+			delta = 0
+			handled++
+		}
+		delta++
+		n++
+	}
+	return string(out)
+}
+
+func punycodeAdapt(delta, points int, first bool) int {
+	const (
+		base = 36
+		tmin = 1
+		tmax = 26
+		skew = 38
+		damp = 700
+	)
+	if first {
+		delta /= damp
+	} else {
+		delta /= 2
+	}
+	delta += delta / points
+	k := 0
+	for delta > (base-tmin)*tmax/2 {
+		delta /= base - tmin
+		k += base
+	}
+	return k + (base-tmin+1)*delta/(delta+skew)
+}
+
+func punycodeDigit(d int) byte {
+	if d < 26 {
+		return byte('a' + d)
+	}
+	return byte('0' + d - 26)
+}
@@ -0,0 +1,43 @@
+package apispec
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type embedded struct {
+	Source string `json:"source"`
+}
+
+type example struct {
+	embedded
+	ID        string            `json:"id"`
+	Count     int               `json:"count,omitempty"`
+	Tags      []string          `json:"tags"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+	Next      *example          `json:"-"`
+	internal  string
+}
+
+func TestSchemaOf(t *testing.T) {
+	s := SchemaOf(example{})
+	if s.Type != "object" {
+		t.Fatalf("type = %q, want object", s.Type)
+	}
+	want := map[string]*Schema{
+		"source":     {Type: "string"},
+		"id":         {Type: "string"},
+		"count":      {Type: "integer"},
+		"tags":       {Type: "array", Items: &Schema{Type: "string"}},
+		"labels":     {Type: "object", AdditionalProperties: &Schema{Type: "string"}},
+		"created_at": {Type: "string", Format: "date-time"},
+	}
+	if !reflect.DeepEqual(s.Properties, want) {
+		t.Errorf("properties = %+v, want %+v", s.Properties, want)
+	}
+	if got, want := s.Required, []string{"source", "id", "tags", "created_at"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("required = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,127 @@
+// Package apperror gives the errors the HTTP API reports a stable machine
+// code, an HTTP status, and a message that is safe to show callers, and
+// renders them as RFC 7807 problem details. The underlying error is kept
+// for the log and never sent.
+package apperror
+
+import (
+	"errors"
+	"net/http"
+)
+
+// ContentType is the media type of a Problem.
+const ContentType = "application/problem+json"
+
+// Code is a stable, machine-readable error code. Codes are part of the API:
+// callers branch on them, so they are never renamed.
+type Code string
+
+const (
+	CodeInvalidRequest          Code = "invalid_request"
+	CodeRequestTooLarge         Code = "request_too_large"
+	CodeMethodNotAllowed        Code = "method_not_allowed"
+	CodeAuthenticationRequired  Code = "authentication_required"
+	CodeInvalidAdminToken       Code = "invalid_admin_token"
+	CodeAdminGroupRequired      Code = "admin_group_required"
+	CodeAdminAuthNotConfigured  Code = "admin_auth_not_configured"
+	CodeRateLimited             Code = "rate_limited"
+	CodeUnknownWebhookSource    Code = "unknown_webhook_source"
+	CodeMissingWebhookAuth      Code = "missing_webhook_auth"
+	CodeInvalidWebhookSignature Code = "invalid_webhook_signature"
+	CodeMalformedWebhook        Code = "malformed_webhook_payload"
+	CodeNotConfigured           Code = "not_configured"
+	CodeMattermostUnavailable   Code = "mattermost_unavailable"
+	CodeMattermostError         Code = "mattermost_error"
+	CodeAuthentikError          Code = "authentik_error"
+	CodeStoreUnavailable        Code = "store_unavailable"
+	CodeReconcileInProgress     Code = "reconcile_in_progress"
+	CodeIdempotencyKeyInUse     Code = "idempotency_key_in_use"
+	CodeIdempotencyKeyReused    Code = "idempotency_key_reused"
+	CodeInvalidConfig           Code = "invalid_config"
+	CodeDeadlineExceeded        Code = "deadline_exceeded"
+	CodeMaintenance             Code = "maintenance"
+	CodeInternal                Code = "internal_error"
+)
+
+// Errors with nothing to add to their message.
+var (
+	MethodNotAllowed       = New(http.StatusMethodNotAllowed, CodeMethodNotAllowed, "method not allowed")
+	AuthenticationRequired = New(http.StatusUnauthorized, CodeAuthenticationRequired, "authentication required")
+	InvalidAdminToken      = New(http.StatusUnauthorized, CodeInvalidAdminToken, "invalid admin token")
+	AdminGroupRequired     = New(http.StatusForbidden, CodeAdminGroupRequired, "admin group membership required")
+	RateLimited            = New(http.StatusTooManyRequests, CodeRateLimited, "rate limit exceeded")
+	MattermostUnavailable  = New(http.StatusServiceUnavailable, CodeMattermostUnavailable, "mattermost temporarily unavailable")
+	DeadlineExceeded       = New(http.StatusGatewayTimeout, CodeDeadlineExceeded, "request took too long")
+)
+
+// Error is an error with its code, status, and public message. Err, when
+// set, is the internal cause.
+type Error struct {
+	Status  int
+	Code    Code
+	Message string
+	Err     error
+}
+
+// New returns an Error without an internal cause.
+func New(status int, code Code, message string) *Error {
+	return &Error{Status: status, Code: code, Message: message}
+}
+
+// Wrap returns an Error that reports message to callers and err to the log.
+func Wrap(err error, status int, code Code, message string) *Error {
+	return &Error{Status: status, Code: code, Message: message, Err: err}
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// Is matches Errors by code, so errors.Is(err, MethodNotAllowed) holds for
+// any method_not_allowed error.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// From returns the Error in err's chain, or an internal error hiding err.
+func From(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	return Wrap(err, http.StatusInternalServerError, CodeInternal, "internal error")
+}
+
+// Problem is an RFC 7807 problem details object, with code and request_id
+// as extension members.
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail"`
+	Code      Code   `json:"code"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// Problem returns the problem details for e. Only the public message is
+// included.
+func (e *Error) Problem() Problem {
+	return Problem{
+		Type:   TypeURI(e.Code),
+		Title:  http.StatusText(e.Status),
+		Status: e.Status,
+		Detail: e.Message,
+		Code:   e.Code,
+	}
+}
+
+// TypeURI is the problem type URI for code.
+func TypeURI(code Code) string {
+	return "urn:auth-manager:problem:" + string(code)
+}
@@ -0,0 +1,55 @@
+package apperror
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestFrom(t *testing.T) {
+	dbErr := errors.New(`dial postgres://auth:hunter2@db:5432/auth: connection refused`)
+
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantCode   Code
+		wantDetail string
+	}{
+		{"typed", MethodNotAllowed, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "method not allowed"},
+		{"wrapped typed", fmt.Errorf("handler: %w", Wrap(dbErr, http.StatusServiceUnavailable, CodeStoreUnavailable, "shadow store unavailable")),
+			http.StatusServiceUnavailable, CodeStoreUnavailable, "shadow store unavailable"},
+		{"untyped", dbErr, http.StatusInternalServerError, CodeInternal, "internal error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := From(tt.err).Problem()
+			if p.Status != tt.wantStatus || p.Code != tt.wantCode || p.Detail != tt.wantDetail {
+				t.Errorf("problem = %+v, want status %d, code %s, detail %q", p, tt.wantStatus, tt.wantCode, tt.wantDetail)
+			}
+			if p.Type != "urn:auth-manager:problem:"+string(tt.wantCode) || p.Title != http.StatusText(tt.wantStatus) {
+				t.Errorf("problem type %q, title %q", p.Type, p.Title)
+			}
+			if strings.Contains(fmt.Sprintf("%+v", p), "hunter2") {
+				t.Errorf("problem %+v leaks the internal error", p)
+			}
+		})
+	}
+}
+
+func TestErrorIsMatchesCode(t *testing.T) {
+	err := fmt.Errorf("wrapped: %w", New(http.StatusMethodNotAllowed, CodeMethodNotAllowed, "POST only"))
+	if !errors.Is(err, MethodNotAllowed) {
+		t.Error("errors.Is didn't match an Error by code")
+	}
+	if errors.Is(err, RateLimited) {
+		t.Error("errors.Is matched an Error with a different code")
+	}
+
+	cause := errors.New("boom")
+	if !errors.Is(Wrap(cause, http.StatusInternalServerError, CodeInternal, "internal error"), cause) {
+		t.Error("errors.Is didn't reach the wrapped cause")
+	}
+}
@@ -0,0 +1,210 @@
+package authentik
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/httpx"
+)
+
+var (
+	// ErrNotFound is returned when Authentik signals a 404 for the requested resource.
+	ErrNotFound = errors.New("authentik resource not found")
+	// ErrRateLimited is returned when Authentik keeps answering 429 after all retries.
+	ErrRateLimited = errors.New("authentik rate limit exceeded")
+)
+
+// DefaultPageSize is the page size requested from list endpoints.
+const DefaultPageSize = 100
+
+const (
+	maxRateLimitRetries = 3
+	maxRetryDelay       = 30 * time.Second
+)
+
+// User is the subset of Authentik's core user object we care about.
+type User struct {
+	PK       int     `json:"pk"`
+	UID      string  `json:"uid"`
+	Username string  `json:"username"`
+	Name     string  `json:"name"`
+	Email    string  `json:"email"`
+	IsActive bool    `json:"is_active"`
+	Type     string  `json:"type"`
+	Groups   []Group `json:"groups_obj"`
+}
+
+// Group is the embedded group summary returned alongside a user.
+type Group struct {
+	PK   string `json:"pk"`
+	Name string `json:"name"`
+}
+
+// GroupNames returns the names of the user's groups.
+func (u User) GroupNames() []string {
+	names := make([]string, 0, len(u.Groups))
+	for _, g := range u.Groups {
+		if g.Name != "" {
+			names = append(names, g.Name)
+		}
+	}
+	return names
+}
+
+// Pagination mirrors Authentik's pagination envelope. Next is 0 on the last page.
+type Pagination struct {
+	Next       int `json:"next"`
+	Previous   int `json:"previous"`
+	Count      int `json:"count"`
+	Current    int `json:"current"`
+	TotalPages int `json:"total_pages"`
+}
+
+// UserPage is one page of results from /api/v3/core/users/.
+type UserPage struct {
+	Pagination Pagination `json:"pagination"`
+	Results    []User     `json:"results"`
+}
+
+// Client is a minimal Authentik API client used for pull-based reconciliation.
+type Client struct {
+	baseURL    string
+	token      string
+	httpClient *http.Client
+}
+
+// NewClient creates a client against the given Authentik base URL using an API token.
+func NewClient(baseURL, token string) *Client {
+	return NewClientWithOptions(baseURL, token, httpx.Options{})
+}
+
+// NewClientWithOptions is NewClient with a tuned HTTP transport.
+func NewClientWithOptions(baseURL, token string, opts httpx.Options) *Client {
+	return &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		token:      token,
+		httpClient: httpx.NewClient(opts),
+	}
+}
+
+// ListUsers returns a single page of users. Pages are 1-indexed.
+func (c *Client) ListUsers(ctx context.Context, page int) (UserPage, error) {
+	if page < 1 {
+		page = 1
+	}
+	query := url.Values{}
+	query.Set("page", strconv.Itoa(page))
+	query.Set("page_size", strconv.Itoa(DefaultPageSize))
+	query.Set("ordering", "pk")
+
+	var result UserPage
+	if err := c.do(ctx, "/api/v3/core/users/?"+query.Encode(), &result); err != nil {
+		return UserPage{}, err
+	}
+	return result, nil
+}
+
+// EachUser walks every page of users, calling fn for each one. It stops at the
+// first error returned by fn or by the API.
+func (c *Client) EachUser(ctx context.Context, fn func(User) error) error {
+	page := 1
+	for {
+		result, err := c.ListUsers(ctx, page)
+		if err != nil {
+			return fmt.Errorf("list users page %d: %w", page, err)
+		}
+		for _, user := range result.Results {
+			if err := fn(user); err != nil {
+				return err
+			}
+		}
+		if result.Pagination.Next == 0 || result.Pagination.Next <= page {
+			return nil
+		}
+		page = result.Pagination.Next
+	}
+}
+
+// GetUser fetches a single user by primary key.
+func (c *Client) GetUser(ctx context.Context, pk int) (User, error) {
+	var user User
+	if err := c.do(ctx, fmt.Sprintf("/api/v3/core/users/%d/", pk), &user); err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
+
+// do issues a GET request, retrying 429 responses with Retry-After or
+// exponential backoff until the retry budget or the context runs out.
+func (c *Client) do(ctx context.Context, path string, dest any) error {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+path, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+c.token)
+		req.Header.Set("Accept", "application/json")
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return err
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests {
+			delay := retryDelay(resp.Header.Get("Retry-After"), attempt)
+			resp.Body.Close()
+			if attempt >= maxRateLimitRetries {
+				return ErrRateLimited
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(delay):
+			}
+			continue
+		}
+
+		err = decodeResponse(resp, path, dest)
+		resp.Body.Close()
+		return err
+	}
+}
+
+func decodeResponse(resp *http.Response, path string, dest any) error {
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	if resp.StatusCode >= 400 {
+		errBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("authentik GET %s failed (%d): %s", path, resp.StatusCode, strings.TrimSpace(string(errBody)))
+	}
+	if dest != nil {
+		return json.NewDecoder(resp.Body).Decode(dest)
+	}
+	return nil
+}
+
+// retryDelay honors a Retry-After header in seconds, falling back to
+// exponential backoff starting at one second.
+func retryDelay(header string, attempt int) time.Duration {
+	if secs, err := strconv.Atoi(strings.TrimSpace(header)); err == nil && secs >= 0 {
+		d := time.Duration(secs) * time.Second
+		if d > maxRetryDelay {
+			d = maxRetryDelay
+		}
+		return d
+	}
+	d := time.Second << attempt
+	if d > maxRetryDelay {
+		d = maxRetryDelay
+	}
+	return d
+}
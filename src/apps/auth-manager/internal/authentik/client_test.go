@@ -0,0 +1,151 @@
+package authentik
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/httpx"
+)
+
+// fakeUsers serves numbered pages of users, one user per page.
+func fakeUsers(t *testing.T, pages int) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer api-token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		next := page + 1
+		if page >= pages {
+			next = 0
+		}
+		_ = json.NewEncoder(w).Encode(UserPage{
+			Pagination: Pagination{Next: next, Current: page, TotalPages: pages, Count: pages},
+			Results: []User{{
+				PK:       page,
+				Username: fmt.Sprintf("user%d", page),
+				Email:    fmt.Sprintf("user%d@example.com", page),
+				IsActive: true,
+			}},
+		})
+	}))
+}
+
+func TestEachUser_FollowsPagination(t *testing.T) {
+	srv := fakeUsers(t, 3)
+	defer srv.Close()
+
+	client := NewClient(srv.URL, "api-token")
+	var seen []string
+	err := client.EachUser(context.Background(), func(u User) error {
+		seen = append(seen, u.Username)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(seen) != 3 || seen[2] != "user3" {
+		t.Errorf("expected users 1..3, got %v", seen)
+	}
+}
+
+func TestGetUser(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v3/core/users/42/" {
+			http.NotFound(w, r)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"pk":         42,
+			"username":   "jane",
+			"email":      "jane@example.com",
+			"is_active":  true,
+			"groups_obj": []map[string]string{{"pk": "g1", "name": "rave-admins"}},
+		})
+	}))
+	defer srv.Close()
+
+	client := NewClient(srv.URL+"/", "api-token")
+	user, err := client.GetUser(context.Background(), 42)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if user.Email != "jane@example.com" {
+		t.Errorf("expected jane@example.com, got %q", user.Email)
+	}
+	if groups := user.GroupNames(); len(groups) != 1 || groups[0] != "rave-admins" {
+		t.Errorf("expected [rave-admins], got %v", groups)
+	}
+
+	if _, err := client.GetUser(context.Background(), 7); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestDo_RetriesRateLimit(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(User{PK: 1})
+	}))
+	defer srv.Close()
+
+	client := NewClient(srv.URL, "api-token")
+	if _, err := client.GetUser(context.Background(), 1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := calls.Load(); got != 3 {
+		t.Errorf("expected 3 calls, got %d", got)
+	}
+}
+
+func TestDo_RateLimitExhausted(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "0")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	client := NewClient(srv.URL, "api-token")
+	if _, err := client.GetUser(context.Background(), 1); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("expected ErrRateLimited, got %v", err)
+	}
+}
+
+func TestNewClientWithOptions_UsesHTTPXTransport(t *testing.T) {
+	var gotID string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotID = r.Header.Get(httpx.RequestIDHeader)
+		_ = json.NewEncoder(w).Encode(User{PK: 42, Username: "akadmin"})
+	}))
+	defer srv.Close()
+
+	var recorded []string
+	client := NewClientWithOptions(srv.URL, "api-token", httpx.Options{
+		Record: func(req *http.Request, simulated bool) {
+			recorded = append(recorded, req.Method+" "+req.URL.Path)
+		},
+	})
+	ctx := httpx.WithRequestID(context.Background(), "req-1")
+	if _, err := client.GetUser(ctx, 42); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if gotID != "req-1" {
+		t.Errorf("request ID header = %q, want req-1", gotID)
+	}
+	if len(recorded) != 1 || recorded[0] != "GET /api/v3/core/users/42/" {
+		t.Errorf("recorded = %v, want one GET for user 42", recorded)
+	}
+}
@@ -0,0 +1,241 @@
+// Package breaker is the circuit breaker that guards auth-manager's calls to
+// downstream services.
+//
+// A Breaker opens after Threshold consecutive failures and refuses calls for
+// the cooldown. It then goes half-open: a limited number of probe calls are
+// let through, and the first result decides. A success closes the breaker; a
+// failure reopens it, for twice as long when a MaxCooldown is set.
+package breaker
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// State is where a Breaker is in its cycle.
+type State int
+
+const (
+	Closed State = iota
+	Open
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+// Settings configure a Breaker.
+type Settings struct {
+	// Threshold is the number of consecutive failures that opens the
+	// breaker.
+	Threshold int
+	// Cooldown is how long the breaker stays open before probing.
+	Cooldown time.Duration
+	// Probes is the number of calls let through while half-open. Values
+	// below 1 mean 1.
+	Probes int
+	// MaxCooldown, when above Cooldown, caps the cooldown as it doubles
+	// with each failed probe. Otherwise the cooldown is fixed.
+	MaxCooldown time.Duration
+}
+
+// Breaker is a circuit breaker. Its methods are safe for concurrent use.
+type Breaker struct {
+	settings Settings
+	now      func() time.Time
+
+	mu        sync.Mutex
+	state     State
+	failures  int
+	cooldown  time.Duration // the current cooldown, grown by failed probes
+	openUntil time.Time
+	probes    int       // probes let through since going half-open
+	probeEnd  time.Time // when unanswered probes are given up on
+	lastErr   string
+	lastErrAt time.Time
+
+	// OnStateChange and OnReject, when set, are called as the breaker moves
+	// between states and each time Allow refuses a call. They're called with
+	// the breaker locked and must not call back into it.
+	OnStateChange func(from, to State)
+	OnReject      func()
+}
+
+// New returns a closed Breaker.
+func New(settings Settings) *Breaker {
+	if settings.Probes < 1 {
+		settings.Probes = 1
+	}
+	return &Breaker{settings: settings, now: time.Now, cooldown: settings.Cooldown}
+}
+
+// Allow reports whether a call may go ahead. The caller reports its outcome
+// with RecordSuccess or RecordFailure.
+func (b *Breaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	if b.state == Open && !now.Before(b.openUntil) {
+		b.setState(HalfOpen)
+		b.probes = 0
+	}
+	if b.state == HalfOpen && b.probes >= b.settings.Probes && !now.Before(b.probeEnd) {
+		// The probes never reported back; try again rather than staying
+		// half-open for good.
+		b.probes = 0
+	}
+	switch b.state {
+	case Open:
+		b.reject()
+		return false
+	case HalfOpen:
+		if b.probes >= b.settings.Probes {
+			b.reject()
+			return false
+		}
+		if b.probes == 0 {
+			b.probeEnd = now.Add(b.cooldown)
+		}
+		b.probes++
+	}
+	return true
+}
+
+// RecordSuccess reports a call that worked, closing the breaker.
+func (b *Breaker) RecordSuccess() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.cooldown = b.settings.Cooldown
+	b.openUntil = time.Time{}
+	b.setState(Closed)
+}
+
+// RecordFailure reports a failed call, and whether it opened the breaker.
+// err is kept as the last failure reason.
+func (b *Breaker) RecordFailure(err error) (opened bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	if err != nil {
+		b.lastErr = err.Error()
+		b.lastErrAt = now
+	}
+	switch b.state {
+	case Open:
+		return false
+	case HalfOpen:
+		if b.settings.MaxCooldown > b.settings.Cooldown {
+			b.cooldown = min(2*b.cooldown, b.settings.MaxCooldown)
+		}
+	default:
+		b.failures++
+		if b.failures < b.settings.Threshold {
+			return false
+		}
+	}
+	b.failures = 0
+	b.openUntil = now.Add(b.cooldown)
+	b.setState(Open)
+	return true
+}
+
+// Remaining returns how long the breaker stays open, or 0 when it isn't.
+func (b *Breaker) Remaining() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != Open {
+		return 0
+	}
+	return max(b.openUntil.Sub(b.now()), 0)
+}
+
+// Snapshot is a Breaker's state at one moment.
+type Snapshot struct {
+	State     State
+	Remaining time.Duration
+	// LastFailure is the last failure's error, and LastFailureAt when it was
+	// recorded; both are kept after the breaker closes.
+	LastFailure   string
+	LastFailureAt time.Time
+}
+
+// Snapshot returns the breaker's state. An open breaker whose cooldown has
+// passed reports HalfOpen, which it becomes on the next Allow.
+func (b *Breaker) Snapshot() Snapshot {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	snap := Snapshot{State: b.state, LastFailure: b.lastErr, LastFailureAt: b.lastErrAt}
+	if b.state == Open {
+		if snap.Remaining = b.openUntil.Sub(b.now()); snap.Remaining <= 0 {
+			snap.State, snap.Remaining = HalfOpen, 0
+		}
+	}
+	return snap
+}
+
+func (b *Breaker) setState(to State) {
+	from := b.state
+	b.state = to
+	if from != to && b.OnStateChange != nil {
+		b.OnStateChange(from, to)
+	}
+}
+
+func (b *Breaker) reject() {
+	if b.OnReject != nil {
+		b.OnReject()
+	}
+}
+
+// Registry holds a Breaker per operation of one service, so a failing
+// endpoint only stops calls to itself. Its methods are safe for concurrent
+// use.
+type Registry struct {
+	settings Settings
+
+	mu       sync.Mutex
+	breakers map[string]*Breaker
+}
+
+// NewRegistry returns a Registry whose breakers use settings, with a breaker
+// already made for each of names.
+func NewRegistry(settings Settings, names ...string) *Registry {
+	r := &Registry{settings: settings, breakers: map[string]*Breaker{}}
+	for _, name := range names {
+		r.Get(name)
+	}
+	return r
+}
+
+// Get returns the breaker for operation name, making it on first use.
+func (r *Registry) Get(name string) *Breaker {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b, ok := r.breakers[name]
+	if !ok {
+		b = New(r.settings)
+		r.breakers[name] = b
+	}
+	return b
+}
+
+// Names returns the operations with a breaker, sorted.
+func (r *Registry) Names() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	names := make([]string, 0, len(r.breakers))
+	for name := range r.breakers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,223 @@
+package breaker
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeClock is a settable time source.
+type fakeClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	c.t = c.t.Add(d)
+	c.mu.Unlock()
+}
+
+func newTestBreaker(settings Settings) (*Breaker, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	b := New(settings)
+	b.now = clock.now
+	return b, clock
+}
+
+var errDown = errors.New("connection refused")
+
+func TestBreaker_OpensAtThreshold(t *testing.T) {
+	b, _ := newTestBreaker(Settings{Threshold: 3, Cooldown: 30 * time.Second})
+	for i := 0; i < 2; i++ {
+		if b.RecordFailure(errDown) {
+			t.Fatalf("failure %d opened the breaker before the threshold", i+1)
+		}
+	}
+	b.RecordSuccess()
+	for i := 0; i < 2; i++ {
+		b.RecordFailure(errDown)
+	}
+	if !b.Allow() {
+		t.Fatal("a success should reset the failure count")
+	}
+	if !b.RecordFailure(errDown) {
+		t.Fatal("third consecutive failure should open the breaker")
+	}
+	if b.Allow() {
+		t.Error("open breaker allowed a call")
+	}
+	if got := b.Remaining(); got != 30*time.Second {
+		t.Errorf("Remaining = %v, want 30s", got)
+	}
+	snap := b.Snapshot()
+	if snap.State != Open || snap.LastFailure != "connection refused" || snap.LastFailureAt.IsZero() {
+		t.Errorf("Snapshot = %+v, want open with the last failure", snap)
+	}
+}
+
+func TestBreaker_HalfOpen(t *testing.T) {
+	tests := []struct {
+		name      string
+		probe     func(b *Breaker)
+		wantState State
+		wantAllow bool
+	}{
+		{name: "probe succeeds", probe: func(b *Breaker) { b.RecordSuccess() }, wantState: Closed, wantAllow: true},
+		{name: "probe fails", probe: func(b *Breaker) { b.RecordFailure(errDown) }, wantState: Open, wantAllow: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, clock := newTestBreaker(Settings{Threshold: 1, Cooldown: 10 * time.Second})
+			b.RecordFailure(errDown)
+			clock.advance(10 * time.Second)
+			if got := b.Snapshot().State; got != HalfOpen {
+				t.Errorf("state after cooldown = %v, want half-open", got)
+			}
+			if !b.Allow() {
+				t.Fatal("half-open breaker refused the probe")
+			}
+			if b.Allow() {
+				t.Fatal("half-open breaker allowed a second call before the probe answered")
+			}
+			tt.probe(b)
+			if got := b.Snapshot().State; got != tt.wantState {
+				t.Errorf("state = %v, want %v", got, tt.wantState)
+			}
+			if got := b.Allow(); got != tt.wantAllow {
+				t.Errorf("Allow = %v, want %v", got, tt.wantAllow)
+			}
+		})
+	}
+}
+
+func TestBreaker_CooldownBackoff(t *testing.T) {
+	b, clock := newTestBreaker(Settings{Threshold: 1, Cooldown: 10 * time.Second, MaxCooldown: 35 * time.Second})
+	b.RecordFailure(errDown)
+	for _, want := range []time.Duration{20 * time.Second, 35 * time.Second, 35 * time.Second} {
+		clock.advance(b.Remaining())
+		if !b.Allow() {
+			t.Fatal("refused the probe")
+		}
+		b.RecordFailure(errDown)
+		if got := b.Remaining(); got != want {
+			t.Errorf("cooldown = %v, want %v", got, want)
+		}
+	}
+
+	clock.advance(b.Remaining())
+	b.Allow()
+	b.RecordSuccess()
+	b.RecordFailure(errDown)
+	if got := b.Remaining(); got != 10*time.Second {
+		t.Errorf("cooldown after recovering = %v, want the base 10s", got)
+	}
+}
+
+func TestBreaker_FixedCooldownWithoutMax(t *testing.T) {
+	b, clock := newTestBreaker(Settings{Threshold: 1, Cooldown: 10 * time.Second})
+	b.RecordFailure(errDown)
+	clock.advance(10 * time.Second)
+	b.Allow()
+	b.RecordFailure(errDown)
+	if got := b.Remaining(); got != 10*time.Second {
+		t.Errorf("cooldown = %v, want 10s", got)
+	}
+}
+
+func TestBreaker_ConcurrentProbes(t *testing.T) {
+	b, clock := newTestBreaker(Settings{Threshold: 1, Cooldown: time.Second, Probes: 3})
+	b.RecordFailure(errDown)
+	clock.advance(time.Second)
+
+	var allowed atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if b.Allow() {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := allowed.Load(); got != 3 {
+		t.Errorf("%d calls allowed while half-open, want 3", got)
+	}
+}
+
+func TestBreaker_UnansweredProbesExpire(t *testing.T) {
+	b, clock := newTestBreaker(Settings{Threshold: 1, Cooldown: 10 * time.Second})
+	b.RecordFailure(errDown)
+	clock.advance(10 * time.Second)
+	if !b.Allow() {
+		t.Fatal("refused the probe")
+	}
+	clock.advance(5 * time.Second)
+	if b.Allow() {
+		t.Error("allowed a second probe while the first may still answer")
+	}
+	clock.advance(5 * time.Second)
+	if !b.Allow() {
+		t.Error("a probe that never answered should be given up on after the cooldown")
+	}
+}
+
+func TestBreaker_Hooks(t *testing.T) {
+	b, clock := newTestBreaker(Settings{Threshold: 1, Cooldown: time.Second})
+	var transitions []string
+	var rejects int
+	b.OnStateChange = func(from, to State) { transitions = append(transitions, from.String()+"->"+to.String()) }
+	b.OnReject = func() { rejects++ }
+
+	b.RecordFailure(errDown)
+	b.Allow()
+	clock.advance(time.Second)
+	b.Allow()
+	b.RecordSuccess()
+
+	want := []string{"closed->open", "open->half-open", "half-open->closed"}
+	if len(transitions) != len(want) {
+		t.Fatalf("transitions = %v, want %v", transitions, want)
+	}
+	for i := range want {
+		if transitions[i] != want[i] {
+			t.Errorf("transitions = %v, want %v", transitions, want)
+			break
+		}
+	}
+	if rejects != 1 {
+		t.Errorf("rejects = %d, want 1", rejects)
+	}
+}
+
+func TestRegistry_IndependentOperations(t *testing.T) {
+	r := NewRegistry(Settings{Threshold: 2, Cooldown: time.Minute}, "ensure-user", "create-session")
+	if got := r.Names(); len(got) != 2 || got[0] != "create-session" || got[1] != "ensure-user" {
+		t.Errorf("Names = %v, want [create-session ensure-user]", got)
+	}
+	for i := 0; i < 2; i++ {
+		r.Get("create-session").RecordFailure(errDown)
+	}
+	if r.Get("create-session").Allow() {
+		t.Error("create-session breaker should be open")
+	}
+	if !r.Get("ensure-user").Allow() {
+		t.Error("ensure-user breaker tripped by create-session failures")
+	}
+	if r.Get("create-session") != r.Get("create-session") {
+		t.Error("Get returned a different breaker for the same operation")
+	}
+	if !r.Get("deactivate").Allow() || len(r.Names()) != 3 {
+		t.Error("Get should make a closed breaker for a new operation")
+	}
+}
@@ -3,9 +3,33 @@ package config
 import (
 	"crypto/rand"
 	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"html/template"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
 	"os"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/eventstream"
+	"github.com/rave-org/rave/apps/auth-manager/internal/gitlab"
+	"github.com/rave-org/rave/apps/auth-manager/internal/grafana"
+	"github.com/rave-org/rave/apps/auth-manager/internal/httpx"
+	"github.com/rave-org/rave/apps/auth-manager/internal/identity"
+	"github.com/rave-org/rave/apps/auth-manager/internal/n8n"
+	"github.com/rave-org/rave/apps/auth-manager/internal/outbound"
+	"github.com/rave-org/rave/apps/auth-manager/internal/provision"
+	"github.com/rave-org/rave/apps/auth-manager/internal/shadow"
+	"github.com/rave-org/rave/apps/auth-manager/internal/webhook"
 )
 
 // Config captures the tunable knobs for the auth-manager service.
@@ -17,12 +41,349 @@ type Config struct {
 	DatabaseURL           string
 	WebhookSecret         string // Shared secret for validating Authentik webhooks
 
+	// AttrEncryptionKey turns on encryption at rest of the shadow
+	// attributes named in SensitiveAttributes: comma-separated base64
+	// AES-256 keys, each "<id>:<key>" when there's more than one. The first
+	// encrypts; the others only decrypt, for rotation. SensitiveAttributes
+	// are also redacted from shadow user listings either way.
+	AttrEncryptionKey   string
+	SensitiveAttributes []string
+
+	// WebhookSecretGenerated is set when FromEnv made up WebhookSecret
+	// because none is configured.
+	WebhookSecretGenerated bool
+
+	// Teams and channels newly created Mattermost users are joined to.
+	// Channels are "team/channel" or a bare channel name looked up in every
+	// default team.
+	MattermostDefaultTeams    []string
+	MattermostDefaultChannels []string
+
+	// Members of MattermostGuestGroups get guest accounts joined only to the
+	// guest teams and channels (same format as the defaults above). Guests
+	// are promoted to regular users once they leave every guest group.
+	MattermostGuestGroups   []string
+	MattermostGuestTeams    []string
+	MattermostGuestChannels []string
+
+	// MattermostRoleMap maps identity groups to Mattermost system roles, e.g.
+	// "rave-admins=system_admin system_user,ops=system_manager system_user".
+	// Roles are only ever added unless MattermostRoleDemotion is set.
+	MattermostRoleMap      string
+	MattermostRoleDemotion bool
+
+	// MattermostTeamMap maps identity groups to Mattermost team names, e.g.
+	// "proj-apollo=apollo"; repeat a group to map it to several teams.
+	// MattermostTeamGroupPrefix maps every group with the prefix to the team
+	// named by the rest, so "proj-" maps proj-apollo to apollo too. Teams
+	// are only ever joined unless MattermostTeamRemoval is set, and
+	// MattermostProtectedTeams, "admin" unless set, are never left.
+	MattermostTeamMap         string
+	MattermostTeamGroupPrefix string
+	MattermostTeamRemoval     bool
+	MattermostProtectedTeams  []string
+
+	// Sessions minted by forward auth. A zero TTL inherits Mattermost's
+	// default; XHR-originated sessions use MattermostSessionXHRTTL when set.
+	MattermostSessionTTL          time.Duration
+	MattermostSessionXHRTTL       time.Duration
+	MattermostSessionDevicePrefix string
+
+	// Forward-auth session cache. SessionCacheTTL must be shorter than the
+	// Mattermost session lifetime; 0 disables caching.
+	SessionCacheTTL  time.Duration
+	SessionCacheSize int
+
+	// DisableProfileSync stops EnsureUser from patching existing Mattermost
+	// profiles, for deployments that let users customize them.
+	DisableProfileSync bool
+
+	// Service accounts are provisioned as Mattermost bots instead of users.
+	// An identity is a service account when its username starts with
+	// ServiceAccountPrefix or it belongs to one of ServiceAccountGroups.
+	// When BotTokenDir is set, each new bot gets an access token written to
+	// <BotTokenDir>/<username>.
+	ServiceAccountPrefix string
+	ServiceAccountGroups []string
+	BotTokenDir          string
+
+	// AllowedGroups limits provisioning and forward auth to members of at
+	// least one of the groups; empty allows everyone. Members of any of
+	// DeniedGroups are refused even when also in an allowed group.
+	AllowedGroups []string
+	DeniedGroups  []string
+
+	// AllowedEmailDomains limits provisioning and forward auth to emails in
+	// these domains; "*.corp.example.com" allows its subdomains. Empty allows
+	// every domain.
+	AllowedEmailDomains []string
+
+	// The management API (/api/v1/*) requires AdminToken as a bearer token,
+	// or a forwarded identity in one of AdminGroups; with neither set it's
+	// open. MetricsProtected applies the same check to /metrics.
+	AdminToken       string
+	AdminGroups      []string
+	MetricsProtected bool
+
+	// MattermostCommandToken is the token of the /rave slash command, which
+	// Mattermost sends with each invocation; empty disables the command.
+	// Its mutating subcommands are limited to users whose shadow record is
+	// in one of MattermostCommandAdminGroups, or AdminGroups when empty.
+	MattermostCommandToken       string
+	MattermostCommandAdminGroups []string
+
+	// LogFormat is "text" (the default) or "json"; LogLevel is a slog level
+	// name such as "debug" or "warn", defaulting to "info".
+	LogFormat string
+	LogLevel  string
+
+	// DryRun performs Mattermost and n8n lookups but only logs the writes
+	// provisioning and forward auth would make, and skips shadow store writes.
+	DryRun bool
+
+	// RemapByEmail moves a provider's shadow user to a new subject when an
+	// event arrives under a subject with no record but an email with one,
+	// as after Authentik is reinstalled and every user gets a new PK.
+	RemapByEmail bool
+
+	// ReadyRequireMattermost makes /readyz fail while Mattermost is
+	// unreachable or rejects the admin token. By default the probe only
+	// reports Mattermost as degraded.
+	ReadyRequireMattermost bool
+
+	// WebhookSources is a JSON object of additional named webhook sources,
+	// e.g. {"staging": {"secret_file": "/run/secrets/staging"}}.
+	WebhookSources string
+
+	// Webhook action policy: either a JSON object mapping actions to
+	// provision/deprovision/ignore, or comma-separated action lists.
+	WebhookPolicy        string
+	WebhookProvisionOn   string
+	WebhookDeprovisionOn string
+
+	// WebhookMinimalMode accepts Authentik's default notification payloads
+	// (no custom body mapping) and provisions from the user email alone.
+	WebhookMinimalMode bool
+
+	// Security alert forwarding for non-user events at or above
+	// AlertMinSeverity (notice, warning, alert) to a Mattermost channel.
+	AlertChannelID   string
+	AlertMinSeverity string
+
+	// Operator alerts about auth-manager itself go to OpsAlertChannelID, or
+	// AlertChannelID when it's empty: a circuit breaker opening, and a user
+	// whose provisioning has failed more than OpsAlertFailureThreshold times
+	// in a row (0 disables those). Each cause alerts at most once per
+	// OpsAlertInterval.
+	OpsAlertChannelID        string
+	OpsAlertFailureThreshold int
+	OpsAlertInterval         time.Duration
+
+	// OutboundWebhooks is a JSON object of named webhooks notified of
+	// provisioning outcomes, e.g. {"cmdb": {"url": "https://cmdb/hooks/rave",
+	// "secret_file": "/run/secrets/cmdb", "events": ["user.provisioned"]}}.
+	// Each has a queue of OutboundWebhookQueueSize events (256 when 0), and
+	// a delivery is tried up to OutboundWebhookMaxAttempts times (5 when 0).
+	OutboundWebhooks           string
+	OutboundWebhookQueueSize   int
+	OutboundWebhookMaxAttempts int
+
+	// EventStreamURL, a redis:// or rediss:// URL, enables consuming
+	// Authentik events from the Redis stream EventStream as consumer group
+	// EventStreamGroup, alongside or instead of webhooks. Events are handled
+	// as if they came from the webhook source EventStreamSource. One failing
+	// EventStreamMaxAttempts times (5 when 0) moves to the stream
+	// EventStreamDeadLetter, EventStream + ":dead" when empty.
+	EventStreamURL         string
+	EventStream            string
+	EventStreamGroup       string
+	EventStreamDeadLetter  string
+	EventStreamMaxAttempts int
+	EventStreamSource      string
+
+	// DeprovisionEnabled lets deprovision webhook events deactivate the
+	// user's Mattermost account instead of only logging them.
+	DeprovisionEnabled bool
+
+	// RevokeSessionsOnCredentialChange revokes every Mattermost session for a
+	// user when Authentik reports a password change or MFA device change.
+	RevokeSessionsOnCredentialChange bool
+
+	// Authentik API configuration for pull-based reconciliation
+	AuthentikURL     string
+	AuthentikToken   string
+	ReconcileTimeout time.Duration
+	// ReconcileInterval schedules background reconciliation; 0 disables it.
+	ReconcileInterval time.Duration
+
+	// Request budgets: once one passes, the handler's context is canceled and
+	// the caller gets a 504. ForwardAuthTimeout covers /auth/*, WebhookTimeout
+	// the webhooks, and RequestTimeout everything else except reconcile runs
+	// and session cleanup, which have their own timeouts. Zero means 3s, 10s,
+	// and 10s.
+	ForwardAuthTimeout time.Duration
+	WebhookTimeout     time.Duration
+	RequestTimeout     time.Duration
+
+	// WarmupTimeout bounds the startup warm-up, during which the server
+	// probes the shadow store, Mattermost, and n8n and reports not ready
+	// until they all answer. 0 disables warm-up.
+	WarmupTimeout time.Duration
+
+	// Transport tuning for the internal Mattermost and n8n API clients.
+	// Zero values use the httpx defaults.
+	HTTPDialTimeout           time.Duration
+	HTTPTLSHandshakeTimeout   time.Duration
+	HTTPResponseHeaderTimeout time.Duration
+	HTTPMaxIdleConnsPerHost   int
+	HTTPCAFile                string // PEM bundle trusted in addition to the system roots
+	HTTPInsecureSkipVerify    bool   // Development only
+
 	// n8n configuration
 	N8NEnabled     bool
 	N8NURL         string
 	N8NInternalURL string
 	N8NOwnerEmail  string
 	N8NOwnerPass   string
+
+	// N8NAPIKey authenticates against n8n's public API instead of logging in
+	// as the owner. It wins when the owner credentials are also set, but
+	// session issuance and deprovisioning need the owner login.
+	N8NAPIKey string
+
+	// N8NIssueSessions makes n8n forward auth log users in by resetting
+	// their n8n password through the owner account and setting the
+	// resulting n8n-auth cookie. Users' own n8n passwords stop working.
+	N8NIssueSessions bool
+
+	// N8NRoleMap maps identity groups to n8n global roles, e.g.
+	// "rave-admins=global:admin". Users in none of the groups get
+	// global:member. Roles are only ever raised unless N8NRoleDemotion is set.
+	N8NRoleMap      string
+	N8NRoleDemotion bool
+
+	// N8NProjectMap maps identity groups to n8n project names, e.g.
+	// "data=Analytics,data=Shared". Users are added to their groups'
+	// projects as editors and never removed.
+	N8NProjectMap string
+
+	// N8NPendingInvites is what provisioning does about users who never
+	// accepted their n8n invitation: "accept" completes it for them (pair
+	// with N8NIssueSessions), "resend" emails it again, and "" leaves them.
+	N8NPendingInvites string
+
+	// N8NDeprovisionAction is what deprovisioning does to the n8n account:
+	// "disable" (default) or "delete". Deleted users' workflows and
+	// credentials go to N8NTransferTo, an n8n user's email, or the owner.
+	N8NDeprovisionAction string
+	N8NTransferTo        string
+
+	// GitLab configuration. GitLabToken is an admin personal access token
+	// with the api scope.
+	GitLabEnabled     bool
+	GitLabInternalURL string
+	GitLabToken       string
+
+	// GitLabGroupMap maps identity groups to GitLab group memberships, e.g.
+	// "rave-devs=platform:developer platform/infra:maintainer". Groups are
+	// IDs or full paths; the access level defaults to developer. Access is
+	// only ever raised.
+	GitLabGroupMap string
+
+	// Grafana configuration. The admin account must be a Grafana server
+	// admin, since the admin API only takes basic auth. Roles and teams
+	// apply to organization GrafanaOrgID.
+	GrafanaEnabled     bool
+	GrafanaInternalURL string
+	GrafanaAdminUser   string
+	GrafanaAdminPass   string
+	GrafanaOrgID       int
+
+	// GrafanaRoleMap maps identity groups to Grafana org roles, e.g.
+	// "rave-admins=Admin,devs=Editor"; the highest mapped role applies.
+	// GrafanaTeamMap maps groups to team names, e.g. "devs=Backend Team";
+	// repeat a group to map it to several teams.
+	GrafanaRoleMap string
+	GrafanaTeamMap string
+
+	// ForwardAuthServices is a JSON object of additional /auth/{service}
+	// endpoints, or overrides of the built-in ones, e.g.
+	// {"outline": {"headers": {"X-Outline-Email": "email"}, "shadow": true}}.
+	ForwardAuthServices string
+
+	// Provisioners lists the downstream services users are provisioned
+	// into, in order; empty means every configured one, in
+	// ProvisionerNames order. ProvisionPolicy is "fail-fast" (the default),
+	// which stops at the first Mattermost failure, or "best-effort".
+	Provisioners    []string
+	ProvisionPolicy string
+
+	// RateLimitPerMinute and RateLimitBurst throttle each caller of the
+	// manual sync endpoint with a token bucket; 0 disables the limit.
+	RateLimitPerMinute int
+	RateLimitBurst     int
+
+	// IdempotencyTTL is how long the response to a request sent with an
+	// Idempotency-Key is replayed to retries; zero means 24h. At most
+	// IdempotencyMaxKeys keys are remembered; 0 ignores the header.
+	IdempotencyTTL     time.Duration
+	IdempotencyMaxKeys int
+
+	// BreakerThreshold consecutive failures open a downstream service's
+	// circuit breaker for BreakerCooldown. Zero means 5 failures and 30s.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
+	// MaxRequestBytes caps request bodies outside the webhooks, which have
+	// their own 1MB limit. Zero means DefaultMaxRequestBytes.
+	MaxRequestBytes int
+
+	// IdentitySources lists the proxies whose identity headers are trusted
+	// (pomerium, authentik, proxy), in precedence order; empty means all of
+	// them, in that order.
+	IdentitySources []string
+
+	// TrustedProxies lists the CIDRs (or bare IPs) of the proxies allowed to
+	// send identity headers, and ProxySecret a value those proxies inject as
+	// X-Auth-Manager-Proxy-Secret. A request carrying identity headers must
+	// come from a trusted proxy or carry the secret; with neither set every
+	// peer is trusted.
+	TrustedProxies []string
+	ProxySecret    string
+
+	// CORSOrigins lists the browser origins (scheme://host[:port]) allowed to
+	// call the management API, which may then send credentials. "*" allows
+	// any origin, but never with credentials. Empty disables CORS.
+	CORSOrigins []string
+
+	// CookieDomain is the Domain of the session cookies forward auth sets,
+	// e.g. example.com to share them between chat.example.com and
+	// n8n.example.com. Empty scopes Mattermost's cookies to the host that
+	// served them and n8n's to the public n8n host. CookieSameSite is their
+	// SameSite attribute: lax (the default), strict, or none, which needs
+	// https public URLs. They are Secure when the public URL is https.
+	CookieDomain   string
+	CookieSameSite string
+
+	// LogoutRedirectURL is where /auth/logout sends the browser once the
+	// cookies are cleared, typically Authentik's end-session URL. Empty
+	// answers 200 instead.
+	LogoutRedirectURL string
+
+	// ErrorPageTemplate is an html/template file that replaces the built-in
+	// page browsers see when forward auth fails. Empty uses the built-in one.
+	ErrorPageTemplate string
+
+	// DebugAddr is a second listen address serving net/http/pprof under
+	// /debug/pprof/ and runtime diagnostics at /debug/vars, behind the admin
+	// token or groups when they are set. Empty, the default, serves neither.
+	// Bind it to loopback: profiles expose memory contents.
+	DebugAddr string
+
+	// OTLPEndpoint is the OpenTelemetry collector's OTLP/HTTP base URL (e.g.
+	// http://127.0.0.1:4318) or traces URL to send request traces to. Empty
+	// disables tracing.
+	OTLPEndpoint string
 }
 
 // FromEnv builds a Config by reading environment variables and falling back to
@@ -34,38 +395,919 @@ func FromEnv() Config {
 		MattermostInternalURL: getEnv("AUTH_MANAGER_MATTERMOST_INTERNAL_URL", "http://127.0.0.1:8065"),
 		MattermostAdminToken:  getSecretFromEnv("AUTH_MANAGER_MATTERMOST_ADMIN_TOKEN", "AUTH_MANAGER_MATTERMOST_ADMIN_TOKEN_FILE", ""),
 		DatabaseURL:           getEnv("AUTH_MANAGER_DATABASE_URL", ""),
+		AttrEncryptionKey:     getSecretFromEnv("AUTH_MANAGER_ATTR_ENCRYPTION_KEY", "AUTH_MANAGER_ATTR_ENCRYPTION_KEY_FILE", ""),
+		SensitiveAttributes:   getList("AUTH_MANAGER_SENSITIVE_ATTRIBUTES"),
 		WebhookSecret:         getSecretFromEnv("AUTH_MANAGER_WEBHOOK_SECRET", "AUTH_MANAGER_WEBHOOK_SECRET_FILE", ""),
 
+		MattermostDefaultTeams:    getList("AUTH_MANAGER_MATTERMOST_DEFAULT_TEAMS"),
+		MattermostDefaultChannels: getList("AUTH_MANAGER_MATTERMOST_DEFAULT_CHANNELS"),
+		MattermostGuestGroups:     getList("AUTH_MANAGER_GUEST_GROUPS"),
+		MattermostGuestTeams:      getList("AUTH_MANAGER_GUEST_DEFAULT_TEAMS"),
+		MattermostGuestChannels:   getList("AUTH_MANAGER_GUEST_DEFAULT_CHANNELS"),
+		MattermostRoleMap:         getEnv("AUTH_MANAGER_MATTERMOST_ROLE_MAP", ""),
+		MattermostRoleDemotion:    getEnv("AUTH_MANAGER_MATTERMOST_ROLE_DEMOTION", "") == "true",
+		MattermostTeamMap:         getEnv("AUTH_MANAGER_MATTERMOST_TEAM_MAP", ""),
+		MattermostTeamGroupPrefix: getEnv("AUTH_MANAGER_MATTERMOST_TEAM_GROUP_PREFIX", ""),
+		MattermostTeamRemoval:     getEnv("AUTH_MANAGER_MATTERMOST_TEAM_REMOVAL", "") == "true",
+		MattermostProtectedTeams:  splitList(getEnv("AUTH_MANAGER_MATTERMOST_PROTECTED_TEAMS", "admin")),
+		DisableProfileSync:        getEnv("AUTH_MANAGER_DISABLE_PROFILE_SYNC", "") == "true",
+		DryRun:                    getEnv("AUTH_MANAGER_DRY_RUN", "") == "true",
+		RemapByEmail:              getEnv("AUTH_MANAGER_REMAP_BY_EMAIL", "") == "true",
+		ReadyRequireMattermost:    getEnv("AUTH_MANAGER_READY_REQUIRE_MATTERMOST", "") == "true",
+
+		ServiceAccountPrefix: getEnv("AUTH_MANAGER_SERVICE_ACCOUNT_PREFIX", "svc-"),
+		ServiceAccountGroups: getList("AUTH_MANAGER_SERVICE_ACCOUNT_GROUPS"),
+		BotTokenDir:          getEnv("AUTH_MANAGER_BOT_TOKEN_DIR", ""),
+
+		AllowedGroups: getList("AUTH_MANAGER_ALLOWED_GROUPS"),
+		DeniedGroups:  getList("AUTH_MANAGER_DENIED_GROUPS"),
+
+		AllowedEmailDomains: getList("AUTH_MANAGER_ALLOWED_EMAIL_DOMAINS"),
+
+		AdminToken:       getSecretFromEnv("AUTH_MANAGER_ADMIN_TOKEN", "AUTH_MANAGER_ADMIN_TOKEN_FILE", ""),
+		AdminGroups:      getList("AUTH_MANAGER_ADMIN_GROUPS"),
+		MetricsProtected: getEnv("AUTH_MANAGER_METRICS_PROTECTED", "") == "true",
+
+		MattermostCommandToken:       getSecretFromEnv("AUTH_MANAGER_MATTERMOST_COMMAND_TOKEN", "AUTH_MANAGER_MATTERMOST_COMMAND_TOKEN_FILE", ""),
+		MattermostCommandAdminGroups: getList("AUTH_MANAGER_MATTERMOST_COMMAND_ADMIN_GROUPS"),
+
+		LogFormat: getEnv("AUTH_MANAGER_LOG_FORMAT", "text"),
+		LogLevel:  getEnv("AUTH_MANAGER_LOG_LEVEL", "info"),
+
+		MattermostSessionTTL:          getDuration("AUTH_MANAGER_MATTERMOST_SESSION_TTL", 0),
+		MattermostSessionXHRTTL:       getDuration("AUTH_MANAGER_MATTERMOST_SESSION_XHR_TTL", 0),
+		MattermostSessionDevicePrefix: getEnv("AUTH_MANAGER_MATTERMOST_SESSION_DEVICE_PREFIX", "rave-sso"),
+		SessionCacheTTL:               getDuration("AUTH_MANAGER_SESSION_CACHE_TTL", 10*time.Minute),
+		SessionCacheSize:              getInt("AUTH_MANAGER_SESSION_CACHE_SIZE", 1000),
+
+		WebhookSources:       getEnv("AUTH_MANAGER_WEBHOOK_SOURCES", ""),
+		WebhookPolicy:        getEnv("AUTH_MANAGER_WEBHOOK_POLICY", ""),
+		WebhookProvisionOn:   getEnv("AUTH_MANAGER_WEBHOOK_PROVISION_ON", ""),
+		WebhookDeprovisionOn: getEnv("AUTH_MANAGER_WEBHOOK_DEPROVISION_ON", ""),
+		WebhookMinimalMode:   getEnv("AUTH_MANAGER_WEBHOOK_MINIMAL_MODE", "") == "true",
+
+		AlertChannelID:   getEnv("AUTH_MANAGER_ALERT_CHANNEL_ID", ""),
+		AlertMinSeverity: getEnv("AUTH_MANAGER_ALERT_MIN_SEVERITY", "warning"),
+
+		OpsAlertChannelID:        getEnv("AUTH_MANAGER_OPS_ALERT_CHANNEL_ID", ""),
+		OpsAlertFailureThreshold: getInt("AUTH_MANAGER_OPS_ALERT_FAILURE_THRESHOLD", 3),
+		OpsAlertInterval:         getDuration("AUTH_MANAGER_OPS_ALERT_INTERVAL", 15*time.Minute),
+
+		OutboundWebhooks:           getEnv("AUTH_MANAGER_OUTBOUND_WEBHOOKS", ""),
+		OutboundWebhookQueueSize:   getInt("AUTH_MANAGER_OUTBOUND_WEBHOOK_QUEUE_SIZE", 256),
+		OutboundWebhookMaxAttempts: getInt("AUTH_MANAGER_OUTBOUND_WEBHOOK_MAX_ATTEMPTS", 5),
+
+		EventStreamURL:         getSecretFromEnv("AUTH_MANAGER_EVENT_STREAM_URL", "AUTH_MANAGER_EVENT_STREAM_URL_FILE", ""),
+		EventStream:            getEnv("AUTH_MANAGER_EVENT_STREAM", "authentik:events"),
+		EventStreamGroup:       getEnv("AUTH_MANAGER_EVENT_STREAM_GROUP", "auth-manager"),
+		EventStreamDeadLetter:  getEnv("AUTH_MANAGER_EVENT_STREAM_DEAD_LETTER", ""),
+		EventStreamMaxAttempts: getInt("AUTH_MANAGER_EVENT_STREAM_MAX_ATTEMPTS", 5),
+		EventStreamSource:      getEnv("AUTH_MANAGER_EVENT_STREAM_SOURCE", DefaultWebhookSource),
+
+		DeprovisionEnabled:               getEnv("AUTH_MANAGER_DEPROVISION_ENABLED", "") == "true",
+		RevokeSessionsOnCredentialChange: getEnv("AUTH_MANAGER_REVOKE_SESSIONS_ON_CREDENTIAL_CHANGE", "") == "true",
+
+		// Authentik API configuration
+		AuthentikURL:      getEnv("AUTH_MANAGER_AUTHENTIK_URL", ""),
+		AuthentikToken:    getSecretFromEnv("AUTH_MANAGER_AUTHENTIK_TOKEN", "AUTH_MANAGER_AUTHENTIK_TOKEN_FILE", ""),
+		ReconcileTimeout:  getDuration("AUTH_MANAGER_RECONCILE_TIMEOUT", 5*time.Minute),
+		ReconcileInterval: getDuration("AUTH_MANAGER_RECONCILE_INTERVAL", 0),
+
+		ForwardAuthTimeout: getDuration("AUTH_MANAGER_FORWARD_AUTH_TIMEOUT", 3*time.Second),
+		WebhookTimeout:     getDuration("AUTH_MANAGER_WEBHOOK_TIMEOUT", 10*time.Second),
+		RequestTimeout:     getDuration("AUTH_MANAGER_REQUEST_TIMEOUT", 10*time.Second),
+
+		WarmupTimeout: getDuration("AUTH_MANAGER_WARMUP_TIMEOUT", time.Minute),
+
+		HTTPDialTimeout:           getDuration("AUTH_MANAGER_HTTP_DIAL_TIMEOUT", 0),
+		HTTPTLSHandshakeTimeout:   getDuration("AUTH_MANAGER_HTTP_TLS_HANDSHAKE_TIMEOUT", 0),
+		HTTPResponseHeaderTimeout: getDuration("AUTH_MANAGER_HTTP_RESPONSE_HEADER_TIMEOUT", 0),
+		HTTPMaxIdleConnsPerHost:   getInt("AUTH_MANAGER_HTTP_MAX_IDLE_CONNS_PER_HOST", 0),
+		HTTPCAFile:                getEnv("AUTH_MANAGER_HTTP_CA_FILE", ""),
+		HTTPInsecureSkipVerify:    getEnv("AUTH_MANAGER_HTTP_INSECURE_SKIP_VERIFY", "") == "true",
+
 		// n8n configuration
 		N8NEnabled:     getEnv("AUTH_MANAGER_N8N_ENABLED", "") == "true",
 		N8NURL:         getEnv("AUTH_MANAGER_N8N_URL", "https://localhost:8443/n8n"),
 		N8NInternalURL: getEnv("AUTH_MANAGER_N8N_INTERNAL_URL", "http://127.0.0.1:5678"),
 		N8NOwnerEmail:  getSecretFromEnv("AUTH_MANAGER_N8N_OWNER_EMAIL", "AUTH_MANAGER_N8N_OWNER_EMAIL_FILE", ""),
 		N8NOwnerPass:   getSecretFromEnv("AUTH_MANAGER_N8N_OWNER_PASS", "AUTH_MANAGER_N8N_OWNER_PASS_FILE", ""),
+
+		N8NAPIKey: getSecretFromEnv("AUTH_MANAGER_N8N_API_KEY", "AUTH_MANAGER_N8N_API_KEY_FILE", ""),
+
+		N8NIssueSessions: getEnv("AUTH_MANAGER_N8N_ISSUE_SESSIONS", "") == "true",
+
+		N8NRoleMap:      getEnv("AUTH_MANAGER_N8N_ROLE_MAP", ""),
+		N8NRoleDemotion: getEnv("AUTH_MANAGER_N8N_ROLE_DEMOTION", "") == "true",
+
+		N8NProjectMap: getEnv("AUTH_MANAGER_N8N_PROJECT_MAP", ""),
+
+		N8NPendingInvites: getEnv("AUTH_MANAGER_N8N_PENDING_INVITES", ""),
+
+		N8NDeprovisionAction: getEnv("AUTH_MANAGER_N8N_DEPROVISION_ACTION", "disable"),
+		N8NTransferTo:        getEnv("AUTH_MANAGER_N8N_TRANSFER_TO", ""),
+
+		GitLabEnabled:     getEnv("AUTH_MANAGER_GITLAB_ENABLED", "") == "true",
+		GitLabInternalURL: getEnv("AUTH_MANAGER_GITLAB_INTERNAL_URL", "http://127.0.0.1:8080"),
+		GitLabToken:       getSecretFromEnv("AUTH_MANAGER_GITLAB_TOKEN", "AUTH_MANAGER_GITLAB_TOKEN_FILE", ""),
+
+		GitLabGroupMap: getEnv("AUTH_MANAGER_GITLAB_GROUP_MAP", ""),
+
+		GrafanaEnabled:     getEnv("AUTH_MANAGER_GRAFANA_ENABLED", "") == "true",
+		GrafanaInternalURL: getEnv("AUTH_MANAGER_GRAFANA_INTERNAL_URL", "http://127.0.0.1:3000"),
+		GrafanaAdminUser:   getEnv("AUTH_MANAGER_GRAFANA_ADMIN_USER", "admin"),
+		GrafanaAdminPass:   getSecretFromEnv("AUTH_MANAGER_GRAFANA_ADMIN_PASSWORD", "AUTH_MANAGER_GRAFANA_ADMIN_PASSWORD_FILE", ""),
+		GrafanaOrgID:       getInt("AUTH_MANAGER_GRAFANA_ORG_ID", 1),
+
+		GrafanaRoleMap: getEnv("AUTH_MANAGER_GRAFANA_ROLE_MAP", ""),
+		GrafanaTeamMap: getEnv("AUTH_MANAGER_GRAFANA_TEAM_MAP", ""),
+
+		ForwardAuthServices: getEnv("AUTH_MANAGER_FORWARD_AUTH_SERVICES", ""),
+
+		Provisioners:    getList("AUTH_MANAGER_PROVISIONERS"),
+		ProvisionPolicy: getEnv("AUTH_MANAGER_PROVISION_POLICY", ""),
+
+		RateLimitPerMinute: getInt("AUTH_MANAGER_RATE_LIMIT_PER_MINUTE", 60),
+		RateLimitBurst:     getInt("AUTH_MANAGER_RATE_LIMIT_BURST", 10),
+
+		IdempotencyTTL:     getDuration("AUTH_MANAGER_IDEMPOTENCY_TTL", 24*time.Hour),
+		IdempotencyMaxKeys: getInt("AUTH_MANAGER_IDEMPOTENCY_MAX_KEYS", 10000),
+
+		BreakerThreshold: getInt("AUTH_MANAGER_BREAKER_THRESHOLD", 5),
+		BreakerCooldown:  getDuration("AUTH_MANAGER_BREAKER_COOLDOWN", 30*time.Second),
+
+		MaxRequestBytes: getInt("AUTH_MANAGER_MAX_REQUEST_BYTES", DefaultMaxRequestBytes),
+
+		IdentitySources: getList("AUTH_MANAGER_IDENTITY_SOURCES"),
+
+		TrustedProxies: getList("AUTH_MANAGER_TRUSTED_PROXIES"),
+		ProxySecret:    getSecretFromEnv("AUTH_MANAGER_PROXY_SECRET", "AUTH_MANAGER_PROXY_SECRET_FILE", ""),
+
+		CORSOrigins: getList("AUTH_MANAGER_CORS_ORIGINS"),
+
+		CookieDomain:   getEnv("AUTH_MANAGER_COOKIE_DOMAIN", ""),
+		CookieSameSite: getEnv("AUTH_MANAGER_COOKIE_SAMESITE", ""),
+
+		LogoutRedirectURL: getEnv("AUTH_MANAGER_LOGOUT_REDIRECT_URL", ""),
+
+		ErrorPageTemplate: getEnv("AUTH_MANAGER_ERROR_PAGE_TEMPLATE", ""),
+
+		DebugAddr: getEnv("AUTH_MANAGER_DEBUG_ADDR", ""),
+
+		OTLPEndpoint: getEnv("AUTH_MANAGER_OTLP_ENDPOINT", ""),
 	}
 
 	// Generate a random webhook secret if not provided (for dev)
 	if cfg.WebhookSecret == "" {
 		cfg.WebhookSecret = randomKey()
+		cfg.WebhookSecretGenerated = true
 	}
 
 	return cfg
 }
 
-// Validate performs minimal static validation on the configuration.
+// DefaultMaxRequestBytes is the request body limit when MaxRequestBytes is
+// zero.
+const DefaultMaxRequestBytes = 1 << 20
+
+// MinSecretLength is the shortest webhook secret, admin token, or proxy
+// secret Validate accepts.
+const MinSecretLength = 16
+
+// Validate checks the configuration and returns every problem it finds,
+// joined, so they can all be fixed in one pass.
 func (c Config) Validate() error {
-	if c.ListenAddr == "" {
-		return fmt.Errorf("listen address must not be empty")
+	var errs []error
+	check := func(err error) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	check(validListenAddr("listen address (AUTH_MANAGER_LISTEN_ADDR)", c.ListenAddr))
+	if c.DebugAddr != "" {
+		check(validListenAddr("debug address (AUTH_MANAGER_DEBUG_ADDR)", c.DebugAddr))
 	}
 	if c.MattermostURL == "" {
-		return fmt.Errorf("mattermost URL must not be empty")
+		check(fmt.Errorf("mattermost URL (AUTH_MANAGER_MATTERMOST_URL) must not be empty"))
 	}
 	if c.MattermostInternalURL == "" {
-		return fmt.Errorf("mattermost internal URL must not be empty")
+		check(fmt.Errorf("mattermost internal URL (AUTH_MANAGER_MATTERMOST_INTERNAL_URL) must not be empty"))
+	}
+	check(c.CheckURLs())
+	for _, secret := range []struct{ name, value string }{
+		{"webhook secret (AUTH_MANAGER_WEBHOOK_SECRET)", c.WebhookSecret},
+		{"admin token (AUTH_MANAGER_ADMIN_TOKEN)", c.AdminToken},
+		{"proxy secret (AUTH_MANAGER_PROXY_SECRET)", c.ProxySecret},
+	} {
+		if secret.value != "" && len(secret.value) < MinSecretLength {
+			check(fmt.Errorf("%s must be at least %d characters", secret.name, MinSecretLength))
+		}
+	}
+	if sources, err := c.WebhookSourceMap(); err != nil {
+		check(err)
+	} else {
+		names := make([]string, 0, len(sources))
+		for name := range sources {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		for _, name := range names {
+			if name != DefaultWebhookSource && len(sources[name].Secret) < MinSecretLength {
+				check(fmt.Errorf("webhook sources: %s: secret must be at least %d characters", name, MinSecretLength))
+			}
+		}
+	}
+
+	if c.N8NEnabled {
+		if c.N8NURL == "" || c.N8NInternalURL == "" {
+			check(fmt.Errorf("n8n is enabled: AUTH_MANAGER_N8N_URL and AUTH_MANAGER_N8N_INTERNAL_URL must be set"))
+		}
+		owner := c.N8NOwnerEmail != "" && c.N8NOwnerPass != ""
+		if c.N8NAPIKey == "" && !owner {
+			check(fmt.Errorf("n8n is enabled: set AUTH_MANAGER_N8N_API_KEY, or both AUTH_MANAGER_N8N_OWNER_EMAIL and AUTH_MANAGER_N8N_OWNER_PASS"))
+		}
+		if c.N8NIssueSessions && !owner {
+			check(fmt.Errorf("n8n session issuance needs the owner login: set AUTH_MANAGER_N8N_OWNER_EMAIL and AUTH_MANAGER_N8N_OWNER_PASS"))
+		}
+	}
+
+	for _, parse := range []func() error{
+		func() error { _, err := c.WebhookActionPolicy(); return err },
+		func() error { _, err := c.RoleMapping(); return err },
+		func() error { _, err := c.MattermostTeamMapping(); return err },
+		func() error { _, err := c.N8NRoleMapping(); return err },
+		func() error { _, err := c.N8NProjectMapping(); return err },
+		func() error { _, err := c.GitLabGroupMapping(); return err },
+		func() error { _, err := c.GrafanaRoleMapping(); return err },
+		func() error { _, err := c.GrafanaTeamMapping(); return err },
+		func() error { _, err := c.ForwardAuthServiceMap(); return err },
+		func() error { _, err := c.ProvisionerOrder(); return err },
+		func() error { _, err := c.ProvisionerPolicy(); return err },
+		func() error { _, err := c.TrustedIdentitySources(); return err },
+		func() error { _, err := c.TrustedProxyPrefixes(); return err },
+		func() error { _, err := c.SessionCookieSameSite(); return err },
+		func() error { _, _, err := c.CORSPolicy(); return err },
+		func() error { _, err := c.SlogLevel(); return err },
+		func() error { _, err := c.HTTPOptions(); return err },
+		func() error { _, err := c.ErrorPage(); return err },
+		func() error { _, err := c.OutboundWebhookTargets(); return err },
+		func() error { _, _, err := c.EventStreamOptions(); return err },
+		func() error { _, err := c.AttributeEncryptionKeys(); return err },
+	} {
+		check(parse())
+	}
+
+	if len(c.AdminGroups) > 0 && len(c.TrustedProxies) == 0 && c.ProxySecret == "" {
+		// Otherwise any peer could send admin group headers itself.
+		check(fmt.Errorf("admin groups (AUTH_MANAGER_ADMIN_GROUPS) need AUTH_MANAGER_TRUSTED_PROXIES or AUTH_MANAGER_PROXY_SECRET, so only the proxy can vouch for group membership"))
+	}
+	switch c.LogFormat {
+	case "", "text", "json":
+	default:
+		check(fmt.Errorf("log format %q must be text or json", c.LogFormat))
+	}
+	switch c.N8NPendingInvites {
+	case "", "accept", "resend":
+	default:
+		check(fmt.Errorf("n8n pending invites %q must be accept or resend", c.N8NPendingInvites))
+	}
+	if c.N8NDeprovisionAction != "disable" && c.N8NDeprovisionAction != "delete" {
+		check(fmt.Errorf("n8n deprovision action %q must be disable or delete", c.N8NDeprovisionAction))
+	}
+	if c.BreakerThreshold < 0 || c.BreakerCooldown < 0 {
+		check(fmt.Errorf("breaker threshold %d and cooldown %s must not be negative", c.BreakerThreshold, c.BreakerCooldown))
+	}
+	if c.ForwardAuthTimeout < 0 || c.WebhookTimeout < 0 || c.RequestTimeout < 0 {
+		check(fmt.Errorf("forward auth, webhook, and request timeouts %s, %s, and %s must not be negative", c.ForwardAuthTimeout, c.WebhookTimeout, c.RequestTimeout))
+	}
+	if c.CookieDomain != "" {
+		domain := strings.ToLower(strings.TrimPrefix(c.CookieDomain, "."))
+		host := ""
+		if u, err := url.Parse(c.MattermostURL); err == nil {
+			host = strings.ToLower(u.Hostname())
+		}
+		if net.ParseIP(domain) != nil || (host != domain && !strings.HasSuffix(host, "."+domain)) {
+			check(fmt.Errorf("cookie domain (AUTH_MANAGER_COOKIE_DOMAIN) %q must be a domain the Mattermost host %q is in", c.CookieDomain, host))
+		}
+	}
+	if c.WarmupTimeout < 0 {
+		check(fmt.Errorf("warm-up timeout (AUTH_MANAGER_WARMUP_TIMEOUT) %s must not be negative", c.WarmupTimeout))
+	}
+	if c.MaxRequestBytes < 0 {
+		check(fmt.Errorf("max request bytes (AUTH_MANAGER_MAX_REQUEST_BYTES) %d must not be negative", c.MaxRequestBytes))
+	}
+	for _, ttl := range []time.Duration{c.MattermostSessionTTL, c.MattermostSessionXHRTTL} {
+		if ttl > 0 && c.SessionCacheTTL >= ttl {
+			check(fmt.Errorf("session cache TTL %s must be shorter than the Mattermost session TTL %s", c.SessionCacheTTL, ttl))
+		}
+	}
+	if c.AlertChannelID != "" && webhook.SeverityRank(c.AlertMinSeverity) == 0 {
+		check(fmt.Errorf("alert minimum severity %q must be one of notice, warning, alert", c.AlertMinSeverity))
+	}
+	if c.OpsAlertFailureThreshold < 0 {
+		check(fmt.Errorf("ops alert failure threshold (AUTH_MANAGER_OPS_ALERT_FAILURE_THRESHOLD) %d must not be negative", c.OpsAlertFailureThreshold))
+	}
+	if c.OpsAlertInterval < 0 {
+		check(fmt.Errorf("ops alert interval (AUTH_MANAGER_OPS_ALERT_INTERVAL) %s must not be negative", c.OpsAlertInterval))
+	}
+	if c.OutboundWebhookQueueSize < 0 {
+		check(fmt.Errorf("outbound webhook queue size (AUTH_MANAGER_OUTBOUND_WEBHOOK_QUEUE_SIZE) %d must not be negative", c.OutboundWebhookQueueSize))
+	}
+	if c.OutboundWebhookMaxAttempts < 0 {
+		check(fmt.Errorf("outbound webhook max attempts (AUTH_MANAGER_OUTBOUND_WEBHOOK_MAX_ATTEMPTS) %d must not be negative", c.OutboundWebhookMaxAttempts))
+	}
+	if c.EventStreamMaxAttempts < 0 {
+		check(fmt.Errorf("event stream max attempts (AUTH_MANAGER_EVENT_STREAM_MAX_ATTEMPTS) %d must not be negative", c.EventStreamMaxAttempts))
+	}
+	if c.IdempotencyTTL < 0 {
+		check(fmt.Errorf("idempotency TTL (AUTH_MANAGER_IDEMPOTENCY_TTL) %s must not be negative", c.IdempotencyTTL))
+	}
+	if c.IdempotencyMaxKeys < 0 {
+		check(fmt.Errorf("idempotency max keys (AUTH_MANAGER_IDEMPOTENCY_MAX_KEYS) %d must not be negative", c.IdempotencyMaxKeys))
+	}
+	return errors.Join(errs...)
+}
+
+// CheckURLs reports every configured service URL that isn't an absolute
+// http or https URL. Empty URLs are skipped; Validate checks the required
+// ones are set.
+func (c Config) CheckURLs() error {
+	var errs []error
+	for _, u := range []struct{ name, value string }{
+		{"AUTH_MANAGER_MATTERMOST_URL", c.MattermostURL},
+		{"AUTH_MANAGER_MATTERMOST_INTERNAL_URL", c.MattermostInternalURL},
+		{"AUTH_MANAGER_AUTHENTIK_URL", c.AuthentikURL},
+		{"AUTH_MANAGER_N8N_URL", c.N8NURL},
+		{"AUTH_MANAGER_N8N_INTERNAL_URL", c.N8NInternalURL},
+		{"AUTH_MANAGER_GITLAB_INTERNAL_URL", c.GitLabInternalURL},
+		{"AUTH_MANAGER_GRAFANA_INTERNAL_URL", c.GrafanaInternalURL},
+		{"AUTH_MANAGER_LOGOUT_REDIRECT_URL", c.LogoutRedirectURL},
+		{"AUTH_MANAGER_OTLP_ENDPOINT", c.OTLPEndpoint},
+	} {
+		if u.value == "" {
+			continue
+		}
+		parsed, err := url.Parse(u.value)
+		switch {
+		case err != nil:
+			errs = append(errs, fmt.Errorf("%s: %w", u.name, err))
+		case parsed.Scheme != "http" && parsed.Scheme != "https" || parsed.Host == "":
+			errs = append(errs, fmt.Errorf("%s %q must be an absolute http or https URL", u.name, u.value))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// validListenAddr checks addr is a host:port with a numeric port, like
+// ":8088" or "127.0.0.1:8088". name describes the setting in errors.
+func validListenAddr(name, addr string) error {
+	if addr == "" {
+		return fmt.Errorf("%s must not be empty", name)
+	}
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("%s %q: %w", name, addr, err)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return fmt.Errorf("%s %q: port must be a number from 0 to 65535", name, addr)
 	}
 	return nil
 }
 
+// RoleMapping parses MattermostRoleMap into group name → Mattermost roles.
+// An empty map disables role management.
+func (c Config) RoleMapping() (map[string][]string, error) {
+	mapping := map[string][]string{}
+	for _, entry := range strings.Split(c.MattermostRoleMap, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		group, roles, ok := strings.Cut(entry, "=")
+		group = strings.TrimSpace(group)
+		fields := strings.Fields(roles)
+		if !ok || group == "" || len(fields) == 0 {
+			return nil, fmt.Errorf("mattermost role map entry %q must be group=role [role...]", entry)
+		}
+		mapping[group] = append(mapping[group], fields...)
+	}
+	return mapping, nil
+}
+
+// MattermostTeamMapping parses MattermostTeamMap into group name →
+// Mattermost team names, like GrafanaTeamMapping. Team names are lowercased,
+// as Mattermost's are.
+func (c Config) MattermostTeamMapping() (map[string][]string, error) {
+	mapping := map[string][]string{}
+	for _, entry := range strings.Split(c.MattermostTeamMap, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		group, team, ok := strings.Cut(entry, "=")
+		group, team = strings.TrimSpace(group), strings.ToLower(strings.TrimSpace(team))
+		if !ok || group == "" || team == "" {
+			return nil, fmt.Errorf("mattermost team map entry %q must be group=team", strings.TrimSpace(entry))
+		}
+		mapping[group] = append(mapping[group], team)
+	}
+	return mapping, nil
+}
+
+// N8NRoleMapping parses N8NRoleMap into group name → n8n global role.
+// An empty map disables n8n role management.
+func (c Config) N8NRoleMapping() (map[string]string, error) {
+	mapping := map[string]string{}
+	for _, entry := range strings.Split(c.N8NRoleMap, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		group, role, ok := strings.Cut(entry, "=")
+		group, role = strings.TrimSpace(group), strings.TrimSpace(role)
+		if !ok || group == "" {
+			return nil, fmt.Errorf("n8n role map entry %q must be group=role", entry)
+		}
+		if role != n8n.RoleAdmin && role != n8n.RoleMember {
+			return nil, fmt.Errorf("n8n role map entry %q: role must be %s or %s", entry, n8n.RoleAdmin, n8n.RoleMember)
+		}
+		mapping[group] = role
+	}
+	return mapping, nil
+}
+
+// N8NProjectMapping parses N8NProjectMap into group name → n8n project
+// names. Names may contain spaces; repeat a group to map it to several
+// projects.
+func (c Config) N8NProjectMapping() (map[string][]string, error) {
+	mapping := map[string][]string{}
+	for _, entry := range strings.Split(c.N8NProjectMap, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		group, project, ok := strings.Cut(entry, "=")
+		group, project = strings.TrimSpace(group), strings.TrimSpace(project)
+		if !ok || group == "" || project == "" {
+			return nil, fmt.Errorf("n8n project map entry %q must be group=project", strings.TrimSpace(entry))
+		}
+		mapping[group] = append(mapping[group], project)
+	}
+	return mapping, nil
+}
+
+// HTTPOptions returns the transport options for internal API clients,
+// loading HTTPCAFile when set.
+func (c Config) HTTPOptions() (httpx.Options, error) {
+	opts := httpx.Options{
+		DialTimeout:           c.HTTPDialTimeout,
+		TLSHandshakeTimeout:   c.HTTPTLSHandshakeTimeout,
+		ResponseHeaderTimeout: c.HTTPResponseHeaderTimeout,
+		MaxIdleConnsPerHost:   c.HTTPMaxIdleConnsPerHost,
+		InsecureSkipVerify:    c.HTTPInsecureSkipVerify,
+	}
+	if c.HTTPCAFile != "" {
+		pool, err := httpx.LoadCertPool(c.HTTPCAFile)
+		if err != nil {
+			return httpx.Options{}, fmt.Errorf("http CA file %s: %w", c.HTTPCAFile, err)
+		}
+		opts.RootCAs = pool
+	}
+	return opts, nil
+}
+
+// DefaultWebhookSource is the source served at /webhook/authentik using WebhookSecret.
+const DefaultWebhookSource = "default"
+
+// WebhookSource is a named Authentik instance allowed to send webhooks.
+type WebhookSource struct {
+	Name     string
+	Secret   string
+	Provider string // Shadow store provider label for events from this source
+}
+
+var sourceNameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// WebhookSourceMap returns every configured webhook source keyed by name,
+// always including the default source. Named sources store their users under
+// provider "authentik-<name>" so subjects can't collide across tenants; the
+// default source keeps the historical "authentik" provider.
+func (c Config) WebhookSourceMap() (map[string]WebhookSource, error) {
+	sources := map[string]WebhookSource{
+		DefaultWebhookSource: {Name: DefaultWebhookSource, Secret: c.WebhookSecret, Provider: "authentik"},
+	}
+	if strings.TrimSpace(c.WebhookSources) == "" {
+		return sources, nil
+	}
+
+	var raw map[string]struct {
+		Secret     string `json:"secret"`
+		SecretFile string `json:"secret_file"`
+	}
+	if err := json.Unmarshal([]byte(c.WebhookSources), &raw); err != nil {
+		return nil, fmt.Errorf("webhook sources: invalid JSON: %w", err)
+	}
+	for name, src := range raw {
+		if !sourceNameRe.MatchString(name) {
+			return nil, fmt.Errorf("webhook sources: invalid source name %q (use lowercase letters, digits, - and _)", name)
+		}
+		if name == DefaultWebhookSource {
+			return nil, fmt.Errorf("webhook sources: %q is reserved, configure it with AUTH_MANAGER_WEBHOOK_SECRET", name)
+		}
+		secret := strings.TrimSpace(src.Secret)
+		if src.SecretFile != "" {
+			data, err := os.ReadFile(src.SecretFile)
+			if err != nil {
+				return nil, fmt.Errorf("webhook sources: %s: read secret file: %w", name, err)
+			}
+			secret = strings.TrimSpace(string(data))
+		}
+		if secret == "" {
+			return nil, fmt.Errorf("webhook sources: %s: secret or secret_file is required", name)
+		}
+		sources[name] = WebhookSource{Name: name, Secret: secret, Provider: "authentik-" + name}
+	}
+	return sources, nil
+}
+
+// OutboundWebhookTargets parses OutboundWebhooks, sorted by name.
+func (c Config) OutboundWebhookTargets() ([]outbound.Target, error) {
+	if strings.TrimSpace(c.OutboundWebhooks) == "" {
+		return nil, nil
+	}
+	var raw map[string]struct {
+		URL        string   `json:"url"`
+		Secret     string   `json:"secret"`
+		SecretFile string   `json:"secret_file"`
+		Events     []string `json:"events"`
+	}
+	if err := json.Unmarshal([]byte(c.OutboundWebhooks), &raw); err != nil {
+		return nil, fmt.Errorf("outbound webhooks: invalid JSON: %w", err)
+	}
+	targets := make([]outbound.Target, 0, len(raw))
+	for name, target := range raw {
+		if !sourceNameRe.MatchString(name) {
+			return nil, fmt.Errorf("outbound webhooks: invalid name %q (use lowercase letters, digits, - and _)", name)
+		}
+		if u, err := url.Parse(target.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("outbound webhooks: %s: url must be an http(s) URL", name)
+		}
+		secret := strings.TrimSpace(target.Secret)
+		if target.SecretFile != "" {
+			data, err := os.ReadFile(target.SecretFile)
+			if err != nil {
+				return nil, fmt.Errorf("outbound webhooks: %s: read secret file: %w", name, err)
+			}
+			secret = strings.TrimSpace(string(data))
+		}
+		if len(secret) < MinSecretLength {
+			return nil, fmt.Errorf("outbound webhooks: %s: secret or secret_file must hold at least %d characters", name, MinSecretLength)
+		}
+		for _, event := range target.Events {
+			if !slices.Contains(outbound.EventTypes, event) {
+				return nil, fmt.Errorf("outbound webhooks: %s: unknown event %q (use %s)", name, event, strings.Join(outbound.EventTypes, ", "))
+			}
+		}
+		targets = append(targets, outbound.Target{Name: name, URL: target.URL, Secret: secret, Events: target.Events})
+	}
+	slices.SortFunc(targets, func(a, b outbound.Target) int { return strings.Compare(a.Name, b.Name) })
+	return targets, nil
+}
+
+var attrKeyIDRe = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+// AttributeEncryptionKeys parses AttrEncryptionKey, the encrypting key
+// first, or returns nil when it's empty. A lone key without an ID gets ID
+// "1".
+func (c Config) AttributeEncryptionKeys() ([]shadow.Key, error) {
+	if strings.TrimSpace(c.AttrEncryptionKey) == "" {
+		return nil, nil
+	}
+	if len(c.SensitiveAttributes) == 0 {
+		return nil, errors.New("attribute encryption key (AUTH_MANAGER_ATTR_ENCRYPTION_KEY) is set but no attributes are marked sensitive (AUTH_MANAGER_SENSITIVE_ATTRIBUTES)")
+	}
+	entries := strings.Split(c.AttrEncryptionKey, ",")
+	keys := make([]shadow.Key, 0, len(entries))
+	seen := map[string]bool{}
+	for i, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		id, encoded, ok := strings.Cut(entry, ":")
+		if !ok {
+			if len(entries) > 1 {
+				return nil, fmt.Errorf("attribute encryption key %d: with several keys each must be <id>:<base64 key>", i+1)
+			}
+			id, encoded = "1", entry
+		}
+		if !attrKeyIDRe.MatchString(id) {
+			return nil, fmt.Errorf("attribute encryption key %d: ID %q must be 1-32 letters, digits, - or _", i+1, id)
+		}
+		if seen[id] {
+			return nil, fmt.Errorf("attribute encryption key %d: duplicate ID %q", i+1, id)
+		}
+		seen[id] = true
+		secret, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(secret) != shadow.KeySize {
+			return nil, fmt.Errorf("attribute encryption key %q must be %d bytes, base64-encoded (openssl rand -base64 32)", id, shadow.KeySize)
+		}
+		keys = append(keys, shadow.Key{ID: id, Secret: secret})
+	}
+	return keys, nil
+}
+
+// EventStreamOptions returns the Redis stream to consume and the webhook
+// source its events are attributed to. The options are zero when
+// EventStreamURL is empty.
+func (c Config) EventStreamOptions() (eventstream.RedisOptions, WebhookSource, error) {
+	if c.EventStreamURL == "" {
+		return eventstream.RedisOptions{}, WebhookSource{}, nil
+	}
+	if u, err := url.Parse(c.EventStreamURL); err != nil || (u.Scheme != "redis" && u.Scheme != "rediss") || u.Host == "" {
+		return eventstream.RedisOptions{}, WebhookSource{}, errors.New("event stream URL (AUTH_MANAGER_EVENT_STREAM_URL) must be a redis:// or rediss:// URL")
+	}
+	if c.EventStream == "" || c.EventStreamGroup == "" {
+		return eventstream.RedisOptions{}, WebhookSource{}, errors.New("event stream (AUTH_MANAGER_EVENT_STREAM) and group (AUTH_MANAGER_EVENT_STREAM_GROUP) must not be empty")
+	}
+	sources, err := c.WebhookSourceMap()
+	if err != nil {
+		return eventstream.RedisOptions{}, WebhookSource{}, err
+	}
+	source, ok := sources[c.EventStreamSource]
+	if !ok {
+		return eventstream.RedisOptions{}, WebhookSource{}, fmt.Errorf("event stream source (AUTH_MANAGER_EVENT_STREAM_SOURCE) %q is not a configured webhook source", c.EventStreamSource)
+	}
+	return eventstream.RedisOptions{
+		URL:        c.EventStreamURL,
+		Stream:     c.EventStream,
+		Group:      c.EventStreamGroup,
+		DeadLetter: c.EventStreamDeadLetter,
+	}, source, nil
+}
+
+// GitLabGroupMapping parses GitLabGroupMap into identity group → GitLab
+// group memberships. An empty map disables group management.
+func (c Config) GitLabGroupMapping() (map[string][]gitlab.Membership, error) {
+	mapping := map[string][]gitlab.Membership{}
+	for _, entry := range strings.Split(c.GitLabGroupMap, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		group, targets, ok := strings.Cut(entry, "=")
+		group = strings.TrimSpace(group)
+		fields := strings.Fields(targets)
+		if !ok || group == "" || len(fields) == 0 {
+			return nil, fmt.Errorf("gitlab group map entry %q must be group=gitlab-group[:level] [...]", entry)
+		}
+		for _, field := range fields {
+			target, levelName, hasLevel := strings.Cut(field, ":")
+			level := gitlab.Developer
+			if hasLevel {
+				var err error
+				if level, err = gitlab.ParseAccessLevel(levelName); err != nil {
+					return nil, fmt.Errorf("gitlab group map entry %q: %w", entry, err)
+				}
+			}
+			if target == "" {
+				return nil, fmt.Errorf("gitlab group map entry %q: empty gitlab group", entry)
+			}
+			mapping[group] = append(mapping[group], gitlab.Membership{Group: target, AccessLevel: level})
+		}
+	}
+	return mapping, nil
+}
+
+// GrafanaRoleMapping parses GrafanaRoleMap into group name → Grafana org
+// role. An empty map leaves roles alone.
+func (c Config) GrafanaRoleMapping() (map[string]string, error) {
+	mapping := map[string]string{}
+	for _, entry := range strings.Split(c.GrafanaRoleMap, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		group, role, ok := strings.Cut(entry, "=")
+		group = strings.TrimSpace(group)
+		if !ok || group == "" {
+			return nil, fmt.Errorf("grafana role map entry %q must be group=role", entry)
+		}
+		role, err := grafana.ParseRole(role)
+		if err != nil {
+			return nil, fmt.Errorf("grafana role map entry %q: %w", entry, err)
+		}
+		mapping[group] = role
+	}
+	return mapping, nil
+}
+
+// GrafanaTeamMapping parses GrafanaTeamMap into group name → Grafana team
+// names, like N8NProjectMapping.
+func (c Config) GrafanaTeamMapping() (map[string][]string, error) {
+	mapping := map[string][]string{}
+	for _, entry := range strings.Split(c.GrafanaTeamMap, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		group, team, ok := strings.Cut(entry, "=")
+		group, team = strings.TrimSpace(group), strings.TrimSpace(team)
+		if !ok || group == "" || team == "" {
+			return nil, fmt.Errorf("grafana team map entry %q must be group=team", strings.TrimSpace(entry))
+		}
+		mapping[group] = append(mapping[group], team)
+	}
+	return mapping, nil
+}
+
+// ForwardAuthService describes an /auth/{service} endpoint.
+type ForwardAuthService struct {
+	Name string
+	// Headers maps response headers, which the proxy forwards to the
+	// service, to the identity field they carry: email, username, name, or
+	// groups.
+	Headers map[string]string
+	// Shadow records the user in the shadow store on each request.
+	Shadow bool
+	// Provision names the built-in hook that provisions the user
+	// downstream ("mattermost", "n8n", "gitlab", or "grafana"); empty only
+	// maps headers.
+	Provision string
+}
+
+// ForwardAuthFields are the identity fields a forward-auth header can carry.
+var ForwardAuthFields = []string{"email", "username", "name", "groups"}
+
+// ForwardAuthProvisioners are the built-in forward-auth provisioning hooks.
+var ForwardAuthProvisioners = []string{"mattermost", "n8n", "gitlab", "grafana"}
+
+// ForwardAuthServiceMap returns every forward-auth service keyed by name:
+// the built-in mattermost, n8n, gitlab, and grafana services, with entries
+// from ForwardAuthServices added or replacing them.
+func (c Config) ForwardAuthServiceMap() (map[string]ForwardAuthService, error) {
+	services := map[string]ForwardAuthService{
+		"mattermost": {Name: "mattermost", Provision: "mattermost"},
+		"n8n":        {Name: "n8n", Provision: "n8n"},
+		"gitlab":     {Name: "gitlab", Provision: "gitlab"},
+		"grafana": {Name: "grafana", Provision: "grafana", Headers: map[string]string{
+			"X-WEBAUTH-USER":  "username",
+			"X-WEBAUTH-EMAIL": "email",
+			"X-WEBAUTH-NAME":  "name",
+		}},
+	}
+	if strings.TrimSpace(c.ForwardAuthServices) == "" {
+		return services, nil
+	}
+
+	var raw map[string]struct {
+		Headers   map[string]string `json:"headers"`
+		Shadow    bool              `json:"shadow"`
+		Provision string            `json:"provision"`
+	}
+	if err := json.Unmarshal([]byte(c.ForwardAuthServices), &raw); err != nil {
+		return nil, fmt.Errorf("forward auth services: invalid JSON: %w", err)
+	}
+	for name, svc := range raw {
+		if !sourceNameRe.MatchString(name) {
+			return nil, fmt.Errorf("forward auth services: invalid service name %q (use lowercase letters, digits, - and _)", name)
+		}
+		if name == "logout" {
+			return nil, fmt.Errorf("forward auth services: %q is reserved for /auth/logout", name)
+		}
+		for header, field := range svc.Headers {
+			if !slices.Contains(ForwardAuthFields, field) {
+				return nil, fmt.Errorf("forward auth services: %s: header %s: unknown field %q (use %s)", name, header, field, strings.Join(ForwardAuthFields, ", "))
+			}
+		}
+		if svc.Provision != "" && !slices.Contains(ForwardAuthProvisioners, svc.Provision) {
+			return nil, fmt.Errorf("forward auth services: %s: unknown provision hook %q (use %s)", name, svc.Provision, strings.Join(ForwardAuthProvisioners, ", "))
+		}
+		services[name] = ForwardAuthService{Name: name, Headers: svc.Headers, Shadow: svc.Shadow, Provision: svc.Provision}
+	}
+	return services, nil
+}
+
+// ProvisionerNames are the built-in provisioners, in their default order.
+var ProvisionerNames = []string{"mattermost", "n8n", "gitlab", "grafana"}
+
+// ProvisionerOrder returns the provisioners to run, in order.
+func (c Config) ProvisionerOrder() ([]string, error) {
+	if len(c.Provisioners) == 0 {
+		return ProvisionerNames, nil
+	}
+	seen := map[string]bool{}
+	for _, name := range c.Provisioners {
+		if !slices.Contains(ProvisionerNames, name) {
+			return nil, fmt.Errorf("provisioners: unknown provisioner %q (use %s)", name, strings.Join(ProvisionerNames, ", "))
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("provisioners: %s listed twice", name)
+		}
+		seen[name] = true
+	}
+	return c.Provisioners, nil
+}
+
+// ProvisionerPolicy parses ProvisionPolicy.
+func (c Config) ProvisionerPolicy() (provision.Policy, error) {
+	return provision.ParsePolicy(c.ProvisionPolicy)
+}
+
+// TrustedIdentitySources parses IdentitySources.
+func (c Config) TrustedIdentitySources() ([]identity.Source, error) {
+	return identity.ParseSources(c.IdentitySources)
+}
+
+// TrustedProxyPrefixes parses TrustedProxies. A bare IP is a single-address
+// prefix.
+func (c Config) TrustedProxyPrefixes() ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(c.TrustedProxies))
+	for _, entry := range c.TrustedProxies {
+		if !strings.Contains(entry, "/") {
+			addr, err := netip.ParseAddr(entry)
+			if err != nil {
+				return nil, fmt.Errorf("trusted proxies: %q is not an IP or CIDR", entry)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxies: %q is not an IP or CIDR", entry)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// CORSPolicy parses CORSOrigins into the set of listed origins, lowercased,
+// and whether "*" allows any other origin too.
+func (c Config) CORSPolicy() (origins map[string]bool, anyOrigin bool, err error) {
+	origins = map[string]bool{}
+	for _, entry := range c.CORSOrigins {
+		if entry == "*" {
+			anyOrigin = true
+			continue
+		}
+		u, err := url.Parse(entry)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+			u.User != nil || (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+			return nil, false, fmt.Errorf("CORS origins (AUTH_MANAGER_CORS_ORIGINS): %q must be \"*\" or scheme://host[:port]", entry)
+		}
+		origins[strings.ToLower(u.Scheme+"://"+u.Host)] = true
+	}
+	return origins, anyOrigin, nil
+}
+
+// ErrorPage parses ErrorPageTemplate, or returns nil when it's unset.
+func (c Config) ErrorPage() (*template.Template, error) {
+	if c.ErrorPageTemplate == "" {
+		return nil, nil
+	}
+	tmpl, err := template.ParseFiles(c.ErrorPageTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("error page template (AUTH_MANAGER_ERROR_PAGE_TEMPLATE): %w", err)
+	}
+	return tmpl, nil
+}
+
+// SessionCookieSameSite parses CookieSameSite; "" means lax. None is only
+// accepted when every public URL a session cookie is set for is https, since
+// browsers drop SameSite=None cookies that aren't Secure.
+func (c Config) SessionCookieSameSite() (http.SameSite, error) {
+	switch strings.ToLower(c.CookieSameSite) {
+	case "", "lax":
+		return http.SameSiteLaxMode, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		urls := []string{c.MattermostURL}
+		if c.N8NEnabled && c.N8NIssueSessions {
+			urls = append(urls, c.N8NURL)
+		}
+		for _, raw := range urls {
+			if !strings.HasPrefix(strings.ToLower(raw), "https://") {
+				return 0, fmt.Errorf("cookie SameSite (AUTH_MANAGER_COOKIE_SAMESITE) none needs https public URLs, not %q", raw)
+			}
+		}
+		return http.SameSiteNoneMode, nil
+	}
+	return 0, fmt.Errorf("cookie SameSite (AUTH_MANAGER_COOKIE_SAMESITE) %q must be lax, strict, or none", c.CookieSameSite)
+}
+
+// SlogLevel parses LogLevel; "" means info.
+func (c Config) SlogLevel() (slog.Level, error) {
+	var level slog.Level
+	if c.LogLevel == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		return 0, fmt.Errorf("log level %q must be debug, info, warn, or error", c.LogLevel)
+	}
+	return level, nil
+}
+
+// WebhookActionPolicy parses the configured webhook action policy.
+func (c Config) WebhookActionPolicy() (webhook.Policy, error) {
+	return webhook.ParsePolicy(c.WebhookPolicy, c.WebhookProvisionOn, c.WebhookDeprovisionOn)
+}
+
 func getEnv(key, fallback string) string {
 	if value, ok := os.LookupEnv(key); ok && value != "" {
 		return value
@@ -73,6 +1315,160 @@ func getEnv(key, fallback string) string {
 	return fallback
 }
 
+func getList(key string) []string {
+	return splitList(getEnv(key, ""))
+}
+
+// splitList splits a comma-separated list, dropping empty entries.
+func splitList(raw string) []string {
+	var out []string
+	for _, part := range strings.Split(raw, ",") {
+		if trimmed := strings.TrimSpace(part); trimmed != "" {
+			out = append(out, trimmed)
+		}
+	}
+	return out
+}
+
+func getInt(key string, fallback int) int {
+	value := getEnv(key, "")
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return fallback
+	}
+	return n
+}
+
+func getDuration(key string, fallback time.Duration) time.Duration {
+	value := getEnv(key, "")
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return fallback
+	}
+	return d
+}
+
+// ChangedFields returns the names of the fields that differ between a and b,
+// in declaration order.
+func ChangedFields(a, b Config) []string {
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	var changed []string
+	for i := 0; i < va.NumField(); i++ {
+		if !reflect.DeepEqual(va.Field(i).Interface(), vb.Field(i).Interface()) {
+			changed = append(changed, va.Type().Field(i).Name)
+		}
+	}
+	return changed
+}
+
+// RedactedValue replaces secrets in Redacted configurations.
+const RedactedValue = "[REDACTED]"
+
+// Redacted returns a copy of c that is safe to print or serve: secrets are
+// replaced by RedactedValue, passwords in URLs are masked, and the webhook
+// sources keep only their secret files.
+func (c Config) Redacted() Config {
+	for _, secret := range c.secretFields() {
+		if *secret != "" {
+			*secret = RedactedValue
+		}
+	}
+	for _, u := range c.urlFields() {
+		*u = redactURL(*u)
+	}
+	if strings.TrimSpace(c.WebhookSources) != "" {
+		c.WebhookSources = redactSecretFields(c.WebhookSources)
+	}
+	if strings.TrimSpace(c.OutboundWebhooks) != "" {
+		c.OutboundWebhooks = redactSecretFields(c.OutboundWebhooks)
+	}
+	return c
+}
+
+// Secrets returns the configured secret values, including the webhook
+// sources' secrets and the passwords in URLs, for masking wherever they
+// turn up, such as in logs.
+func (c Config) Secrets() []string {
+	var secrets []string
+	for _, secret := range c.secretFields() {
+		if *secret != "" {
+			secrets = append(secrets, *secret)
+		}
+	}
+	sources, _ := c.WebhookSourceMap()
+	for _, source := range sources {
+		if source.Secret != "" {
+			secrets = append(secrets, source.Secret)
+		}
+	}
+	for _, raw := range c.urlFields() {
+		if u, err := url.Parse(*raw); err == nil && u.User != nil {
+			if password, ok := u.User.Password(); ok && password != "" {
+				secrets = append(secrets, password)
+			}
+		}
+	}
+	return secrets
+}
+
+func (c *Config) secretFields() []*string {
+	return []*string{
+		&c.MattermostAdminToken, &c.WebhookSecret, &c.AdminToken, &c.AuthentikToken,
+		&c.N8NOwnerPass, &c.N8NAPIKey, &c.GitLabToken, &c.GrafanaAdminPass, &c.ProxySecret,
+		&c.MattermostCommandToken, &c.AttrEncryptionKey,
+	}
+}
+
+func (c *Config) urlFields() []*string {
+	return []*string{
+		&c.DatabaseURL, &c.MattermostURL, &c.MattermostInternalURL, &c.AuthentikURL,
+		&c.N8NURL, &c.N8NInternalURL, &c.GitLabInternalURL, &c.GrafanaInternalURL,
+		&c.LogoutRedirectURL, &c.OTLPEndpoint, &c.EventStreamURL,
+	}
+}
+
+// redactURL masks the password in raw. Values that don't parse as URLs are
+// masked whole, since they might be a secret pasted in the wrong place.
+func redactURL(raw string) string {
+	if raw == "" {
+		return ""
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return RedactedValue
+	}
+	return u.Redacted()
+}
+
+// redactSecretFields masks the secrets in raw, a JSON object of named
+// entries like WebhookSources: their secret fields, and the passwords in
+// their URLs.
+func redactSecretFields(raw string) string {
+	var sources map[string]map[string]any
+	if err := json.Unmarshal([]byte(raw), &sources); err != nil {
+		return RedactedValue
+	}
+	for _, source := range sources {
+		if _, ok := source["secret"]; ok {
+			source["secret"] = RedactedValue
+		}
+		if u, ok := source["url"].(string); ok {
+			source["url"] = redactURL(u)
+		}
+	}
+	data, err := json.Marshal(sources)
+	if err != nil {
+		return RedactedValue
+	}
+	return string(data)
+}
+
 func getSecretFromEnv(valueKey, fileKey, fallback string) string {
 	if path := os.Getenv(fileKey); path != "" {
 		if data, err := os.ReadFile(path); err == nil {
@@ -0,0 +1,191 @@
+// Package eventstream consumes Authentik events from a queue, as an
+// alternative to webhooks that loses nothing while auth-manager is down.
+// A Source is the queue; Redis Streams is the one implemented. A Consumer
+// reads from a Source, hands each message to a handler, acknowledges it
+// when the handler succeeds, and otherwise leaves it for redelivery until
+// it has failed MaxAttempts times, when it's moved to a dead-letter queue.
+package eventstream
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// Message is an event read from a Source.
+type Message struct {
+	ID   string
+	Body []byte
+	// Attempts counts deliveries of the message, this one included.
+	Attempts int
+}
+
+// Source is a queue of events with at-least-once delivery: a message read
+// but not acknowledged is delivered again later.
+type Source interface {
+	// Read waits until messages are available or ctx ends. Messages left
+	// unacknowledged come back from a later Read.
+	Read(ctx context.Context) ([]Message, error)
+	Ack(ctx context.Context, msg Message) error
+	// DeadLetter moves msg to the dead-letter queue with the reason it
+	// failed, and acknowledges it.
+	DeadLetter(ctx context.Context, msg Message, reason string) error
+	// Backlog reports the messages not yet delivered, or -1 when the
+	// source can't tell, and those delivered but not acknowledged.
+	Backlog(ctx context.Context) (lag, pending int64, err error)
+	Close() error
+}
+
+// Results passed to Settings.OnResult.
+const (
+	ResultAcked        = "acked"
+	ResultFailed       = "failed" // left for redelivery
+	ResultDeadLettered = "dead_lettered"
+)
+
+// Permanent marks a handler error that redelivery won't fix, such as a
+// message that doesn't parse, so the message is dead-lettered at once.
+func Permanent(err error) error { return permanentError{err} }
+
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Handler processes a message. An error leaves it for redelivery.
+type Handler func(ctx context.Context, msg Message) error
+
+// Settings tune a Consumer. Zero values take the defaults.
+type Settings struct {
+	MaxAttempts     int           // before dead-lettering; 5
+	BacklogEvery    time.Duration // between Backlog checks; 15s
+	RetryBackoff    time.Duration // after a failed Read, doubling; 1s
+	MaxRetryBackoff time.Duration // 30s
+
+	// OnResult, when set, is called for each message handled, with one of
+	// the Result constants. OnBacklog is called with each Backlog report.
+	OnResult  func(result string)
+	OnBacklog func(lag, pending int64)
+}
+
+func (s Settings) withDefaults() Settings {
+	if s.MaxAttempts <= 0 {
+		s.MaxAttempts = 5
+	}
+	if s.BacklogEvery <= 0 {
+		s.BacklogEvery = 15 * time.Second
+	}
+	if s.RetryBackoff <= 0 {
+		s.RetryBackoff = time.Second
+	}
+	if s.MaxRetryBackoff <= 0 {
+		s.MaxRetryBackoff = 30 * time.Second
+	}
+	return s
+}
+
+// Consumer feeds a Source's messages to a Handler.
+type Consumer struct {
+	source   Source
+	handle   Handler
+	settings Settings
+	logger   *slog.Logger
+}
+
+// NewConsumer returns a Consumer; Run starts it.
+func NewConsumer(source Source, handle Handler, settings Settings, logger *slog.Logger) *Consumer {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Consumer{source: source, handle: handle, settings: settings.withDefaults(), logger: logger}
+}
+
+// Run consumes messages until ctx ends, then closes the source. A message
+// being handled then is left unacknowledged, so it's delivered again.
+func (c *Consumer) Run(ctx context.Context) {
+	defer func() {
+		if err := c.source.Close(); err != nil {
+			c.logger.Warn("close event stream", "err", err)
+		}
+	}()
+	backoff := c.settings.RetryBackoff
+	var nextBacklog time.Time
+	for ctx.Err() == nil {
+		if now := time.Now(); !now.Before(nextBacklog) {
+			c.reportBacklog(ctx)
+			nextBacklog = now.Add(c.settings.BacklogEvery)
+		}
+
+		msgs, err := c.source.Read(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			c.logger.Warn("event stream read failed", "err", err, "retry_in", backoff)
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return
+			}
+			backoff = min(2*backoff, c.settings.MaxRetryBackoff)
+			continue
+		}
+		backoff = c.settings.RetryBackoff
+		for _, msg := range msgs {
+			if ctx.Err() != nil {
+				return
+			}
+			c.process(ctx, msg)
+		}
+	}
+}
+
+func (c *Consumer) process(ctx context.Context, msg Message) {
+	err := c.handle(ctx, msg)
+	if err == nil {
+		if err := c.source.Ack(ctx, msg); err != nil {
+			c.logger.Warn("event stream ack failed; the event will be delivered again", "id", msg.ID, "err", err)
+		}
+		c.result(ResultAcked)
+		return
+	}
+	if ctx.Err() != nil {
+		// Shutting down: whatever failed, the message is redelivered.
+		return
+	}
+
+	var permanent permanentError
+	if !errors.As(err, &permanent) && msg.Attempts < c.settings.MaxAttempts {
+		c.logger.Warn("event failed, leaving it for redelivery", "id", msg.ID, "attempts", msg.Attempts, "err", err)
+		c.result(ResultFailed)
+		return
+	}
+	c.logger.Error("event failed, dead-lettering it", "id", msg.ID, "attempts", msg.Attempts, "err", err)
+	if err := c.source.DeadLetter(ctx, msg, err.Error()); err != nil {
+		c.logger.Error("dead-lettering event failed; it will be delivered again", "id", msg.ID, "err", err)
+		c.result(ResultFailed)
+		return
+	}
+	c.result(ResultDeadLettered)
+}
+
+func (c *Consumer) reportBacklog(ctx context.Context) {
+	if c.settings.OnBacklog == nil {
+		return
+	}
+	lag, pending, err := c.source.Backlog(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			c.logger.Warn("event stream backlog check failed", "err", err)
+		}
+		return
+	}
+	c.settings.OnBacklog(lag, pending)
+}
+
+func (c *Consumer) result(result string) {
+	if c.settings.OnResult != nil {
+		c.settings.OnResult(result)
+	}
+}
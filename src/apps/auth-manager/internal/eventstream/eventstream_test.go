@@ -0,0 +1,181 @@
+package eventstream
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memorySource is a Source over a slice: every Read returns the messages
+// not yet acknowledged, each delivery counting as an attempt.
+type memorySource struct {
+	mu     sync.Mutex
+	msgs   []Message
+	acked  []string
+	dead   map[string]string
+	closed bool
+}
+
+func (s *memorySource) Read(ctx context.Context) ([]Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.msgs {
+		s.msgs[i].Attempts++
+	}
+	out := append([]Message(nil), s.msgs...)
+	if len(out) == 0 {
+		s.mu.Unlock()
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Millisecond):
+		}
+		s.mu.Lock()
+	}
+	return out, nil
+}
+
+func (s *memorySource) remove(id string) {
+	for i, msg := range s.msgs {
+		if msg.ID == id {
+			s.msgs = append(s.msgs[:i], s.msgs[i+1:]...)
+			return
+		}
+	}
+}
+
+func (s *memorySource) Ack(ctx context.Context, msg Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.acked = append(s.acked, msg.ID)
+	s.remove(msg.ID)
+	return nil
+}
+
+func (s *memorySource) DeadLetter(ctx context.Context, msg Message, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.dead == nil {
+		s.dead = map[string]string{}
+	}
+	s.dead[msg.ID] = reason
+	s.remove(msg.ID)
+	return nil
+}
+
+func (s *memorySource) Backlog(ctx context.Context) (int64, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return -1, int64(len(s.msgs)), nil
+}
+
+func (s *memorySource) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+func (s *memorySource) drained() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.msgs) == 0
+}
+
+func TestConsumer(t *testing.T) {
+	source := &memorySource{msgs: []Message{
+		{ID: "ok", Body: []byte("ok")},
+		{ID: "flaky", Body: []byte("flaky")},
+		{ID: "broken", Body: []byte("broken")},
+		{ID: "garbage", Body: []byte("garbage")},
+	}}
+	var mu sync.Mutex
+	results := map[string]int{}
+	var backlogs int
+	flakyCalls := 0
+	handle := func(ctx context.Context, msg Message) error {
+		switch msg.ID {
+		case "flaky":
+			if flakyCalls++; flakyCalls < 2 {
+				return errors.New("try again")
+			}
+		case "broken":
+			return errors.New("still broken")
+		case "garbage":
+			return Permanent(errors.New("unparseable"))
+		}
+		return nil
+	}
+	c := NewConsumer(source, handle, Settings{
+		MaxAttempts: 3,
+		OnResult: func(result string) {
+			mu.Lock()
+			results[result]++
+			mu.Unlock()
+		},
+		OnBacklog: func(lag, pending int64) { backlogs++ },
+	}, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.Run(ctx)
+		close(done)
+	}()
+	deadline := time.Now().Add(2 * time.Second)
+	for !source.drained() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	if !source.closed {
+		t.Error("source not closed after Run returned")
+	}
+	if len(source.acked) != 2 || source.acked[0] != "ok" || source.acked[1] != "flaky" {
+		t.Errorf("acked = %q, want ok then flaky", source.acked)
+	}
+	if source.dead["broken"] != "still broken" || source.dead["garbage"] != "unparseable" || len(source.dead) != 2 {
+		t.Errorf("dead-lettered = %v", source.dead)
+	}
+	// broken fails twice before its third and last attempt; flaky once.
+	want := map[string]int{ResultAcked: 2, ResultFailed: 3, ResultDeadLettered: 2}
+	for result, n := range want {
+		if results[result] != n {
+			t.Errorf("results = %v, want %v", results, want)
+			break
+		}
+	}
+	if backlogs != 1 {
+		t.Errorf("backlog reported %d times, want once", backlogs)
+	}
+}
+
+func TestConsumerShutdownLeavesMessage(t *testing.T) {
+	source := &memorySource{msgs: []Message{{ID: "slow"}}}
+	started := make(chan struct{})
+	handle := func(ctx context.Context, msg Message) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	var results []string
+	c := NewConsumer(source, handle, Settings{MaxAttempts: 1, OnResult: func(r string) { results = append(results, r) }}, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.Run(ctx)
+		close(done)
+	}()
+	<-started
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run didn't return after cancel")
+	}
+	if source.drained() || len(source.dead) != 0 || len(results) != 0 {
+		t.Errorf("interrupted message: dead = %v, results = %q; want it left for redelivery", source.dead, results)
+	}
+}
@@ -0,0 +1,399 @@
+package eventstream
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EventField is the stream entry field holding the event JSON.
+const EventField = "event"
+
+// deadLetterMaxLen caps the dead-letter stream, approximately, so a flood of
+// bad events can't fill Redis.
+const deadLetterMaxLen = 10000
+
+const (
+	dialTimeout  = 10 * time.Second
+	replyTimeout = 10 * time.Second
+)
+
+// RedisOptions configure a Redis Streams source.
+type RedisOptions struct {
+	// URL is redis://[user:password@]host[:port][/db], or rediss:// for TLS.
+	URL string
+	// Stream is the stream read, Group the consumer group reading it,
+	// created if missing, and Consumer this reader's name in the group;
+	// the hostname by default.
+	Stream   string
+	Group    string
+	Consumer string
+	// DeadLetter is the stream failed events are moved to; Stream + ":dead"
+	// by default.
+	DeadLetter string
+	// ClaimIdle is how long a delivered event may go unacknowledged before
+	// it's delivered again, to this or another consumer; 30s by default.
+	ClaimIdle time.Duration
+	// Block bounds how long a Read waits for new events, so redeliveries
+	// are checked regularly; 5s by default.
+	Block time.Duration
+	// Count caps the events returned by one Read; 10 by default.
+	Count int
+}
+
+// Redis reads events from a Redis stream through a consumer group. Events
+// are read with XREADGROUP, acknowledged with XACK, and those left
+// unacknowledged for ClaimIdle are taken back with XAUTOCLAIM, so a
+// consumer that dies mid-event doesn't lose it. It needs Redis 6.2 or
+// later; Backlog reports the lag from Redis 7.
+type Redis struct {
+	opts     RedisOptions
+	addr     string
+	username string
+	password string
+	db       int
+	tls      *tls.Config
+
+	// One command at a time: mu guards the connection, made on first use
+	// and again after an I/O error.
+	mu          sync.Mutex
+	conn        *respConn
+	groupReady  bool
+	claimCursor string
+}
+
+// NewRedis returns a source for the stream described by opts. It doesn't
+// connect until first used.
+func NewRedis(opts RedisOptions) (*Redis, error) {
+	u, err := url.Parse(opts.URL)
+	if err != nil {
+		return nil, fmt.Errorf("event stream URL: %w", err)
+	}
+	r := &Redis{opts: opts, claimCursor: "0-0"}
+	switch u.Scheme {
+	case "redis":
+	case "rediss":
+		r.tls = &tls.Config{ServerName: u.Hostname(), MinVersion: tls.VersionTLS12}
+	default:
+		return nil, fmt.Errorf("event stream URL: scheme must be redis or rediss, got %q", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, errors.New("event stream URL: missing host")
+	}
+	r.addr = u.Host
+	if u.Port() == "" {
+		r.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		r.username = u.User.Username()
+		r.password, _ = u.User.Password()
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if r.db, err = strconv.Atoi(db); err != nil || r.db < 0 {
+			return nil, fmt.Errorf("event stream URL: database must be a number, got %q", db)
+		}
+	}
+
+	if r.opts.Stream == "" {
+		return nil, errors.New("event stream: missing stream name")
+	}
+	if r.opts.Group == "" {
+		return nil, errors.New("event stream: missing consumer group")
+	}
+	if r.opts.Consumer == "" {
+		if r.opts.Consumer, err = os.Hostname(); err != nil || r.opts.Consumer == "" {
+			r.opts.Consumer = "auth-manager"
+		}
+	}
+	if r.opts.DeadLetter == "" {
+		r.opts.DeadLetter = r.opts.Stream + ":dead"
+	}
+	if r.opts.ClaimIdle <= 0 {
+		r.opts.ClaimIdle = 30 * time.Second
+	}
+	if r.opts.Block <= 0 {
+		r.opts.Block = 5 * time.Second
+	}
+	if r.opts.Count <= 0 {
+		r.opts.Count = 10
+	}
+	return r, nil
+}
+
+// Read returns events whose delivery timed out, if any, and otherwise waits
+// up to Block for new ones.
+func (r *Redis) Read(ctx context.Context) ([]Message, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.groupReady {
+		_, err := r.do(ctx, "XGROUP", "CREATE", r.opts.Stream, r.opts.Group, "0", "MKSTREAM")
+		if err != nil && !strings.HasPrefix(err.Error(), "redis: BUSYGROUP") {
+			return nil, fmt.Errorf("create consumer group: %w", err)
+		}
+		r.groupReady = true
+	}
+
+	msgs, err := r.claim(ctx)
+	if err != nil || len(msgs) > 0 {
+		return msgs, err
+	}
+
+	reply, err := r.do(ctx, "XREADGROUP", "GROUP", r.opts.Group, r.opts.Consumer,
+		"COUNT", strconv.Itoa(r.opts.Count), "BLOCK", strconv.FormatInt(r.opts.Block.Milliseconds(), 10),
+		"STREAMS", r.opts.Stream, ">")
+	if err != nil {
+		return nil, r.groupError(err)
+	}
+	if reply == nil {
+		return nil, nil
+	}
+	streams, ok := reply.([]any)
+	if !ok || len(streams) == 0 {
+		return nil, fmt.Errorf("XREADGROUP: unexpected reply %T", reply)
+	}
+	stream, ok := streams[0].([]any)
+	if !ok || len(stream) != 2 {
+		return nil, errors.New("XREADGROUP: unexpected reply")
+	}
+	entries, err := parseEntries(stream[1])
+	if err != nil {
+		return nil, fmt.Errorf("XREADGROUP: %w", err)
+	}
+	for i := range entries {
+		entries[i].Attempts = 1
+	}
+	return entries, nil
+}
+
+// claim takes over events delivered more than ClaimIdle ago and never
+// acknowledged. Each scan picks up where the last left off; an empty batch
+// may just mean the scan is still working through the group's pending list.
+func (r *Redis) claim(ctx context.Context) ([]Message, error) {
+	reply, err := r.do(ctx, "XAUTOCLAIM", r.opts.Stream, r.opts.Group, r.opts.Consumer,
+		strconv.FormatInt(r.opts.ClaimIdle.Milliseconds(), 10), r.claimCursor, "COUNT", strconv.Itoa(r.opts.Count))
+	if err != nil {
+		return nil, r.groupError(err)
+	}
+	parts, ok := reply.([]any)
+	if !ok || len(parts) < 2 {
+		return nil, fmt.Errorf("XAUTOCLAIM: unexpected reply %T", reply)
+	}
+	cursor, _ := parts[0].(string)
+	if cursor == "" {
+		cursor = "0-0"
+	}
+	r.claimCursor = cursor
+	all, err := parseEntries(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("XAUTOCLAIM: %w", err)
+	}
+
+	msgs := all[:0]
+	for _, msg := range all {
+		if msg.Body == nil && msg.Attempts < 0 {
+			// Trimmed from the stream while pending: nothing left to process.
+			if _, err := r.do(ctx, "XACK", r.opts.Stream, r.opts.Group, msg.ID); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		attempts, err := r.deliveries(ctx, msg.ID)
+		if err != nil {
+			return nil, err
+		}
+		msg.Attempts = attempts
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
+// deliveries reports how many times the group has delivered the event id.
+func (r *Redis) deliveries(ctx context.Context, id string) (int, error) {
+	reply, err := r.do(ctx, "XPENDING", r.opts.Stream, r.opts.Group, id, id, "1")
+	if err != nil {
+		return 0, err
+	}
+	if rows, ok := reply.([]any); ok && len(rows) == 1 {
+		if row, ok := rows[0].([]any); ok && len(row) == 4 {
+			if n, ok := row[3].(int64); ok {
+				return int(n), nil
+			}
+		}
+	}
+	// Acknowledged meanwhile by another consumer; handling it again is
+	// harmless since provisioning is idempotent.
+	return 1, nil
+}
+
+// Ack acknowledges msg, removing it from the group's pending list.
+func (r *Redis) Ack(ctx context.Context, msg Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, err := r.do(ctx, "XACK", r.opts.Stream, r.opts.Group, msg.ID)
+	return err
+}
+
+// DeadLetter adds msg to the dead-letter stream, with where it came from,
+// how often it was tried, and the last error, then acknowledges it.
+func (r *Redis) DeadLetter(ctx context.Context, msg Message, reason string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := r.do(ctx, "XADD", r.opts.DeadLetter, "MAXLEN", "~", strconv.Itoa(deadLetterMaxLen), "*",
+		EventField, string(msg.Body), "source_id", msg.ID, "attempts", strconv.Itoa(msg.Attempts), "error", reason); err != nil {
+		return err
+	}
+	_, err := r.do(ctx, "XACK", r.opts.Stream, r.opts.Group, msg.ID)
+	return err
+}
+
+// Backlog reads the group's lag and pending count from XINFO GROUPS.
+func (r *Redis) Backlog(ctx context.Context) (lag, pending int64, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	reply, err := r.do(ctx, "XINFO", "GROUPS", r.opts.Stream)
+	if err != nil {
+		return 0, 0, err
+	}
+	groups, _ := reply.([]any)
+	for _, g := range groups {
+		fields, _ := g.([]any)
+		info := map[string]any{}
+		for i := 0; i+1 < len(fields); i += 2 {
+			if key, ok := fields[i].(string); ok {
+				info[key] = fields[i+1]
+			}
+		}
+		if info["name"] != r.opts.Group {
+			continue
+		}
+		pending, _ = info["pending"].(int64)
+		lag, ok := info["lag"].(int64)
+		if !ok {
+			lag = -1
+		}
+		return lag, pending, nil
+	}
+	return 0, 0, fmt.Errorf("consumer group %q not found", r.opts.Group)
+}
+
+// Close drops the connection.
+func (r *Redis) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.conn == nil {
+		return nil
+	}
+	err := r.conn.close()
+	r.conn = nil
+	return err
+}
+
+// groupError notes that the group must be created again when Redis reports
+// it missing, as after the stream is deleted.
+func (r *Redis) groupError(err error) error {
+	if strings.HasPrefix(err.Error(), "redis: NOGROUP") {
+		r.groupReady = false
+	}
+	return err
+}
+
+// do runs a command, connecting first if need be. r.mu must be held. The
+// reply is awaited for replyTimeout beyond Block, so a blocking read on a
+// quiet stream isn't mistaken for a dead connection.
+func (r *Redis) do(ctx context.Context, args ...string) (any, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.opts.Block+replyTimeout)
+	defer cancel()
+	if r.conn == nil {
+		conn, err := r.dial(ctx)
+		if err != nil {
+			return nil, err
+		}
+		r.conn = conn
+	}
+	reply, err := r.conn.do(ctx, args...)
+	var redisErr redisError
+	if err != nil && !errors.As(err, &redisErr) {
+		_ = r.conn.close()
+		r.conn = nil
+	}
+	return reply, err
+}
+
+func (r *Redis) dial(ctx context.Context) (*respConn, error) {
+	dialCtx, cancel := context.WithTimeout(ctx, dialTimeout)
+	defer cancel()
+	dialer := &net.Dialer{KeepAlive: 30 * time.Second}
+	var nc net.Conn
+	var err error
+	if r.tls != nil {
+		nc, err = (&tls.Dialer{NetDialer: dialer, Config: r.tls}).DialContext(dialCtx, "tcp", r.addr)
+	} else {
+		nc, err = dialer.DialContext(dialCtx, "tcp", r.addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("connect to redis: %w", err)
+	}
+	conn := newRESPConn(nc)
+	if r.password != "" {
+		args := []string{"AUTH", r.password}
+		if r.username != "" {
+			args = []string{"AUTH", r.username, r.password}
+		}
+		if _, err := conn.do(dialCtx, args...); err != nil {
+			conn.close()
+			return nil, fmt.Errorf("redis AUTH: %w", err)
+		}
+	}
+	if r.db != 0 {
+		if _, err := conn.do(dialCtx, "SELECT", strconv.Itoa(r.db)); err != nil {
+			conn.close()
+			return nil, fmt.Errorf("redis SELECT: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+// parseEntries decodes a list of stream entries, [id, [field, value, ...]].
+// An entry deleted from the stream while pending comes back, from
+// XAUTOCLAIM on Redis 6.2, with no fields; it's marked with Attempts -1.
+func parseEntries(reply any) ([]Message, error) {
+	if reply == nil {
+		return nil, nil
+	}
+	list, ok := reply.([]any)
+	if !ok {
+		return nil, fmt.Errorf("unexpected entries %T", reply)
+	}
+	msgs := make([]Message, 0, len(list))
+	for _, item := range list {
+		entry, ok := item.([]any)
+		if !ok || len(entry) != 2 {
+			return nil, errors.New("malformed stream entry")
+		}
+		id, ok := entry[0].(string)
+		if !ok {
+			return nil, errors.New("malformed stream entry ID")
+		}
+		msg := Message{ID: id}
+		fields, _ := entry[1].([]any)
+		if fields == nil {
+			msg.Attempts = -1
+		}
+		for i := 0; i+1 < len(fields); i += 2 {
+			if key, _ := fields[i].(string); key == EventField {
+				value, _ := fields[i+1].(string)
+				msg.Body = []byte(value)
+			}
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
@@ -0,0 +1,128 @@
+package eventstream
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/eventstream/redistest"
+)
+
+func newTestRedis(t *testing.T, fake *redistest.Server) *Redis {
+	t.Helper()
+	r, err := NewRedis(RedisOptions{
+		URL:       fake.URL,
+		Stream:    "events",
+		Group:     "auth-manager",
+		Consumer:  "test",
+		ClaimIdle: 20 * time.Millisecond,
+		Block:     10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+func TestRedisReadAckRedeliver(t *testing.T) {
+	fake := redistest.NewServer(t)
+	r := newTestRedis(t, fake)
+	ctx := context.Background()
+
+	msgs, err := r.Read(ctx)
+	if err != nil || len(msgs) != 0 {
+		t.Fatalf("Read on an empty stream = %v, %v", msgs, err)
+	}
+	first := fake.Add("events", EventField, `{"action":"login"}`)
+	second := fake.Add("events", "other", "x")
+	msgs, err = r.Read(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 2 || msgs[0].ID != first || string(msgs[0].Body) != `{"action":"login"}` || msgs[0].Attempts != 1 {
+		t.Fatalf("Read = %+v", msgs)
+	}
+	if msgs[1].Body != nil {
+		t.Errorf("entry without an event field: body = %q, want nil", msgs[1].Body)
+	}
+	if err := r.Ack(ctx, msgs[0]); err != nil {
+		t.Fatal(err)
+	}
+	if pending := fake.Pending("events", "auth-manager"); len(pending) != 1 || pending[0] != second {
+		t.Errorf("pending = %q, want only %s", pending, second)
+	}
+
+	// Unacknowledged past ClaimIdle, the second entry comes back.
+	time.Sleep(30 * time.Millisecond)
+	msgs, err = r.Read(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || msgs[0].ID != second || msgs[0].Attempts != 2 {
+		t.Fatalf("redelivery = %+v", msgs)
+	}
+
+	lag, pending, err := r.Backlog(ctx)
+	if err != nil || lag != 0 || pending != 1 {
+		t.Errorf("Backlog = %d, %d, %v; want 0 lag and 1 pending", lag, pending, err)
+	}
+
+	if err := r.DeadLetter(ctx, msgs[0], "no event field"); err != nil {
+		t.Fatal(err)
+	}
+	dead := fake.Entries("events:dead")
+	if len(dead) != 1 || dead[0].Fields["source_id"] != second || dead[0].Fields["attempts"] != "2" || dead[0].Fields["error"] != "no event field" {
+		t.Errorf("dead letters = %+v", dead)
+	}
+	if pending := fake.Pending("events", "auth-manager"); len(pending) != 0 {
+		t.Errorf("pending after dead-lettering = %q", pending)
+	}
+}
+
+func TestRedisAuthAndReconnect(t *testing.T) {
+	fake := redistest.NewServer(t)
+	fake.RequirePassword("hunter2")
+	ctx := context.Background()
+
+	wrong, err := NewRedis(RedisOptions{URL: strings.Replace(fake.URL, "redis://", "redis://:nope@", 1), Stream: "events", Group: "g"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wrong.Read(ctx); err == nil || !strings.Contains(err.Error(), "WRONGPASS") {
+		t.Errorf("Read with the wrong password: err = %v", err)
+	}
+
+	r, err := NewRedis(RedisOptions{URL: strings.Replace(fake.URL, "redis://", "redis://default:hunter2@", 1) + "/2", Stream: "events", Group: "g", Block: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, err := r.Read(ctx); err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Join(fake.Commands(), " ")
+	if !strings.Contains(got, "AUTH SELECT XGROUP XAUTOCLAIM XREADGROUP") {
+		t.Errorf("commands = %s", got)
+	}
+
+	// A dropped connection is made again on the next command.
+	r.mu.Lock()
+	r.conn.close()
+	r.mu.Unlock()
+	if _, err := r.Read(ctx); err == nil {
+		t.Error("Read on a closed connection succeeded")
+	}
+	if _, err := r.Read(ctx); err != nil {
+		t.Errorf("Read after reconnecting: %v", err)
+	}
+}
+
+func TestNewRedisRejectsBadURLs(t *testing.T) {
+	for _, raw := range []string{"http://localhost", "redis://", "redis://localhost/x", "redis://localhost/-1"} {
+		if _, err := NewRedis(RedisOptions{URL: raw, Stream: "s", Group: "g"}); err == nil {
+			t.Errorf("NewRedis(%q) succeeded", raw)
+		}
+	}
+}
@@ -0,0 +1,461 @@
+// Package redistest provides an in-memory fake of the Redis Streams
+// commands the event stream consumer uses, for tests. It speaks enough RESP2
+// over TCP for a real client, and keeps just enough state for consumer
+// groups: entries, each group's last delivered ID, and its pending list.
+package redistest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Entry is a stream entry as stored by the fake.
+type Entry struct {
+	ID     string
+	Fields map[string]string
+}
+
+type pending struct {
+	consumer    string
+	deliveredAt time.Time
+	deliveries  int64
+}
+
+type group struct {
+	lastDelivered int64
+	pending       map[string]*pending
+}
+
+type stream struct {
+	entries []Entry
+	groups  map[string]*group
+}
+
+// Server is a fake Redis server.
+type Server struct {
+	// URL is the redis:// URL of the server.
+	URL string
+
+	listener net.Listener
+
+	mu       sync.Mutex
+	password string
+	nextID   int64
+	streams  map[string]*stream
+	commands []string
+	conns    map[net.Conn]bool
+	closed   bool
+}
+
+// NewServer starts a fake listening on localhost, closed when t ends.
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("redistest: listen: %v", err)
+	}
+	s := &Server{
+		URL:      "redis://" + l.Addr().String(),
+		listener: l,
+		streams:  map[string]*stream{},
+		conns:    map[net.Conn]bool{},
+	}
+	go s.serve()
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Close stops the server and drops its connections.
+func (s *Server) Close() {
+	s.mu.Lock()
+	s.closed = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.listener.Close()
+}
+
+// RequirePassword makes connections made from now on AUTH with password.
+func (s *Server) RequirePassword(password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.password = password
+}
+
+// Add appends an entry with the given field/value pairs to a stream and
+// returns its ID.
+func (s *Server) Add(name string, fieldValues ...string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.add(name, fieldValues)
+}
+
+// Entries returns a copy of a stream's entries.
+func (s *Server) Entries(name string) []Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.streams[name]
+	if st == nil {
+		return nil
+	}
+	return append([]Entry(nil), st.entries...)
+}
+
+// Pending returns the IDs a group has delivered and not had acknowledged.
+func (s *Server) Pending(name, groupName string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.streams[name]
+	if st == nil || st.groups[groupName] == nil {
+		return nil
+	}
+	var ids []string
+	for _, e := range st.entries {
+		if st.groups[groupName].pending[e.ID] != nil {
+			ids = append(ids, e.ID)
+		}
+	}
+	return ids
+}
+
+// Commands returns the names of the commands received, in order.
+func (s *Server) Commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.commands...)
+}
+
+func (s *Server) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = true
+		s.mu.Unlock()
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	s.mu.Lock()
+	password := s.password
+	s.mu.Unlock()
+	authed := password == ""
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(args[0])
+		var reply any
+		switch {
+		case cmd == "AUTH":
+			if args[len(args)-1] != password {
+				reply = fmt.Errorf("WRONGPASS invalid username-password pair")
+				break
+			}
+			authed = true
+			reply = simple("OK")
+		case !authed:
+			reply = fmt.Errorf("NOAUTH Authentication required.")
+		case cmd == "XREADGROUP":
+			reply = s.readGroup(args)
+		default:
+			s.mu.Lock()
+			reply = s.exec(cmd, args)
+			s.mu.Unlock()
+		}
+		s.mu.Lock()
+		s.commands = append(s.commands, cmd)
+		s.mu.Unlock()
+		writeReply(w, reply)
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// simple is a simple string reply; other strings are sent as bulk strings.
+type simple string
+
+// exec runs any command but XREADGROUP, which may block. s.mu is held.
+func (s *Server) exec(cmd string, args []string) any {
+	switch cmd {
+	case "PING":
+		return simple("PONG")
+	case "SELECT":
+		return simple("OK")
+	case "XGROUP":
+		if len(args) < 5 || strings.ToUpper(args[1]) != "CREATE" {
+			return fmt.Errorf("ERR unsupported XGROUP")
+		}
+		st := s.streams[args[2]]
+		if st == nil {
+			st = &stream{groups: map[string]*group{}}
+			s.streams[args[2]] = st
+		}
+		if st.groups[args[3]] != nil {
+			return fmt.Errorf("BUSYGROUP Consumer Group name already exists")
+		}
+		g := &group{pending: map[string]*pending{}}
+		if args[4] == "$" && len(st.entries) > 0 {
+			g.lastDelivered = seq(st.entries[len(st.entries)-1].ID)
+		}
+		st.groups[args[3]] = g
+		return simple("OK")
+	case "XADD":
+		name, rest := args[1], args[2:]
+		if len(rest) > 3 && strings.ToUpper(rest[0]) == "MAXLEN" {
+			rest = rest[1:]
+			if rest[0] == "~" || rest[0] == "=" {
+				rest = rest[1:]
+			}
+			rest = rest[1:]
+		}
+		if len(rest) == 0 || rest[0] != "*" || len(rest)%2 == 0 {
+			return fmt.Errorf("ERR unsupported XADD")
+		}
+		return s.add(name, rest[1:])
+	case "XACK":
+		g, err := s.group(args[1], args[2])
+		if err != nil {
+			return err
+		}
+		var n int64
+		for _, id := range args[3:] {
+			if g.pending[id] != nil {
+				delete(g.pending, id)
+				n++
+			}
+		}
+		return n
+	case "XPENDING":
+		// Only the extended form for a single ID, as the consumer uses it.
+		g, err := s.group(args[1], args[2])
+		if err != nil {
+			return err
+		}
+		if len(args) < 6 {
+			return fmt.Errorf("ERR unsupported XPENDING")
+		}
+		rows := []any{}
+		for _, e := range s.streams[args[1]].entries {
+			if p := g.pending[e.ID]; p != nil && seq(e.ID) >= seq(args[3]) && seq(e.ID) <= seq(args[4]) {
+				rows = append(rows, []any{e.ID, p.consumer, int64(time.Since(p.deliveredAt) / time.Millisecond), p.deliveries})
+			}
+		}
+		return rows
+	case "XAUTOCLAIM":
+		return s.autoclaim(args)
+	case "XINFO":
+		if len(args) < 3 || strings.ToUpper(args[1]) != "GROUPS" {
+			return fmt.Errorf("ERR unsupported XINFO")
+		}
+		st := s.streams[args[2]]
+		if st == nil {
+			return fmt.Errorf("ERR no such key")
+		}
+		groups := []any{}
+		for name, g := range st.groups {
+			var lag int64
+			for _, e := range st.entries {
+				if seq(e.ID) > g.lastDelivered {
+					lag++
+				}
+			}
+			groups = append(groups, []any{
+				"name", name, "consumers", int64(1), "pending", int64(len(g.pending)),
+				"last-delivered-id", fmt.Sprintf("%d-0", g.lastDelivered), "entries-read", nil, "lag", lag,
+			})
+		}
+		return groups
+	}
+	return fmt.Errorf("ERR unknown command '%s'", cmd)
+}
+
+func (s *Server) add(name string, fieldValues []string) string {
+	st := s.streams[name]
+	if st == nil {
+		st = &stream{groups: map[string]*group{}}
+		s.streams[name] = st
+	}
+	s.nextID++
+	entry := Entry{ID: fmt.Sprintf("%d-0", s.nextID), Fields: map[string]string{}}
+	for i := 0; i+1 < len(fieldValues); i += 2 {
+		entry.Fields[fieldValues[i]] = fieldValues[i+1]
+	}
+	st.entries = append(st.entries, entry)
+	return entry.ID
+}
+
+func (s *Server) group(name, groupName string) (*group, error) {
+	st := s.streams[name]
+	if st == nil || st.groups[groupName] == nil {
+		return nil, fmt.Errorf("NOGROUP No such key '%s' or consumer group '%s'", name, groupName)
+	}
+	return st.groups[groupName], nil
+}
+
+// readGroup serves XREADGROUP GROUP g c COUNT n BLOCK ms STREAMS key >,
+// polling for new entries until the BLOCK runs out.
+func (s *Server) readGroup(args []string) any {
+	if len(args) != 11 || strings.ToUpper(args[4]) != "COUNT" || strings.ToUpper(args[6]) != "BLOCK" || args[10] != ">" {
+		return fmt.Errorf("ERR unsupported XREADGROUP")
+	}
+	groupName, consumer, name := args[2], args[3], args[9]
+	count, _ := strconv.Atoi(args[5])
+	block, _ := strconv.Atoi(args[7])
+	deadline := time.Now().Add(time.Duration(block) * time.Millisecond)
+	for {
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			return nil
+		}
+		g, err := s.group(name, groupName)
+		if err != nil {
+			s.mu.Unlock()
+			return err
+		}
+		var entries []any
+		for _, e := range s.streams[name].entries {
+			if len(entries) == count {
+				break
+			}
+			if id := seq(e.ID); id > g.lastDelivered {
+				g.lastDelivered = id
+				g.pending[e.ID] = &pending{consumer: consumer, deliveredAt: time.Now(), deliveries: 1}
+				entries = append(entries, entryReply(e))
+			}
+		}
+		s.mu.Unlock()
+		if len(entries) > 0 {
+			return []any{[]any{name, entries}}
+		}
+		if time.Now().After(deadline) {
+			return nil
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+}
+
+// autoclaim serves XAUTOCLAIM key group consumer min-idle start COUNT n,
+// replying in the Redis 7 form.
+func (s *Server) autoclaim(args []string) any {
+	if len(args) != 8 || strings.ToUpper(args[6]) != "COUNT" {
+		return fmt.Errorf("ERR unsupported XAUTOCLAIM")
+	}
+	g, err := s.group(args[1], args[2])
+	if err != nil {
+		return err
+	}
+	minIdle, _ := strconv.Atoi(args[4])
+	count, _ := strconv.Atoi(args[7])
+	start := seq(args[5])
+	entries := []any{}
+	cursor := "0-0"
+	for _, e := range s.streams[args[1]].entries {
+		p := g.pending[e.ID]
+		if p == nil || seq(e.ID) < start || time.Since(p.deliveredAt) < time.Duration(minIdle)*time.Millisecond {
+			continue
+		}
+		if len(entries) == count {
+			cursor = e.ID
+			break
+		}
+		p.consumer, p.deliveredAt = args[3], time.Now()
+		p.deliveries++
+		entries = append(entries, entryReply(e))
+	}
+	return []any{cursor, entries, []any{}}
+}
+
+func entryReply(e Entry) any {
+	fields := []any{}
+	for k, v := range e.Fields {
+		fields = append(fields, k, v)
+	}
+	return []any{e.ID, fields}
+}
+
+// seq is the numeric part of an ID the fake generated, "n-0".
+func seq(id string) int64 {
+	n, _ := strconv.ParseInt(strings.SplitN(id, "-", 2)[0], 10, 64)
+	return n
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return nil, fmt.Errorf("redistest: expected an array, got %q", line)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("redistest: bad array header %q", line)
+	}
+	args := make([]string, n)
+	for i := range args {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "$")))
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("redistest: bad bulk header %q", line)
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func writeReply(w *bufio.Writer, reply any) {
+	switch v := reply.(type) {
+	case nil:
+		w.WriteString("*-1\r\n")
+	case simple:
+		fmt.Fprintf(w, "+%s\r\n", v)
+	case error:
+		fmt.Fprintf(w, "-%s\r\n", v)
+	case string:
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(v), v)
+	case int64:
+		fmt.Fprintf(w, ":%d\r\n", v)
+	case []any:
+		fmt.Fprintf(w, "*%d\r\n", len(v))
+		for _, item := range v {
+			writeReply(w, item)
+		}
+	default:
+		panic(fmt.Sprintf("redistest: can't encode %T", reply))
+	}
+}
@@ -0,0 +1,120 @@
+package eventstream
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// Bounds on what a reply may claim to hold, so a confused or hostile peer
+// can't make the client allocate without limit.
+const (
+	maxBulkBytes     = 64 << 20
+	maxArrayElements = 1 << 20
+)
+
+// redisError is an error reply. The connection is still usable after one.
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// respConn speaks RESP2, the Redis protocol, over a connection, one command
+// at a time. Replies decode to string (simple and bulk strings), int64,
+// []any, or nil.
+type respConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+}
+
+func newRESPConn(conn net.Conn) *respConn {
+	return &respConn{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}
+}
+
+// do sends a command and reads its reply. ctx bounds the round trip: when
+// it ends the connection's deadline is pulled in, which interrupts a
+// blocking read. Any error but a redisError leaves the connection unusable.
+func (c *respConn) do(ctx context.Context, args ...string) (any, error) {
+	deadline, _ := ctx.Deadline()
+	if err := c.conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	stop := context.AfterFunc(ctx, func() { _ = c.conn.SetDeadline(time.Unix(1, 0)) })
+	defer stop()
+
+	fmt.Fprintf(c.w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(c.w, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	err := c.w.Flush()
+	var reply any
+	if err == nil {
+		reply, err = readReply(c.r)
+	}
+	if err != nil && ctx.Err() != nil {
+		var redisErr redisError
+		if !errors.As(err, &redisErr) {
+			return nil, ctx.Err()
+		}
+	}
+	return reply, err
+}
+
+func (c *respConn) close() error { return c.conn.Close() }
+
+func readReply(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: malformed reply %q", line)
+	}
+	kind, rest := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return rest, nil
+	case '-':
+		return nil, redisError(rest)
+	case ':':
+		n, err := strconv.ParseInt(rest, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("redis: malformed integer %q", rest)
+		}
+		return n, nil
+	case '$':
+		n, err := strconv.Atoi(rest)
+		switch {
+		case err != nil || n > maxBulkBytes:
+			return nil, fmt.Errorf("redis: bad bulk length %q", rest)
+		case n < 0:
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(rest)
+		switch {
+		case err != nil || n > maxArrayElements:
+			return nil, fmt.Errorf("redis: bad array length %q", rest)
+		case n < 0:
+			return nil, nil
+		}
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unknown reply type %q", kind)
+}
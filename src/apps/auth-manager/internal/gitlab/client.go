@@ -0,0 +1,198 @@
+package gitlab
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/accounts"
+	"github.com/rave-org/rave/apps/auth-manager/internal/httpx"
+)
+
+// User states reported by GitLab.
+const (
+	StateActive  = "active"
+	StateBlocked = "blocked"
+)
+
+// Identity captures the fields we need to create a GitLab user.
+type Identity struct {
+	Email    string
+	Name     string
+	Username string
+}
+
+// User is the subset of GitLab user fields we care about.
+type User struct {
+	ID       int    `json:"id"`
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	Name     string `json:"name"`
+	State    string `json:"state"`
+}
+
+// Client is a minimal GitLab REST API client for user provisioning. It
+// authenticates with an admin personal access token.
+type Client struct {
+	baseURL    string
+	token      string
+	httpClient *http.Client
+	metrics    Metrics
+}
+
+// NewClient returns a client for the GitLab instance at baseURL.
+func NewClient(baseURL, token string) *Client {
+	return NewClientWithOptions(baseURL, token, httpx.Options{})
+}
+
+// NewClientWithOptions is NewClient with a tuned HTTP transport.
+func NewClientWithOptions(baseURL, token string, opts httpx.Options) *Client {
+	return &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		token:      token,
+		httpClient: httpx.NewClient(opts),
+	}
+}
+
+// EnsureUser returns the GitLab user with the identity's email, creating it
+// when missing. created reports whether it was.
+func (c *Client) EnsureUser(ctx context.Context, ident Identity) (user User, created bool, err error) {
+	if ident.Email == "" {
+		return User{}, false, errors.New("email required for gitlab provisioning")
+	}
+	user, err = c.GetUserByEmail(ctx, ident.Email)
+	if err == nil {
+		return user, false, nil
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return User{}, false, err
+	}
+	user, err = c.CreateUser(ctx, ident)
+	if StatusCode(err) == http.StatusConflict {
+		// Lost a race with another request creating the same user.
+		if existing, lookupErr := c.GetUserByEmail(ctx, ident.Email); lookupErr == nil {
+			return existing, false, nil
+		}
+	}
+	if err != nil {
+		return User{}, false, err
+	}
+	return user, true, nil
+}
+
+// GetUserByEmail looks up a user by email, returning ErrNotFound when GitLab
+// has no such user. Admin searches match emails, but also names and
+// usernames, so results are filtered for an exact match.
+func (c *Client) GetUserByEmail(ctx context.Context, email string) (User, error) {
+	path := "/api/v4/users?" + url.Values{"search": {email}, "per_page": {"100"}}.Encode()
+	var users []User
+	if err := c.call(ctx, http.MethodGet, path, nil, &users, "find_user"); err != nil {
+		return User{}, err
+	}
+	for _, u := range users {
+		if strings.EqualFold(u.Email, email) {
+			return u, nil
+		}
+	}
+	return User{}, ErrNotFound
+}
+
+// CreateUser creates a confirmed user with a random password; they sign in
+// through the identity provider, never with it.
+func (c *Client) CreateUser(ctx context.Context, ident Identity) (User, error) {
+	name := strings.TrimSpace(ident.Name)
+	if name == "" {
+		name = ident.Email
+	}
+	password, err := accounts.RandomPassword(32, accounts.Symbols)
+	if err != nil {
+		return User{}, fmt.Errorf("generate gitlab password: %w", err)
+	}
+	payload := map[string]any{
+		"email":             ident.Email,
+		"username":          username(ident),
+		"name":              name,
+		"password":          password,
+		"skip_confirmation": true,
+	}
+	var user User
+	if err := c.call(ctx, http.MethodPost, "/api/v4/users", payload, &user, "create_user"); err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
+
+// BlockUser blocks the user from signing in. Their projects and
+// contributions are kept.
+func (c *Client) BlockUser(ctx context.Context, userID int) error {
+	return c.call(ctx, http.MethodPost, "/api/v4/users/"+strconv.Itoa(userID)+"/block", nil, nil, "block_user")
+}
+
+// UnblockUser lets a blocked user sign in again.
+func (c *Client) UnblockUser(ctx context.Context, userID int) error {
+	return c.call(ctx, http.MethodPost, "/api/v4/users/"+strconv.Itoa(userID)+"/unblock", nil, nil, "unblock_user")
+}
+
+// Ping checks that GitLab is reachable and accepts the token.
+func (c *Client) Ping(ctx context.Context) error {
+	return c.call(ctx, http.MethodGet, "/api/v4/user", nil, nil, "current_user")
+}
+
+// call sends a JSON request and decodes a JSON response into dest. Error
+// responses return an *APIError.
+func (c *Client) call(ctx context.Context, method, path string, payload, dest any, operation string) error {
+	var reader io.Reader
+	if payload != nil {
+		body, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("PRIVATE-TOKEN", c.token)
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.send(req, operation)
+	if err != nil {
+		return fmt.Errorf("gitlab %s %s: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return newAPIError(resp, method, path)
+	}
+	if dest != nil {
+		return json.NewDecoder(resp.Body).Decode(dest)
+	}
+	return nil
+}
+
+var usernameInvalid = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// username returns a GitLab-safe username: the identity's username, or the
+// email's local part.
+func username(ident Identity) string {
+	name := ident.Username
+	if name == "" {
+		name, _, _ = strings.Cut(ident.Email, "@")
+	}
+	name = strings.Trim(usernameInvalid.ReplaceAllString(name, "_"), "_.-")
+	if name == "" {
+		name = "user"
+	}
+	return name
+}
@@ -0,0 +1,160 @@
+package gitlab
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeGitLab serves the user and group member endpoints the client uses.
+type fakeGitLab struct {
+	*httptest.Server
+	mu      sync.Mutex
+	users   []User
+	members map[string]map[int]AccessLevel // Group path → user ID → level
+}
+
+func newFakeGitLab(t *testing.T) *fakeGitLab {
+	t.Helper()
+	f := &fakeGitLab{members: map[string]map[int]AccessLevel{}}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeGitLab) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	path := r.URL.EscapedPath()
+	if r.Header.Get("PRIVATE-TOKEN") != "token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"message":"401 Unauthorized"}`))
+		return
+	}
+	switch {
+	case r.Method == http.MethodGet && path == "/api/v4/users":
+		var found []User
+		for _, u := range f.users {
+			if strings.Contains(u.Email, r.URL.Query().Get("search")) {
+				found = append(found, u)
+			}
+		}
+		_ = json.NewEncoder(w).Encode(found)
+	case r.Method == http.MethodPost && path == "/api/v4/users":
+		var payload map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+		if payload["skip_confirmation"] != true || payload["password"] == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		u := User{ID: len(f.users) + 1, Email: payload["email"].(string), Username: payload["username"].(string), State: StateActive}
+		f.users = append(f.users, u)
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(u)
+	case strings.HasPrefix(path, "/api/v4/groups/"):
+		rest := strings.TrimPrefix(path, "/api/v4/groups/")
+		group, rest, _ := strings.Cut(rest, "/members")
+		members := f.members[group]
+		var userID int
+		if rest != "" {
+			_ = json.Unmarshal([]byte(strings.TrimPrefix(rest, "/")), &userID)
+		}
+		var payload struct {
+			UserID      int         `json:"user_id"`
+			AccessLevel AccessLevel `json:"access_level"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+		switch r.Method {
+		case http.MethodGet:
+			level, ok := members[userID]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = w.Write([]byte(`{"message":"404 Not found"}`))
+				return
+			}
+			_ = json.NewEncoder(w).Encode(Member{ID: userID, AccessLevel: level})
+		case http.MethodPost:
+			if f.members[group] == nil {
+				f.members[group] = map[int]AccessLevel{}
+			}
+			f.members[group][payload.UserID] = payload.AccessLevel
+			w.WriteHeader(http.StatusCreated)
+		case http.MethodPut:
+			members[userID] = payload.AccessLevel
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestEnsureUser(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.users = []User{{ID: 7, Email: "alice@example.com"}, {ID: 8, Email: "alice@example.com.evil"}}
+	c := NewClient(f.URL, "token")
+
+	user, created, err := c.EnsureUser(context.Background(), Identity{Email: "alice@example.com"})
+	if err != nil || created || user.ID != 7 {
+		t.Fatalf("EnsureUser(existing) = %+v, %v, %v", user, created, err)
+	}
+
+	user, created, err = c.EnsureUser(context.Background(), Identity{Email: "bob.smith+ci@example.com", Name: "Bob"})
+	if err != nil || !created {
+		t.Fatalf("EnsureUser(new) = %+v, %v, %v", user, created, err)
+	}
+	if user.Username != "bob.smith_ci" {
+		t.Errorf("username = %q, want bob.smith_ci", user.Username)
+	}
+}
+
+func TestEnsureUser_BadToken(t *testing.T) {
+	f := newFakeGitLab(t)
+	c := NewClient(f.URL, "wrong")
+
+	_, _, err := c.EnsureUser(context.Background(), Identity{Email: "alice@example.com"})
+	if StatusCode(err) != http.StatusUnauthorized || !IsClientError(err) {
+		t.Fatalf("EnsureUser() error = %v, want a 401 client error", err)
+	}
+	if !strings.Contains(err.Error(), "401 Unauthorized") {
+		t.Errorf("error %q lacks GitLab's message", err)
+	}
+	if errors.Is(err, ErrNotFound) {
+		t.Error("401 must not match ErrNotFound")
+	}
+}
+
+func TestEnsureGroupMember(t *testing.T) {
+	f := newFakeGitLab(t)
+	f.members["platform%2Finfra"] = map[int]AccessLevel{1: Reporter, 2: Owner}
+	c := NewClient(f.URL, "token")
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		userID int
+		want   AccessLevel
+	}{
+		{1, Developer}, // Promoted
+		{2, Owner},     // Left alone
+		{3, Developer}, // Added
+	} {
+		if err := c.EnsureGroupMember(ctx, "platform/infra", tc.userID, Developer); err != nil {
+			t.Fatalf("EnsureGroupMember(%d) error = %v", tc.userID, err)
+		}
+		if got := f.members["platform%2Finfra"][tc.userID]; got != tc.want {
+			t.Errorf("user %d level = %d, want %d", tc.userID, got, tc.want)
+		}
+	}
+}
+
+func TestParseAccessLevel(t *testing.T) {
+	if level, err := ParseAccessLevel(" Maintainer "); err != nil || level != Maintainer {
+		t.Errorf("ParseAccessLevel(Maintainer) = %d, %v", level, err)
+	}
+	if _, err := ParseAccessLevel("admin"); err == nil {
+		t.Error("expected an error for an unknown level")
+	}
+}
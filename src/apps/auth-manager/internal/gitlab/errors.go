@@ -0,0 +1,84 @@
+package gitlab
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/redact"
+)
+
+// ErrNotFound matches (via errors.Is) GitLab 404s and users missing from a
+// lookup.
+var ErrNotFound = errors.New("gitlab resource not found")
+
+// APIError is a non-2xx response from the GitLab API. Message is GitLab's
+// "message" (or "error") field when present; validation errors, which GitLab
+// reports as an object of field errors, are flattened into it.
+type APIError struct {
+	StatusCode int
+	Message    string
+	Method     string
+	Path       string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("gitlab %s %s failed (%d): %s", e.Method, e.Path, e.StatusCode, strings.TrimSpace(e.Message))
+}
+
+// Is lets errors.Is(err, ErrNotFound) match 404 responses.
+func (e *APIError) Is(target error) bool {
+	return target == ErrNotFound && e.StatusCode == http.StatusNotFound
+}
+
+// newAPIError reads a GitLab error response body into an APIError,
+// masking any credentials it echoes back, ours included.
+func newAPIError(resp *http.Response, method, path string) *APIError {
+	apiErr := parseAPIError(resp, method, path)
+	apiErr.Message = redact.Text(apiErr.Message, redact.RequestSecrets(resp.Request)...)
+	return apiErr
+}
+
+func parseAPIError(resp *http.Response, method, path string) *APIError {
+	apiErr := &APIError{StatusCode: resp.StatusCode, Method: method, Path: path}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	var payload struct {
+		Message json.RawMessage `json:"message"`
+		Error   string          `json:"error"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		apiErr.Message = string(body)
+		return apiErr
+	}
+	var message string
+	switch {
+	case json.Unmarshal(payload.Message, &message) == nil:
+		apiErr.Message = message
+	case len(payload.Message) > 0:
+		apiErr.Message = string(payload.Message)
+	case payload.Error != "":
+		apiErr.Message = payload.Error
+	default:
+		apiErr.Message = string(body)
+	}
+	return apiErr
+}
+
+// StatusCode returns the HTTP status of a GitLab API error, or 0.
+func StatusCode(err error) int {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode
+	}
+	return 0
+}
+
+// IsClientError reports whether GitLab answered and refused the request
+// (4xx other than 429), as opposed to being unreachable or failing.
+func IsClientError(err error) bool {
+	status := StatusCode(err)
+	return status >= 400 && status < 500 && status != http.StatusTooManyRequests
+}
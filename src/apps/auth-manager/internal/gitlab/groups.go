@@ -0,0 +1,97 @@
+package gitlab
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// AccessLevel is a GitLab group membership level.
+type AccessLevel int
+
+// Group access levels, as the GitLab API numbers them.
+const (
+	Guest      AccessLevel = 10
+	Reporter   AccessLevel = 20
+	Developer  AccessLevel = 30
+	Maintainer AccessLevel = 40
+	Owner      AccessLevel = 50
+)
+
+var accessLevelNames = map[string]AccessLevel{
+	"guest":      Guest,
+	"reporter":   Reporter,
+	"developer":  Developer,
+	"maintainer": Maintainer,
+	"owner":      Owner,
+}
+
+// ParseAccessLevel parses an access level name such as "developer".
+func ParseAccessLevel(name string) (AccessLevel, error) {
+	level, ok := accessLevelNames[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return 0, fmt.Errorf("unknown gitlab access level %q (use guest, reporter, developer, maintainer, or owner)", name)
+	}
+	return level, nil
+}
+
+// Membership is a user's access to a group, named by ID or full path.
+type Membership struct {
+	Group       string
+	AccessLevel AccessLevel
+}
+
+// Member is the subset of GitLab group member fields we care about.
+type Member struct {
+	ID          int         `json:"id"`
+	Username    string      `json:"username"`
+	AccessLevel AccessLevel `json:"access_level"`
+}
+
+// GroupMember returns the user's direct membership of group, or ErrNotFound.
+func (c *Client) GroupMember(ctx context.Context, group string, userID int) (Member, error) {
+	var member Member
+	err := c.call(ctx, http.MethodGet, groupMembersPath(group)+"/"+strconv.Itoa(userID), nil, &member, "get_member")
+	return member, err
+}
+
+// AddGroupMember adds the user to group at level.
+func (c *Client) AddGroupMember(ctx context.Context, group string, userID int, level AccessLevel) error {
+	payload := map[string]int{"user_id": userID, "access_level": int(level)}
+	return c.call(ctx, http.MethodPost, groupMembersPath(group), payload, nil, "add_member")
+}
+
+// UpdateGroupMember changes the user's access level in group.
+func (c *Client) UpdateGroupMember(ctx context.Context, group string, userID int, level AccessLevel) error {
+	payload := map[string]int{"access_level": int(level)}
+	return c.call(ctx, http.MethodPut, groupMembersPath(group)+"/"+strconv.Itoa(userID), payload, nil, "update_member")
+}
+
+// EnsureGroupMember makes the user a member of group with at least level.
+// Existing members at or above level are left alone, so access granted in
+// GitLab directly isn't taken away.
+func (c *Client) EnsureGroupMember(ctx context.Context, group string, userID int, level AccessLevel) error {
+	member, err := c.GroupMember(ctx, group, userID)
+	switch {
+	case StatusCode(err) == http.StatusNotFound:
+		err = c.AddGroupMember(ctx, group, userID, level)
+		if StatusCode(err) == http.StatusConflict {
+			return nil // Added concurrently
+		}
+		return err
+	case err != nil:
+		return err
+	case member.AccessLevel < level:
+		return c.UpdateGroupMember(ctx, group, userID, level)
+	}
+	return nil
+}
+
+// groupMembersPath accepts a numeric group ID or a full path such as
+// "platform/backend", which GitLab wants URL-encoded.
+func groupMembersPath(group string) string {
+	return "/api/v4/groups/" + url.PathEscape(group) + "/members"
+}
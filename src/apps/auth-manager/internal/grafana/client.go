@@ -0,0 +1,273 @@
+package grafana
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/accounts"
+	"github.com/rave-org/rave/apps/auth-manager/internal/httpx"
+)
+
+// Grafana organization roles, lowest first.
+const (
+	RoleViewer = "Viewer"
+	RoleEditor = "Editor"
+	RoleAdmin  = "Admin"
+)
+
+var roleRanks = map[string]int{RoleViewer: 1, RoleEditor: 2, RoleAdmin: 3}
+
+// RoleRank orders org roles: 0 for unknown roles, then Viewer < Editor <
+// Admin.
+func RoleRank(role string) int {
+	return roleRanks[role]
+}
+
+// ParseRole returns the canonical org role for name, case-insensitively.
+func ParseRole(name string) (string, error) {
+	for role := range roleRanks {
+		if strings.EqualFold(role, strings.TrimSpace(name)) {
+			return role, nil
+		}
+	}
+	return "", fmt.Errorf("unknown grafana role %q (use Viewer, Editor, or Admin)", name)
+}
+
+// Identity captures the fields we need to create a Grafana user. Login must
+// match what the auth proxy header carries.
+type Identity struct {
+	Email string
+	Name  string
+	Login string
+}
+
+// User is the subset of Grafana user fields we care about.
+type User struct {
+	ID         int    `json:"id"`
+	Email      string `json:"email"`
+	Login      string `json:"login"`
+	Name       string `json:"name"`
+	IsDisabled bool   `json:"isDisabled"`
+}
+
+// Client is a minimal Grafana HTTP API client for user provisioning. It
+// authenticates as a Grafana server admin with basic auth, which the admin
+// API requires, and scopes org calls to one organization.
+type Client struct {
+	baseURL    string
+	user       string
+	password   string
+	orgID      int
+	httpClient *http.Client
+	metrics    Metrics
+}
+
+// NewClient returns a client for the Grafana instance at baseURL managing
+// organization orgID.
+func NewClient(baseURL, user, password string, orgID int) *Client {
+	return NewClientWithOptions(baseURL, user, password, orgID, httpx.Options{})
+}
+
+// NewClientWithOptions is NewClient with a tuned HTTP transport.
+func NewClientWithOptions(baseURL, user, password string, orgID int, opts httpx.Options) *Client {
+	if orgID <= 0 {
+		orgID = 1
+	}
+	return &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		user:       user,
+		password:   password,
+		orgID:      orgID,
+		httpClient: httpx.NewClient(opts),
+	}
+}
+
+// EnsureUser returns the Grafana user with the identity's email or login,
+// creating it when missing. created reports whether it was.
+func (c *Client) EnsureUser(ctx context.Context, ident Identity) (user User, created bool, err error) {
+	if ident.Email == "" {
+		return User{}, false, errors.New("email required for grafana provisioning")
+	}
+	user, err = c.LookupUser(ctx, ident.Email)
+	if errors.Is(err, ErrNotFound) && ident.Login != "" && ident.Login != ident.Email {
+		user, err = c.LookupUser(ctx, ident.Login)
+	}
+	if err == nil {
+		return user, false, nil
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return User{}, false, err
+	}
+	user, err = c.CreateUser(ctx, ident)
+	if err != nil {
+		return User{}, false, err
+	}
+	return user, true, nil
+}
+
+// LookupUser finds a user by login or email, returning ErrNotFound when
+// Grafana has no such user.
+func (c *Client) LookupUser(ctx context.Context, loginOrEmail string) (User, error) {
+	var user User
+	path := "/api/users/lookup?" + url.Values{"loginOrEmail": {loginOrEmail}}.Encode()
+	err := c.call(ctx, http.MethodGet, path, nil, &user, "lookup_user")
+	return user, err
+}
+
+// CreateUser creates a user with a random password; they sign in through
+// the auth proxy, never with it. Grafana adds new users to the main org.
+func (c *Client) CreateUser(ctx context.Context, ident Identity) (User, error) {
+	password, err := accounts.RandomPassword(32, accounts.Alphanumeric)
+	if err != nil {
+		return User{}, fmt.Errorf("generate grafana password: %w", err)
+	}
+	login := ident.Login
+	if login == "" {
+		login = ident.Email
+	}
+	payload := map[string]any{
+		"email":    ident.Email,
+		"login":    login,
+		"name":     ident.Name,
+		"password": password,
+		"OrgId":    c.orgID,
+	}
+	var result struct {
+		ID int `json:"id"`
+	}
+	if err := c.call(ctx, http.MethodPost, "/api/admin/users", payload, &result, "create_user"); err != nil {
+		return User{}, err
+	}
+	return User{ID: result.ID, Email: ident.Email, Login: login, Name: ident.Name}, nil
+}
+
+// DisableUser disables the user's account, signing them out everywhere.
+func (c *Client) DisableUser(ctx context.Context, userID int) error {
+	return c.call(ctx, http.MethodPost, "/api/admin/users/"+strconv.Itoa(userID)+"/disable", nil, nil, "disable_user")
+}
+
+// EnableUser re-enables a disabled account.
+func (c *Client) EnableUser(ctx context.Context, userID int) error {
+	return c.call(ctx, http.MethodPost, "/api/admin/users/"+strconv.Itoa(userID)+"/enable", nil, nil, "enable_user")
+}
+
+// Ping checks that Grafana is reachable and accepts the admin credentials.
+func (c *Client) Ping(ctx context.Context) error {
+	return c.call(ctx, http.MethodGet, "/api/org", nil, nil, "ping")
+}
+
+// OrgRole returns the user's role in the client's organization, or "" when
+// they aren't a member.
+func (c *Client) OrgRole(ctx context.Context, userID int) (string, error) {
+	var orgs []struct {
+		OrgID int    `json:"orgId"`
+		Role  string `json:"role"`
+	}
+	if err := c.call(ctx, http.MethodGet, "/api/users/"+strconv.Itoa(userID)+"/orgs", nil, &orgs, "user_orgs"); err != nil {
+		return "", err
+	}
+	for _, org := range orgs {
+		if org.OrgID == c.orgID {
+			return org.Role, nil
+		}
+	}
+	return "", nil
+}
+
+// SetOrgRole gives the user role in the client's organization, adding them
+// to it when they aren't a member.
+func (c *Client) SetOrgRole(ctx context.Context, user User, role string) error {
+	current, err := c.OrgRole(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	orgPath := "/api/orgs/" + strconv.Itoa(c.orgID) + "/users"
+	switch current {
+	case role:
+		return nil
+	case "":
+		payload := map[string]string{"loginOrEmail": user.Login, "role": role}
+		return c.call(ctx, http.MethodPost, orgPath, payload, nil, "add_org_user")
+	}
+	payload := map[string]string{"role": role}
+	return c.call(ctx, http.MethodPatch, orgPath+"/"+strconv.Itoa(user.ID), payload, nil, "set_org_role")
+}
+
+// TeamID returns the ID of the team called name in the client's
+// organization, or ErrNotFound.
+func (c *Client) TeamID(ctx context.Context, name string) (int, error) {
+	var result struct {
+		Teams []struct {
+			ID   int    `json:"id"`
+			Name string `json:"name"`
+		} `json:"teams"`
+	}
+	path := "/api/teams/search?" + url.Values{"name": {name}}.Encode()
+	if err := c.call(ctx, http.MethodGet, path, nil, &result, "search_teams"); err != nil {
+		return 0, err
+	}
+	for _, team := range result.Teams {
+		if team.Name == name {
+			return team.ID, nil
+		}
+	}
+	return 0, ErrNotFound
+}
+
+// AddTeamMember adds the user to the team. Existing members are not an
+// error.
+func (c *Client) AddTeamMember(ctx context.Context, teamID, userID int) error {
+	payload := map[string]int{"userId": userID}
+	err := c.call(ctx, http.MethodPost, "/api/teams/"+strconv.Itoa(teamID)+"/members", payload, nil, "add_team_member")
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusBadRequest &&
+		strings.Contains(strings.ToLower(apiErr.Message), "already") {
+		return nil
+	}
+	return err
+}
+
+// call sends a JSON request and decodes a JSON response into dest. Error
+// responses return an *APIError.
+func (c *Client) call(ctx context.Context, method, path string, payload, dest any, operation string) error {
+	var reader io.Reader
+	if payload != nil {
+		body, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(c.user, c.password)
+	req.Header.Set("X-Grafana-Org-Id", strconv.Itoa(c.orgID))
+	req.Header.Set("Accept", "application/json")
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.send(req, operation)
+	if err != nil {
+		return fmt.Errorf("grafana %s %s: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return newAPIError(resp, method, path)
+	}
+	if dest != nil {
+		return json.NewDecoder(resp.Body).Decode(dest)
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+package grafana
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeGrafana serves the user, org, and team endpoints the client uses for
+// org 1.
+type fakeGrafana struct {
+	*httptest.Server
+	mu      sync.Mutex
+	users   map[string]User // Login and email → user
+	roles   map[int]string  // User ID → org 1 role
+	teams   map[string]int  // Team name → ID
+	members map[int][]int   // Team ID → user IDs
+	calls   []string
+}
+
+func newFakeGrafana(t *testing.T) *fakeGrafana {
+	t.Helper()
+	f := &fakeGrafana{users: map[string]User{}, roles: map[int]string{}, teams: map[string]int{}, members: map[int][]int{}}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeGrafana) addUser(u User, role string) {
+	f.users[u.Login], f.users[u.Email] = u, u
+	if role != "" {
+		f.roles[u.ID] = role
+	}
+}
+
+func (f *fakeGrafana) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, r.Method+" "+r.URL.Path)
+	if user, pass, _ := r.BasicAuth(); user != "admin" || pass != "secret" || r.Header.Get("X-Grafana-Org-Id") != "1" {
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"message":"Invalid username or password"}`))
+		return
+	}
+	var body map[string]any
+	_ = json.NewDecoder(r.Body).Decode(&body)
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/api/users/lookup":
+		u, ok := f.users[r.URL.Query().Get("loginOrEmail")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"message":"user not found"}`))
+			return
+		}
+		_ = json.NewEncoder(w).Encode(u)
+	case r.Method == http.MethodPost && r.URL.Path == "/api/admin/users":
+		u := User{ID: 100 + len(f.users), Email: body["email"].(string), Login: body["login"].(string)}
+		f.addUser(u, RoleViewer)
+		_ = json.NewEncoder(w).Encode(map[string]any{"id": u.ID, "message": "User created"})
+	case r.Method == http.MethodPost && len(parts) == 5 && parts[1] == "admin" && (parts[4] == "disable" || parts[4] == "enable"):
+		id, _ := strconv.Atoi(parts[3])
+		for key, u := range f.users {
+			if u.ID == id {
+				u.IsDisabled = parts[4] == "disable"
+				f.users[key] = u
+			}
+		}
+	case r.Method == http.MethodGet && len(parts) == 4 && parts[3] == "orgs":
+		id, _ := strconv.Atoi(parts[2])
+		orgs := []map[string]any{{"orgId": 2, "role": RoleAdmin}}
+		if role, ok := f.roles[id]; ok {
+			orgs = append(orgs, map[string]any{"orgId": 1, "role": role})
+		}
+		_ = json.NewEncoder(w).Encode(orgs)
+	case r.Method == http.MethodPost && r.URL.Path == "/api/orgs/1/users":
+		u := f.users[body["loginOrEmail"].(string)]
+		f.roles[u.ID] = body["role"].(string)
+	case r.Method == http.MethodPatch && len(parts) == 5 && parts[2] == "1":
+		id, _ := strconv.Atoi(parts[4])
+		f.roles[id] = body["role"].(string)
+	case r.Method == http.MethodGet && r.URL.Path == "/api/teams/search":
+		teams := []map[string]any{}
+		for name, id := range f.teams {
+			if strings.Contains(name, r.URL.Query().Get("name")) {
+				teams = append(teams, map[string]any{"id": id, "name": name})
+			}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"teams": teams})
+	case r.Method == http.MethodPost && len(parts) == 4 && parts[3] == "members":
+		id, _ := strconv.Atoi(parts[2])
+		userID := int(body["userId"].(float64))
+		for _, member := range f.members[id] {
+			if member == userID {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"message":"User is already added to this team"}`))
+				return
+			}
+		}
+		f.members[id] = append(f.members[id], userID)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestEnsureUser(t *testing.T) {
+	f := newFakeGrafana(t)
+	f.addUser(User{ID: 7, Email: "alice@example.com", Login: "alice"}, RoleViewer)
+	f.addUser(User{ID: 8, Email: "bob@old.example.com", Login: "bob"}, RoleViewer)
+	c := NewClient(f.URL, "admin", "secret", 1)
+	ctx := context.Background()
+
+	tests := []struct {
+		name        string
+		ident       Identity
+		wantID      int
+		wantCreated bool
+	}{
+		{"by email", Identity{Email: "alice@example.com", Login: "someone-else"}, 7, false},
+		{"by login", Identity{Email: "bob@example.com", Login: "bob"}, 8, false},
+		{"created", Identity{Email: "carol@example.com", Login: "carol"}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, created, err := c.EnsureUser(ctx, tt.ident)
+			if err != nil {
+				t.Fatalf("EnsureUser() error = %v", err)
+			}
+			if created != tt.wantCreated || (tt.wantID != 0 && user.ID != tt.wantID) {
+				t.Errorf("EnsureUser() = %+v, created %v", user, created)
+			}
+			if created && (user.ID == 0 || user.Login != "carol") {
+				t.Errorf("created user = %+v", user)
+			}
+		})
+	}
+
+	if _, _, err := NewClient(f.URL, "admin", "wrong", 1).EnsureUser(ctx, Identity{Email: "alice@example.com"}); StatusCode(err) != http.StatusUnauthorized {
+		t.Errorf("EnsureUser() with a bad password error = %v, want 401", err)
+	}
+}
+
+func TestSetOrgRole(t *testing.T) {
+	f := newFakeGrafana(t)
+	member := User{ID: 7, Email: "alice@example.com", Login: "alice"}
+	outsider := User{ID: 8, Email: "bob@example.com", Login: "bob"}
+	f.addUser(member, RoleViewer)
+	f.addUser(outsider, "")
+	c := NewClient(f.URL, "admin", "secret", 1)
+	ctx := context.Background()
+
+	if err := c.SetOrgRole(ctx, member, RoleEditor); err != nil || f.roles[7] != RoleEditor {
+		t.Fatalf("SetOrgRole(member) = %v, role %q", err, f.roles[7])
+	}
+	if err := c.SetOrgRole(ctx, outsider, RoleAdmin); err != nil || f.roles[8] != RoleAdmin {
+		t.Fatalf("SetOrgRole(outsider) = %v, role %q", err, f.roles[8])
+	}
+	before := len(f.calls)
+	if err := c.SetOrgRole(ctx, member, RoleEditor); err != nil {
+		t.Fatalf("SetOrgRole(unchanged) error = %v", err)
+	}
+	if writes := len(f.calls) - before; writes != 1 {
+		t.Errorf("expected only the role lookup for an unchanged role, got %d calls", writes)
+	}
+}
+
+func TestTeams(t *testing.T) {
+	f := newFakeGrafana(t)
+	f.teams["Backend"], f.teams["Backend Oncall"] = 3, 4
+	c := NewClient(f.URL, "admin", "secret", 1)
+	ctx := context.Background()
+
+	id, err := c.TeamID(ctx, "Backend")
+	if err != nil || id != 3 {
+		t.Fatalf("TeamID(Backend) = %d, %v; want 3", id, err)
+	}
+	if _, err := c.TeamID(ctx, "Frontend"); err != ErrNotFound {
+		t.Errorf("TeamID(Frontend) error = %v, want ErrNotFound", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := c.AddTeamMember(ctx, 3, 7); err != nil {
+			t.Fatalf("AddTeamMember() #%d error = %v", i+1, err)
+		}
+	}
+	if got := f.members[3]; len(got) != 1 || got[0] != 7 {
+		t.Errorf("team 3 members = %v, want [7]", got)
+	}
+}
+
+func TestDisableUser(t *testing.T) {
+	f := newFakeGrafana(t)
+	f.addUser(User{ID: 7, Email: "a@example.com", Login: "alice"}, RoleViewer)
+	c := NewClient(f.URL, "admin", "secret", 1)
+	ctx := context.Background()
+
+	if err := c.DisableUser(ctx, 7); err != nil {
+		t.Fatalf("DisableUser() error = %v", err)
+	}
+	if user, err := c.LookupUser(ctx, "alice"); err != nil || !user.IsDisabled {
+		t.Fatalf("LookupUser() = %+v, %v; want a disabled user", user, err)
+	}
+	if err := c.EnableUser(ctx, 7); err != nil {
+		t.Fatalf("EnableUser() error = %v", err)
+	}
+	if user, _ := c.LookupUser(ctx, "a@example.com"); user.IsDisabled {
+		t.Error("expected the user to be enabled again")
+	}
+}
+
+func TestParseRole(t *testing.T) {
+	if role, err := ParseRole(" editor "); err != nil || role != RoleEditor {
+		t.Errorf("ParseRole(editor) = %q, %v", role, err)
+	}
+	if _, err := ParseRole("Owner"); err == nil {
+		t.Error("expected an error for an unknown role")
+	}
+	if RoleRank(RoleAdmin) <= RoleRank(RoleEditor) || RoleRank(RoleViewer) <= RoleRank("") {
+		t.Error("roles out of order")
+	}
+}
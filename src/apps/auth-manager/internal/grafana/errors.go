@@ -0,0 +1,67 @@
+package grafana
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/redact"
+)
+
+// ErrNotFound matches (via errors.Is) Grafana 404s and teams missing from a
+// search.
+var ErrNotFound = errors.New("grafana resource not found")
+
+// APIError is a non-2xx response from the Grafana API. Message comes from
+// Grafana's error JSON ({"message": ...}) when present.
+type APIError struct {
+	StatusCode int
+	Message    string
+	Method     string
+	Path       string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("grafana %s %s failed (%d): %s", e.Method, e.Path, e.StatusCode, strings.TrimSpace(e.Message))
+}
+
+// Is lets errors.Is(err, ErrNotFound) match 404 responses.
+func (e *APIError) Is(target error) bool {
+	return target == ErrNotFound && e.StatusCode == http.StatusNotFound
+}
+
+// newAPIError reads a Grafana error response body into an APIError,
+// masking any credentials it echoes back, ours included.
+func newAPIError(resp *http.Response, method, path string) *APIError {
+	apiErr := &APIError{StatusCode: resp.StatusCode, Method: method, Path: path}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	var payload struct {
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(body, &payload); err == nil && payload.Message != "" {
+		apiErr.Message = payload.Message
+	} else {
+		apiErr.Message = string(body)
+	}
+	apiErr.Message = redact.Text(apiErr.Message, redact.RequestSecrets(resp.Request)...)
+	return apiErr
+}
+
+// StatusCode returns the HTTP status of a Grafana API error, or 0.
+func StatusCode(err error) int {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode
+	}
+	return 0
+}
+
+// IsClientError reports whether Grafana answered and refused the request
+// (4xx other than 429), which says nothing about Grafana's health.
+func IsClientError(err error) bool {
+	status := StatusCode(err)
+	return status >= 400 && status < 500 && status != http.StatusTooManyRequests
+}
@@ -0,0 +1,135 @@
+// Package httpx builds tuned HTTP clients for the internal service APIs
+// (Mattermost, n8n) so each client doesn't carry its own transport setup.
+package httpx
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/tracing"
+)
+
+// Defaults applied to zero-valued Options fields.
+const (
+	DefaultTimeout               = 15 * time.Second
+	DefaultDialTimeout           = 5 * time.Second
+	DefaultTLSHandshakeTimeout   = 5 * time.Second
+	DefaultResponseHeaderTimeout = 10 * time.Second
+	DefaultMaxIdleConnsPerHost   = 16
+	DefaultIdleConnTimeout       = 90 * time.Second
+)
+
+// Options tunes the transport of an internal API client. Zero values use the
+// package defaults.
+type Options struct {
+	// Timeout bounds a whole request, including reading the body. Negative
+	// means no bound beyond the request's context.
+	Timeout               time.Duration
+	DialTimeout           time.Duration
+	TLSHandshakeTimeout   time.Duration
+	ResponseHeaderTimeout time.Duration
+	MaxIdleConnsPerHost   int
+	IdleConnTimeout       time.Duration
+
+	// RootCAs replaces the system roots, e.g. for an internal CA.
+	RootCAs *x509.CertPool
+	// InsecureSkipVerify disables certificate verification (dev only).
+	InsecureSkipVerify bool
+
+	// Record, when set, is called for every request with whether it was
+	// simulated.
+	Record func(req *http.Request, simulated bool)
+	// DryRun executes reads (GET, HEAD, OPTIONS) but answers every other
+	// request with a synthetic success instead of sending it. The response
+	// echoes a JSON object request body, or is {}, unless DryRunRespond is
+	// set. DryRunPassthrough marks writes that must still be sent, such as
+	// logins.
+	DryRun            bool
+	DryRunPassthrough func(*http.Request) bool
+	DryRunRespond     func(req *http.Request, body []byte) []byte
+
+	// TraceService, when set, times requests made within a trace as client
+	// spans named for it, and sends their traceparent.
+	TraceService string
+}
+
+// NewClient returns an http.Client with a dedicated, connection-reusing
+// transport configured from opts. Requests carry their context's request ID.
+func NewClient(opts Options) *http.Client {
+	var transport http.RoundTripper = NewTransport(opts)
+	if opts.Record != nil || opts.DryRun {
+		transport = newRecordingTransport(transport, opts)
+	}
+	if opts.TraceService != "" {
+		transport = tracing.Transport(opts.TraceService, transport)
+	}
+	timeout := orDuration(opts.Timeout, DefaultTimeout)
+	if opts.Timeout < 0 {
+		timeout = 0
+	}
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: PropagateRequestID(transport),
+	}
+}
+
+// NewTransport returns an http.Transport configured from opts.
+func NewTransport(opts Options) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   orDuration(opts.DialTimeout, DefaultDialTimeout),
+		KeepAlive: 30 * time.Second,
+	}
+	maxIdle := opts.MaxIdleConnsPerHost
+	if maxIdle <= 0 {
+		maxIdle = DefaultMaxIdleConnsPerHost
+	}
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          maxIdle * 4,
+		MaxIdleConnsPerHost:   maxIdle,
+		IdleConnTimeout:       orDuration(opts.IdleConnTimeout, DefaultIdleConnTimeout),
+		TLSHandshakeTimeout:   orDuration(opts.TLSHandshakeTimeout, DefaultTLSHandshakeTimeout),
+		ResponseHeaderTimeout: orDuration(opts.ResponseHeaderTimeout, DefaultResponseHeaderTimeout),
+		ExpectContinueTimeout: time.Second,
+	}
+	if opts.RootCAs != nil || opts.InsecureSkipVerify {
+		transport.TLSClientConfig = &tls.Config{
+			MinVersion:         tls.VersionTLS12,
+			RootCAs:            opts.RootCAs,
+			InsecureSkipVerify: opts.InsecureSkipVerify,
+		}
+	}
+	return transport
+}
+
+// LoadCertPool reads PEM certificates from path into a pool seeded with the
+// system roots.
+func LoadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read CA file: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.New("CA file contains no PEM certificates")
+	}
+	return pool, nil
+}
+
+func orDuration(d, fallback time.Duration) time.Duration {
+	if d > 0 {
+		return d
+	}
+	return fallback
+}
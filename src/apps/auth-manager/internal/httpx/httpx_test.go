@@ -0,0 +1,166 @@
+package httpx
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewTransport_Defaults(t *testing.T) {
+	transport := NewTransport(Options{})
+	if transport.MaxIdleConnsPerHost != DefaultMaxIdleConnsPerHost {
+		t.Errorf("MaxIdleConnsPerHost = %d, want %d", transport.MaxIdleConnsPerHost, DefaultMaxIdleConnsPerHost)
+	}
+	if transport.TLSHandshakeTimeout != DefaultTLSHandshakeTimeout {
+		t.Errorf("TLSHandshakeTimeout = %v, want %v", transport.TLSHandshakeTimeout, DefaultTLSHandshakeTimeout)
+	}
+	if transport.TLSClientConfig != nil {
+		t.Error("expected default TLS config")
+	}
+
+	client := NewClient(Options{Timeout: time.Second, ResponseHeaderTimeout: 2 * time.Second})
+	if client.Timeout != time.Second {
+		t.Errorf("Timeout = %v, want 1s", client.Timeout)
+	}
+	if got := client.Transport.(requestIDTransport).next.(*http.Transport).ResponseHeaderTimeout; got != 2*time.Second {
+		t.Errorf("ResponseHeaderTimeout = %v, want 2s", got)
+	}
+	if got := NewClient(Options{}).Timeout; got != DefaultTimeout {
+		t.Errorf("default Timeout = %v, want %v", got, DefaultTimeout)
+	}
+	if got := NewClient(Options{Timeout: -1}).Timeout; got != 0 {
+		t.Errorf("negative Timeout = %v, want none", got)
+	}
+}
+
+func TestNewClient_CustomRootCAs(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	// Without the test CA the handshake fails.
+	if _, err := NewClient(Options{}).Get(srv.URL); err == nil {
+		t.Fatal("expected certificate verification failure")
+	}
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	block := &pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	pool, err := LoadCertPool(caFile)
+	if err != nil {
+		t.Fatalf("LoadCertPool() error = %v", err)
+	}
+
+	resp, err := NewClient(Options{RootCAs: pool}).Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Get() with custom CA error = %v", err)
+	}
+	resp.Body.Close()
+
+	resp, err = NewClient(Options{InsecureSkipVerify: true}).Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Get() with InsecureSkipVerify error = %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestLoadCertPool_InvalidFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "empty.pem")
+	if err := os.WriteFile(path, []byte("not a cert"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadCertPool(path); err == nil {
+		t.Error("expected error for file without certificates")
+	}
+	if _, err := LoadCertPool(filepath.Join(t.TempDir(), "missing.pem")); err == nil {
+		t.Error("expected error for missing file")
+	}
+}
+
+func TestNewClient_DryRun(t *testing.T) {
+	var hits []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits = append(hits, r.Method+" "+r.URL.Path)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	var recorded []string
+	client := NewClient(Options{
+		DryRun:            true,
+		DryRunPassthrough: func(r *http.Request) bool { return r.URL.Path == "/login" },
+		Record: func(r *http.Request, simulated bool) {
+			recorded = append(recorded, fmt.Sprintf("%s %s %v", r.Method, r.URL.Path, simulated))
+		},
+	})
+
+	resp, err := client.Post(srv.URL+"/users", "application/json", strings.NewReader(`{"email":"a@example.com"}`))
+	if err != nil {
+		t.Fatalf("POST error = %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated || resp.Header.Get(SimulatedHeader) != "true" {
+		t.Errorf("simulated response = %d %v, want 201 with %s", resp.StatusCode, resp.Header, SimulatedHeader)
+	}
+	if string(body) != `{"email":"a@example.com"}` {
+		t.Errorf("simulated body = %s, want echoed request", body)
+	}
+
+	for _, do := range []func() (*http.Response, error){
+		func() (*http.Response, error) { return client.Get(srv.URL + "/users") },
+		func() (*http.Response, error) { return client.Post(srv.URL+"/login", "application/json", nil) },
+	} {
+		resp, err := do()
+		if err != nil {
+			t.Fatalf("request error = %v", err)
+		}
+		resp.Body.Close()
+	}
+
+	wantHits := []string{"GET /users", "POST /login"}
+	if !reflect.DeepEqual(hits, wantHits) {
+		t.Errorf("server hits = %v, want %v", hits, wantHits)
+	}
+	wantRecorded := []string{"POST /users true", "GET /users false", "POST /login false"}
+	if !reflect.DeepEqual(recorded, wantRecorded) {
+		t.Errorf("recorded = %v, want %v", recorded, wantRecorded)
+	}
+}
+
+func TestNewClient_PropagatesRequestID(t *testing.T) {
+	var got []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Header.Get(RequestIDHeader))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	client := NewClient(Options{})
+	for _, ctx := range []context.Context{WithRequestID(context.Background(), "req-1"), context.Background()} {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		resp.Body.Close()
+		if req.Header.Get(RequestIDHeader) != "" {
+			t.Error("transport modified the caller's request")
+		}
+	}
+	if len(got) != 2 || got[0] != "req-1" || got[1] != "" {
+		t.Errorf("request IDs sent = %q, want [req-1 \"\"]", got)
+	}
+}
@@ -0,0 +1,97 @@
+package httpx
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// SimulatedHeader is set to "true" on responses synthesized in dry-run mode.
+const SimulatedHeader = "X-Dry-Run"
+
+// recordingTransport reports every request to record and, in dry-run mode,
+// answers writes itself instead of sending them.
+type recordingTransport struct {
+	next        http.RoundTripper
+	dryRun      bool
+	passthrough func(*http.Request) bool
+	respond     func(*http.Request, []byte) []byte
+	record      func(req *http.Request, simulated bool)
+}
+
+func newRecordingTransport(next http.RoundTripper, opts Options) http.RoundTripper {
+	return &recordingTransport{
+		next:        next,
+		dryRun:      opts.DryRun,
+		passthrough: opts.DryRunPassthrough,
+		respond:     opts.DryRunRespond,
+		record:      opts.Record,
+	}
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.simulate(req) {
+		if t.record != nil {
+			t.record(req, false)
+		}
+		return t.next.RoundTrip(req)
+	}
+
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if t.record != nil {
+		t.record(req, true)
+	}
+
+	respBody := echoBody(body)
+	if t.respond != nil {
+		respBody = t.respond(req, body)
+	}
+	status := http.StatusOK
+	if req.Method == http.MethodPost {
+		status = http.StatusCreated
+	}
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set(SimulatedHeader, "true")
+	return &http.Response{
+		Status:        http.StatusText(status),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(respBody)),
+		ContentLength: int64(len(respBody)),
+		Request:       req,
+	}, nil
+}
+
+// simulate reports whether req is a write that dry-run mode must not send.
+func (t *recordingTransport) simulate(req *http.Request) bool {
+	if !t.dryRun {
+		return false
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	return t.passthrough == nil || !t.passthrough(req)
+}
+
+// echoBody returns a JSON object request body as the simulated response, so
+// "create" calls decode into something resembling what they asked for.
+func echoBody(body []byte) []byte {
+	if trimmed := bytes.TrimSpace(body); strings.HasPrefix(string(trimmed), "{") {
+		return trimmed
+	}
+	return []byte("{}")
+}
@@ -0,0 +1,48 @@
+package httpx
+
+import (
+	"context"
+	"net/http"
+)
+
+// RequestIDHeader carries a request's correlation ID, inbound and on the
+// downstream calls made for it.
+const RequestIDHeader = "X-Request-Id"
+
+type requestIDKey struct{}
+
+// WithRequestID returns ctx carrying id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the ID ctx carries, or "".
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// PropagateRequestID wraps next to send the request context's ID as
+// RequestIDHeader. NewClient applies it; clients built without NewClient can
+// wrap their own transport.
+func PropagateRequestID(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return requestIDTransport{next}
+}
+
+type requestIDTransport struct {
+	next http.RoundTripper
+}
+
+func (t requestIDTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	id := RequestID(req.Context())
+	if id == "" || req.Header.Get(RequestIDHeader) != "" {
+		return t.next.RoundTrip(req)
+	}
+	// RoundTrippers mustn't modify the caller's request.
+	req = req.Clone(req.Context())
+	req.Header.Set(RequestIDHeader, id)
+	return t.next.RoundTrip(req)
+}
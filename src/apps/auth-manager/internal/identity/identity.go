@@ -0,0 +1,177 @@
+// Package identity reads the user that the authenticating proxy in front of
+// auth-manager forwarded with a request.
+//
+// Each supported proxy is a Source with its own headers. FromRequest takes
+// the identity from the first trusted source that sent an email, and never
+// mixes fields from different sources.
+package identity
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/accounts"
+	"github.com/rave-org/rave/apps/auth-manager/internal/provision"
+)
+
+// Source is a proxy that forwards identity headers.
+type Source string
+
+const (
+	// Pomerium sends X-Pomerium-Claim-* headers when identity headers are
+	// passed through.
+	Pomerium Source = "pomerium"
+	// Authentik's proxy outpost sends X-Authentik-* headers.
+	Authentik Source = "authentik"
+	// Proxy covers oauth2-proxy style X-Auth-Request-* and X-Forwarded-*
+	// headers.
+	Proxy Source = "proxy"
+)
+
+// DefaultSources are the sources trusted when none are configured, in
+// precedence order. Pomerium isn't among them: where Authentik's outpost is
+// the proxy, nothing strips X-Pomerium-Claim-* headers a client sends, so
+// trusting them by default would let anyone pick their identity.
+var DefaultSources = []Source{Authentik, Proxy}
+
+// ErrNoIdentity is returned when no trusted source sent an identity.
+var ErrNoIdentity = errors.New("no identity headers from a trusted source")
+
+// sourceHeaders names the headers a source sends. Each field takes the first
+// non-empty header; groupSep splits the groups header.
+type sourceHeaders struct {
+	email, username, name, subject []string
+	groups                         string
+	groupSep                       string
+}
+
+var headers = map[Source]sourceHeaders{
+	Pomerium: {
+		email:    []string{"X-Pomerium-Claim-Email"},
+		username: []string{"X-Pomerium-Claim-Preferred-Username", "X-Pomerium-Claim-User"},
+		name:     []string{"X-Pomerium-Claim-Name"},
+		// Pomerium's subject is the upstream IdP's, not one shadow users
+		// are keyed on, so it isn't used.
+		groups:   "X-Pomerium-Claim-Groups",
+		groupSep: ",",
+	},
+	Authentik: {
+		email:    []string{"X-Authentik-Email"},
+		username: []string{"X-Authentik-Username"},
+		name:     []string{"X-Authentik-Name"},
+		subject:  []string{"X-Authentik-Uid"},
+		groups:   "X-Authentik-Groups",
+		groupSep: "|",
+	},
+	Proxy: {
+		email:    []string{"X-Auth-Request-Email", "X-Forwarded-Email"},
+		username: []string{"X-Auth-Request-User", "X-Forwarded-User", "Remote-User"},
+		name:     []string{"X-Auth-Request-Name", "X-Auth-Request-User", "X-Forwarded-User"},
+		groups:   "X-Auth-Request-Groups",
+		groupSep: ",",
+	},
+}
+
+// ParseSources parses trusted source names; none means DefaultSources.
+func ParseSources(names []string) ([]Source, error) {
+	if len(names) == 0 {
+		return DefaultSources, nil
+	}
+	sources := make([]Source, 0, len(names))
+	for _, name := range names {
+		source := Source(strings.ToLower(name))
+		if _, ok := headers[source]; !ok {
+			return nil, fmt.Errorf("unknown identity source %q (use %s, %s, or %s)", name, Pomerium, Authentik, Proxy)
+		}
+		for _, seen := range sources {
+			if seen == source {
+				return nil, fmt.Errorf("identity source %s listed twice", source)
+			}
+		}
+		sources = append(sources, source)
+	}
+	return sources, nil
+}
+
+// FromRequest returns the identity sent by the first of trusted, in order,
+// whose email header is set, with the email normalized. Groups is nil when
+// that source sent no groups header, so callers can tell "no groups" from
+// "unknown".
+func FromRequest(r *http.Request, trusted []Source) (provision.CanonicalIdentity, Source, error) {
+	for _, source := range trusted {
+		h, ok := headers[source]
+		if !ok {
+			continue
+		}
+		email := first(r, h.email)
+		if email == "" {
+			continue
+		}
+		return provision.CanonicalIdentity{
+			Subject:  first(r, h.subject),
+			Email:    accounts.NormalizeEmail(email),
+			Name:     first(r, h.name),
+			Username: first(r, h.username),
+			Groups:   splitGroups(r, h.groups, h.groupSep),
+		}, source, nil
+	}
+	return provision.CanonicalIdentity{}, "", ErrNoIdentity
+}
+
+// HasHeaders reports whether r carries identity headers from any source,
+// trusted or not.
+func HasHeaders(r *http.Request) bool {
+	for _, key := range headerNames() {
+		if _, ok := r.Header[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// headerNames lists every source's identity headers in canonical form. The
+// authentik-strip-identity middleware in the Traefik module and the README
+// blanks each of them.
+func headerNames() []string {
+	var names []string
+	for _, h := range headers {
+		for _, keys := range [][]string{h.email, h.username, h.name, h.subject, {h.groups}} {
+			for _, key := range keys {
+				if key = http.CanonicalHeaderKey(key); !slices.Contains(names, key) {
+					names = append(names, key)
+				}
+			}
+		}
+	}
+	return names
+}
+
+// splitGroups splits every value of the name header on sep, dropping blanks.
+// It returns nil when the header is absent.
+func splitGroups(r *http.Request, name, sep string) []string {
+	values, ok := r.Header[http.CanonicalHeaderKey(name)]
+	if !ok {
+		return nil
+	}
+	groups := []string{}
+	for _, value := range values {
+		for _, group := range strings.Split(value, sep) {
+			if group = strings.TrimSpace(group); group != "" {
+				groups = append(groups, group)
+			}
+		}
+	}
+	return groups
+}
+
+func first(r *http.Request, keys []string) string {
+	for _, key := range keys {
+		if value := strings.TrimSpace(r.Header.Get(key)); value != "" {
+			return value
+		}
+	}
+	return ""
+}
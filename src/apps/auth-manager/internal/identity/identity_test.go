@@ -0,0 +1,196 @@
+package identity
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"testing"
+)
+
+func TestFromRequest(t *testing.T) {
+	tests := []struct {
+		name       string
+		headers    map[string][]string
+		trusted    []Source
+		wantSource Source
+		wantEmail  string
+		wantGroups []string
+		wantErr    error
+	}{
+		{
+			name: "authentik groups split on pipes",
+			headers: map[string][]string{
+				"X-Authentik-Email":  {"dev@example.com"},
+				"X-Authentik-Uid":    {"uid-1"},
+				"X-Authentik-Groups": {"devs| ops |"},
+			},
+			trusted:    DefaultSources,
+			wantSource: Authentik,
+			wantEmail:  "dev@example.com",
+			wantGroups: []string{"devs", "ops"},
+		},
+		{
+			name:       "email normalized",
+			headers:    map[string][]string{"X-Authentik-Email": {"Jane.Doe@Bücher.Example"}},
+			trusted:    DefaultSources,
+			wantSource: Authentik,
+			wantEmail:  "jane.doe@xn--bcher-kva.example",
+		},
+		{
+			name: "pomerium groups split on commas",
+			headers: map[string][]string{
+				"X-Pomerium-Claim-Email":  {"dev@example.com"},
+				"X-Pomerium-Claim-Groups": {"devs,ops", "admins"},
+			},
+			trusted:    []Source{Pomerium},
+			wantSource: Pomerium,
+			wantEmail:  "dev@example.com",
+			wantGroups: []string{"devs", "ops", "admins"},
+		},
+		{
+			name: "pomerium takes precedence and fields aren't mixed",
+			headers: map[string][]string{
+				"X-Pomerium-Claim-Email": {"pomerium@example.com"},
+				"X-Authentik-Email":      {"authentik@example.com"},
+				"X-Authentik-Groups":     {"devs"},
+			},
+			trusted:    []Source{Pomerium, Authentik, Proxy},
+			wantSource: Pomerium,
+			wantEmail:  "pomerium@example.com",
+		},
+		{
+			name: "client-sent pomerium headers don't override authentik by default",
+			headers: map[string][]string{
+				"X-Pomerium-Claim-Email":  {"admin@example.com"},
+				"X-Pomerium-Claim-Groups": {"admins"},
+				"X-Authentik-Email":       {"dev@example.com"},
+				"X-Authentik-Groups":      {"devs"},
+			},
+			trusted:    DefaultSources,
+			wantSource: Authentik,
+			wantEmail:  "dev@example.com",
+			wantGroups: []string{"devs"},
+		},
+		{
+			name:    "pomerium isn't trusted by default",
+			headers: map[string][]string{"X-Pomerium-Claim-Email": {"admin@example.com"}},
+			trusted: DefaultSources,
+			wantErr: ErrNoIdentity,
+		},
+		{
+			name: "configured order sets precedence",
+			headers: map[string][]string{
+				"X-Pomerium-Claim-Email": {"pomerium@example.com"},
+				"X-Authentik-Email":      {"authentik@example.com"},
+			},
+			trusted:    []Source{Authentik, Pomerium},
+			wantSource: Authentik,
+			wantEmail:  "authentik@example.com",
+		},
+		{
+			name: "empty groups header means no groups",
+			headers: map[string][]string{
+				"X-Auth-Request-Email":  {"dev@example.com"},
+				"X-Auth-Request-Groups": {""},
+			},
+			trusted:    DefaultSources,
+			wantSource: Proxy,
+			wantEmail:  "dev@example.com",
+			wantGroups: []string{},
+		},
+		{
+			name: "untrusted source is ignored",
+			headers: map[string][]string{
+				"X-Forwarded-Email": {"dev@example.com"},
+			},
+			trusted: []Source{Authentik},
+			wantErr: ErrNoIdentity,
+		},
+		{
+			name:    "no headers",
+			trusted: DefaultSources,
+			wantErr: ErrNoIdentity,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/auth/mattermost", nil)
+			for key, values := range tt.headers {
+				for _, value := range values {
+					r.Header.Add(key, value)
+				}
+			}
+			ident, source, err := FromRequest(r, tt.trusted)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("FromRequest() error = %v, want %v", err, tt.wantErr)
+			}
+			if source != tt.wantSource || ident.Email != tt.wantEmail {
+				t.Errorf("FromRequest() = %q from %q, want %q from %q", ident.Email, source, tt.wantEmail, tt.wantSource)
+			}
+			if !reflect.DeepEqual(ident.Groups, tt.wantGroups) {
+				t.Errorf("groups = %#v, want %#v", ident.Groups, tt.wantGroups)
+			}
+		})
+	}
+}
+
+func TestParseSources(t *testing.T) {
+	if got, err := ParseSources(nil); err != nil || !reflect.DeepEqual(got, DefaultSources) {
+		t.Errorf("ParseSources(nil) = %v, %v; want the defaults", got, err)
+	}
+	if got, err := ParseSources([]string{"Authentik"}); err != nil || !reflect.DeepEqual(got, []Source{Authentik}) {
+		t.Errorf("ParseSources([Authentik]) = %v, %v", got, err)
+	}
+	for _, names := range [][]string{{"traefik"}, {"authentik", "authentik"}} {
+		if _, err := ParseSources(names); err == nil {
+			t.Errorf("ParseSources(%v): expected an error", names)
+		}
+	}
+}
+
+// TestStripMiddlewaresInSync checks that the Traefik module's and the
+// README's authentik-strip-identity middlewares blank the same headers, and
+// every header HasHeaders looks for among them.
+func TestStripMiddlewaresInSync(t *testing.T) {
+	stripped := func(path string, block, entry *regexp.Regexp) []string {
+		t.Helper()
+		data, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			t.Skipf("%s not in this checkout", path)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := block.FindSubmatch(data)
+		if m == nil {
+			t.Fatalf("%s: no authentik-strip-identity middleware", path)
+		}
+		var names []string
+		for _, e := range entry.FindAllSubmatch(m[1], -1) {
+			names = append(names, http.CanonicalHeaderKey(string(e[1])))
+		}
+		sort.Strings(names)
+		return names
+	}
+	nix := stripped(filepath.Join("..", "..", "..", "..", "..", "infra", "nixos", "modules", "services", "traefik", "default.nix"),
+		regexp.MustCompile(`(?s)"authentik-strip-identity" = \{\s*headers\.customRequestHeaders = \{(.*?)\};`),
+		regexp.MustCompile(`"([A-Za-z-]+)" = "";`))
+	readme := stripped(filepath.Join("..", "..", "README.md"),
+		regexp.MustCompile(`(?s)authentik-strip-identity:\s*headers:\s*customRequestHeaders:\n(.*?)\n\n`),
+		regexp.MustCompile(`(?m)^\s+([A-Za-z-]+): ""$`))
+
+	if !reflect.DeepEqual(nix, readme) {
+		t.Errorf("README strips %v, Traefik module strips %v", readme, nix)
+	}
+	for _, name := range headerNames() {
+		if !slices.Contains(nix, name) {
+			t.Errorf("Traefik module doesn't strip %s", name)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package mattermost
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Bot is the subset of Mattermost bot fields we care about.
+type Bot struct {
+	UserID      string `json:"user_id"`
+	Username    string `json:"username"`
+	DisplayName string `json:"display_name"`
+	Description string `json:"description"`
+	OwnerID     string `json:"owner_id"`
+	DeleteAt    int64  `json:"delete_at"`
+}
+
+// UserAccessToken is a personal access token. Token is only populated in the
+// response that creates it.
+type UserAccessToken struct {
+	ID          string `json:"id"`
+	Token       string `json:"token,omitempty"`
+	UserID      string `json:"user_id"`
+	Description string `json:"description"`
+}
+
+// ErrNotABot is returned by EnsureBot when the username belongs to a regular
+// Mattermost user.
+var ErrNotABot = errors.New("mattermost username belongs to a non-bot user")
+
+// EnsureBot returns the bot with the given username, creating it if needed.
+// The username is normalized the same way as regular users'.
+func (c *Client) EnsureBot(ctx context.Context, username, displayName, description string) (bot Bot, created bool, err error) {
+	username = deriveUsername(Identity{User: username})
+
+	bot, err = c.getBotByUsername(ctx, username)
+	if err == nil {
+		return bot, false, nil
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return Bot{}, false, err
+	}
+
+	bot, err = c.CreateBot(ctx, username, displayName, description)
+	if HasErrorID(err, errIDUsernameExists) {
+		// Lost a race with another create; use the winner's bot.
+		bot, err = c.getBotByUsername(ctx, username)
+		return bot, false, err
+	}
+	if err != nil {
+		return Bot{}, false, err
+	}
+	return bot, true, nil
+}
+
+// CreateBot creates a bot account owned by the admin token's user.
+func (c *Client) CreateBot(ctx context.Context, username, displayName, description string) (Bot, error) {
+	payload := map[string]string{
+		"username":     username,
+		"display_name": displayName,
+		"description":  description,
+	}
+	var bot Bot
+	if err := c.do(ctx, http.MethodPost, "/api/v4/bots", payload, &bot); err != nil {
+		return Bot{}, err
+	}
+	return bot, nil
+}
+
+// GetBot fetches a bot by its user ID, including disabled bots.
+func (c *Client) GetBot(ctx context.Context, userID string) (Bot, error) {
+	path := fmt.Sprintf("/api/v4/bots/%s?include_deleted=true", url.PathEscape(userID))
+	var bot Bot
+	if err := c.do(ctx, http.MethodGet, path, nil, &bot); err != nil {
+		return Bot{}, err
+	}
+	return bot, nil
+}
+
+// CreateUserAccessToken issues a personal access token for the user or bot.
+func (c *Client) CreateUserAccessToken(ctx context.Context, userID, description string) (UserAccessToken, error) {
+	path := fmt.Sprintf("/api/v4/users/%s/tokens", url.PathEscape(userID))
+	var token UserAccessToken
+	if err := c.do(ctx, http.MethodPost, path, map[string]string{"description": description}, &token); err != nil {
+		return UserAccessToken{}, err
+	}
+	return token, nil
+}
+
+// GetUserByUsername looks up a Mattermost user by username, returning
+// ErrNotFound when absent.
+func (c *Client) GetUserByUsername(ctx context.Context, username string) (User, error) {
+	path := fmt.Sprintf("/api/v4/users/username/%s", url.PathEscape(strings.ToLower(username)))
+	var user User
+	if err := c.do(ctx, http.MethodGet, path, nil, &user); err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
+
+func (c *Client) getBotByUsername(ctx context.Context, username string) (Bot, error) {
+	user, err := c.GetUserByUsername(ctx, username)
+	if err != nil {
+		return Bot{}, err
+	}
+	if !user.IsBot {
+		return Bot{}, fmt.Errorf("%w: %s", ErrNotABot, username)
+	}
+	return c.GetBot(ctx, user.ID)
+}
@@ -3,19 +3,25 @@ package mattermost
 import (
 	"bytes"
 	"context"
-	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/accounts"
+	"github.com/rave-org/rave/apps/auth-manager/internal/httpx"
 )
 
 var (
-	// ErrNotFound is returned when Mattermost signals a 404 for the requested resource.
+	// ErrNotFound matches (via errors.Is) Mattermost 404s for the requested resource.
 	ErrNotFound = errors.New("mattermost resource not found")
 )
 
@@ -24,6 +30,9 @@ type Identity struct {
 	Email string
 	Name  string
 	User  string
+	// ID is a previously recorded Mattermost user ID. When set, EnsureUser
+	// looks the user up by ID first so email changes can be synced.
+	ID string
 }
 
 // User represents the subset of Mattermost user fields we care about.
@@ -33,62 +42,319 @@ type User struct {
 	Email     string `json:"email"`
 	FirstName string `json:"first_name"`
 	LastName  string `json:"last_name"`
+	Roles     string `json:"roles"` // Space-separated system roles
+	IsBot     bool   `json:"is_bot"`
 	CreateAt  int64  `json:"create_at"`
 	UpdateAt  int64  `json:"update_at"`
+	DeleteAt  int64  `json:"delete_at"` // Nonzero once deactivated
+}
+
+// Team is the subset of Mattermost team fields we care about.
+type Team struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+}
+
+// Channel is the subset of Mattermost channel fields we care about.
+type Channel struct {
+	ID     string `json:"id"`
+	TeamID string `json:"team_id"`
+	Name   string `json:"name"`
 }
 
 // Session mirrors the JSON payload returned by POST /users/{id}/sessions.
 type Session struct {
-	ID        string `json:"id"`
-	Token     string `json:"token"`
-	UserID    string `json:"user_id"`
-	CreateAt  int64  `json:"create_at"`
-	ExpiresAt int64  `json:"expires_at"`
-	DeviceID  string `json:"device_id"`
+	ID        string            `json:"id"`
+	Token     string            `json:"token"`
+	UserID    string            `json:"user_id"`
+	CreateAt  int64             `json:"create_at"`
+	ExpiresAt int64             `json:"expires_at"`
+	DeviceID  string            `json:"device_id"`
+	Props     map[string]string `json:"props,omitempty"`
 }
 
 // Client is a minimal Mattermost REST API client focused on user/session flows.
 type Client struct {
-	baseURL    string
-	token      string
-	httpClient *http.Client
+	baseURL     string
+	token       string
+	httpClient  *http.Client
+	logger      *slog.Logger
+	profileSync bool
+	onRetry     func(method, reason string)
+	metrics     Metrics
 }
 
+// ClientOptions tunes the client's HTTP transport.
+type ClientOptions = httpx.Options
+
 // NewClient creates a client against the given Mattermost base URL (host:port, no trailing slash).
 func NewClient(baseURL, token string) *Client {
+	return NewClientWithOptions(baseURL, token, ClientOptions{})
+}
+
+// NewClientWithOptions is NewClient with a tuned HTTP transport. opts.Timeout
+// is ignored: calls end with their context, or after maxRetryBudget when it
+// has no deadline, so a forward-auth request's short budget isn't outlived.
+func NewClientWithOptions(baseURL, token string, opts ClientOptions) *Client {
+	opts.Timeout = -1
 	trimmed := strings.TrimRight(baseURL, "/")
 	return &Client{
-		baseURL: trimmed,
-		token:   token,
-		httpClient: &http.Client{
-			Timeout: 15 * time.Second,
-		},
+		baseURL:     trimmed,
+		token:       token,
+		httpClient:  httpx.NewClient(opts),
+		logger:      slog.Default(),
+		profileSync: true,
 	}
 }
 
+// SetProfileSync controls whether EnsureUser patches existing users whose
+// name, username, or email differ from the incoming identity. It is on by
+// default.
+func (c *Client) SetProfileSync(enabled bool) {
+	c.profileSync = enabled
+}
+
 // EnsureUser guarantees a local Mattermost user exists for the provided identity.
-func (c *Client) EnsureUser(ctx context.Context, ident Identity) (User, error) {
+// created reports whether the user had to be created by this call.
+func (c *Client) EnsureUser(ctx context.Context, ident Identity) (user User, created bool, err error) {
 	if ident.Email == "" {
-		return User{}, errors.New("identity email required")
+		return User{}, false, errors.New("identity email required")
 	}
+	ident.Email = accounts.NormalizeEmail(ident.Email)
 
-	user, err := c.getUserByEmail(ctx, ident.Email)
+	err = ErrNotFound
+	if ident.ID != "" {
+		user, err = c.GetUser(ctx, ident.ID)
+	}
+	if errors.Is(err, ErrNotFound) {
+		user, err = c.GetUserByEmail(ctx, ident.Email)
+	}
 	if err == nil {
-		return user, nil
+		if c.profileSync {
+			// A failed profile update shouldn't block the login.
+			if patched, patchErr := c.syncProfile(ctx, user, ident); patchErr != nil {
+				c.logger.Warn("mattermost profile sync failed", "user_id", user.ID, "err", patchErr)
+			} else {
+				user = patched
+			}
+		}
+		return user, false, nil
 	}
 	if !errors.Is(err, ErrNotFound) {
+		return User{}, false, err
+	}
+
+	user, err = c.createUser(ctx, ident)
+	if HasErrorID(err, errIDEmailExists) {
+		// Someone else created the user between our lookup and create
+		// (e.g. concurrent logins); use theirs.
+		if existing, lookupErr := c.GetUserByEmail(ctx, ident.Email); lookupErr == nil {
+			return existing, false, nil
+		}
+	}
+	if err != nil {
+		return User{}, false, err
+	}
+	return user, true, nil
+}
+
+// syncProfile patches the user's name, username, and email to match the
+// identity. Empty identity fields are left alone. A username that is already
+// taken keeps the old username rather than failing the login.
+func (c *Client) syncProfile(ctx context.Context, user User, ident Identity) (User, error) {
+	patch := UserPatch{}
+	if ident.Name != "" {
+		first, last := accounts.SplitName(ident.Name)
+		if first != user.FirstName {
+			patch.FirstName = &first
+		}
+		if last != user.LastName {
+			patch.LastName = &last
+		}
+	}
+	if ident.User != "" {
+		// A suffix given on a collision is kept: patching back to the
+		// taken name would fail on every login.
+		if base := deriveUsername(ident); !isUsernameFor(user.Username, base) {
+			username := firstUsername(base)
+			patch.Username = &username
+		}
+	}
+	if accounts.NormalizeEmail(user.Email) != accounts.NormalizeEmail(ident.Email) {
+		patch.Email = &ident.Email
+	}
+	if patch.empty() {
+		return user, nil
+	}
+
+	updated, err := c.PatchUser(ctx, user.ID, patch)
+	if err != nil && patch.Username != nil && HasErrorID(err, errIDUsernameExists) {
+		c.logger.Warn("mattermost username already taken, keeping existing username",
+			"user_id", user.ID, "username", user.Username, "wanted", *patch.Username)
+		patch.Username = nil
+		if patch.empty() {
+			return user, nil
+		}
+		updated, err = c.PatchUser(ctx, user.ID, patch)
+	}
+	if err != nil {
+		return User{}, err
+	}
+	return updated, nil
+}
+
+// UserPatch lists the profile fields to change; nil fields are left as is.
+type UserPatch struct {
+	Email     *string `json:"email,omitempty"`
+	Username  *string `json:"username,omitempty"`
+	FirstName *string `json:"first_name,omitempty"`
+	LastName  *string `json:"last_name,omitempty"`
+}
+
+func (p UserPatch) empty() bool {
+	return p.Email == nil && p.Username == nil && p.FirstName == nil && p.LastName == nil
+}
+
+// PatchUser partially updates a user's profile.
+func (c *Client) PatchUser(ctx context.Context, userID string, patch UserPatch) (User, error) {
+	path := fmt.Sprintf("/api/v4/users/%s/patch", url.PathEscape(userID))
+	var user User
+	if err := c.do(ctx, http.MethodPut, path, patch, &user); err != nil {
 		return User{}, err
 	}
+	return user, nil
+}
 
-	return c.createUser(ctx, ident)
+// UpdateUserRoles replaces the user's system roles with the space-separated
+// roles string, e.g. "system_admin system_user".
+func (c *Client) UpdateUserRoles(ctx context.Context, userID, roles string) error {
+	path := fmt.Sprintf("/api/v4/users/%s/roles", url.PathEscape(userID))
+	payload := map[string]string{"roles": roles}
+	return c.do(ctx, http.MethodPut, path, payload, nil)
+}
+
+// IsGuest reports whether the user has Mattermost's guest role.
+func (u User) IsGuest() bool {
+	for _, role := range strings.Fields(u.Roles) {
+		if role == "system_guest" {
+			return true
+		}
+	}
+	return false
+}
+
+// DemoteToGuest converts a regular user to a guest. Mattermost removes them
+// from channels guests can't see; guest accounts must be enabled.
+func (c *Client) DemoteToGuest(ctx context.Context, userID string) error {
+	path := fmt.Sprintf("/api/v4/users/%s/demote", url.PathEscape(userID))
+	return c.do(ctx, http.MethodPost, path, nil, nil)
+}
+
+// PromoteToUser converts a guest to a regular user.
+func (c *Client) PromoteToUser(ctx context.Context, userID string) error {
+	path := fmt.Sprintf("/api/v4/users/%s/promote", url.PathEscape(userID))
+	return c.do(ctx, http.MethodPost, path, nil, nil)
+}
+
+// DeactivateUser deactivates the user, which also revokes their sessions.
+func (c *Client) DeactivateUser(ctx context.Context, userID string) error {
+	return c.setActive(ctx, userID, false)
+}
+
+// ReactivateUser re-enables a previously deactivated user.
+func (c *Client) ReactivateUser(ctx context.Context, userID string) error {
+	return c.setActive(ctx, userID, true)
+}
+
+func (c *Client) setActive(ctx context.Context, userID string, active bool) error {
+	path := fmt.Sprintf("/api/v4/users/%s/active", url.PathEscape(userID))
+	payload := map[string]bool{"active": active}
+	return c.do(ctx, http.MethodPut, path, payload, nil)
+}
+
+// GetTeamByName looks up a team by its URL name.
+func (c *Client) GetTeamByName(ctx context.Context, name string) (Team, error) {
+	path := fmt.Sprintf("/api/v4/teams/name/%s", url.PathEscape(name))
+	var team Team
+	if err := c.do(ctx, http.MethodGet, path, nil, &team); err != nil {
+		return Team{}, err
+	}
+	return team, nil
+}
+
+// GetChannelByName looks up a channel by its URL name within a team.
+func (c *Client) GetChannelByName(ctx context.Context, teamID, name string) (Channel, error) {
+	path := fmt.Sprintf("/api/v4/teams/%s/channels/name/%s", url.PathEscape(teamID), url.PathEscape(name))
+	var channel Channel
+	if err := c.do(ctx, http.MethodGet, path, nil, &channel); err != nil {
+		return Channel{}, err
+	}
+	return channel, nil
+}
+
+// AddUserToTeam adds the user to a team. Existing memberships are not an error.
+func (c *Client) AddUserToTeam(ctx context.Context, teamID, userID string) error {
+	path := fmt.Sprintf("/api/v4/teams/%s/members", url.PathEscape(teamID))
+	payload := map[string]string{"team_id": teamID, "user_id": userID}
+	return ignoreAlreadyMember(c.do(ctx, http.MethodPost, path, payload, nil))
+}
+
+// ListTeamsForUser returns the teams the user is a member of.
+func (c *Client) ListTeamsForUser(ctx context.Context, userID string) ([]Team, error) {
+	path := fmt.Sprintf("/api/v4/users/%s/teams", url.PathEscape(userID))
+	var teams []Team
+	if err := c.do(ctx, http.MethodGet, path, nil, &teams); err != nil {
+		return nil, err
+	}
+	return teams, nil
+}
+
+// RemoveUserFromTeam removes the user from a team, and with it from the
+// team's channels.
+func (c *Client) RemoveUserFromTeam(ctx context.Context, teamID, userID string) error {
+	path := fmt.Sprintf("/api/v4/teams/%s/members/%s", url.PathEscape(teamID), url.PathEscape(userID))
+	return c.do(ctx, http.MethodDelete, path, nil, nil)
+}
+
+// AddUserToChannel adds the user to a channel. Existing memberships are not an error.
+func (c *Client) AddUserToChannel(ctx context.Context, channelID, userID string) error {
+	path := fmt.Sprintf("/api/v4/channels/%s/members", url.PathEscape(channelID))
+	payload := map[string]string{"user_id": userID}
+	return ignoreAlreadyMember(c.do(ctx, http.MethodPost, path, payload, nil))
+}
+
+// ignoreAlreadyMember swallows Mattermost's "member already exists" errors.
+func ignoreAlreadyMember(err error) error {
+	if HasErrorID(err, errIDTeamMemberExists, errIDChanMemberExists) {
+		return nil
+	}
+	return err
+}
+
+// SessionOptions controls the sessions CreateSession mints.
+type SessionOptions struct {
+	// TTL sets the session expiry; zero inherits the Mattermost server default.
+	TTL time.Duration
+	// DeviceID tags the session so admins can tell where it came from.
+	DeviceID string
+	// Props are stored on the session verbatim.
+	Props map[string]string
 }
 
 // CreateSession creates a Mattermost session for the given user ID.
-func (c *Client) CreateSession(ctx context.Context, userID string) (Session, error) {
+func (c *Client) CreateSession(ctx context.Context, userID string, opts SessionOptions) (Session, error) {
 	path := fmt.Sprintf("/api/v4/users/%s/sessions", url.PathEscape(userID))
+	var expiresAt int64
+	if opts.TTL > 0 {
+		expiresAt = time.Now().Add(opts.TTL).UnixMilli()
+	}
 	payload := map[string]any{
-		"device_id":  "",
-		"expires_at": 0,
+		"device_id":  opts.DeviceID,
+		"expires_at": expiresAt,
+	}
+	if len(opts.Props) > 0 {
+		payload["props"] = opts.Props
 	}
 	var session Session
 	if err := c.do(ctx, http.MethodPost, path, payload, &session); err != nil {
@@ -97,7 +363,79 @@ func (c *Client) CreateSession(ctx context.Context, userID string) (Session, err
 	return session, nil
 }
 
-func (c *Client) getUserByEmail(ctx context.Context, email string) (User, error) {
+// ListSessions returns all active sessions for the given user ID.
+func (c *Client) ListSessions(ctx context.Context, userID string) ([]Session, error) {
+	path := fmt.Sprintf("/api/v4/users/%s/sessions", url.PathEscape(userID))
+	var sessions []Session
+	if err := c.do(ctx, http.MethodGet, path, nil, &sessions); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// RevokeSession revokes a single session belonging to the given user ID.
+func (c *Client) RevokeSession(ctx context.Context, userID, sessionID string) error {
+	path := fmt.Sprintf("/api/v4/users/%s/sessions/revoke", url.PathEscape(userID))
+	return c.do(ctx, http.MethodPost, path, map[string]string{"session_id": sessionID}, nil)
+}
+
+// RevokeSessionByToken revokes the session token belongs to by logging it
+// out as its user, since Mattermost only shows admins session IDs, not
+// tokens. Mattermost answers OK for a token that is already invalid.
+func (c *Client) RevokeSessionByToken(ctx context.Context, token string) error {
+	return c.doAs(ctx, token, http.MethodPost, "/api/v4/users/logout", nil, nil)
+}
+
+// RevokeAllSessions lists and revokes every session for the given user ID,
+// returning the number of sessions revoked. It stops at the first failure.
+func (c *Client) RevokeAllSessions(ctx context.Context, userID string) (int, error) {
+	sessions, err := c.ListSessions(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+	revoked := 0
+	for _, session := range sessions {
+		if err := c.RevokeSession(ctx, userID, session.ID); err != nil {
+			return revoked, fmt.Errorf("revoke session %s: %w", session.ID, err)
+		}
+		revoked++
+	}
+	return revoked, nil
+}
+
+// PostToChannel posts a message to the given channel as the admin/bot token owner.
+func (c *Client) PostToChannel(ctx context.Context, channelID, message string) error {
+	payload := map[string]string{
+		"channel_id": channelID,
+		"message":    message,
+	}
+	return c.do(ctx, http.MethodPost, "/api/v4/posts", payload, nil)
+}
+
+// Ping checks that Mattermost is reachable and that the admin token is still
+// accepted. An IsUnauthorized error means the server is up but the token is bad.
+func (c *Client) Ping(ctx context.Context) error {
+	if err := c.do(ctx, http.MethodGet, "/api/v4/system/ping", nil, nil); err != nil {
+		return fmt.Errorf("ping: %w", err)
+	}
+	if err := c.do(ctx, http.MethodGet, "/api/v4/users/me", nil, nil); err != nil {
+		return fmt.Errorf("validate token: %w", err)
+	}
+	return nil
+}
+
+// GetUser fetches a user by Mattermost user ID.
+func (c *Client) GetUser(ctx context.Context, userID string) (User, error) {
+	path := fmt.Sprintf("/api/v4/users/%s", url.PathEscape(userID))
+	var user User
+	if err := c.do(ctx, http.MethodGet, path, nil, &user); err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
+
+// GetUserByEmail looks up a Mattermost user by email, returning ErrNotFound when absent.
+func (c *Client) GetUserByEmail(ctx context.Context, email string) (User, error) {
 	path := fmt.Sprintf("/api/v4/users/email/%s", url.PathEscape(email))
 	var user User
 	if err := c.do(ctx, http.MethodGet, path, nil, &user); err != nil {
@@ -106,57 +444,113 @@ func (c *Client) getUserByEmail(ctx context.Context, email string) (User, error)
 	return user, nil
 }
 
+// createUser creates the user with the first free candidate username: the
+// derived one, then with -2, -3, and so on while Mattermost reports the
+// previous one taken, up to maxUsernameAttempts.
 func (c *Client) createUser(ctx context.Context, ident Identity) (User, error) {
-	username := deriveUsername(ident)
-	first, last := splitName(ident.Name)
+	base := deriveUsername(ident)
+	first, last := accounts.SplitName(ident.Name)
+	password, err := accounts.RandomPassword(24, accounts.Alphanumeric)
+	if err != nil {
+		return User{}, fmt.Errorf("generate mattermost password: %w", err)
+	}
 	payload := map[string]any{
 		"email":           ident.Email,
-		"username":        username,
 		"first_name":      first,
 		"last_name":       last,
-		"password":        randomPassword(),
+		"password":        password,
 		"allow_marketing": false,
 		"locale":          "en",
 		"email_verified":  true,
 	}
-	var user User
-	if err := c.do(ctx, http.MethodPost, "/api/v4/users", payload, &user); err != nil {
-		return User{}, err
+	for n := 1; n <= maxUsernameAttempts; n++ {
+		username := usernameCandidate(base, n)
+		if reservedUsernames[username] {
+			continue
+		}
+		payload["username"] = username
+		var user User
+		err = c.do(ctx, http.MethodPost, "/api/v4/users", payload, &user)
+		if HasErrorID(err, errIDUsernameExists) {
+			c.logger.Info("mattermost username taken, trying the next", "email", ident.Email, "username", username)
+			continue
+		}
+		if err != nil {
+			return User{}, err
+		}
+		return user, nil
 	}
-	return user, nil
+	return User{}, fmt.Errorf("no free mattermost username for %s after %d attempts: %w", base, maxUsernameAttempts, err)
 }
 
 func (c *Client) do(ctx context.Context, method, path string, body any, dest any) error {
+	return c.doAs(ctx, c.token, method, path, body, dest)
+}
+
+// doAs is do, authenticated with token instead of the admin token.
+func (c *Client) doAs(ctx context.Context, token, method, path string, body any, dest any) (err error) {
+	start := time.Now()
+	defer func() { c.observe(method, path, start, err) }()
+
 	fullURL := c.baseURL + path
-	var reader io.Reader
+	var payload []byte
 	if body != nil {
 		buf, err := json.Marshal(body)
 		if err != nil {
 			return err
 		}
-		reader = bytes.NewReader(buf)
+		payload = buf
 	}
 
-	req, err := http.NewRequestWithContext(ctx, method, fullURL, reader)
-	if err != nil {
-		return err
+	// Bound the whole retry loop, not just each attempt.
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, maxRetryBudget)
+		defer cancel()
 	}
-	req.Header.Set("Authorization", "Bearer "+c.token)
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-Requested-With", "XMLHttpRequest")
 
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
+	for attempt := 1; ; attempt++ {
+		var reader io.Reader
+		if payload != nil {
+			reader = bytes.NewReader(payload)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, fullURL, reader)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Requested-With", "XMLHttpRequest")
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			if ctx.Err() != nil || attempt >= maxAttempts || !retryableConnError(method, err) || !c.wait(ctx, method, "connection", retryDelay("", attempt)) {
+				return err
+			}
+			continue
+		}
+
+		if reason, ok := retryableStatus(method, resp.StatusCode); ok && attempt < maxAttempts {
+			delay := retryDelay(resp.Header.Get("Retry-After"), attempt)
+			apiErr := newAPIError(resp, method, path)
+			resp.Body.Close()
+			if c.wait(ctx, method, reason, delay) {
+				continue
+			}
+			return apiErr
+		}
+
+		err = decodeResponse(resp, method, path, dest)
+		resp.Body.Close()
 		return err
 	}
-	defer resp.Body.Close()
+}
 
-	if resp.StatusCode == http.StatusNotFound {
-		return ErrNotFound
-	}
+// decodeResponse decodes a successful response into dest, or returns an
+// *APIError for any non-2xx status.
+func decodeResponse(resp *http.Response, method, path string, dest any) error {
 	if resp.StatusCode >= 400 {
-		errBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
-		return fmt.Errorf("mattermost %s %s failed: %s", method, path, strings.TrimSpace(string(errBody)))
+		return newAPIError(resp, method, path)
 	}
 
 	if dest != nil {
@@ -167,6 +561,55 @@ func (c *Client) do(ctx context.Context, method, path string, body any, dest any
 	return nil
 }
 
+// maxUsernameLen is the longest username Mattermost accepts.
+const maxUsernameLen = 22
+
+// maxUsernameAttempts bounds the candidate usernames createUser tries.
+const maxUsernameAttempts = 10
+
+// reservedUsernames are usernames Mattermost keeps for itself.
+var reservedUsernames = map[string]bool{
+	"admin": true, "all": true, "channel": true, "here": true, "matterbot": true, "system": true,
+}
+
+// usernameCandidate returns the nth username to try for base: base itself,
+// then base-2, base-3, and so on, with base shortened to fit the suffix.
+func usernameCandidate(base string, n int) string {
+	if n == 1 {
+		return base
+	}
+	suffix := "-" + strconv.Itoa(n)
+	if len(base) > maxUsernameLen-len(suffix) {
+		base = strings.TrimRight(base[:maxUsernameLen-len(suffix)], "-._")
+	}
+	return base + suffix
+}
+
+// firstUsername returns base's first candidate that isn't reserved.
+func firstUsername(base string) string {
+	n := 1
+	for reservedUsernames[usernameCandidate(base, n)] {
+		n++
+	}
+	return usernameCandidate(base, n)
+}
+
+// isUsernameFor reports whether username is one of the candidates
+// createUser tries for base.
+func isUsernameFor(username, base string) bool {
+	for n := 1; n <= maxUsernameAttempts; n++ {
+		if username == usernameCandidate(base, n) {
+			return true
+		}
+	}
+	return false
+}
+
+// deriveUsername returns the username a user gets in Mattermost: their
+// username or the email's local part, lowercased, with the characters
+// Mattermost doesn't allow replaced, and truncated to maxUsernameLen. When
+// nothing is left, as for an all-Cyrillic name, it falls back to a name
+// hashed from the identity, so the same user always derives the same one.
 func deriveUsername(ident Identity) string {
 	candidate := ident.User
 	if candidate == "" && ident.Email != "" {
@@ -184,34 +627,11 @@ func deriveUsername(ident Identity) string {
 	}, cleaned)
 	cleaned = strings.Trim(cleaned, "-._")
 	if cleaned == "" {
-		cleaned = fmt.Sprintf("shadow-%d", time.Now().Unix())
+		sum := sha256.Sum256([]byte(ident.Email + "\x00" + candidate))
+		cleaned = "shadow-" + hex.EncodeToString(sum[:4])
 	}
-	if len(cleaned) > 22 {
-		cleaned = cleaned[:22]
+	if len(cleaned) > maxUsernameLen {
+		cleaned = strings.TrimRight(cleaned[:maxUsernameLen], "-._")
 	}
 	return cleaned
 }
-
-func splitName(full string) (string, string) {
-	trimmed := strings.TrimSpace(full)
-	if trimmed == "" {
-		return "", ""
-	}
-	parts := strings.Fields(trimmed)
-	if len(parts) == 1 {
-		return parts[0], ""
-	}
-	return parts[0], strings.Join(parts[1:], " ")
-}
-
-func randomPassword() string {
-	const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	buf := make([]byte, 24)
-	if _, err := rand.Read(buf); err != nil {
-		return "ChangeMe123!"
-	}
-	for i := range buf {
-		buf[i] = letters[int(buf[i])%len(letters)]
-	}
-	return string(buf)
-}
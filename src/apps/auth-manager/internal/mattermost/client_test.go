@@ -0,0 +1,650 @@
+package mattermost
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/mattermost/mattermosttest"
+)
+
+func TestDo_RetriesIdempotentOnGatewayErrors(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(User{ID: "u1", Email: "a@example.com"})
+	}))
+	defer srv.Close()
+
+	var retries []string
+	c := NewClient(srv.URL, "token")
+	c.SetRetryHook(func(method, reason string) { retries = append(retries, method+" "+reason) })
+
+	user, err := c.GetUserByEmail(context.Background(), "a@example.com")
+	if err != nil {
+		t.Fatalf("GetUserByEmail() error = %v", err)
+	}
+	if user.ID != "u1" {
+		t.Errorf("GetUserByEmail() = %+v, want ID u1", user)
+	}
+	if got := calls.Load(); got != 3 {
+		t.Errorf("expected 3 attempts, got %d", got)
+	}
+	if len(retries) != 2 || retries[0] != "GET 502" {
+		t.Errorf("unexpected retry hook calls: %v", retries)
+	}
+}
+
+func TestDo_DoesNotRetryPostOnGatewayErrors(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL, "token")
+	if _, err := c.CreateSession(context.Background(), "u1", SessionOptions{}); err == nil {
+		t.Fatal("expected error")
+	}
+	if got := calls.Load(); got != 1 {
+		t.Errorf("expected 1 attempt, got %d", got)
+	}
+}
+
+func TestDo_RetriesPostOnlyWhenNothingWasSent(t *testing.T) {
+	// The connection drops after Mattermost has read the request.
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL, "token")
+	if _, err := c.CreateSession(context.Background(), "u1", SessionOptions{}); err == nil {
+		t.Fatal("expected error")
+	}
+	if got := calls.Load(); got != 1 {
+		t.Errorf("POST after a dropped connection: %d attempts, want 1", got)
+	}
+	calls.Store(0)
+	if _, err := c.GetUser(context.Background(), "u1"); err == nil {
+		t.Fatal("expected error")
+	}
+	if got := calls.Load(); got != maxAttempts {
+		t.Errorf("GET after a dropped connection: %d attempts, want %d", got, maxAttempts)
+	}
+
+	// Nothing listens, so the POST never left.
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	var retries []string
+	c = NewClient(closed.URL, "token")
+	c.SetRetryHook(func(method, reason string) { retries = append(retries, method+" "+reason) })
+	if _, err := c.CreateSession(context.Background(), "u1", SessionOptions{}); err == nil {
+		t.Fatal("expected error")
+	}
+	if want := []string{"POST connection", "POST connection"}; !reflect.DeepEqual(retries, want) {
+		t.Errorf("retries after failing to connect = %v, want %v", retries, want)
+	}
+}
+
+func TestDo_RetriesPostOnRateLimit(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(Session{ID: "s1", Token: "tok"})
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL, "token")
+	session, err := c.CreateSession(context.Background(), "u1", SessionOptions{})
+	if err != nil {
+		t.Fatalf("CreateSession() error = %v", err)
+	}
+	if session.ID != "s1" {
+		t.Errorf("CreateSession() = %+v, want ID s1", session)
+	}
+	if got := calls.Load(); got != 2 {
+		t.Errorf("expected 2 attempts, got %d", got)
+	}
+}
+
+func TestDo_GivesUpWhenRetryExceedsDeadline(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Retry-After", "5")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	c := NewClient(srv.URL, "token")
+	if _, err := c.GetUser(ctx, "u1"); err == nil {
+		t.Fatal("expected error")
+	}
+	if got := calls.Load(); got != 1 {
+		t.Errorf("expected 1 attempt, got %d", got)
+	}
+}
+
+func TestDo_RespectsCallerContext(t *testing.T) {
+	if c := NewClientWithOptions("http://mattermost.invalid", "token", ClientOptions{Timeout: time.Second}); c.httpClient.Timeout != 0 {
+		t.Errorf("http client Timeout = %v, want none: calls end with their context", c.httpClient.Timeout)
+	}
+
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := NewClient(srv.URL, "token").GetUser(ctx, "u1")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("GetUser() error = %v, want the context's deadline", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("GetUser() took %v after a 100ms deadline", elapsed)
+	}
+}
+
+func TestAPIError_FromResponse(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"id":          "api.context.session_expired.app_error",
+			"message":     "Invalid or expired session, please login again.",
+			"status_code": 401,
+		})
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL, "bad-token")
+	_, err := c.GetUser(context.Background(), "u1")
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("expected *APIError, got %T: %v", err, err)
+	}
+	if apiErr.StatusCode != http.StatusUnauthorized || apiErr.ID != "api.context.session_expired.app_error" || apiErr.Path != "/api/v4/users/u1" {
+		t.Errorf("unexpected APIError: %+v", apiErr)
+	}
+	if !IsUnauthorized(err) {
+		t.Error("expected IsUnauthorized() to return true")
+	}
+	if IsConflict(err) || errors.Is(err, ErrNotFound) {
+		t.Error("expected 401 to be neither a conflict nor not found")
+	}
+}
+
+func TestAPIError_NotFound(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	c := NewClient(srv.URL, "token")
+	if _, err := c.GetUser(context.Background(), "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestIsClientError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{err: &APIError{StatusCode: http.StatusBadRequest}, want: true},
+		{err: fmt.Errorf("ensure: %w", &APIError{StatusCode: http.StatusForbidden}), want: true},
+		{err: ErrNotFound, want: true},
+		{err: &APIError{StatusCode: http.StatusTooManyRequests}, want: false},
+		{err: &APIError{StatusCode: http.StatusBadGateway}, want: false},
+		{err: context.DeadlineExceeded, want: false},
+		{err: errors.New("connection refused"), want: false},
+	}
+	for _, tt := range tests {
+		if got := IsClientError(tt.err); got != tt.want {
+			t.Errorf("IsClientError(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestEnsureUser_EmailConflictRetriesLookup(t *testing.T) {
+	var lookups atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v4/users/email/race@example.com":
+			if lookups.Add(1) == 1 {
+				http.NotFound(w, r)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(User{ID: "u-race", Email: "race@example.com", Username: "race"})
+		case r.Method == http.MethodPost && r.URL.Path == "/api/v4/users":
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]any{"id": "app.user.save.email_exists.app_error", "message": "An account with that email already exists."})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL, "token")
+	user, created, err := c.EnsureUser(context.Background(), Identity{Email: "race@example.com", User: "race"})
+	if err != nil {
+		t.Fatalf("EnsureUser() error = %v", err)
+	}
+	if created || user.ID != "u-race" {
+		t.Errorf("EnsureUser() = %+v, created %v; want existing u-race", user, created)
+	}
+}
+
+func TestCreateSession_Options(t *testing.T) {
+	var payload map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v4/users/u1/sessions" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+		_ = json.NewEncoder(w).Encode(Session{ID: "s1"})
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL, "token")
+	before := time.Now().Add(time.Hour).UnixMilli()
+	if _, err := c.CreateSession(context.Background(), "u1", SessionOptions{
+		TTL:      time.Hour,
+		DeviceID: "rave-sso:web",
+		Props:    map[string]string{"rave_source": "forward-auth"},
+	}); err != nil {
+		t.Fatalf("CreateSession() error = %v", err)
+	}
+	after := time.Now().Add(time.Hour).UnixMilli()
+
+	if got := payload["device_id"]; got != "rave-sso:web" {
+		t.Errorf("device_id = %v, want rave-sso:web", got)
+	}
+	expiresAt, _ := payload["expires_at"].(float64)
+	if int64(expiresAt) < before || int64(expiresAt) > after {
+		t.Errorf("expires_at = %v, want now+1h in ms", payload["expires_at"])
+	}
+	props, _ := payload["props"].(map[string]any)
+	if props["rave_source"] != "forward-auth" {
+		t.Errorf("props = %v, want rave_source=forward-auth", payload["props"])
+	}
+}
+
+func TestCreateSession_DefaultsInheritServerExpiry(t *testing.T) {
+	var payload map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+		_ = json.NewEncoder(w).Encode(Session{ID: "s1"})
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL, "token")
+	if _, err := c.CreateSession(context.Background(), "u1", SessionOptions{}); err != nil {
+		t.Fatalf("CreateSession() error = %v", err)
+	}
+	if payload["expires_at"] != float64(0) {
+		t.Errorf("expires_at = %v, want 0", payload["expires_at"])
+	}
+	if _, ok := payload["props"]; ok {
+		t.Errorf("expected no props, got %v", payload["props"])
+	}
+}
+
+func TestEnsureUser(t *testing.T) {
+	tests := []struct {
+		name        string
+		seed        []mattermosttest.User
+		ident       Identity
+		wantCreated bool
+		wantErr     bool
+		wantUser    string
+	}{
+		{
+			name:     "existing user",
+			seed:     []mattermosttest.User{{ID: "u1", Email: "a@example.com", Username: "alice"}},
+			ident:    Identity{Email: "a@example.com", User: "alice"},
+			wantUser: "alice",
+		},
+		{
+			name:        "missing user is created",
+			ident:       Identity{Email: "b@example.com", User: "Bob", Name: "Bob Builder"},
+			wantCreated: true,
+			wantUser:    "bob",
+		},
+		{
+			name:        "username conflict on create",
+			seed:        []mattermosttest.User{{ID: "u1", Email: "other@example.com", Username: "carol"}},
+			ident:       Identity{Email: "c@example.com", User: "carol"},
+			wantCreated: true,
+			wantUser:    "carol-2",
+		},
+		{
+			name:        "truncated emails collide",
+			seed:        []mattermosttest.User{{ID: "u1", Email: "jonathan.smithington.jr@example.com", Username: "jonathan.smithington.j"}},
+			ident:       Identity{Email: "jonathan.smithington.jnr@example.com"},
+			wantCreated: true,
+			wantUser:    "jonathan.smithington-2",
+		},
+		{
+			name:        "reserved username",
+			ident:       Identity{Email: "admin@example.com"},
+			wantCreated: true,
+			wantUser:    "admin-2",
+		},
+		{
+			name:        "multi-byte email",
+			ident:       Identity{Email: "jörg.müller@example.com"},
+			wantCreated: true,
+			wantUser:    "j-rg.m-ller",
+		},
+		{
+			name:    "missing email",
+			ident:   Identity{User: "nobody"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := mattermosttest.NewServer(t)
+			for _, u := range tt.seed {
+				fake.AddUser(u)
+			}
+			c := NewClient(fake.URL, "token")
+
+			user, created, err := c.EnsureUser(context.Background(), tt.ident)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("EnsureUser() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if created != tt.wantCreated {
+				t.Errorf("EnsureUser() created = %v, want %v", created, tt.wantCreated)
+			}
+			if user.Username != tt.wantUser {
+				t.Errorf("EnsureUser() username = %q, want %q", user.Username, tt.wantUser)
+			}
+			if _, ok := fake.UserByEmail(tt.ident.Email); !ok {
+				t.Errorf("expected %s to exist in Mattermost", tt.ident.Email)
+			}
+		})
+	}
+}
+
+func TestEnsureUser_CreatePayload(t *testing.T) {
+	fake := mattermosttest.NewServer(t)
+	c := NewClient(fake.URL, "token")
+
+	if _, _, err := c.EnsureUser(context.Background(), Identity{Email: "d@example.com", User: "dana", Name: "Dana Q Public"}); err != nil {
+		t.Fatalf("EnsureUser() error = %v", err)
+	}
+	user, _ := fake.UserByEmail("d@example.com")
+	if user.FirstName != "Dana" || user.LastName != "Q Public" {
+		t.Errorf("name = %q %q, want Dana / Q Public", user.FirstName, user.LastName)
+	}
+	if got := fake.Count(http.MethodPost, "/api/v4/users"); got != 1 {
+		t.Errorf("expected 1 create request, got %d", got)
+	}
+}
+
+func TestEnsureBot(t *testing.T) {
+	fake := mattermosttest.NewServer(t)
+	fake.AddUser(mattermosttest.User{ID: "u1", Email: "svc-human@example.com", Username: "svc-human"})
+	c := NewClient(fake.URL, "token")
+	ctx := context.Background()
+
+	bot, created, err := c.EnsureBot(ctx, "SVC-Deploy", "Deploy Bot", "deployments")
+	if err != nil {
+		t.Fatalf("EnsureBot() error = %v", err)
+	}
+	if !created || bot.Username != "svc-deploy" || bot.UserID == "" {
+		t.Errorf("EnsureBot() = %+v, created %v; want new svc-deploy bot", bot, created)
+	}
+
+	again, created, err := c.EnsureBot(ctx, "svc-deploy", "Deploy Bot", "deployments")
+	if err != nil {
+		t.Fatalf("EnsureBot() second call error = %v", err)
+	}
+	if created || again.UserID != bot.UserID {
+		t.Errorf("EnsureBot() second call = %+v, created %v; want existing %s", again, created, bot.UserID)
+	}
+
+	if _, _, err := c.EnsureBot(ctx, "svc-human", "", ""); !errors.Is(err, ErrNotABot) {
+		t.Errorf("EnsureBot() on regular user error = %v, want ErrNotABot", err)
+	}
+}
+
+func TestCreateSession_Fake(t *testing.T) {
+	fake := mattermosttest.NewServer(t)
+	user := fake.AddUser(mattermosttest.User{Email: "e@example.com", Username: "erin"})
+	c := NewClient(fake.URL, "token")
+
+	session, err := c.CreateSession(context.Background(), user.ID, SessionOptions{DeviceID: "rave-sso:web"})
+	if err != nil {
+		t.Fatalf("CreateSession() error = %v", err)
+	}
+	if session.Token == "" || session.UserID != user.ID {
+		t.Errorf("CreateSession() = %+v", session)
+	}
+	stored := fake.Sessions(user.ID)
+	if len(stored) != 1 || stored[0].DeviceID != "rave-sso:web" {
+		t.Errorf("stored sessions = %+v", stored)
+	}
+
+	if _, err := c.CreateSession(context.Background(), "missing", SessionOptions{}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("CreateSession(missing) error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestRevokeSessionByToken(t *testing.T) {
+	fake := mattermosttest.NewServer(t)
+	user := fake.AddUser(mattermosttest.User{Email: "e@example.com", Username: "erin"})
+	keep := fake.AddSession(user.ID, mattermosttest.Session{})
+	drop := fake.AddSession(user.ID, mattermosttest.Session{})
+	c := NewClient(fake.URL, "admin-token")
+
+	if err := c.RevokeSessionByToken(context.Background(), drop.Token); err != nil {
+		t.Fatalf("RevokeSessionByToken() error = %v", err)
+	}
+	if stored := fake.Sessions(user.ID); len(stored) != 1 || stored[0].ID != keep.ID {
+		t.Errorf("sessions after revoke = %+v, want only %s", stored, keep.ID)
+	}
+	// Already revoked.
+	if err := c.RevokeSessionByToken(context.Background(), drop.Token); err != nil {
+		t.Errorf("RevokeSessionByToken(revoked) error = %v", err)
+	}
+}
+
+func TestErrorPropagation(t *testing.T) {
+	fake := mattermosttest.NewServer(t)
+	fake.Error(http.MethodGet, "/api/v4/users/email/f@example.com", http.StatusForbidden, "api.context.permissions.app_error")
+	c := NewClient(fake.URL, "token")
+
+	_, _, err := c.EnsureUser(context.Background(), Identity{Email: "f@example.com"})
+	if !IsUnauthorized(err) {
+		t.Errorf("expected IsUnauthorized, got %v", err)
+	}
+	if !HasErrorID(err, "api.context.permissions.app_error") {
+		t.Errorf("expected permissions error ID, got %v", err)
+	}
+	if got := fake.Count(http.MethodPost, "/api/v4/users"); got != 0 {
+		t.Errorf("expected no create after lookup failure, got %d", got)
+	}
+}
+
+func TestDeriveUsername(t *testing.T) {
+	tests := []struct {
+		name  string
+		ident Identity
+		want  string
+	}{
+		{"username", Identity{User: "Alice"}, "alice"},
+		{"email local part", Identity{Email: "bob.smith@example.com"}, "bob.smith"},
+		{"invalid characters", Identity{User: "carol+ops@x"}, "carol-ops-x"},
+		{"unicode", Identity{User: "zoë"}, "zo"},
+		{"trimmed separators", Identity{User: "__dave__"}, "dave"},
+		{"truncated", Identity{User: "abcdefghijklmnopqrstuvwxyz"}, "abcdefghijklmnopqrstuv"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := deriveUsername(tt.ident); got != tt.want {
+				t.Errorf("deriveUsername() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	for _, ident := range []Identity{{}, {User: "ßßß"}} {
+		if got := deriveUsername(ident); !strings.HasPrefix(got, "shadow-") {
+			t.Errorf("deriveUsername(%+v) = %q, want shadow- fallback", ident, got)
+		}
+	}
+	// The fallback is stable for a user, so profile sync doesn't flap.
+	a, b := Identity{Email: "山田@example.com"}, Identity{Email: "田中@example.com"}
+	if deriveUsername(a) != deriveUsername(a) || deriveUsername(a) == deriveUsername(b) {
+		t.Errorf("fallbacks: %q, %q, %q", deriveUsername(a), deriveUsername(a), deriveUsername(b))
+	}
+}
+
+func TestEnsureUser_UsernamesExhausted(t *testing.T) {
+	fake := mattermosttest.NewServer(t)
+	fake.AddUser(mattermosttest.User{Email: "x@example.com", Username: "dana"})
+	for n := 2; n <= maxUsernameAttempts; n++ {
+		fake.AddUser(mattermosttest.User{Email: fmt.Sprintf("x%d@example.com", n), Username: fmt.Sprintf("dana-%d", n)})
+	}
+	c := NewClient(fake.URL, "token")
+
+	_, _, err := c.EnsureUser(context.Background(), Identity{Email: "dana@example.com"})
+	if err == nil || !HasErrorID(err, errIDUsernameExists) {
+		t.Fatalf("EnsureUser() error = %v, want the username conflict", err)
+	}
+	if got := fake.Count(http.MethodPost, "/api/v4/users"); got != maxUsernameAttempts {
+		t.Errorf("tried %d usernames, want %d", got, maxUsernameAttempts)
+	}
+}
+
+func TestEnsureUser_KeepsSuffixedUsername(t *testing.T) {
+	fake := mattermosttest.NewServer(t)
+	fake.AddUser(mattermosttest.User{ID: "u1", Email: "carol@example.com", Username: "carol"})
+	fake.AddUser(mattermosttest.User{ID: "u2", Email: "carol2@example.com", Username: "carol-2"})
+	c := NewClient(fake.URL, "token")
+
+	user, _, err := c.EnsureUser(context.Background(), Identity{Email: "carol2@example.com", User: "carol"})
+	if err != nil || user.Username != "carol-2" {
+		t.Fatalf("EnsureUser() = %+v, %v; want carol-2 kept", user, err)
+	}
+	if got := fake.Count(http.MethodPut, "/api/v4/users/u2/patch"); got != 0 {
+		t.Errorf("patched the suffixed username %d times", got)
+	}
+
+	// A new username elsewhere is still synced.
+	user, _, err = c.EnsureUser(context.Background(), Identity{Email: "carol2@example.com", User: "caroline"})
+	if err != nil || user.Username != "caroline" {
+		t.Errorf("EnsureUser() after a rename = %+v, %v", user, err)
+	}
+}
+
+func TestTeamMemberships(t *testing.T) {
+	fake := mattermosttest.NewServer(t)
+	user := fake.AddUser(mattermosttest.User{Email: "dev@example.com", Username: "dev"})
+	apollo, gemini := fake.AddTeam("apollo"), fake.AddTeam("gemini")
+	fake.AddTeamMember(apollo.ID, user.ID)
+	fake.AddTeamMember(gemini.ID, user.ID)
+	c := NewClient(fake.URL, "token")
+	ctx := context.Background()
+
+	teams, err := c.ListTeamsForUser(ctx, user.ID)
+	if err != nil || len(teams) != 2 || teams[0].Name != "apollo" || teams[1].ID != gemini.ID {
+		t.Fatalf("ListTeamsForUser() = %+v, %v", teams, err)
+	}
+	if err := c.RemoveUserFromTeam(ctx, gemini.ID, user.ID); err != nil {
+		t.Fatalf("RemoveUserFromTeam() error = %v", err)
+	}
+	if teams, err := c.ListTeamsForUser(ctx, user.ID); err != nil || len(teams) != 1 || teams[0].ID != apollo.ID {
+		t.Errorf("ListTeamsForUser() after removal = %+v, %v", teams, err)
+	}
+	if err := c.RemoveUserFromTeam(ctx, gemini.ID, user.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("removing a non-member: err = %v, want ErrNotFound", err)
+	}
+}
+
+type recordingMetrics struct {
+	mu  sync.Mutex
+	ops []string
+}
+
+func (m *recordingMetrics) ObserveRequest(operation, outcome string, _ time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ops = append(m.ops, operation+" "+outcome)
+}
+
+func TestClientMetrics(t *testing.T) {
+	fake := mattermosttest.NewServer(t)
+	fake.AddUser(mattermosttest.User{ID: "u1", Email: "a@example.com", Username: "alice"})
+	c := NewClient(fake.URL, "token")
+	metrics := &recordingMetrics{}
+	c.SetMetrics(metrics)
+
+	ctx := context.Background()
+	_, _ = c.GetUserByEmail(ctx, "a@example.com")
+	_, _ = c.GetUser(ctx, "missing")
+
+	want := []string{"GET /users/email/:id ok", "GET /users/:id client_error"}
+	if !reflect.DeepEqual(metrics.ops, want) {
+		t.Errorf("observed = %v, want %v", metrics.ops, want)
+	}
+
+	// A client without metrics stays usable.
+	c.SetMetrics(nil)
+	if _, err := c.GetUser(ctx, "u1"); err != nil {
+		t.Errorf("GetUser() error = %v", err)
+	}
+}
+
+func TestOperationName(t *testing.T) {
+	tests := []struct {
+		method, path, want string
+	}{
+		{"POST", "/api/v4/users", "POST /users"},
+		{"PUT", "/api/v4/users/abc123/patch", "PUT /users/:id/patch"},
+		{"GET", "/api/v4/teams/t1/channels/name/town-square", "GET /teams/:id/channels/name/:id"},
+		{"GET", "/api/v4/bots/b1?include_deleted=true", "GET /bots/:id"},
+		{"GET", "/api/v4/users/me", "GET /users/me"},
+	}
+	for _, tt := range tests {
+		if got := operationName(tt.method, tt.path); got != tt.want {
+			t.Errorf("operationName(%q, %q) = %q, want %q", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package mattermost
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/redact"
+)
+
+// Mattermost error IDs we act on.
+const (
+	errIDEmailExists      = "app.user.save.email_exists.app_error"
+	errIDUsernameExists   = "app.user.save.username_exists.app_error"
+	errIDTeamMemberExists = "store.sql_team.save_member.exists.app_error"
+	errIDChanMemberExists = "store.sql_channel.save_member.exists.app_error"
+)
+
+// APIError is a non-2xx response from the Mattermost API. ID and Message come
+// from Mattermost's error JSON ({"id": ..., "message": ...}) when present.
+type APIError struct {
+	StatusCode int
+	ID         string
+	Message    string
+	Method     string
+	Path       string
+}
+
+func (e *APIError) Error() string {
+	detail := e.Message
+	if e.ID != "" {
+		detail = e.ID + ": " + e.Message
+	}
+	return fmt.Sprintf("mattermost %s %s failed (%d): %s", e.Method, e.Path, e.StatusCode, strings.TrimSpace(detail))
+}
+
+// Is lets errors.Is(err, ErrNotFound) keep matching 404 responses.
+func (e *APIError) Is(target error) bool {
+	return target == ErrNotFound && e.StatusCode == http.StatusNotFound
+}
+
+// newAPIError reads a Mattermost error response body into an APIError,
+// masking any credentials it echoes back, ours included.
+func newAPIError(resp *http.Response, method, path string) *APIError {
+	apiErr := &APIError{StatusCode: resp.StatusCode, Method: method, Path: path}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	var payload struct {
+		ID      string `json:"id"`
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(body, &payload); err == nil && (payload.ID != "" || payload.Message != "") {
+		apiErr.ID, apiErr.Message = payload.ID, payload.Message
+	} else {
+		apiErr.Message = string(body)
+	}
+	apiErr.Message = redact.Text(apiErr.Message, redact.RequestSecrets(resp.Request)...)
+	return apiErr
+}
+
+// StatusCode returns the HTTP status of a Mattermost API error, or 0.
+func StatusCode(err error) int {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode
+	}
+	return 0
+}
+
+// HasErrorID reports whether err is a Mattermost API error with one of ids.
+func HasErrorID(err error, ids ...string) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	for _, id := range ids {
+		if apiErr.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// IsUnauthorized reports whether Mattermost rejected our credentials: 401 for
+// an invalid or expired token, 403 for a token lacking permissions.
+func IsUnauthorized(err error) bool {
+	code := StatusCode(err)
+	return code == http.StatusUnauthorized || code == http.StatusForbidden
+}
+
+// IsClientError reports whether Mattermost answered and refused the request
+// (4xx other than 429, or ErrNotFound), as opposed to being unreachable or
+// failing. A refusal usually means a bad payload, such as an invalid
+// username, and says nothing about Mattermost's health.
+func IsClientError(err error) bool {
+	if errors.Is(err, ErrNotFound) {
+		return true
+	}
+	status := StatusCode(err)
+	return status >= 400 && status < 500 && status != http.StatusTooManyRequests
+}
+
+// IsConflict reports whether the request collided with existing state, such
+// as an email or username already in use. Mattermost reports most of these as
+// 400 with an *_exists error ID rather than 409.
+func IsConflict(err error) bool {
+	if StatusCode(err) == http.StatusConflict {
+		return true
+	}
+	return HasErrorID(err, errIDEmailExists, errIDUsernameExists, errIDTeamMemberExists, errIDChanMemberExists)
+}
@@ -0,0 +1,679 @@
+// Package mattermosttest provides an in-memory fake of the Mattermost REST
+// endpoints the auth-manager uses, for tests. It deliberately does not import
+// the mattermost package so that package's own tests can use it.
+package mattermosttest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// User is a Mattermost user as stored by the fake.
+type User struct {
+	ID        string `json:"id"`
+	Username  string `json:"username"`
+	Email     string `json:"email"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Roles     string `json:"roles"`
+	IsBot     bool   `json:"is_bot"`
+	CreateAt  int64  `json:"create_at"`
+	UpdateAt  int64  `json:"update_at"`
+	DeleteAt  int64  `json:"delete_at"`
+}
+
+// Bot is a Mattermost bot as stored by the fake. Each bot also has a User
+// with IsBot set.
+type Bot struct {
+	UserID      string `json:"user_id"`
+	Username    string `json:"username"`
+	DisplayName string `json:"display_name"`
+	Description string `json:"description"`
+	OwnerID     string `json:"owner_id"`
+}
+
+// Token is a personal access token issued by the fake.
+type Token struct {
+	ID          string `json:"id"`
+	Token       string `json:"token,omitempty"`
+	UserID      string `json:"user_id"`
+	Description string `json:"description"`
+}
+
+// Session is a Mattermost session as stored by the fake.
+type Session struct {
+	ID        string            `json:"id"`
+	Token     string            `json:"token"`
+	UserID    string            `json:"user_id"`
+	CreateAt  int64             `json:"create_at"`
+	ExpiresAt int64             `json:"expires_at"`
+	DeviceID  string            `json:"device_id"`
+	Props     map[string]string `json:"props,omitempty"`
+}
+
+// Team is a Mattermost team as stored by the fake.
+type Team struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+}
+
+// Channel is a Mattermost channel as stored by the fake.
+type Channel struct {
+	ID     string `json:"id"`
+	TeamID string `json:"team_id"`
+	Name   string `json:"name"`
+}
+
+// Post is a message posted through the fake.
+type Post struct {
+	ChannelID string `json:"channel_id"`
+	Message   string `json:"message"`
+}
+
+// Request records a call made against the fake.
+type Request struct {
+	Method string
+	Path   string
+	Body   []byte
+}
+
+// Server is a fake Mattermost API. Its zero state has no users; seed it with
+// AddUser, AddTeam, AddChannel, and AddSession, and program failures with
+// Error or Handle.
+type Server struct {
+	*httptest.Server
+
+	mu             sync.Mutex
+	nextID         int
+	users          map[string]*User
+	sessions       map[string][]Session
+	bots           map[string]Bot
+	tokens         map[string][]Token
+	teams          map[string]Team
+	channels       map[string]Channel
+	teamMembers    map[string]map[string]bool
+	channelMembers map[string]map[string]bool
+	posts          []Post
+	requests       []Request
+	overrides      map[string]http.HandlerFunc
+}
+
+// NewServer starts a fake Mattermost server that is closed when the test ends.
+func NewServer(t testing.TB) *Server {
+	s := &Server{
+		users:          map[string]*User{},
+		sessions:       map[string][]Session{},
+		bots:           map[string]Bot{},
+		tokens:         map[string][]Token{},
+		teams:          map[string]Team{},
+		channels:       map[string]Channel{},
+		teamMembers:    map[string]map[string]bool{},
+		channelMembers: map[string]map[string]bool{},
+		overrides:      map[string]http.HandlerFunc{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// AddUser stores a user, assigning an ID when empty, and returns it.
+func (s *Server) AddUser(u User) User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if u.ID == "" {
+		u.ID = s.newIDLocked("user")
+	}
+	if u.Roles == "" {
+		u.Roles = "system_user"
+	}
+	stored := u
+	s.users[u.ID] = &stored
+	return u
+}
+
+// User returns the stored user with the given ID.
+func (s *Server) User(id string) (User, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[id]
+	if !ok {
+		return User{}, false
+	}
+	return *u, true
+}
+
+// UserByEmail returns the stored user with the given email.
+func (s *Server) UserByEmail(email string) (User, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if u := s.userByEmailLocked(email); u != nil {
+		return *u, true
+	}
+	return User{}, false
+}
+
+// Bot returns the stored bot with the given user ID.
+func (s *Server) Bot(userID string) (Bot, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bot, ok := s.bots[userID]
+	return bot, ok
+}
+
+// Tokens returns the access tokens issued to the user, without their secrets.
+func (s *Server) Tokens(userID string) []Token {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tokens := make([]Token, 0, len(s.tokens[userID]))
+	for _, token := range s.tokens[userID] {
+		token.Token = ""
+		tokens = append(tokens, token)
+	}
+	return tokens
+}
+
+// AddTeam stores a team with the given name and returns it.
+func (s *Server) AddTeam(name string) Team {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	team := Team{ID: s.newIDLocked("team"), Name: name, DisplayName: name}
+	s.teams[team.ID] = team
+	return team
+}
+
+// AddChannel stores a channel in the team and returns it.
+func (s *Server) AddChannel(teamID, name string) Channel {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	channel := Channel{ID: s.newIDLocked("channel"), TeamID: teamID, Name: name}
+	s.channels[channel.ID] = channel
+	return channel
+}
+
+// AddSession stores a session for the user, assigning an ID and token when empty.
+func (s *Server) AddSession(userID string, session Session) Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.addSessionLocked(userID, session)
+}
+
+// Sessions returns the user's live sessions.
+func (s *Server) Sessions(userID string) []Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Session(nil), s.sessions[userID]...)
+}
+
+// TeamMembers returns the sorted user IDs on the team.
+func (s *Server) TeamMembers(teamID string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return sortedKeys(s.teamMembers[teamID])
+}
+
+// AddTeamMember puts the user on the team.
+func (s *Server) AddTeamMember(teamID, userID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.teamMembers[teamID] == nil {
+		s.teamMembers[teamID] = map[string]bool{}
+	}
+	s.teamMembers[teamID][userID] = true
+}
+
+// ChannelMembers returns the sorted user IDs in the channel.
+func (s *Server) ChannelMembers(channelID string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return sortedKeys(s.channelMembers[channelID])
+}
+
+// Posts returns every message posted so far.
+func (s *Server) Posts() []Post {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Post(nil), s.posts...)
+}
+
+// Requests returns every request received so far.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// Count returns how many requests matched method and path.
+func (s *Server) Count(method, path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, req := range s.requests {
+		if req.Method == method && req.Path == path {
+			n++
+		}
+	}
+	return n
+}
+
+// Handle overrides the fake's behavior for an exact method and path.
+func (s *Server) Handle(method, path string, h http.HandlerFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.overrides[method+" "+path] = h
+}
+
+// Error makes method and path fail with a Mattermost-style error body.
+func (s *Server) Error(method, path string, status int, id string) {
+	s.Handle(method, path, func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, status, id)
+	})
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	s.mu.Lock()
+	s.requests = append(s.requests, Request{Method: r.Method, Path: r.URL.Path, Body: body})
+	override := s.overrides[r.Method+" "+r.URL.Path]
+	s.mu.Unlock()
+
+	if override != nil {
+		override(w, r)
+		return
+	}
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+		writeError(w, http.StatusUnauthorized, "api.context.session_expired.app_error")
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v4/"), "/")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r.Method == http.MethodPost && match(parts, "users", "logout") {
+		// Logs out the caller's own session, like Mattermost, which also
+		// answers OK for tokens it doesn't know.
+		s.logoutLocked(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]string{"status": "OK"})
+		return
+	}
+	status, resp := s.route(r.Method, parts, body)
+	if status >= 400 {
+		id, _ := resp.(string)
+		writeError(w, status, id)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// route dispatches a request with s.mu held. Errors return the status and
+// the Mattermost error ID as resp.
+func (s *Server) route(method string, parts []string, body []byte) (int, any) {
+	switch {
+	case method == http.MethodPost && match(parts, "users"):
+		return s.createUser(body)
+	case method == http.MethodGet && match(parts, "users", "email", "*"):
+		if u := s.userByEmailLocked(parts[2]); u != nil {
+			return http.StatusOK, u
+		}
+		return http.StatusNotFound, "app.user.missing_account.const"
+	case method == http.MethodGet && match(parts, "system", "ping"):
+		return http.StatusOK, map[string]string{"status": "OK"}
+	case method == http.MethodGet && match(parts, "users", "me"):
+		return http.StatusOK, User{ID: "admin", Username: "admin", Roles: "system_admin system_user"}
+	case method == http.MethodGet && match(parts, "users", "username", "*"):
+		if u := s.userByUsernameLocked(parts[2]); u != nil {
+			return http.StatusOK, u
+		}
+		return http.StatusNotFound, "app.user.missing_account.const"
+	case method == http.MethodGet && match(parts, "users", "*"):
+		if u, ok := s.users[parts[1]]; ok {
+			return http.StatusOK, u
+		}
+		return http.StatusNotFound, "app.user.missing_account.const"
+	case method == http.MethodPut && match(parts, "users", "*", "patch"):
+		return s.patchUser(parts[1], body)
+	case method == http.MethodPut && match(parts, "users", "*", "roles"):
+		return s.updateRoles(parts[1], body)
+	case method == http.MethodPut && match(parts, "users", "*", "active"):
+		return s.setActive(parts[1], body)
+	case method == http.MethodPost && match(parts, "users", "*", "sessions"):
+		return s.createSession(parts[1], body)
+	case method == http.MethodGet && match(parts, "users", "*", "teams"):
+		if _, ok := s.users[parts[1]]; !ok {
+			return http.StatusNotFound, "app.user.missing_account.const"
+		}
+		teams := []Team{}
+		for id, members := range s.teamMembers {
+			if members[parts[1]] {
+				teams = append(teams, s.teams[id])
+			}
+		}
+		sort.Slice(teams, func(i, j int) bool { return teams[i].Name < teams[j].Name })
+		return http.StatusOK, teams
+	case method == http.MethodGet && match(parts, "users", "*", "sessions"):
+		if _, ok := s.users[parts[1]]; !ok {
+			return http.StatusNotFound, "app.user.missing_account.const"
+		}
+		return http.StatusOK, append([]Session{}, s.sessions[parts[1]]...)
+	case method == http.MethodPost && match(parts, "users", "*", "sessions", "revoke"):
+		return s.revokeSession(parts[1], body)
+	case method == http.MethodPost && match(parts, "users", "*", "demote"):
+		return s.setRoles(parts[1], "system_guest")
+	case method == http.MethodPost && match(parts, "users", "*", "promote"):
+		return s.setRoles(parts[1], "system_user")
+	case method == http.MethodPost && match(parts, "users", "*", "tokens"):
+		return s.createToken(parts[1], body)
+	case method == http.MethodPost && match(parts, "bots"):
+		return s.createBot(body)
+	case method == http.MethodGet && match(parts, "bots", "*"):
+		if bot, ok := s.bots[parts[1]]; ok {
+			return http.StatusOK, bot
+		}
+		return http.StatusNotFound, "store.sql_bot.get.missing.app_error"
+	case method == http.MethodGet && match(parts, "teams", "name", "*"):
+		for _, team := range s.teams {
+			if team.Name == parts[2] {
+				return http.StatusOK, team
+			}
+		}
+		return http.StatusNotFound, "app.team.get_by_name.missing.app_error"
+	case method == http.MethodGet && match(parts, "teams", "*", "channels", "name", "*"):
+		for _, channel := range s.channels {
+			if channel.TeamID == parts[1] && channel.Name == parts[4] {
+				return http.StatusOK, channel
+			}
+		}
+		return http.StatusNotFound, "app.channel.get_by_name.missing.app_error"
+	case method == http.MethodPost && match(parts, "teams", "*", "members"):
+		return s.addMember(s.teamMembers, parts[1], body, "store.sql_team.save_member.exists.app_error")
+	case method == http.MethodDelete && match(parts, "teams", "*", "members", "*"):
+		if !s.teamMembers[parts[1]][parts[3]] {
+			return http.StatusNotFound, "app.team.get_member.missing.app_error"
+		}
+		delete(s.teamMembers[parts[1]], parts[3])
+		return http.StatusOK, map[string]string{"status": "OK"}
+	case method == http.MethodPost && match(parts, "channels", "*", "members"):
+		return s.addMember(s.channelMembers, parts[1], body, "store.sql_channel.save_member.exists.app_error")
+	case method == http.MethodPost && match(parts, "posts"):
+		var post Post
+		if err := json.Unmarshal(body, &post); err != nil {
+			return http.StatusBadRequest, "api.context.invalid_body_param.app_error"
+		}
+		s.posts = append(s.posts, post)
+		return http.StatusCreated, post
+	}
+	return http.StatusNotFound, "api.context.404.app_error"
+}
+
+func (s *Server) createUser(body []byte) (int, any) {
+	var u User
+	if err := json.Unmarshal(body, &u); err != nil || u.Email == "" || u.Username == "" {
+		return http.StatusBadRequest, "api.context.invalid_body_param.app_error"
+	}
+	if s.userByEmailLocked(u.Email) != nil {
+		return http.StatusBadRequest, "app.user.save.email_exists.app_error"
+	}
+	if s.userByUsernameLocked(u.Username) != nil {
+		return http.StatusBadRequest, "app.user.save.username_exists.app_error"
+	}
+	u.ID = s.newIDLocked("user")
+	u.Roles = "system_user"
+	u.CreateAt = time.Now().UnixMilli()
+	u.UpdateAt = u.CreateAt
+	s.users[u.ID] = &u
+	return http.StatusCreated, u
+}
+
+func (s *Server) createBot(body []byte) (int, any) {
+	var bot Bot
+	if err := json.Unmarshal(body, &bot); err != nil || bot.Username == "" {
+		return http.StatusBadRequest, "api.context.invalid_body_param.app_error"
+	}
+	if s.userByUsernameLocked(bot.Username) != nil {
+		return http.StatusBadRequest, "app.user.save.username_exists.app_error"
+	}
+	now := time.Now().UnixMilli()
+	u := &User{ID: s.newIDLocked("bot"), Username: bot.Username, Roles: "system_user", IsBot: true, CreateAt: now, UpdateAt: now}
+	s.users[u.ID] = u
+	bot.UserID = u.ID
+	bot.OwnerID = "admin"
+	s.bots[u.ID] = bot
+	return http.StatusCreated, bot
+}
+
+func (s *Server) createToken(userID string, body []byte) (int, any) {
+	if _, ok := s.users[userID]; !ok {
+		return http.StatusNotFound, "app.user.missing_account.const"
+	}
+	var token Token
+	if err := json.Unmarshal(body, &token); err != nil {
+		return http.StatusBadRequest, "api.context.invalid_body_param.app_error"
+	}
+	token.ID = s.newIDLocked("token")
+	token.Token = s.newIDLocked("secret")
+	token.UserID = userID
+	s.tokens[userID] = append(s.tokens[userID], token)
+	return http.StatusOK, token
+}
+
+func (s *Server) patchUser(id string, body []byte) (int, any) {
+	u, ok := s.users[id]
+	if !ok {
+		return http.StatusNotFound, "app.user.missing_account.const"
+	}
+	var patch struct {
+		Email     *string `json:"email"`
+		Username  *string `json:"username"`
+		FirstName *string `json:"first_name"`
+		LastName  *string `json:"last_name"`
+	}
+	if err := json.Unmarshal(body, &patch); err != nil {
+		return http.StatusBadRequest, "api.context.invalid_body_param.app_error"
+	}
+	if patch.Username != nil {
+		if other := s.userByUsernameLocked(*patch.Username); other != nil && other.ID != id {
+			return http.StatusBadRequest, "app.user.save.username_exists.app_error"
+		}
+		u.Username = *patch.Username
+	}
+	if patch.Email != nil {
+		if other := s.userByEmailLocked(*patch.Email); other != nil && other.ID != id {
+			return http.StatusBadRequest, "app.user.save.email_exists.app_error"
+		}
+		u.Email = *patch.Email
+	}
+	if patch.FirstName != nil {
+		u.FirstName = *patch.FirstName
+	}
+	if patch.LastName != nil {
+		u.LastName = *patch.LastName
+	}
+	u.UpdateAt = time.Now().UnixMilli()
+	return http.StatusOK, u
+}
+
+func (s *Server) updateRoles(id string, body []byte) (int, any) {
+	u, ok := s.users[id]
+	if !ok {
+		return http.StatusNotFound, "app.user.missing_account.const"
+	}
+	var payload struct {
+		Roles string `json:"roles"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return http.StatusBadRequest, "api.context.invalid_body_param.app_error"
+	}
+	u.Roles = payload.Roles
+	return http.StatusOK, map[string]string{"status": "OK"}
+}
+
+func (s *Server) setRoles(id, roles string) (int, any) {
+	u, ok := s.users[id]
+	if !ok {
+		return http.StatusNotFound, "app.user.missing_account.const"
+	}
+	u.Roles = roles
+	return http.StatusOK, map[string]string{"status": "OK"}
+}
+
+func (s *Server) setActive(id string, body []byte) (int, any) {
+	u, ok := s.users[id]
+	if !ok {
+		return http.StatusNotFound, "app.user.missing_account.const"
+	}
+	var payload struct {
+		Active bool `json:"active"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return http.StatusBadRequest, "api.context.invalid_body_param.app_error"
+	}
+	if payload.Active {
+		u.DeleteAt = 0
+	} else {
+		u.DeleteAt = time.Now().UnixMilli()
+		delete(s.sessions, id) // Mattermost revokes sessions on deactivation
+	}
+	return http.StatusOK, map[string]string{"status": "OK"}
+}
+
+func (s *Server) createSession(userID string, body []byte) (int, any) {
+	if _, ok := s.users[userID]; !ok {
+		return http.StatusNotFound, "app.user.missing_account.const"
+	}
+	var session Session
+	if err := json.Unmarshal(body, &session); err != nil {
+		return http.StatusBadRequest, "api.context.invalid_body_param.app_error"
+	}
+	return http.StatusCreated, s.addSessionLocked(userID, session)
+}
+
+func (s *Server) revokeSession(userID string, body []byte) (int, any) {
+	var payload struct {
+		SessionID string `json:"session_id"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return http.StatusBadRequest, "api.context.invalid_body_param.app_error"
+	}
+	sessions := s.sessions[userID]
+	for i, session := range sessions {
+		if session.ID == payload.SessionID {
+			s.sessions[userID] = append(sessions[:i:i], sessions[i+1:]...)
+			return http.StatusOK, map[string]string{"status": "OK"}
+		}
+	}
+	return http.StatusBadRequest, "api.user.revoke_session.app_error"
+}
+
+func (s *Server) logoutLocked(token string) {
+	for userID, sessions := range s.sessions {
+		for i, session := range sessions {
+			if session.Token == token {
+				s.sessions[userID] = append(sessions[:i:i], sessions[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+func (s *Server) addMember(members map[string]map[string]bool, id string, body []byte, existsID string) (int, any) {
+	var payload struct {
+		UserID string `json:"user_id"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil || payload.UserID == "" {
+		return http.StatusBadRequest, "api.context.invalid_body_param.app_error"
+	}
+	if members[id] == nil {
+		members[id] = map[string]bool{}
+	}
+	if members[id][payload.UserID] {
+		return http.StatusBadRequest, existsID
+	}
+	members[id][payload.UserID] = true
+	return http.StatusCreated, map[string]string{"user_id": payload.UserID}
+}
+
+func (s *Server) addSessionLocked(userID string, session Session) Session {
+	if session.ID == "" {
+		session.ID = s.newIDLocked("session")
+	}
+	if session.Token == "" {
+		session.Token = s.newIDLocked("token")
+	}
+	if session.CreateAt == 0 {
+		session.CreateAt = time.Now().UnixMilli()
+	}
+	session.UserID = userID
+	s.sessions[userID] = append(s.sessions[userID], session)
+	return session
+}
+
+func (s *Server) userByEmailLocked(email string) *User {
+	for _, u := range s.users {
+		if strings.EqualFold(u.Email, email) {
+			return u
+		}
+	}
+	return nil
+}
+
+func (s *Server) userByUsernameLocked(username string) *User {
+	for _, u := range s.users {
+		if u.Username == username {
+			return u
+		}
+	}
+	return nil
+}
+
+func (s *Server) newIDLocked(kind string) string {
+	s.nextID++
+	return fmt.Sprintf("%s-%d", kind, s.nextID)
+}
+
+// match reports whether path segments match the pattern; "*" matches any
+// single non-empty segment.
+func match(parts []string, pattern ...string) bool {
+	if len(parts) != len(pattern) {
+		return false
+	}
+	for i, p := range pattern {
+		if p == "*" && parts[i] != "" {
+			continue
+		}
+		if p != parts[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func writeError(w http.ResponseWriter, status int, id string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"id":          id,
+		"message":     http.StatusText(status),
+		"status_code": status,
+	})
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,68 @@
+package mattermost
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"time"
+)
+
+// Metrics receives the duration of each API call, including retries.
+// operation is the method and path template, e.g. "GET /users/email/:id";
+// outcome is "ok", "client_error", "server_error", or "transport_error".
+type Metrics interface {
+	ObserveRequest(operation, outcome string, duration time.Duration)
+}
+
+// SetMetrics registers m to observe API call latency. A nil m disables it.
+func (c *Client) SetMetrics(m Metrics) {
+	c.metrics = m
+}
+
+func (c *Client) observe(method, path string, start time.Time, err error) {
+	if c.metrics == nil {
+		return
+	}
+	c.metrics.ObserveRequest(operationName(method, path), requestOutcome(err), time.Since(start))
+}
+
+// pathLiterals are the fixed path segments of the endpoints we call; every
+// other segment is an ID, email, or name and is collapsed to ":id" to keep
+// label cardinality bounded.
+var pathLiterals = map[string]bool{
+	"users": true, "email": true, "username": true, "me": true, "patch": true,
+	"roles": true, "active": true, "sessions": true, "revoke": true, "all": true,
+	"demote": true, "promote": true, "tokens": true, "teams": true, "name": true,
+	"channels": true, "members": true, "bots": true, "posts": true, "system": true,
+	"ping": true, "logout": true,
+}
+
+func operationName(method, path string) string {
+	path, _, _ = strings.Cut(path, "?")
+	parts := strings.Split(strings.TrimPrefix(path, "/api/v4/"), "/")
+	for i, part := range parts {
+		if !pathLiterals[part] {
+			parts[i] = ":id"
+		}
+	}
+	return method + " /" + strings.Join(parts, "/")
+}
+
+func requestOutcome(err error) string {
+	if err == nil {
+		return "ok"
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		if apiErr.StatusCode >= 500 {
+			return "server_error"
+		}
+		return "client_error"
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return "transport_error"
+	}
+	return "client_error"
+}
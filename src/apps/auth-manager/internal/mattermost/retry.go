@@ -0,0 +1,103 @@
+package mattermost
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxAttempts    = 3
+	baseRetryDelay = 250 * time.Millisecond
+	maxRetryDelay  = 5 * time.Second
+	// maxRetryBudget bounds a request and its retries when the caller's
+	// context has no deadline of its own.
+	maxRetryBudget = 30 * time.Second
+)
+
+// SetRetryHook registers fn to be called before each retry with the HTTP
+// method and the reason ("connection", "429", "502", "503", "504").
+func (c *Client) SetRetryHook(fn func(method, reason string)) {
+	c.onRetry = fn
+}
+
+// retryableStatus reports whether a response status is worth retrying.
+// 429 means Mattermost refused the request outright, so any method may retry.
+// Gateway errors are ambiguous for writes that may already have been applied,
+// so only idempotent methods retry them; POSTs (user and session creation)
+// only retry on 429 and on failing to connect (see retryableConnError).
+func retryableStatus(method string, status int) (string, bool) {
+	switch status {
+	case http.StatusTooManyRequests:
+		return "429", true
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		if idempotent(method) {
+			return strconv.Itoa(status), true
+		}
+	}
+	return "", false
+}
+
+// retryableConnError reports whether a request that failed with err before
+// any response is worth retrying. A connection that drops mid-request may
+// have delivered it, and replaying a POST would create a second user or
+// session, so other methods only retry when dialing failed and nothing was
+// sent.
+func retryableConnError(method string, err error) bool {
+	if idempotent(method) {
+		return true
+	}
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+	return errors.As(err, &opErr) && opErr.Op == "dial" || errors.As(err, &dnsErr)
+}
+
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// wait sleeps for delay before the next attempt. It returns false without
+// waiting when the delay would run past the context deadline.
+func (c *Client) wait(ctx context.Context, method, reason string, delay time.Duration) bool {
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+		return false
+	}
+	if c.onRetry != nil {
+		c.onRetry(method, reason)
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// retryDelay honors a Retry-After header in seconds, falling back to
+// jittered exponential backoff.
+func retryDelay(header string, attempt int) time.Duration {
+	if secs, err := strconv.Atoi(strings.TrimSpace(header)); err == nil && secs >= 0 {
+		d := time.Duration(secs) * time.Second
+		if d > maxRetryDelay {
+			d = maxRetryDelay
+		}
+		return d
+	}
+	d := baseRetryDelay << (attempt - 1)
+	if d > maxRetryDelay {
+		d = maxRetryDelay
+	}
+	// Jitter within [d/2, d) so concurrent logins don't retry in lockstep.
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)))
+}
@@ -0,0 +1,135 @@
+package n8n
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrOwnerLoginRequired is returned in API-key mode by operations n8n's
+// public API doesn't offer.
+var ErrOwnerLoginRequired = errors.New("n8n operation requires owner login, not an API key")
+
+// APIKeyHeader carries the key for n8n's public API.
+const APIKeyHeader = "X-N8N-API-KEY"
+
+// ownerRefreshSkew renews the owner session this long before n8n expires it.
+const ownerRefreshSkew = 30 * time.Second
+
+// credential authenticates a request as the owner.
+type credential interface {
+	apply(req *http.Request)
+}
+
+// sessionCookie is an n8n-auth cookie value.
+type sessionCookie string
+
+func (c sessionCookie) apply(req *http.Request) {
+	req.Header.Set("Cookie", AuthCookieName+"="+string(c))
+}
+
+// apiKey is a key for n8n's public API.
+type apiKey string
+
+func (k apiKey) apply(req *http.Request) {
+	req.Header.Set(APIKeyHeader, string(k))
+}
+
+// authStrategy supplies the owner credential for management requests.
+type authStrategy interface {
+	credential(ctx context.Context) (credential, error)
+	// reject reports that n8n refused cred, and whether a retry with a
+	// fresh credential could succeed.
+	reject(cred credential) bool
+}
+
+// ownerLogin logs in with the owner's password and caches the session
+// cookie until shortly before it expires. Concurrent callers wait on a
+// single login.
+type ownerLogin struct {
+	client *Client
+
+	mu     sync.Mutex // Held while the session is refreshed
+	cookie string
+	expiry time.Time // Zero when the cookie didn't say
+}
+
+func (o *ownerLogin) credential(ctx context.Context) (credential, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.cookie != "" && (o.expiry.IsZero() || time.Now().Add(ownerRefreshSkew).Before(o.expiry)) {
+		return sessionCookie(o.cookie), nil
+	}
+
+	cookie, err := o.client.loginCookie(ctx, o.client.ownerEmail, o.client.ownerPass)
+	if errors.Is(err, ErrUnauthorized) {
+		return nil, fmt.Errorf("owner login failed: %w: %w", ErrOwnerAuthFailed, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("owner login failed: %w", err)
+	}
+	o.cookie = cookie.Value
+	o.expiry = cookieExpiry(cookie, time.Now())
+	return sessionCookie(o.cookie), nil
+}
+
+// reject forgets the cached cookie if it is still the rejected one, so a
+// caller racing with another's refresh doesn't discard the new session.
+func (o *ownerLogin) reject(cred credential) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if sessionCookie(o.cookie) == cred {
+		o.cookie = ""
+		o.expiry = time.Time{}
+	}
+	return true
+}
+
+// apiKeyAuth sends a fixed API key; a rejected key stays rejected.
+type apiKeyAuth string
+
+func (k apiKeyAuth) credential(context.Context) (credential, error) {
+	return apiKey(k), nil
+}
+
+func (apiKeyAuth) reject(credential) bool {
+	return false
+}
+
+// usesAPIKey reports whether the client talks to the public API.
+func (c *Client) usesAPIKey() bool {
+	_, ok := c.auth.(apiKeyAuth)
+	return ok
+}
+
+// asOwner runs fn with the owner credential. When n8n rejects a session
+// cookie (it was revoked or n8n restarted), it logs in again and retries fn
+// once.
+func (c *Client) asOwner(ctx context.Context, fn func(owner credential) error) error {
+	cred, err := c.auth.credential(ctx)
+	if err != nil {
+		return err
+	}
+	err = fn(cred)
+	if !errors.Is(err, ErrUnauthorized) || !c.auth.reject(cred) {
+		return err
+	}
+	if cred, err = c.auth.credential(ctx); err != nil {
+		return err
+	}
+	return fn(cred)
+}
+
+// Ping verifies n8n accepts the owner credential, logging in if needed.
+func (c *Client) Ping(ctx context.Context) error {
+	return c.asOwner(ctx, func(owner credential) error {
+		if c.usesAPIKey() {
+			return c.call(ctx, http.MethodGet, "/api/v1/users?limit=1", owner, nil, nil, "ping")
+		}
+		_, err := c.currentUser(ctx, owner)
+		return err
+	})
+}
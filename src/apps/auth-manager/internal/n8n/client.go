@@ -3,21 +3,23 @@ package n8n
 import (
 	"bytes"
 	"context"
-	"crypto/rand"
 	"encoding/json"
 	"errors"
-	"fmt"
-	"io"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/accounts"
+	"github.com/rave-org/rave/apps/auth-manager/internal/httpx"
 )
 
-var (
-	// ErrNotFound is returned when n8n returns a 404.
-	ErrNotFound = errors.New("n8n resource not found")
-	// ErrUnauthorized is returned when authentication fails.
-	ErrUnauthorized = errors.New("n8n authentication failed")
+// Global roles n8n assigns to users. The owner role can't be granted.
+const (
+	RoleOwner  = "global:owner"
+	RoleAdmin  = "global:admin"
+	RoleMember = "global:member"
 )
 
 // Identity captures the fields needed to create/update an n8n user.
@@ -25,6 +27,7 @@ type Identity struct {
 	Email    string
 	Name     string
 	Username string
+	Role     string // Global role for invited users; RoleMember when empty
 }
 
 // User represents an n8n user.
@@ -35,12 +38,22 @@ type User struct {
 	LastName  string `json:"lastName"`
 	Role      string `json:"role"`
 	Disabled  bool   `json:"disabled"`
+	IsPending bool   `json:"isPending"` // Invited but never signed up
+
+	// InviteAcceptURL is the sign-up link n8n emailed a pending user, when
+	// it reports one.
+	InviteAcceptURL string `json:"inviteAcceptUrl,omitempty"`
 }
 
-// Session represents an n8n session with the auth cookie.
+// AuthCookieName is the cookie n8n keeps its session in.
+const AuthCookieName = "n8n-auth"
+
+// Session represents an n8n session with the auth cookie. ExpiresAt is zero
+// when n8n didn't say when the cookie expires.
 type Session struct {
-	UserID string
-	Cookie string
+	UserID    string
+	Cookie    string
+	ExpiresAt time.Time
 }
 
 // Client is a minimal n8n REST API client.
@@ -49,19 +62,62 @@ type Client struct {
 	httpClient *http.Client
 	ownerEmail string
 	ownerPass  string
+	metrics    Metrics
+	auth       authStrategy
 }
 
+// ClientOptions tunes the client's HTTP transport.
+type ClientOptions = httpx.Options
+
 // NewClient creates a client against the given n8n base URL.
 // ownerEmail and ownerPass are credentials for the n8n owner account used to manage users.
 func NewClient(baseURL, ownerEmail, ownerPass string) *Client {
+	return NewClientWithOptions(baseURL, ownerEmail, ownerPass, ClientOptions{})
+}
+
+// NewClientWithOptions is NewClient with a tuned HTTP transport. In dry-run
+// mode the owner login is still sent, since lookups need its cookie, and
+// invitations are answered with the invited user.
+func NewClientWithOptions(baseURL, ownerEmail, ownerPass string, opts ClientOptions) *Client {
+	if opts.DryRun {
+		if opts.DryRunPassthrough == nil {
+			opts.DryRunPassthrough = func(req *http.Request) bool {
+				return strings.HasSuffix(req.URL.Path, "/rest/login")
+			}
+		}
+		if opts.DryRunRespond == nil {
+			opts.DryRunRespond = simulatedResponse
+		}
+	}
 	trimmed := strings.TrimRight(baseURL, "/")
-	return &Client{
+	c := &Client{
 		baseURL:    trimmed,
 		ownerEmail: ownerEmail,
 		ownerPass:  ownerPass,
-		httpClient: &http.Client{
-			Timeout: 15 * time.Second,
-		},
+		httpClient: httpx.NewClient(opts),
+	}
+	c.auth = &ownerLogin{client: c}
+	return c
+}
+
+// NewClientWithAPIKey creates a client that authenticates with an n8n API
+// key instead of the owner's password, managing users through the public
+// API (/api/v1). Operations the public API lacks return
+// ErrOwnerLoginRequired.
+func NewClientWithAPIKey(baseURL, key string) *Client {
+	return NewClientWithAPIKeyOptions(baseURL, key, ClientOptions{})
+}
+
+// NewClientWithAPIKeyOptions is NewClientWithAPIKey with a tuned HTTP
+// transport.
+func NewClientWithAPIKeyOptions(baseURL, key string, opts ClientOptions) *Client {
+	if opts.DryRun && opts.DryRunRespond == nil {
+		opts.DryRunRespond = simulatedResponse
+	}
+	return &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: httpx.NewClient(opts),
+		auth:       apiKeyAuth(key),
 	}
 }
 
@@ -72,124 +128,199 @@ func (c *Client) EnsureUser(ctx context.Context, ident Identity) (User, error) {
 	if ident.Email == "" {
 		return User{}, errors.New("identity email required")
 	}
+	ident.Email = accounts.NormalizeEmail(ident.Email)
+
+	var user User
+	err := c.asOwner(ctx, func(owner credential) error {
+		// Try to find user by email
+		var err error
+		user, err = c.lookupUser(ctx, owner, ident.Email)
+		if !errors.Is(err, ErrNotFound) {
+			return err
+		}
 
-	// First authenticate as owner to get a session
-	ownerCookie, err := c.login(ctx, c.ownerEmail, c.ownerPass)
+		// User doesn't exist - invite them
+		if c.usesAPIKey() {
+			user, err = c.createPublicUser(ctx, owner, ident)
+		} else {
+			user, err = c.inviteUser(ctx, owner, ident)
+		}
+		return err
+	})
 	if err != nil {
-		return User{}, fmt.Errorf("owner login failed: %w", err)
-	}
-
-	// Try to find user by email
-	user, err := c.getUserByEmail(ctx, ownerCookie, ident.Email)
-	if err == nil {
-		return user, nil
-	}
-	if !errors.Is(err, ErrNotFound) {
 		return User{}, err
 	}
-
-	// User doesn't exist - invite them
-	return c.inviteUser(ctx, ownerCookie, ident)
+	return user, nil
 }
 
 // CreateSession creates an n8n session for the user.
 // Since n8n uses cookie-based auth, this returns the session cookie.
 // The user must already exist with a password set.
 func (c *Client) CreateSession(ctx context.Context, email, password string) (Session, error) {
-	cookie, err := c.login(ctx, email, password)
+	cookie, err := c.loginCookie(ctx, email, password)
 	if err != nil {
 		return Session{}, err
 	}
 	return Session{
-		Cookie: cookie,
+		Cookie:    cookie.Value,
+		ExpiresAt: cookieExpiry(cookie, time.Now()),
 	}, nil
 }
 
-// login authenticates with n8n and returns the session cookie.
-func (c *Client) login(ctx context.Context, email, password string) (string, error) {
+// loginCookie authenticates with n8n and returns the session cookie.
+func (c *Client) loginCookie(ctx context.Context, email, password string) (*http.Cookie, error) {
 	payload := map[string]string{
 		"email":    email,
 		"password": password,
 	}
 	body, err := json.Marshal(payload)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/rest/login", bytes.NewReader(body))
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.send(req, "login")
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
-		return "", ErrUnauthorized
-	}
 	if resp.StatusCode >= 400 {
-		errBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
-		return "", fmt.Errorf("n8n login failed: %s", strings.TrimSpace(string(errBody)))
+		return nil, newAPIError(resp)
 	}
 
-	// Extract the session cookie
+	if cookie := authCookie(resp); cookie != nil {
+		return cookie, nil
+	}
+	return nil, errors.New("no n8n-auth cookie in response")
+}
+
+// authCookie returns the n8n-auth cookie set by resp, if any.
+func authCookie(resp *http.Response) *http.Cookie {
 	for _, cookie := range resp.Cookies() {
-		if cookie.Name == "n8n-auth" {
-			return cookie.Value, nil
+		if cookie.Name == AuthCookieName {
+			return cookie
 		}
 	}
-
-	return "", errors.New("no n8n-auth cookie in response")
+	return nil
 }
 
-// getUserByEmail looks up a user by email.
-func (c *Client) getUserByEmail(ctx context.Context, authCookie, email string) (User, error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/rest/users", nil)
+// usersPageSize is how many users getUserByEmail requests per page.
+var usersPageSize = 100
+
+// getUserByEmail looks up a user by email. It asks n8n to filter by email
+// first; versions that ignore the filter get paged through with skip/take
+// until the user turns up. The oldest versions return every user at once.
+func (c *Client) getUserByEmail(ctx context.Context, owner credential, email string) (User, error) {
+	filter, err := json.Marshal(map[string]string{"email": email})
 	if err != nil {
 		return User{}, err
 	}
-	req.Header.Set("Cookie", "n8n-auth="+authCookie)
-
-	resp, err := c.httpClient.Do(req)
+	query := url.Values{"filter": {string(filter)}, "take": {strconv.Itoa(usersPageSize)}, "skip": {"0"}}
+	page, err := c.listUsers(ctx, owner, query)
 	if err != nil {
 		return User{}, err
 	}
+	filtered := true
+	for _, user := range page.users {
+		if accounts.NormalizeEmail(user.Email) == accounts.NormalizeEmail(email) {
+			return user, nil
+		}
+		filtered = false
+	}
+	if filtered || !page.paged {
+		return User{}, ErrNotFound
+	}
+
+	// The filter was ignored: walk every page.
+	seen := map[string]bool{}
+	for skip := 0; ; skip += len(page.users) {
+		if skip > 0 {
+			query = url.Values{"take": {strconv.Itoa(usersPageSize)}, "skip": {strconv.Itoa(skip)}}
+			if page, err = c.listUsers(ctx, owner, query); err != nil {
+				return User{}, err
+			}
+		}
+		for _, user := range page.users {
+			if accounts.NormalizeEmail(user.Email) == accounts.NormalizeEmail(email) {
+				return user, nil
+			}
+		}
+		// Stop on a short page, the reported total, or a page we've already
+		// seen (skip ignored).
+		if len(page.users) < usersPageSize || (page.count > 0 && skip+len(page.users) >= page.count) || seen[page.users[0].ID] {
+			return User{}, ErrNotFound
+		}
+		seen[page.users[0].ID] = true
+	}
+}
+
+// userPage is one page of GET /rest/users. Newer n8n versions wrap the page
+// as {"count": n, "items": [...]}; older ones return a bare array.
+type userPage struct {
+	users []User
+	count int
+	paged bool // The response carried count/items
+}
+
+func (c *Client) listUsers(ctx context.Context, owner credential, query url.Values) (userPage, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/rest/users?"+query.Encode(), nil)
+	if err != nil {
+		return userPage{}, err
+	}
+	owner.apply(req)
+
+	resp, err := c.send(req, "list_users")
+	if err != nil {
+		return userPage{}, err
+	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode >= 400 {
-		errBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
-		return User{}, fmt.Errorf("n8n get users failed: %s", strings.TrimSpace(string(errBody)))
+		return userPage{}, newAPIError(resp)
 	}
 
 	var result struct {
-		Data []User `json:"data"`
+		Data json.RawMessage `json:"data"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return User{}, err
+		return userPage{}, err
 	}
-
-	for _, user := range result.Data {
-		if strings.EqualFold(user.Email, email) {
-			return user, nil
+	if bytes.HasPrefix(bytes.TrimSpace(result.Data), []byte("[")) {
+		var users []User
+		if err := json.Unmarshal(result.Data, &users); err != nil {
+			return userPage{}, err
 		}
+		return userPage{users: users}, nil
 	}
-
-	return User{}, ErrNotFound
+	var paged struct {
+		Count int    `json:"count"`
+		Items []User `json:"items"`
+	}
+	if err := json.Unmarshal(result.Data, &paged); err != nil {
+		return userPage{}, err
+	}
+	return userPage{users: paged.Items, count: paged.Count, paged: true}, nil
 }
 
 // inviteUser sends an invite to create a new n8n user.
-func (c *Client) inviteUser(ctx context.Context, authCookie string, ident Identity) (User, error) {
-	first, last := splitName(ident.Name)
+func (c *Client) inviteUser(ctx context.Context, owner credential, ident Identity) (User, error) {
+	first, last := accounts.SplitName(ident.Name)
+	role := ident.Role
+	if role == "" {
+		role = RoleMember
+	}
 	payload := []map[string]string{
 		{
 			"email":     ident.Email,
 			"firstName": first,
 			"lastName":  last,
-			"role":      "global:member",
+			"role":      role,
 		},
 	}
 	body, err := json.Marshal(payload)
@@ -202,17 +333,16 @@ func (c *Client) inviteUser(ctx context.Context, authCookie string, ident Identi
 		return User{}, err
 	}
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Cookie", "n8n-auth="+authCookie)
+	owner.apply(req)
 
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.send(req, "invite")
 	if err != nil {
 		return User{}, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode >= 400 {
-		errBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
-		return User{}, fmt.Errorf("n8n invite failed: %s", strings.TrimSpace(string(errBody)))
+		return User{}, newAPIError(resp)
 	}
 
 	var result struct {
@@ -228,29 +358,42 @@ func (c *Client) inviteUser(ctx context.Context, authCookie string, ident Identi
 		return User{}, errors.New("no user returned from invite")
 	}
 
-	return result.Data[0].User, nil
+	user := result.Data[0].User
+	if user.Role == "" {
+		user.Role = role
+	}
+	return user, nil
 }
 
-func splitName(full string) (string, string) {
-	trimmed := strings.TrimSpace(full)
-	if trimmed == "" {
-		return "", ""
-	}
-	parts := strings.Fields(trimmed)
-	if len(parts) == 1 {
-		return parts[0], ""
+// SetUserRole changes a user's global role.
+func (c *Client) SetUserRole(ctx context.Context, userID, role string) error {
+	payload := map[string]string{"newRoleName": role}
+	path := "/rest/users/" + url.PathEscape(userID) + "/role"
+	if c.usesAPIKey() {
+		path = "/api/v1/users/" + url.PathEscape(userID) + "/role"
 	}
-	return parts[0], strings.Join(parts[1:], " ")
+	return c.asOwner(ctx, func(owner credential) error {
+		return c.call(ctx, http.MethodPatch, path, owner, payload, nil, "set_role")
+	})
 }
 
-func randomPassword() string {
-	const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789!@#$%^&*()"
-	buf := make([]byte, 24)
-	if _, err := rand.Read(buf); err != nil {
-		return "ChangeMe123!"
+// simulatedResponse builds dry-run responses for n8n writes.
+func simulatedResponse(req *http.Request, body []byte) []byte {
+	public := strings.HasSuffix(req.URL.Path, "/api/v1/users")
+	if !public && !strings.HasSuffix(req.URL.Path, "/rest/invitations") {
+		return []byte("{}")
+	}
+	var invites []User
+	_ = json.Unmarshal(body, &invites)
+	users := []map[string]User{}
+	for _, invite := range invites {
+		users = append(users, map[string]User{"user": invite})
 	}
-	for i := range buf {
-		buf[i] = letters[int(buf[i])%len(letters)]
+	var out []byte
+	if public {
+		out, _ = json.Marshal(users)
+	} else {
+		out, _ = json.Marshal(map[string]any{"data": users})
 	}
-	return string(buf)
+	return out
 }
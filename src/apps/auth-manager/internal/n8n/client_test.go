@@ -0,0 +1,374 @@
+package n8n
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// ownerServer fakes the n8n endpoints EnsureUser and Ping use. Only the most
+// recently issued owner cookie is accepted.
+type ownerServer struct {
+	*httptest.Server
+	logins atomic.Int32
+	maxAge int
+
+	mu     sync.Mutex
+	cookie string
+}
+
+func newOwnerServer(t *testing.T) *ownerServer {
+	t.Helper()
+	s := &ownerServer{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rest/login", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			if !s.authorized(r) {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]User{"data": {ID: "owner"}})
+			return
+		}
+		n := s.logins.Add(1)
+		time.Sleep(10 * time.Millisecond) // Widen the window for concurrent logins
+		s.mu.Lock()
+		s.cookie = "owner-" + strconv.Itoa(int(n))
+		cookie := &http.Cookie{Name: AuthCookieName, Value: s.cookie, MaxAge: s.maxAge}
+		s.mu.Unlock()
+		http.SetCookie(w, cookie)
+		_, _ = w.Write([]byte(`{"data":{"id":"owner"}}`))
+	})
+	mux.HandleFunc("/rest/users", func(w http.ResponseWriter, r *http.Request) {
+		if !s.authorized(r) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string][]User{"data": {{ID: "u1", Email: "a@example.com"}}})
+	})
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *ownerServer) authorized(r *http.Request) bool {
+	cookie, err := r.Cookie(AuthCookieName)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return err == nil && cookie.Value == s.cookie
+}
+
+// revoke invalidates the current owner cookie, as an n8n restart would.
+func (s *ownerServer) revoke() {
+	s.mu.Lock()
+	s.cookie = ""
+	s.mu.Unlock()
+}
+
+func TestEnsureUser_ConcurrentCallsShareOwnerLogin(t *testing.T) {
+	srv := newOwnerServer(t)
+	c := NewClient(srv.URL, "owner@example.com", "secret")
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.EnsureUser(context.Background(), Identity{Email: "a@example.com"}); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("EnsureUser() error = %v", err)
+	}
+	if got := srv.logins.Load(); got != 1 {
+		t.Errorf("expected 1 owner login, got %d", got)
+	}
+}
+
+func TestEnsureUser_RelogsInOnUnauthorized(t *testing.T) {
+	srv := newOwnerServer(t)
+	c := NewClient(srv.URL, "owner@example.com", "secret")
+	ctx := context.Background()
+
+	if _, err := c.EnsureUser(ctx, Identity{Email: "a@example.com"}); err != nil {
+		t.Fatalf("EnsureUser() error = %v", err)
+	}
+	srv.revoke()
+	user, err := c.EnsureUser(ctx, Identity{Email: "a@example.com"})
+	if err != nil {
+		t.Fatalf("EnsureUser() after revoke error = %v", err)
+	}
+	if user.ID != "u1" {
+		t.Errorf("EnsureUser() = %+v, want ID u1", user)
+	}
+	if got := srv.logins.Load(); got != 2 {
+		t.Errorf("expected 2 owner logins, got %d", got)
+	}
+}
+
+func TestOwnerSession_RefreshesBeforeExpiry(t *testing.T) {
+	srv := newOwnerServer(t)
+	srv.maxAge = int(ownerRefreshSkew/time.Second) - 1 // Already inside the refresh window
+	c := NewClient(srv.URL, "owner@example.com", "secret")
+
+	for i := 0; i < 2; i++ {
+		if err := c.Ping(context.Background()); err != nil {
+			t.Fatalf("Ping() error = %v", err)
+		}
+	}
+	if got := srv.logins.Load(); got != 2 {
+		t.Errorf("expected a login per call for an expiring cookie, got %d", got)
+	}
+}
+
+func TestPing_BadOwnerCredentials(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL, "owner@example.com", "wrong")
+	err := c.Ping(context.Background())
+	if !errors.Is(err, ErrUnauthorized) || !errors.Is(err, ErrOwnerAuthFailed) {
+		t.Errorf("Ping() error = %v, want ErrOwnerAuthFailed", err)
+	}
+}
+
+func TestIsClientError(t *testing.T) {
+	tests := []struct {
+		status int
+		want   bool
+	}{
+		{http.StatusBadRequest, true},
+		{http.StatusNotFound, true},
+		{http.StatusTooManyRequests, false},
+		{http.StatusBadGateway, false},
+	}
+	for _, tt := range tests {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.status)
+			_, _ = w.Write([]byte(`{"message":"nope"}`))
+		}))
+		c := NewClient(srv.URL, "owner@example.com", "secret")
+		_, err := c.CreateSession(context.Background(), "a@example.com", "pw")
+		srv.Close()
+
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != tt.status || apiErr.Message != "nope" {
+			t.Errorf("status %d: error = %#v, want APIError with message", tt.status, err)
+		}
+		if got := IsClientError(err); got != tt.want {
+			t.Errorf("status %d: IsClientError() = %v, want %v", tt.status, got, tt.want)
+		}
+		if tt.status == http.StatusTooManyRequests && !errors.Is(err, ErrRateLimited) {
+			t.Errorf("status 429: error = %v, want ErrRateLimited", err)
+		}
+	}
+	if IsClientError(errors.New("dial tcp: connection refused")) {
+		t.Error("transport errors are not client errors")
+	}
+}
+
+func TestCookieExpiry(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	expires := now.Add(time.Hour)
+	tests := []struct {
+		name   string
+		cookie http.Cookie
+		want   time.Time
+	}{
+		{"max-age", http.Cookie{MaxAge: 60, Expires: expires}, now.Add(time.Minute)},
+		{"expires", http.Cookie{Expires: expires}, expires},
+		{"session", http.Cookie{}, time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cookieExpiry(&tt.cookie, now); !got.Equal(tt.want) {
+				t.Errorf("cookieExpiry() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeleteUser(t *testing.T) {
+	srv := newOwnerServer(t)
+	var query string
+	srv.Config.Handler.(*http.ServeMux).HandleFunc("/rest/users/", func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/rest/users/busy":
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"code":400,"message":"User has active workflows"}`))
+		case "/rest/users/gone":
+			http.NotFound(w, r)
+		default:
+			query = r.URL.RawQuery
+		}
+	})
+	c := NewClient(srv.URL, "owner@example.com", "secret")
+	ctx := context.Background()
+
+	if err := c.DeleteUser(ctx, "u1", "owner"); err != nil {
+		t.Fatalf("DeleteUser() error = %v", err)
+	}
+	if query != "transferId=owner" {
+		t.Errorf("query = %q, want transferId=owner", query)
+	}
+	if err := c.DeleteUser(ctx, "busy", ""); !errors.Is(err, ErrUserOwnsWorkflows) {
+		t.Errorf("DeleteUser(busy) error = %v, want ErrUserOwnsWorkflows", err)
+	}
+	if err := c.DeleteUser(ctx, "gone", ""); !errors.Is(err, ErrNotFound) {
+		t.Errorf("DeleteUser(gone) error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestEnsureUser_PagesThroughUsers(t *testing.T) {
+	defer func(size int) { usersPageSize = size }(usersPageSize)
+	usersPageSize = 2
+
+	users := []User{
+		{ID: "u1", Email: "one@example.com"},
+		{ID: "u2", Email: "two@example.com"},
+		{ID: "u3", Email: "three@example.com"},
+		{ID: "u4", Email: "four@example.com"},
+		{ID: "u5", Email: "target@example.com"},
+	}
+	tests := []struct {
+		name      string
+		filter    bool // Whether the fake honors ?filter=
+		wantPages int
+	}{
+		{"filter ignored", false, 3},
+		{"filter honored", true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newOwnerServer(t)
+			var pages, invites atomic.Int32
+			mux := srv.Config.Handler.(*http.ServeMux)
+			srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/rest/invitations":
+					invites.Add(1)
+					w.WriteHeader(http.StatusBadRequest)
+				case "/rest/users":
+					pages.Add(1)
+					matches := users
+					if tt.filter && r.URL.Query().Get("filter") != "" {
+						var filter struct{ Email string }
+						_ = json.Unmarshal([]byte(r.URL.Query().Get("filter")), &filter)
+						matches = nil
+						for _, user := range users {
+							if user.Email == filter.Email {
+								matches = append(matches, user)
+							}
+						}
+					}
+					skip, _ := strconv.Atoi(r.URL.Query().Get("skip"))
+					take, _ := strconv.Atoi(r.URL.Query().Get("take"))
+					end := skip + take
+					if end > len(matches) {
+						end = len(matches)
+					}
+					_ = json.NewEncoder(w).Encode(map[string]any{
+						"data": map[string]any{"count": len(matches), "items": matches[skip:end]},
+					})
+				default:
+					mux.ServeHTTP(w, r)
+				}
+			})
+
+			c := NewClient(srv.URL, "owner@example.com", "secret")
+			user, err := c.EnsureUser(context.Background(), Identity{Email: "target@example.com"})
+			if err != nil {
+				t.Fatalf("EnsureUser() error = %v", err)
+			}
+			if user.ID != "u5" {
+				t.Errorf("EnsureUser() = %+v, want ID u5", user)
+			}
+			if got := invites.Load(); got != 0 {
+				t.Errorf("expected no invites, got %d", got)
+			}
+			if got := pages.Load(); got != int32(tt.wantPages) {
+				t.Errorf("user list requests = %d, want %d", got, tt.wantPages)
+			}
+		})
+	}
+}
+
+func TestAPIKeyMode(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		created []map[string]string
+		paths   []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		paths = append(paths, r.Method+" "+r.URL.Path)
+		if r.Header.Get(APIKeyHeader) != "key" || r.Header.Get("Cookie") != "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v1/users":
+			_, _ = w.Write([]byte(`{"data":[]}`))
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v1/users/known@example.com":
+			_, _ = w.Write([]byte(`{"id":"u1","email":"known@example.com","role":"global:member"}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/api/v1/users":
+			_ = json.NewDecoder(r.Body).Decode(&created)
+			_, _ = w.Write([]byte(`[{"user":{"id":"u2","email":"new@example.com"},"error":""}]`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	c := NewClientWithAPIKey(srv.URL, "key")
+	ctx := context.Background()
+	if err := c.Ping(ctx); err != nil {
+		t.Fatalf("Ping() error = %v", err)
+	}
+	user, err := c.EnsureUser(ctx, Identity{Email: "known@example.com"})
+	if err != nil || user.ID != "u1" {
+		t.Fatalf("EnsureUser(known) = %+v, %v; want u1", user, err)
+	}
+	user, err = c.EnsureUser(ctx, Identity{Email: "new@example.com", Role: RoleAdmin})
+	if err != nil || user.ID != "u2" || !user.IsPending || user.Role != RoleAdmin {
+		t.Fatalf("EnsureUser(new) = %+v, %v; want pending admin u2", user, err)
+	}
+	if len(created) != 1 || created[0]["email"] != "new@example.com" || created[0]["role"] != RoleAdmin {
+		t.Errorf("created = %v", created)
+	}
+	for _, path := range paths {
+		if strings.HasPrefix(path, "POST /rest/") {
+			t.Errorf("unexpected owner API call %s", path)
+		}
+	}
+	if _, err := c.IssueSession(ctx, user); !errors.Is(err, ErrSessionUnsupported) {
+		t.Errorf("IssueSession() error = %v, want ErrSessionUnsupported", err)
+	}
+
+	bad := NewClientWithAPIKey(srv.URL, "wrong")
+	mu.Lock()
+	paths = nil
+	mu.Unlock()
+	if err := bad.Ping(ctx); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("Ping() with bad key error = %v, want ErrUnauthorized", err)
+	}
+	if len(paths) != 1 {
+		t.Errorf("requests with bad key = %v, want a single attempt", paths)
+	}
+}
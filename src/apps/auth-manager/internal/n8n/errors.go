@@ -0,0 +1,94 @@
+package n8n
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/redact"
+)
+
+var (
+	// ErrNotFound is returned when n8n returns a 404.
+	ErrNotFound = errors.New("n8n resource not found")
+	// ErrUnauthorized is returned when authentication fails.
+	ErrUnauthorized = errors.New("n8n authentication failed")
+	// ErrOwnerAuthFailed is returned when n8n rejects the configured owner
+	// email and password. Retrying won't help until the config is fixed.
+	ErrOwnerAuthFailed = errors.New("n8n rejected the owner credentials")
+	// ErrRateLimited is returned when n8n answers 429, typically its login
+	// rate limiting.
+	ErrRateLimited = errors.New("n8n rate limited the request")
+)
+
+// APIError is a non-2xx response from n8n. Message comes from n8n's error
+// JSON ({"message": ...}) when present.
+type APIError struct {
+	StatusCode int
+	Message    string
+	Method     string
+	Path       string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("n8n %s %s failed (%d): %s", e.Method, e.Path, e.StatusCode, e.Message)
+}
+
+// Is lets errors.Is match ErrNotFound, ErrUnauthorized (401 and 403), and
+// ErrRateLimited against the response status.
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	}
+	return false
+}
+
+// newAPIError reads an n8n error response body into an APIError, masking
+// any credentials it echoes back, ours included.
+func newAPIError(resp *http.Response) *APIError {
+	apiErr := &APIError{StatusCode: resp.StatusCode, Method: resp.Request.Method, Path: resp.Request.URL.Path}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	var payload struct {
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(body, &payload); err == nil && payload.Message != "" {
+		apiErr.Message = payload.Message
+	} else {
+		apiErr.Message = strings.TrimSpace(string(body))
+	}
+	apiErr.Message = redact.Text(apiErr.Message, redact.RequestSecrets(resp.Request)...)
+	return apiErr
+}
+
+// StatusCode returns the HTTP status of an n8n API error, or 0.
+func StatusCode(err error) int {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode
+	}
+	return 0
+}
+
+// IsClientError reports whether err means n8n answered and refused the
+// request, as opposed to being unreachable or failing: missing resources,
+// rejected credentials, unsupported operations. Rate limiting is not a
+// client error, since backing off is the right response to it.
+func IsClientError(err error) bool {
+	switch {
+	case errors.Is(err, ErrRateLimited):
+		return false
+	case errors.Is(err, ErrNotFound), errors.Is(err, ErrUnauthorized), errors.Is(err, ErrOwnerAuthFailed),
+		errors.Is(err, ErrSessionUnsupported), errors.Is(err, ErrOwnerLoginRequired), errors.Is(err, ErrUserOwnsWorkflows):
+		return true
+	}
+	code := StatusCode(err)
+	return code >= 400 && code < 500
+}
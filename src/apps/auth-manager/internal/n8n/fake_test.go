@@ -0,0 +1,143 @@
+package n8n
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/n8n/n8ntest"
+)
+
+func TestEnsureUser(t *testing.T) {
+	tests := []struct {
+		name        string
+		ownerPass   string
+		seed        *n8ntest.User
+		ident       Identity
+		wantErr     error
+		wantPending bool
+		wantInvites int
+		wantFirst   string
+		wantLast    string
+	}{
+		{
+			name:      "existing user",
+			ownerPass: n8ntest.OwnerPassword,
+			seed:      &n8ntest.User{Email: "alice@example.com", FirstName: "Alice"},
+			ident:     Identity{Email: "alice@example.com", Name: "Alice Example"},
+			wantFirst: "Alice",
+		},
+		{
+			name:        "missing user is invited",
+			ownerPass:   n8ntest.OwnerPassword,
+			ident:       Identity{Email: "bob@example.com", Name: "Bob van Example"},
+			wantPending: true,
+			wantInvites: 1,
+			wantFirst:   "Bob",
+			wantLast:    "van Example",
+		},
+		{
+			name:      "owner login failure",
+			ownerPass: "wrong",
+			ident:     Identity{Email: "carol@example.com"},
+			wantErr:   ErrOwnerAuthFailed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := n8ntest.NewServer(t)
+			if tt.seed != nil {
+				fake.AddUser(*tt.seed)
+			}
+			c := NewClient(fake.URL, n8ntest.OwnerEmail, tt.ownerPass)
+
+			user, err := c.EnsureUser(context.Background(), tt.ident)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("EnsureUser() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("EnsureUser() error = %v", err)
+			}
+			if user.Email != tt.ident.Email || user.IsPending != tt.wantPending {
+				t.Errorf("EnsureUser() = %+v", user)
+			}
+			if user.FirstName != tt.wantFirst || user.LastName != tt.wantLast {
+				t.Errorf("name = %q %q, want %q %q", user.FirstName, user.LastName, tt.wantFirst, tt.wantLast)
+			}
+			if got := fake.Count(http.MethodPost, "/rest/invitations"); got != tt.wantInvites {
+				t.Errorf("expected %d invites, got %d", tt.wantInvites, got)
+			}
+			if _, ok := fake.UserByEmail(tt.ident.Email); !ok {
+				t.Errorf("user %s not stored", tt.ident.Email)
+			}
+		})
+	}
+}
+
+func TestCreateSession(t *testing.T) {
+	tests := []struct {
+		name       string
+		password   string
+		maxAge     int
+		wantErr    error
+		wantExpiry bool
+	}{
+		{name: "valid password", password: "user-pass", maxAge: 600, wantExpiry: true},
+		{name: "cookie without expiry", password: "user-pass"},
+		{name: "wrong password", password: "nope", wantErr: ErrUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := n8ntest.NewServer(t)
+			fake.AddUser(n8ntest.User{Email: "alice@example.com", Password: "user-pass"})
+			fake.SetCookieMaxAge(tt.maxAge)
+			c := NewClient(fake.URL, n8ntest.OwnerEmail, n8ntest.OwnerPassword)
+
+			session, err := c.CreateSession(context.Background(), "alice@example.com", tt.password)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("CreateSession() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CreateSession() error = %v", err)
+			}
+			if session.Cookie == "" {
+				t.Error("expected a session cookie")
+			}
+			if got := !session.ExpiresAt.IsZero(); got != tt.wantExpiry {
+				t.Errorf("ExpiresAt = %v, want set %v", session.ExpiresAt, tt.wantExpiry)
+			}
+		})
+	}
+}
+
+func TestFakeServer_InjectedFailures(t *testing.T) {
+	fake := n8ntest.NewServer(t)
+	fake.FailRate(0.5, http.StatusServiceUnavailable)
+	fake.Latency(20 * time.Millisecond)
+	c := NewClient(fake.URL, n8ntest.OwnerEmail, n8ntest.OwnerPassword)
+
+	start := time.Now()
+	var failures int
+	for i := 0; i < 4; i++ {
+		if _, err := c.CreateSession(context.Background(), n8ntest.OwnerEmail, n8ntest.OwnerPassword); err != nil {
+			if StatusCode(err) != http.StatusServiceUnavailable {
+				t.Fatalf("CreateSession() error = %v, want 503", err)
+			}
+			failures++
+		}
+	}
+	if failures != 2 {
+		t.Errorf("expected 2 of 4 requests to fail, got %d", failures)
+	}
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		t.Errorf("expected injected latency, requests took %v", elapsed)
+	}
+}
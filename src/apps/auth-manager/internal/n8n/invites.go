@@ -0,0 +1,41 @@
+package n8n
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/accounts"
+)
+
+// AcceptInvitation completes a pending user's sign-up on their behalf with
+// a random password, so SSO users never need the invite email. They can
+// only log in through sessions issued with IssueSession afterwards.
+func (c *Client) AcceptInvitation(ctx context.Context, user User) error {
+	if user.ID == "" {
+		return errors.New("n8n user id required")
+	}
+	owner, err := c.Owner(ctx)
+	if err != nil {
+		return err
+	}
+	password, err := accounts.RandomPassword(24, accounts.Symbols)
+	if err != nil {
+		return fmt.Errorf("generate n8n password: %w", err)
+	}
+	_, err = c.acceptInvitation(ctx, owner.ID, user, password)
+	return err
+}
+
+// ResendInvite emails a pending user their invitation again.
+func (c *Client) ResendInvite(ctx context.Context, userID string) error {
+	if c.usesAPIKey() {
+		return ErrOwnerLoginRequired
+	}
+	path := "/rest/users/" + url.PathEscape(userID) + "/reinvite"
+	return c.asOwner(ctx, func(owner credential) error {
+		return c.call(ctx, http.MethodPost, path, owner, nil, nil, "reinvite")
+	})
+}
@@ -0,0 +1,40 @@
+package n8n
+
+import (
+	"net/http"
+	"time"
+)
+
+// Metrics receives the duration of each n8n API call. operation names the
+// call ("login", "list_users", "invite", "password_reset_link", ...); outcome
+// is "ok", "client_error", "server_error", or "transport_error".
+type Metrics interface {
+	ObserveRequest(operation, outcome string, duration time.Duration)
+}
+
+// SetMetrics registers m to observe API call latency. A nil m disables it.
+func (c *Client) SetMetrics(m Metrics) {
+	c.metrics = m
+}
+
+// send performs req, reporting its latency as operation.
+func (c *Client) send(req *http.Request, operation string) (*http.Response, error) {
+	start := time.Now()
+	resp, err := c.httpClient.Do(req)
+	if c.metrics != nil {
+		c.metrics.ObserveRequest(operation, responseOutcome(resp, err), time.Since(start))
+	}
+	return resp, err
+}
+
+func responseOutcome(resp *http.Response, err error) string {
+	switch {
+	case err != nil:
+		return "transport_error"
+	case resp.StatusCode >= 500:
+		return "server_error"
+	case resp.StatusCode >= 400:
+		return "client_error"
+	}
+	return "ok"
+}
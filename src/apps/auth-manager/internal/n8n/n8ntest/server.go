@@ -0,0 +1,529 @@
+// Package n8ntest provides an in-memory fake of the n8n REST endpoints the
+// auth-manager uses, for tests. It deliberately does not import the n8n
+// package so that package's own tests can use it.
+package n8ntest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Owner credentials the fake accepts at /rest/login.
+const (
+	OwnerEmail    = "owner@example.com"
+	OwnerPassword = "owner-pass"
+	OwnerID       = "owner"
+)
+
+// User is an n8n user as stored by the fake.
+type User struct {
+	ID        string `json:"id"`
+	Email     string `json:"email"`
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+	Role      string `json:"role"`
+	Disabled  bool   `json:"disabled"`
+	IsPending bool   `json:"isPending"`
+	Password  string `json:"-"`
+}
+
+// Project is an n8n project as stored by the fake.
+type Project struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// Request records a call made against the fake.
+type Request struct {
+	Method string
+	Path   string
+	Query  string
+	Body   []byte
+}
+
+// Server is a fake n8n API. It starts with only the owner; seed it with
+// AddUser and AddProject, and program failures with Error, Handle, Latency,
+// and FailRate. User listing is paginated with skip/take and honors the
+// email filter unless IgnoreFilter is called.
+type Server struct {
+	*httptest.Server
+
+	mu             sync.Mutex
+	nextID         int
+	users          map[string]*User
+	cookies        map[string]string // Cookie value → user ID
+	projects       map[string]Project
+	projectMembers map[string]map[string]string // Project ID → user ID → role
+	resetTokens    map[string]string            // Token → user ID
+	requests       []Request
+	overrides      map[string]http.HandlerFunc
+	ignoreFilter   bool
+	latency        time.Duration
+	failRate       float64
+	failStatus     int
+	served         int
+	cookieMaxAge   int
+}
+
+// NewServer starts a fake n8n server that is closed when the test ends.
+func NewServer(t testing.TB) *Server {
+	s := &Server{
+		users:          map[string]*User{},
+		cookies:        map[string]string{},
+		projects:       map[string]Project{},
+		projectMembers: map[string]map[string]string{},
+		resetTokens:    map[string]string{},
+		overrides:      map[string]http.HandlerFunc{},
+		cookieMaxAge:   3600,
+	}
+	s.users[OwnerID] = &User{ID: OwnerID, Email: OwnerEmail, Role: "global:owner", Password: OwnerPassword}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// AddUser stores a user, assigning an ID when empty and the member role when
+// unset, and returns it.
+func (s *Server) AddUser(u User) User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if u.ID == "" {
+		u.ID = s.newIDLocked("user")
+	}
+	if u.Role == "" {
+		u.Role = "global:member"
+	}
+	stored := u
+	s.users[u.ID] = &stored
+	return u
+}
+
+// User returns the stored user with the given ID.
+func (s *Server) User(id string) (User, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[id]
+	if !ok {
+		return User{}, false
+	}
+	return *u, true
+}
+
+// UserByEmail returns the stored user with the given email.
+func (s *Server) UserByEmail(email string) (User, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if u := s.userByEmailLocked(email); u != nil {
+		return *u, true
+	}
+	return User{}, false
+}
+
+// AddProject stores a team project with the given name and returns it.
+func (s *Server) AddProject(name string) Project {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	project := Project{ID: s.newIDLocked("project"), Name: name, Type: "team"}
+	s.projects[project.ID] = project
+	return project
+}
+
+// ProjectMembers returns the project's members and their roles.
+func (s *Server) ProjectMembers(projectID string) map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	members := map[string]string{}
+	for id, role := range s.projectMembers[projectID] {
+		members[id] = role
+	}
+	return members
+}
+
+// IgnoreFilter makes user listing ignore ?filter=, like older n8n versions.
+func (s *Server) IgnoreFilter() {
+	s.mu.Lock()
+	s.ignoreFilter = true
+	s.mu.Unlock()
+}
+
+// SetCookieMaxAge sets the Max-Age of issued n8n-auth cookies; 0 omits it.
+func (s *Server) SetCookieMaxAge(seconds int) {
+	s.mu.Lock()
+	s.cookieMaxAge = seconds
+	s.mu.Unlock()
+}
+
+// RevokeSessions invalidates every issued cookie, as an n8n restart would.
+func (s *Server) RevokeSessions() {
+	s.mu.Lock()
+	s.cookies = map[string]string{}
+	s.mu.Unlock()
+}
+
+// Latency delays every response by d.
+func (s *Server) Latency(d time.Duration) {
+	s.mu.Lock()
+	s.latency = d
+	s.mu.Unlock()
+}
+
+// FailRate makes the given fraction of requests (0–1) fail with status. The
+// failures are spread evenly rather than random, so tests are repeatable:
+// a rate of 0.5 fails every other request.
+func (s *Server) FailRate(rate float64, status int) {
+	s.mu.Lock()
+	s.failRate, s.failStatus = rate, status
+	s.mu.Unlock()
+}
+
+// Requests returns every request received so far.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// Count returns how many requests matched method and path.
+func (s *Server) Count(method, path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, req := range s.requests {
+		if req.Method == method && req.Path == path {
+			n++
+		}
+	}
+	return n
+}
+
+// Handle overrides the fake's behavior for an exact method and path.
+func (s *Server) Handle(method, path string, h http.HandlerFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.overrides[method+" "+path] = h
+}
+
+// Error makes method and path fail with an n8n-style error body.
+func (s *Server) Error(method, path string, status int, message string) {
+	s.Handle(method, path, func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, status, message)
+	})
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	s.mu.Lock()
+	s.requests = append(s.requests, Request{Method: r.Method, Path: r.URL.Path, Query: r.URL.RawQuery, Body: body})
+	override := s.overrides[r.Method+" "+r.URL.Path]
+	latency := s.latency
+	s.served++
+	fail := s.failRate > 0 && int(float64(s.served)*s.failRate) > int(float64(s.served-1)*s.failRate)
+	failStatus := s.failStatus
+	s.mu.Unlock()
+
+	if latency > 0 {
+		time.Sleep(latency)
+	}
+	if fail {
+		writeError(w, failStatus, "injected failure")
+		return
+	}
+	if override != nil {
+		override(w, r)
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/rest/"), "/")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.Method == http.MethodPost && match(parts, "login") {
+		s.login(w, body)
+		return
+	}
+	if r.Method == http.MethodPost && match(parts, "change-password") {
+		status, resp := s.changePassword(body)
+		s.respond(w, status, resp)
+		return
+	}
+	if r.Method == http.MethodPost && match(parts, "invitations", "*", "accept") {
+		s.acceptInvitation(w, parts[1], body)
+		return
+	}
+	caller := s.callerLocked(r)
+	if caller == nil {
+		writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	status, resp := s.route(r, parts, caller, body)
+	s.respond(w, status, resp)
+}
+
+// route dispatches an authenticated request with s.mu held. Errors return
+// the status and the error message as resp.
+func (s *Server) route(r *http.Request, parts []string, caller *User, body []byte) (int, any) {
+	method := r.Method
+	switch {
+	case method == http.MethodGet && match(parts, "login"):
+		return http.StatusOK, map[string]any{"data": caller}
+	case method == http.MethodGet && match(parts, "users"):
+		return s.listUsers(r)
+	case method == http.MethodPost && match(parts, "invitations"):
+		return s.invite(body)
+	case method == http.MethodPatch && match(parts, "users", "*", "role"):
+		var payload struct {
+			NewRoleName string `json:"newRoleName"`
+		}
+		_ = json.Unmarshal(body, &payload)
+		return s.updateUser(parts[1], func(u *User) { u.Role = payload.NewRoleName })
+	case method == http.MethodPatch && match(parts, "users", "*", "settings"):
+		var payload struct {
+			Disabled bool `json:"disabled"`
+		}
+		_ = json.Unmarshal(body, &payload)
+		return s.updateUser(parts[1], func(u *User) { u.Disabled = payload.Disabled })
+	case method == http.MethodPost && match(parts, "users", "*", "reinvite"):
+		return s.updateUser(parts[1], func(*User) {})
+	case method == http.MethodGet && match(parts, "users", "*", "password-reset-link"):
+		u, ok := s.users[parts[1]]
+		if !ok {
+			return http.StatusNotFound, "User not found"
+		}
+		token := s.newIDLocked("reset")
+		s.resetTokens[token] = u.ID
+		return http.StatusOK, map[string]any{"data": map[string]string{"link": s.URL + "/change-password?token=" + token}}
+	case method == http.MethodDelete && match(parts, "users", "*"):
+		if _, ok := s.users[parts[1]]; !ok || parts[1] == OwnerID {
+			return http.StatusNotFound, "User not found"
+		}
+		delete(s.users, parts[1])
+		return http.StatusOK, map[string]bool{"success": true}
+	case method == http.MethodGet && match(parts, "projects"):
+		projects := []Project{}
+		for _, project := range s.projects {
+			projects = append(projects, project)
+		}
+		return http.StatusOK, map[string]any{"data": projects}
+	case method == http.MethodPost && match(parts, "projects", "*", "users"):
+		return s.addProjectMembers(parts[1], body)
+	}
+	return http.StatusNotFound, "Not found"
+}
+
+func (s *Server) login(w http.ResponseWriter, body []byte) {
+	var creds struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+	_ = json.Unmarshal(body, &creds)
+	u := s.userByEmailLocked(creds.Email)
+	if u == nil || u.Password == "" || u.Password != creds.Password || u.Disabled {
+		writeError(w, http.StatusUnauthorized, "Wrong username or password. Do you have caps lock on?")
+		return
+	}
+	s.issueCookieLocked(w, u)
+	s.respond(w, http.StatusOK, map[string]any{"data": u})
+}
+
+func (s *Server) listUsers(r *http.Request) (int, any) {
+	query := r.URL.Query()
+	var filter struct {
+		Email string `json:"email"`
+	}
+	if raw := query.Get("filter"); raw != "" && !s.ignoreFilter {
+		_ = json.Unmarshal([]byte(raw), &filter)
+	}
+	// Stable order: by ID.
+	var users []User
+	for _, u := range s.users {
+		if filter.Email == "" || strings.EqualFold(u.Email, filter.Email) {
+			users = append(users, *u)
+		}
+	}
+	sortUsers(users)
+	skip, _ := strconv.Atoi(query.Get("skip"))
+	take, err := strconv.Atoi(query.Get("take"))
+	if err != nil || take <= 0 {
+		take = len(users)
+	}
+	page := []User{}
+	if skip < len(users) {
+		end := skip + take
+		if end > len(users) {
+			end = len(users)
+		}
+		page = users[skip:end]
+	}
+	return http.StatusOK, map[string]any{"data": map[string]any{"count": len(users), "items": page}}
+}
+
+func (s *Server) invite(body []byte) (int, any) {
+	var invites []User
+	if err := json.Unmarshal(body, &invites); err != nil || len(invites) == 0 {
+		return http.StatusBadRequest, "Invalid payload"
+	}
+	var result []map[string]User
+	for _, invite := range invites {
+		if invite.Email == "" {
+			return http.StatusBadRequest, "Invalid email"
+		}
+		if s.userByEmailLocked(invite.Email) != nil {
+			return http.StatusBadRequest, "The user " + invite.Email + " already exists"
+		}
+		u := &User{ID: s.newIDLocked("user"), Email: invite.Email, FirstName: invite.FirstName, LastName: invite.LastName, Role: invite.Role, IsPending: true}
+		if u.Role == "" {
+			u.Role = "global:member"
+		}
+		s.users[u.ID] = u
+		result = append(result, map[string]User{"user": *u})
+	}
+	return http.StatusOK, map[string]any{"data": result}
+}
+
+func (s *Server) acceptInvitation(w http.ResponseWriter, id string, body []byte) {
+	u, ok := s.users[id]
+	if !ok || !u.IsPending {
+		writeError(w, http.StatusBadRequest, "Invalid invitation")
+		return
+	}
+	var payload struct {
+		FirstName string `json:"firstName"`
+		LastName  string `json:"lastName"`
+		Password  string `json:"password"`
+	}
+	_ = json.Unmarshal(body, &payload)
+	u.IsPending = false
+	u.Password = payload.Password
+	u.FirstName, u.LastName = payload.FirstName, payload.LastName
+	s.issueCookieLocked(w, u)
+	s.respond(w, http.StatusOK, map[string]any{"data": u})
+}
+
+func (s *Server) changePassword(body []byte) (int, any) {
+	var payload struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	_ = json.Unmarshal(body, &payload)
+	id, ok := s.resetTokens[payload.Token]
+	if !ok {
+		return http.StatusBadRequest, "Invalid token"
+	}
+	delete(s.resetTokens, payload.Token)
+	if u, ok := s.users[id]; ok {
+		u.Password = payload.Password
+	}
+	return http.StatusOK, map[string]bool{"success": true}
+}
+
+func (s *Server) updateUser(id string, update func(*User)) (int, any) {
+	u, ok := s.users[id]
+	if !ok {
+		return http.StatusNotFound, "User not found"
+	}
+	update(u)
+	return http.StatusOK, map[string]bool{"success": true}
+}
+
+func (s *Server) addProjectMembers(projectID string, body []byte) (int, any) {
+	if _, ok := s.projects[projectID]; !ok {
+		return http.StatusNotFound, "Project not found"
+	}
+	var payload struct {
+		Relations []struct {
+			UserID string `json:"userId"`
+			Role   string `json:"role"`
+		} `json:"relations"`
+	}
+	_ = json.Unmarshal(body, &payload)
+	if s.projectMembers[projectID] == nil {
+		s.projectMembers[projectID] = map[string]string{}
+	}
+	for _, relation := range payload.Relations {
+		if _, ok := s.projectMembers[projectID][relation.UserID]; ok {
+			return http.StatusBadRequest, "User is already a member of this project"
+		}
+		s.projectMembers[projectID][relation.UserID] = relation.Role
+	}
+	return http.StatusCreated, map[string]bool{"success": true}
+}
+
+func (s *Server) issueCookieLocked(w http.ResponseWriter, u *User) {
+	value := s.newIDLocked("cookie")
+	s.cookies[value] = u.ID
+	http.SetCookie(w, &http.Cookie{Name: "n8n-auth", Value: value, MaxAge: s.cookieMaxAge, HttpOnly: true})
+}
+
+func (s *Server) callerLocked(r *http.Request) *User {
+	cookie, err := r.Cookie("n8n-auth")
+	if err != nil {
+		return nil
+	}
+	return s.users[s.cookies[cookie.Value]]
+}
+
+func (s *Server) respond(w http.ResponseWriter, status int, resp any) {
+	if status >= 400 {
+		message, _ := resp.(string)
+		writeError(w, status, message)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) userByEmailLocked(email string) *User {
+	for _, u := range s.users {
+		if strings.EqualFold(u.Email, email) {
+			return u
+		}
+	}
+	return nil
+}
+
+func (s *Server) newIDLocked(kind string) string {
+	s.nextID++
+	return fmt.Sprintf("%s-%d", kind, s.nextID)
+}
+
+// match reports whether path segments match the pattern; "*" matches any
+// single non-empty segment.
+func match(parts []string, pattern ...string) bool {
+	if len(parts) != len(pattern) {
+		return false
+	}
+	for i, p := range pattern {
+		if p == "*" && parts[i] != "" {
+			continue
+		}
+		if p != parts[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func sortUsers(users []User) {
+	for i := 1; i < len(users); i++ {
+		for j := i; j > 0 && users[j].ID < users[j-1].ID; j-- {
+			users[j], users[j-1] = users[j-1], users[j]
+		}
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]any{"code": status, "message": message})
+}
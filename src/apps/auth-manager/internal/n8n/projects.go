@@ -0,0 +1,77 @@
+package n8n
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ProjectRoleEditor lets project members create and edit its workflows.
+const ProjectRoleEditor = "project:editor"
+
+// Project is an n8n project. Type is "personal" or "team".
+type Project struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// ListProjects returns the projects visible to the owner.
+func (c *Client) ListProjects(ctx context.Context) ([]Project, error) {
+	var projects []Project
+	err := c.asOwner(ctx, func(owner credential) error {
+		projects = nil
+		if !c.usesAPIKey() {
+			var result struct {
+				Data []Project `json:"data"`
+			}
+			err := c.call(ctx, http.MethodGet, "/rest/projects", owner, nil, &result, "list_projects")
+			projects = result.Data
+			return err
+		}
+		// The public API pages with a cursor.
+		cursor := ""
+		for {
+			query := url.Values{"limit": {"100"}}
+			if cursor != "" {
+				query.Set("cursor", cursor)
+			}
+			var result struct {
+				Data       []Project `json:"data"`
+				NextCursor string    `json:"nextCursor"`
+			}
+			if err := c.call(ctx, http.MethodGet, "/api/v1/projects?"+query.Encode(), owner, nil, &result, "list_projects"); err != nil {
+				return err
+			}
+			projects = append(projects, result.Data...)
+			if result.NextCursor == "" {
+				return nil
+			}
+			cursor = result.NextCursor
+		}
+	})
+	return projects, err
+}
+
+// AddUserToProject makes the user a member of the project with role. A user
+// who is already a member is not an error.
+func (c *Client) AddUserToProject(ctx context.Context, projectID, userID, role string) error {
+	path := "/rest/projects/" + url.PathEscape(projectID) + "/users"
+	if c.usesAPIKey() {
+		path = "/api/v1/projects/" + url.PathEscape(projectID) + "/users"
+	}
+	payload := map[string]any{
+		"relations": []map[string]string{{"userId": userID, "role": role}},
+	}
+	err := c.asOwner(ctx, func(owner credential) error {
+		return c.call(ctx, http.MethodPost, path, owner, payload, nil, "add_project_user")
+	})
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusBadRequest || apiErr.StatusCode == http.StatusConflict) &&
+		strings.Contains(strings.ToLower(apiErr.Message), "already") {
+		return nil
+	}
+	return err
+}
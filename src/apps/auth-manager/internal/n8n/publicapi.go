@@ -0,0 +1,57 @@
+package n8n
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// lookupUser finds a user by email through whichever API the client uses.
+func (c *Client) lookupUser(ctx context.Context, owner credential, email string) (User, error) {
+	if c.usesAPIKey() {
+		return c.getPublicUser(ctx, owner, email)
+	}
+	return c.getUserByEmail(ctx, owner, email)
+}
+
+// getPublicUser looks a user up by email (or ID) through the public API.
+func (c *Client) getPublicUser(ctx context.Context, owner credential, idOrEmail string) (User, error) {
+	var user User
+	path := "/api/v1/users/" + url.PathEscape(idOrEmail) + "?includeRole=true"
+	if err := c.call(ctx, http.MethodGet, path, owner, nil, &user, "get_user"); err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
+
+// createPublicUser invites the identity through the public API, which
+// can't set names.
+func (c *Client) createPublicUser(ctx context.Context, owner credential, ident Identity) (User, error) {
+	role := ident.Role
+	if role == "" {
+		role = RoleMember
+	}
+	payload := []map[string]string{{"email": ident.Email, "role": role}}
+	var result []struct {
+		User  User   `json:"user"`
+		Error string `json:"error"`
+	}
+	if err := c.call(ctx, http.MethodPost, "/api/v1/users", owner, payload, &result, "invite"); err != nil {
+		return User{}, err
+	}
+	if len(result) == 0 {
+		return User{}, errors.New("no user returned from invite")
+	}
+	if result[0].Error != "" {
+		return User{}, fmt.Errorf("n8n invite failed: %s", result[0].Error)
+	}
+
+	user := result[0].User
+	user.IsPending = true
+	if user.Role == "" {
+		user.Role = role
+	}
+	return user, nil
+}
@@ -0,0 +1,195 @@
+package n8n
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/accounts"
+)
+
+// ErrSessionUnsupported is returned by IssueSession when the n8n instance
+// lacks the owner password-reset endpoints it relies on.
+var ErrSessionUnsupported = errors.New("n8n does not support owner-issued sessions")
+
+// IssueSession logs the user in on their behalf. Invited users don't have a
+// password, so the owner session completes their invitation (pending users)
+// or resets their password to a random one (active users), and the resulting
+// n8n-auth cookie is returned. Existing user passwords stop working.
+func (c *Client) IssueSession(ctx context.Context, user User) (Session, error) {
+	if user.ID == "" || user.Email == "" {
+		return Session{}, errors.New("n8n user id and email required")
+	}
+	if c.usesAPIKey() {
+		return Session{}, ErrSessionUnsupported
+	}
+
+	password, err := accounts.RandomPassword(24, accounts.Symbols)
+	if err != nil {
+		return Session{}, fmt.Errorf("generate n8n password: %w", err)
+	}
+	if user.IsPending {
+		owner, err := c.Owner(ctx)
+		if err != nil {
+			return Session{}, err
+		}
+		cookie, err := c.acceptInvitation(ctx, owner.ID, user, password)
+		if err != nil {
+			return Session{}, err
+		}
+		return Session{UserID: user.ID, Cookie: cookie.Value, ExpiresAt: cookieExpiry(cookie, time.Now())}, nil
+	}
+
+	var token string
+	err = c.asOwner(ctx, func(owner credential) error {
+		var err error
+		token, err = c.passwordResetToken(ctx, owner, user.ID)
+		return err
+	})
+	if err != nil {
+		return Session{}, err
+	}
+	if err := c.changePassword(ctx, user.ID, token, password); err != nil {
+		return Session{}, err
+	}
+	session, err := c.CreateSession(ctx, user.Email, password)
+	if err != nil {
+		return Session{}, fmt.Errorf("user login failed: %w", err)
+	}
+	session.UserID = user.ID
+	return session, nil
+}
+
+// currentUser returns the user the credential belongs to.
+func (c *Client) currentUser(ctx context.Context, cred credential) (User, error) {
+	var result struct {
+		Data User `json:"data"`
+	}
+	if err := c.call(ctx, http.MethodGet, "/rest/login", cred, nil, &result, "current_user"); err != nil {
+		return User{}, err
+	}
+	return result.Data, nil
+}
+
+// passwordResetToken asks n8n for the user's password reset link and returns
+// its token.
+func (c *Client) passwordResetToken(ctx context.Context, owner credential, userID string) (string, error) {
+	var result struct {
+		Data struct {
+			Link string `json:"link"`
+		} `json:"data"`
+	}
+	path := "/rest/users/" + url.PathEscape(userID) + "/password-reset-link"
+	if err := c.call(ctx, http.MethodGet, path, owner, nil, &result, "password_reset_link"); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return "", ErrSessionUnsupported
+		}
+		return "", err
+	}
+	link, err := url.Parse(result.Data.Link)
+	if err != nil || link.Query().Get("token") == "" {
+		return "", fmt.Errorf("n8n password reset link has no token: %q", result.Data.Link)
+	}
+	return link.Query().Get("token"), nil
+}
+
+func (c *Client) changePassword(ctx context.Context, userID, token, password string) error {
+	payload := map[string]string{
+		"token":    token,
+		"userId":   userID, // Older n8n versions require it alongside the token
+		"password": password,
+	}
+	return c.call(ctx, http.MethodPost, "/rest/change-password", nil, payload, nil, "change_password")
+}
+
+// acceptInvitation completes a pending user's sign-up and returns the
+// session cookie n8n sets for them.
+func (c *Client) acceptInvitation(ctx context.Context, inviterID string, user User, password string) (*http.Cookie, error) {
+	payload := map[string]string{
+		"inviterId": inviterID,
+		"firstName": user.FirstName,
+		"lastName":  user.LastName,
+		"password":  password,
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	path := "/rest/invitations/" + url.PathEscape(user.ID) + "/accept"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.send(req, "accept_invitation")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrSessionUnsupported
+	}
+	if resp.StatusCode >= 400 {
+		return nil, newAPIError(resp)
+	}
+	if cookie := authCookie(resp); cookie != nil {
+		return cookie, nil
+	}
+	return nil, errors.New("no n8n-auth cookie in accept invitation response")
+}
+
+// call sends a JSON request, authenticated with cred when set, and decodes
+// a JSON response into dest. Error responses return an *APIError.
+func (c *Client) call(ctx context.Context, method, path string, cred credential, payload, dest any, operation string) error {
+	var reader io.Reader
+	if payload != nil {
+		body, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if cred != nil {
+		cred.apply(req)
+	}
+
+	resp, err := c.send(req, operation)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return newAPIError(resp)
+	}
+	if dest != nil {
+		return json.NewDecoder(resp.Body).Decode(dest)
+	}
+	return nil
+}
+
+// cookieExpiry returns when cookie expires, preferring Max-Age over Expires,
+// or the zero time when it sets neither.
+func cookieExpiry(cookie *http.Cookie, now time.Time) time.Time {
+	if cookie.MaxAge > 0 {
+		return now.Add(time.Duration(cookie.MaxAge) * time.Second)
+	}
+	if !cookie.Expires.IsZero() {
+		return cookie.Expires
+	}
+	return time.Time{}
+}
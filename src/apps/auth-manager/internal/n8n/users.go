@@ -0,0 +1,93 @@
+package n8n
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ErrUserOwnsWorkflows is returned by DeleteUser when n8n refuses to delete a
+// user because of workflows or credentials it couldn't hand over.
+var ErrUserOwnsWorkflows = errors.New("n8n user owns workflows that could not be transferred")
+
+// GetUserByEmail looks up a user by email, returning ErrNotFound when n8n
+// has no such user.
+func (c *Client) GetUserByEmail(ctx context.Context, email string) (User, error) {
+	var user User
+	err := c.asOwner(ctx, func(owner credential) error {
+		var err error
+		user, err = c.lookupUser(ctx, owner, email)
+		return err
+	})
+	return user, err
+}
+
+// Owner returns the owner account the client manages users with.
+func (c *Client) Owner(ctx context.Context) (User, error) {
+	if c.usesAPIKey() {
+		return User{}, ErrOwnerLoginRequired
+	}
+	var owner User
+	err := c.asOwner(ctx, func(cred credential) error {
+		var err error
+		owner, err = c.currentUser(ctx, cred)
+		return err
+	})
+	return owner, err
+}
+
+// DisableUser marks the user disabled so n8n rejects their logins. Their
+// workflows keep running.
+func (c *Client) DisableUser(ctx context.Context, userID string) error {
+	if c.usesAPIKey() {
+		return ErrOwnerLoginRequired
+	}
+	payload := map[string]bool{"disabled": true}
+	path := "/rest/users/" + url.PathEscape(userID) + "/settings"
+	return c.asOwner(ctx, func(owner credential) error {
+		return c.call(ctx, http.MethodPatch, path, owner, payload, nil, "disable_user")
+	})
+}
+
+// DeleteUser deletes the user, handing their workflows and credentials to
+// transferToUserID. An empty transferToUserID deletes them along with the
+// user.
+func (c *Client) DeleteUser(ctx context.Context, userID, transferToUserID string) error {
+	path := "/rest/users/" + url.PathEscape(userID)
+	if c.usesAPIKey() {
+		// The public API deletes without transferring.
+		if transferToUserID != "" {
+			return ErrOwnerLoginRequired
+		}
+		path = "/api/v1/users/" + url.PathEscape(userID)
+	}
+	if transferToUserID != "" {
+		path += "?" + url.Values{"transferId": {transferToUserID}}.Encode()
+	}
+	return c.asOwner(ctx, func(owner credential) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodDelete, c.baseURL+path, nil)
+		if err != nil {
+			return err
+		}
+		owner.apply(req)
+
+		resp, err := c.send(req, "delete_user")
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode < 400 {
+			return nil
+		}
+		apiErr := newAPIError(resp)
+		if (apiErr.StatusCode == http.StatusBadRequest || apiErr.StatusCode == http.StatusConflict) &&
+			strings.Contains(strings.ToLower(apiErr.Message), "workflow") {
+			return fmt.Errorf("%w: %w", ErrUserOwnsWorkflows, apiErr)
+		}
+		return apiErr
+	})
+}
@@ -0,0 +1,393 @@
+// Package outbound notifies other systems of auth-manager's events by
+// posting them, signed, to their webhooks. Each target has its own bounded
+// queue and worker, so a slow or failing target only delays itself, and
+// failed deliveries are retried with backoff.
+package outbound
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The events auth-manager sends.
+const (
+	EventUserProvisioned     = "user.provisioned"
+	EventUserDeprovisioned   = "user.deprovisioned"
+	EventUserProvisionFailed = "user.provision_failed"
+)
+
+// EventTypes lists every event, for validating targets' event filters.
+var EventTypes = []string{EventUserProvisioned, EventUserDeprovisioned, EventUserProvisionFailed}
+
+// Headers sent with each delivery. SignatureHeader is the hex HMAC-SHA256
+// of the body keyed with the target's secret; DeliveryHeader is the event
+// ID, which stays the same across retries.
+const (
+	SignatureHeader = "X-Rave-Signature"
+	EventHeader     = "X-Rave-Event"
+	DeliveryHeader  = "X-Rave-Delivery"
+)
+
+// Results passed to Settings.OnResult.
+const (
+	ResultDelivered = "delivered"
+	ResultFailed    = "failed"  // refused, or out of attempts
+	ResultDropped   = "dropped" // queue full, or shut down before sending
+)
+
+// Target is a webhook to notify.
+type Target struct {
+	Name   string
+	URL    string
+	Secret string
+	// Events limits the events sent to these types; empty sends them all.
+	Events []string
+}
+
+func (t Target) wants(eventType string) bool {
+	if len(t.Events) == 0 {
+		return true
+	}
+	for _, e := range t.Events {
+		if e == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Event is the JSON body of a delivery.
+type Event struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request_id,omitempty"`
+	Data      any       `json:"data"`
+}
+
+// Settings tune a Dispatcher. Zero values take the defaults.
+type Settings struct {
+	QueueSize   int           // deliveries waiting per target; 256
+	MaxAttempts int           // per delivery; 5
+	Backoff     time.Duration // before the first retry, doubling after; 1s
+	MaxBackoff  time.Duration // 1m
+	Timeout     time.Duration // per attempt; 10s
+
+	// OnResult, when set, is called as each delivery finishes, with one
+	// of the Result constants. OnRetry is called before each retry.
+	OnResult func(target, result string)
+	OnRetry  func(target string)
+}
+
+func (s Settings) withDefaults() Settings {
+	if s.QueueSize <= 0 {
+		s.QueueSize = 256
+	}
+	if s.MaxAttempts <= 0 {
+		s.MaxAttempts = 5
+	}
+	if s.Backoff <= 0 {
+		s.Backoff = time.Second
+	}
+	if s.MaxBackoff <= 0 {
+		s.MaxBackoff = time.Minute
+	}
+	s.MaxBackoff = max(s.MaxBackoff, s.Backoff)
+	if s.Timeout <= 0 {
+		s.Timeout = 10 * time.Second
+	}
+	return s
+}
+
+// Status is a target's delivery record.
+type Status struct {
+	Target      string     `json:"target"`
+	URL         string     `json:"url"`
+	Events      []string   `json:"events,omitempty"`
+	Queued      int        `json:"queued"`
+	Delivered   int64      `json:"delivered"`
+	Failed      int64      `json:"failed"`
+	Dropped     int64      `json:"dropped"`
+	LastEvent   string     `json:"last_event,omitempty"`
+	LastAttempt *time.Time `json:"last_attempt,omitempty"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	LastStatus  int        `json:"last_status,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+}
+
+// Dispatcher delivers events to targets. A nil *Dispatcher drops events,
+// so callers needn't check whether any targets are configured.
+type Dispatcher struct {
+	client   *http.Client
+	settings Settings
+	logger   *slog.Logger
+	workers  []*worker
+
+	quit     chan struct{} // closed when Shutdown gives up on the queues
+	quitOnce sync.Once
+	wg       sync.WaitGroup
+
+	mu     sync.Mutex
+	closed bool
+}
+
+type worker struct {
+	target Target
+	queue  chan delivery
+
+	mu     sync.Mutex
+	status Status
+}
+
+type delivery struct {
+	id, eventType string
+	body          []byte
+}
+
+// New starts a Dispatcher with a worker for each target.
+func New(targets []Target, client *http.Client, settings Settings, logger *slog.Logger) *Dispatcher {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if logger == nil {
+		logger = slog.Default()
+	}
+	d := &Dispatcher{client: client, settings: settings.withDefaults(), logger: logger, quit: make(chan struct{})}
+	for _, target := range targets {
+		w := &worker{
+			target: target,
+			queue:  make(chan delivery, d.settings.QueueSize),
+			status: Status{Target: target.Name, URL: redactURL(target.URL), Events: target.Events},
+		}
+		d.workers = append(d.workers, w)
+		d.wg.Add(1)
+		go d.run(w)
+	}
+	return d
+}
+
+// Publish queues event for every target that wants it, filling in its ID
+// and time when unset. It never blocks: a target whose queue is full drops
+// the event.
+func (d *Dispatcher) Publish(event Event) {
+	if d == nil {
+		return
+	}
+	if event.ID == "" {
+		event.ID = newEventID()
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		d.logger.Error("encode outbound event", "event", event.Type, "err", err)
+		return
+	}
+	next := delivery{id: event.ID, eventType: event.Type, body: body}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, w := range d.workers {
+		if !w.target.wants(event.Type) {
+			continue
+		}
+		if d.closed {
+			d.finish(w, next, ResultDropped)
+			continue
+		}
+		select {
+		case w.queue <- next:
+		default:
+			d.logger.Warn("outbound webhook queue full, dropping event", "target", w.target.Name, "event", event.Type, "id", event.ID)
+			d.finish(w, next, ResultDropped)
+		}
+	}
+}
+
+// Status reports each target, sorted by name.
+func (d *Dispatcher) Status() []Status {
+	if d == nil {
+		return nil
+	}
+	statuses := make([]Status, 0, len(d.workers))
+	for _, w := range d.workers {
+		w.mu.Lock()
+		status := w.status
+		w.mu.Unlock()
+		status.Queued = len(w.queue)
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Target < statuses[j].Target })
+	return statuses
+}
+
+// Shutdown stops accepting events and delivers those queued until ctx
+// ends, when the rest are dropped.
+func (d *Dispatcher) Shutdown(ctx context.Context) error {
+	if d == nil {
+		return nil
+	}
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		for _, w := range d.workers {
+			close(w.queue)
+		}
+	}
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		d.quitOnce.Do(func() { close(d.quit) })
+		<-done
+		return fmt.Errorf("deliver outbound webhooks: %w", ctx.Err())
+	}
+}
+
+func (d *Dispatcher) run(w *worker) {
+	defer d.wg.Done()
+	for next := range w.queue {
+		select {
+		case <-d.quit:
+			d.finish(w, next, ResultDropped)
+			continue
+		default:
+		}
+		d.finish(w, next, d.deliver(w, next))
+	}
+}
+
+// deliver sends next, retrying until it's accepted, refused, out of
+// attempts, or the dispatcher quits.
+func (d *Dispatcher) deliver(w *worker, next delivery) string {
+	backoff := d.settings.Backoff
+	for attempt := 1; ; attempt++ {
+		status, retry, err := d.send(w.target, next)
+
+		now := time.Now().UTC()
+		w.mu.Lock()
+		w.status.LastAttempt, w.status.LastStatus, w.status.LastEvent = &now, status, next.eventType
+		if err == nil {
+			w.status.LastSuccess, w.status.LastError = &now, ""
+		} else {
+			w.status.LastError = err.Error()
+		}
+		w.mu.Unlock()
+
+		switch {
+		case err == nil:
+			return ResultDelivered
+		case !retry || attempt >= d.settings.MaxAttempts:
+			d.logger.Warn("outbound webhook delivery failed", "target", w.target.Name, "event", next.eventType, "id", next.id, "attempts", attempt, "err", err)
+			return ResultFailed
+		}
+		if d.settings.OnRetry != nil {
+			d.settings.OnRetry(w.target.Name)
+		}
+		select {
+		case <-time.After(backoff):
+		case <-d.quit:
+			return ResultDropped
+		}
+		backoff = min(2*backoff, d.settings.MaxBackoff)
+	}
+}
+
+// send makes one attempt, returning the response status and whether a
+// failure is worth retrying: network errors, 429, and 5xx are.
+func (d *Dispatcher) send(target Target, next delivery) (status int, retry bool, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d.settings.Timeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-d.quit:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target.URL, bytes.NewReader(next.body))
+	if err != nil {
+		return 0, false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, next.eventType)
+	req.Header.Set(DeliveryHeader, next.id)
+	req.Header.Set(SignatureHeader, Sign(target.Secret, next.body))
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, true, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode/100 == 2 {
+		return resp.StatusCode, false, nil
+	}
+	retry = resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return resp.StatusCode, retry, fmt.Errorf("target answered %s", resp.Status)
+}
+
+func (d *Dispatcher) finish(w *worker, next delivery, result string) {
+	w.mu.Lock()
+	switch result {
+	case ResultDelivered:
+		w.status.Delivered++
+	case ResultFailed:
+		w.status.Failed++
+	case ResultDropped:
+		w.status.Dropped++
+	}
+	w.mu.Unlock()
+	if d.settings.OnResult != nil {
+		d.settings.OnResult(w.target.Name, result)
+	}
+}
+
+// Sign returns the hex HMAC-SHA256 of body keyed with secret, the value of
+// SignatureHeader.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// newEventID returns a random (version 4) UUID.
+func newEventID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(fmt.Errorf("generate event ID: %w", err))
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// redactURL masks the password in raw for Status.
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	return u.Redacted()
+}
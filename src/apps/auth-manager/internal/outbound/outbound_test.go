@@ -0,0 +1,182 @@
+package outbound
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// results collects OnResult calls.
+type results struct {
+	mu  sync.Mutex
+	got []string
+}
+
+func (r *results) record(target, result string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.got = append(r.got, target+" "+result)
+}
+
+func (r *results) wait(t *testing.T, n int) []string {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		r.mu.Lock()
+		got := append([]string(nil), r.got...)
+		r.mu.Unlock()
+		if len(got) >= n || time.Now().After(deadline) {
+			if len(got) != n {
+				t.Fatalf("results = %q, want %d", got, n)
+			}
+			return got
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestDelivery(t *testing.T) {
+	type received struct {
+		header http.Header
+		body   []byte
+	}
+	bodies := make(chan received, 1)
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies <- received{r.Header, body}
+	}))
+	defer target.Close()
+
+	var res results
+	d := New([]Target{
+		{Name: "cmdb", URL: target.URL + "/hook", Secret: "s3cret"},
+		{Name: "failures-only", URL: target.URL, Secret: "other", Events: []string{EventUserProvisionFailed}},
+	}, target.Client(), Settings{OnResult: res.record}, nil)
+	d.Publish(Event{Type: EventUserProvisioned, RequestID: "req-1", Data: map[string]string{"email": "dev@example.com"}})
+
+	got := <-bodies
+	if want := Sign("s3cret", got.body); got.header.Get(SignatureHeader) != want {
+		t.Errorf("signature = %q, want %q", got.header.Get(SignatureHeader), want)
+	}
+	var event Event
+	if err := json.Unmarshal(got.body, &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Type != EventUserProvisioned || event.RequestID != "req-1" || event.ID == "" || event.Time.IsZero() {
+		t.Errorf("event = %+v", event)
+	}
+	if got.header.Get(EventHeader) != EventUserProvisioned || got.header.Get(DeliveryHeader) != event.ID {
+		t.Errorf("headers = %v", got.header)
+	}
+	if r := res.wait(t, 1); r[0] != "cmdb delivered" {
+		t.Errorf("results = %q, want only cmdb to get the event", r)
+	}
+
+	status := d.Status()
+	if len(status) != 2 || status[0].Target != "cmdb" || status[0].Delivered != 1 || status[0].LastSuccess == nil || status[0].LastStatus != http.StatusOK {
+		t.Errorf("status = %+v", status)
+	}
+	if status[1].Target != "failures-only" || status[1].LastAttempt != nil {
+		t.Errorf("status = %+v, want failures-only untouched", status[1])
+	}
+	if err := d.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	d.Publish(Event{Type: EventUserProvisioned})
+	if r := res.wait(t, 2); r[1] != "cmdb dropped" {
+		t.Errorf("publish after shutdown: results = %q", r)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	var calls atomic.Int32
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/flaky":
+			if calls.Add(1) < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		case "/refuses":
+			w.WriteHeader(http.StatusBadRequest)
+		default:
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer target.Close()
+
+	var res results
+	var retries atomic.Int32
+	d := New([]Target{
+		{Name: "flaky", URL: target.URL + "/flaky"},
+		{Name: "refuses", URL: target.URL + "/refuses"},
+		{Name: "down", URL: target.URL + "/down"},
+	}, target.Client(), Settings{
+		MaxAttempts: 3,
+		Backoff:     time.Millisecond,
+		OnResult:    res.record,
+		OnRetry:     func(string) { retries.Add(1) },
+	}, nil)
+	defer d.Shutdown(context.Background())
+	d.Publish(Event{Type: EventUserDeprovisioned})
+
+	got := map[string]bool{}
+	for _, r := range res.wait(t, 3) {
+		got[r] = true
+	}
+	for _, want := range []string{"flaky delivered", "refuses failed", "down failed"} {
+		if !got[want] {
+			t.Errorf("results %v missing %q", got, want)
+		}
+	}
+	// flaky and down retry twice each; a 400 isn't retried.
+	if n := retries.Load(); n != 4 {
+		t.Errorf("retries = %d, want 4", n)
+	}
+	for _, status := range d.Status() {
+		if status.Target == "down" && (status.LastStatus != http.StatusBadGateway || status.LastError == "" || status.Failed != 1) {
+			t.Errorf("down status = %+v", status)
+		}
+	}
+}
+
+func TestQueueBoundsAndShutdown(t *testing.T) {
+	release := make(chan struct{})
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer target.Close()
+	defer close(release)
+
+	var res results
+	d := New([]Target{{Name: "slow", URL: target.URL}}, target.Client(), Settings{QueueSize: 1, OnResult: res.record}, nil)
+	d.Publish(Event{Type: EventUserProvisioned}) // in flight
+	deadline := time.Now().Add(2 * time.Second)
+	for d.Status()[0].Queued != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	d.Publish(Event{Type: EventUserProvisioned}) // queued
+	d.Publish(Event{Type: EventUserProvisioned}) // over the bound
+	if r := res.wait(t, 1); r[0] != "slow dropped" {
+		t.Errorf("results = %q, want the third event dropped", r)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := d.Shutdown(ctx); err == nil {
+		t.Error("Shutdown returned nil with a delivery stuck")
+	}
+	// The stuck delivery was cut off and the queued one dropped.
+	res.wait(t, 3)
+	if status := d.Status()[0]; status.Dropped != 3 || status.Queued != 0 {
+		t.Errorf("status = %+v, want all 3 dropped", status)
+	}
+}
@@ -0,0 +1,207 @@
+// Package provision defines the interface downstream services implement to
+// receive users from auth-manager, and the Runner that applies an ordered
+// list of them, each behind its own circuit breaker.
+//
+// A fail-fast run that stops early still reports the remaining provisioners,
+// as skipped, so callers can see what wasn't attempted.
+package provision
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// CanonicalIdentity is a user as every provisioner sees it, whatever
+// identity source it came from.
+type CanonicalIdentity struct {
+	Provider string
+	Subject  string
+	Email    string
+	Name     string
+	Username string
+	// Groups the identity belongs to. nil means the source didn't report
+	// groups, which is distinct from an empty membership.
+	Groups []string
+	// ServiceAccount marks automation identities. Most provisioners skip
+	// them.
+	ServiceAccount bool
+	// ShadowID and Attributes are the user's shadow record as it stood when
+	// the run started.
+	ShadowID   string
+	Attributes map[string]string
+}
+
+// Provisioner is a downstream service that user accounts are created in and
+// removed from.
+type Provisioner interface {
+	// Name identifies the provisioner in configuration, results, and
+	// metrics, e.g. "mattermost".
+	Name() string
+	// Provision creates or updates the user's account.
+	Provision(ctx context.Context, ident CanonicalIdentity) error
+	// Deprovision deactivates or removes the user's account. Accounts that
+	// don't exist are not an error.
+	Deprovision(ctx context.Context, ident CanonicalIdentity) error
+	// Healthy reports whether the service is reachable with the configured
+	// credentials.
+	Healthy(ctx context.Context) error
+}
+
+// ErrSkipped, wrapped in a Provision or Deprovision error, reports that the
+// provisioner deliberately did nothing for the identity.
+var ErrSkipped = errors.New("skipped")
+
+// ErrCircuitOpen is reported for provisioners whose breaker is open.
+var ErrCircuitOpen = errors.New("circuit open")
+
+// errNotAttempted is reported for provisioners a fail-fast run stopped
+// before.
+var errNotAttempted = errors.New("not attempted after an earlier failure")
+
+// Status is the outcome of one provisioner in a run.
+type Status string
+
+const (
+	StatusOK      Status = "ok"
+	StatusSkipped Status = "skipped"
+	StatusFailed  Status = "failed"
+)
+
+// Result is one provisioner's outcome, in the shape the API reports it.
+type Result struct {
+	Provisioner string `json:"provisioner,omitempty"`
+	Status      Status `json:"status"`
+	Error       string `json:"error,omitempty"`
+}
+
+// Policy decides whether a failing provisioner stops the run.
+type Policy string
+
+const (
+	// FailFast stops at the first required provisioner that fails.
+	FailFast Policy = "fail-fast"
+	// BestEffort runs every provisioner and reports all failures.
+	BestEffort Policy = "best-effort"
+)
+
+// ParsePolicy parses a policy name; "" means FailFast.
+func ParsePolicy(name string) (Policy, error) {
+	switch Policy(name) {
+	case "", FailFast:
+		return FailFast, nil
+	case BestEffort:
+		return BestEffort, nil
+	}
+	return "", fmt.Errorf("unknown provision policy %q (use %s or %s)", name, FailFast, BestEffort)
+}
+
+// Breaker guards a provisioner against a failing service: while Allow
+// returns false the runner doesn't call the provisioner. Provisioners report
+// outcomes to their breaker themselves, since only they know which errors
+// are the service's fault.
+type Breaker interface {
+	Allow() bool
+}
+
+// Metrics receives the duration and status of each provisioner call.
+type Metrics interface {
+	ObserveProvisioner(name, operation string, status Status, duration time.Duration)
+}
+
+// Target is a provisioner in a Runner. Failures provisioning an Optional
+// target are reported but never fail the run; deprovisioning failures
+// always do, since they leave an account active. A nil Breaker never trips.
+// DeprovisionBreaker, when set, guards deprovisioning instead of Breaker.
+type Target struct {
+	Provisioner
+	Breaker            Breaker
+	DeprovisionBreaker Breaker
+	Optional           bool
+}
+
+// Runner applies its targets in order.
+type Runner struct {
+	targets []Target
+	policy  Policy
+	metrics Metrics
+}
+
+// NewRunner returns a Runner for targets. metrics may be nil.
+func NewRunner(policy Policy, metrics Metrics, targets ...Target) *Runner {
+	return &Runner{targets: targets, policy: policy, metrics: metrics}
+}
+
+// Targets returns the runner's targets in order.
+func (r *Runner) Targets() []Target {
+	return r.targets
+}
+
+// Skipped returns a skipped result carrying reason for every target, for
+// callers that stop before running any.
+func (r *Runner) Skipped(reason string) []Result {
+	results := make([]Result, 0, len(r.targets))
+	for _, target := range r.targets {
+		results = append(results, Result{Provisioner: target.Name(), Status: StatusSkipped, Error: reason})
+	}
+	return results
+}
+
+// Provision provisions ident into every target. A target whose breaker is
+// open is skipped. The error joins the failures of required targets.
+func (r *Runner) Provision(ctx context.Context, ident CanonicalIdentity) ([]Result, error) {
+	return r.run(ctx, "provision", ident, false)
+}
+
+// Deprovision deprovisions ident from every target. A target whose breaker
+// is open fails, since its account stays active. The error joins every
+// failure.
+func (r *Runner) Deprovision(ctx context.Context, ident CanonicalIdentity) ([]Result, error) {
+	return r.run(ctx, "deprovision", ident, true)
+}
+
+func (r *Runner) run(ctx context.Context, operation string, ident CanonicalIdentity, deprovision bool) ([]Result, error) {
+	results := make([]Result, 0, len(r.targets))
+	var errs []error
+	for _, target := range r.targets {
+		name := target.Name()
+		if len(errs) > 0 && r.policy != BestEffort {
+			results = append(results, Result{Provisioner: name, Status: StatusSkipped, Error: errNotAttempted.Error()})
+			continue
+		}
+
+		start := time.Now()
+		var err error
+		breaker := target.Breaker
+		if deprovision && target.DeprovisionBreaker != nil {
+			breaker = target.DeprovisionBreaker
+		}
+		if breaker != nil && !breaker.Allow() {
+			err = fmt.Errorf("%s: %w", name, ErrCircuitOpen)
+		} else if deprovision {
+			err = target.Deprovision(ctx, ident)
+		} else {
+			err = target.Provision(ctx, ident)
+		}
+
+		result := Result{Provisioner: name, Status: StatusOK}
+		switch {
+		case err == nil:
+		case errors.Is(err, ErrSkipped), errors.Is(err, ErrCircuitOpen) && !deprovision:
+			result.Status = StatusSkipped
+			result.Error = err.Error()
+		default:
+			result.Status = StatusFailed
+			result.Error = err.Error()
+			if deprovision || !target.Optional {
+				errs = append(errs, err)
+			}
+		}
+		if r.metrics != nil {
+			r.metrics.ObserveProvisioner(name, operation, result.Status, time.Since(start))
+		}
+		results = append(results, result)
+	}
+	return results, errors.Join(errs...)
+}
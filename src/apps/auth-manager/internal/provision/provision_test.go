@@ -0,0 +1,191 @@
+package provision
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fakeProvisioner returns err from Provision and Deprovision and counts
+// calls.
+type fakeProvisioner struct {
+	name  string
+	err   error
+	calls int
+}
+
+func (f *fakeProvisioner) Name() string { return f.name }
+
+func (f *fakeProvisioner) Provision(context.Context, CanonicalIdentity) error {
+	f.calls++
+	return f.err
+}
+
+func (f *fakeProvisioner) Deprovision(context.Context, CanonicalIdentity) error {
+	f.calls++
+	return f.err
+}
+
+func (f *fakeProvisioner) Healthy(context.Context) error { return nil }
+
+type openBreaker struct{}
+
+func (openBreaker) Allow() bool { return false }
+
+type closedBreaker struct{}
+
+func (closedBreaker) Allow() bool { return true }
+
+type recordingMetrics []string
+
+func (m *recordingMetrics) ObserveProvisioner(name, operation string, status Status, _ time.Duration) {
+	*m = append(*m, name+" "+operation+" "+string(status))
+}
+
+func statuses(results []Result) []Status {
+	var out []Status
+	for _, r := range results {
+		out = append(out, r.Status)
+	}
+	return out
+}
+
+func TestRunnerProvision(t *testing.T) {
+	down := errors.New("connection refused")
+	tests := []struct {
+		name    string
+		policy  Policy
+		targets func() []Target
+		want    []Status
+		wantErr bool
+	}{
+		{
+			name:   "all ok",
+			policy: FailFast,
+			targets: func() []Target {
+				return []Target{{Provisioner: &fakeProvisioner{name: "a"}}, {Provisioner: &fakeProvisioner{name: "b"}}}
+			},
+			want: []Status{StatusOK, StatusOK},
+		},
+		{
+			name:   "fail-fast stops after a required failure",
+			policy: FailFast,
+			targets: func() []Target {
+				return []Target{{Provisioner: &fakeProvisioner{name: "a", err: down}}, {Provisioner: &fakeProvisioner{name: "b"}}}
+			},
+			want:    []Status{StatusFailed, StatusSkipped},
+			wantErr: true,
+		},
+		{
+			name:   "best-effort keeps going",
+			policy: BestEffort,
+			targets: func() []Target {
+				return []Target{{Provisioner: &fakeProvisioner{name: "a", err: down}}, {Provisioner: &fakeProvisioner{name: "b"}}}
+			},
+			want:    []Status{StatusFailed, StatusOK},
+			wantErr: true,
+		},
+		{
+			name:   "optional failures don't fail the run",
+			policy: FailFast,
+			targets: func() []Target {
+				return []Target{{Provisioner: &fakeProvisioner{name: "a", err: down}, Optional: true}, {Provisioner: &fakeProvisioner{name: "b"}}}
+			},
+			want: []Status{StatusFailed, StatusOK},
+		},
+		{
+			name:   "open breaker skips",
+			policy: FailFast,
+			targets: func() []Target {
+				return []Target{{Provisioner: &fakeProvisioner{name: "a"}, Breaker: openBreaker{}}, {Provisioner: &fakeProvisioner{name: "b"}}}
+			},
+			want: []Status{StatusSkipped, StatusOK},
+		},
+		{
+			name:   "provisioner skips",
+			policy: FailFast,
+			targets: func() []Target {
+				return []Target{{Provisioner: &fakeProvisioner{name: "a", err: fmt.Errorf("%w: service account", ErrSkipped)}}}
+			},
+			want: []Status{StatusSkipped},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var metrics recordingMetrics
+			results, err := NewRunner(tt.policy, &metrics, tt.targets()...).Provision(context.Background(), CanonicalIdentity{Email: "a@example.com"})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Provision() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := statuses(results); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("statuses = %v, want %v (%+v)", got, tt.want, results)
+			}
+			if len(metrics) != len(tt.want)-countStatus(results, errNotAttempted) {
+				t.Errorf("observed %v, want one observation per attempted provisioner", metrics)
+			}
+		})
+	}
+}
+
+func countStatus(results []Result, reason error) int {
+	n := 0
+	for _, r := range results {
+		if r.Error == reason.Error() {
+			n++
+		}
+	}
+	return n
+}
+
+func TestRunnerDeprovision(t *testing.T) {
+	optional := &fakeProvisioner{name: "optional", err: errors.New("disable failed")}
+	guarded := &fakeProvisioner{name: "guarded"}
+	runner := NewRunner(BestEffort, nil,
+		Target{Provisioner: optional, Optional: true},
+		Target{Provisioner: guarded, Breaker: openBreaker{}},
+	)
+
+	results, err := runner.Deprovision(context.Background(), CanonicalIdentity{Email: "a@example.com"})
+	if err == nil {
+		t.Fatal("expected deprovisioning failures to fail the run")
+	}
+	if got, want := statuses(results), []Status{StatusFailed, StatusFailed}; !reflect.DeepEqual(got, want) {
+		t.Errorf("statuses = %v, want %v", got, want)
+	}
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("error = %v, want it to include ErrCircuitOpen", err)
+	}
+	if guarded.calls != 0 {
+		t.Errorf("guarded provisioner called %d times behind an open breaker", guarded.calls)
+	}
+}
+
+func TestRunnerDeprovisionBreaker(t *testing.T) {
+	split := &fakeProvisioner{name: "split"}
+	runner := NewRunner(FailFast, nil, Target{Provisioner: split, Breaker: openBreaker{}, DeprovisionBreaker: closedBreaker{}})
+
+	if _, err := runner.Deprovision(context.Background(), CanonicalIdentity{Email: "a@example.com"}); err != nil {
+		t.Fatalf("Deprovision: %v", err)
+	}
+	if split.calls != 1 {
+		t.Errorf("deprovisioner called %d times, want 1: its own breaker is closed", split.calls)
+	}
+	results, _ := runner.Provision(context.Background(), CanonicalIdentity{Email: "a@example.com"})
+	if got := statuses(results); !reflect.DeepEqual(got, []Status{StatusSkipped}) || split.calls != 1 {
+		t.Errorf("provision statuses = %v with %d calls, want skipped behind the open breaker", got, split.calls)
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	for name, want := range map[string]Policy{"": FailFast, "fail-fast": FailFast, "best-effort": BestEffort} {
+		if got, err := ParsePolicy(name); err != nil || got != want {
+			t.Errorf("ParsePolicy(%q) = %q, %v; want %q", name, got, err, want)
+		}
+	}
+	if _, err := ParsePolicy("sometimes"); err == nil {
+		t.Error("expected an error for an unknown policy")
+	}
+}
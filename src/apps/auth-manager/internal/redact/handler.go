@@ -0,0 +1,124 @@
+package redact
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+)
+
+// Secrets is a set of credentials to mask wherever they appear, safe for
+// concurrent use.
+type Secrets struct {
+	mu     sync.RWMutex
+	values []string
+}
+
+// Add adds values to the set. Secrets are never dropped, so one rotated out
+// stays masked in whatever still echoes it.
+func (s *Secrets) Add(values ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, v := range values {
+		if len(v) < minSecretLen || contains(s.values, v) {
+			continue
+		}
+		s.values = append(s.values, v)
+	}
+}
+
+// Text masks the set's secrets and credential-shaped text in text, as
+// the package's Text does.
+func (s *Secrets) Text(text string) string {
+	if s == nil {
+		return Text(text)
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return Text(text, s.values...)
+}
+
+func contains(values []string, v string) bool {
+	for _, have := range values {
+		if have == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Handler masks credentials in the records it passes to next: the message
+// and every string, error, and Stringer attribute go through secrets.Text.
+// It's the last line of defence for credentials that reach a log call
+// unmasked.
+type Handler struct {
+	next    slog.Handler
+	secrets *Secrets
+}
+
+// NewHandler returns a Handler masking secrets in next's records.
+func NewHandler(next slog.Handler, secrets *Secrets) *Handler {
+	return &Handler{next: next, secrets: secrets}
+}
+
+func (h *Handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *Handler) Handle(ctx context.Context, record slog.Record) error {
+	masked := slog.NewRecord(record.Time, record.Level, h.secrets.Text(record.Message), record.PC)
+	record.Attrs(func(attr slog.Attr) bool {
+		masked.AddAttrs(h.attr(attr))
+		return true
+	})
+	return h.next.Handle(ctx, masked)
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	masked := make([]slog.Attr, len(attrs))
+	for i, attr := range attrs {
+		masked[i] = h.attr(attr)
+	}
+	return &Handler{next: h.next.WithAttrs(masked), secrets: h.secrets}
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	return &Handler{next: h.next.WithGroup(name), secrets: h.secrets}
+}
+
+func (h *Handler) attr(attr slog.Attr) slog.Attr {
+	value := attr.Value.Resolve()
+	switch value.Kind() {
+	case slog.KindString:
+		attr.Value = slog.StringValue(h.secrets.Text(value.String()))
+	case slog.KindGroup:
+		group := value.Group()
+		masked := make([]slog.Attr, len(group))
+		for i, a := range group {
+			masked[i] = h.attr(a)
+		}
+		attr.Value = slog.GroupValue(masked...)
+	case slog.KindAny:
+		switch v := value.Any().(type) {
+		case error:
+			attr.Value = h.masked(value, v.Error())
+		case fmt.Stringer:
+			attr.Value = h.masked(value, v.String())
+		case []string:
+			masked := make([]string, len(v))
+			for i, s := range v {
+				masked[i] = h.secrets.Text(s)
+			}
+			attr.Value = slog.AnyValue(masked)
+		}
+	}
+	return attr
+}
+
+// masked returns value, or text masked when masking changes it.
+func (h *Handler) masked(value slog.Value, text string) slog.Value {
+	if masked := h.secrets.Text(text); masked != text {
+		return slog.StringValue(masked)
+	}
+	return value
+}
@@ -0,0 +1,182 @@
+// Package redact masks credentials in headers, queries, and free text
+// before they reach a log line or a response body.
+package redact
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Placeholder replaces a masked value.
+const Placeholder = "[REDACTED]"
+
+// Name reports whether a header, cookie, or query parameter name suggests
+// its value is a credential.
+func Name(name string) bool {
+	name = strings.ToLower(name)
+	switch name {
+	case "authorization", "proxy-authorization", "cookie", "set-cookie", "code":
+		return true
+	}
+	for _, word := range []string{"token", "secret", "password", "passwd", "key", "jwt", "signature", "assertion", "session"} {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// Header returns a header's values with credentials masked: Authorization
+// keeps its scheme, cookies keep their names, and other credential headers,
+// such as X-Authentik-Jwt, are masked whole.
+func Header(name string, values []string) []string {
+	var mask func(string) string
+	switch strings.ToLower(name) {
+	case "authorization", "proxy-authorization":
+		mask = Authorization
+	case "cookie", "set-cookie":
+		mask = Cookie
+	default:
+		if !Name(name) {
+			return values
+		}
+		mask = Token
+	}
+	masked := make([]string, len(values))
+	for i, v := range values {
+		masked[i] = mask(v)
+	}
+	return masked
+}
+
+// Token masks a credential whole. Empty values stay empty, so a missing
+// credential still reads as missing.
+func Token(value string) string {
+	if value == "" {
+		return ""
+	}
+	return Placeholder
+}
+
+// Authorization masks the credentials of an Authorization header value,
+// keeping the scheme: "Bearer abc" becomes "Bearer [REDACTED]".
+func Authorization(value string) string {
+	scheme, credentials, ok := strings.Cut(strings.TrimSpace(value), " ")
+	if !ok || strings.TrimSpace(credentials) == "" {
+		return Token(value)
+	}
+	return scheme + " " + Placeholder
+}
+
+// Cookie masks the values in a Cookie or Set-Cookie header value, keeping
+// the cookie names and, for Set-Cookie, its attributes.
+func Cookie(value string) string {
+	parts := strings.Split(value, ";")
+	for i, part := range parts {
+		name, v, ok := strings.Cut(part, "=")
+		if !ok || v == "" {
+			continue
+		}
+		if i > 0 && isCookieAttribute(name) {
+			continue
+		}
+		parts[i] = name + "=" + Placeholder
+	}
+	return strings.Join(parts, ";")
+}
+
+func isCookieAttribute(name string) bool {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "path", "domain", "expires", "max-age", "samesite":
+		return true
+	}
+	return false
+}
+
+// Query returns a raw query with sensitive parameters' values masked,
+// keeping the parameters' order. Parameter names that don't unescape are
+// masked too, since there's no telling what they hold.
+func Query(raw string) string {
+	params := strings.Split(raw, "&")
+	for i, param := range params {
+		key, _, _ := strings.Cut(param, "=")
+		if name, err := url.QueryUnescape(key); err != nil || Name(name) {
+			params[i] = key + "=" + Placeholder
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+var (
+	// credentialHeader matches a credential header echoed back in a request
+	// dump, as "Name: value" or JSON, and masks the rest of its line.
+	credentialHeader = regexp.MustCompile(`(?i)\b((?:proxy-)?authorization|(?:set-)?cookie|x-authentik-jwt)("?\s*[:=]\s*[\["]*)([^"\r\n]+)`)
+	// credentialParam matches name=value and "name": "value" pairs whose
+	// name says the value is a credential.
+	credentialParam = regexp.MustCompile(`(?i)([\w-]*(?:token|secret|password|passwd|apikey|api[_-]key|jwt)"?\s*[:=]\s*"?)([^\s"'&,;\]}]+)`)
+	// bearerCredential matches credentials following their scheme.
+	bearerCredential = regexp.MustCompile(`(?i)\b(?:bearer|basic)\s+([\w.~+/=-]{16,})`)
+)
+
+// minSecretLen is the shortest secret Text looks for: masking every "1" or
+// "on" would garble the text without hiding anything worth hiding.
+const minSecretLen = 6
+
+// Text masks credentials in free text, such as an upstream error body: each
+// of the given secrets wherever it appears, and anything shaped like a
+// credential header, a credential parameter, or a bearer token.
+func Text(s string, secrets ...string) string {
+	for _, secret := range secrets {
+		if len(secret) >= minSecretLen {
+			s = strings.ReplaceAll(s, secret, Placeholder)
+		}
+	}
+	s = mask(credentialHeader, s)
+	s = mask(credentialParam, s)
+	return mask(bearerCredential, s)
+}
+
+// mask replaces the last group of each of re's matches in s, keeping the
+// others. Matches already holding the placeholder are left alone, so text
+// can be masked twice.
+func mask(re *regexp.Regexp, s string) string {
+	return re.ReplaceAllStringFunc(s, func(match string) string {
+		groups := re.FindStringSubmatchIndex(match)
+		last := groups[len(groups)-2]
+		if strings.Contains(match[last:], strings.Trim(Placeholder, "[]")) {
+			return match
+		}
+		return match[:last] + Placeholder
+	})
+}
+
+// RequestSecrets returns the credentials r carried in its headers, so text
+// echoing r back, such as an error body, can be scrubbed of them with Text.
+func RequestSecrets(r *http.Request) []string {
+	if r == nil {
+		return nil
+	}
+	var secrets []string
+	for name, values := range r.Header {
+		switch strings.ToLower(name) {
+		case "cookie":
+			for _, c := range r.Cookies() {
+				secrets = append(secrets, c.Value)
+			}
+		case "authorization", "proxy-authorization":
+			for _, v := range values {
+				if _, credentials, ok := strings.Cut(v, " "); ok {
+					v = credentials
+				}
+				secrets = append(secrets, strings.TrimSpace(v))
+			}
+		default:
+			if Name(name) {
+				secrets = append(secrets, values...)
+			}
+		}
+	}
+	return secrets
+}
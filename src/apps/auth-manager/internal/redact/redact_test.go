@@ -0,0 +1,98 @@
+package redact
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestHeader(t *testing.T) {
+	for _, tc := range []struct {
+		name, value, want string
+	}{
+		{"Authorization", "Bearer abc.def", "Bearer [REDACTED]"},
+		{"Authorization", "abc", "[REDACTED]"},
+		{"Cookie", "MMAUTHTOKEN=abc; MMUSERID=42", "MMAUTHTOKEN=[REDACTED]; MMUSERID=[REDACTED]"},
+		{"Set-Cookie", "n8n-auth=abc; Path=/; HttpOnly", "n8n-auth=[REDACTED]; Path=/; HttpOnly"},
+		{"X-Authentik-Jwt", "eyJhbGciOi", "[REDACTED]"},
+		{"X-Authentik-Email", "dev@example.com", "dev@example.com"},
+	} {
+		if got := Header(tc.name, []string{tc.value}); got[0] != tc.want {
+			t.Errorf("Header(%s, %q) = %q, want %q", tc.name, tc.value, got[0], tc.want)
+		}
+	}
+}
+
+func TestQuery(t *testing.T) {
+	got := Query("email=dev%40example.com&access_token=abc&%zz=1")
+	if want := "email=dev%40example.com&access_token=[REDACTED]&%zz=[REDACTED]"; got != want {
+		t.Errorf("Query = %q, want %q", got, want)
+	}
+}
+
+func TestText(t *testing.T) {
+	for _, tc := range []struct {
+		in, want string
+	}{
+		{"request: GET /api\r\nAuthorization: Bearer s3cr3t-admin\r\nAccept: */*", "request: GET /api\r\nAuthorization: [REDACTED]\r\nAccept: */*"},
+		{`{"headers":{"Cookie":["MMAUTHTOKEN=abc"]}}`, `{"headers":{"Cookie":["[REDACTED]"]}}`},
+		{`{"token":"abc","id":"x"}`, `{"token":"[REDACTED]","id":"x"}`},
+		{"redirect to /login?session_token=abc&next=/", "redirect to /login?session_token=[REDACTED]&next=/"},
+		{"got bearer eyJhbGciOiJIUzI1NiJ9.e30 back", "got bearer [REDACTED] back"},
+		{"invalid email address", "invalid email address"},
+	} {
+		if got := Text(tc.in); got != tc.want {
+			t.Errorf("Text(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+		if got := Text(Text(tc.in)); got != tc.want {
+			t.Errorf("Text twice on %q = %q", tc.in, got)
+		}
+	}
+	if got := Text("echo: hunter22 and 42", "hunter22", "42"); got != "echo: [REDACTED] and 42" {
+		t.Errorf("Text with secrets = %q", got)
+	}
+}
+
+func TestRequestSecrets(t *testing.T) {
+	r, _ := http.NewRequest(http.MethodGet, "http://mattermost/api/v4/users", nil)
+	r.Header.Set("Authorization", "Bearer admin-token")
+	r.Header.Set("X-N8N-API-KEY", "n8n-api-key")
+	r.Header.Set("Accept", "application/json")
+	r.AddCookie(&http.Cookie{Name: "n8n-auth", Value: "cookie-value"})
+	got := strings.Join(RequestSecrets(r), " ")
+	for _, want := range []string{"admin-token", "n8n-api-key", "cookie-value"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("RequestSecrets = %q, missing %q", got, want)
+		}
+	}
+	if strings.Contains(got, "application/json") || strings.Contains(got, "Bearer") {
+		t.Errorf("RequestSecrets = %q, want only credentials", got)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	var buf bytes.Buffer
+	secrets := &Secrets{}
+	secrets.Add("admin-token-1")
+	logger := slog.New(NewHandler(slog.NewJSONHandler(&buf, nil), secrets)).With("base", "admin-token-1")
+	secrets.Add("rotated-token-2")
+
+	logger.Info("calling with admin-token-1",
+		"err", errors.New("mattermost failed: rotated-token-2"),
+		"values", []string{"Bearer rotated-token-2"},
+		slog.Group("request", "url", "/x?token=abc"),
+		"count", 3,
+	)
+	out := buf.String()
+	for _, secret := range []string{"admin-token-1", "rotated-token-2", "token=abc"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("log line holds %q: %s", secret, out)
+		}
+	}
+	if !strings.Contains(out, `"count":3`) {
+		t.Errorf("log line lost an attribute: %s", out)
+	}
+}
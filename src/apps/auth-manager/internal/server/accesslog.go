@@ -0,0 +1,38 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/redact"
+)
+
+// logRequest writes the access log: one line per request with its outcome,
+// who made it, and how long it took. Query values that look like credentials
+// are redacted.
+func (s *Server) logRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		args := []any{
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"bytes", rec.bytes,
+			"duration", time.Since(start),
+			"remote", s.clientIP(r),
+			"user_agent", r.UserAgent(),
+		}
+		if r.URL.RawQuery != "" {
+			args = append(args, "query", redact.Query(r.URL.RawQuery))
+		}
+		if ident, err := s.identityFromRequest(r); err == nil {
+			args = append(args, "user", ident.Email)
+		}
+		s.logger.InfoContext(r.Context(), "request", args...)
+	})
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/apperror"
+)
+
+// requireAdmin guards the management API (/api/v1/*), the API docs at /docs,
+// the debug endpoints (/debug/*), and /metrics when MetricsProtected is set.
+// Callers authenticate with the admin bearer token, or as a forwarded
+// identity in one of the admin groups. Health checks, forward auth,
+// webhooks, and the Mattermost slash command have their own authentication
+// and are exempt.
+func (s *Server) requireAdmin(next http.Handler) http.Handler {
+	if s.cfg.AdminToken == "" && len(s.cfg.AdminGroups) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		protected := (strings.HasPrefix(r.URL.Path, "/api/v1/") && r.URL.Path != mattermostCommandPath) || strings.HasPrefix(r.URL.Path, "/debug/") || r.URL.Path == "/docs" || (s.cfg.MetricsProtected && r.URL.Path == "/metrics")
+		if !protected {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			if s.cfg.AdminToken != "" && subtle.ConstantTimeCompare([]byte(strings.TrimSpace(token)), []byte(s.cfg.AdminToken)) == 1 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			s.logger.WarnContext(r.Context(), "management API request with an invalid admin token", "path", r.URL.Path, "client", s.clientIP(r))
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			s.respondError(w, r, apperror.InvalidAdminToken)
+			return
+		}
+
+		if len(s.cfg.AdminGroups) > 0 {
+			if ident, err := s.identityFromRequest(r); err == nil {
+				if !inAnyGroup(ident.Groups, s.cfg.AdminGroups) {
+					s.logger.WarnContext(r.Context(), "management API request from a non-admin", "path", r.URL.Path, "email", ident.Email)
+					s.respondError(w, r, apperror.AdminGroupRequired)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		s.respondError(w, r, apperror.AuthenticationRequired)
+	})
+}
+
+// inAnyGroup reports whether groups and wanted share a group, ignoring case.
+func inAnyGroup(groups, wanted []string) bool {
+	for _, group := range groups {
+		for _, w := range wanted {
+			if strings.EqualFold(group, w) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/webhook"
+)
+
+// alertPostTimeout bounds an asynchronous alert post to Mattermost.
+const alertPostTimeout = 10 * time.Second
+
+// shouldForwardAlert reports whether a non-user event is severe enough to be
+// forwarded to the configured Mattermost alert channel.
+func (s *Server) shouldForwardAlert(event *webhook.AuthentikEvent) bool {
+	if s.mattermost() == nil || s.cfg.AlertChannelID == "" {
+		return false
+	}
+	rank := webhook.SeverityRank(event.Severity)
+	return rank > 0 && rank >= webhook.SeverityRank(s.cfg.AlertMinSeverity)
+}
+
+// forwardAlert posts the event to the alert channel in the background so the
+// webhook response isn't held up by Mattermost.
+func (s *Server) forwardAlert(event *webhook.AuthentikEvent) {
+	if !s.alertBreaker.Allow() {
+		s.alertsDropped.Inc()
+		s.logger.Warn("alert circuit open, dropping alert", "action", event.Action(), "severity", event.Severity)
+		return
+	}
+
+	message := formatAlert(event)
+	err := s.lifecycle.goWorker("alert", func(ctx context.Context) {
+		ctx, cancel := context.WithTimeout(ctx, alertPostTimeout)
+		defer cancel()
+
+		if err := s.mattermost().PostToChannel(ctx, s.cfg.AlertChannelID, message); err != nil {
+			s.alertsDropped.Inc()
+			if opened := s.alertBreaker.RecordFailure(err); opened {
+				s.logger.ErrorContext(ctx, "alert circuit opened", "cooldown", s.alertBreaker.Remaining(), "err", err)
+			} else {
+				s.logger.WarnContext(ctx, "alert forwarding failed", "err", err)
+			}
+			return
+		}
+		s.alertBreaker.RecordSuccess()
+		s.alertsForwarded.Inc()
+	})
+	if err != nil {
+		s.alertsDropped.Inc()
+		s.logger.Warn("dropping alert", "action", event.Action(), "severity", event.Severity, "err", err)
+	}
+}
+
+// formatAlert renders an Authentik event as a Mattermost markdown message.
+func formatAlert(event *webhook.AuthentikEvent) string {
+	var b strings.Builder
+	action := event.Action()
+	if action == "" {
+		action = "event"
+	}
+	fmt.Fprintf(&b, "#### :rotating_light: Authentik %s: `%s`\n", strings.ToLower(event.Severity), action)
+
+	info := event.ExtractUser()
+	switch {
+	case info.Username != "" && info.Email != "":
+		fmt.Fprintf(&b, "**User:** %s (%s)\n", info.Username, info.Email)
+	case info.Username != "":
+		fmt.Fprintf(&b, "**User:** %s\n", info.Username)
+	case info.Email != "":
+		fmt.Fprintf(&b, "**User:** %s\n", info.Email)
+	}
+
+	if event.Event != nil {
+		if !event.Event.Created.IsZero() {
+			fmt.Fprintf(&b, "**When:** %s\n", event.Event.Created.UTC().Format(time.RFC3339))
+		}
+		if len(event.Event.Context) > 0 {
+			// MarshalIndent sorts map keys, keeping the output stable.
+			if ctxJSON, err := json.MarshalIndent(event.Event.Context, "", "  "); err == nil {
+				fmt.Fprintf(&b, "```json\n%s\n```\n", ctxJSON)
+			}
+		}
+	} else if event.Body != "" {
+		fmt.Fprintf(&b, "```\n%s\n```\n", event.Body)
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/apperror"
+	"github.com/rave-org/rave/apps/auth-manager/internal/config"
+	"github.com/rave-org/rave/apps/auth-manager/internal/shadow"
+)
+
+// includeSensitive reports whether r asked for sensitive attributes with
+// include_sensitive=true. That takes admin credentials: when the management
+// API is open, it's refused rather than served to anyone.
+func (s *Server) includeSensitive(r *http.Request) (bool, error) {
+	raw := r.URL.Query().Get("include_sensitive")
+	if raw == "" {
+		return false, nil
+	}
+	include, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, invalidRequest("include_sensitive must be true or false")
+	}
+	if !include {
+		return false, nil
+	}
+	if s.cfg.AdminToken == "" && len(s.cfg.AdminGroups) == 0 {
+		return false, apperror.New(http.StatusForbidden, apperror.CodeAdminAuthNotConfigured,
+			"include_sensitive requires AUTH_MANAGER_ADMIN_TOKEN or AUTH_MANAGER_ADMIN_GROUPS")
+	}
+	s.logger.InfoContext(r.Context(), "sensitive shadow attributes requested", "path", r.URL.Path, "client", s.clientIP(r))
+	return true, nil
+}
+
+// redactSensitive masks attributes' sensitive values in place.
+func (s *Server) redactSensitive(attributes map[string]string) {
+	for k, v := range attributes {
+		if v != "" && slices.Contains(s.cfg.SensitiveAttributes, k) {
+			attributes[k] = config.RedactedValue
+		}
+	}
+}
+
+// mergeEmailDuplicates folds shadow users recorded under several spellings
+// of one email into one, once per startup.
+func (s *Server) mergeEmailDuplicates(ctx context.Context) {
+	n, err := shadow.MergeEmailDuplicates(ctx, s.shadowStore)
+	switch {
+	case err != nil && ctx.Err() == nil:
+		s.logger.Error("merging duplicate shadow users failed", "removed", n, "err", err)
+	case n > 0:
+		s.logger.Info("merged shadow users differing only by email case", "removed", n)
+	}
+}
+
+// unreadableAttribute logs and counts an encrypted attribute value the
+// shadow store left out of a read because it won't decrypt.
+func (s *Server) unreadableAttribute(id, attr string, err error) {
+	reason := "undecryptable"
+	if errors.Is(err, shadow.ErrUnknownKey) {
+		reason = "unknown_key"
+	}
+	s.shadowUnreadable.WithLabelValues(reason).Inc()
+	s.logger.Warn("shadow attribute won't decrypt; left out", "id", id, "attribute", attr, "reason", reason, "err", err)
+}
+
+// rekeyAttributes re-encrypts sensitive attributes left under a retired key
+// or in plaintext, so the retired key can be dropped after one startup.
+func (s *Server) rekeyAttributes(ctx context.Context, rekeyer shadow.Rekeyer) {
+	report, err := rekeyer.Rekey(ctx)
+	switch {
+	case err != nil && ctx.Err() == nil:
+		s.logger.Error("re-encrypting shadow attributes failed", "rewritten", report.Rewritten, "err", err)
+		return
+	case report.Rewritten > 0:
+		s.logger.Info("re-encrypted shadow attributes with the current key", "users", report.Rewritten)
+	}
+	if report.Remaining > 0 {
+		s.logger.Warn("shadow attributes left under other keys because they won't decrypt; dropping a retired key loses them", "values", report.Remaining)
+	}
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/apperror"
+	"github.com/rave-org/rave/apps/auth-manager/internal/config"
+	"github.com/rave-org/rave/apps/auth-manager/internal/webhook"
+)
+
+// bodyLimit is the largest request body the route pattern accepts:
+// webhook.MaxPayloadBytes for the webhooks, MaxRequestBytes elsewhere.
+func (s *Server) bodyLimit(pattern string) int64 {
+	if strings.HasPrefix(pattern, "/webhook/") {
+		return webhook.MaxPayloadBytes
+	}
+	if s.cfg.MaxRequestBytes > 0 {
+		return int64(s.cfg.MaxRequestBytes)
+	}
+	return config.DefaultMaxRequestBytes
+}
+
+// limitBody makes reads past limit bytes of a request body fail with an
+// *http.MaxBytesError, which respondBodyError turns into a 413.
+func limitBody(limit int64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// respondBodyError responds to a request body that couldn't be decoded:
+// 413 if it was over the limit, 400 otherwise.
+func (s *Server) respondBodyError(w http.ResponseWriter, r *http.Request, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		s.respondError(w, r, apperror.Wrap(err, http.StatusRequestEntityTooLarge, apperror.CodeRequestTooLarge,
+			fmt.Sprintf("request body exceeds %d bytes", tooLarge.Limit)))
+		return
+	}
+	// Decoding errors describe the caller's own input, so they're safe to
+	// repeat.
+	s.respondError(w, r, apperror.New(http.StatusBadRequest, apperror.CodeInvalidRequest, "invalid request body: "+err.Error()))
+}
+
+// invalidRequest is a 400 for a request that decoded but is incomplete or
+// out of range.
+func invalidRequest(message string) *apperror.Error {
+	return apperror.New(http.StatusBadRequest, apperror.CodeInvalidRequest, message)
+}
+
+// notConfigured is a 503 for an endpoint whose service isn't configured.
+func notConfigured(message string) *apperror.Error {
+	return apperror.New(http.StatusServiceUnavailable, apperror.CodeNotConfigured, message)
+}
+
+// storeUnavailable hides a shadow store error, which can carry the database
+// address, behind a 503.
+func storeUnavailable(err error) *apperror.Error {
+	return apperror.Wrap(err, http.StatusServiceUnavailable, apperror.CodeStoreUnavailable, "shadow store unavailable")
+}
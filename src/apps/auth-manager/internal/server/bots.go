@@ -0,0 +1,112 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/shadow"
+	"github.com/rave-org/rave/apps/auth-manager/internal/webhook"
+)
+
+// Shadow attributes recorded for service accounts provisioned as bots. The
+// token attribute holds the access token's ID, never the token itself.
+const (
+	attrMattermostBotID      = "mattermost_bot_user_id"
+	attrMattermostBotTokenID = "mattermost_bot_token_id"
+)
+
+// botProvisionError marks a failure provisioning a service account as a
+// Mattermost bot, so callers can tell it apart from regular user failures.
+type botProvisionError struct {
+	username string
+	err      error
+}
+
+func (e *botProvisionError) Error() string {
+	return "mattermost bot " + e.username + ": " + e.err.Error()
+}
+
+func (e *botProvisionError) Unwrap() error { return e.err }
+
+// isServiceAccount reports whether info is an automation identity that should
+// become a Mattermost bot rather than a user.
+func (s *Server) isServiceAccount(info *webhook.UserInfo) bool {
+	prefix := strings.ToLower(s.cfg.ServiceAccountPrefix)
+	if prefix != "" && strings.HasPrefix(strings.ToLower(info.Username), prefix) {
+		return true
+	}
+	for _, group := range info.Groups {
+		for _, svc := range s.cfg.ServiceAccountGroups {
+			if strings.EqualFold(group, svc) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// provisionBot ensures the service account has a Mattermost bot and, when a
+// token directory is configured, an access token for it.
+func (s *Server) provisionBot(ctx context.Context, info *webhook.UserInfo, shadowUser shadow.ShadowUser) error {
+	username := info.Username
+	if username == "" {
+		username = strings.Split(info.Email, "@")[0]
+	}
+	displayName := info.Name
+	if displayName == "" {
+		displayName = username
+	}
+
+	bot, created, err := s.mattermost().EnsureBot(ctx, username, displayName, "Service account "+info.Email+" (managed by auth-manager)")
+	if err != nil {
+		s.recordMattermostFailure(mmOpEnsureUser, err)
+		return &botProvisionError{username: username, err: err}
+	}
+	s.recordMattermostSuccess(mmOpEnsureUser)
+
+	attrs := map[string]string{}
+	if shadowUser.Attributes[attrMattermostBotID] != bot.UserID {
+		attrs[attrMattermostBotID] = bot.UserID
+	}
+	// Dry-run tokens aren't real, so don't write them out.
+	if s.cfg.BotTokenDir != "" && !s.cfg.DryRun && shadowUser.Attributes[attrMattermostBotTokenID] == "" {
+		tokenID, err := s.issueBotToken(ctx, bot.UserID, bot.Username)
+		if err != nil {
+			return &botProvisionError{username: bot.Username, err: err}
+		}
+		attrs[attrMattermostBotTokenID] = tokenID
+	}
+	if len(attrs) > 0 {
+		if _, err := s.upsertShadow(ctx, shadowUser.Identity, attrs); err != nil {
+			return fmt.Errorf("shadow store upsert: %w", err)
+		}
+	}
+
+	s.logger.InfoContext(ctx, "service account provisioned as mattermost bot",
+		"email", info.Email,
+		"bot_user_id", bot.UserID,
+		"username", bot.Username,
+		"created", created,
+	)
+	return nil
+}
+
+// issueBotToken creates an access token for the bot and writes it to
+// BotTokenDir, returning the token's ID.
+func (s *Server) issueBotToken(ctx context.Context, botUserID, username string) (string, error) {
+	token, err := s.mattermost().CreateUserAccessToken(ctx, botUserID, "auth-manager provisioned token")
+	if err != nil {
+		s.recordMattermostFailure(mmOpEnsureUser, err)
+		return "", fmt.Errorf("create access token: %w", err)
+	}
+	s.recordMattermostSuccess(mmOpEnsureUser)
+
+	path := filepath.Join(s.cfg.BotTokenDir, username)
+	if err := os.WriteFile(path, []byte(token.Token+"\n"), 0o600); err != nil {
+		return "", fmt.Errorf("write access token %s (token id %s): %w", path, token.ID, err)
+	}
+	return token.ID, nil
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/breaker"
+	"github.com/rave-org/rave/apps/auth-manager/internal/config"
+)
+
+// maxBreakerCooldown caps how far failed half-open probes stretch a
+// breaker's cooldown.
+const maxBreakerCooldown = 5 * time.Minute
+
+// Mattermost operations with their own breakers, so that, say, a failing
+// session endpoint doesn't stop user lookups.
+const (
+	mmOpEnsureUser = "ensure-user" // lookups, creation, and onboarding
+	mmOpSession    = "create-session"
+	mmOpDeactivate = "deactivate"
+	mmOpRevoke     = "revoke-sessions"
+)
+
+var mmOperations = []string{mmOpEnsureUser, mmOpSession, mmOpDeactivate, mmOpRevoke}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *breaker.Breaker {
+	return breaker.New(breaker.Settings{Threshold: threshold, Cooldown: cooldown, MaxCooldown: maxBreakerCooldown})
+}
+
+// breakerSettings are the downstream services' breaker settings:
+// BreakerThreshold and BreakerCooldown, or 5 failures and 30s.
+func breakerSettings(cfg config.Config) breaker.Settings {
+	settings := breaker.Settings{Threshold: 5, Cooldown: 30 * time.Second, MaxCooldown: maxBreakerCooldown}
+	if cfg.BreakerThreshold > 0 {
+		settings.Threshold = cfg.BreakerThreshold
+	}
+	if cfg.BreakerCooldown > 0 {
+		settings.Cooldown = cfg.BreakerCooldown
+	}
+	settings.MaxCooldown = max(settings.MaxCooldown, settings.Cooldown)
+	return settings
+}
+
+// breakers names every breaker, Mattermost's as "mattermost/<operation>".
+func (s *Server) breakers() map[string]*breaker.Breaker {
+	breakers := map[string]*breaker.Breaker{
+		"n8n":     s.n8nBreaker,
+		"gitlab":  s.gitlabBreaker,
+		"grafana": s.grafanaBreaker,
+		"alerts":  s.alertBreaker,
+	}
+	for _, op := range s.mmBreakers.Names() {
+		breakers["mattermost/"+op] = s.mmBreakers.Get(op)
+	}
+	return breakers
+}
+
+// breakerStates reports every breaker for /healthz.
+func (s *Server) breakerStates() map[string]breakerState {
+	states := map[string]breakerState{}
+	for name, b := range s.breakers() {
+		states[name] = breakerStatus(b)
+	}
+	return states
+}
+
+// breakerState is the circuit breaker summary reported by /healthz.
+type breakerState struct {
+	State         string `json:"state"`
+	Remaining     string `json:"remaining,omitempty"`
+	LastFailure   string `json:"last_failure,omitempty"`
+	LastFailureAt string `json:"last_failure_at,omitempty"`
+}
+
+func breakerStatus(b *breaker.Breaker) breakerState {
+	if b == nil {
+		return breakerState{State: "disabled"}
+	}
+	snap := b.Snapshot()
+	state := breakerState{State: snap.State.String()}
+	if snap.Remaining > 0 {
+		state.Remaining = snap.Remaining.Round(time.Second).String()
+	}
+	if snap.LastFailure != "" {
+		state.LastFailure = snap.LastFailure
+		state.LastFailureAt = snap.LastFailureAt.UTC().Format(time.RFC3339)
+	}
+	return state
+}
+
+// instrumentBreakers exports each breaker's state, remaining cooldown, opens,
+// and rejected calls, labelled by service, and passes each state change to
+// onChange, which runs with the breaker locked.
+func instrumentBreakers(reg prometheus.Registerer, breakers map[string]*breaker.Breaker, onChange func(service string, to breaker.State)) {
+	opens := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_manager_circuit_breaker_opens_total",
+		Help: "Number of times a downstream service's circuit breaker opened",
+	}, []string{"service"})
+	rejected := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_manager_circuit_breaker_rejected_total",
+		Help: "Number of calls to a downstream service refused while its circuit breaker was open or probing",
+	}, []string{"service"})
+	reg.MustRegister(opens, rejected)
+
+	for service, b := range breakers {
+		if b == nil {
+			continue
+		}
+		b, service := b, service
+		opened := opens.WithLabelValues(service)
+		b.OnStateChange = func(_, to breaker.State) {
+			if to == breaker.Open {
+				opened.Inc()
+			}
+			if onChange != nil {
+				onChange(service, to)
+			}
+		}
+		b.OnReject = rejected.WithLabelValues(service).Inc
+		reg.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "auth_manager_circuit_breaker_open",
+			Help:        "Whether the circuit breaker for a downstream service is open (1) or closed or probing (0)",
+			ConstLabels: prometheus.Labels{"service": service},
+		}, func() float64 {
+			if b.Remaining() > 0 {
+				return 1
+			}
+			return 0
+		}))
+		reg.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "auth_manager_circuit_breaker_cooldown_seconds",
+			Help:        "Seconds until an open circuit breaker starts probing, or 0",
+			ConstLabels: prometheus.Labels{"service": service},
+		}, func() float64 {
+			return b.Remaining().Seconds()
+		}))
+	}
+}
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/apperror"
+	"github.com/rave-org/rave/apps/auth-manager/internal/version"
+)
+
+// registerBuildInfo exports the running build as an always-1 gauge, for
+// joining onto other series and spotting mixed versions during a rollout.
+func registerBuildInfo(reg prometheus.Registerer) {
+	info := version.Get()
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "auth_manager_build_info",
+		Help: "Always 1, labelled with the running build's version, commit, build date, and Go version",
+		ConstLabels: prometheus.Labels{
+			"version":    info.Version,
+			"commit":     info.Commit,
+			"date":       info.Date,
+			"modified":   strconv.FormatBool(info.Modified),
+			"go_version": info.GoVersion,
+		},
+	})
+	gauge.Set(1)
+	reg.MustRegister(gauge)
+}
+
+// handleVersion reports the running build.
+func (s *Server) handleVersion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		s.respondError(w, r, apperror.MethodNotAllowed)
+		return
+	}
+	s.respondJSON(w, http.StatusOK, version.Get())
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/httpx"
+)
+
+// corsMaxAge is how long browsers may cache a preflight response.
+const corsMaxAge = 10 * time.Minute
+
+// corsAllowHeaders are the request headers browsers may send cross-origin.
+var corsAllowHeaders = strings.Join([]string{"Authorization", "Content-Type", httpx.RequestIDHeader, idempotencyKeyHeader}, ", ")
+
+// apiMethods maps each management API path to its Allow header, from the
+// OpenAPI document so the two can't disagree.
+func apiMethods() map[string]string {
+	methods := map[string]string{}
+	for path, item := range openAPIDocument().Paths {
+		if !strings.HasPrefix(path, "/api/v1/") {
+			continue
+		}
+		names := []string{http.MethodOptions}
+		for method := range item {
+			names = append(names, strings.ToUpper(method))
+		}
+		sort.Strings(names)
+		methods[path] = strings.Join(names, ", ")
+	}
+	return methods
+}
+
+// cors answers OPTIONS for the management API itself, so handlers never see
+// it, and adds CORS headers for the origins in CORSOrigins. Preflights carry
+// no credentials, so this has to run before requireAdmin.
+func (s *Server) cors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods, ok := s.apiMethods[r.URL.Path]
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		allowed := s.allowOrigin(w, r.Header.Get("Origin"))
+		if r.Method != http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Allow", methods)
+		if allowed && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", methods)
+			w.Header().Set("Access-Control-Allow-Headers", corsAllowHeaders)
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(corsMaxAge.Seconds())))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// allowOrigin sets the CORS response headers for origin and reports whether
+// it's allowed. Only listed origins get credentials: a wildcard with
+// credentials would let any site act as a signed-in admin.
+func (s *Server) allowOrigin(w http.ResponseWriter, origin string) bool {
+	if len(s.corsOrigins) == 0 && !s.corsAnyOrigin {
+		return false
+	}
+	w.Header().Add("Vary", "Origin")
+	switch {
+	case origin == "":
+		return false
+	case s.corsOrigins[strings.ToLower(origin)]:
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	case s.corsAnyOrigin:
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	default:
+		return false
+	}
+	w.Header().Set("Access-Control-Expose-Headers", httpx.RequestIDHeader)
+	return true
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// urlOrigin is rawURL's scheme://host[:port], lowercased, or "" when it
+// doesn't parse.
+func urlOrigin(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return ""
+	}
+	return strings.ToLower(u.Scheme + "://" + u.Host)
+}
+
+// crossSiteReason reports which header shows that r, a forward-auth request
+// that would set session cookies for a service served at origin, came from
+// another site, or "" when none does. When a trusted proxy forwarded the
+// host the browser used, that origin is accepted too.
+//
+// Traefik forwards the browser's headers with the original method in
+// X-Forwarded-Method. An Origin must match. Without one, Sec-Fetch-Site must
+// not be cross-site except for top-level navigations, which SameSite=Lax
+// cookies would follow anyway, like a link in an email. Unsafe methods that
+// send neither are judged by their Referer.
+func (s *Server) crossSiteReason(r *http.Request, origin string) string {
+	if origin == "" {
+		return ""
+	}
+	sameOrigin := func(got string) bool {
+		return got != "" && (got == origin || got == s.forwardedURL(r, "").origin())
+	}
+	if got := r.Header.Get("Origin"); got != "" {
+		if !sameOrigin(strings.ToLower(got)) {
+			return "origin"
+		}
+		return ""
+	}
+	if r.Header.Get("Sec-Fetch-Site") == "cross-site" && r.Header.Get("Sec-Fetch-Mode") != "navigate" {
+		return "sec-fetch-site"
+	}
+	method := r.Header.Get("X-Forwarded-Method")
+	if method == "" {
+		method = r.Method
+	}
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return ""
+	}
+	if referer := r.Header.Get("Referer"); referer != "" && !sameOrigin(urlOrigin(referer)) {
+		return "referer"
+	}
+	return ""
+}
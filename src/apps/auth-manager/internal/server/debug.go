@@ -0,0 +1,97 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+)
+
+// newDebugServer builds the DebugAddr listener, or returns nil when it's
+// unset. It serves net/http/pprof and /debug/vars behind the same proxy
+// check and admin guard as the management API.
+func (s *Server) newDebugServer() *http.Server {
+	if s.cfg.DebugAddr == "" {
+		return nil
+	}
+	return &http.Server{
+		Addr:        s.cfg.DebugAddr,
+		Handler:     s.withRequestID(s.logRequest(s.trustProxies(s.requireAdmin(s.debugHandler())))),
+		ReadTimeout: 15 * time.Second,
+		// No WriteTimeout: CPU profiles and traces run for ?seconds=N.
+		IdleTimeout: 60 * time.Second,
+	}
+}
+
+func (s *Server) debugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/vars", s.handleDebugVars)
+	return mux
+}
+
+// startDebugServer serves the debug listener in the background. A listener
+// that fails is logged rather than taking the service down.
+func (s *Server) startDebugServer() {
+	if s.debugServer == nil {
+		return
+	}
+	if s.cfg.AdminToken == "" && len(s.cfg.AdminGroups) == 0 {
+		s.logger.Warn("debug endpoints enabled without AUTH_MANAGER_ADMIN_TOKEN or AUTH_MANAGER_ADMIN_GROUPS; anyone reaching the address can profile the process", "addr", s.cfg.DebugAddr)
+	} else {
+		s.logger.Warn("debug endpoints enabled", "addr", s.cfg.DebugAddr)
+	}
+	go func() {
+		if err := s.debugServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logger.Error("debug listener failed", "addr", s.cfg.DebugAddr, "err", err)
+		}
+	}()
+}
+
+// debugVars is the /debug/vars snapshot.
+type debugVars struct {
+	Goroutines int                     `json:"goroutines"`
+	Heap       debugHeap               `json:"heap"`
+	Breakers   map[string]breakerState `json:"breakers"`
+	Workers    map[string]int          `json:"workers"` // background workers running, by name
+	Store      string                  `json:"store"`
+	Warmup     warmupStatus            `json:"warmup"`
+}
+
+type debugHeap struct {
+	AllocBytes   uint64 `json:"alloc_bytes"`
+	SysBytes     uint64 `json:"sys_bytes"`
+	Objects      uint64 `json:"objects"`
+	NumGC        uint32 `json:"num_gc"`
+	LastGC       string `json:"last_gc,omitempty"`
+	PauseTotalNs uint64 `json:"pause_total_ns"`
+}
+
+func (s *Server) handleDebugVars(w http.ResponseWriter, r *http.Request) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	heap := debugHeap{
+		AllocBytes:   mem.HeapAlloc,
+		SysBytes:     mem.HeapSys,
+		Objects:      mem.HeapObjects,
+		NumGC:        mem.NumGC,
+		PauseTotalNs: mem.PauseTotalNs,
+	}
+	if mem.LastGC != 0 {
+		heap.LastGC = time.Unix(0, int64(mem.LastGC)).UTC().Format(time.RFC3339Nano)
+	}
+	s.respondJSON(w, http.StatusOK, debugVars{
+		Goroutines: runtime.NumGoroutine(),
+		Heap:       heap,
+		Breakers:   s.breakerStates(),
+		Workers:    s.lifecycle.workers(),
+		Store:      fmt.Sprintf("%T", s.shadowStore),
+		Warmup:     s.warm.status(),
+	})
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/accounts"
+	"github.com/rave-org/rave/apps/auth-manager/internal/apperror"
+	"github.com/rave-org/rave/apps/auth-manager/internal/mattermost"
+	"github.com/rave-org/rave/apps/auth-manager/internal/outbound"
+	"github.com/rave-org/rave/apps/auth-manager/internal/provision"
+	"github.com/rave-org/rave/apps/auth-manager/internal/shadow"
+	"github.com/rave-org/rave/apps/auth-manager/internal/webhook"
+)
+
+// attrMattermostDeactivated marks shadow users whose Mattermost account we
+// deactivated, so reconciliation doesn't bring it back.
+const attrMattermostDeactivated = "mattermost_deactivated"
+
+// deprovisionRequest is the body of POST /api/v1/deprovision.
+type deprovisionRequest struct {
+	Email   string `json:"email"`
+	Subject string `json:"subject"`
+}
+
+// handleManualDeprovision deactivates a user's downstream accounts on demand.
+func (s *Server) handleManualDeprovision(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		s.respondError(w, r, apperror.MethodNotAllowed)
+		return
+	}
+
+	var payload deprovisionRequest
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		s.respondBodyError(w, r, err)
+		return
+	}
+
+	if payload.Email == "" {
+		s.respondError(w, r, invalidRequest("email is required"))
+		return
+	}
+	if len(s.provisioners.Targets()) == 0 {
+		s.respondError(w, r, notConfigured("no downstream services configured"))
+		return
+	}
+
+	userInfo := &webhook.UserInfo{
+		Email:   payload.Email,
+		Subject: payload.Subject,
+	}
+
+	results, err := s.deprovisionUser(r.Context(), userInfo)
+	if err != nil {
+		s.respondDeprovisionError(w, results, err)
+		return
+	}
+
+	s.respondJSON(w, http.StatusOK, map[string]any{
+		"status":  "deprovisioned",
+		"email":   payload.Email,
+		"targets": results,
+	})
+}
+
+// deprovisionUser runs every provisioner's Deprovision for the user:
+// deactivating Mattermost, disabling or deleting n8n, blocking GitLab, and
+// disabling Grafana, each marking the shadow record. Accounts that no longer
+// exist are not an error.
+func (s *Server) deprovisionUser(ctx context.Context, info *webhook.UserInfo) ([]provision.Result, error) {
+	info.Email = accounts.NormalizeEmail(info.Email)
+	s.sessionCache.invalidate(info.Email)
+	s.n8nSessions.invalidate(info.Email)
+
+	// Keep the stored identity so a sparse deprovision request doesn't blank it.
+	shadowUser, err := s.shadowStore.Get(ctx, info.ShadowProvider(), info.ShadowSubject())
+	if err != nil {
+		shadowUser = shadow.ShadowUser{}
+	}
+	ident := s.canonicalIdentity(info, shadowUser)
+	results, err := s.provisioners.Deprovision(ctx, ident)
+	s.publishUserEvent(ctx, outbound.EventUserDeprovisioned, ident, results, err)
+	return results, err
+}
+
+// deactivateMattermostUser deactivates the user's Mattermost account,
+// recording the outcome in attributes.
+func (s *Server) deactivateMattermostUser(ctx context.Context, info *webhook.UserInfo, attributes map[string]string) error {
+	userID, err := s.mattermostUserID(ctx, info)
+	if err == nil {
+		err = s.mattermost().DeactivateUser(ctx, userID)
+		if errors.Is(err, mattermost.ErrNotFound) && info.Email != "" {
+			// The stored ID may be stale; retry with a fresh email lookup.
+			s.logger.WarnContext(ctx, "stored mattermost user id not found, looking up by email", "email", info.Email, "mattermost_user_id", userID)
+			var mmUser mattermost.User
+			if mmUser, err = s.mattermost().GetUserByEmail(ctx, info.Email); err == nil && mmUser.ID != userID {
+				userID = mmUser.ID
+				err = s.mattermost().DeactivateUser(ctx, userID)
+			}
+		}
+	}
+	attributes[attrMattermostDeactivated] = "true"
+	switch {
+	case errors.Is(err, mattermost.ErrNotFound):
+		s.logger.InfoContext(ctx, "no mattermost user to deactivate", "email", info.Email)
+	case err != nil:
+		s.recordMattermostFailure(mmOpDeactivate, err)
+		return fmt.Errorf("mattermost deactivate: %w", err)
+	default:
+		s.recordMattermostSuccess(mmOpDeactivate)
+		attributes["mattermost_user_id"] = userID
+		s.logger.InfoContext(ctx, "mattermost user deactivated", "email", info.Email, "mattermost_user_id", userID)
+	}
+	return nil
+}
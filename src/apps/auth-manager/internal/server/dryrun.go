@@ -0,0 +1,77 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/shadow"
+)
+
+// dryRunHeader tells the forward-auth caller that dry-run mode let the
+// request through without issuing a session.
+const dryRunHeader = "X-Rave-Dry-Run"
+
+// recordDownstream returns the httpx hook that counts requests to service
+// and logs the writes dry-run mode simulated.
+func (s *Server) recordDownstream(service string) func(*http.Request, bool) {
+	return func(req *http.Request, simulated bool) {
+		mode := "real"
+		if simulated {
+			mode = "simulated"
+			s.logger.Info("dry run: simulated request", "service", service, "method", req.Method, "path", req.URL.Path)
+		}
+		s.downstreamCalls.WithLabelValues(service, req.Method, mode).Inc()
+	}
+}
+
+// upsertShadow writes to the shadow store, remapping by email when
+// AUTH_MANAGER_REMAP_BY_EMAIL is set. In dry-run mode it returns the record
+// the write would produce without storing it.
+func (s *Server) upsertShadow(ctx context.Context, ident shadow.Identity, attributes map[string]string) (shadow.ShadowUser, error) {
+	if !s.cfg.DryRun && s.cfg.RemapByEmail {
+		user, moved, err := shadow.UpsertByEmail(ctx, s.shadowStore, ident, attributes)
+		if len(moved) > 0 {
+			s.shadowRemapped.WithLabelValues("upsert").Add(float64(len(moved)))
+			s.logger.InfoContext(ctx, "remapped shadow user to a new subject", "email", user.Identity.Email, "id", user.ID, "from", moved)
+		}
+		return user, err
+	}
+	if !s.cfg.DryRun {
+		return s.shadowStore.Upsert(ctx, ident, attributes)
+	}
+	user, err := s.shadowStore.Get(ctx, ident.Provider, ident.Subject)
+	if errors.Is(err, shadow.ErrNotFound) {
+		user, err = shadow.ShadowUser{}, nil
+	}
+	if err != nil {
+		return shadow.ShadowUser{}, err
+	}
+	merged := make(map[string]string, len(user.Attributes)+len(attributes))
+	for k, v := range user.Attributes {
+		merged[k] = v
+	}
+	for k, v := range attributes {
+		merged[k] = v
+	}
+	user.Identity = ident
+	user.Attributes = merged
+	return user, nil
+}
+
+// withDryRun marks JSON object payloads as dry-run results.
+func withDryRun(payload any) any {
+	switch p := payload.(type) {
+	case map[string]any:
+		p["dry_run"] = true
+		return p
+	case map[string]string:
+		out := make(map[string]any, len(p)+1)
+		for k, v := range p {
+			out[k] = v
+		}
+		out["dry_run"] = true
+		return out
+	}
+	return payload
+}
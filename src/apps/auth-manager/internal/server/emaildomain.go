@@ -0,0 +1,24 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/accounts"
+)
+
+// domainAllowed reports whether domain matches one of allowed, which must be
+// normalized. "*.example.com" matches subdomains of example.com, but not
+// example.com itself.
+func domainAllowed(domain string, allowed []string) bool {
+	domain = accounts.NormalizeDomain(domain)
+	for _, pattern := range allowed {
+		if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+			if strings.HasSuffix(domain, "."+suffix) {
+				return true
+			}
+		} else if domain == pattern {
+			return true
+		}
+	}
+	return false
+}
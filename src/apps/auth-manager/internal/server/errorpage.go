@@ -0,0 +1,116 @@
+package server
+
+import (
+	"bytes"
+	"embed"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/httpx"
+)
+
+//go:embed templates/errorpage.html
+var templates embed.FS
+
+// defaultErrorPage is the page browsers see when forward auth fails, unless
+// AUTH_MANAGER_ERROR_PAGE_TEMPLATE replaces it.
+var defaultErrorPage = template.Must(template.ParseFS(templates, "templates/errorpage.html"))
+
+// errorPage is the data an error page template is executed with.
+type errorPage struct {
+	Status     int
+	StatusText string
+	Title      string
+	Message    string
+	Code       string // the X-Rave-Auth-Error value, if any
+	RequestID  string
+	RetryURL   string // the page the browser asked for, when it's known
+}
+
+// authErrorMessages are friendlier explanations for browsers of the
+// X-Rave-Auth-Error codes users can do something about, if only waiting.
+var authErrorMessages = map[string]string{
+	"warming-up":                      "The sign-in service is starting up. Try again in a few seconds.",
+	"maintenance":                     "Sign-in is paused for maintenance. If you were already signed in, you still are; otherwise try again once it's over.",
+	"mattermost-circuit-open":         "Mattermost is temporarily unavailable. Try again in a minute.",
+	"mattermost-provision-failed":     "We couldn't set up your Mattermost account. Try again; if it keeps failing, contact an administrator.",
+	"mattermost-session-failed":       "We couldn't sign you in to Mattermost. Try again; if it keeps failing, contact an administrator.",
+	"mattermost-client-misconfigured": "Mattermost sign-in isn't set up. Contact an administrator.",
+	"mattermost-admin-token-rejected": "Mattermost sign-in is misconfigured. Contact an administrator.",
+	"cross-site-request":              "This sign-in came from another site, so it was refused. Open the page directly instead.",
+}
+
+// wantsJSON reports whether r came from a script rather than a browser
+// navigating, judged by X-Requested-With and Accept.
+func wantsJSON(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("X-Requested-With"), "XMLHttpRequest") ||
+		strings.Contains(strings.ToLower(r.Header.Get("Accept")), "json")
+}
+
+// wantsHTML reports whether r accepts an HTML page, as browsers navigating
+// to a page do.
+func wantsHTML(r *http.Request) bool {
+	return strings.Contains(strings.ToLower(r.Header.Get("Accept")), "text/html")
+}
+
+// authError answers a failed forward-auth request like http.Error, but in
+// the form the client asked for: JSON for scripts, an HTML page for
+// browsers, and plain text otherwise. Traefik passes the body on to the
+// client, and acts on the status and the X-Rave-Auth-Error and Retry-After
+// headers set beforehand, which don't depend on the form.
+func (s *Server) authError(w http.ResponseWriter, r *http.Request, message string, status int) {
+	code := w.Header().Get("X-Rave-Auth-Error")
+	switch {
+	case wantsJSON(r):
+		body := map[string]string{"error": message, "request_id": httpx.RequestID(r.Context())}
+		if code != "" {
+			body["code"] = code
+		}
+		s.respondJSON(w, status, body)
+	case wantsHTML(r):
+		friendly, ok := authErrorMessages[code]
+		if !ok {
+			friendly = message + "."
+		}
+		s.respondErrorPage(w, r, status, errorPage{
+			Title:    "Something went wrong signing you in",
+			Message:  friendly,
+			Code:     code,
+			RetryURL: s.retryURL(r),
+		})
+	default:
+		http.Error(w, message, status)
+	}
+}
+
+// respondErrorPage renders the error page with page's Title, Message, Code,
+// and RetryURL, filling in the rest.
+func (s *Server) respondErrorPage(w http.ResponseWriter, r *http.Request, status int, page errorPage) {
+	page.Status, page.StatusText = status, http.StatusText(status)
+	page.RequestID = httpx.RequestID(r.Context())
+	// Render first so a broken custom template still gets the status out.
+	var body bytes.Buffer
+	if err := s.errorPage.Execute(&body, page); err != nil {
+		s.logger.ErrorContext(r.Context(), "render error page", "err", err)
+		http.Error(w, page.Message, status)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	if _, err := w.Write(body.Bytes()); err != nil {
+		s.logger.DebugContext(r.Context(), "write error page", "err", err)
+	}
+}
+
+// retryURL is the path and query the browser asked for, from Traefik's
+// X-Forwarded-Uri, or "" when it's unknown or not a local path. A relative
+// link is enough, since the browser is still on the page's origin.
+func (s *Server) retryURL(r *http.Request) string {
+	uri := r.Header.Get("X-Forwarded-Uri")
+	if !s.forwardedTrusted(r) || !strings.HasPrefix(uri, "/") || strings.HasPrefix(uri, "//") || strings.HasPrefix(uri, "/\\") {
+		return ""
+	}
+	return uri
+}
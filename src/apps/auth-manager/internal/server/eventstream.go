@@ -0,0 +1,99 @@
+package server
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/config"
+	"github.com/rave-org/rave/apps/auth-manager/internal/eventstream"
+	"github.com/rave-org/rave/apps/auth-manager/internal/httpx"
+	"github.com/rave-org/rave/apps/auth-manager/internal/tracing"
+	"github.com/rave-org/rave/apps/auth-manager/internal/webhook"
+)
+
+// newEventConsumer builds the consumer of the configured event stream, or
+// returns nil when there is none or its settings don't parse. startBackground
+// runs it.
+func (s *Server) newEventConsumer(cfg config.Config, reg prometheus.Registerer) *eventstream.Consumer {
+	opts, source, err := cfg.EventStreamOptions()
+	if err != nil {
+		s.logger.Error("invalid event stream, consumer disabled", "err", err)
+		return nil
+	}
+	if opts.URL == "" {
+		return nil
+	}
+	redis, err := eventstream.NewRedis(opts)
+	if err != nil {
+		s.logger.Error("invalid event stream, consumer disabled", "err", err)
+		return nil
+	}
+
+	messages := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_manager_event_stream_messages_total",
+		Help: "Event stream messages handled by result (acked, failed, dead_lettered)",
+	}, []string{"result"})
+	lag := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "auth_manager_event_stream_lag",
+		Help: "Event stream entries not yet read by the consumer group, or -1 when Redis doesn't report it",
+	})
+	pending := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "auth_manager_event_stream_pending",
+		Help: "Event stream entries read by the consumer group and not yet acknowledged",
+	})
+	reg.MustRegister(messages, lag, pending)
+
+	s.logger.Info("event stream enabled", "url", cfg.Redacted().EventStreamURL, "stream", opts.Stream, "group", opts.Group, "source", source.Name)
+	return eventstream.NewConsumer(redis, s.streamHandler(source), eventstream.Settings{
+		MaxAttempts: cfg.EventStreamMaxAttempts,
+		OnResult: func(result string) {
+			messages.WithLabelValues(result).Inc()
+		},
+		OnBacklog: func(l, p int64) {
+			lag.Set(float64(l))
+			pending.Set(float64(p))
+		},
+	}, s.logger)
+}
+
+// streamHandler applies stream messages as webhook events from source.
+// Each is traced and logged under a request ID derived from its stream ID,
+// and bounded by the webhook timeout. A message that doesn't parse is
+// dead-lettered at once; one whose processing fails is retried.
+func (s *Server) streamHandler(source config.WebhookSource) eventstream.Handler {
+	return func(ctx context.Context, msg eventstream.Message) error {
+		ctx = httpx.WithRequestID(ctx, "stream-"+msg.ID)
+		ctx, span := s.tracer.Start(ctx, "event stream message", tracing.KindConsumer)
+		defer span.End()
+		span.SetAttribute("messaging.message.id", msg.ID)
+		span.SetAttribute("request_id", httpx.RequestID(ctx))
+		if budget := s.requestBudget("/webhook/"); budget > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, budget)
+			defer cancel()
+		}
+
+		event, err := webhook.Parse(msg.Body)
+		if err != nil {
+			s.logger.WarnContext(ctx, "event stream message parse failed", "id", msg.ID, "err", err)
+			span.SetError(err)
+			return eventstream.Permanent(err)
+		}
+		s.logDecodeWarnings(ctx, source.Name, event)
+		s.logger.InfoContext(ctx, "event stream message received",
+			"id", msg.ID,
+			"attempt", msg.Attempts,
+			"source", source.Name,
+			"action", event.Action(),
+			"is_user_event", event.IsUserEvent(),
+		)
+		res := s.processEvent(ctx, event, source)
+		span.SetAttribute("outcome", res.outcome)
+		if res.outcome == "error" {
+			span.SetError(res.err)
+			return res.err
+		}
+		return nil
+	}
+}
@@ -0,0 +1,195 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/config"
+	"github.com/rave-org/rave/apps/auth-manager/internal/identity"
+	"github.com/rave-org/rave/apps/auth-manager/internal/provision"
+	"github.com/rave-org/rave/apps/auth-manager/internal/redact"
+	"github.com/rave-org/rave/apps/auth-manager/internal/shadow"
+	"github.com/rave-org/rave/apps/auth-manager/internal/webhook"
+	"github.com/rave-org/rave/apps/auth-manager/pkg/mmbridge"
+)
+
+// attrAuthentikUID records the X-Authentik-Uid forward auth last saw.
+const attrAuthentikUID = "authentik_uid"
+
+// forwardIdentity is the user the proxy in front of us authenticated, and
+// which of the trusted sources sent it. Groups is nil when the proxy sent no
+// groups header.
+type forwardIdentity struct {
+	provision.CanonicalIdentity
+	Source identity.Source
+}
+
+// identityFromRequest reads the identity headers of the first trusted
+// source that sent any.
+func (s *Server) identityFromRequest(r *http.Request) (forwardIdentity, error) {
+	ident, source, err := identity.FromRequest(r, s.identitySources)
+	return forwardIdentity{CanonicalIdentity: ident, Source: source}, err
+}
+
+// field returns the identity field a service header carries. Services
+// generally key users on the username, so it falls back to the email.
+func (id forwardIdentity) field(name string) string {
+	switch name {
+	case "email":
+		return id.Email
+	case "username":
+		if id.Username != "" {
+			return id.Username
+		}
+		return id.Email
+	case "name":
+		return id.Name
+	case "groups":
+		return strings.Join(id.Groups, ",")
+	}
+	return ""
+}
+
+// forwardProvisioner provisions the user in a downstream service during
+// forward auth.
+type forwardProvisioner struct {
+	// sessionCookie lets requests without identity headers through when
+	// they carry the service's own session cookie.
+	sessionCookie string
+	// provision runs once the identity is known. It returns false when it
+	// has already written an error response.
+	provision func(w http.ResponseWriter, r *http.Request, ident forwardIdentity) bool
+}
+
+// forwardService is a configured /auth/{service} endpoint.
+type forwardService struct {
+	config.ForwardAuthService
+	hook forwardProvisioner
+}
+
+// forwardServices builds the forward-auth registry from the configured
+// services and the built-in provisioning hooks.
+func (s *Server) forwardServices(services map[string]config.ForwardAuthService) map[string]forwardService {
+	hooks := map[string]forwardProvisioner{
+		"mattermost": {sessionCookie: mmbridge.AuthTokenCookie, provision: s.mattermostForwardAuth},
+		"n8n":        {provision: s.n8nForwardAuth},
+		"gitlab":     {provision: s.gitlabForwardAuth},
+		"grafana":    {provision: s.grafanaForwardAuth},
+	}
+	registry := make(map[string]forwardService, len(services))
+	for name, svc := range services {
+		registry[name] = forwardService{ForwardAuthService: svc, hook: hooks[svc.Provision]}
+	}
+	return registry
+}
+
+// handleForwardAuth serves /auth/{service} for Traefik's ForwardAuth
+// middleware, chained after Authentik's outpost. It reads the identity
+// headers, optionally records a shadow user and provisions the user
+// downstream, then answers 200 with the service's identity headers, which
+// the middleware's authResponseHeaders pass on to the service.
+func (s *Server) handleForwardAuth(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/auth/")
+	svc, ok := s.forwardAuth[name]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if s.rejectWarmingUp(w, r, name) {
+		return
+	}
+	ident, err := s.identityFromRequest(r)
+
+	// Log all identity headers for debugging
+	for key, values := range r.Header {
+		lowerKey := strings.ToLower(key)
+		if strings.HasPrefix(lowerKey, "x-authentik") || strings.HasPrefix(lowerKey, "x-auth-request") || strings.HasPrefix(lowerKey, "x-pomerium-claim") {
+			s.logger.DebugContext(r.Context(), "authentik header", "service", name, "key", key, "values", redact.Header(key, values))
+		}
+	}
+
+	if err != nil {
+		if svc.hook.sessionCookie != "" {
+			if _, err := r.Cookie(svc.hook.sessionCookie); err == nil {
+				// The user already has a session with the service
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+		}
+		s.logger.DebugContext(r.Context(), "no trusted identity headers found", "service", name, "path", r.URL.Path, "sources", s.identitySources)
+		s.authError(w, r, "Unauthorized - no Authentik session", http.StatusUnauthorized)
+		return
+	}
+
+	s.logger.InfoContext(r.Context(), "forward auth request",
+		"service", name,
+		"source", ident.Source,
+		"email", ident.Email,
+		"username", ident.Username,
+		"name", ident.Name,
+		"path", r.Header.Get("X-Forwarded-Uri"),
+	)
+
+	if list, reason := s.userFilter(ident.Email, ident.Groups); reason != "" {
+		s.logger.InfoContext(r.Context(), "forward auth user filtered", "service", name, "email", ident.Email, "list", list, "reason", reason)
+		s.usersFiltered.WithLabelValues("forward_auth", list).Inc()
+		s.recordUserHistory(r.Context(), ident.Email, historyFiltered, errors.New(reason))
+		s.respondFiltered(w, r, name, list, reason)
+		return
+	}
+
+	if svc.Shadow {
+		s.recordForwardShadow(r, ident)
+	}
+	if svc.hook.provision != nil && s.maintenance.active() {
+		if !s.maintenanceForwardAuth(w, r, name, svc.hook) {
+			return
+		}
+	} else if svc.hook.provision != nil && !svc.hook.provision(w, r, ident) {
+		return
+	}
+	for header, field := range svc.Headers {
+		if value := ident.field(field); value != "" {
+			w.Header().Set(header, value)
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// recordForwardShadow upserts the forward-auth user into the shadow store,
+// onto the record already stored for their email, which webhooks and
+// reconcile key by Authentik PK, or under the email when there's none yet.
+// X-Authentik-Uid is Authentik's hashed uid rather than the PK, so it's
+// kept as an attribute instead of keying a record nothing else would use.
+// Failures only log: the store is bookkeeping, not a gate.
+func (s *Server) recordForwardShadow(r *http.Request, ident forwardIdentity) {
+	ctx := r.Context()
+	subject := ident.Email
+	switch known, err := shadow.FindByEmail(ctx, s.shadowStore, webhook.DefaultProvider, ident.Email); {
+	case err == nil:
+		subject = known.Identity.Subject
+	case !errors.Is(err, shadow.ErrNotFound):
+		s.logger.WarnContext(ctx, "failed to record forward auth user", "email", ident.Email, "err", err)
+		return
+	}
+	attributes := map[string]string{}
+	if ident.Username != "" {
+		attributes["username"] = ident.Username
+	}
+	if ident.Subject != "" {
+		attributes[attrAuthentikUID] = ident.Subject
+	}
+	if ident.Groups != nil {
+		attributes[attrGroups] = groupsAttribute(ident.Groups)
+	}
+	_, err := s.upsertShadow(ctx, shadow.Identity{
+		Provider: webhook.DefaultProvider,
+		Subject:  subject,
+		Email:    ident.Email,
+		Name:     ident.Name,
+	}, attributes)
+	if err != nil {
+		s.logger.WarnContext(ctx, "failed to record forward auth user", "email", ident.Email, "err", err)
+	}
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// publicURL is where the browser reached a request: scheme, host[:port],
+// and the path prefix a path-stripping proxy removed, without a trailing
+// slash.
+type publicURL struct {
+	scheme, host, prefix string
+}
+
+func (u publicURL) origin() string {
+	if u.scheme == "" || u.host == "" {
+		return ""
+	}
+	return strings.ToLower(u.scheme + "://" + u.host)
+}
+
+// forwardedURL is r's public URL from the X-Forwarded-Proto, -Host, and
+// -Prefix headers when a trusted proxy sent them, each falling back to the
+// configured URL fallback's. With several proxies in the chain the first
+// value, set by the one facing the browser, wins.
+func (s *Server) forwardedURL(r *http.Request, fallback string) publicURL {
+	var u publicURL
+	if parsed, err := url.Parse(fallback); err == nil && parsed.Host != "" {
+		u = publicURL{scheme: strings.ToLower(parsed.Scheme), host: parsed.Host, prefix: strings.TrimRight(parsed.Path, "/")}
+	}
+	if !s.forwardedTrusted(r) {
+		return u
+	}
+	first := func(name string) string {
+		value, _, _ := strings.Cut(r.Header.Get(name), ",")
+		return strings.TrimSpace(value)
+	}
+	if proto := strings.ToLower(first("X-Forwarded-Proto")); proto == "http" || proto == "https" {
+		u.scheme = proto
+	}
+	if host := first("X-Forwarded-Host"); host != "" && !strings.ContainsAny(host, "/\\@ ") {
+		u.host = host
+	}
+	if prefix := first("X-Forwarded-Prefix"); strings.HasPrefix(prefix, "/") && !strings.HasPrefix(prefix, "//") {
+		u.prefix = strings.TrimRight(prefix, "/")
+	}
+	return u
+}
+
+// forwardedTrusted reports whether r's X-Forwarded-* headers are believed:
+// like identity headers, from trusted proxies, or from anyone when no proxies
+// or proxy secret are configured.
+func (s *Server) forwardedTrusted(r *http.Request) bool {
+	return (len(s.trustedProxies) == 0 && s.cfg.ProxySecret == "") || s.fromTrustedProxy(r)
+}
+
+// url returns u as a URL, for mmbridge's cookies.
+func (u publicURL) url() *url.URL {
+	return &url.URL{Scheme: u.scheme, Host: u.host, Path: u.prefix}
+}
+
+// sessionCookie builds a session cookie for a service at public, as
+// mmbridge builds Mattermost's: Secure when it's served over https, with the
+// configured SameSite, and scoped to CookieDomain when the host is in it.
+func (s *Server) sessionCookie(name, value string, public publicURL) *http.Cookie {
+	return s.mmOptions.Cookies.Cookie(name, value, public.url())
+}
+
+// hostname strips the port from host[:port].
+func hostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return strings.Trim(host, "[]")
+}
+
+// redirectToSlash wraps mux so that its redirects from a subtree's bare path,
+// e.g. /auth to /auth/, keep the prefix a path-stripping proxy removed and,
+// when a trusted proxy forwarded them, the browser's scheme and host. The
+// mux's own redirect is path-absolute and would drop the prefix.
+func (s *Server) redirectToSlash(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !needsRedirectToSlash(mux, r) {
+			mux.ServeHTTP(w, r)
+			return
+		}
+		public := s.forwardedURL(r, "")
+		location := public.origin() + public.prefix + r.URL.Path + "/"
+		if r.URL.RawQuery != "" {
+			location += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, location, http.StatusMovedPermanently)
+	})
+}
+
+// needsRedirectToSlash reports whether mux would redirect r to its path with
+// a trailing slash, because only the subtree pattern path+"/" is registered.
+func needsRedirectToSlash(mux *http.ServeMux, r *http.Request) bool {
+	if strings.HasSuffix(r.URL.Path, "/") {
+		return false
+	}
+	_, pattern := mux.Handler(r)
+	return pattern == r.URL.Path+"/"
+}
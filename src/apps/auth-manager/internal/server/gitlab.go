@@ -0,0 +1,181 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/gitlab"
+	"github.com/rave-org/rave/apps/auth-manager/internal/shadow"
+	"github.com/rave-org/rave/apps/auth-manager/internal/webhook"
+)
+
+// Shadow attributes for GitLab accounts. attrGitLabBlocked is "true" once
+// deprovisioning blocked the user.
+const (
+	attrGitLabUserID  = "gitlab_user_id"
+	attrGitLabBlocked = "gitlab_blocked"
+)
+
+// provisionGitLabUser ensures the user exists in GitLab with their mapped
+// group memberships, unblocks them if deprovisioning blocked them, and
+// records their GitLab ID on the shadow record. Group membership failures
+// are logged, not returned.
+func (s *Server) provisionGitLabUser(ctx context.Context, info *webhook.UserInfo, shadowUser shadow.ShadowUser) error {
+	user, created, err := s.gitlabClient.EnsureUser(ctx, gitlab.Identity{
+		Email:    info.Email,
+		Name:     info.Name,
+		Username: info.Username,
+	})
+	if err != nil {
+		s.recordGitLabFailure(err)
+		s.logger.WarnContext(ctx, "failed to provision gitlab user", "email", info.Email, "err", err)
+		return fmt.Errorf("gitlab provision: %w", err)
+	}
+	s.recordGitLabSuccess()
+
+	attrs := map[string]string{}
+	if shadowUser.Attributes[attrGitLabBlocked] == "true" {
+		if user.State == gitlab.StateBlocked {
+			if err := s.gitlabClient.UnblockUser(ctx, user.ID); err != nil {
+				s.recordGitLabFailure(err)
+				s.logger.WarnContext(ctx, "failed to unblock gitlab user", "email", info.Email, "gitlab_user_id", user.ID, "err", err)
+				return fmt.Errorf("gitlab unblock: %w", err)
+			}
+			s.logger.InfoContext(ctx, "gitlab user unblocked", "email", info.Email, "gitlab_user_id", user.ID)
+		}
+		attrs[attrGitLabBlocked] = ""
+	}
+	s.ensureGitLabGroups(ctx, user, info.Groups)
+	s.logger.InfoContext(ctx, "user provisioned to gitlab", "email", info.Email, "gitlab_user_id", user.ID, "created", created)
+
+	if id := strconv.Itoa(user.ID); user.ID != 0 && shadowUser.Attributes[attrGitLabUserID] != id {
+		attrs[attrGitLabUserID] = id
+	}
+	if len(attrs) == 0 {
+		return nil
+	}
+	if _, err := s.upsertShadow(ctx, shadowUser.Identity, attrs); err != nil {
+		s.logger.WarnContext(ctx, "failed to record gitlab user id", "email", info.Email, "err", err)
+	}
+	return nil
+}
+
+// gitlabForwardAuth is the /auth/gitlab provisioning hook. It ensures the
+// user and their group memberships exist before GitLab's own SSO login
+// runs, and always lets the request through.
+func (s *Server) gitlabForwardAuth(w http.ResponseWriter, r *http.Request, ident forwardIdentity) bool {
+	if s.gitlabClient == nil {
+		s.logger.DebugContext(r.Context(), "gitlab client not configured, allowing through")
+		return true
+	}
+	if s.gitlabBreaker != nil && !s.gitlabBreaker.Allow() {
+		s.logger.WarnContext(r.Context(), "gitlab circuit open", "email", ident.Email)
+		return true
+	}
+	ctx := r.Context()
+	user, _, err := s.gitlabClient.EnsureUser(ctx, gitlab.Identity{
+		Email:    ident.Email,
+		Name:     ident.Name,
+		Username: ident.Username,
+	})
+	if err != nil {
+		s.recordGitLabFailure(err)
+		s.logger.WarnContext(ctx, "failed to ensure gitlab user (allowing through)", "email", ident.Email, "err", err)
+		return true
+	}
+	s.recordGitLabSuccess()
+	s.ensureGitLabGroups(ctx, user, ident.Groups)
+	if s.cfg.DryRun {
+		w.Header().Set(dryRunHeader, "no-user-created")
+	}
+	return true
+}
+
+// ensureGitLabGroups grants the GitLab group memberships mapped from the
+// user's identity groups, at the highest level any of them maps to.
+// Memberships are never removed or lowered.
+func (s *Server) ensureGitLabGroups(ctx context.Context, user gitlab.User, groups []string) {
+	if len(s.gitlabGroups) == 0 || user.ID == 0 {
+		return
+	}
+	desired := map[string]gitlab.AccessLevel{}
+	for _, group := range groups {
+		for _, m := range s.gitlabGroups[group] {
+			if m.AccessLevel > desired[m.Group] {
+				desired[m.Group] = m.AccessLevel
+			}
+		}
+	}
+	for group, level := range desired {
+		if err := s.gitlabClient.EnsureGroupMember(ctx, group, user.ID, level); err != nil {
+			s.recordGitLabFailure(err)
+			s.logger.WarnContext(ctx, "failed to add gitlab group member", "gitlab_user_id", user.ID, "group", group, "err", err)
+		}
+	}
+}
+
+// blockGitLabUser blocks the user's GitLab account, recording the outcome
+// in attributes. A user GitLab doesn't have is not an error.
+func (s *Server) blockGitLabUser(ctx context.Context, info *webhook.UserInfo, attributes map[string]string) error {
+	userID, err := s.gitlabUserID(ctx, info)
+	if err == nil {
+		err = s.gitlabClient.BlockUser(ctx, userID)
+	}
+	switch {
+	case errors.Is(err, gitlab.ErrNotFound):
+		s.logger.InfoContext(ctx, "no gitlab user to block", "email", info.Email)
+		return nil
+	case err != nil:
+		s.recordGitLabFailure(err)
+		return fmt.Errorf("gitlab block: %w", err)
+	}
+	s.recordGitLabSuccess()
+	attributes[attrGitLabBlocked] = "true"
+	attributes[attrGitLabUserID] = strconv.Itoa(userID)
+	s.logger.InfoContext(ctx, "gitlab user blocked", "email", info.Email, "gitlab_user_id", userID)
+	return nil
+}
+
+// gitlabUserID returns the user's GitLab ID from the shadow record, falling
+// back to an email lookup.
+func (s *Server) gitlabUserID(ctx context.Context, info *webhook.UserInfo) (int, error) {
+	if existing, err := s.shadowStore.Get(ctx, info.ShadowProvider(), info.ShadowSubject()); err == nil {
+		if id, err := strconv.Atoi(existing.Attributes[attrGitLabUserID]); err == nil && id > 0 {
+			return id, nil
+		}
+	}
+	user, err := s.gitlabClient.GetUserByEmail(ctx, info.Email)
+	if err != nil {
+		return 0, err
+	}
+	return user.ID, nil
+}
+
+// recordGitLabFailure counts err against the GitLab breaker unless GitLab
+// answered and refused the request.
+func (s *Server) recordGitLabFailure(err error) {
+	if gitlab.IsClientError(err) {
+		if status := gitlab.StatusCode(err); status == http.StatusUnauthorized || status == http.StatusForbidden {
+			s.logger.Error("gitlab rejected the admin token; check AUTH_MANAGER_GITLAB_TOKEN", "err", err)
+		}
+		return
+	}
+	if s.gitlabBreaker == nil {
+		return
+	}
+	if opened := s.gitlabBreaker.RecordFailure(err); opened {
+		s.logger.Error("gitlab circuit opened", "cooldown", s.gitlabBreaker.Remaining(), "err", err)
+	} else {
+		s.logger.Warn("gitlab operation failed", "err", err)
+	}
+}
+
+func (s *Server) recordGitLabSuccess() {
+	if s.gitlabBreaker == nil {
+		return
+	}
+	s.gitlabBreaker.RecordSuccess()
+}
@@ -0,0 +1,202 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/accounts"
+	"github.com/rave-org/rave/apps/auth-manager/internal/grafana"
+)
+
+// attrGrafanaDisabled is "true" once deprovisioning disabled the user's
+// Grafana account.
+const attrGrafanaDisabled = "grafana_disabled"
+
+// grafanaSyncCache remembers when each user's Grafana role and teams were
+// last synced, so forward auth, which Grafana's asset requests also pass
+// through, doesn't call Grafana on every request.
+type grafanaSyncCache struct {
+	mu     sync.Mutex
+	ttl    time.Duration
+	synced map[string]time.Time // Email + groups → last successful sync
+}
+
+func newGrafanaSyncCache(ttl time.Duration) *grafanaSyncCache {
+	if ttl <= 0 {
+		ttl = 10 * time.Minute
+	}
+	return &grafanaSyncCache{ttl: ttl, synced: map[string]time.Time{}}
+}
+
+// fresh reports whether key was synced within the TTL, dropping expired
+// entries as it goes.
+func (c *grafanaSyncCache) fresh(key string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, at := range c.synced {
+		if now.Sub(at) >= c.ttl {
+			delete(c.synced, k)
+		}
+	}
+	_, ok := c.synced[key]
+	return ok
+}
+
+func (c *grafanaSyncCache) mark(key string, now time.Time) {
+	c.mu.Lock()
+	c.synced[key] = now
+	c.mu.Unlock()
+}
+
+// grafanaSyncKey changes whenever the user's groups do, so a group change
+// is synced right away.
+func grafanaSyncKey(email string, groups []string) string {
+	sorted := append([]string(nil), groups...)
+	sort.Strings(sorted)
+	return accounts.NormalizeEmail(email) + "|" + strings.Join(sorted, "|")
+}
+
+// grafanaForwardAuth is the /auth/grafana provisioning hook. Grafana signs
+// the user in from the proxy headers itself, so a failed sync only logs.
+func (s *Server) grafanaForwardAuth(w http.ResponseWriter, r *http.Request, ident forwardIdentity) bool {
+	if s.grafanaClient == nil {
+		return true
+	}
+	if s.grafanaBreaker != nil && !s.grafanaBreaker.Allow() {
+		s.logger.WarnContext(r.Context(), "grafana circuit open", "email", ident.Email)
+		return true
+	}
+	_ = s.syncGrafanaUser(r.Context(), grafana.Identity{
+		Email: ident.Email,
+		Name:  ident.Name,
+		Login: ident.field("username"),
+	}, ident.Groups)
+	return true
+}
+
+// syncGrafanaUser ensures the user exists in Grafana with the org role and
+// teams their groups map to. Every step is attempted; failures are logged,
+// counted in auth_manager_grafana_sync_failures_total, and returned joined.
+func (s *Server) syncGrafanaUser(ctx context.Context, ident grafana.Identity, groups []string) error {
+	key := grafanaSyncKey(ident.Email, groups)
+	if s.grafanaSynced.fresh(key, time.Now()) {
+		return nil
+	}
+
+	user, created, err := s.grafanaClient.EnsureUser(ctx, ident)
+	if err != nil {
+		s.grafanaFailed("ensure_user", err, "email", ident.Email)
+		return fmt.Errorf("grafana provision: %w", err)
+	}
+	s.recordGrafanaSuccess()
+	if created {
+		s.logger.InfoContext(ctx, "grafana user created", "email", ident.Email, "grafana_user_id", user.ID)
+	}
+	var errs []error
+
+	if role := s.desiredGrafanaRole(groups); role != "" {
+		if err := s.grafanaClient.SetOrgRole(ctx, user, role); err != nil {
+			s.grafanaFailed("set_role", err, "email", ident.Email, "role", role)
+			errs = append(errs, fmt.Errorf("grafana role %s: %w", role, err))
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, group := range groups {
+		for _, team := range s.grafanaTeams[group] {
+			if seen[team] {
+				continue
+			}
+			seen[team] = true
+			teamID, err := s.grafanaClient.TeamID(ctx, team)
+			if err == nil {
+				err = s.grafanaClient.AddTeamMember(ctx, teamID, user.ID)
+			}
+			if errors.Is(err, grafana.ErrNotFound) {
+				s.logger.WarnContext(ctx, "mapped grafana team does not exist", "team", team)
+			}
+			if err != nil {
+				s.grafanaFailed("add_team", err, "email", ident.Email, "team", team)
+				errs = append(errs, fmt.Errorf("grafana team %s: %w", team, err))
+			}
+		}
+	}
+	if len(errs) == 0 {
+		s.grafanaSynced.mark(key, time.Now())
+	}
+	return errors.Join(errs...)
+}
+
+// setGrafanaDisabled disables or re-enables the user's Grafana account,
+// recording the outcome in attributes. A user Grafana doesn't have is not an
+// error.
+func (s *Server) setGrafanaDisabled(ctx context.Context, email string, disabled bool, attributes map[string]string) error {
+	user, err := s.grafanaClient.LookupUser(ctx, email)
+	switch {
+	case errors.Is(err, grafana.ErrNotFound):
+		attributes[attrGrafanaDisabled] = ""
+		return nil
+	case err != nil:
+		s.recordGrafanaFailure(err)
+		return fmt.Errorf("grafana lookup: %w", err)
+	}
+	if user.IsDisabled != disabled {
+		action, update := "enable", s.grafanaClient.EnableUser
+		if disabled {
+			action, update = "disable", s.grafanaClient.DisableUser
+		}
+		if err := update(ctx, user.ID); err != nil {
+			s.recordGrafanaFailure(err)
+			return fmt.Errorf("grafana %s: %w", action, err)
+		}
+	}
+	s.recordGrafanaSuccess()
+	attributes[attrGrafanaDisabled] = ""
+	if disabled {
+		attributes[attrGrafanaDisabled] = "true"
+	}
+	s.logger.InfoContext(ctx, "grafana user updated", "email", email, "grafana_user_id", user.ID, "disabled", disabled)
+	return nil
+}
+
+// desiredGrafanaRole returns the highest org role the groups map to, or ""
+// when none does.
+func (s *Server) desiredGrafanaRole(groups []string) string {
+	var role string
+	for _, group := range groups {
+		if mapped := s.grafanaRoles[group]; grafana.RoleRank(mapped) > grafana.RoleRank(role) {
+			role = mapped
+		}
+	}
+	return role
+}
+
+func (s *Server) grafanaFailed(step string, err error, logArgs ...any) {
+	s.grafanaFailures.WithLabelValues(step).Inc()
+	s.recordGrafanaFailure(err)
+	s.logger.Warn("grafana sync failed", append(logArgs, "step", step, "status", grafana.StatusCode(err), "err", err)...)
+}
+
+// recordGrafanaFailure counts err against the Grafana breaker unless Grafana
+// answered and refused the request.
+func (s *Server) recordGrafanaFailure(err error) {
+	if grafana.IsClientError(err) || s.grafanaBreaker == nil {
+		return
+	}
+	if opened := s.grafanaBreaker.RecordFailure(err); opened {
+		s.logger.Error("grafana circuit opened", "cooldown", s.grafanaBreaker.Remaining(), "err", err)
+	}
+}
+
+func (s *Server) recordGrafanaSuccess() {
+	if s.grafanaBreaker == nil {
+		return
+	}
+	s.grafanaBreaker.RecordSuccess()
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"context"
+	"strings"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/mattermost"
+)
+
+// guestTier reports whether groups place the identity in the guest tier. known
+// is false when no guest groups are configured or the source didn't report
+// groups, in which case the user's current tier is left alone.
+func (s *Server) guestTier(groups []string) (guest, known bool) {
+	if len(s.cfg.MattermostGuestGroups) == 0 || groups == nil {
+		return false, false
+	}
+	for _, group := range groups {
+		for _, guestGroup := range s.cfg.MattermostGuestGroups {
+			if strings.EqualFold(group, guestGroup) {
+				return true, true
+			}
+		}
+	}
+	return false, true
+}
+
+// onboardMattermostUser runs the post-EnsureUser hooks: it moves the user
+// between the guest and member tiers to match their groups, joins the
+// memberships for their tier when they are new to it, and syncs roles for
+// members. It returns the user with updated roles.
+func (s *Server) onboardMattermostUser(ctx context.Context, user mattermost.User, created bool, groups []string) mattermost.User {
+	guest, known := s.guestTier(groups)
+	switch {
+	case known && guest && !user.IsGuest():
+		if err := s.mattermost().DemoteToGuest(ctx, user.ID); err != nil {
+			// Leave the user without memberships rather than give a guest
+			// the member defaults.
+			s.recordMattermostFailure(mmOpEnsureUser, err)
+			s.logger.ErrorContext(ctx, "failed to demote mattermost user to guest", "user_id", user.ID, "err", err)
+			return user
+		}
+		s.recordMattermostSuccess(mmOpEnsureUser)
+		user.Roles = "system_guest"
+		s.logger.InfoContext(ctx, "mattermost user set to guest", "user_id", user.ID, "created", created)
+		s.joinGuestMemberships(ctx, user)
+		return user
+	case known && !guest && user.IsGuest():
+		if err := s.mattermost().PromoteToUser(ctx, user.ID); err != nil {
+			s.recordMattermostFailure(mmOpEnsureUser, err)
+			s.logger.ErrorContext(ctx, "failed to promote mattermost guest", "user_id", user.ID, "err", err)
+			return user
+		}
+		s.recordMattermostSuccess(mmOpEnsureUser)
+		user.Roles = "system_user"
+		s.logger.InfoContext(ctx, "mattermost guest promoted to member", "user_id", user.ID)
+		s.joinDefaultMemberships(ctx, user)
+	case created && user.IsGuest():
+		s.joinGuestMemberships(ctx, user)
+	case created:
+		s.joinDefaultMemberships(ctx, user)
+	}
+
+	if !user.IsGuest() {
+		s.syncMattermostRoles(ctx, user, groups)
+	}
+	return user
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// httpMetrics instruments the HTTP server by route.
+type httpMetrics struct {
+	duration  *prometheus.HistogramVec
+	responses *prometheus.CounterVec
+	inFlight  *prometheus.GaugeVec
+}
+
+func newHTTPMetrics(reg prometheus.Registerer) *httpMetrics {
+	m := &httpMetrics{
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "auth_manager_http_request_duration_seconds",
+			Help:    "HTTP request duration by route and status class",
+			Buckets: latencyBuckets,
+		}, []string{"route", "status_class"}),
+		responses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "auth_manager_http_responses_total",
+			Help: "HTTP responses by route and status code",
+		}, []string{"route", "code"}),
+		inFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "auth_manager_http_requests_in_flight",
+			Help: "HTTP requests being served, by route",
+		}, []string{"route"}),
+	}
+	reg.MustRegister(m.duration, m.responses, m.inFlight)
+	return m
+}
+
+// instrument records metrics for each request next serves, labelled with
+// the mux route that matches it so paths with IDs in them don't each get a
+// series.
+func (s *Server) instrument(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := metricsRoute(mux, r)
+		inFlight := s.httpMetrics.inFlight.WithLabelValues(route)
+		inFlight.Inc()
+		defer inFlight.Dec()
+
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		s.httpMetrics.duration.WithLabelValues(route, strconv.Itoa(rec.status/100)+"xx").Observe(time.Since(start).Seconds())
+		s.httpMetrics.responses.WithLabelValues(route, strconv.Itoa(rec.status)).Inc()
+	})
+}
+
+// metricsRoute names the route r matches, or "unmatched" for requests no
+// route matches.
+func metricsRoute(mux *http.ServeMux, r *http.Request) string {
+	_, pattern := mux.Handler(r)
+	if pattern == "" {
+		return "unmatched"
+	}
+	return routeName(pattern)
+}
+
+// routeName is a mux pattern as documented: the subtree patterns' variable
+// part is spelled out.
+func routeName(pattern string) string {
+	switch pattern {
+	case "/auth/":
+		return "/auth/{service}"
+	case "/webhook/authentik/":
+		return "/webhook/authentik/{source}"
+	case "/api/v1/users/":
+		return "/api/v1/users/{email}/status"
+	}
+	return pattern
+}
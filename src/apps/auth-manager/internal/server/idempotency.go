@@ -0,0 +1,286 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/apperror"
+)
+
+const (
+	idempotencyKeyHeader = "Idempotency-Key"
+	// idempotentReplayHeader marks a response replayed from an earlier
+	// request with the same key.
+	idempotentReplayHeader = "Idempotent-Replayed"
+
+	maxIdempotencyKeyLength = 255
+	// maxIdempotentResponseBytes bounds the response remembered for a key.
+	// Larger ones, such as a long session cleanup stream, aren't
+	// remembered, so a retry runs again.
+	maxIdempotentResponseBytes = 1 << 20
+	idempotencySweepInterval   = time.Minute
+	defaultIdempotencyTTL      = 24 * time.Hour
+)
+
+// The outcomes of a request with an Idempotency-Key, the result label of
+// auth_manager_idempotency_requests_total.
+const (
+	idempotencyNew        = "new"
+	idempotencyReplayed   = "replayed"
+	idempotencyInProgress = "in_progress"
+	idempotencyMismatch   = "mismatch"
+	idempotencyUntracked  = "untracked" // every key is in flight
+)
+
+// idempotencyCache remembers the responses to requests sent with an
+// Idempotency-Key, by endpoint and key, for ttl. Keys past the count bound
+// push out the oldest finished one; keys still in flight are never evicted,
+// since forgetting one would let a duplicate run.
+type idempotencyCache struct {
+	ttl      time.Duration
+	maxKeys  int
+	requests *prometheus.CounterVec
+	now      func() time.Time
+
+	mu        sync.Mutex
+	entries   map[string]*idempotencyEntry
+	nextSweep time.Time
+}
+
+type idempotencyEntry struct {
+	fingerprint [sha256.Size]byte
+	created     time.Time
+	done        bool
+
+	status      int
+	contentType string
+	body        []byte
+}
+
+// newIdempotencyCache returns a cache of up to maxKeys keys, or nil when
+// maxKeys is 0.
+func newIdempotencyCache(ttl time.Duration, maxKeys int, reg prometheus.Registerer) *idempotencyCache {
+	if maxKeys <= 0 {
+		return nil
+	}
+	if ttl <= 0 {
+		ttl = defaultIdempotencyTTL
+	}
+	c := &idempotencyCache{
+		ttl:     ttl,
+		maxKeys: maxKeys,
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "auth_manager_idempotency_requests_total",
+			Help: "Requests with an Idempotency-Key by endpoint and result (new, replayed, in_progress, mismatch, untracked)",
+		}, []string{"endpoint", "result"}),
+		now:     time.Now,
+		entries: map[string]*idempotencyEntry{},
+	}
+	reg.MustRegister(c.requests, prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "auth_manager_idempotency_keys",
+		Help: "Idempotency keys remembered, finished or in flight",
+	}, func() float64 {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return float64(len(c.entries))
+	}))
+	return c
+}
+
+// begin claims key for a request whose query and body hash to
+// fingerprint. Unless the outcome is idempotencyNew the request must not
+// run; for idempotencyReplayed the entry holds the response to send.
+func (c *idempotencyCache) begin(key string, fingerprint [sha256.Size]byte) (*idempotencyEntry, string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	if !now.Before(c.nextSweep) {
+		c.sweep(now)
+		c.nextSweep = now.Add(idempotencySweepInterval)
+	}
+
+	if entry, ok := c.entries[key]; ok && !c.expired(entry, now) {
+		switch {
+		case entry.fingerprint != fingerprint:
+			return nil, idempotencyMismatch
+		case !entry.done:
+			return nil, idempotencyInProgress
+		}
+		return entry, idempotencyReplayed
+	}
+	delete(c.entries, key)
+	if len(c.entries) >= c.maxKeys {
+		c.sweep(now)
+		if len(c.entries) >= c.maxKeys && !c.evictOldest() {
+			return nil, idempotencyUntracked
+		}
+	}
+	c.entries[key] = &idempotencyEntry{fingerprint: fingerprint, created: now}
+	return nil, idempotencyNew
+}
+
+// finish records the response to key's request, or forgets the key when
+// the response isn't worth replaying, so a retry runs again: server
+// errors, conflicts, rate limiting, and responses too large to keep.
+func (c *idempotencyCache) finish(key string, rec *idempotencyRecorder) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || entry.done {
+		return
+	}
+	switch status := rec.status; {
+	case status == 0, status >= 500, rec.overflow,
+		status == http.StatusRequestTimeout, status == http.StatusConflict, status == http.StatusTooManyRequests:
+		delete(c.entries, key)
+		return
+	}
+	entry.done = true
+	entry.status = rec.status
+	entry.contentType = rec.Header().Get("Content-Type")
+	entry.body = bytes.Clone(rec.body.Bytes())
+}
+
+func (c *idempotencyCache) expired(entry *idempotencyEntry, now time.Time) bool {
+	return entry.done && now.Sub(entry.created) >= c.ttl
+}
+
+func (c *idempotencyCache) sweep(now time.Time) {
+	for key, entry := range c.entries {
+		if c.expired(entry, now) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// evictOldest drops the oldest finished key, reporting whether there was
+// one.
+func (c *idempotencyCache) evictOldest() bool {
+	var oldest string
+	var oldestAt time.Time
+	for key, entry := range c.entries {
+		if entry.done && (oldest == "" || entry.created.Before(oldestAt)) {
+			oldest, oldestAt = key, entry.created
+		}
+	}
+	if oldest == "" {
+		return false
+	}
+	delete(c.entries, oldest)
+	return true
+}
+
+// idempotent makes POSTs to handler sent with an Idempotency-Key run once:
+// a retry with the same key and request gets the first response again,
+// with Idempotent-Replayed: true, while one sent before the first finishes
+// gets a 409, and one with a different body a 422. Keys are scoped to
+// endpoint. Requests without the header, or with the cache off, run as
+// usual.
+func (s *Server) idempotent(endpoint string, handler http.HandlerFunc) http.HandlerFunc {
+	if s.idempotency == nil {
+		return handler
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if key == "" || r.Method != http.MethodPost {
+			handler(w, r)
+			return
+		}
+		if !validIdempotencyKey(key) {
+			s.respondError(w, r, invalidRequest("Idempotency-Key must be 1 to 255 printable ASCII characters"))
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			s.respondBodyError(w, r, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		h := sha256.New()
+		h.Write([]byte(r.URL.RawQuery))
+		h.Write([]byte{0})
+		h.Write(body)
+		var fingerprint [sha256.Size]byte
+		copy(fingerprint[:], h.Sum(nil))
+
+		scoped := endpoint + " " + key
+		entry, outcome := s.idempotency.begin(scoped, fingerprint)
+		s.idempotency.requests.WithLabelValues(endpoint, outcome).Inc()
+		switch outcome {
+		case idempotencyReplayed:
+			s.logger.InfoContext(r.Context(), "replaying idempotent request", "endpoint", endpoint, "status", entry.status)
+			if entry.contentType != "" {
+				w.Header().Set("Content-Type", entry.contentType)
+			}
+			w.Header().Set(idempotentReplayHeader, "true")
+			w.WriteHeader(entry.status)
+			w.Write(entry.body)
+			return
+		case idempotencyInProgress:
+			s.respondError(w, r, apperror.New(http.StatusConflict, apperror.CodeIdempotencyKeyInUse, "a request with this Idempotency-Key is still in progress"))
+			return
+		case idempotencyMismatch:
+			s.respondError(w, r, apperror.New(http.StatusUnprocessableEntity, apperror.CodeIdempotencyKeyReused, "this Idempotency-Key was used for a different request"))
+			return
+		case idempotencyUntracked:
+			s.logger.WarnContext(r.Context(), "idempotency keys exhausted by requests in flight, running without one", "endpoint", endpoint)
+			handler(w, r)
+			return
+		}
+
+		rec := &idempotencyRecorder{ResponseWriter: w}
+		defer s.idempotency.finish(scoped, rec)
+		handler(rec, r)
+	}
+}
+
+func validIdempotencyKey(key string) bool {
+	if len(key) > maxIdempotencyKeyLength {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < 0x20 || key[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// idempotencyRecorder passes a response through while keeping a copy of
+// it. Unwrap lets handlers flush and extend deadlines as usual.
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status   int
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (r *idempotencyRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *idempotencyRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	if !r.overflow {
+		if r.body.Len()+len(b) > maxIdempotentResponseBytes {
+			r.overflow = true
+			r.body = bytes.Buffer{}
+		} else {
+			r.body.Write(b)
+		}
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *idempotencyRecorder) Unwrap() http.ResponseWriter { return r.ResponseWriter }
@@ -0,0 +1,38 @@
+package server
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// latencyBuckets suit sub-second HTTP calls, from 5ms to 5s.
+var latencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5}
+
+// latencyObserver adapts a HistogramVec with operation and outcome labels to
+// the mattermost and n8n client Metrics hooks.
+type latencyObserver struct {
+	vec *prometheus.HistogramVec
+}
+
+func newLatencyObserver(reg prometheus.Registerer, name, help string) latencyObserver {
+	vec := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    name,
+		Help:    help,
+		Buckets: latencyBuckets,
+	}, []string{"operation", "outcome"})
+	reg.MustRegister(vec)
+	return latencyObserver{vec: vec}
+}
+
+func (o latencyObserver) ObserveRequest(operation, outcome string, duration time.Duration) {
+	o.vec.WithLabelValues(operation, outcome).Observe(duration.Seconds())
+}
+
+// errorOutcome labels an operation's result for duration histograms.
+func errorOutcome(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// errStopping is returned by lifecycle.goWorker once shutdown has begun.
+var errStopping = errors.New("server is shutting down")
+
+// lifecycle tracks the server's background workers: they run under a root
+// context that stop cancels, and stop waits for them to return.
+type lifecycle struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	wg       sync.WaitGroup
+	stopping bool
+	running  map[string]int // worker name → instances running
+}
+
+func newLifecycle() *lifecycle {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &lifecycle{ctx: ctx, cancel: cancel, running: map[string]int{}}
+}
+
+// goWorker runs fn in a goroutine with the root context, or returns
+// errStopping without running it once stop has been called.
+func (l *lifecycle) goWorker(name string, fn func(ctx context.Context)) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.stopping {
+		return errStopping
+	}
+	l.running[name]++
+	l.wg.Add(1)
+	go func() {
+		defer func() {
+			l.mu.Lock()
+			if l.running[name]--; l.running[name] == 0 {
+				delete(l.running, name)
+			}
+			l.mu.Unlock()
+			l.wg.Done()
+		}()
+		fn(l.ctx)
+	}()
+	return nil
+}
+
+// workers reports how many instances of each worker are running.
+func (l *lifecycle) workers() map[string]int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	running := make(map[string]int, len(l.running))
+	for name, n := range l.running {
+		running[name] = n
+	}
+	return running
+}
+
+// stop refuses new workers, cancels the root context, and waits for the
+// running workers until ctx ends. It reports the workers still running then.
+func (l *lifecycle) stop(ctx context.Context) error {
+	l.mu.Lock()
+	l.stopping = true
+	l.mu.Unlock()
+	l.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		l.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	names := make([]string, 0, len(l.running))
+	for name, n := range l.running {
+		if n > 1 {
+			name = fmt.Sprintf("%s (%d)", name, n)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return fmt.Errorf("background workers still running: %s: %w", strings.Join(names, ", "), ctx.Err())
+}
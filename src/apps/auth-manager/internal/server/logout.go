@@ -0,0 +1,68 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/apperror"
+	"github.com/rave-org/rave/apps/auth-manager/internal/n8n"
+	"github.com/rave-org/rave/apps/auth-manager/pkg/mmbridge"
+)
+
+// handleLogout serves /auth/logout. It revokes the Mattermost session in the
+// MMAUTHTOKEN cookie, expires the cookies forward auth sets, and redirects to
+// LogoutRedirectURL when configured. The cookies are cleared even when
+// Mattermost can't be reached: the browser is logged out either way, and the
+// failed revocation is logged and counted.
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		s.respondError(w, r, apperror.MethodNotAllowed)
+		return
+	}
+
+	result := "none"
+	if cookie, err := r.Cookie(mmbridge.AuthTokenCookie); err == nil && cookie.Value != "" {
+		result = s.revokeLogoutSession(r, cookie.Value)
+	}
+	s.logouts.WithLabelValues(result).Inc()
+
+	public := s.forwardedURL(r, s.cfg.MattermostURL)
+	for _, cookie := range s.mattermostBridge(nil).ExpiredCookies(public.url()) {
+		http.SetCookie(w, cookie)
+	}
+	if s.cfg.N8NIssueSessions {
+		cookie := s.n8nCookie(r, n8n.Session{})
+		cookie.MaxAge = -1
+		http.SetCookie(w, cookie)
+		if ident, err := s.identityFromRequest(r); err == nil {
+			s.n8nSessions.invalidate(ident.Email)
+		}
+	}
+
+	if s.cfg.LogoutRedirectURL != "" {
+		http.Redirect(w, r, s.cfg.LogoutRedirectURL, http.StatusSeeOther)
+		return
+	}
+	s.respondJSON(w, http.StatusOK, map[string]string{"status": "logged_out", "mattermost_session": result})
+}
+
+// revokeLogoutSession revokes the Mattermost session token and drops it
+// from the session cache, reporting revoked or revoke_failed.
+func (s *Server) revokeLogoutSession(r *http.Request, token string) string {
+	// A cached copy would hand the revoked token straight back on the next
+	// forward auth.
+	s.sessionCache.invalidateToken(token)
+	if s.cfg.DryRun {
+		s.logger.InfoContext(r.Context(), "dry run: would revoke mattermost session on logout")
+		return "revoked"
+	}
+	if s.mattermost() == nil {
+		return "revoke_failed"
+	}
+	if err := s.mattermost().RevokeSessionByToken(r.Context(), token); err != nil {
+		s.logger.WarnContext(r.Context(), "failed to revoke mattermost session on logout; cookies cleared anyway", "err", err)
+		return "revoke_failed"
+	}
+	s.logger.InfoContext(r.Context(), "mattermost session revoked on logout")
+	return "revoked"
+}
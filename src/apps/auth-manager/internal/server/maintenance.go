@@ -0,0 +1,314 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/apperror"
+	"github.com/rave-org/rave/apps/auth-manager/internal/config"
+	"github.com/rave-org/rave/apps/auth-manager/internal/httpx"
+	"github.com/rave-org/rave/apps/auth-manager/internal/webhook"
+)
+
+// maxMaintenanceQueue bounds the webhook events held during maintenance.
+// Past it events are refused with 503, so senders that retry do.
+const maxMaintenanceQueue = 10000
+
+// maintenanceReason is the result.Deferred of provisioning during
+// maintenance.
+const maintenanceReason = "maintenance mode"
+
+// maintenance is the runtime switch that pauses provisioning, typically
+// during a Mattermost upgrade. While it's on, provisioning is deferred,
+// forward auth lets users with a session through without creating any, and
+// user events from webhooks and the event stream are queued, to be applied
+// in order once it ends. It's held in memory: a restart ends it, and the
+// queue with it.
+type maintenance struct {
+	changes  *prometheus.CounterVec
+	deferred *prometheus.CounterVec
+
+	mu      sync.Mutex
+	enabled bool
+	since   time.Time
+	until   time.Time // zero without auto-expiry
+	reason  string
+	timer   *time.Timer
+	window  uint64 // counts windows, so a stale timer can't end a newer one
+	queue   []queuedEvent
+}
+
+// queuedEvent is a user event held until maintenance ends.
+type queuedEvent struct {
+	event     *webhook.AuthentikEvent
+	source    config.WebhookSource
+	requestID string
+}
+
+// maintenanceStatus is the maintenance summary reported by /healthz and the
+// maintenance endpoint.
+type maintenanceStatus struct {
+	Enabled   bool   `json:"enabled"`
+	Since     string `json:"since,omitempty"`
+	ExpiresAt string `json:"expires_at,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+	Queued    int    `json:"queued_events"`
+}
+
+func newMaintenance(reg prometheus.Registerer) *maintenance {
+	m := &maintenance{
+		changes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "auth_manager_maintenance_changes_total",
+			Help: "Maintenance mode switched on or off, by state (on, off) and trigger (api, expired)",
+		}, []string{"state", "trigger"}),
+		deferred: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "auth_manager_maintenance_deferred_total",
+			Help: "Work held back by maintenance mode, by kind (provision, forward_auth, event) and result (deferred, passed, refused, replayed, dropped)",
+		}, []string{"kind", "result"}),
+	}
+	reg.MustRegister(m.changes, m.deferred)
+	return m
+}
+
+// active reports whether maintenance is on. An expired window is off even
+// before its timer has fired.
+func (m *maintenance) active() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.activeLocked()
+}
+
+func (m *maintenance) activeLocked() bool {
+	return m.enabled && (m.until.IsZero() || time.Now().Before(m.until))
+}
+
+// remaining is how long the maintenance window has left, or zero when it
+// doesn't expire or is off.
+func (m *maintenance) remaining() time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.activeLocked() || m.until.IsZero() {
+		return 0
+	}
+	return time.Until(m.until)
+}
+
+func (m *maintenance) status() maintenanceStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	status := maintenanceStatus{Enabled: m.activeLocked(), Queued: len(m.queue)}
+	if !status.Enabled {
+		return status
+	}
+	status.Since = m.since.UTC().Format(time.RFC3339)
+	if !m.until.IsZero() {
+		status.ExpiresAt = m.until.UTC().Format(time.RFC3339)
+	}
+	status.Reason = m.reason
+	return status
+}
+
+// enqueue holds ev until maintenance ends. It reports false when
+// maintenance is off, and errMaintenanceQueueFull when the queue is full.
+func (m *maintenance) enqueue(ev queuedEvent) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.activeLocked() {
+		return false, nil
+	}
+	if len(m.queue) >= maxMaintenanceQueue {
+		m.deferred.WithLabelValues("event", "refused").Inc()
+		return true, errMaintenanceQueueFull
+	}
+	m.queue = append(m.queue, ev)
+	m.deferred.WithLabelValues("event", "deferred").Inc()
+	return true, nil
+}
+
+// errMaintenanceQueueFull refuses a user event that can't be queued.
+var errMaintenanceQueueFull = apperror.New(http.StatusServiceUnavailable, apperror.CodeMaintenance,
+	"auth-manager is in maintenance mode and its event queue is full; retry later")
+
+// setMaintenance switches maintenance on, for d when d > 0, or off. An
+// existing window is replaced. Switching off applies the queued events in
+// the background.
+func (s *Server) setMaintenance(enabled bool, d time.Duration, reason, trigger string) maintenanceStatus {
+	return s.switchMaintenance(enabled, d, reason, trigger, 0)
+}
+
+// switchMaintenance is setMaintenance, doing nothing when window is set and
+// isn't the current window: an expiry timer firing just as it's replaced.
+func (s *Server) switchMaintenance(enabled bool, d time.Duration, reason, trigger string, window uint64) maintenanceStatus {
+	m := s.maintenance
+	m.mu.Lock()
+	if window != 0 && window != m.window {
+		m.mu.Unlock()
+		return m.status()
+	}
+	m.window++
+	wasActive, wasOn := m.activeLocked(), m.enabled
+	if m.timer != nil {
+		m.timer.Stop()
+		m.timer = nil
+	}
+	var queued []queuedEvent
+	if enabled {
+		if !wasActive {
+			m.since = time.Now()
+		}
+		m.enabled, m.reason, m.until = true, reason, time.Time{}
+		if d > 0 {
+			m.until = time.Now().Add(d)
+			window := m.window
+			m.timer = time.AfterFunc(d, func() { s.switchMaintenance(false, 0, "", "expired", window) })
+		}
+	} else {
+		m.enabled, m.reason, m.until = false, "", time.Time{}
+		queued, m.queue = m.queue, nil
+	}
+	m.mu.Unlock()
+
+	switch {
+	case enabled && !wasActive:
+		m.changes.WithLabelValues("on", trigger).Inc()
+		s.logger.Warn("maintenance mode on: provisioning paused", "trigger", trigger, "duration", d.String(), "reason", reason)
+	case enabled:
+		s.logger.Info("maintenance window changed", "duration", d.String(), "reason", reason)
+	case wasOn:
+		m.changes.WithLabelValues("off", trigger).Inc()
+		s.logger.Warn("maintenance mode off: provisioning resumed", "trigger", trigger, "queued_events", len(queued))
+	}
+	if len(queued) > 0 {
+		s.replayQueuedEvents(queued)
+	}
+	return m.status()
+}
+
+// replayQueuedEvents applies the events queued during maintenance, in the
+// order they arrived, under the request IDs they arrived with.
+func (s *Server) replayQueuedEvents(queued []queuedEvent) {
+	err := s.lifecycle.goWorker("maintenance_replay", func(ctx context.Context) {
+		for i, q := range queued {
+			if ctx.Err() != nil {
+				s.maintenance.deferred.WithLabelValues("event", "dropped").Add(float64(len(queued) - i))
+				s.logger.Warn("shutting down; queued maintenance events dropped", "dropped", len(queued)-i)
+				return
+			}
+			s.replayEvent(ctx, q)
+		}
+		s.logger.Info("queued maintenance events applied", "events", len(queued))
+	})
+	if err != nil {
+		s.maintenance.deferred.WithLabelValues("event", "dropped").Add(float64(len(queued)))
+		s.logger.Warn("queued maintenance events dropped", "events", len(queued), "err", err)
+	}
+}
+
+func (s *Server) replayEvent(ctx context.Context, q queuedEvent) {
+	ctx = httpx.WithRequestID(ctx, q.requestID)
+	if budget := s.requestBudget("/webhook/"); budget > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, budget)
+		defer cancel()
+	}
+	res := s.processEvent(ctx, q.event, q.source)
+	s.webhookEvents.WithLabelValues(webhookActionLabel(q.event.Action()), res.outcome).Inc()
+	if res.outcome != "deferred" {
+		s.maintenance.deferred.WithLabelValues("event", "replayed").Inc()
+	}
+	if res.outcome == "error" {
+		s.logger.ErrorContext(ctx, "queued maintenance event failed", "source", q.source.Name, "action", q.event.Action(), "err", res.err)
+	}
+}
+
+// deferEvent queues a user event from source while maintenance is on. It
+// reports whether it did, with the result to answer the sender with.
+func (s *Server) deferEvent(ctx context.Context, event *webhook.AuthentikEvent, source config.WebhookSource, email string) (eventResult, bool) {
+	queued, err := s.maintenance.enqueue(queuedEvent{event: event, source: source, requestID: httpx.RequestID(ctx)})
+	if !queued {
+		return eventResult{}, false
+	}
+	if err != nil {
+		s.logger.WarnContext(ctx, "maintenance queue full; refusing event", "email", email, "action", event.Action())
+		return eventResult{outcome: "error", err: err}, true
+	}
+	s.logger.InfoContext(ctx, "maintenance mode: event queued", "email", email, "action", event.Action())
+	return eventResult{outcome: "deferred", status: http.StatusAccepted, body: map[string]string{
+		"status": "deferred",
+		"reason": maintenanceReason,
+		"email":  email,
+	}}, true
+}
+
+// maintenanceForwardAuth stands in for a service's provisioning hook during
+// maintenance, creating no user or session. A service whose sessions live in
+// a cookie lets users who have one through and turns the rest away with 503
+// and the error page; the others are let through unprovisioned. It returns
+// false when it has written the response.
+func (s *Server) maintenanceForwardAuth(w http.ResponseWriter, r *http.Request, service string, hook forwardProvisioner) bool {
+	if hook.sessionCookie == "" {
+		s.maintenance.deferred.WithLabelValues("forward_auth", "passed").Inc()
+		return true
+	}
+	if _, err := r.Cookie(hook.sessionCookie); err == nil {
+		s.maintenance.deferred.WithLabelValues("forward_auth", "passed").Inc()
+		return true
+	}
+	s.maintenance.deferred.WithLabelValues("forward_auth", "refused").Inc()
+	if left := s.maintenance.remaining(); left > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(max(1, int(left.Seconds()))))
+	}
+	w.Header().Set("X-Rave-Auth-Error", "maintenance")
+	s.logger.InfoContext(r.Context(), "maintenance mode: no session created", "service", service)
+	s.authError(w, r, "Sign-in is paused for maintenance", http.StatusServiceUnavailable)
+	return false
+}
+
+// maintenanceRequest is the body of POST /api/v1/admin/maintenance.
+type maintenanceRequest struct {
+	Enabled bool `json:"enabled"`
+	// Duration ends maintenance automatically after it, such as "30m".
+	// Empty keeps it on until switched off.
+	Duration string `json:"duration,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// handleMaintenance reports maintenance mode on GET, and switches it on or
+// off on POST.
+func (s *Server) handleMaintenance(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.respondJSON(w, http.StatusOK, s.maintenance.status())
+	case http.MethodPost:
+		var payload maintenanceRequest
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil && !errors.Is(err, io.EOF) {
+			s.respondBodyError(w, r, err)
+			return
+		}
+		var d time.Duration
+		if payload.Duration != "" {
+			var err error
+			if d, err = time.ParseDuration(payload.Duration); err != nil || d <= 0 {
+				s.respondError(w, r, invalidRequest("duration must be a positive Go duration, such as 30m"))
+				return
+			}
+			if !payload.Enabled {
+				s.respondError(w, r, invalidRequest("duration only applies when enabling maintenance"))
+				return
+			}
+		}
+		s.logger.InfoContext(r.Context(), "maintenance mode requested", "enabled", payload.Enabled, "duration", payload.Duration, "client", s.clientIP(r))
+		s.respondJSON(w, http.StatusOK, s.setMaintenance(payload.Enabled, d, payload.Reason, "api"))
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		s.respondError(w, r, apperror.MethodNotAllowed)
+	}
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"context"
+	"strings"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/mattermost"
+)
+
+// joinDefaultMemberships adds a newly created Mattermost user to the
+// configured default teams and channels. Failures are logged and counted but
+// never fail provisioning.
+func (s *Server) joinDefaultMemberships(ctx context.Context, user mattermost.User) {
+	s.joinMemberships(ctx, user, s.cfg.MattermostDefaultTeams, s.cfg.MattermostDefaultChannels)
+}
+
+// joinGuestMemberships adds a guest to the configured guest teams and
+// channels only.
+func (s *Server) joinGuestMemberships(ctx context.Context, user mattermost.User) {
+	s.joinMemberships(ctx, user, s.cfg.MattermostGuestTeams, s.cfg.MattermostGuestChannels)
+}
+
+// joinMemberships joins user to defaultTeams and to channels, which are
+// "team/channel" or a bare name looked up in every team in defaultTeams.
+func (s *Server) joinMemberships(ctx context.Context, user mattermost.User, defaultTeams, channels []string) {
+	if len(defaultTeams) == 0 && len(channels) == 0 {
+		return
+	}
+
+	teamIDs := map[string]string{}
+	resolveTeam := func(name string) (string, bool) {
+		if id, ok := teamIDs[name]; ok {
+			return id, id != ""
+		}
+		team, err := s.mattermost().GetTeamByName(ctx, name)
+		if err != nil {
+			s.joinFailures.WithLabelValues("team").Inc()
+			s.logger.WarnContext(ctx, "mattermost team lookup failed", "team", name, "err", err)
+			teamIDs[name] = ""
+			return "", false
+		}
+		teamIDs[name] = team.ID
+		return team.ID, true
+	}
+
+	for _, name := range defaultTeams {
+		teamID, ok := resolveTeam(name)
+		if !ok {
+			continue
+		}
+		if err := s.mattermost().AddUserToTeam(ctx, teamID, user.ID); err != nil {
+			s.joinFailures.WithLabelValues("team").Inc()
+			s.logger.WarnContext(ctx, "failed to add user to mattermost team", "team", name, "user_id", user.ID, "err", err)
+			teamIDs[name] = "" // Can't join channels in a team the user isn't on
+			continue
+		}
+		s.logger.InfoContext(ctx, "user added to mattermost team", "team", name, "user_id", user.ID)
+	}
+
+	for _, spec := range channels {
+		teams := defaultTeams
+		channelName := spec
+		if team, channel, ok := strings.Cut(spec, "/"); ok {
+			teams, channelName = []string{team}, channel
+		}
+		for _, teamName := range teams {
+			teamID, ok := resolveTeam(teamName)
+			if !ok {
+				continue
+			}
+			channel, err := s.mattermost().GetChannelByName(ctx, teamID, channelName)
+			if err != nil {
+				s.joinFailures.WithLabelValues("channel").Inc()
+				s.logger.WarnContext(ctx, "mattermost channel lookup failed", "team", teamName, "channel", channelName, "err", err)
+				continue
+			}
+			if err := s.mattermost().AddUserToChannel(ctx, channel.ID, user.ID); err != nil {
+				s.joinFailures.WithLabelValues("channel").Inc()
+				s.logger.WarnContext(ctx, "failed to add user to mattermost channel", "team", teamName, "channel", channelName, "user_id", user.ID, "err", err)
+				continue
+			}
+			s.logger.InfoContext(ctx, "user added to mattermost channel", "team", teamName, "channel", channelName, "user_id", user.ID)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/n8n"
+)
+
+// n8nProjectRefresh is how long the n8n project list is reused before it is
+// fetched again, so newly created projects are picked up.
+const n8nProjectRefresh = 5 * time.Minute
+
+// n8nProjectCache remembers n8n project IDs by name and the memberships
+// already ensured, so forward auth doesn't call n8n on every request.
+type n8nProjectCache struct {
+	mu      sync.Mutex
+	ids     map[string]string // Project name → ID; replaced, never mutated
+	fetched time.Time
+	missing map[string]bool // Mapped names already warned about
+	joined  map[string]bool // userID + "/" + projectID
+}
+
+func newN8NProjectCache() *n8nProjectCache {
+	return &n8nProjectCache{missing: map[string]bool{}, joined: map[string]bool{}}
+}
+
+// ensureN8NProjects adds the user to the projects their groups map to.
+// Projects that don't exist are warned about once.
+func (s *Server) ensureN8NProjects(ctx context.Context, user n8n.User, groups []string) {
+	if len(s.n8nProjectMap) == 0 || groups == nil || user.ID == "" {
+		return
+	}
+	var names []string
+	seen := map[string]bool{}
+	for _, group := range groups {
+		for _, name := range s.n8nProjectMap[group] {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	if len(names) == 0 {
+		return
+	}
+
+	ids, err := s.n8nProjectIDs(ctx)
+	if err != nil {
+		s.recordN8NFailure(err)
+		s.logger.WarnContext(ctx, "failed to list n8n projects", "err", err)
+		return
+	}
+	cache := s.n8nProjects
+	for _, name := range names {
+		id, ok := ids[name]
+		cache.mu.Lock()
+		warn := !ok && !cache.missing[name]
+		if !ok {
+			cache.missing[name] = true
+		} else {
+			delete(cache.missing, name)
+		}
+		joined := ok && cache.joined[user.ID+"/"+id]
+		cache.mu.Unlock()
+		if warn {
+			s.logger.WarnContext(ctx, "mapped n8n project not found", "project", name)
+		}
+		if !ok || joined {
+			continue
+		}
+
+		if err := s.n8nAPI().AddUserToProject(ctx, id, user.ID, n8n.ProjectRoleEditor); err != nil {
+			s.recordN8NFailure(err)
+			s.logger.WarnContext(ctx, "failed to add user to n8n project", "user_id", user.ID, "project", name, "err", err)
+			continue
+		}
+		s.recordN8NSuccess()
+		cache.mu.Lock()
+		cache.joined[user.ID+"/"+id] = true
+		cache.mu.Unlock()
+		s.logger.InfoContext(ctx, "n8n project membership ensured", "user_id", user.ID, "project", name)
+	}
+}
+
+// n8nProjectIDs returns project IDs by name, refetching the list once it is
+// older than n8nProjectRefresh.
+func (s *Server) n8nProjectIDs(ctx context.Context) (map[string]string, error) {
+	cache := s.n8nProjects
+	cache.mu.Lock()
+	if cache.ids != nil && time.Since(cache.fetched) < n8nProjectRefresh {
+		defer cache.mu.Unlock()
+		return cache.ids, nil
+	}
+	cache.mu.Unlock()
+
+	projects, err := s.n8nAPI().ListProjects(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[string]string, len(projects))
+	for _, project := range projects {
+		if project.Type != "personal" {
+			ids[project.Name] = project.ID
+		}
+	}
+	cache.mu.Lock()
+	cache.ids, cache.fetched = ids, time.Now()
+	cache.mu.Unlock()
+	return ids, nil
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/accounts"
+	"github.com/rave-org/rave/apps/auth-manager/internal/n8n"
+)
+
+// n8nSessionCache remembers the n8n-auth cookie issued to each email, since
+// issuing one resets the user's n8n password. Entries expire after ttl or a
+// minute before the cookie does.
+type n8nSessionCache struct {
+	ttl time.Duration
+	max int
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]n8nCacheEntry
+}
+
+type n8nCacheEntry struct {
+	session n8n.Session
+	expires time.Time
+}
+
+// newN8NSessionCache returns nil, which disables caching, when ttl or max is
+// not positive.
+func newN8NSessionCache(ttl time.Duration, max int) *n8nSessionCache {
+	if ttl <= 0 || max <= 0 {
+		return nil
+	}
+	return &n8nSessionCache{ttl: ttl, max: max, now: time.Now, entries: map[string]n8nCacheEntry{}}
+}
+
+func (c *n8nSessionCache) get(email string) (n8n.Session, bool) {
+	if c == nil {
+		return n8n.Session{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := accounts.NormalizeEmail(email)
+	entry, ok := c.entries[key]
+	if !ok {
+		return n8n.Session{}, false
+	}
+	if !c.now().Before(entry.expires) {
+		delete(c.entries, key)
+		return n8n.Session{}, false
+	}
+	return entry.session, true
+}
+
+func (c *n8nSessionCache) store(email string, session n8n.Session) {
+	if c == nil {
+		return
+	}
+	now := c.now()
+	expires := now.Add(c.ttl)
+	if !session.ExpiresAt.IsZero() {
+		if end := session.ExpiresAt.Add(-time.Minute); end.Before(expires) {
+			expires = end
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= c.max {
+		for key, entry := range c.entries {
+			if !now.Before(entry.expires) {
+				delete(c.entries, key)
+			}
+		}
+		// Still full: drop an arbitrary entry; it only costs a re-issue.
+		for key := range c.entries {
+			if len(c.entries) < c.max {
+				break
+			}
+			delete(c.entries, key)
+		}
+	}
+	c.entries[accounts.NormalizeEmail(email)] = n8nCacheEntry{session: session, expires: expires}
+}
+
+func (c *n8nSessionCache) invalidate(email string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	delete(c.entries, accounts.NormalizeEmail(email))
+	c.mu.Unlock()
+}
+
+// issueN8NSession sets an n8n-auth cookie for user on w, reusing a cached
+// session when possible. Failures are logged and leave the request to fall
+// through to n8n's own login.
+func (s *Server) issueN8NSession(r *http.Request, w http.ResponseWriter, user n8n.User) {
+	ctx := r.Context()
+	defer s.userLocks.lock("n8n|" + user.Email)()
+
+	session, ok := s.n8nSessions.get(user.Email)
+	if !ok {
+		var err error
+		session, err = s.n8nAPI().IssueSession(ctx, user)
+		if err != nil {
+			if errors.Is(err, n8n.ErrSessionUnsupported) {
+				s.logger.WarnContext(ctx, "n8n version can't issue sessions, falling back to n8n login", "email", user.Email)
+			} else {
+				s.recordN8NFailure(err)
+				s.logger.WarnContext(ctx, "failed to issue n8n session (allowing through)", "email", user.Email, "err", err)
+			}
+			return
+		}
+		s.recordN8NSuccess()
+		s.n8nSessions.store(user.Email, session)
+		s.logger.InfoContext(ctx, "n8n session issued", "email", user.Email, "n8n_user_id", user.ID)
+	}
+	http.SetCookie(w, s.n8nCookie(r, session))
+}
+
+// n8nCookie builds the n8n-auth cookie for the public n8n URL r was
+// forwarded for, or AUTH_MANAGER_N8N_URL, scoped to CookieDomain when set
+// and otherwise to that host.
+func (s *Server) n8nCookie(r *http.Request, session n8n.Session) *http.Cookie {
+	public := s.forwardedURL(r, s.cfg.N8NURL)
+	cookie := s.sessionCookie(n8n.AuthCookieName, session.Cookie, public)
+	cookie.Expires = session.ExpiresAt
+	if public.prefix != "" {
+		cookie.Path = public.prefix
+	}
+	// Browsers reject Domain on IPs and single-label hosts; leave those host-only.
+	if host := hostname(public.host); cookie.Domain == "" && net.ParseIP(host) == nil && strings.Contains(host, ".") {
+		cookie.Domain = host
+	}
+	return cookie
+}
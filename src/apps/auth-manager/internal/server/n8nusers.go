@@ -0,0 +1,182 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/n8n"
+	"github.com/rave-org/rave/apps/auth-manager/internal/shadow"
+	"github.com/rave-org/rave/apps/auth-manager/internal/webhook"
+)
+
+// Shadow attributes for n8n accounts. attrN8NDeprovisioned records what
+// deprovisioning did ("disable" or "delete"); attrN8NPending is "true" while
+// the user hasn't accepted their invitation.
+const (
+	attrN8NUserID        = "n8n_user_id"
+	attrN8NDeprovisioned = "n8n_deprovisioned"
+	attrN8NPending       = "n8n_pending"
+)
+
+// provisionN8NUser ensures the user exists in n8n and records their n8n ID
+// on the shadow record for deprovisioning. Only the account itself is
+// required; role and project sync failures are logged, not returned.
+func (s *Server) provisionN8NUser(ctx context.Context, info *webhook.UserInfo, shadowUser shadow.ShadowUser) error {
+	role := s.desiredN8NRole(info.Groups)
+	user, err := s.n8nAPI().EnsureUser(ctx, n8n.Identity{
+		Email:    info.Email,
+		Name:     info.Name,
+		Username: info.Username,
+		Role:     role,
+	})
+	if err != nil {
+		s.recordN8NFailure(err)
+		s.logger.WarnContext(ctx, "failed to provision n8n user", "email", info.Email, "err", err)
+		return fmt.Errorf("n8n provision: %w", err)
+	}
+	s.recordN8NSuccess()
+	user = s.settleN8NInvite(ctx, user, true)
+	s.syncN8NRole(ctx, user, role)
+	s.ensureN8NProjects(ctx, user, info.Groups)
+
+	attrs := map[string]string{}
+	if pending := strconv.FormatBool(user.IsPending); shadowUser.Attributes[attrN8NPending] != pending {
+		attrs[attrN8NPending] = pending
+	}
+	if user.ID != "" && shadowUser.Attributes[attrN8NUserID] != user.ID {
+		attrs[attrN8NUserID] = user.ID
+	}
+	if shadowUser.Attributes[attrN8NDeprovisioned] != "" {
+		attrs[attrN8NDeprovisioned] = ""
+	}
+	if len(attrs) == 0 {
+		return nil
+	}
+	if _, err := s.upsertShadow(ctx, shadowUser.Identity, attrs); err != nil {
+		s.logger.WarnContext(ctx, "failed to record n8n user id", "email", info.Email, "err", err)
+	}
+	return nil
+}
+
+// settleN8NInvite applies N8NPendingInvites to a user who hasn't accepted
+// their invitation, returning the user as it now stands. Invitations are
+// only resent when resend is set, so forward auth doesn't email on every
+// request.
+func (s *Server) settleN8NInvite(ctx context.Context, user n8n.User, resend bool) n8n.User {
+	mode := s.cfg.N8NPendingInvites
+	if !user.IsPending || mode == "" || (mode == "resend" && !resend) {
+		return user
+	}
+	if s.cfg.DryRun {
+		s.logger.InfoContext(ctx, "dry run: leaving n8n invitation pending", "user_id", user.ID, "mode", mode)
+		return user
+	}
+
+	var err error
+	if mode == "accept" {
+		err = s.n8nAPI().AcceptInvitation(ctx, user)
+	} else {
+		err = s.n8nAPI().ResendInvite(ctx, user.ID)
+	}
+	switch {
+	case errors.Is(err, n8n.ErrSessionUnsupported) || errors.Is(err, n8n.ErrOwnerLoginRequired):
+		s.logger.WarnContext(ctx, "n8n can't settle pending invitations", "user_id", user.ID, "mode", mode, "err", err)
+		return user
+	case err != nil:
+		s.recordN8NFailure(err)
+		s.logger.WarnContext(ctx, "failed to settle pending n8n invitation", "user_id", user.ID, "mode", mode, "err", err)
+		return user
+	}
+	s.recordN8NSuccess()
+	s.logger.InfoContext(ctx, "pending n8n invitation settled", "user_id", user.ID, "mode", mode)
+	if mode == "accept" {
+		user.IsPending = false
+	}
+	return user
+}
+
+// deprovisionN8NUser disables or deletes the user's n8n account according
+// to N8NDeprovisionAction, recording the outcome in attributes. A user n8n
+// doesn't know is not an error.
+func (s *Server) deprovisionN8NUser(ctx context.Context, info *webhook.UserInfo, attributes map[string]string) error {
+	action := s.cfg.N8NDeprovisionAction
+	if action != "delete" {
+		action = "disable"
+	}
+
+	var transferTo string
+	if action == "delete" {
+		var err error
+		if transferTo, err = s.n8nTransferTarget(ctx); err != nil {
+			return err
+		}
+	}
+
+	userID, err := s.n8nUserID(ctx, info)
+	if err == nil {
+		if action == "delete" {
+			err = s.n8nAPI().DeleteUser(ctx, userID, transferTo)
+		} else {
+			err = s.n8nAPI().DisableUser(ctx, userID)
+		}
+	}
+	switch {
+	case errors.Is(err, n8n.ErrNotFound):
+		s.logger.InfoContext(ctx, "no n8n user to deprovision", "email", info.Email)
+		return nil
+	case err != nil:
+		s.recordN8NFailure(err)
+		return fmt.Errorf("n8n %s: %w", action, err)
+	}
+	s.recordN8NSuccess()
+	attributes[attrN8NDeprovisioned] = action
+	if action == "delete" {
+		attributes[attrN8NUserID] = ""
+	}
+	s.logger.InfoContext(ctx, "n8n user deprovisioned", "email", info.Email, "n8n_user_id", userID, "action", action)
+	return nil
+}
+
+// n8nUserID resolves the n8n user ID for an identity, preferring the
+// n8n_user_id shadow attribute and falling back to an email lookup.
+func (s *Server) n8nUserID(ctx context.Context, info *webhook.UserInfo) (string, error) {
+	shadowUser, err := s.shadowStore.Get(ctx, info.ShadowProvider(), info.ShadowSubject())
+	if err == nil {
+		if id := shadowUser.Attributes[attrN8NUserID]; id != "" {
+			return id, nil
+		}
+	} else if !errors.Is(err, shadow.ErrNotFound) {
+		return "", fmt.Errorf("shadow store get: %w", err)
+	}
+
+	if info.Email == "" {
+		return "", n8n.ErrNotFound
+	}
+	user, err := s.n8nAPI().GetUserByEmail(ctx, info.Email)
+	if err != nil {
+		return "", err
+	}
+	return user.ID, nil
+}
+
+// n8nTransferTarget returns the ID of the user that inherits a deleted
+// user's workflows: N8NTransferTo when set, otherwise the owner.
+func (s *Server) n8nTransferTarget(ctx context.Context) (string, error) {
+	if s.cfg.N8NTransferTo == "" {
+		owner, err := s.n8nAPI().Owner(ctx)
+		if err != nil {
+			return "", fmt.Errorf("resolve n8n owner: %w", err)
+		}
+		return owner.ID, nil
+	}
+	user, err := s.n8nAPI().GetUserByEmail(ctx, s.cfg.N8NTransferTo)
+	if errors.Is(err, n8n.ErrNotFound) {
+		return "", fmt.Errorf("n8n transfer target %s not found", s.cfg.N8NTransferTo)
+	}
+	if err != nil {
+		return "", fmt.Errorf("resolve n8n transfer target: %w", err)
+	}
+	return user.ID, nil
+}
@@ -0,0 +1,458 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/apispec"
+	"github.com/rave-org/rave/apps/auth-manager/internal/apperror"
+	"github.com/rave-org/rave/apps/auth-manager/internal/outbound"
+	"github.com/rave-org/rave/apps/auth-manager/internal/provision"
+	"github.com/rave-org/rave/apps/auth-manager/internal/shadow"
+	"github.com/rave-org/rave/apps/auth-manager/internal/version"
+)
+
+// docsPage renders /openapi.json with Redoc. The spec URL is relative so the
+// page works behind a path prefix.
+const docsPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>auth-manager API</title>
+</head>
+<body>
+<redoc spec-url="openapi.json"></redoc>
+<script src="https://cdn.redoc.ly/redoc/v2.1.5/bundles/redoc.standalone.js"></script>
+</body>
+</html>
+`
+
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		s.respondError(w, r, apperror.MethodNotAllowed)
+		return
+	}
+	s.respondJSON(w, http.StatusOK, openAPIDocument())
+}
+
+func (s *Server) handleDocs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		s.respondError(w, r, apperror.MethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write([]byte(docsPage))
+}
+
+// openAPIDocument describes every route. Bodies the handlers encode from
+// named types get their schemas from those types.
+func openAPIDocument() apispec.Document {
+	admin := []apispec.Requirement{{"adminToken": {}}}
+	errorResponse := func(description string) *apispec.Response {
+		return &apispec.Response{Description: description, Content: map[string]apispec.MediaType{apperror.ContentType: {Schema: apispec.Ref("Problem")}}}
+	}
+	jsonResponse := func(description string, schema *apispec.Schema) *apispec.Response {
+		return &apispec.Response{Description: description, Content: apispec.JSON(schema)}
+	}
+	adminOp := func(op *apispec.Operation) *apispec.Operation {
+		op.Tags = []string{"management"}
+		op.Security = admin
+		op.Responses["401"] = errorResponse("Missing or invalid admin token")
+		op.Responses["403"] = errorResponse("Forwarded identity isn't in an admin group")
+		op.Responses["504"] = errorResponse("Over AUTH_MANAGER_REQUEST_TIMEOUT")
+		return op
+	}
+
+	provisionResponse := apispec.Object(map[string]*apispec.Schema{
+		"status":  {Type: "string", Enum: []string{"provisioned", "partial", "failed", "filtered", "deferred"}},
+		"email":   apispec.String(),
+		"shadow":  apispec.Ref("ProvisionResult"),
+		"targets": apispec.Array(apispec.Ref("ProvisionResult")),
+	})
+	provisionResponse.Properties["error"] = apispec.String()
+	provisionResponse.Properties["error_class"] = &apispec.Schema{Type: "string", Enum: []string{"bot_provisioning"}}
+
+	webhookResponse := &apispec.Schema{
+		Type:                 "object",
+		Description:          "status is provisioned, partial, failed, or filtered for user events (as for /api/v1/sync), deprovisioned or noted for deletions, deferred for user events queued during maintenance, revoked for credential changes, alert_forwarded, or ignored with a reason",
+		Properties:           map[string]*apispec.Schema{"status": apispec.String(), "reason": apispec.String()},
+		Required:             []string{"status"},
+		AdditionalProperties: &apispec.Schema{},
+	}
+	webhookOp := func(summary string, params []apispec.Parameter) *apispec.Operation {
+		return &apispec.Operation{
+			Summary:     summary,
+			Tags:        []string{"webhooks"},
+			Security:    []apispec.Requirement{{"webhookSecret": {}}, {"webhookSignature": {}}},
+			Parameters:  params,
+			RequestBody: &apispec.RequestBody{Required: true, Content: apispec.JSON(&apispec.Schema{Type: "object", Description: "An Authentik notification"})},
+			Responses: map[string]*apispec.Response{
+				"200": jsonResponse("Event handled or ignored", webhookResponse),
+				"202": jsonResponse("User event queued until maintenance mode ends", webhookResponse),
+				"400": errorResponse("Malformed event"),
+				"401": errorResponse("Missing or invalid secret or signature"),
+				"404": errorResponse("Unknown webhook source"),
+				"413": errorResponse("Event over 1MB"),
+				"502": errorResponse("Session revocation failed"),
+				"504": errorResponse("Over AUTH_MANAGER_WEBHOOK_TIMEOUT"),
+				"503": errorResponse("Mattermost temporarily unavailable"),
+			},
+		}
+	}
+
+	readyCheck := &apispec.Schema{
+		Type: "object",
+		Properties: map[string]*apispec.Schema{
+			"status": {Type: "string", Enum: []string{"ok", "error", "unauthorized"}},
+			"error":  apispec.String(),
+		},
+		Required: []string{"status"},
+	}
+	ready := &apispec.Schema{
+		Type:        "object",
+		Description: "status, plus a check for each provisioner by name",
+		Properties: map[string]*apispec.Schema{
+			"status": {Type: "string", Enum: []string{"ready", "degraded", "not_ready", "warming_up"}},
+			"warmup": apispec.SchemaOf(warmupStatus{}),
+		},
+		Required:             []string{"status"},
+		AdditionalProperties: readyCheck,
+	}
+
+	logout := &apispec.Operation{
+		Summary:     "Log out",
+		Description: "Revokes the Mattermost session in the MMAUTHTOKEN cookie and expires the cookies forward auth set, even when Mattermost is unreachable.",
+		Tags:        []string{"forward-auth"},
+		Responses: map[string]*apispec.Response{
+			"200": jsonResponse("Logged out", apispec.Object(map[string]*apispec.Schema{
+				"status":             {Type: "string", Enum: []string{"logged_out"}},
+				"mattermost_session": {Type: "string", Enum: []string{"revoked", "revoke_failed", "none"}},
+			})),
+			"303": {Description: "Logged out, redirecting to AUTH_MANAGER_LOGOUT_REDIRECT_URL"},
+			"405": errorResponse("Method not allowed"),
+		},
+	}
+
+	reconcileStatus := apispec.Object(map[string]*apispec.Schema{
+		"configured": apispec.Boolean(),
+		"running":    apispec.Boolean(),
+		"interval":   apispec.String(),
+		"last_run":   apispec.Ref("ReconcileSummary"),
+	})
+	reconcileStatus.Properties["last_success"] = &apispec.Schema{Type: "string", Format: "date-time"}
+	reconcileStatus.Properties["next_run"] = &apispec.Schema{Type: "string", Format: "date-time"}
+
+	syncBody := apispec.SchemaOf(syncRequest{})
+	syncBody.Required = []string{"email"}
+	deprovisionBody := apispec.SchemaOf(deprovisionRequest{})
+	deprovisionBody.Required = []string{"email"}
+	cleanupBody := apispec.SchemaOf(sessionCleanupRequest{})
+	cleanupBody.Required = nil
+
+	doc := apispec.Document{
+		OpenAPI: apispec.Version,
+		Info: apispec.Info{
+			Title:       "auth-manager",
+			Version:     "v1",
+			Description: "Provisions Authentik users into Mattermost, n8n, GitLab, and Grafana, and answers forward-auth requests for them.",
+		},
+		Components: apispec.Components{
+			Schemas: map[string]*apispec.Schema{
+				"Problem":          problemSchema(),
+				"ProvisionResult":  apispec.SchemaOf(provision.Result{}),
+				"ShadowUser":       apispec.SchemaOf(shadow.ShadowUser{}),
+				"ReconcileSummary": apispec.SchemaOf(reconcileSummary{}),
+				"BuildInfo":        apispec.SchemaOf(version.Info{}),
+			},
+			SecuritySchemes: map[string]apispec.SecurityScheme{
+				"adminToken": {
+					Type:        "http",
+					Scheme:      "bearer",
+					Description: "AUTH_MANAGER_ADMIN_TOKEN. A forwarded identity in one of AUTH_MANAGER_ADMIN_GROUPS is also accepted; with neither configured the management API is open.",
+				},
+				"webhookSecret": {Type: "http", Scheme: "bearer", Description: "The webhook source's secret"},
+				"slashCommandToken": {
+					Type:        "apiKey",
+					In:          "header",
+					Name:        "Authorization",
+					Description: "\"Token \" and AUTH_MANAGER_MATTERMOST_COMMAND_TOKEN, as Mattermost sends it; the token form field is also accepted",
+				},
+				"webhookSignature": {
+					Type:        "apiKey",
+					In:          "header",
+					Name:        "X-Authentik-Signature",
+					Description: "Hex HMAC-SHA256 of the body keyed with the webhook source's secret",
+				},
+			},
+		},
+		Paths: map[string]apispec.PathItem{
+			"/healthz": {"get": {
+				Summary: "Liveness, with circuit breaker states",
+				Tags:    []string{"health"},
+				Responses: map[string]*apispec.Response{"200": jsonResponse("Serving", apispec.Object(map[string]*apispec.Schema{
+					"status":       {Type: "string", Enum: []string{"ok"}},
+					"mattermost":   apispec.String(),
+					"current_time": {Type: "string", Format: "date-time"},
+					"breakers":     {Type: "object", AdditionalProperties: apispec.SchemaOf(breakerState{})},
+					"warmup":       apispec.SchemaOf(warmupStatus{}),
+					"maintenance":  apispec.SchemaOf(maintenanceStatus{}),
+					"build":        apispec.Ref("BuildInfo"),
+				}))},
+			}},
+			"/readyz": {"get": {
+				Summary: "Readiness of the shadow store and provisioners",
+				Tags:    []string{"health"},
+				Responses: map[string]*apispec.Response{
+					"200": jsonResponse("Ready or degraded", ready),
+					"503": {Description: "Warming up, shadow store unavailable (a problem), or Mattermost down with AUTH_MANAGER_READY_REQUIRE_MATTERMOST", Content: map[string]apispec.MediaType{
+						"application/json":   {Schema: ready},
+						apperror.ContentType: {Schema: apispec.Ref("Problem")},
+					}},
+				},
+			}},
+			"/openapi.json": {"get": {
+				Summary:   "This document",
+				Tags:      []string{"docs"},
+				Responses: map[string]*apispec.Response{"200": jsonResponse("OpenAPI document", &apispec.Schema{Type: "object"})},
+			}},
+			"/docs": {"get": {
+				Summary:   "API reference rendered from /openapi.json",
+				Tags:      []string{"docs"},
+				Security:  admin,
+				Responses: map[string]*apispec.Response{"200": {Description: "HTML page", Content: map[string]apispec.MediaType{"text/html": {Schema: apispec.String()}}}},
+			}},
+			"/metrics": {"get": {
+				Summary:     "Prometheus metrics",
+				Description: "Requires the admin token when AUTH_MANAGER_METRICS_PROTECTED is set.",
+				Tags:        []string{"health"},
+				Responses:   map[string]*apispec.Response{"200": {Description: "Prometheus text format", Content: map[string]apispec.MediaType{"text/plain": {Schema: apispec.String()}}}},
+			}},
+			"/webhook/authentik": {"post": webhookOp("Authentik notification from the default source", nil)},
+			"/webhook/authentik/{source}": {"post": webhookOp("Authentik notification from a named source", []apispec.Parameter{
+				{Name: "source", In: "path", Required: true, Description: "A source from AUTH_MANAGER_WEBHOOK_SOURCES", Schema: apispec.String()},
+			})},
+			"/auth/{service}": {"get": {
+				Summary:     "Forward auth",
+				Description: "Checks the identity headers set by the proxy, provisions the user, and answers 200 with the service's identity headers (and session cookies) or an error status.",
+				Tags:        []string{"forward-auth"},
+				Parameters: []apispec.Parameter{
+					{Name: "service", In: "path", Required: true, Description: "mattermost, n8n, gitlab, grafana, or a service from AUTH_MANAGER_FORWARD_AUTH_SERVICES", Schema: apispec.String()},
+				},
+				Responses: map[string]*apispec.Response{
+					"200": {Description: "Authenticated"},
+					"401": {Description: "No trusted identity headers"},
+					"403": {Description: "Untrusted proxy, user refused by the group or email domain lists, or a cross-site request that would set session cookies"},
+					"404": {Description: "Unknown service"},
+					"502": {Description: "The service's integration is misconfigured"},
+					"503": {Description: "A downstream service is unavailable, auth-manager is still warming up (with Retry-After), or maintenance mode is on and the user has no session"},
+					"504": {Description: "Over AUTH_MANAGER_FORWARD_AUTH_TIMEOUT", Content: map[string]apispec.MediaType{apperror.ContentType: {Schema: apispec.Ref("Problem")}}},
+				},
+			}},
+			"/auth/logout": {"get": logout, "post": logout},
+			"/api/v1/shadow-users": {"get": adminOp(&apispec.Operation{
+				Summary:     "List shadow users",
+				Description: "Attributes in AUTH_MANAGER_SENSITIVE_ATTRIBUTES are redacted unless include_sensitive is set.",
+				Parameters: []apispec.Parameter{
+					{Name: "include_sensitive", In: "query", Description: "Return sensitive attributes as stored; refused with 403 when the management API has no admin auth configured", Schema: apispec.Boolean()},
+				},
+				Responses: map[string]*apispec.Response{
+					"400": errorResponse("include_sensitive isn't a boolean"),
+					"200": jsonResponse("Every shadow user", apispec.Object(map[string]*apispec.Schema{"shadow_users": apispec.Array(apispec.Ref("ShadowUser"))})),
+					"503": errorResponse("Shadow store unavailable"),
+				},
+			})},
+			"/api/v1/users/{email}/status": {"get": adminOp(&apispec.Operation{
+				Summary:     "One user's provisioning status",
+				Description: "Shadow records, live Mattermost and n8n lookups, the breakers in front of provisioning, and recent history. Each lookup runs for at most 2s and reports unknown (timeout) when it takes longer, without failing the request.",
+				Parameters: []apispec.Parameter{
+					{Name: "email", In: "path", Required: true, Schema: apispec.String()},
+					{Name: "include_sensitive", In: "query", Description: "Return sensitive attributes as stored; refused with 403 when the management API has no admin auth configured", Schema: apispec.Boolean()},
+				},
+				Responses: map[string]*apispec.Response{
+					"400": errorResponse("include_sensitive isn't a boolean"),
+					"200": jsonResponse("Status", apispec.SchemaOf(userStatus{})),
+				},
+			})},
+			"/api/v1/stats": {"get": adminOp(&apispec.Operation{
+				Summary: "Shadow store counts",
+				Responses: map[string]*apispec.Response{
+					"200": jsonResponse("Counts", apispec.Object(map[string]*apispec.Schema{"shadow_users": apispec.Integer(), "n8n_pending_users": apispec.Integer()})),
+					"503": errorResponse("Shadow store unavailable"),
+				},
+			})},
+			"/api/v1/version": {"get": adminOp(&apispec.Operation{
+				Summary:   "Running build",
+				Responses: map[string]*apispec.Response{"200": jsonResponse("Version, commit, build date, and Go version", apispec.Ref("BuildInfo"))},
+			})},
+			"/api/v1/sync": {"post": adminOp(&apispec.Operation{
+				Summary:     "Provision a user",
+				Description: "Rate limited per caller by AUTH_MANAGER_RATE_LIMIT_PER_MINUTE.",
+				RequestBody: &apispec.RequestBody{Required: true, Content: apispec.JSON(syncBody)},
+				Responses: map[string]*apispec.Response{
+					"200": jsonResponse("Stored in the shadow store; targets report each provisioner", provisionResponse),
+					"400": errorResponse("Invalid body or missing email"),
+					"413": errorResponse("Body over AUTH_MANAGER_MAX_REQUEST_BYTES"),
+					"429": errorResponse("Rate limited"),
+					"502": jsonResponse("The shadow store write failed", provisionResponse),
+				},
+			})},
+			"/api/v1/deprovision": {"post": adminOp(&apispec.Operation{
+				Summary:     "Deprovision a user from every downstream service",
+				RequestBody: &apispec.RequestBody{Required: true, Content: apispec.JSON(deprovisionBody)},
+				Responses: map[string]*apispec.Response{
+					"200": jsonResponse("Deprovisioned", apispec.Object(map[string]*apispec.Schema{
+						"status":  {Type: "string", Enum: []string{"deprovisioned"}},
+						"email":   apispec.String(),
+						"targets": apispec.Array(apispec.Ref("ProvisionResult")),
+					})),
+					"400": errorResponse("Invalid body or missing email"),
+					"413": errorResponse("Body over AUTH_MANAGER_MAX_REQUEST_BYTES"),
+					"500": jsonResponse("A provisioner failed", apispec.Object(map[string]*apispec.Schema{
+						"error":   apispec.String(),
+						"targets": apispec.Array(apispec.Ref("ProvisionResult")),
+					})),
+					"503": errorResponse("No downstream services configured"),
+				},
+			})},
+			"/api/v1/mattermost/sessions/cleanup": {"post": adminOp(&apispec.Operation{
+				Summary:     "Revoke surplus SSO sessions",
+				Description: "Streams one NDJSON line per user, then a summary line with done set.",
+				RequestBody: &apispec.RequestBody{Content: apispec.JSON(cleanupBody)},
+				Responses: map[string]*apispec.Response{
+					"200": {Description: "Progress stream", Content: map[string]apispec.MediaType{"application/x-ndjson": {Schema: &apispec.Schema{
+						OneOf: []*apispec.Schema{apispec.SchemaOf(sessionCleanupLine{}), apispec.SchemaOf(sessionCleanupSummary{})},
+					}}}},
+					"400": errorResponse("Invalid body"),
+					"413": errorResponse("Body over AUTH_MANAGER_MAX_REQUEST_BYTES"),
+					"503": errorResponse("Mattermost not configured, or shadow store unavailable"),
+				},
+			})},
+			"/api/v1/mattermost/teams/reconcile": {"post": adminOp(&apispec.Operation{
+				Summary:     "Sync every user's Mattermost teams with their groups",
+				Description: "Streams one NDJSON line per user with a Mattermost ID and recorded groups, then a summary line with done set.",
+				RequestBody: &apispec.RequestBody{Content: apispec.JSON(apispec.Object(map[string]*apispec.Schema{"dry_run": apispec.Boolean()}))},
+				Responses: map[string]*apispec.Response{
+					"200": {Description: "Progress stream", Content: map[string]apispec.MediaType{"application/x-ndjson": {Schema: &apispec.Schema{
+						OneOf: []*apispec.Schema{apispec.SchemaOf(teamSyncLine{}), apispec.SchemaOf(teamReconcileSummary{})},
+					}}}},
+					"400": errorResponse("Invalid body"),
+					"413": errorResponse("Body over AUTH_MANAGER_MAX_REQUEST_BYTES"),
+					"503": errorResponse("Mattermost or team mapping not configured, or shadow store unavailable"),
+				},
+			})},
+			"/api/v1/reconcile": {"post": adminOp(&apispec.Operation{
+				Summary: "Sync every Authentik user",
+				Responses: map[string]*apispec.Response{
+					"200": jsonResponse("Run finished", apispec.Ref("ReconcileSummary")),
+					"409": errorResponse("A run is already in progress"),
+					"502": jsonResponse("Run failed", apispec.Ref("ReconcileSummary")),
+					"503": errorResponse("Authentik API not configured"),
+				},
+			})},
+			"/api/v1/reconcile/status": {"get": adminOp(&apispec.Operation{
+				Summary:   "Most recent reconcile run",
+				Responses: map[string]*apispec.Response{"200": jsonResponse("Status", reconcileStatus)},
+			})},
+			"/api/v1/integrations/mattermost/command": {"post": {
+				Summary:     "Mattermost slash command",
+				Description: "Serves /rave whois <email>, /rave sync <email> (for users whose shadow record is in an admin group), and /rave status. Answers are ephemeral messages, usage errors included.",
+				Tags:        []string{"integrations"},
+				Security:    []apispec.Requirement{{"slashCommandToken": {}}},
+				RequestBody: &apispec.RequestBody{Required: true, Content: map[string]apispec.MediaType{"application/x-www-form-urlencoded": {Schema: apispec.Object(map[string]*apispec.Schema{
+					"token":     apispec.String(),
+					"user_id":   apispec.String(),
+					"user_name": apispec.String(),
+					"command":   apispec.String(),
+					"text":      apispec.String(),
+				})}}},
+				Responses: map[string]*apispec.Response{
+					"200": jsonResponse("A Mattermost command response", apispec.SchemaOf(commandResponse{})),
+					"401": errorResponse("Invalid command token"),
+					"405": errorResponse("Method not allowed"),
+					"413": errorResponse("Body over AUTH_MANAGER_MAX_REQUEST_BYTES"),
+					"503": errorResponse("AUTH_MANAGER_MATTERMOST_COMMAND_TOKEN isn't set"),
+					"504": errorResponse("Over AUTH_MANAGER_REQUEST_TIMEOUT"),
+				},
+			}},
+			"/api/v1/admin/reload": {"post": adminOp(&apispec.Operation{
+				Summary: "Reload the configuration, like SIGHUP",
+				Responses: map[string]*apispec.Response{
+					"200": jsonResponse("Names of the settings applied and ignored until restart", apispec.SchemaOf(ReloadResult{})),
+					"422": errorResponse("Invalid configuration; nothing changed"),
+				},
+			})},
+			"/api/v1/admin/maintenance": {
+				"get": adminOp(&apispec.Operation{
+					Summary:   "Maintenance mode",
+					Responses: map[string]*apispec.Response{"200": jsonResponse("Status", apispec.SchemaOf(maintenanceStatus{}))},
+				}),
+				"post": adminOp(&apispec.Operation{
+					Summary:     "Switch maintenance mode on or off",
+					Description: "While it's on, provisioning is deferred, forward auth creates no users or sessions, and user events from webhooks and the event stream are queued until it ends.",
+					RequestBody: &apispec.RequestBody{Content: apispec.JSON(apispec.SchemaOf(maintenanceRequest{}))},
+					Responses: map[string]*apispec.Response{
+						"200": jsonResponse("Status after the switch", apispec.SchemaOf(maintenanceStatus{})),
+						"400": errorResponse("Malformed body or duration"),
+					},
+				}),
+			},
+			"/api/v1/admin/remap": {"post": adminOp(&apispec.Operation{
+				Summary:     "Move every shadow user to their email's current subject",
+				Description: "Merges the duplicate shadow users an Authentik reinstallation leaves, keeping each user's attributes and created_at, as AUTH_MANAGER_REMAP_BY_EMAIL does for each event. Records with no current subject for their email are reported as orphaned and left alone.",
+				RequestBody: &apispec.RequestBody{Content: apispec.JSON(apispec.SchemaOf(remapRequest{}))},
+				Responses: map[string]*apispec.Response{
+					"200": jsonResponse("What the run did", apispec.SchemaOf(remapResult{})),
+					"400": errorResponse("Malformed body or unknown source"),
+					"413": errorResponse("Body over AUTH_MANAGER_MAX_REQUEST_BYTES"),
+					"502": errorResponse("Listing Authentik users failed"),
+					"503": errorResponse("Authentik API not configured, or shadow store unavailable"),
+				},
+			})},
+			"/api/v1/outbound-webhooks/status": {"get": adminOp(&apispec.Operation{
+				Summary:     "Outbound webhook deliveries",
+				Description: "Each target from AUTH_MANAGER_OUTBOUND_WEBHOOKS with its queue, delivery counts, and most recent delivery.",
+				Responses: map[string]*apispec.Response{"200": jsonResponse("Status", apispec.Object(map[string]*apispec.Schema{
+					"configured": apispec.Boolean(),
+					"targets":    apispec.Array(apispec.SchemaOf(outbound.Status{})),
+				}))},
+			})},
+		},
+	}
+	doc.Paths["/api/v1/shadow-users"]["get"].Responses["403"].Description += ", or include_sensitive without admin auth configured"
+	// These manage their own deadlines rather than a request budget.
+	for _, path := range []string{"/api/v1/reconcile", "/api/v1/mattermost/sessions/cleanup", "/api/v1/mattermost/teams/reconcile", "/api/v1/admin/remap"} {
+		delete(doc.Paths[path]["post"].Responses, "504")
+	}
+	// These accept an Idempotency-Key; see Server.idempotent.
+	for _, path := range []string{"/api/v1/sync", "/api/v1/deprovision", "/api/v1/mattermost/sessions/cleanup", "/api/v1/mattermost/teams/reconcile", "/api/v1/reconcile"} {
+		op := doc.Paths[path]["post"]
+		op.Parameters = append(op.Parameters, apispec.Parameter{
+			Name: "Idempotency-Key", In: "header", Schema: apispec.String(),
+			Description: "Retries with the same key and body within AUTH_MANAGER_IDEMPOTENCY_TTL get the first response again, marked Idempotent-Replayed: true",
+		})
+		if op.Responses["409"] == nil {
+			op.Responses["409"] = errorResponse("A request with this Idempotency-Key is still in progress")
+		} else {
+			op.Responses["409"].Description += ", or a request with this Idempotency-Key is"
+		}
+		op.Responses["422"] = errorResponse("The Idempotency-Key was used with a different body")
+	}
+	return doc
+}
+
+// problemSchema describes apperror.Problem, listing the codes.
+func problemSchema() *apispec.Schema {
+	schema := apispec.SchemaOf(apperror.Problem{})
+	schema.Description = "RFC 7807 problem details; branch on code, which is stable"
+	schema.Properties["code"].Enum = []string{
+		string(apperror.CodeInvalidRequest), string(apperror.CodeRequestTooLarge), string(apperror.CodeMethodNotAllowed),
+		string(apperror.CodeAuthenticationRequired), string(apperror.CodeInvalidAdminToken), string(apperror.CodeAdminGroupRequired),
+		string(apperror.CodeRateLimited), string(apperror.CodeUnknownWebhookSource), string(apperror.CodeMissingWebhookAuth),
+		string(apperror.CodeInvalidWebhookSignature), string(apperror.CodeMalformedWebhook), string(apperror.CodeNotConfigured),
+		string(apperror.CodeMattermostUnavailable), string(apperror.CodeMattermostError), string(apperror.CodeStoreUnavailable),
+		string(apperror.CodeReconcileInProgress), string(apperror.CodeIdempotencyKeyInUse), string(apperror.CodeIdempotencyKeyReused),
+		string(apperror.CodeInvalidConfig), string(apperror.CodeDeadlineExceeded), string(apperror.CodeInternal),
+	}
+	return schema
+}
@@ -0,0 +1,244 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/breaker"
+	"github.com/rave-org/rave/apps/auth-manager/internal/provision"
+)
+
+const (
+	// maxPendingOpsAlerts bounds the alerts held while Mattermost is down;
+	// later ones are dropped.
+	maxPendingOpsAlerts = 20
+	// maxTrackedFailures bounds the users whose consecutive provisioning
+	// failures are counted; past it the counts start over.
+	maxTrackedFailures = 10000
+)
+
+// Operator alert kinds, the kind label of auth_manager_operator_alerts_total.
+const (
+	opsAlertBreakerOpen      = "breaker_open"
+	opsAlertProvisionFailing = "provision_failures"
+)
+
+// opsAlerter holds the state behind operator alerts: when each cause last
+// alerted, each user's consecutive provisioning failures, and the alerts
+// waiting for Mattermost to come back.
+type opsAlerter struct {
+	alerts *prometheus.CounterVec
+
+	mu       sync.Mutex
+	last     map[string]time.Time // by cause
+	failures map[string]int       // by email
+	pending  []pendingOpsAlert
+}
+
+type pendingOpsAlert struct {
+	kind    string
+	message string
+}
+
+func newOpsAlerter(reg prometheus.Registerer) *opsAlerter {
+	a := &opsAlerter{
+		alerts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "auth_manager_operator_alerts_total",
+			Help: "Operator alerts about auth-manager by kind (breaker_open, provision_failures) and result (sent, suppressed, buffered, dropped)",
+		}, []string{"kind", "result"}),
+		last:     map[string]time.Time{},
+		failures: map[string]int{},
+	}
+	reg.MustRegister(a.alerts)
+	return a
+}
+
+// opsAlertChannel is the channel operator alerts go to, or "" when they're
+// off.
+func (s *Server) opsAlertChannel() string {
+	if s.mattermost() == nil {
+		return ""
+	}
+	if s.cfg.OpsAlertChannelID != "" {
+		return s.cfg.OpsAlertChannelID
+	}
+	return s.cfg.AlertChannelID
+}
+
+// breakerChanged is every breaker's OnStateChange hook. It alerts when a
+// breaker opens and flushes buffered alerts when a Mattermost breaker
+// closes. It runs with the breaker locked, so the work happens elsewhere.
+func (s *Server) breakerChanged(service string, to breaker.State) {
+	switch {
+	case to == breaker.Open && service != "alerts":
+		go s.alertBreakerOpen(service)
+	case to == breaker.Closed && postsDependOn(service):
+		go s.flushOpsAlerts()
+	}
+}
+
+// postsDependOn reports whether posting to Mattermost stops when service's
+// breaker opens.
+func postsDependOn(service string) bool {
+	return service == "alerts" || strings.HasPrefix(service, "mattermost/")
+}
+
+func (s *Server) alertBreakerOpen(service string) {
+	b := s.breakers()[service]
+	if b == nil {
+		return
+	}
+	snap := b.Snapshot()
+	message := fmt.Sprintf("#### :warning: auth-manager: `%s` circuit breaker opened\nCalls to %s are refused", service, service)
+	if snap.Remaining > 0 {
+		message += " for the next " + snap.Remaining.Round(time.Second).String()
+	}
+	message += "."
+	if snap.LastFailure != "" {
+		message += "\n**Last failure:** " + snap.LastFailure
+	}
+	s.raiseOpsAlert(opsAlertBreakerOpen, "breaker:"+service, message)
+}
+
+// recordProvisionOutcome counts email's consecutive provisioning failures,
+// alerting once they pass OpsAlertFailureThreshold. Refusals by an open
+// breaker aren't counted: the breaker's own alert covers them.
+func (s *Server) recordProvisionOutcome(email string, err error) {
+	a := s.opsAlerts
+	if a == nil || email == "" || errors.Is(err, provision.ErrCircuitOpen) {
+		return
+	}
+	a.mu.Lock()
+	if err == nil {
+		delete(a.failures, email)
+		a.mu.Unlock()
+		return
+	}
+	if _, ok := a.failures[email]; !ok && len(a.failures) >= maxTrackedFailures {
+		clear(a.failures)
+	}
+	a.failures[email]++
+	failures := a.failures[email]
+	a.mu.Unlock()
+
+	if threshold := s.cfg.OpsAlertFailureThreshold; threshold <= 0 || failures <= threshold {
+		return
+	}
+	message := fmt.Sprintf("#### :warning: auth-manager: provisioning keeps failing for %s\nIt has failed %d times in a row.\n**Last error:** %s", email, failures, err)
+	s.raiseOpsAlert(opsAlertProvisionFailing, "provision:"+email, message)
+}
+
+// raiseOpsAlert posts message to the operator alert channel in the
+// background, at most once per OpsAlertInterval for each cause. While
+// Mattermost's breakers are open the alert is held until one closes, so
+// alerts about Mattermost don't add to its load or fail in turn.
+func (s *Server) raiseOpsAlert(kind, cause, message string) {
+	a := s.opsAlerts
+	if a == nil || s.opsAlertChannel() == "" {
+		return
+	}
+	now := time.Now()
+	a.mu.Lock()
+	if last, ok := a.last[cause]; ok && now.Sub(last) < s.cfg.OpsAlertInterval {
+		a.mu.Unlock()
+		a.alerts.WithLabelValues(kind, "suppressed").Inc()
+		return
+	}
+	a.last[cause] = now
+	a.mu.Unlock()
+
+	if s.mattermostDown() {
+		s.bufferOpsAlert(pendingOpsAlert{kind: kind, message: message})
+		return
+	}
+	s.postOpsAlerts([]pendingOpsAlert{{kind: kind, message: message}})
+}
+
+// mattermostDown reports whether a breaker that posting depends on is open.
+func (s *Server) mattermostDown() bool {
+	for service, b := range s.breakers() {
+		if b != nil && postsDependOn(service) && b.Snapshot().State == breaker.Open {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) bufferOpsAlert(alerts ...pendingOpsAlert) {
+	a := s.opsAlerts
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, alert := range alerts {
+		if len(a.pending) >= maxPendingOpsAlerts {
+			a.alerts.WithLabelValues(alert.kind, "dropped").Inc()
+			continue
+		}
+		a.pending = append(a.pending, alert)
+		a.alerts.WithLabelValues(alert.kind, "buffered").Inc()
+	}
+}
+
+// flushOpsAlerts posts the buffered alerts, as one message, once Mattermost
+// is back.
+func (s *Server) flushOpsAlerts() {
+	a := s.opsAlerts
+	if a == nil || s.mattermostDown() {
+		return
+	}
+	a.mu.Lock()
+	pending := a.pending
+	a.pending = nil
+	a.mu.Unlock()
+	if len(pending) > 0 {
+		s.postOpsAlerts(pending)
+	}
+}
+
+// postOpsAlerts posts alerts in the background, buffering them again if the
+// post fails.
+func (s *Server) postOpsAlerts(alerts []pendingOpsAlert) {
+	if !s.alertBreaker.Allow() {
+		s.bufferOpsAlert(alerts...)
+		return
+	}
+	messages := make([]string, len(alerts))
+	for i, alert := range alerts {
+		messages[i] = alert.message
+	}
+	message := strings.Join(messages, "\n\n")
+	if len(alerts) > 1 {
+		message = fmt.Sprintf("%d alerts held while Mattermost was unavailable:\n\n%s", len(alerts), message)
+	}
+	channel := s.opsAlertChannel()
+
+	err := s.lifecycle.goWorker("ops-alert", func(ctx context.Context) {
+		ctx, cancel := context.WithTimeout(ctx, alertPostTimeout)
+		defer cancel()
+
+		if err := s.mattermost().PostToChannel(ctx, channel, message); err != nil {
+			s.alertBreaker.RecordFailure(err)
+			s.logger.WarnContext(ctx, "operator alert failed, holding it", "alerts", len(alerts), "err", err)
+			s.bufferOpsAlert(alerts...)
+			return
+		}
+		s.alertBreaker.RecordSuccess()
+		for _, alert := range alerts {
+			s.opsAlerts.alerts.WithLabelValues(alert.kind, "sent").Inc()
+		}
+		// Alerts held after a failed post that didn't open a breaker
+		// would otherwise wait for the next one to close.
+		s.flushOpsAlerts()
+	})
+	if err != nil {
+		for _, alert := range alerts {
+			s.opsAlerts.alerts.WithLabelValues(alert.kind, "dropped").Inc()
+		}
+		s.logger.Warn("dropping operator alert", "alerts", len(alerts), "err", err)
+	}
+}
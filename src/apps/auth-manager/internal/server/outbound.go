@@ -0,0 +1,132 @@
+package server
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/apperror"
+	"github.com/rave-org/rave/apps/auth-manager/internal/config"
+	"github.com/rave-org/rave/apps/auth-manager/internal/httpx"
+	"github.com/rave-org/rave/apps/auth-manager/internal/outbound"
+	"github.com/rave-org/rave/apps/auth-manager/internal/provision"
+)
+
+// outboundIdentity is the canonical identity as sent in outbound events.
+// Attributes are left out: they hold other services' IDs and bookkeeping,
+// not anything a subscriber should rely on.
+type outboundIdentity struct {
+	Provider       string   `json:"provider"`
+	Subject        string   `json:"subject"`
+	Email          string   `json:"email"`
+	Name           string   `json:"name,omitempty"`
+	Username       string   `json:"username,omitempty"`
+	Groups         []string `json:"groups,omitempty"`
+	ServiceAccount bool     `json:"service_account,omitempty"`
+	ShadowID       string   `json:"shadow_id,omitempty"`
+}
+
+// outboundEventData is the data of a user event: who, what each
+// provisioner did, and why the run failed when it did.
+type outboundEventData struct {
+	Identity outboundIdentity   `json:"identity"`
+	Targets  []provision.Result `json:"targets"`
+	Error    string             `json:"error,omitempty"`
+}
+
+// newOutboundDispatcher starts delivering to the configured outbound
+// webhooks, or returns nil when there are none or they don't parse.
+func (s *Server) newOutboundDispatcher(cfg config.Config, opts httpx.Options, reg prometheus.Registerer) *outbound.Dispatcher {
+	targets, err := cfg.OutboundWebhookTargets()
+	if err != nil {
+		s.logger.Error("invalid outbound webhooks, notifications disabled", "err", err)
+		return nil
+	}
+	if len(targets) == 0 {
+		return nil
+	}
+
+	deliveries := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_manager_outbound_webhook_deliveries_total",
+		Help: "Outbound webhook deliveries by target and result (delivered, failed, dropped)",
+	}, []string{"target", "result"})
+	retries := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_manager_outbound_webhook_retries_total",
+		Help: "Outbound webhook delivery retries by target",
+	}, []string{"target"})
+	reg.MustRegister(deliveries, retries)
+
+	d := outbound.New(targets, httpx.NewClient(s.clientOptions(opts, "outbound-webhook")), outbound.Settings{
+		QueueSize:   cfg.OutboundWebhookQueueSize,
+		MaxAttempts: cfg.OutboundWebhookMaxAttempts,
+		OnResult: func(target, result string) {
+			deliveries.WithLabelValues(target, result).Inc()
+		},
+		OnRetry: func(target string) {
+			retries.WithLabelValues(target).Inc()
+		},
+	}, s.logger)
+	for _, target := range targets {
+		name := target.Name
+		reg.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "auth_manager_outbound_webhook_queue_depth",
+			Help:        "Outbound webhook deliveries waiting by target",
+			ConstLabels: prometheus.Labels{"target": name},
+		}, func() float64 {
+			for _, status := range d.Status() {
+				if status.Target == name {
+					return float64(status.Queued)
+				}
+			}
+			return 0
+		}))
+	}
+	s.logger.Info("outbound webhooks enabled", "targets", len(targets))
+	return d
+}
+
+// publishUserEvent queues an outbound event about ident. It returns at
+// once; delivery happens in the background.
+func (s *Server) publishUserEvent(ctx context.Context, eventType string, ident provision.CanonicalIdentity, targets []provision.Result, err error) {
+	s.recordUserHistory(ctx, ident.Email, eventType, err)
+	if s.outbound == nil {
+		return
+	}
+	data := outboundEventData{
+		Identity: outboundIdentity{
+			Provider:       ident.Provider,
+			Subject:        ident.Subject,
+			Email:          ident.Email,
+			Name:           ident.Name,
+			Username:       ident.Username,
+			Groups:         ident.Groups,
+			ServiceAccount: ident.ServiceAccount,
+			ShadowID:       ident.ShadowID,
+		},
+		Targets: targets,
+	}
+	if data.Targets == nil {
+		data.Targets = []provision.Result{}
+	}
+	data.Error = eventError(err)
+	s.outbound.Publish(outbound.Event{Type: eventType, RequestID: httpx.RequestID(ctx), Data: data})
+}
+
+// handleOutboundWebhookStatus reports each outbound webhook target's queue
+// and its most recent delivery.
+func (s *Server) handleOutboundWebhookStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		s.respondError(w, r, apperror.MethodNotAllowed)
+		return
+	}
+	targets := s.outbound.Status()
+	if targets == nil {
+		targets = []outbound.Status{}
+	}
+	s.respondJSON(w, http.StatusOK, map[string]any{
+		"configured": s.outbound != nil,
+		"targets":    targets,
+	})
+}
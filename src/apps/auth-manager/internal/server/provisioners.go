@@ -0,0 +1,285 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/breaker"
+	"github.com/rave-org/rave/apps/auth-manager/internal/config"
+	"github.com/rave-org/rave/apps/auth-manager/internal/gitlab"
+	"github.com/rave-org/rave/apps/auth-manager/internal/grafana"
+	"github.com/rave-org/rave/apps/auth-manager/internal/mattermost"
+	"github.com/rave-org/rave/apps/auth-manager/internal/n8n"
+	"github.com/rave-org/rave/apps/auth-manager/internal/provision"
+	"github.com/rave-org/rave/apps/auth-manager/internal/shadow"
+	"github.com/rave-org/rave/apps/auth-manager/internal/webhook"
+)
+
+// errServiceAccount skips service accounts, which only Mattermost provisions
+// (as bots).
+var errServiceAccount = fmt.Errorf("%w: service account", provision.ErrSkipped)
+
+// provisionResult is what provisionUser did for one user: the shadow store
+// write, then each provisioner in order. Filtered is why the group lists
+// skipped every step, if they did.
+type provisionResult struct {
+	Shadow   provision.Result   `json:"shadow"`
+	Targets  []provision.Result `json:"targets"`
+	Filtered string             `json:"filtered,omitempty"`
+	// Deferred is why provisioning was put off, as in maintenance mode.
+	Deferred string `json:"deferred,omitempty"`
+}
+
+// summary is "provisioned" when nothing failed, "partial" when the shadow
+// write succeeded but a provisioner failed, "failed" when the shadow write
+// did, "filtered" when the group lists skipped the user, and "deferred" in
+// maintenance mode.
+func (r provisionResult) summary() string {
+	if r.Filtered != "" {
+		return "filtered"
+	}
+	if r.Deferred != "" {
+		return "deferred"
+	}
+	if r.Shadow.Status != provision.StatusOK {
+		return "failed"
+	}
+	for _, target := range r.Targets {
+		if target.Status == provision.StatusFailed {
+			return "partial"
+		}
+	}
+	return "provisioned"
+}
+
+// newProvisionRunner builds the provisioners for the configured downstream
+// services in the configured order. Mattermost is required; the others are
+// best effort.
+func (s *Server) newProvisionRunner(cfg config.Config, reg prometheus.Registerer) *provision.Runner {
+	order, err := cfg.ProvisionerOrder()
+	if err != nil {
+		s.logger.Error("invalid provisioners, using the default order", "err", err)
+		order = config.ProvisionerNames
+	}
+	policy, err := cfg.ProvisionerPolicy()
+	if err != nil {
+		s.logger.Error("invalid provision policy, using fail-fast", "err", err)
+		policy = provision.FailFast
+	}
+
+	available := map[string]provision.Target{}
+	if s.mattermost() != nil {
+		available["mattermost"] = provision.Target{Provisioner: mattermostProvisioner{s}, Breaker: breakerGate{s.mmBreakers.Get(mmOpEnsureUser)}, DeprovisionBreaker: breakerGate{s.mmBreakers.Get(mmOpDeactivate)}}
+	}
+	if s.n8nAPI() != nil {
+		available["n8n"] = provision.Target{Provisioner: n8nProvisioner{s}, Breaker: breakerGate{s.n8nBreaker}, Optional: true}
+	}
+	if s.gitlabClient != nil {
+		available["gitlab"] = provision.Target{Provisioner: gitlabProvisioner{s}, Breaker: breakerGate{s.gitlabBreaker}, Optional: true}
+	}
+	if s.grafanaClient != nil {
+		available["grafana"] = provision.Target{Provisioner: grafanaProvisioner{s}, Breaker: breakerGate{s.grafanaBreaker}, Optional: true}
+	}
+	var targets []provision.Target
+	for _, name := range order {
+		target, ok := available[name]
+		if !ok {
+			if len(cfg.Provisioners) > 0 {
+				s.logger.Warn("provisioner listed but not configured", "provisioner", name)
+			}
+			continue
+		}
+		targets = append(targets, target)
+	}
+
+	latency := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "auth_manager_provisioner_duration_seconds",
+		Help:    "Duration of each provisioner's provision and deprovision calls, by status (ok, skipped, failed)",
+		Buckets: latencyBuckets,
+	}, []string{"provisioner", "operation", "status"})
+	reg.MustRegister(latency)
+	return provision.NewRunner(policy, provisionerMetrics{latency}, targets...)
+}
+
+// breakerGate adapts a breaker.Breaker to provision.Breaker.
+type breakerGate struct {
+	breaker *breaker.Breaker
+}
+
+func (g breakerGate) Allow() bool {
+	return g.breaker == nil || g.breaker.Allow()
+}
+
+type provisionerMetrics struct {
+	latency *prometheus.HistogramVec
+}
+
+func (m provisionerMetrics) ObserveProvisioner(name, operation string, status provision.Status, duration time.Duration) {
+	m.latency.WithLabelValues(name, operation, string(status)).Observe(duration.Seconds())
+}
+
+// canonicalIdentity is info as provisioners see it, with the shadow record
+// it was stored as. A sparse request keeps the stored name.
+func (s *Server) canonicalIdentity(info *webhook.UserInfo, shadowUser shadow.ShadowUser) provision.CanonicalIdentity {
+	name := info.Name
+	if name == "" {
+		name = shadowUser.Identity.Name
+	}
+	return provision.CanonicalIdentity{
+		Provider:       info.ShadowProvider(),
+		Subject:        info.ShadowSubject(),
+		Email:          info.Email,
+		Name:           name,
+		Username:       info.Username,
+		Groups:         info.Groups,
+		ServiceAccount: s.isServiceAccount(info),
+		ShadowID:       shadowUser.ID,
+		Attributes:     shadowUser.Attributes,
+	}
+}
+
+// userInfoFor and shadowUserFor convert a canonical identity back for the
+// per-service flows.
+func userInfoFor(ident provision.CanonicalIdentity) *webhook.UserInfo {
+	return &webhook.UserInfo{
+		Email:    ident.Email,
+		Username: ident.Username,
+		Name:     ident.Name,
+		Subject:  ident.Subject,
+		Provider: ident.Provider,
+		Groups:   ident.Groups,
+	}
+}
+
+func shadowUserFor(ident provision.CanonicalIdentity) shadow.ShadowUser {
+	return shadow.ShadowUser{
+		ID: ident.ShadowID,
+		Identity: shadow.Identity{
+			Provider: ident.Provider,
+			Subject:  ident.Subject,
+			Email:    ident.Email,
+			Name:     ident.Name,
+		},
+		Attributes: ident.Attributes,
+	}
+}
+
+// runShadowStep runs a step that records its outcome in shadow attributes,
+// saving them on the user's shadow record when it succeeds.
+func (s *Server) runShadowStep(ctx context.Context, ident provision.CanonicalIdentity, step func(context.Context, *webhook.UserInfo, map[string]string) error) error {
+	attributes := map[string]string{}
+	if err := step(ctx, userInfoFor(ident), attributes); err != nil {
+		return err
+	}
+	if len(attributes) == 0 {
+		return nil
+	}
+	if _, err := s.upsertShadow(ctx, shadowUserFor(ident).Identity, attributes); err != nil {
+		return fmt.Errorf("shadow store upsert: %w", err)
+	}
+	return nil
+}
+
+// unauthorizedError reports whether a health check failed because the
+// service rejected auth-manager's credentials.
+func unauthorizedError(err error) bool {
+	if mattermost.IsUnauthorized(err) || errors.Is(err, n8n.ErrUnauthorized) {
+		return true
+	}
+	for _, status := range []int{gitlab.StatusCode(err), grafana.StatusCode(err)} {
+		if status == http.StatusUnauthorized || status == http.StatusForbidden {
+			return true
+		}
+	}
+	return false
+}
+
+type mattermostProvisioner struct{ s *Server }
+
+func (p mattermostProvisioner) Name() string { return "mattermost" }
+
+func (p mattermostProvisioner) Provision(ctx context.Context, ident provision.CanonicalIdentity) error {
+	if ident.ServiceAccount {
+		return p.s.provisionBot(ctx, userInfoFor(ident), shadowUserFor(ident))
+	}
+	return p.s.provisionMattermostUser(ctx, userInfoFor(ident), shadowUserFor(ident))
+}
+
+func (p mattermostProvisioner) Deprovision(ctx context.Context, ident provision.CanonicalIdentity) error {
+	return p.s.runShadowStep(ctx, ident, p.s.deactivateMattermostUser)
+}
+
+func (p mattermostProvisioner) Healthy(ctx context.Context) error { return p.s.mattermost().Ping(ctx) }
+
+type n8nProvisioner struct{ s *Server }
+
+func (p n8nProvisioner) Name() string { return "n8n" }
+
+func (p n8nProvisioner) Provision(ctx context.Context, ident provision.CanonicalIdentity) error {
+	if ident.ServiceAccount {
+		return errServiceAccount
+	}
+	return p.s.provisionN8NUser(ctx, userInfoFor(ident), shadowUserFor(ident))
+}
+
+func (p n8nProvisioner) Deprovision(ctx context.Context, ident provision.CanonicalIdentity) error {
+	return p.s.runShadowStep(ctx, ident, p.s.deprovisionN8NUser)
+}
+
+func (p n8nProvisioner) Healthy(ctx context.Context) error { return p.s.n8nAPI().Ping(ctx) }
+
+type gitlabProvisioner struct{ s *Server }
+
+func (p gitlabProvisioner) Name() string { return "gitlab" }
+
+func (p gitlabProvisioner) Provision(ctx context.Context, ident provision.CanonicalIdentity) error {
+	if ident.ServiceAccount {
+		return errServiceAccount
+	}
+	return p.s.provisionGitLabUser(ctx, userInfoFor(ident), shadowUserFor(ident))
+}
+
+func (p gitlabProvisioner) Deprovision(ctx context.Context, ident provision.CanonicalIdentity) error {
+	return p.s.runShadowStep(ctx, ident, p.s.blockGitLabUser)
+}
+
+func (p gitlabProvisioner) Healthy(ctx context.Context) error { return p.s.gitlabClient.Ping(ctx) }
+
+type grafanaProvisioner struct{ s *Server }
+
+func (p grafanaProvisioner) Name() string { return "grafana" }
+
+// Provision re-enables an account deprovisioning disabled before syncing
+// it.
+func (p grafanaProvisioner) Provision(ctx context.Context, ident provision.CanonicalIdentity) error {
+	if ident.ServiceAccount {
+		return errServiceAccount
+	}
+	if ident.Attributes[attrGrafanaDisabled] == "true" {
+		if err := p.s.runShadowStep(ctx, ident, p.setDisabled(false)); err != nil {
+			return err
+		}
+	}
+	login := ident.Username
+	if login == "" {
+		login = ident.Email
+	}
+	return p.s.syncGrafanaUser(ctx, grafana.Identity{Email: ident.Email, Name: ident.Name, Login: login}, ident.Groups)
+}
+
+func (p grafanaProvisioner) Deprovision(ctx context.Context, ident provision.CanonicalIdentity) error {
+	return p.s.runShadowStep(ctx, ident, p.setDisabled(true))
+}
+
+func (p grafanaProvisioner) setDisabled(disabled bool) func(context.Context, *webhook.UserInfo, map[string]string) error {
+	return func(ctx context.Context, info *webhook.UserInfo, attributes map[string]string) error {
+		return p.s.setGrafanaDisabled(ctx, info.Email, disabled, attributes)
+	}
+}
+
+func (p grafanaProvisioner) Healthy(ctx context.Context) error { return p.s.grafanaClient.Ping(ctx) }
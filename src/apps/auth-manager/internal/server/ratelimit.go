@@ -0,0 +1,110 @@
+package server
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/accounts"
+	"github.com/rave-org/rave/apps/auth-manager/internal/apperror"
+)
+
+// rateLimiter is a token bucket per caller. A bucket left alone long enough
+// to refill is indistinguishable from a new one, so sweeps drop those, and
+// memory stays bounded by the callers active within one refill period.
+type rateLimiter struct {
+	mu        sync.Mutex
+	perSecond float64
+	burst     float64
+	buckets   map[string]*tokenBucket
+	nextSweep time.Time
+	now       func() time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	at     time.Time
+}
+
+// newRateLimiter returns a limiter allowing perMinute requests per caller
+// with bursts of up to burst, or nil when perMinute is 0.
+func newRateLimiter(perMinute, burst int) *rateLimiter {
+	if perMinute <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = 1
+	}
+	return &rateLimiter{
+		perSecond: float64(perMinute) / 60,
+		burst:     float64(burst),
+		buckets:   make(map[string]*tokenBucket),
+		now:       time.Now,
+	}
+}
+
+// refillPeriod is how long an empty bucket takes to fill up.
+func (l *rateLimiter) refillPeriod() time.Duration {
+	return time.Duration(l.burst / l.perSecond * float64(time.Second))
+}
+
+// allow takes a token from key's bucket. When it's empty, wait is how long
+// until the next token arrives.
+func (l *rateLimiter) allow(key string) (ok bool, wait time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	if !now.Before(l.nextSweep) {
+		for k, b := range l.buckets {
+			if now.Sub(b.at) >= l.refillPeriod() {
+				delete(l.buckets, k)
+			}
+		}
+		l.nextSweep = now.Add(l.refillPeriod())
+	}
+
+	b, found := l.buckets[key]
+	if !found {
+		b = &tokenBucket{tokens: l.burst, at: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.at).Seconds()*l.perSecond)
+	b.at = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / l.perSecond * float64(time.Second))
+}
+
+// rateLimit throttles handler per caller, answering 429 with Retry-After
+// once the caller's bucket is empty. Callers are keyed by their forwarded
+// identity, falling back to the client IP.
+func (s *Server) rateLimit(endpoint string, handler http.HandlerFunc) http.HandlerFunc {
+	if s.rateLimiter == nil {
+		return handler
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ok, wait := s.rateLimiter.allow(endpoint + " " + s.rateLimitKey(r))
+		if !ok {
+			s.rateLimited.WithLabelValues(endpoint).Inc()
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			s.respondError(w, r, apperror.RateLimited)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func (s *Server) rateLimitKey(r *http.Request) string {
+	ident, _ := s.identityFromRequest(r)
+	switch {
+	case ident.Subject != "":
+		return "uid:" + ident.Subject
+	case ident.Email != "":
+		return "email:" + accounts.NormalizeEmail(ident.Email)
+	}
+	return "ip:" + s.clientIP(r)
+}
@@ -0,0 +1,321 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/apperror"
+	"github.com/rave-org/rave/apps/auth-manager/internal/authentik"
+	"github.com/rave-org/rave/apps/auth-manager/internal/shadow"
+	"github.com/rave-org/rave/apps/auth-manager/internal/webhook"
+)
+
+// maxReconcileFailures bounds how many per-user failures a summary reports.
+const maxReconcileFailures = 20
+
+// reconcileState tracks the in-progress guard and results of reconcile runs,
+// shared by the on-demand endpoint and the background loop.
+type reconcileState struct {
+	mu       sync.Mutex
+	running  bool
+	last     *reconcileSummary
+	lastOK   time.Time
+	nextRun  time.Time
+	duration prometheus.Gauge
+	success  prometheus.Gauge
+	runs     *prometheus.CounterVec
+}
+
+func newReconcileState(reg prometheus.Registerer) *reconcileState {
+	st := &reconcileState{
+		duration: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "auth_manager_reconcile_last_duration_seconds",
+			Help: "Duration of the most recent reconcile run",
+		}),
+		success: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "auth_manager_reconcile_last_success_timestamp_seconds",
+			Help: "Unix time of the most recent successful reconcile run",
+		}),
+		runs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "auth_manager_reconcile_runs_total",
+			Help: "Number of reconcile runs by trigger and result",
+		}, []string{"trigger", "result"}),
+	}
+	reg.MustRegister(st.duration, st.success, st.runs)
+	return st
+}
+
+// begin marks a run as started, returning false if one is already in progress.
+func (st *reconcileState) begin() bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.running {
+		return false
+	}
+	st.running = true
+	return true
+}
+
+func (st *reconcileState) finish(trigger string, summary reconcileSummary, err error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.running = false
+	st.last = &summary
+	st.duration.Set(summary.FinishedAt.Sub(summary.StartedAt).Seconds())
+	result := "success"
+	if err != nil {
+		result = "error"
+	} else {
+		st.lastOK = summary.FinishedAt
+		st.success.Set(float64(summary.FinishedAt.Unix()))
+	}
+	st.runs.WithLabelValues(trigger, result).Inc()
+}
+
+func (st *reconcileState) setNextRun(t time.Time) {
+	st.mu.Lock()
+	st.nextRun = t
+	st.mu.Unlock()
+}
+
+// reconcileSummary reports the outcome of a pull-based reconciliation run.
+type reconcileSummary struct {
+	Created    int                `json:"created"`
+	Updated    int                `json:"updated"`
+	Skipped    int                `json:"skipped"`
+	Errors     int                `json:"errors"`
+	Failures   []reconcileFailure `json:"failures,omitempty"`
+	StartedAt  time.Time          `json:"started_at"`
+	FinishedAt time.Time          `json:"finished_at"`
+	Duration   string             `json:"duration"`
+	Error      string             `json:"error,omitempty"`
+	DryRun     bool               `json:"dry_run,omitempty"`
+}
+
+type reconcileFailure struct {
+	Email string `json:"email"`
+	Error string `json:"error"`
+}
+
+// handleReconcile walks every Authentik user and syncs them into the shadow
+// store and downstream services.
+func (s *Server) handleReconcile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		s.respondError(w, r, apperror.MethodNotAllowed)
+		return
+	}
+	if s.authentikClient == nil {
+		s.respondError(w, r, notConfigured("authentik API not configured"))
+		return
+	}
+
+	timeout := s.reconcileTimeout()
+	// A full run can outlast the server-wide write timeout.
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(timeout + 5*time.Second))
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	summary, err := s.runReconcile(ctx, "manual")
+	if errors.Is(err, errReconcileInProgress) {
+		s.respondError(w, r, apperror.Wrap(err, http.StatusConflict, apperror.CodeReconcileInProgress, "a reconcile run is already in progress"))
+		return
+	}
+	status := http.StatusOK
+	if err != nil {
+		status = http.StatusBadGateway
+	}
+	s.respondJSON(w, status, summary)
+}
+
+// handleReconcileStatus reports the state of the most recent reconcile run.
+func (s *Server) handleReconcileStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		s.respondError(w, r, apperror.MethodNotAllowed)
+		return
+	}
+
+	st := s.reconcileState
+	st.mu.Lock()
+	payload := map[string]any{
+		"configured": s.authentikClient != nil,
+		"running":    st.running,
+		"interval":   s.cfg.ReconcileInterval.String(),
+		"last_run":   st.last,
+	}
+	if !st.lastOK.IsZero() {
+		payload["last_success"] = st.lastOK
+	}
+	if !st.nextRun.IsZero() {
+		payload["next_run"] = st.nextRun
+	}
+	st.mu.Unlock()
+
+	s.respondJSON(w, http.StatusOK, payload)
+}
+
+var errReconcileInProgress = errors.New("reconcile already in progress")
+
+func (s *Server) reconcileTimeout() time.Duration {
+	if s.cfg.ReconcileTimeout <= 0 {
+		return 5 * time.Minute
+	}
+	return s.cfg.ReconcileTimeout
+}
+
+// runReconcile guards reconcile so only one run happens at a time and records
+// its outcome for the status endpoint and metrics.
+func (s *Server) runReconcile(ctx context.Context, trigger string) (reconcileSummary, error) {
+	if !s.reconcileState.begin() {
+		return reconcileSummary{}, errReconcileInProgress
+	}
+	summary, err := s.reconcile(ctx)
+	s.reconcileState.finish(trigger, summary, err)
+	return summary, err
+}
+
+// runReconciler periodically reconciles until ctx is cancelled. Each wait is
+// jittered by up to ±10% so replicas don't hit Authentik in lockstep.
+func (s *Server) runReconciler(ctx context.Context, interval time.Duration) {
+	s.logger.InfoContext(ctx, "background reconciler started", "interval", interval)
+	for {
+		wait := jitter(interval)
+		s.reconcileState.setNextRun(time.Now().Add(wait).UTC())
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			s.logger.InfoContext(ctx, "background reconciler stopped")
+			return
+		case <-timer.C:
+		}
+
+		runCtx, cancel := context.WithTimeout(ctx, s.reconcileTimeout())
+		if _, err := s.runReconcile(runCtx, "scheduled"); errors.Is(err, errReconcileInProgress) {
+			s.logger.InfoContext(ctx, "skipping scheduled reconcile, previous run still in progress")
+		}
+		cancel()
+	}
+}
+
+func jitter(d time.Duration) time.Duration {
+	spread := int64(d) / 5
+	if spread <= 0 {
+		return d
+	}
+	return d - time.Duration(spread/2) + time.Duration(rand.Int63n(spread))
+}
+
+// reconcile performs one Authentik → shadow store → downstream sync. The
+// returned summary is populated even when the run is cut short by err.
+func (s *Server) reconcile(ctx context.Context) (reconcileSummary, error) {
+	summary := reconcileSummary{StartedAt: time.Now().UTC(), DryRun: s.cfg.DryRun}
+
+	err := s.authentikClient.EachUser(ctx, func(user authentik.User) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !user.IsActive || user.Email == "" {
+			summary.Skipped++
+			return nil
+		}
+
+		info := &webhook.UserInfo{
+			Email:    user.Email,
+			Username: user.Username,
+			Name:     user.Name,
+			Subject:  strconv.Itoa(user.PK),
+			Groups:   user.GroupNames(),
+		}
+
+		existing, getErr := s.shadowStore.Get(ctx, info.ShadowProvider(), info.ShadowSubject())
+		existed := getErr == nil
+		if getErr != nil && !errors.Is(getErr, shadow.ErrNotFound) {
+			summary.addFailure(info.Email, getErr)
+			return nil
+		}
+		// Deprovisioned users stay deactivated until explicitly re-synced.
+		if existing.Attributes[attrMattermostDeactivated] == "true" {
+			summary.Skipped++
+			return nil
+		}
+
+		result, err := s.provisionUser(ctx, info)
+		if err != nil {
+			summary.addFailure(info.Email, err)
+			return nil
+		}
+		if result.Filtered != "" || result.Deferred != "" {
+			summary.Skipped++
+			return nil
+		}
+		if existed {
+			summary.Updated++
+		} else {
+			summary.Created++
+		}
+		return nil
+	})
+
+	summary.FinishedAt = time.Now().UTC()
+	summary.Duration = summary.FinishedAt.Sub(summary.StartedAt).String()
+	if err != nil {
+		summary.Error = errorText(err)
+		s.logger.ErrorContext(ctx, "reconcile aborted", "err", err, "created", summary.Created, "updated", summary.Updated)
+		return summary, err
+	}
+
+	s.logger.InfoContext(ctx, "reconcile complete",
+		"created", summary.Created,
+		"updated", summary.Updated,
+		"skipped", summary.Skipped,
+		"errors", summary.Errors,
+		"duration", summary.Duration,
+	)
+	return summary, nil
+}
+
+func (rs *reconcileSummary) addFailure(email string, err error) {
+	rs.Errors++
+	if len(rs.Failures) < maxReconcileFailures {
+		rs.Failures = append(rs.Failures, reconcileFailure{Email: email, Error: errorText(err)})
+	}
+}
+
+// enrichFromAuthentik fills in missing identity fields for native webhook
+// payloads by fetching the user from the Authentik API. It is best effort.
+func (s *Server) enrichFromAuthentik(ctx context.Context, info *webhook.UserInfo) {
+	if s.authentikClient == nil || info.Subject == "" {
+		return
+	}
+	pk, err := strconv.Atoi(info.Subject)
+	if err != nil {
+		return
+	}
+	user, err := s.authentikClient.GetUser(ctx, pk)
+	if err != nil {
+		s.logger.WarnContext(ctx, "authentik user lookup failed", "pk", pk, "err", err)
+		return
+	}
+	if info.Email == "" {
+		info.Email = user.Email
+	}
+	if info.Username == "" {
+		info.Username = user.Username
+	}
+	if info.Name == "" {
+		info.Name = user.Name
+	}
+	if info.Groups == nil {
+		info.Groups = user.GroupNames()
+	}
+}
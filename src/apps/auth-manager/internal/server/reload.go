@@ -0,0 +1,163 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/apperror"
+	"github.com/rave-org/rave/apps/auth-manager/internal/config"
+	"github.com/rave-org/rave/apps/auth-manager/internal/mattermost"
+	"github.com/rave-org/rave/apps/auth-manager/internal/n8n"
+)
+
+// mattermost returns the current Mattermost client, or nil when Mattermost
+// isn't configured.
+func (s *Server) mattermost() *mattermost.Client { return s.mmClient.Load() }
+
+// n8nAPI returns the current n8n client, or nil when n8n isn't configured.
+func (s *Server) n8nAPI() *n8n.Client { return s.n8nClient.Load() }
+
+// newMattermostClient builds the Mattermost client for cfg, or returns nil
+// without an admin token.
+func (s *Server) newMattermostClient(cfg config.Config) *mattermost.Client {
+	if cfg.MattermostAdminToken == "" {
+		return nil
+	}
+	opts := s.clientOptions(s.clientOpts, "mattermost")
+	client := mattermost.NewClientWithOptions(cfg.MattermostInternalURL, cfg.MattermostAdminToken, opts)
+	client.SetProfileSync(!cfg.DisableProfileSync)
+	client.SetRetryHook(func(method, reason string) {
+		s.mmRetries.WithLabelValues(method, reason).Inc()
+	})
+	client.SetMetrics(s.mmLatency)
+	return client
+}
+
+// newN8NClient builds the n8n client for cfg, preferring the API key, or
+// returns nil without credentials.
+func (s *Server) newN8NClient(cfg config.Config) *n8n.Client {
+	opts := s.clientOptions(s.clientOpts, "n8n")
+	var client *n8n.Client
+	switch {
+	case cfg.N8NAPIKey != "":
+		client = n8n.NewClientWithAPIKeyOptions(cfg.N8NInternalURL, cfg.N8NAPIKey, opts)
+	case cfg.N8NOwnerEmail != "" && cfg.N8NOwnerPass != "":
+		client = n8n.NewClientWithOptions(cfg.N8NInternalURL, cfg.N8NOwnerEmail, cfg.N8NOwnerPass, opts)
+	default:
+		return nil
+	}
+	client.SetMetrics(s.n8nLatency)
+	return client
+}
+
+// SetConfigSource replaces the function Reload reads the configuration
+// with, config.FromEnv by default.
+func (s *Server) SetConfigSource(load func() config.Config) {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+	s.loadConfig = load
+}
+
+// ReloadResult lists the settings a reload found changed: those it applied,
+// and those that only take effect after a restart.
+type ReloadResult struct {
+	Applied []string `json:"applied"`
+	Ignored []string `json:"ignored"`
+}
+
+// Reload reads the configuration again and applies what can change at
+// runtime: the webhook secrets and sources, and the Mattermost and n8n
+// credentials, for which new clients are swapped in. Other changed settings,
+// such as ListenAddr and DatabaseURL, are reported as ignored until a
+// restart. An invalid configuration changes nothing.
+func (s *Server) Reload() (ReloadResult, error) {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	live, next := s.liveCfg, s.loadConfig()
+	if next.WebhookSecretGenerated {
+		// A newly generated secret would lock out every Authentik instance
+		// configured with the one in use.
+		next.WebhookSecret, next.WebhookSecretGenerated = live.WebhookSecret, live.WebhookSecretGenerated
+	}
+	if err := next.Validate(); err != nil {
+		s.logger.Error("configuration reload failed, nothing changed", "err", err)
+		return ReloadResult{}, err
+	}
+
+	// Even secrets that only apply after a restart are masked from now on.
+	s.secrets.Add(next.Secrets()...)
+
+	changed := config.ChangedFields(live, next)
+	pending := map[string]bool{}
+	for _, name := range changed {
+		pending[name] = true
+	}
+	anyPending := func(names ...string) bool {
+		for _, name := range names {
+			if pending[name] {
+				return true
+			}
+		}
+		return false
+	}
+	done := func(names ...string) {
+		for _, name := range names {
+			delete(pending, name)
+		}
+	}
+
+	if anyPending("WebhookSecret", "WebhookSources") {
+		sources, _ := next.WebhookSourceMap() // checked by Validate
+		s.webhookSources.Store(&sources)
+		live.WebhookSecret, live.WebhookSecretGenerated, live.WebhookSources = next.WebhookSecret, next.WebhookSecretGenerated, next.WebhookSources
+		done("WebhookSecret", "WebhookSecretGenerated", "WebhookSources")
+	}
+
+	// Clients are only swapped, never added or removed, since the
+	// provisioners are fixed at startup.
+	if anyPending("MattermostAdminToken") && s.mattermost() != nil && next.MattermostAdminToken != "" {
+		live.MattermostAdminToken = next.MattermostAdminToken
+		s.mmClient.Store(s.newMattermostClient(live))
+		done("MattermostAdminToken")
+	}
+	if anyPending("N8NAPIKey", "N8NOwnerEmail", "N8NOwnerPass") && s.n8nAPI() != nil {
+		cfg := live
+		cfg.N8NAPIKey, cfg.N8NOwnerEmail, cfg.N8NOwnerPass = next.N8NAPIKey, next.N8NOwnerEmail, next.N8NOwnerPass
+		if client := s.newN8NClient(cfg); client != nil {
+			s.n8nClient.Store(client)
+			live = cfg
+			done("N8NAPIKey", "N8NOwnerEmail", "N8NOwnerPass")
+		}
+	}
+
+	result := ReloadResult{Applied: []string{}, Ignored: []string{}}
+	for _, name := range changed {
+		switch {
+		case name == "WebhookSecretGenerated": // reported as WebhookSecret
+		case pending[name]:
+			result.Ignored = append(result.Ignored, name)
+		default:
+			result.Applied = append(result.Applied, name)
+		}
+	}
+	s.liveCfg = live
+	s.logger.Info("configuration reloaded", "applied", result.Applied, "ignored_until_restart", result.Ignored)
+	return result, nil
+}
+
+// handleReload reloads the configuration, like SIGHUP.
+func (s *Server) handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		s.respondError(w, r, apperror.MethodNotAllowed)
+		return
+	}
+	result, err := s.Reload()
+	if err != nil {
+		// Validation messages can quote settings such as the database URL.
+		s.respondError(w, r, apperror.Wrap(err, http.StatusUnprocessableEntity, apperror.CodeInvalidConfig,
+			"the new configuration is invalid and nothing changed; the server log lists the problems"))
+		return
+	}
+	s.respondJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/accounts"
+	"github.com/rave-org/rave/apps/auth-manager/internal/apperror"
+	"github.com/rave-org/rave/apps/auth-manager/internal/authentik"
+	"github.com/rave-org/rave/apps/auth-manager/internal/shadow"
+	"github.com/rave-org/rave/apps/auth-manager/internal/webhook"
+)
+
+// Sources of each email's current subject for the remap endpoint.
+const (
+	remapSourceAuthentik   = "authentik"
+	remapSourceShadowStore = "shadow_store"
+)
+
+// remapRequest is the optional body of POST /api/v1/admin/remap.
+type remapRequest struct {
+	// Source is where each email's current subject comes from: authentik,
+	// the default when its API is configured, for the PK of the Authentik
+	// user with the email, or shadow_store for the subject of the email's
+	// most recently updated record.
+	Source string `json:"source,omitempty"`
+	DryRun bool   `json:"dry_run,omitempty"`
+}
+
+// remapResult is what a remap run did.
+type remapResult struct {
+	Source   string `json:"source"`
+	DryRun   bool   `json:"dry_run"`
+	Merged   int    `json:"merged"`
+	Orphaned int    `json:"orphaned"`
+}
+
+// handleRemap moves every shadow user to the current subject of their
+// email, as AUTH_MANAGER_REMAP_BY_EMAIL does for each event, so the records
+// left by an Authentik reinstallation are merged in one go rather than as
+// their users next show up.
+func (s *Server) handleRemap(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		s.respondError(w, r, apperror.MethodNotAllowed)
+		return
+	}
+	var payload remapRequest
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil && !errors.Is(err, io.EOF) {
+		s.respondBodyError(w, r, err)
+		return
+	}
+	switch payload.Source {
+	case "":
+		payload.Source = remapSourceShadowStore
+		if s.authentikClient != nil {
+			payload.Source = remapSourceAuthentik
+		}
+	case remapSourceAuthentik:
+		if s.authentikClient == nil {
+			s.respondError(w, r, notConfigured("authentik API not configured"))
+			return
+		}
+	case remapSourceShadowStore:
+	default:
+		s.respondError(w, r, invalidRequest("source must be authentik or shadow_store"))
+		return
+	}
+	payload.DryRun = payload.DryRun || s.cfg.DryRun
+
+	timeout := s.reconcileTimeout()
+	// Listing every Authentik user can outlast the server-wide write timeout.
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(timeout + 5*time.Second))
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	var current map[string]string
+	if payload.Source == remapSourceAuthentik {
+		var err error
+		if current, err = s.authentikSubjects(ctx); err != nil {
+			s.logger.ErrorContext(ctx, "remap: listing authentik users failed", "err", err)
+			s.respondError(w, r, apperror.Wrap(err, http.StatusBadGateway, apperror.CodeAuthentikError, "listing Authentik users failed"))
+			return
+		}
+	}
+	report, err := shadow.Remap(ctx, s.shadowStore, webhook.DefaultProvider, current, payload.DryRun)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "remap failed", "merged", report.Merged, "err", err)
+		s.respondError(w, r, storeUnavailable(err))
+		return
+	}
+	if !payload.DryRun {
+		s.shadowRemapped.WithLabelValues("admin").Add(float64(report.Merged))
+	}
+	s.logger.InfoContext(ctx, "remapped shadow users", "source", payload.Source, "dry_run", payload.DryRun, "merged", report.Merged, "orphaned", report.Orphaned)
+	s.respondJSON(w, http.StatusOK, remapResult{
+		Source:   payload.Source,
+		DryRun:   payload.DryRun,
+		Merged:   report.Merged,
+		Orphaned: report.Orphaned,
+	})
+}
+
+// authentikSubjects maps the email of every Authentik user to their PK.
+// Emails several users share are left out, since there's no telling which
+// of them a shadow record belongs to.
+func (s *Server) authentikSubjects(ctx context.Context) (map[string]string, error) {
+	subjects := map[string]string{}
+	shared := map[string]bool{}
+	err := s.authentikClient.EachUser(ctx, func(user authentik.User) error {
+		email := accounts.NormalizeEmail(user.Email)
+		if email == "" {
+			return nil
+		}
+		if _, seen := subjects[email]; seen {
+			shared[email] = true
+		}
+		subjects[email] = strconv.Itoa(user.PK)
+		return nil
+	})
+	for email := range shared {
+		delete(subjects, email)
+	}
+	return subjects, err
+}
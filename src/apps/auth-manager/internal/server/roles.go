@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"strings"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/mattermost"
+	"github.com/rave-org/rave/apps/auth-manager/internal/n8n"
+)
+
+// syncMattermostRoles applies the configured group → role mapping to a
+// Mattermost user. It only calls Mattermost when the desired roles differ
+// from the user's current ones, and does nothing when groups are unknown.
+func (s *Server) syncMattermostRoles(ctx context.Context, user mattermost.User, groups []string) {
+	if len(s.roleMap) == 0 || groups == nil {
+		return
+	}
+	roles := desiredRoles(user.Roles, groups, s.roleMap, s.cfg.MattermostRoleDemotion)
+	if roles == user.Roles {
+		return
+	}
+	if err := s.mattermost().UpdateUserRoles(ctx, user.ID, roles); err != nil {
+		s.recordMattermostFailure(mmOpEnsureUser, err)
+		s.logger.WarnContext(ctx, "failed to update mattermost roles", "user_id", user.ID, "roles", roles, "err", err)
+		return
+	}
+	s.recordMattermostSuccess(mmOpEnsureUser)
+	s.logger.InfoContext(ctx, "mattermost roles updated", "user_id", user.ID, "from", user.Roles, "to", roles)
+}
+
+// desiredRoles computes the roles string for a user from their current roles
+// and groups. Roles granted by a matching group are appended; with demote set,
+// mapped roles the user's groups no longer grant are removed. Roles the mapping
+// doesn't mention, and system_user, are never removed. The current string is
+// returned unchanged when nothing would change.
+func desiredRoles(current string, groups []string, mapping map[string][]string, demote bool) string {
+	granted := map[string]bool{}
+	for _, group := range groups {
+		for _, role := range mapping[group] {
+			granted[role] = true
+		}
+	}
+	managed := map[string]bool{}
+	for _, roles := range mapping {
+		for _, role := range roles {
+			managed[role] = true
+		}
+	}
+
+	var next []string
+	have := map[string]bool{}
+	changed := false
+	for _, role := range strings.Fields(current) {
+		if demote && managed[role] && !granted[role] && role != "system_user" {
+			changed = true
+			continue
+		}
+		if !have[role] {
+			have[role] = true
+			next = append(next, role)
+		}
+	}
+	// Append in mapping order per group so the result is deterministic.
+	for _, group := range groups {
+		for _, role := range mapping[group] {
+			if !have[role] {
+				have[role] = true
+				next = append(next, role)
+				changed = true
+			}
+		}
+	}
+	if !changed {
+		return current
+	}
+	return strings.Join(next, " ")
+}
+
+// desiredN8NRole returns the n8n global role the user's groups grant:
+// RoleAdmin when any group maps to it, otherwise RoleMember. It returns ""
+// when no role map is configured or the groups are unknown.
+func (s *Server) desiredN8NRole(groups []string) string {
+	if len(s.n8nRoleMap) == 0 || groups == nil {
+		return ""
+	}
+	for _, group := range groups {
+		if s.n8nRoleMap[group] == n8n.RoleAdmin {
+			return n8n.RoleAdmin
+		}
+	}
+	return n8n.RoleMember
+}
+
+// syncN8NRole changes the user's n8n role to role when they differ. Users
+// whose current role n8n didn't report, and the owner, are never changed, and admins are only demoted with N8NRoleDemotion.
+func (s *Server) syncN8NRole(ctx context.Context, user n8n.User, role string) {
+	if role == "" || user.Role == "" || user.Role == role || user.Role == n8n.RoleOwner {
+		return
+	}
+	if user.Role == n8n.RoleAdmin && !s.cfg.N8NRoleDemotion {
+		return
+	}
+	if err := s.n8nAPI().SetUserRole(ctx, user.ID, role); err != nil {
+		s.recordN8NFailure(err)
+		s.logger.WarnContext(ctx, "failed to update n8n role", "user_id", user.ID, "role", role, "err", err)
+		return
+	}
+	s.recordN8NSuccess()
+	s.logger.InfoContext(ctx, "n8n role updated", "user_id", user.ID, "from", user.Role, "to", role)
+}
@@ -5,61 +5,341 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"html/template"
 	"log/slog"
 	"net/http"
+	"net/netip"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
+	"github.com/rave-org/rave/apps/auth-manager/internal/accounts"
+	"github.com/rave-org/rave/apps/auth-manager/internal/apperror"
+	"github.com/rave-org/rave/apps/auth-manager/internal/authentik"
+	"github.com/rave-org/rave/apps/auth-manager/internal/breaker"
 	"github.com/rave-org/rave/apps/auth-manager/internal/config"
+	"github.com/rave-org/rave/apps/auth-manager/internal/eventstream"
+	"github.com/rave-org/rave/apps/auth-manager/internal/gitlab"
+	"github.com/rave-org/rave/apps/auth-manager/internal/grafana"
+	"github.com/rave-org/rave/apps/auth-manager/internal/httpx"
+	"github.com/rave-org/rave/apps/auth-manager/internal/identity"
 	"github.com/rave-org/rave/apps/auth-manager/internal/mattermost"
 	"github.com/rave-org/rave/apps/auth-manager/internal/n8n"
+	"github.com/rave-org/rave/apps/auth-manager/internal/outbound"
+	"github.com/rave-org/rave/apps/auth-manager/internal/provision"
+	"github.com/rave-org/rave/apps/auth-manager/internal/redact"
 	"github.com/rave-org/rave/apps/auth-manager/internal/shadow"
+	"github.com/rave-org/rave/apps/auth-manager/internal/tracing"
+	"github.com/rave-org/rave/apps/auth-manager/internal/version"
 	"github.com/rave-org/rave/apps/auth-manager/internal/webhook"
+	"github.com/rave-org/rave/apps/auth-manager/pkg/mmbridge"
 )
 
 // Server owns the HTTP surface area for the auth-manager control plane.
 type Server struct {
 	cfg              config.Config
+	secrets          *redact.Secrets // masked in every log line
 	shadowStore      shadow.Store
 	httpServer       *http.Server
-	mmClient         *mattermost.Client
-	n8nClient        *n8n.Client
+	tracer           *tracing.Tracer                   // nil when tracing is off
+	debugServer      *http.Server                      // on DebugAddr, or nil
+	mmClient         atomic.Pointer[mattermost.Client] // swapped by Reload
+	n8nClient        atomic.Pointer[n8n.Client]        // swapped by Reload
+	clientOpts       httpx.Options
+	mmLatency        latencyObserver
+	n8nLatency       latencyObserver
+	gitlabClient     *gitlab.Client
+	grafanaClient    *grafana.Client
+	authentikClient  *authentik.Client
 	metricsRegistry  *prometheus.Registry
 	usersProvisioned prometheus.Counter
-	webhooksReceived prometheus.Counter
+	usersFiltered    *prometheus.CounterVec
+	webhooksReceived prometheus.Counter // deprecated sum of webhookEvents
+	webhookEvents    *prometheus.CounterVec
+	sessionsRevoked  prometheus.Counter
+	logouts          *prometheus.CounterVec
+	webhookRejected  *prometheus.CounterVec
+	webhookWarnings  *prometheus.CounterVec
+	webhookUnmapped  prometheus.Counter
+	joinFailures     *prometheus.CounterVec
+	teamSyncChanges  *prometheus.CounterVec
+	shadowRemapped   *prometheus.CounterVec
+	shadowUnreadable *prometheus.CounterVec
+	mmRetries        *prometheus.CounterVec
+	sessionCache     *sessionCache
+	n8nSessions      *n8nSessionCache
+	sessionLookups   *prometheus.CounterVec
+	downstreamCalls  *prometheus.CounterVec // Mattermost/n8n requests, real or simulated
+	provisionLatency *prometheus.HistogramVec
+	userLocks        *userLocks
+	webhookPolicy    webhook.Policy
+	webhookSources   atomic.Pointer[map[string]config.WebhookSource] // swapped by Reload
+	forwardAuth      map[string]forwardService
+	roleMap          map[string][]string // Group → Mattermost system roles
+	teamMap          map[string][]string // Group → Mattermost team names
+	n8nRoleMap       map[string]string   // Group → n8n global role
+	n8nProjectMap    map[string][]string // Group → n8n project names
+	n8nProjects      *n8nProjectCache
+	gitlabGroups     map[string][]gitlab.Membership // Group → GitLab memberships
+	grafanaRoles     map[string]string              // Group → Grafana org role
+	grafanaTeams     map[string][]string            // Group → Grafana team names
+	grafanaSynced    *grafanaSyncCache
+	grafanaFailures  *prometheus.CounterVec
 	logger           *slog.Logger
-	mmBreaker        *circuitBreaker
-	n8nBreaker       *circuitBreaker
+	mmBreakers       *breaker.Registry // per Mattermost operation
+	n8nBreaker       *breaker.Breaker
+	gitlabBreaker    *breaker.Breaker
+	grafanaBreaker   *breaker.Breaker
+	provisioners     *provision.Runner
+	rateLimiter      *rateLimiter
+	idempotency      *idempotencyCache // nil when Idempotency-Key is ignored
+	identitySources  []identity.Source
+	trustedProxies   []netip.Prefix
+	corsOrigins      map[string]bool    // lowercased CORSOrigins
+	corsAnyOrigin    bool               // CORSOrigins has "*"
+	apiMethods       map[string]string  // management API path → Allow
+	mmOptions        mmbridge.Options   // of forward auth's Mattermost sessions and the session cookies it sets
+	mattermostOrigin string             // of MattermostURL, for crossSiteReason
+	n8nOrigin        string             // of N8NURL, for crossSiteReason
+	errorPage        *template.Template // shown to browsers when forward auth fails
+	emailDomains     []string           // normalized AllowedEmailDomains
+	rateLimited      *prometheus.CounterVec
+	httpMetrics      *httpMetrics
+	alertBreaker     *breaker.Breaker
+	alertsForwarded  prometheus.Counter
+	alertsDropped    prometheus.Counter
+	opsAlerts        *opsAlerter
+	userHistory      *userHistory
+	maintenance      *maintenance
+	outbound         *outbound.Dispatcher  // nil without outbound webhooks
+	eventConsumer    *eventstream.Consumer // nil without an event stream
+	reconcileState   *reconcileState
+	warm             *warmup
+
+	// Background workers run under lifecycle, which Shutdown stops.
+	lifecycle *lifecycle
+
+	// Reload applies the settings loadConfig returns on top of liveCfg, the
+	// configuration last applied.
+	reloadMu   sync.Mutex
+	liveCfg    config.Config
+	loadConfig func() config.Config
 }
 
-// New wires up the HTTP server, routes, and store.
-func New(cfg config.Config, store shadow.Store, logger *slog.Logger) *Server {
+// New wires up the HTTP server, routes, and store. It fails if a service URL
+// in cfg doesn't parse or the attribute encryption key is invalid, rather
+// than store sensitive attributes in plaintext; other invalid settings are
+// logged and fall back to their defaults.
+func New(cfg config.Config, store shadow.Store, logger *slog.Logger) (*Server, error) {
+	if err := cfg.CheckURLs(); err != nil {
+		return nil, err
+	}
+	attrKeys, err := cfg.AttributeEncryptionKeys()
+	if err != nil {
+		return nil, err
+	}
 	if logger == nil {
 		logger = slog.Default()
 	}
+	secrets := &redact.Secrets{}
+	secrets.Add(cfg.Secrets()...)
+	logger = slog.New(requestIDHandler{redact.NewHandler(logger.Handler(), secrets)})
 
 	srv := &Server{
 		cfg:        cfg,
+		secrets:    secrets,
 		logger:     logger,
-		mmBreaker:  newCircuitBreaker(5, 30*time.Second),
-		n8nBreaker: newCircuitBreaker(5, 30*time.Second),
+		userLocks:  newUserLocks(),
+		mmBreakers: breaker.NewRegistry(breakerSettings(cfg), mmOperations...),
+		n8nBreaker: breaker.New(breakerSettings(cfg)),
+		// Alerts get their own breaker so a broken alert channel can't
+		// trip provisioning and vice versa.
+		alertBreaker: newCircuitBreaker(3, time.Minute),
+	}
+	srv.gitlabBreaker = breaker.New(breakerSettings(cfg))
+	srv.grafanaBreaker = breaker.New(breakerSettings(cfg))
+	srv.rateLimiter = newRateLimiter(cfg.RateLimitPerMinute, cfg.RateLimitBurst)
+	srv.lifecycle = newLifecycle()
+	srv.warm = newWarmup()
+	policy, err := cfg.WebhookActionPolicy()
+	if err != nil {
+		logger.Error("invalid webhook policy, using default", "err", err)
+		policy = webhook.DefaultPolicy()
+	}
+	srv.webhookPolicy = policy
+
+	sources, err := cfg.WebhookSourceMap()
+	if err != nil {
+		logger.Error("invalid webhook sources, only the default source is enabled", "err", err)
+		sources = map[string]config.WebhookSource{
+			config.DefaultWebhookSource: {Name: config.DefaultWebhookSource, Secret: cfg.WebhookSecret, Provider: webhook.DefaultProvider},
+		}
+	}
+	srv.webhookSources.Store(&sources)
+
+	services, err := cfg.ForwardAuthServiceMap()
+	if err != nil {
+		logger.Error("invalid forward auth services, only the built-in services are enabled", "err", err)
+		services, _ = config.Config{}.ForwardAuthServiceMap()
+	}
+	srv.forwardAuth = srv.forwardServices(services)
+
+	trusted, err := cfg.TrustedIdentitySources()
+	if err != nil {
+		logger.Error("invalid identity sources, trusting the defaults", "err", err)
+		trusted = identity.DefaultSources
+	}
+	srv.identitySources = trusted
+
+	for _, domain := range cfg.AllowedEmailDomains {
+		srv.emailDomains = append(srv.emailDomains, accounts.NormalizeDomain(domain))
+	}
+
+	proxies, err := cfg.TrustedProxyPrefixes()
+	if err != nil {
+		// Failing open would let anyone reaching the listener claim any
+		// identity, so trust no peer (the zero prefix contains no address);
+		// the proxy secret still works.
+		logger.Error("invalid trusted proxies, trusting none", "err", err)
+		proxies = []netip.Prefix{{}}
+	}
+	srv.trustedProxies = proxies
+	if len(proxies) == 0 && cfg.ProxySecret == "" {
+		logger.Warn("AUTH_MANAGER_TRUSTED_PROXIES and AUTH_MANAGER_PROXY_SECRET not set; identity headers are trusted from any peer")
+	}
+	sameSite, err := cfg.SessionCookieSameSite()
+	if err != nil {
+		logger.Error("invalid cookie SameSite, using lax", "err", err)
+		sameSite = http.SameSiteLaxMode
+	}
+	srv.mmOptions = mmbridge.Options{
+		PublicURL:     cfg.MattermostURL,
+		SessionTTL:    cfg.MattermostSessionTTL,
+		XHRSessionTTL: cfg.MattermostSessionXHRTTL,
+		DevicePrefix:  cfg.MattermostSessionDevicePrefix,
+		Cookies:       mmbridge.CookieOptions{Domain: cfg.CookieDomain, SameSite: sameSite},
+	}
+	srv.mattermostOrigin, srv.n8nOrigin = urlOrigin(cfg.MattermostURL), urlOrigin(cfg.N8NURL)
+	if srv.errorPage, err = cfg.ErrorPage(); err != nil {
+		logger.Error("invalid error page template, using the built-in page", "err", err)
+	}
+	if srv.errorPage == nil {
+		srv.errorPage = defaultErrorPage
+	}
+	if srv.corsOrigins, srv.corsAnyOrigin, err = cfg.CORSPolicy(); err != nil {
+		logger.Error("invalid CORS origins, CORS disabled", "err", err)
+		srv.corsOrigins, srv.corsAnyOrigin = nil, false
+	}
+	srv.apiMethods = apiMethods()
+	if cfg.AdminToken == "" && len(cfg.AdminGroups) == 0 {
+		logger.Warn("AUTH_MANAGER_ADMIN_TOKEN and AUTH_MANAGER_ADMIN_GROUPS not set; the management API is unauthenticated")
+	}
+
+	roleMap, err := cfg.RoleMapping()
+	if err != nil {
+		logger.Error("invalid mattermost role map, role sync disabled", "err", err)
+		roleMap = nil
+	}
+	srv.roleMap = roleMap
+
+	teamMap, err := cfg.MattermostTeamMapping()
+	if err != nil {
+		logger.Error("invalid mattermost team map, mapped teams disabled", "err", err)
+		teamMap = nil
+	}
+	srv.teamMap = teamMap
+
+	n8nRoleMap, err := cfg.N8NRoleMapping()
+	if err != nil {
+		logger.Error("invalid n8n role map, role sync disabled", "err", err)
+		n8nRoleMap = nil
+	}
+	srv.n8nRoleMap = n8nRoleMap
+
+	n8nProjectMap, err := cfg.N8NProjectMapping()
+	if err != nil {
+		logger.Error("invalid n8n project map, project membership disabled", "err", err)
+		n8nProjectMap = nil
+	}
+	srv.n8nProjectMap = n8nProjectMap
+	srv.n8nProjects = newN8NProjectCache()
+
+	gitlabGroups, err := cfg.GitLabGroupMapping()
+	if err != nil {
+		logger.Error("invalid gitlab group map, group membership disabled", "err", err)
+		gitlabGroups = nil
+	}
+	srv.gitlabGroups = gitlabGroups
+
+	grafanaRoles, err := cfg.GrafanaRoleMapping()
+	if err != nil {
+		logger.Error("invalid grafana role map, role sync disabled", "err", err)
+		grafanaRoles = nil
 	}
+	srv.grafanaRoles = grafanaRoles
+
+	grafanaTeams, err := cfg.GrafanaTeamMapping()
+	if err != nil {
+		logger.Error("invalid grafana team map, team membership disabled", "err", err)
+		grafanaTeams = nil
+	}
+	srv.grafanaTeams = grafanaTeams
+	srv.grafanaSynced = newGrafanaSyncCache(cfg.SessionCacheTTL)
+
+	httpOpts, err := cfg.HTTPOptions()
+	if err != nil {
+		logger.Error("invalid HTTP client options, using defaults", "err", err)
+		httpOpts = httpx.Options{}
+	}
+	srv.tracer = srv.newTracer(cfg, httpOpts)
+
 	if store == nil {
 		store = srv.newStoreFromConfig()
 	}
+	if srv.tracer != nil {
+		store = shadow.Traced(store)
+	}
+	if attrKeys != nil {
+		if store, err = shadow.Encrypted(store, attrKeys, cfg.SensitiveAttributes); err != nil {
+			return nil, err
+		}
+		logger.Info("shadow attribute encryption enabled", "key_id", attrKeys[0].ID, "attributes", cfg.SensitiveAttributes)
+		if reporter, ok := store.(shadow.UnreadableReporter); ok {
+			reporter.OnUnreadable(srv.unreadableAttribute)
+		}
+	}
 	srv.shadowStore = store
+	if httpOpts.InsecureSkipVerify {
+		logger.Warn("TLS certificate verification disabled for internal API clients")
+	}
 
-	if cfg.MattermostAdminToken != "" {
-		srv.mmClient = mattermost.NewClient(cfg.MattermostInternalURL, cfg.MattermostAdminToken)
+	if cfg.DryRun {
+		logger.Warn("dry-run mode: Mattermost and n8n writes will be logged, not sent")
 	}
+	httpOpts.DryRun = cfg.DryRun
+	srv.clientOpts = httpOpts
 
-	if cfg.N8NEnabled && cfg.N8NOwnerEmail != "" && cfg.N8NOwnerPass != "" {
-		srv.n8nClient = n8n.NewClient(cfg.N8NInternalURL, cfg.N8NOwnerEmail, cfg.N8NOwnerPass)
+	// Dry-run sessions are never real, so there's nothing to cache.
+	if !cfg.DryRun {
+		srv.sessionCache = newSessionCache(cfg.SessionCacheTTL, cfg.SessionCacheSize)
+		srv.n8nSessions = newN8NSessionCache(cfg.SessionCacheTTL, cfg.SessionCacheSize)
+	}
+
+	if cfg.AuthentikURL != "" && cfg.AuthentikToken != "" {
+		srv.authentikClient = authentik.NewClientWithOptions(cfg.AuthentikURL, cfg.AuthentikToken, srv.clientOptions(httpOpts, "authentik"))
+	}
+
+	if cfg.GitLabEnabled && cfg.GitLabToken != "" {
+		srv.gitlabClient = gitlab.NewClientWithOptions(cfg.GitLabInternalURL, cfg.GitLabToken, srv.clientOptions(httpOpts, "gitlab"))
+	}
+
+	if cfg.GrafanaEnabled && cfg.GrafanaAdminPass != "" {
+		srv.grafanaClient = grafana.NewClientWithOptions(cfg.GrafanaInternalURL, cfg.GrafanaAdminUser, cfg.GrafanaAdminPass, cfg.GrafanaOrgID, srv.clientOptions(httpOpts, "grafana"))
 	}
 
 	reg := prometheus.NewRegistry()
@@ -70,37 +350,189 @@ func New(cfg config.Config, store shadow.Store, logger *slog.Logger) *Server {
 	})
 	srv.webhooksReceived = prometheus.NewCounter(prometheus.CounterOpts{
 		Name: "auth_manager_webhooks_received_total",
-		Help: "Number of webhook events received from Authentik",
+		Help: "Number of webhook events received from Authentik (deprecated: sum auth_manager_webhook_events_total instead)",
 	})
-	reg.MustRegister(srv.usersProvisioned, srv.webhooksReceived)
+	srv.webhookEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_manager_webhook_events_total",
+		Help: "Number of webhook events received from Authentik, by action and outcome (provisioned, deprovisioned, revoked, alert_forwarded, ignored, error)",
+	}, []string{"action", "outcome"})
+	reg.MustRegister(srv.webhookEvents)
+	srv.sessionsRevoked = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "auth_manager_mattermost_sessions_revoked_total",
+		Help: "Number of Mattermost sessions revoked after Authentik credential changes",
+	})
+	srv.logouts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_manager_logouts_total",
+		Help: "Number of /auth/logout requests, by what became of the Mattermost session (revoked, revoke_failed, none)",
+	}, []string{"session"})
+	reg.MustRegister(srv.logouts)
+	srv.webhookRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_manager_webhook_rejected_total",
+		Help: "Number of webhook requests rejected during parsing, by error class",
+	}, []string{"class"})
+	srv.webhookWarnings = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_manager_webhook_decode_warnings_total",
+		Help: "Number of mis-shaped webhook payload fields coerced or ignored while decoding, by kind",
+	}, []string{"kind"})
+	reg.MustRegister(srv.webhookWarnings)
+	srv.webhookUnmapped = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "auth_manager_webhook_unmapped_actions_total",
+		Help: "Number of user events ignored because their action has no policy mapping",
+	})
+	srv.alertsForwarded = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "auth_manager_alerts_forwarded_total",
+		Help: "Number of Authentik security events forwarded to the Mattermost alert channel",
+	})
+	srv.alertsDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "auth_manager_alerts_dropped_total",
+		Help: "Number of Authentik security events that could not be forwarded",
+	})
+	srv.usersFiltered = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_manager_users_filtered_total",
+		Help: "Number of users refused by the email domain or group lists, by path (provision, forward_auth) and list (email_domain, group)",
+	}, []string{"path", "list"})
+	reg.MustRegister(srv.usersFiltered)
+	reg.MustRegister(srv.usersProvisioned, srv.webhooksReceived, srv.sessionsRevoked, srv.webhookRejected, srv.webhookUnmapped)
+	srv.joinFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_manager_mattermost_join_failures_total",
+		Help: "Number of failed default team/channel joins for new Mattermost users",
+	}, []string{"kind"})
+	srv.teamSyncChanges = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_manager_mattermost_team_sync_total",
+		Help: "Mattermost team memberships changed by group sync, by action (list, add, remove) and result (ok, failed)",
+	}, []string{"action", "result"})
+	srv.shadowRemapped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_manager_shadow_remapped_total",
+		Help: "Shadow users moved to a new subject by email, by trigger (upsert, admin)",
+	}, []string{"trigger"})
+	reg.MustRegister(srv.shadowRemapped)
+	srv.shadowUnreadable = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_manager_shadow_unreadable_attributes_total",
+		Help: "Encrypted shadow attribute values left out of reads because they won't decrypt, by reason (unknown_key, undecryptable)",
+	}, []string{"reason"})
+	reg.MustRegister(srv.shadowUnreadable)
+	srv.mmRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_manager_mattermost_retries_total",
+		Help: "Number of retried Mattermost API requests, by method and reason",
+	}, []string{"method", "reason"})
+	srv.sessionLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_manager_session_cache_requests_total",
+		Help: "Forward-auth Mattermost session lookups by result (hit, miss, shared)",
+	}, []string{"result"})
+	srv.downstreamCalls = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_manager_downstream_requests_total",
+		Help: "Requests to Mattermost, n8n, GitLab, and Grafana by method and mode (real, or simulated in dry-run mode)",
+	}, []string{"service", "method", "mode"})
+	reg.MustRegister(srv.alertsForwarded, srv.alertsDropped, srv.joinFailures, srv.teamSyncChanges, srv.mmRetries, srv.sessionLookups, srv.downstreamCalls)
+	srv.provisionLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "auth_manager_provision_duration_seconds",
+		Help:    "End-to-end duration of provisioning a user into the shadow store and downstream services",
+		Buckets: latencyBuckets,
+	}, []string{"outcome"})
+	reg.MustRegister(srv.provisionLatency)
+	srv.grafanaFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_manager_grafana_sync_failures_total",
+		Help: "Number of failed Grafana user, role, and team syncs, by step",
+	}, []string{"step"})
+	reg.MustRegister(srv.grafanaFailures)
+	srv.rateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_manager_rate_limited_requests_total",
+		Help: "Number of requests rejected with 429 by the per-caller rate limit, by endpoint",
+	}, []string{"endpoint"})
+	reg.MustRegister(srv.rateLimited)
+	srv.idempotency = newIdempotencyCache(cfg.IdempotencyTTL, cfg.IdempotencyMaxKeys, reg)
+	srv.httpMetrics = newHTTPMetrics(reg)
+	registerBuildInfo(reg)
+	srv.mmLatency = newLatencyObserver(reg, "auth_manager_mattermost_request_duration_seconds", "Mattermost API call latency by operation and outcome, including retries")
+	srv.n8nLatency = newLatencyObserver(reg, "auth_manager_n8n_request_duration_seconds", "n8n API call latency by operation and outcome")
+	if mm := srv.newMattermostClient(cfg); mm != nil {
+		srv.mmClient.Store(mm)
+	}
+	if cfg.N8NEnabled {
+		if client := srv.newN8NClient(cfg); client != nil {
+			srv.n8nClient.Store(client)
+		}
+	}
+	if srv.gitlabClient != nil {
+		srv.gitlabClient.SetMetrics(newLatencyObserver(reg, "auth_manager_gitlab_request_duration_seconds", "GitLab API call latency by operation and outcome"))
+	}
+	if srv.grafanaClient != nil {
+		srv.grafanaClient.SetMetrics(newLatencyObserver(reg, "auth_manager_grafana_request_duration_seconds", "Grafana API call latency by operation and outcome"))
+	}
+	srv.provisioners = srv.newProvisionRunner(cfg, reg)
+	srv.reconcileState = newReconcileState(reg)
+	srv.opsAlerts = newOpsAlerter(reg)
+	srv.userHistory = newUserHistory()
+	srv.maintenance = newMaintenance(reg)
+	srv.outbound = srv.newOutboundDispatcher(cfg, httpOpts, reg)
+	srv.eventConsumer = srv.newEventConsumer(cfg, reg)
+	instrumentBreakers(reg, srv.breakers(), srv.breakerChanged)
 
 	mux := http.NewServeMux()
-	mux.HandleFunc("/healthz", srv.handleHealth)
-	mux.HandleFunc("/readyz", srv.handleReady)
-	mux.HandleFunc("/api/v1/shadow-users", srv.handleShadowUsers)
-	mux.HandleFunc("/webhook/authentik", srv.handleAuthentikWebhook)
-	mux.HandleFunc("/api/v1/sync", srv.handleManualSync)
-	mux.HandleFunc("/auth/mattermost", srv.handleMattermostForwardAuth)
-	mux.HandleFunc("/auth/n8n", srv.handleN8NForwardAuth)
-	mux.Handle("/metrics", promhttp.HandlerFor(srv.metricsRegistry, promhttp.HandlerOpts{}))
+	for _, rt := range srv.routes() {
+		mux.Handle(rt.pattern, limitBody(srv.bodyLimit(rt.pattern), srv.withTimeout(srv.requestBudget(rt.pattern), rt.handler)))
+	}
+
+	srv.liveCfg = cfg
+	srv.loadConfig = config.FromEnv
 
 	srv.httpServer = &http.Server{
 		Addr:         cfg.ListenAddr,
-		Handler:      srv.logRequest(mux),
+		Handler:      srv.withRequestID(srv.trace(mux, srv.logRequest(srv.instrument(mux, srv.compress(srv.cors(srv.trustProxies(srv.requireAdmin(srv.redirectToSlash(mux))))))))),
 		ReadTimeout:  15 * time.Second,
 		WriteTimeout: 15 * time.Second,
 		IdleTimeout:  60 * time.Second,
 	}
+	srv.debugServer = srv.newDebugServer()
 
-	return srv
+	return srv, nil
+}
+
+// route is a mux pattern and its handler.
+type route struct {
+	pattern string
+	handler http.Handler
+}
+
+// routes lists every endpoint. openapi.go documents each of them, as
+// TestOpenAPICoversRoutes checks.
+func (s *Server) routes() []route {
+	return []route{
+		{"/healthz", http.HandlerFunc(s.handleHealth)},
+		{"/readyz", http.HandlerFunc(s.handleReady)},
+		{"/openapi.json", http.HandlerFunc(s.handleOpenAPI)},
+		{"/docs", http.HandlerFunc(s.handleDocs)},
+		{"/api/v1/shadow-users", http.HandlerFunc(s.handleShadowUsers)},
+		{"/api/v1/stats", http.HandlerFunc(s.handleStats)},
+		{"/api/v1/users/", http.HandlerFunc(s.handleUserStatus)},
+		{"/api/v1/version", http.HandlerFunc(s.handleVersion)},
+		{"/webhook/authentik", http.HandlerFunc(s.handleAuthentikWebhook)},
+		{"/webhook/authentik/", http.HandlerFunc(s.handleAuthentikWebhook)},
+		{"/api/v1/sync", s.idempotent("sync", s.rateLimit("sync", s.handleManualSync))},
+		{"/api/v1/deprovision", s.idempotent("deprovision", s.handleManualDeprovision)},
+		{"/api/v1/mattermost/sessions/cleanup", s.idempotent("session_cleanup", s.handleSessionCleanup)},
+		{"/api/v1/mattermost/teams/reconcile", s.idempotent("team_reconcile", s.handleTeamReconcile)},
+		{"/api/v1/reconcile", s.idempotent("reconcile", s.handleReconcile)},
+		{"/api/v1/reconcile/status", http.HandlerFunc(s.handleReconcileStatus)},
+		{"/api/v1/admin/reload", http.HandlerFunc(s.handleReload)},
+		{"/api/v1/admin/maintenance", http.HandlerFunc(s.handleMaintenance)},
+		{"/api/v1/admin/remap", http.HandlerFunc(s.handleRemap)},
+		{"/api/v1/outbound-webhooks/status", http.HandlerFunc(s.handleOutboundWebhookStatus)},
+		{mattermostCommandPath, http.HandlerFunc(s.handleMattermostCommand)},
+		{"/auth/logout", http.HandlerFunc(s.handleLogout)},
+		{"/auth/", http.HandlerFunc(s.handleForwardAuth)},
+		{"/metrics", promhttp.HandlerFor(s.metricsRegistry, promhttp.HandlerOpts{})},
+	}
 }
 
 // Start begins serving HTTP requests.
 func (s *Server) Start() error {
-	s.logger.Info("auth-manager listening", "addr", s.cfg.ListenAddr, "mattermost", s.cfg.MattermostURL)
+	s.logger.Info("auth-manager listening", "addr", s.cfg.ListenAddr, "mattermost", s.cfg.MattermostURL, "webhook_policy", s.webhookPolicy.String())
 	if err := s.cfg.Validate(); err != nil {
 		return err
 	}
+	s.startBackground()
+	s.startDebugServer()
 	err := s.httpServer.ListenAndServe()
 	if errors.Is(err, http.ErrServerClosed) {
 		return nil
@@ -108,115 +540,446 @@ func (s *Server) Start() error {
 	return err
 }
 
-// Shutdown gracefully stops the HTTP listener.
+// startBackground launches background workers configured for this server.
+func (s *Server) startBackground() {
+	s.startWarmup(s.cfg.WarmupTimeout)
+	if s.cfg.ReconcileInterval > 0 {
+		if s.authentikClient == nil {
+			s.logger.Warn("reconcile interval set but Authentik API not configured; background reconciler disabled")
+		} else {
+			_ = s.lifecycle.goWorker("reconciler", func(ctx context.Context) {
+				s.runReconciler(ctx, s.cfg.ReconcileInterval)
+			})
+		}
+	}
+	if s.eventConsumer != nil {
+		_ = s.lifecycle.goWorker("event-stream", s.eventConsumer.Run)
+	}
+	// Merged first, so re-encryption sees each user once.
+	_ = s.lifecycle.goWorker("shadow-maintenance", func(ctx context.Context) {
+		s.mergeEmailDuplicates(ctx)
+		if rekeyer, ok := s.shadowStore.(shadow.Rekeyer); ok {
+			s.rekeyAttributes(ctx, rekeyer)
+		}
+	})
+}
+
+// Shutdown stops the server in order: it stops accepting HTTP requests and
+// waits for in-flight ones, cancels the background workers, the event
+// stream consumer among them, and waits for them, then closes the shadow
+// store and delivers queued outbound webhooks and traces. Each step is
+// bounded by ctx; the returned error describes every step that didn't
+// finish cleanly.
 func (s *Server) Shutdown(ctx context.Context) error {
+	var errs []error
 	if err := s.httpServer.Shutdown(ctx); err != nil {
-		return err
+		errs = append(errs, fmt.Errorf("http server: %w", err))
+	}
+	if s.debugServer != nil {
+		if err := s.debugServer.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("debug server: %w", err))
+		}
+	}
+	if err := s.lifecycle.stop(ctx); err != nil {
+		errs = append(errs, err)
 	}
 	if s.shadowStore != nil {
-		return s.shadowStore.Close(ctx)
+		if err := s.shadowStore.Close(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("shadow store: %w", err))
+		}
 	}
-	return nil
+	if err := s.outbound.Shutdown(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("outbound webhooks: %w", err))
+	}
+	if err := s.tracer.Shutdown(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("tracer: %w", err))
+	}
+	return errors.Join(errs...)
 }
 
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	s.respondJSON(w, http.StatusOK, map[string]any{
 		"status":       "ok",
-		"mattermost":   s.cfg.MattermostURL,
+		"mattermost":   s.cfg.Redacted().MattermostURL,
 		"current_time": time.Now().UTC().Format(time.RFC3339Nano),
+		"breakers":     s.breakerStates(),
+		"warmup":       s.warm.status(),
+		"maintenance":  s.maintenance.status(),
+		"build":        version.Get(),
 	})
 }
 
+// handleReady fails during warm-up and when the shadow store is unhealthy.
+// Mattermost problems only mark the service degraded unless
+// ReadyRequireMattermost is set, so a Mattermost outage doesn't take the
+// webhook receiver out of rotation.
 func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	if warming, _ := s.warm.warming(); warming {
+		s.respondJSON(w, http.StatusServiceUnavailable, map[string]any{"status": "warming_up", "warmup": s.warm.status()})
+		return
+	}
 	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
 	defer cancel()
 	if s.shadowStore != nil {
 		if err := s.shadowStore.HealthCheck(ctx); err != nil {
-			s.respondError(w, http.StatusServiceUnavailable, err)
+			s.respondError(w, r, storeUnavailable(err))
 			return
 		}
 	}
-	s.respondJSON(w, http.StatusOK, map[string]any{"status": "ready"})
+
+	payload := map[string]any{"status": "ready"}
+	for _, target := range s.provisioners.Targets() {
+		check := map[string]string{"status": "ok"}
+		if err := target.Healthy(ctx); err != nil {
+			check["status"] = "error"
+			check["error"] = errorText(err)
+			if unauthorizedError(err) {
+				check["status"] = "unauthorized"
+			}
+			payload["status"] = "degraded"
+		}
+		payload[target.Name()] = check
+
+		if target.Name() == "mattermost" && check["status"] != "ok" && s.cfg.ReadyRequireMattermost {
+			payload["status"] = "not_ready"
+			s.respondJSON(w, http.StatusServiceUnavailable, payload)
+			return
+		}
+	}
+	s.respondJSON(w, http.StatusOK, payload)
 }
 
 func (s *Server) handleShadowUsers(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
+		includeSensitive, err := s.includeSensitive(r)
+		if err != nil {
+			s.respondError(w, r, err)
+			return
+		}
 		users, err := s.shadowStore.List(r.Context())
 		if err != nil {
-			s.respondError(w, http.StatusInternalServerError, err)
+			s.respondError(w, r, storeUnavailable(err))
 			return
 		}
+		if !includeSensitive {
+			for _, user := range users {
+				s.redactSensitive(user.Attributes)
+			}
+		}
 		s.respondJSON(w, http.StatusOK, map[string]any{"shadow_users": users})
 	default:
 		w.Header().Set("Allow", "GET")
-		s.respondJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		s.respondError(w, r, apperror.MethodNotAllowed)
 	}
 }
 
+// handleStats reports counts from the shadow store.
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		s.respondError(w, r, apperror.MethodNotAllowed)
+		return
+	}
+	users, err := s.shadowStore.List(r.Context())
+	if err != nil {
+		s.respondError(w, r, storeUnavailable(err))
+		return
+	}
+	pending := 0
+	for _, user := range users {
+		if user.Attributes[attrN8NPending] == "true" {
+			pending++
+		}
+	}
+	s.respondJSON(w, http.StatusOK, map[string]int{
+		"shadow_users":      len(users),
+		"n8n_pending_users": pending,
+	})
+}
+
 // handleAuthentikWebhook receives webhook notifications from Authentik.
 // Authentik sends these when users are created, updated, or deleted.
+// /webhook/authentik serves the default source; /webhook/authentik/{source}
+// serves additional Authentik instances configured with their own secrets.
 func (s *Server) handleAuthentikWebhook(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		w.Header().Set("Allow", "POST")
-		s.respondJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		s.respondError(w, r, apperror.MethodNotAllowed)
+		return
+	}
+
+	sourceName := strings.Trim(strings.TrimPrefix(r.URL.Path, "/webhook/authentik"), "/")
+	if sourceName == "" {
+		sourceName = config.DefaultWebhookSource
+	}
+	source, ok := (*s.webhookSources.Load())[sourceName]
+	if !ok {
+		s.respondError(w, r, apperror.New(http.StatusNotFound, apperror.CodeUnknownWebhookSource, fmt.Sprintf("unknown webhook source %q", sourceName)))
 		return
 	}
 
-	event, err := webhook.ParseRequest(r, s.cfg.WebhookSecret)
+	event, err := webhook.ParseRequest(r, source.Secret)
 	if err != nil {
-		s.logger.Warn("webhook parse failed", "err", err)
-		s.respondError(w, http.StatusUnauthorized, err)
+		class := webhook.ErrorClass(err)
+		s.webhookRejected.WithLabelValues(class).Inc()
+		s.logger.WarnContext(r.Context(), "webhook parse failed", "source", source.Name, "class", class, "err", err)
+		s.respondError(w, r, webhookError(err))
 		return
 	}
+	s.logDecodeWarnings(r.Context(), source.Name, event)
 
-	s.webhooksReceived.Inc()
-	s.logger.Info("webhook received",
+	s.logger.InfoContext(r.Context(), "webhook received",
+		"source", source.Name,
 		"action", event.Action(),
 		"is_user_event", event.IsUserEvent(),
 		"severity", event.Severity,
 	)
-
-	// Only process user-related events
-	if !event.IsUserEvent() {
-		s.respondJSON(w, http.StatusOK, map[string]string{"status": "ignored", "reason": "not a user event"})
+	res := s.processEvent(r.Context(), event, source)
+	s.webhooksReceived.Inc()
+	s.webhookEvents.WithLabelValues(webhookActionLabel(event.Action()), res.outcome).Inc()
+	if res.body == nil {
+		s.respondError(w, r, res.err)
 		return
 	}
+	s.respondJSON(w, res.status, res.body)
+}
+
+// logDecodeWarnings logs and counts what decoding event had to coerce, so a
+// misconfigured property mapping shows up before it drops events.
+func (s *Server) logDecodeWarnings(ctx context.Context, source string, event *webhook.AuthentikEvent) {
+	for _, w := range event.Warnings {
+		s.webhookWarnings.WithLabelValues(w.Kind).Inc()
+		s.logger.WarnContext(ctx, "webhook payload coerced", "source", source, "field", w.Field, "kind", w.Kind, "detail", w.Message)
+	}
+}
+
+// eventResult is what became of an Authentik event: its outcome, the
+// outcome label of auth_manager_webhook_events_total, and the response for
+// a webhook sender. err is set when the outcome is "error"; without a body
+// the sender gets err as a problem.
+type eventResult struct {
+	outcome string
+	status  int
+	body    any
+	err     error
+}
+
+func ignoredEvent(reason string) eventResult {
+	return eventResult{outcome: "ignored", status: http.StatusOK, body: map[string]string{"status": "ignored", "reason": reason}}
+}
+
+// processEvent applies an Authentik event from source, whether it came by
+// webhook or from the event stream: stale sessions are revoked after a
+// credential change, user events provision or deprovision the user as the
+// webhook policy says, and other events may be forwarded as alerts.
+func (s *Server) processEvent(ctx context.Context, event *webhook.AuthentikEvent, source config.WebhookSource) eventResult {
+	// Password and MFA changes invalidate any Mattermost sessions we issued
+	if event.IsCredentialEvent() {
+		return s.handleCredentialEvent(ctx, event, source)
+	}
+
+	// Only process user-related events. In minimal mode any native payload
+	// naming a user is treated as a request to sync that user.
+	minimal := s.cfg.WebhookMinimalMode && event.IsNative()
+	if !event.IsUserEvent() && !minimal {
+		if s.shouldForwardAlert(event) {
+			s.forwardAlert(event)
+			return eventResult{outcome: "alert_forwarded", status: http.StatusOK, body: map[string]string{"status": "alert_forwarded"}}
+		}
+		return ignoredEvent("not a user event")
+	}
 
 	userInfo := event.ExtractUser()
+	userInfo.Provider = source.Provider
+	if event.IsNative() {
+		s.enrichFromAuthentik(ctx, userInfo)
+	}
 	if userInfo.Email == "" {
-		s.respondJSON(w, http.StatusOK, map[string]string{"status": "ignored", "reason": "no email in event"})
-		return
+		return ignoredEvent("no email in event")
 	}
 
-	// Process based on action
-	switch event.Action() {
-	case webhook.ActionModelCreated, webhook.ActionModelUpdated, webhook.ActionUserWrite, webhook.ActionLogin:
-		if err := s.provisionUser(r.Context(), userInfo); err != nil {
-			s.logger.Error("provision failed", "email", userInfo.Email, "err", err)
-			s.respondError(w, http.StatusInternalServerError, err)
-			return
+	// Process based on the configured action policy
+	behavior, mapped := s.webhookPolicy.Behavior(event.Action())
+	if minimal && event.Action() == "" {
+		behavior, mapped = webhook.BehaviorProvision, true
+	}
+	if !mapped {
+		s.webhookUnmapped.Inc()
+	}
+	if userInfo.FromRecipient && behavior != webhook.BehaviorProvision && behavior != webhook.BehaviorIgnore {
+		s.logger.WarnContext(ctx, "refusing to apply an event to its notification recipient", "action", event.Action(), "behavior", behavior, "email", userInfo.Email)
+		return ignoredEvent("event names no user but the notification recipient")
+	}
+	if behavior == webhook.BehaviorProvision || (behavior == webhook.BehaviorDeprovision && s.cfg.DeprovisionEnabled) {
+		// Queued whole, deprovisions included, so they're applied in order.
+		if res, deferred := s.deferEvent(ctx, event, source, userInfo.Email); deferred {
+			return res
 		}
-		s.respondJSON(w, http.StatusOK, map[string]any{
-			"status": "provisioned",
-			"email":  userInfo.Email,
-		})
-	case webhook.ActionModelDeleted:
-		// For now, just log deletion - don't deprovision
-		s.logger.Info("user deleted in authentik", "email", userInfo.Email)
-		s.respondJSON(w, http.StatusOK, map[string]any{
-			"status": "noted",
-			"action": "deleted",
-			"email":  userInfo.Email,
-		})
+	}
+	switch behavior {
+	case webhook.BehaviorProvision:
+		result, err := s.provisionUser(ctx, userInfo)
+		res := eventResult{outcome: "provisioned", err: err}
+		res.status, res.body = provisionPayload(userInfo.Email, result, err)
+		switch {
+		case err != nil:
+			s.logger.ErrorContext(ctx, "provision failed", "email", userInfo.Email, "err", err)
+			res.outcome = "error"
+		case result.Filtered != "":
+			res.outcome = "ignored"
+		case result.Deferred != "":
+			res.outcome = "deferred"
+		}
+		return res
+	case webhook.BehaviorDeprovision:
+		if !s.cfg.DeprovisionEnabled {
+			// Without opt-in, just log deprovision requests - don't touch downstream accounts
+			s.logger.InfoContext(ctx, "user deprovision requested by authentik", "action", event.Action(), "email", userInfo.Email)
+			return eventResult{outcome: "ignored", status: http.StatusOK, body: map[string]any{
+				"status": "noted",
+				"action": event.Action(),
+				"email":  userInfo.Email,
+			}}
+		}
+		results, err := s.deprovisionUser(ctx, userInfo)
+		if err != nil {
+			s.logger.ErrorContext(ctx, "deprovision failed", "email", userInfo.Email, "err", err)
+			return eventResult{outcome: "error", status: http.StatusInternalServerError, body: deprovisionErrorBody(results, err), err: err}
+		}
+		return eventResult{outcome: "deprovisioned", status: http.StatusOK, body: map[string]any{
+			"status":  "deprovisioned",
+			"action":  event.Action(),
+			"email":   userInfo.Email,
+			"targets": results,
+		}}
 	default:
-		s.respondJSON(w, http.StatusOK, map[string]string{"status": "ignored", "reason": "unhandled action"})
+		reason := "unhandled action"
+		if mapped {
+			reason = "ignored by policy"
+		}
+		return ignoredEvent(reason)
+	}
+}
+
+// webhookActionLabel bounds the action label of
+// auth_manager_webhook_events_total to the actions Authentik is known to
+// send, since anyone with the webhook secret chooses the action.
+func webhookActionLabel(action string) string {
+	switch action {
+	case webhook.ActionModelCreated, webhook.ActionModelUpdated, webhook.ActionModelDeleted,
+		webhook.ActionLogin, webhook.ActionLogout, webhook.ActionUserWrite, webhook.ActionPasswordSet:
+		return action
+	}
+	return "other"
+}
+
+// webhookError maps a webhook.ParseRequest error to what the sender is
+// told. Malformed payloads say what was wrong with them; signature failures
+// don't say more than that.
+func webhookError(err error) *apperror.Error {
+	switch {
+	case errors.Is(err, webhook.ErrPayloadTooLarge):
+		return apperror.Wrap(err, http.StatusRequestEntityTooLarge, apperror.CodeRequestTooLarge,
+			fmt.Sprintf("webhook payload exceeds %d bytes", webhook.MaxPayloadBytes))
+	case errors.Is(err, webhook.ErrMalformedPayload):
+		return apperror.New(http.StatusBadRequest, apperror.CodeMalformedWebhook, err.Error())
+	case errors.Is(err, webhook.ErrMissingAuth):
+		return apperror.Wrap(err, http.StatusUnauthorized, apperror.CodeMissingWebhookAuth, "missing webhook signature or bearer token")
+	default:
+		return apperror.Wrap(err, http.StatusUnauthorized, apperror.CodeInvalidWebhookSignature, "invalid webhook signature")
+	}
+}
+
+// handleCredentialEvent revokes all Mattermost sessions for the user named in a
+// password-change or MFA event so stale sessions can't outlive a credential reset.
+func (s *Server) handleCredentialEvent(ctx context.Context, event *webhook.AuthentikEvent, source config.WebhookSource) eventResult {
+	if !s.cfg.RevokeSessionsOnCredentialChange {
+		return ignoredEvent("session revocation disabled")
+	}
+	if s.mattermost() == nil {
+		return ignoredEvent("mattermost not configured")
+	}
+
+	userInfo := event.ExtractUser()
+	userInfo.Provider = source.Provider
+	if userInfo.Email == "" && userInfo.Subject == "" {
+		return ignoredEvent("no user in event")
+	}
+	if userInfo.FromRecipient {
+		s.logger.WarnContext(ctx, "credential event names no user but the notification recipient; sessions left alone", "action", event.Action(), "email", userInfo.Email)
+		return ignoredEvent("event names no user but the notification recipient")
+	}
+
+	if !s.mmBreakers.Get(mmOpRevoke).Allow() {
+		s.logger.WarnContext(ctx, "mattermost circuit open, cannot revoke sessions", "email", userInfo.Email)
+		return eventResult{outcome: "error", err: apperror.MattermostUnavailable}
+	}
+
+	userID, err := s.mattermostUserID(ctx, userInfo)
+	if errors.Is(err, mattermost.ErrNotFound) {
+		return ignoredEvent("no mattermost user on record")
+	}
+	if err != nil {
+		s.recordMattermostFailure(mmOpRevoke, err)
+		return eventResult{outcome: "error", err: mattermostError(err, "mattermost user lookup failed")}
+	}
+
+	s.sessionCache.invalidate(userInfo.Email)
+	s.n8nSessions.invalidate(userInfo.Email)
+	revoked, err := s.mattermost().RevokeAllSessions(ctx, userID)
+	s.sessionsRevoked.Add(float64(revoked))
+	if err != nil {
+		s.recordMattermostFailure(mmOpRevoke, err)
+		s.logger.ErrorContext(ctx, "mattermost session revocation failed",
+			"email", userInfo.Email,
+			"mattermost_user_id", userID,
+			"revoked", revoked,
+			"err", err,
+		)
+		return eventResult{outcome: "error", err: mattermostError(err, "mattermost session revocation failed")}
+	}
+	s.recordMattermostSuccess(mmOpRevoke)
+
+	s.logger.InfoContext(ctx, "mattermost sessions revoked after credential change",
+		"action", event.Action(),
+		"email", userInfo.Email,
+		"mattermost_user_id", userID,
+		"revoked", revoked,
+	)
+	return eventResult{outcome: "revoked", status: http.StatusOK, body: map[string]any{
+		"status":  "revoked",
+		"email":   userInfo.Email,
+		"revoked": revoked,
+	}}
+}
+
+// mattermostUserID resolves the Mattermost user ID for an identity, preferring
+// the mattermost_user_id attribute recorded in the shadow store at provisioning
+// time and falling back to an email lookup against Mattermost.
+func (s *Server) mattermostUserID(ctx context.Context, info *webhook.UserInfo) (string, error) {
+	shadowUser, err := s.shadowStore.Get(ctx, info.ShadowProvider(), info.ShadowSubject())
+	if err == nil {
+		if id := shadowUser.Attributes["mattermost_user_id"]; id != "" {
+			return id, nil
+		}
+	} else if !errors.Is(err, shadow.ErrNotFound) {
+		return "", fmt.Errorf("shadow store get: %w", err)
 	}
+
+	if info.Email == "" {
+		return "", mattermost.ErrNotFound
+	}
+	mmUser, err := s.mattermost().GetUserByEmail(ctx, info.Email)
+	if err != nil {
+		return "", err
+	}
+	return mmUser.ID, nil
 }
 
-// handleMattermostForwardAuth is called by Traefik's ForwardAuth middleware.
-// It reads Authentik identity headers (set by Authentik's proxy outpost forward-auth),
-// ensures the user exists in Mattermost, creates a session, and returns Set-Cookie headers.
+// mattermostForwardAuth is the /auth/mattermost provisioning hook, run by
+// Traefik's ForwardAuth middleware. It ensures the user exists in Mattermost,
+// creates a session, and returns Set-Cookie headers.
 //
 // Flow:
 // 1. User visits /mattermost
@@ -225,141 +988,93 @@ func (s *Server) handleAuthentikWebhook(w http.ResponseWriter, r *http.Request)
 // 4. After login, Authentik sets X-Authentik-* headers
 // 5. Traefik then calls this endpoint with those headers
 // 6. We create Mattermost session and return cookies via addAuthCookiesToResponse
-func (s *Server) handleMattermostForwardAuth(w http.ResponseWriter, r *http.Request) {
-	// Extract user identity from Authentik headers (set by Authentik proxy outpost)
-	email := headerFirst(r,
-		"X-Authentik-Email",
-		"X-Auth-Request-Email",
-		"X-Forwarded-Email",
-	)
-	username := headerFirst(r,
-		"X-Authentik-Username",
-		"X-Auth-Request-User",
-		"X-Forwarded-User",
-		"Remote-User",
-	)
-	name := headerFirst(r,
-		"X-Authentik-Name",
-		"X-Auth-Request-Name",
-		"X-Auth-Request-User",
-		"X-Forwarded-User",
-	)
-	isXHR := strings.EqualFold(r.Header.Get("X-Requested-With"), "XMLHttpRequest") ||
-		strings.Contains(strings.ToLower(r.Header.Get("Accept")), "json")
-
-	// Log all X-Authentik headers for debugging
-	for key, values := range r.Header {
-		lowerKey := strings.ToLower(key)
-		if strings.HasPrefix(lowerKey, "x-authentik") || strings.HasPrefix(lowerKey, "x-auth-request") {
-			s.logger.Debug("authentik header", "key", key, "values", values)
-		}
-	}
-
-	// If no Authentik headers, check if user already has Mattermost cookies
-	if email == "" {
-		// Check for existing Mattermost session
-		if _, err := r.Cookie("MMAUTHTOKEN"); err == nil {
-			// User already has a Mattermost session, allow through
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-		// No Authentik identity and no Mattermost session - deny
-		s.logger.Debug("no authentik identity headers found", "path", r.URL.Path)
-		http.Error(w, "Unauthorized - no Authentik session", http.StatusUnauthorized)
-		return
-	}
-
-	s.logger.Info("forward auth request",
-		"email", email,
-		"username", username,
-		"name", name,
-		"path", r.Header.Get("X-Forwarded-Uri"),
-	)
+func (s *Server) mattermostForwardAuth(w http.ResponseWriter, r *http.Request, ident forwardIdentity) bool {
+	email := ident.Email
+	isXHR := wantsJSON(r)
 
-	if s.mmClient == nil {
+	if s.mattermost() == nil {
 		w.Header().Set("X-Rave-Auth-Error", "mattermost-client-misconfigured")
-		s.logger.Error("mattermost client not configured")
-		http.Error(w, "Mattermost not configured", http.StatusServiceUnavailable)
-		return
+		s.logger.ErrorContext(r.Context(), "mattermost client not configured")
+		s.authError(w, r, "Mattermost not configured", http.StatusServiceUnavailable)
+		return false
 	}
-
-	// Check circuit breaker
-	if s.mmBreaker != nil && !s.mmBreaker.allow() {
-		w.Header().Set("X-Rave-Auth-Error", "mattermost-circuit-open")
-		if retry := int(s.mmBreaker.remaining().Seconds()); retry > 0 {
-			w.Header().Set("Retry-After", strconv.Itoa(retry))
-		}
-		s.logger.Warn("mattermost circuit open", "email", email)
-		http.Error(w, "Mattermost temporarily unavailable", http.StatusServiceUnavailable)
-		return
+	if reason := s.crossSiteReason(r, s.mattermostOrigin); reason != "" {
+		s.logger.WarnContext(r.Context(), "refusing to set mattermost cookies for a cross-site request", "email", email, "header", reason,
+			"origin", r.Header.Get("Origin"), "referer", r.Header.Get("Referer"))
+		w.Header().Set("X-Rave-Auth-Error", "cross-site-request")
+		s.authError(w, r, "Cross-site request refused", http.StatusForbidden)
+		return false
 	}
 
 	ctx := r.Context()
-
-	// Ensure user exists in Mattermost
-	mmUser, err := s.mmClient.EnsureUser(ctx, mattermost.Identity{
-		Email: email,
-		Name:  name,
-		User:  username,
-	})
-	if err != nil {
-		s.recordMattermostFailure(err)
-		s.logger.Error("failed to ensure mattermost user", "email", email, "err", err)
-		w.Header().Set("X-Rave-Auth-Error", "mattermost-provision-failed")
-		http.Error(w, "Failed to provision user", http.StatusInternalServerError)
-		return
+	key := sessionCacheKey(email, isXHR)
+	cached, hit := s.sessionCache.get(key)
+	if hit {
+		s.sessionLookups.WithLabelValues("hit").Inc()
+	} else {
+		var shared bool
+		var err error
+		cached, shared, err = s.sessionCache.do(ctx, key, func(ctx context.Context) (cachedSession, error) {
+			return s.createMattermostSession(ctx, ident.CanonicalIdentity, isXHR)
+		})
+		if shared {
+			s.sessionLookups.WithLabelValues("shared").Inc()
+		} else {
+			s.sessionLookups.WithLabelValues("miss").Inc()
+		}
+		if err != nil {
+			s.sessionCache.invalidate(email)
+			s.recordUserHistory(ctx, email, historySignInFailed, err)
+			if s.rejectedAdminToken(w, r, err, "email", email) {
+				return false
+			}
+			stage := mmbridge.ErrorStage(err)
+			if stage != "" && errors.Is(err, provision.ErrCircuitOpen) {
+				operation := stageOperation(stage)
+				w.Header().Set("X-Rave-Auth-Error", "mattermost-circuit-open")
+				if retry := int(s.mmBreakers.Get(operation).Remaining().Seconds()); retry > 0 {
+					w.Header().Set("Retry-After", strconv.Itoa(retry))
+				}
+				s.logger.WarnContext(ctx, "mattermost circuit open", "email", email, "operation", operation)
+				s.authError(w, r, "Mattermost temporarily unavailable", http.StatusServiceUnavailable)
+				return false
+			}
+			if stage == mmbridge.StageProvision {
+				s.logger.ErrorContext(ctx, "failed to ensure mattermost user", "email", email, "err", err)
+				w.Header().Set("X-Rave-Auth-Error", "mattermost-provision-failed")
+				s.authError(w, r, "Failed to provision user", http.StatusInternalServerError)
+				return false
+			}
+			s.logger.ErrorContext(ctx, "failed to create mattermost session", "email", email, "err", err)
+			w.Header().Set("X-Rave-Auth-Error", "mattermost-session-failed")
+			s.authError(w, r, "Failed to create session", http.StatusInternalServerError)
+			return false
+		}
 	}
-	s.recordMattermostSuccess()
 
-	// Create Mattermost session
-	session, err := s.mmClient.CreateSession(ctx, mmUser.ID)
-	if err != nil {
-		s.recordMattermostFailure(err)
-		s.logger.Error("failed to create mattermost session", "email", email, "user_id", mmUser.ID, "err", err)
-		w.Header().Set("X-Rave-Auth-Error", "mattermost-session-failed")
-		http.Error(w, "Failed to create session", http.StatusInternalServerError)
-		return
+	if s.cfg.DryRun {
+		w.Header().Set(dryRunHeader, "no-session-issued")
+		return true
 	}
-	s.recordMattermostSuccess()
-
-	s.logger.Info("mattermost session created",
-		"email", email,
-		"mattermost_user_id", mmUser.ID,
-		"session_id", session.ID,
-	)
 
 	// Set Mattermost session cookies
 	// These cookies will be passed through by Traefik to the client
-	http.SetCookie(w, &http.Cookie{
-		Name:     "MMAUTHTOKEN",
-		Value:    session.Token,
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   true,
-		SameSite: http.SameSiteLaxMode,
-	})
-	http.SetCookie(w, &http.Cookie{
-		Name:     "MMUSERID",
-		Value:    mmUser.ID,
-		Path:     "/",
-		HttpOnly: false, // Mattermost client JS needs this
-		Secure:   true,
-		SameSite: http.SameSiteLaxMode,
-	})
+	public := s.forwardedURL(r, s.cfg.MattermostURL)
+	for _, cookie := range s.mattermostBridge(nil).Cookies(cached.Session, public.url()) {
+		http.SetCookie(w, cookie)
+	}
 
 	if isXHR {
-		bearer := "Bearer " + session.Token
+		bearer := "Bearer " + cached.Session.Token
 		w.Header().Set("Authorization", bearer)
-		w.Header().Set("X-MMAUTHTOKEN", session.Token)
+		w.Header().Set("X-MMAUTHTOKEN", cached.Session.Token)
 	}
-
-	// Return 200 to allow the request through
-	w.WriteHeader(http.StatusOK)
+	return true
 }
 
-// handleN8NForwardAuth is called by Traefik's ForwardAuth middleware for n8n.
-// It reads Authentik identity headers (set by Authentik's proxy outpost forward-auth),
-// ensures the user exists in n8n, and allows the request through.
+// n8nForwardAuth is the /auth/n8n provisioning hook, run by Traefik's
+// ForwardAuth middleware. It ensures the user exists in n8n and allows the
+// request through.
 //
 // Unlike Mattermost which uses session cookies, n8n SSO via proxy works by:
 // 1. User visits /n8n
@@ -369,91 +1084,79 @@ func (s *Server) handleMattermostForwardAuth(w http.ResponseWriter, r *http.Requ
 // 5. Traefik then calls this endpoint with those headers
 // 6. We ensure the n8n user exists and allow through
 // 7. n8n sees the authenticated user headers from Authentik
-func (s *Server) handleN8NForwardAuth(w http.ResponseWriter, r *http.Request) {
-	// Extract user identity from Authentik headers (set by Authentik proxy outpost)
-	email := headerFirst(r,
-		"X-Authentik-Email",
-		"X-Auth-Request-Email",
-		"X-Forwarded-Email",
-	)
-	username := headerFirst(r,
-		"X-Authentik-Username",
-		"X-Auth-Request-User",
-		"X-Forwarded-User",
-		"Remote-User",
-	)
-	name := headerFirst(r,
-		"X-Authentik-Name",
-		"X-Auth-Request-Name",
-		"X-Auth-Request-User",
-		"X-Forwarded-User",
-	)
-
-	// Log all X-Authentik headers for debugging
-	for key, values := range r.Header {
-		lowerKey := strings.ToLower(key)
-		if strings.HasPrefix(lowerKey, "x-authentik") || strings.HasPrefix(lowerKey, "x-auth-request") {
-			s.logger.Debug("n8n authentik header", "key", key, "values", values)
-		}
-	}
-
-	// If no Authentik headers, deny access
-	if email == "" {
-		s.logger.Debug("no authentik identity headers found for n8n", "path", r.URL.Path)
-		http.Error(w, "Unauthorized - no Authentik session", http.StatusUnauthorized)
-		return
-	}
-
-	s.logger.Info("n8n forward auth request",
-		"email", email,
-		"username", username,
-		"name", name,
-		"path", r.Header.Get("X-Forwarded-Uri"),
-	)
+func (s *Server) n8nForwardAuth(w http.ResponseWriter, r *http.Request, ident forwardIdentity) bool {
+	email, username, name, groups := ident.Email, ident.Username, ident.Name, ident.Groups
 
 	// If n8n client is not configured, just allow through (n8n will handle its own auth)
-	if s.n8nClient == nil {
-		s.logger.Debug("n8n client not configured, allowing through")
-		w.WriteHeader(http.StatusOK)
-		return
+	if s.n8nAPI() == nil {
+		s.logger.DebugContext(r.Context(), "n8n client not configured, allowing through")
+		return true
 	}
 
 	// Check circuit breaker
-	if s.n8nBreaker != nil && !s.n8nBreaker.allow() {
-		s.logger.Warn("n8n circuit open", "email", email)
+	if s.n8nBreaker != nil && !s.n8nBreaker.Allow() {
+		s.logger.WarnContext(r.Context(), "n8n circuit open", "email", email)
 		// Allow through anyway - n8n will handle auth
-		w.WriteHeader(http.StatusOK)
-		return
+		return true
 	}
 
 	ctx := r.Context()
+	role := s.desiredN8NRole(groups)
 
 	// Ensure user exists in n8n (best effort - don't block if it fails)
-	_, err := s.n8nClient.EnsureUser(ctx, n8n.Identity{
+	user, err := s.n8nAPI().EnsureUser(ctx, n8n.Identity{
 		Email:    email,
 		Name:     name,
 		Username: username,
+		Role:     role,
 	})
 	if err != nil {
 		s.recordN8NFailure(err)
-		s.logger.Warn("failed to ensure n8n user (allowing through)", "email", email, "err", err)
+		s.logger.WarnContext(ctx, "failed to ensure n8n user (allowing through)", "email", email, "err", err)
 		// Don't block - just log and allow through
 	} else {
 		s.recordN8NSuccess()
-		s.logger.Info("n8n user ensured", "email", email)
+		s.logger.InfoContext(ctx, "n8n user ensured", "email", email)
+		user = s.settleN8NInvite(ctx, user, false)
+		s.syncN8NRole(ctx, user, role)
+		s.ensureN8NProjects(ctx, user, groups)
+		// Browsers that already carry an n8n session keep it; disabled
+		// (deprovisioned) users don't get a new one.
+		if _, cookieErr := r.Cookie(n8n.AuthCookieName); cookieErr != nil && s.cfg.N8NIssueSessions && !s.cfg.DryRun && !user.Disabled {
+			if reason := s.crossSiteReason(r, s.n8nOrigin); reason != "" {
+				s.logger.WarnContext(ctx, "not issuing an n8n session for a cross-site request", "email", email, "header", reason)
+			} else {
+				s.issueN8NSession(r, w, user)
+			}
+		}
+	}
+
+	if s.cfg.DryRun {
+		w.Header().Set(dryRunHeader, "no-user-created")
 	}
 
-	// Return 200 to allow the request through
-	// n8n will see the X-Authentik-* headers and can use them for user identification
-	w.WriteHeader(http.StatusOK)
+	// Allow the request through; n8n will see the X-Authentik-* headers and
+	// can use them for user identification
+	return true
 }
 
+// recordN8NFailure counts err against the n8n breaker unless n8n answered
+// and refused the request: a missing user or a bad owner password says
+// nothing about n8n's health, and would otherwise hold the breaker open.
 func (s *Server) recordN8NFailure(err error) {
+	if errors.Is(err, n8n.ErrOwnerAuthFailed) {
+		s.logger.Error("n8n rejected the owner credentials; check AUTH_MANAGER_N8N_OWNER_EMAIL/PASS", "err", err)
+		return
+	}
+	if n8n.IsClientError(err) {
+		s.logger.Warn("n8n request refused", "status", n8n.StatusCode(err), "err", err)
+		return
+	}
 	if s.n8nBreaker == nil {
 		return
 	}
-	if opened := s.n8nBreaker.recordFailure(); opened {
-		s.logger.Error("n8n circuit opened", "cooldown", s.n8nBreaker.remaining(), "err", err)
+	if opened := s.n8nBreaker.RecordFailure(err); opened {
+		s.logger.Error("n8n circuit opened", "cooldown", s.n8nBreaker.Remaining(), "err", err)
 	} else {
 		s.logger.Warn("n8n operation failed", "err", err)
 	}
@@ -463,30 +1166,36 @@ func (s *Server) recordN8NSuccess() {
 	if s.n8nBreaker == nil {
 		return
 	}
-	s.n8nBreaker.recordSuccess()
+	s.n8nBreaker.RecordSuccess()
 }
 
 // handleManualSync allows triggering a sync for a specific user via API.
+// syncRequest is the body of POST /api/v1/sync.
+type syncRequest struct {
+	Email    string `json:"email"`
+	Username string `json:"username"`
+	Name     string `json:"name"`
+	Subject  string `json:"subject"`
+	// Groups is checked against the allowed/denied groups; omitted means
+	// unknown.
+	Groups []string `json:"groups"`
+}
+
 func (s *Server) handleManualSync(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		w.Header().Set("Allow", "POST")
-		s.respondJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		s.respondError(w, r, apperror.MethodNotAllowed)
 		return
 	}
 
-	var payload struct {
-		Email    string `json:"email"`
-		Username string `json:"username"`
-		Name     string `json:"name"`
-		Subject  string `json:"subject"`
-	}
+	var payload syncRequest
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-		s.respondError(w, http.StatusBadRequest, err)
+		s.respondBodyError(w, r, err)
 		return
 	}
 
 	if payload.Email == "" {
-		s.respondError(w, http.StatusBadRequest, errors.New("email is required"))
+		s.respondError(w, r, invalidRequest("email is required"))
 		return
 	}
 
@@ -495,88 +1204,163 @@ func (s *Server) handleManualSync(w http.ResponseWriter, r *http.Request) {
 		Username: payload.Username,
 		Name:     payload.Name,
 		Subject:  payload.Subject,
+		Groups:   payload.Groups,
 	}
 
-	if err := s.provisionUser(r.Context(), userInfo); err != nil {
-		s.respondError(w, http.StatusInternalServerError, err)
-		return
-	}
-
-	s.respondJSON(w, http.StatusOK, map[string]any{
-		"status": "provisioned",
-		"email":  payload.Email,
-	})
+	result, err := s.provisionUser(r.Context(), userInfo)
+	s.respondProvision(w, payload.Email, result, err)
 }
 
-// provisionUser ensures a user exists in all downstream services.
-func (s *Server) provisionUser(ctx context.Context, info *webhook.UserInfo) error {
-	// Store in shadow database
-	subject := info.Subject
-	if subject == "" {
-		subject = info.Email // Use email as fallback subject
+// provisionUser stores the user in the shadow database, then runs every
+// provisioner for them. The result reports each step even when err is set.
+func (s *Server) provisionUser(ctx context.Context, info *webhook.UserInfo) (result provisionResult, err error) {
+	info.Email = accounts.NormalizeEmail(info.Email)
+	start := time.Now()
+	var shadowUser shadow.ShadowUser
+	defer func() {
+		s.provisionLatency.WithLabelValues(errorOutcome(err)).Observe(time.Since(start).Seconds())
+		s.recordProvisionOutcome(info.Email, err)
+		if result.Filtered != "" {
+			s.recordUserHistory(ctx, info.Email, historyFiltered, errors.New(result.Filtered))
+			return
+		}
+		if result.Deferred != "" {
+			s.recordUserHistory(ctx, info.Email, historyDeferred, nil)
+			return
+		}
+		event := outbound.EventUserProvisioned
+		if err != nil {
+			event = outbound.EventUserProvisionFailed
+		}
+		s.publishUserEvent(ctx, event, s.canonicalIdentity(info, shadowUser), result.Targets, err)
+	}()
+	defer s.userLocks.lock(info.Email)()
+
+	if list, reason := s.userFilter(info.Email, info.Groups); reason != "" {
+		s.logger.InfoContext(ctx, "user filtered, not provisioning", "email", info.Email, "list", list, "reason", reason)
+		s.usersFiltered.WithLabelValues("provision", list).Inc()
+		result.Filtered = reason
+		result.Shadow = provision.Result{Status: provision.StatusSkipped, Error: reason}
+		result.Targets = s.provisioners.Skipped(reason)
+		return result, nil
+	}
+	if s.maintenance.active() {
+		s.logger.InfoContext(ctx, "maintenance mode: provisioning deferred", "email", info.Email)
+		s.maintenance.deferred.WithLabelValues("provision", "deferred").Inc()
+		result.Deferred = maintenanceReason
+		result.Shadow = provision.Result{Status: provision.StatusSkipped, Error: maintenanceReason}
+		result.Targets = s.provisioners.Skipped(maintenanceReason)
+		return result, nil
 	}
 
+	// Store in shadow database
 	attributes := map[string]string{}
 	if info.Username != "" {
 		attributes["username"] = info.Username
 	}
+	if info.Groups != nil {
+		attributes[attrGroups] = groupsAttribute(info.Groups)
+	}
 
-	shadowUser, err := s.shadowStore.Upsert(ctx, shadow.Identity{
-		Provider: "authentik",
-		Subject:  subject,
+	shadowUser, err = s.upsertShadow(ctx, shadow.Identity{
+		Provider: info.ShadowProvider(),
+		Subject:  info.ShadowSubject(),
 		Email:    info.Email,
 		Name:     info.Name,
 	}, attributes)
 	if err != nil {
-		return fmt.Errorf("shadow store upsert: %w", err)
+		storeErr := storeUnavailable(fmt.Errorf("shadow store upsert: %w", err))
+		result.Shadow = provision.Result{Status: provision.StatusFailed, Error: storeErr.Message}
+		result.Targets = s.provisioners.Skipped("not attempted: shadow store write failed")
+		return result, storeErr
 	}
+	result.Shadow = provision.Result{Status: provision.StatusOK}
 
-	// Provision to Mattermost
-	if s.mmClient != nil {
-		if s.mmBreaker != nil && !s.mmBreaker.allow() {
-			s.logger.Warn("mattermost circuit open, skipping provisioning", "email", info.Email)
-		} else {
-			mmUser, err := s.mmClient.EnsureUser(ctx, mattermost.Identity{
-				Email: info.Email,
-				Name:  info.Name,
-				User:  info.Username,
-			})
-			if err != nil {
-				s.recordMattermostFailure(err)
-				return fmt.Errorf("mattermost provision: %w", err)
-			}
-			s.recordMattermostSuccess()
-			s.logger.Info("user provisioned to mattermost",
-				"email", info.Email,
-				"mattermost_id", mmUser.ID,
-				"shadow_id", shadowUser.ID,
-			)
-		}
+	result.Targets, err = s.provisioners.Provision(ctx, s.canonicalIdentity(info, shadowUser))
+	if err != nil {
+		return result, err
 	}
-
 	s.usersProvisioned.Inc()
+	return result, nil
+}
+
+// attrMattermostUsername records the username a user got in Mattermost,
+// which has a suffix such as -2 when the derived one was taken.
+const attrMattermostUsername = "mattermost_username"
+
+// provisionMattermostUser ensures the user exists in Mattermost with their
+// onboarding applied, reactivates them if deprovisioning deactivated them,
+// and records their Mattermost ID and username on the shadow record.
+func (s *Server) provisionMattermostUser(ctx context.Context, info *webhook.UserInfo, shadowUser shadow.ShadowUser) error {
+	mmUser, created, err := s.mattermost().EnsureUser(ctx, mattermost.Identity{
+		Email: info.Email,
+		Name:  info.Name,
+		User:  info.Username,
+		ID:    shadowUser.Attributes["mattermost_user_id"],
+	})
+	if err != nil {
+		s.recordMattermostFailure(mmOpEnsureUser, err)
+		return fmt.Errorf("mattermost provision: %w", err)
+	}
+	s.recordMattermostSuccess(mmOpEnsureUser)
+	mmUser = s.onboardMattermostUser(ctx, mmUser, created, info.Groups)
+	if info.Groups != nil && !mmUser.IsGuest() && s.teamSyncEnabled() {
+		s.syncShadowUserTeams(ctx, shadowUser, mmUser.ID, info.Groups, false)
+	}
+	if shadowUser.Attributes[attrMattermostDeactivated] == "true" {
+		if err := s.mattermost().ReactivateUser(ctx, mmUser.ID); err != nil {
+			s.recordMattermostFailure(mmOpEnsureUser, err)
+			return fmt.Errorf("mattermost reactivate: %w", err)
+		}
+		if _, err := s.upsertShadow(ctx, shadowUser.Identity, map[string]string{attrMattermostDeactivated: "false"}); err != nil {
+			s.logger.WarnContext(ctx, "failed to clear mattermost deactivation marker", "email", info.Email, "err", err)
+		}
+		s.logger.InfoContext(ctx, "mattermost user reactivated", "email", info.Email, "mattermost_id", mmUser.ID)
+	}
+	recorded := map[string]string{}
+	if mmUser.ID != "" && shadowUser.Attributes["mattermost_user_id"] != mmUser.ID {
+		recorded["mattermost_user_id"] = mmUser.ID
+	}
+	if mmUser.Username != "" && shadowUser.Attributes[attrMattermostUsername] != mmUser.Username {
+		recorded[attrMattermostUsername] = mmUser.Username
+	}
+	if len(recorded) > 0 {
+		if _, err := s.upsertShadow(ctx, shadowUser.Identity, recorded); err != nil {
+			s.logger.WarnContext(ctx, "failed to record mattermost user", "email", info.Email, "err", err)
+		}
+	}
+	s.logger.InfoContext(ctx, "user provisioned to mattermost",
+		"email", info.Email,
+		"mattermost_id", mmUser.ID,
+		"shadow_id", shadowUser.ID,
+	)
 	return nil
 }
 
-func (s *Server) recordMattermostFailure(err error) {
-	if s.mmBreaker == nil {
+// recordMattermostFailure counts err against the breaker for operation
+// unless Mattermost answered and refused the request: one malformed identity
+// shouldn't open the breaker for everyone.
+func (s *Server) recordMattermostFailure(operation string, err error) {
+	if mattermost.IsClientError(err) {
+		s.logger.Warn("mattermost request refused", "operation", operation, "status", mattermost.StatusCode(err), "err", err)
 		return
 	}
-	if opened := s.mmBreaker.recordFailure(); opened {
-		s.logger.Error("mattermost circuit opened", "cooldown", s.mmBreaker.remaining(), "err", err)
+	b := s.mmBreakers.Get(operation)
+	if opened := b.RecordFailure(err); opened {
+		s.logger.Error("mattermost circuit opened", "operation", operation, "cooldown", b.Remaining(), "err", err)
 	} else {
-		s.logger.Warn("mattermost operation failed", "err", err)
+		s.logger.Warn("mattermost operation failed", "operation", operation, "err", err)
 	}
 }
 
-func (s *Server) recordMattermostSuccess() {
-	if s.mmBreaker == nil {
-		return
-	}
-	s.mmBreaker.recordSuccess()
+func (s *Server) recordMattermostSuccess(operation string) {
+	s.mmBreakers.Get(operation).RecordSuccess()
 }
 
 func (s *Server) respondJSON(w http.ResponseWriter, status int, payload any) {
+	if s.cfg.DryRun {
+		payload = withDryRun(payload)
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 	if err := json.NewEncoder(w).Encode(payload); err != nil {
@@ -584,99 +1368,219 @@ func (s *Server) respondJSON(w http.ResponseWriter, status int, payload any) {
 	}
 }
 
-func (s *Server) respondError(w http.ResponseWriter, status int, err error) {
-	s.respondJSON(w, status, map[string]string{"error": err.Error()})
+// respondError responds with err as problem details. Only an
+// *apperror.Error's public message is sent; anything else becomes an opaque
+// internal_error. The full error is logged.
+func (s *Server) respondError(w http.ResponseWriter, r *http.Request, err error) {
+	appErr := apperror.From(err)
+	if appErr.Status >= http.StatusInternalServerError {
+		s.logger.ErrorContext(r.Context(), "request failed", "path", r.URL.Path, "code", appErr.Code, "err", err)
+	} else {
+		s.logger.DebugContext(r.Context(), "request rejected", "path", r.URL.Path, "code", appErr.Code, "err", err)
+	}
+	problem := appErr.Problem()
+	problem.RequestID = httpx.RequestID(r.Context())
+	w.Header().Set("Content-Type", apperror.ContentType)
+	w.WriteHeader(problem.Status)
+	if err := json.NewEncoder(w).Encode(problem); err != nil {
+		s.logger.ErrorContext(r.Context(), "write response", "err", err)
+	}
+}
+
+// mattermostError hides a failed Mattermost call behind message.
+func mattermostError(err error, message string) *apperror.Error {
+	return apperror.Wrap(err, http.StatusBadGateway, apperror.CodeMattermostError, message)
+}
+
+// respondProvision reports what provisionUser did. Once the shadow write
+// succeeds the user exists as far as auth-manager is concerned, so the
+// status is 200 even when provisioners failed; the body says which. It is
+// 502 only when nothing succeeded.
+func (s *Server) respondProvision(w http.ResponseWriter, email string, result provisionResult, err error) {
+	status, payload := provisionPayload(email, result, err)
+	s.respondJSON(w, status, payload)
 }
 
-// headerFirst returns the first non-empty header value from the provided list of keys.
-func headerFirst(r *http.Request, keys ...string) string {
-	for _, key := range keys {
-		if val := strings.TrimSpace(r.Header.Get(key)); val != "" {
-			return val
+// provisionPayload builds respondProvision's status and body.
+func provisionPayload(email string, result provisionResult, err error) (int, map[string]any) {
+	payload := map[string]any{
+		"status":  result.summary(),
+		"email":   email,
+		"shadow":  result.Shadow,
+		"targets": result.Targets,
+	}
+	status := http.StatusOK
+	if result.Shadow.Status == provision.StatusFailed {
+		status = http.StatusBadGateway
+	}
+	if err != nil {
+		// Provisioner errors are the downstream services' own responses;
+		// typed errors, such as a shadow store failure, only show their
+		// public message.
+		if appErr := (*apperror.Error)(nil); errors.As(err, &appErr) {
+			payload["error"] = appErr.Message
+		} else {
+			payload["error"] = errorText(err)
+		}
+		if errors.As(err, new(*botProvisionError)) {
+			payload["error_class"] = "bot_provisioning"
 		}
 	}
-	return ""
+	return status, payload
 }
 
-func (s *Server) logRequest(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
-		next.ServeHTTP(w, r)
-		s.logger.Info("request", "method", r.Method, "path", r.URL.Path, "duration", time.Since(start))
-	})
+// respondDeprovisionError reports a deprovisionUser failure with each
+// provisioner's result.
+func (s *Server) respondDeprovisionError(w http.ResponseWriter, results []provision.Result, err error) {
+	s.respondJSON(w, http.StatusInternalServerError, deprovisionErrorBody(results, err))
 }
 
-func (s *Server) newStoreFromConfig() shadow.Store {
-	if s.cfg.DatabaseURL == "" {
-		s.logger.Warn("AUTH_MANAGER_DATABASE_URL not set; using in-memory shadow store (data lost on restart)")
-		return shadow.NewMemoryStore()
+func deprovisionErrorBody(results []provision.Result, err error) map[string]any {
+	return map[string]any{
+		"error":   errorText(err),
+		"targets": results,
+	}
+}
+
+// errorText returns err's message for a response body, with any credentials
+// a downstream service echoed back masked.
+func errorText(err error) string {
+	return redact.Text(err.Error())
+}
+
+// stageOperation is the Mattermost breaker a failed sign-in stage reports to.
+func stageOperation(stage mmbridge.Stage) string {
+	if stage == mmbridge.StageProvision {
+		return mmOpEnsureUser
 	}
+	return mmOpSession
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+// mattermostBridge returns the bridge forward auth signs users in to
+// Mattermost with, calling api. Building cookies takes no API.
+func (s *Server) mattermostBridge(api mmbridge.MattermostAPI) *mmbridge.Bridge {
+	return mmbridge.New(api, s.mmOptions)
+}
 
-	store, err := shadow.NewPostgresStore(ctx, s.cfg.DatabaseURL)
+// createMattermostSession ensures the Mattermost user exists, applies the
+// tier, membership, and role hooks, and mints a forward-auth session for them.
+// While the ensure-user breaker is open, a user whose Mattermost ID is on
+// their shadow record still gets a session, without the ensure and hooks.
+func (s *Server) createMattermostSession(ctx context.Context, ident provision.CanonicalIdentity, isXHR bool) (cachedSession, error) {
+	if !s.mmBreakers.Get(mmOpSession).Allow() {
+		return cachedSession{}, &mmbridge.Error{Stage: mmbridge.StageSession, Err: provision.ErrCircuitOpen}
+	}
+
+	api := forwardAuthAPI{s: s, subject: ident.Subject, groups: ident.Groups}
+	session, err := s.mattermostBridge(api).CreateSession(ctx, ident, isXHR)
 	if err != nil {
-		s.logger.Error("failed to init postgres store, falling back to memory", "err", err)
-		return shadow.NewMemoryStore()
+		return cachedSession{}, err
 	}
-	return store
-}
 
-type circuitBreaker struct {
-	mu           sync.Mutex
-	failureCount int
-	threshold    int
-	cooldown     time.Duration
-	openUntil    time.Time
+	s.logger.InfoContext(ctx, "mattermost session created",
+		"email", ident.Email,
+		"mattermost_user_id", session.UserID,
+		"session_id", session.ID,
+	)
+	return cachedSession{UserID: session.UserID, Session: session}, nil
 }
 
-func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
-	return &circuitBreaker{threshold: threshold, cooldown: cooldown}
+// forwardAuthAPI is the Mattermost API behind forward auth's bridge. It
+// wraps the client in the Mattermost breakers, holds the user's lock while
+// ensuring them, and runs the onboarding hooks on the user.
+type forwardAuthAPI struct {
+	s       *Server
+	subject string
+	groups  []string
 }
 
-func (c *circuitBreaker) allow() bool {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	if !c.openUntil.IsZero() {
-		now := time.Now()
-		if now.Before(c.openUntil) {
-			return false
+func (a forwardAuthAPI) EnsureUser(ctx context.Context, ident mattermost.Identity) (mattermost.User, bool, error) {
+	s := a.s
+	if !s.mmBreakers.Get(mmOpEnsureUser).Allow() {
+		id := s.knownMattermostUserID(ctx, a.subject, ident.Email)
+		if id == "" {
+			return mattermost.User{}, false, provision.ErrCircuitOpen
 		}
-		c.openUntil = time.Time{}
-		c.failureCount = 0
+		s.logger.WarnContext(ctx, "mattermost ensure-user circuit open; creating a session for the recorded user", "email", ident.Email, "mattermost_user_id", id)
+		return mattermost.User{ID: id}, false, nil
 	}
-	return true
+
+	unlock := s.userLocks.lock(ident.Email)
+	defer unlock()
+	user, created, err := s.mattermost().EnsureUser(ctx, ident)
+	if err != nil {
+		s.recordMattermostFailure(mmOpEnsureUser, err)
+		s.recordProvisionOutcome(ident.Email, err)
+		return mattermost.User{}, false, err
+	}
+	s.recordMattermostSuccess(mmOpEnsureUser)
+	s.recordProvisionOutcome(ident.Email, nil)
+	return s.onboardMattermostUser(ctx, user, created, a.groups), created, nil
 }
 
-func (c *circuitBreaker) remaining() time.Duration {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	if c.openUntil.IsZero() {
-		return 0
+func (a forwardAuthAPI) CreateSession(ctx context.Context, userID string, opts mattermost.SessionOptions) (mattermost.Session, error) {
+	session, err := a.s.mattermost().CreateSession(ctx, userID, opts)
+	if err != nil {
+		a.s.recordMattermostFailure(mmOpSession, err)
+		return mattermost.Session{}, err
 	}
-	d := time.Until(c.openUntil)
-	if d < 0 {
-		return 0
+	a.s.recordMattermostSuccess(mmOpSession)
+	return session, nil
+}
+
+// knownMattermostUserID returns the Mattermost ID on a forward-auth user's
+// shadow records, or "". Forward auth's subject is X-Authentik-Uid, which
+// webhook and reconcile records aren't keyed by, so when nothing is stored
+// under it the user's records are found by email, the most recently
+// updated one with an ID winning.
+func (s *Server) knownMattermostUserID(ctx context.Context, subject, email string) string {
+	if subject != "" {
+		if user, err := s.shadowStore.Get(ctx, webhook.DefaultProvider, subject); err == nil && user.Attributes["mattermost_user_id"] != "" {
+			return user.Attributes["mattermost_user_id"]
+		}
+	}
+	users, err := s.shadowStore.ListByEmail(ctx, email)
+	if err != nil {
+		s.logger.WarnContext(ctx, "looking up the recorded mattermost user failed", "email", email, "err", err)
+		return ""
+	}
+	var id string
+	var updated time.Time
+	for _, user := range users {
+		if user.Identity.Provider == webhook.DefaultProvider && user.Attributes["mattermost_user_id"] != "" && user.UpdatedAt.After(updated) {
+			id, updated = user.Attributes["mattermost_user_id"], user.UpdatedAt
+		}
 	}
-	return d
+	return id
 }
 
-func (c *circuitBreaker) recordSuccess() {
-	c.mu.Lock()
-	c.failureCount = 0
-	c.openUntil = time.Time{}
-	c.mu.Unlock()
+// rejectedAdminToken responds to forward auth when Mattermost refused our
+// admin token, which is a deployment problem rather than a user one. It
+// reports whether it handled err.
+func (s *Server) rejectedAdminToken(w http.ResponseWriter, r *http.Request, err error, logArgs ...any) bool {
+	if !mattermost.IsUnauthorized(err) {
+		return false
+	}
+	s.logger.Error("mattermost rejected the admin token; check AUTH_MANAGER_MATTERMOST_ADMIN_TOKEN",
+		append(logArgs, "status", mattermost.StatusCode(err), "err", err)...)
+	w.Header().Set("X-Rave-Auth-Error", "mattermost-admin-token-rejected")
+	s.authError(w, r, "Mattermost integration misconfigured", http.StatusBadGateway)
+	return true
 }
 
-func (c *circuitBreaker) recordFailure() (opened bool) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	c.failureCount++
-	if c.failureCount >= c.threshold {
-		c.openUntil = time.Now().Add(c.cooldown)
-		c.failureCount = 0
-		return true
+func (s *Server) newStoreFromConfig() shadow.Store {
+	if s.cfg.DatabaseURL == "" {
+		s.logger.Warn("AUTH_MANAGER_DATABASE_URL not set; using in-memory shadow store (data lost on restart)")
+		return shadow.NewMemoryStore()
 	}
-	return false
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	store, err := shadow.NewPostgresStore(ctx, s.cfg.DatabaseURL)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to init postgres store, falling back to memory", "err", err)
+		return shadow.NewMemoryStore()
+	}
+	return store
 }
@@ -0,0 +1,207 @@
+package server
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/accounts"
+	"github.com/rave-org/rave/apps/auth-manager/internal/mattermost"
+)
+
+// cachedSession is a Mattermost session minted for forward auth.
+type cachedSession struct {
+	UserID  string
+	Session mattermost.Session
+}
+
+// sessionCache remembers the Mattermost session issued to each identity so
+// clients that never send cookies (mobile webviews, API calls) reuse one
+// session instead of minting a new one per request. Entries expire after ttl
+// or a minute before the session itself does, and the least recently used
+// entry is evicted beyond max entries. Concurrent misses for the same key
+// share one creation.
+type sessionCache struct {
+	ttl time.Duration
+	max int
+	now func() time.Time
+
+	mu       sync.Mutex
+	lru      *list.List // Front is most recently used
+	items    map[string]*list.Element
+	inflight map[string]*sessionCall
+}
+
+type sessionCacheEntry struct {
+	key     string
+	value   cachedSession
+	expires time.Time
+}
+
+type sessionCall struct {
+	done  chan struct{}
+	value cachedSession
+	err   error
+}
+
+// newSessionCache returns nil, which disables caching, when ttl or max is not positive.
+func newSessionCache(ttl time.Duration, max int) *sessionCache {
+	if ttl <= 0 || max <= 0 {
+		return nil
+	}
+	return &sessionCache{
+		ttl:      ttl,
+		max:      max,
+		now:      time.Now,
+		lru:      list.New(),
+		items:    map[string]*list.Element{},
+		inflight: map[string]*sessionCall{},
+	}
+}
+
+// sessionCacheKey keys sessions by email and kind, since XHR and browser
+// sessions carry different lifetimes and device IDs.
+func sessionCacheKey(email string, isXHR bool) string {
+	kind := "web"
+	if isXHR {
+		kind = "xhr"
+	}
+	return accounts.NormalizeEmail(email) + "|" + kind
+}
+
+// get returns the live cached session for key.
+func (c *sessionCache) get(key string) (cachedSession, bool) {
+	if c == nil {
+		return cachedSession{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.getLocked(key)
+}
+
+func (c *sessionCache) getLocked(key string) (cachedSession, bool) {
+	elem, ok := c.items[key]
+	if !ok {
+		return cachedSession{}, false
+	}
+	entry := elem.Value.(*sessionCacheEntry)
+	if !c.now().Before(entry.expires) {
+		c.lru.Remove(elem)
+		delete(c.items, key)
+		return cachedSession{}, false
+	}
+	c.lru.MoveToFront(elem)
+	return entry.value, true
+}
+
+// sessionCreateTimeout bounds a shared session creation, which outlives
+// the request that started it.
+var sessionCreateTimeout = 10 * time.Second
+
+// do returns the cached session for key or calls create, sharing a single
+// in-flight create between concurrent callers. shared reports whether the
+// result came from the cache or another caller's create. create runs
+// detached from ctx, under sessionCreateTimeout, so one caller giving up
+// doesn't fail the others; each caller stops waiting when its own ctx ends.
+func (c *sessionCache) do(ctx context.Context, key string, create func(context.Context) (cachedSession, error)) (value cachedSession, shared bool, err error) {
+	if c == nil {
+		value, err = create(ctx)
+		return value, false, err
+	}
+
+	c.mu.Lock()
+	if value, ok := c.getLocked(key); ok {
+		c.mu.Unlock()
+		return value, true, nil
+	}
+	call, shared := c.inflight[key]
+	if !shared {
+		call = &sessionCall{done: make(chan struct{})}
+		c.inflight[key] = call
+		go c.create(context.WithoutCancel(ctx), key, call, create)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.value, shared, call.err
+	case <-ctx.Done():
+		return cachedSession{}, shared, ctx.Err()
+	}
+}
+
+// create runs a shared creation to completion and hands its result to the
+// callers waiting on call, even if create panics.
+func (c *sessionCache) create(ctx context.Context, key string, call *sessionCall, create func(context.Context) (cachedSession, error)) {
+	ctx, cancel := context.WithTimeout(ctx, sessionCreateTimeout)
+	defer cancel()
+	defer func() {
+		if p := recover(); p != nil {
+			call.value, call.err = cachedSession{}, fmt.Errorf("session creation panicked: %v", p)
+		}
+		c.mu.Lock()
+		delete(c.inflight, key)
+		if call.err == nil {
+			c.storeLocked(key, call.value)
+		}
+		c.mu.Unlock()
+		close(call.done)
+	}()
+	call.value, call.err = create(ctx)
+}
+
+func (c *sessionCache) storeLocked(key string, value cachedSession) {
+	expires := c.now().Add(c.ttl)
+	if value.Session.ExpiresAt > 0 {
+		// Never hand out a session that is about to expire.
+		if sessionEnd := time.UnixMilli(value.Session.ExpiresAt).Add(-time.Minute); sessionEnd.Before(expires) {
+			expires = sessionEnd
+		}
+	}
+	entry := &sessionCacheEntry{key: key, value: value, expires: expires}
+	if elem, ok := c.items[key]; ok {
+		elem.Value = entry
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.items[key] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.max {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.items, oldest.Value.(*sessionCacheEntry).key)
+	}
+}
+
+// invalidate drops every cached session for email, e.g. after Mattermost
+// errors or when its sessions are revoked.
+func (c *sessionCache) invalidate(email string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, isXHR := range []bool{false, true} {
+		key := sessionCacheKey(email, isXHR)
+		if elem, ok := c.items[key]; ok {
+			c.lru.Remove(elem)
+			delete(c.items, key)
+		}
+	}
+}
+
+// invalidateToken drops the cached session with token, e.g. on logout.
+func (c *sessionCache) invalidateToken(token string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, elem := range c.items {
+		if elem.Value.(*sessionCacheEntry).value.Session.Token == token {
+			c.lru.Remove(elem)
+			delete(c.items, key)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/apperror"
+	"github.com/rave-org/rave/apps/auth-manager/internal/mattermost"
+)
+
+// sessionCleanupTimeout bounds a full cleanup pass over every shadow user.
+const sessionCleanupTimeout = 10 * time.Minute
+
+// sessionCleanupRequest is the optional body of a cleanup request.
+type sessionCleanupRequest struct {
+	Keep            int  `json:"keep"`
+	DryRun          bool `json:"dry_run"`
+	IncludeUntagged bool `json:"include_untagged"`
+}
+
+// sessionCleanupLine is one line of the streamed NDJSON cleanup progress.
+type sessionCleanupLine struct {
+	Email    string   `json:"email,omitempty"`
+	UserID   string   `json:"mattermost_user_id,omitempty"`
+	Sessions int      `json:"sessions"`
+	Revoke   []string `json:"revoke,omitempty"`
+	Revoked  int      `json:"revoked"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// sessionCleanupSummary is the final line of the cleanup stream.
+type sessionCleanupSummary struct {
+	Done    bool   `json:"done"`
+	DryRun  bool   `json:"dry_run"`
+	Users   int    `json:"users"`
+	Revoked int    `json:"revoked"`
+	Errors  int    `json:"errors"`
+	Error   string `json:"error,omitempty"`
+}
+
+// handleSessionCleanup revokes surplus SSO sessions for every shadow user with
+// a recorded Mattermost ID, keeping the most recent Keep sessions per user.
+// Only sessions whose device ID carries our prefix are touched, plus untagged
+// sessions when IncludeUntagged is set (sessions minted before device tagging
+// have an empty device ID). Progress is streamed as NDJSON, one line per user
+// and a final summary line.
+func (s *Server) handleSessionCleanup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		s.respondError(w, r, apperror.MethodNotAllowed)
+		return
+	}
+	if s.mattermost() == nil {
+		s.respondError(w, r, notConfigured("mattermost not configured"))
+		return
+	}
+
+	payload := sessionCleanupRequest{Keep: 1}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil && !errors.Is(err, io.EOF) {
+		s.respondBodyError(w, r, err)
+		return
+	}
+	payload.DryRun = payload.DryRun || s.cfg.DryRun
+	if payload.Keep < 0 {
+		s.respondError(w, r, invalidRequest("keep must not be negative"))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), sessionCleanupTimeout)
+	defer cancel()
+
+	users, err := s.shadowStore.List(ctx)
+	if err != nil {
+		s.respondError(w, r, storeUnavailable(err))
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Now().Add(sessionCleanupTimeout + 5*time.Second))
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+
+	prefix := s.cfg.MattermostSessionDevicePrefix
+	if prefix == "" {
+		prefix = "rave-sso"
+	}
+	summary := sessionCleanupSummary{DryRun: payload.DryRun}
+	for _, user := range users {
+		userID := user.Attributes["mattermost_user_id"]
+		if userID == "" {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			summary.Error = err.Error()
+			break
+		}
+		if !s.mmBreakers.Get(mmOpRevoke).Allow() {
+			summary.Error = "mattermost circuit open"
+			break
+		}
+
+		line := s.cleanupUserSessions(ctx, userID, prefix, payload.Keep, payload.IncludeUntagged, payload.DryRun)
+		line.Email = user.Identity.Email
+		summary.Users++
+		summary.Revoked += line.Revoked
+		if line.Error != "" {
+			summary.Errors++
+		}
+		if line.Revoked > 0 {
+			s.sessionCache.invalidate(user.Identity.Email)
+		}
+		_ = enc.Encode(line)
+		_ = rc.Flush()
+	}
+
+	summary.Done = summary.Error == ""
+	s.logger.InfoContext(ctx, "mattermost session cleanup finished",
+		"dry_run", summary.DryRun,
+		"users", summary.Users,
+		"revoked", summary.Revoked,
+		"errors", summary.Errors,
+		"err", summary.Error,
+	)
+	_ = enc.Encode(summary)
+}
+
+// cleanupUserSessions revokes (or, in dry-run mode, lists) all but the keep
+// most recent SSO sessions for one Mattermost user.
+func (s *Server) cleanupUserSessions(ctx context.Context, userID, prefix string, keep int, includeUntagged, dryRun bool) sessionCleanupLine {
+	line := sessionCleanupLine{UserID: userID}
+	sessions, err := s.mattermost().ListSessions(ctx, userID)
+	if err != nil {
+		if !errors.Is(err, mattermost.ErrNotFound) {
+			s.recordMattermostFailure(mmOpRevoke, err)
+		}
+		line.Error = errorText(err)
+		return line
+	}
+	s.recordMattermostSuccess(mmOpRevoke)
+
+	var ours []mattermost.Session
+	for _, session := range sessions {
+		if strings.HasPrefix(session.DeviceID, prefix) || includeUntagged && session.DeviceID == "" {
+			ours = append(ours, session)
+		}
+	}
+	line.Sessions = len(ours)
+	if len(ours) <= keep {
+		return line
+	}
+
+	sort.Slice(ours, func(i, j int) bool { return ours[i].CreateAt > ours[j].CreateAt })
+	for _, session := range ours[keep:] {
+		line.Revoke = append(line.Revoke, session.ID)
+	}
+	if dryRun {
+		return line
+	}
+
+	for _, id := range line.Revoke {
+		if err := s.mattermost().RevokeSession(ctx, userID, id); err != nil {
+			s.recordMattermostFailure(mmOpRevoke, err)
+			line.Error = errorText(err)
+			return line
+		}
+		line.Revoked++
+		s.sessionsRevoked.Inc()
+	}
+	s.recordMattermostSuccess(mmOpRevoke)
+	return line
+}
@@ -0,0 +1,278 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/apperror"
+	"github.com/rave-org/rave/apps/auth-manager/internal/shadow"
+	"github.com/rave-org/rave/apps/auth-manager/internal/webhook"
+)
+
+// attrGroups records the user's groups, comma-separated, on their shadow
+// record, as last reported by a webhook, sync, or forward auth.
+const attrGroups = "groups"
+
+// mattermostCommandPath is where Mattermost sends the /rave slash command.
+// It authenticates with the command's token rather than the admin token.
+const mattermostCommandPath = "/api/v1/integrations/mattermost/command"
+
+const commandHelp = "Usage:\n" +
+	"* `/rave whois <email>`: the user's shadow record\n" +
+	"* `/rave sync <email>`: provision the user again (admins only)\n" +
+	"* `/rave status`: circuit breakers, warm-up, and background work"
+
+// commandResponse is the reply to a Mattermost slash command. Ephemeral
+// replies are only shown to the user who ran the command.
+type commandResponse struct {
+	ResponseType string `json:"response_type"`
+	Text         string `json:"text"`
+}
+
+// handleMattermostCommand serves the /rave slash command. Mattermost posts
+// the command form-encoded with the command's token, which is checked
+// against MattermostCommandToken. Usage errors are answered like results,
+// as ephemeral messages, since Mattermost only shows a generic error for
+// other statuses.
+func (s *Server) handleMattermostCommand(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		s.respondError(w, r, apperror.MethodNotAllowed)
+		return
+	}
+	if s.cfg.MattermostCommandToken == "" {
+		s.respondError(w, r, notConfigured("mattermost slash command not configured"))
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		s.respondBodyError(w, r, err)
+		return
+	}
+	token := r.PostForm.Get("token")
+	if header, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Token "); ok {
+		token = strings.TrimSpace(header)
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.cfg.MattermostCommandToken)) != 1 {
+		s.logger.WarnContext(r.Context(), "slash command with an invalid token", "client", s.clientIP(r))
+		s.respondError(w, r, apperror.New(http.StatusUnauthorized, apperror.CodeAuthenticationRequired, "invalid slash command token"))
+		return
+	}
+
+	ctx := r.Context()
+	userID := r.PostForm.Get("user_id")
+	args := strings.Fields(r.PostForm.Get("text"))
+	subcommand := "help"
+	if len(args) > 0 {
+		subcommand = strings.ToLower(args[0])
+	}
+	s.logger.InfoContext(ctx, "slash command", "subcommand", subcommand, "mattermost_user_id", userID, "user_name", r.PostForm.Get("user_name"))
+
+	var text string
+	switch subcommand {
+	case "whois":
+		if len(args) != 2 {
+			text = "Usage: `/rave whois <email>`"
+			break
+		}
+		text = s.commandWhois(ctx, args[1])
+	case "sync":
+		if len(args) != 2 {
+			text = "Usage: `/rave sync <email>`"
+			break
+		}
+		switch admin, err := s.commandAdmin(ctx, userID); {
+		case err != nil:
+			text = "Couldn't check your permissions: " + errorText(err)
+		case !admin:
+			s.logger.WarnContext(ctx, "slash command sync refused to a non-admin", "mattermost_user_id", userID)
+			text = "Only members of an admin group can run `/rave sync`."
+		default:
+			text = s.commandSync(ctx, args[1])
+		}
+	case "status":
+		text = s.commandStatus()
+	case "help":
+		text = commandHelp
+	default:
+		text = fmt.Sprintf("Unknown subcommand `%s`.\n\n%s", subcommand, commandHelp)
+	}
+	s.respondJSON(w, http.StatusOK, commandResponse{ResponseType: "ephemeral", Text: text})
+}
+
+// commandAdmin reports whether the Mattermost user mattermostID has a
+// shadow record in one of the command's admin groups.
+func (s *Server) commandAdmin(ctx context.Context, mattermostID string) (bool, error) {
+	groups := s.cfg.MattermostCommandAdminGroups
+	if len(groups) == 0 {
+		groups = s.cfg.AdminGroups
+	}
+	if mattermostID == "" || len(groups) == 0 {
+		return false, nil
+	}
+	admin := false
+	err := s.shadowStore.Each(ctx, func(user shadow.ShadowUser) error {
+		if user.Attributes["mattermost_user_id"] != mattermostID {
+			return nil
+		}
+		admin = inAnyGroup(shadowGroups(user), groups)
+		return errFound
+	})
+	if err != nil && !errors.Is(err, errFound) {
+		return false, fmt.Errorf("shadow store unavailable")
+	}
+	return admin, nil
+}
+
+// errFound stops a shadow store scan once it has found its user.
+var errFound = errors.New("found")
+
+// shadowUsersByEmail returns the shadow records for email, one per provider.
+func (s *Server) shadowUsersByEmail(ctx context.Context, email string) ([]shadow.ShadowUser, error) {
+	return s.shadowStore.ListByEmail(ctx, email)
+}
+
+func (s *Server) commandWhois(ctx context.Context, email string) string {
+	users, err := s.shadowUsersByEmail(ctx, email)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "slash command whois failed", "email", email, "err", err)
+		return "The shadow store is unavailable."
+	}
+	if len(users) == 0 {
+		return fmt.Sprintf("No shadow record for %s.", email)
+	}
+	var b strings.Builder
+	for i, user := range users {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "#### %s\n", user.Identity.Email)
+		if user.Identity.Name != "" {
+			fmt.Fprintf(&b, "**Name:** %s\n", user.Identity.Name)
+		}
+		fmt.Fprintf(&b, "**Identity:** %s `%s`\n", user.Identity.Provider, user.Identity.Subject)
+		fmt.Fprintf(&b, "**Created:** %s, **updated:** %s\n", user.CreatedAt.UTC().Format(time.RFC3339), user.UpdatedAt.UTC().Format(time.RFC3339))
+		if len(user.Attributes) > 0 {
+			keys := make([]string, 0, len(user.Attributes))
+			for key := range user.Attributes {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			b.WriteString("\n| Attribute | Value |\n|---|---|\n")
+			s.redactSensitive(user.Attributes)
+			for _, key := range keys {
+				fmt.Fprintf(&b, "| %s | %s |\n", key, user.Attributes[key])
+			}
+		}
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// commandSync provisions email again, with the name and groups on their
+// shadow record when they have one.
+func (s *Server) commandSync(ctx context.Context, email string) string {
+	info := &webhook.UserInfo{Email: email}
+	users, err := s.shadowUsersByEmail(ctx, email)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "slash command sync failed", "email", email, "err", err)
+		return "The shadow store is unavailable."
+	}
+	if len(users) > 0 {
+		user := users[0]
+		info = &webhook.UserInfo{
+			Email:    user.Identity.Email,
+			Username: user.Attributes["username"],
+			Name:     user.Identity.Name,
+			Subject:  user.Identity.Subject,
+			Provider: user.Identity.Provider,
+			Groups:   shadowGroups(user),
+		}
+	}
+
+	result, err := s.provisionUser(ctx, info)
+	var b strings.Builder
+	fmt.Fprintf(&b, "Sync of %s: **%s**", info.Email, result.summary())
+	if err != nil {
+		if appErr := (*apperror.Error)(nil); errors.As(err, &appErr) {
+			fmt.Fprintf(&b, " (%s)", appErr.Message)
+		} else {
+			fmt.Fprintf(&b, " (%s)", errorText(err))
+		}
+	}
+	b.WriteString("\n")
+	for _, target := range result.Targets {
+		fmt.Fprintf(&b, "* %s: %s", target.Provisioner, target.Status)
+		if target.Error != "" {
+			fmt.Fprintf(&b, ": %s", target.Error)
+		}
+		b.WriteString("\n")
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+func (s *Server) commandStatus() string {
+	var b strings.Builder
+	b.WriteString("#### auth-manager status\n")
+	states := s.breakerStates()
+	names := make([]string, 0, len(states))
+	for name := range states {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	b.WriteString("| Breaker | State | Last failure |\n|---|---|---|\n")
+	for _, name := range names {
+		state := states[name]
+		status := state.State
+		if state.Remaining != "" {
+			status += " (" + state.Remaining + ")"
+		}
+		fmt.Fprintf(&b, "| %s | %s | %s |\n", name, status, state.LastFailure)
+	}
+
+	fmt.Fprintf(&b, "\n**Warm-up:** %s\n", s.warm.status().State)
+	st := s.reconcileState
+	st.mu.Lock()
+	switch {
+	case s.authentikClient == nil:
+		b.WriteString("**Reconcile:** not configured\n")
+	case st.running:
+		b.WriteString("**Reconcile:** running\n")
+	case st.last != nil:
+		fmt.Fprintf(&b, "**Reconcile:** last run %s, %d errors\n", st.last.FinishedAt.UTC().Format(time.RFC3339), st.last.Errors)
+	default:
+		b.WriteString("**Reconcile:** not run yet\n")
+	}
+	st.mu.Unlock()
+	if a := s.opsAlerts; a != nil {
+		a.mu.Lock()
+		held := len(a.pending)
+		a.mu.Unlock()
+		fmt.Fprintf(&b, "**Operator alerts held:** %d\n", held)
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// shadowGroups returns the groups recorded on user, or nil when none were.
+func shadowGroups(user shadow.ShadowUser) []string {
+	raw, ok := user.Attributes[attrGroups]
+	if !ok {
+		return nil
+	}
+	groups := []string{}
+	for _, group := range strings.Split(raw, ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
+
+// groupsAttribute formats groups for attrGroups.
+func groupsAttribute(groups []string) string {
+	return strings.Join(groups, ",")
+}
@@ -0,0 +1,285 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/apperror"
+	"github.com/rave-org/rave/apps/auth-manager/internal/mattermost"
+	"github.com/rave-org/rave/apps/auth-manager/internal/shadow"
+)
+
+// attrMattermostTeams records, comma-separated, the Mattermost teams the
+// user is on because of their groups. Removal only ever touches these, so
+// memberships added by hand or by the defaults are left alone.
+const attrMattermostTeams = "mattermost_teams"
+
+// teamReconcileTimeout bounds a full team sweep over every shadow user.
+const teamReconcileTimeout = 10 * time.Minute
+
+// teamSyncLine is what syncing one user's team memberships changed, or in
+// dry-run mode would change. It's also one line of the streamed sweep.
+type teamSyncLine struct {
+	Email   string   `json:"email,omitempty"`
+	UserID  string   `json:"mattermost_user_id"`
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// teamReconcileSummary is the final line of the sweep stream.
+type teamReconcileSummary struct {
+	Done    bool   `json:"done"`
+	DryRun  bool   `json:"dry_run"`
+	Users   int    `json:"users"`
+	Added   int    `json:"added"`
+	Removed int    `json:"removed"`
+	Errors  int    `json:"errors"`
+	Error   string `json:"error,omitempty"`
+}
+
+// teamSyncEnabled reports whether any group maps to a Mattermost team.
+func (s *Server) teamSyncEnabled() bool {
+	return len(s.teamMap) > 0 || s.cfg.MattermostTeamGroupPrefix != ""
+}
+
+// desiredTeams returns the Mattermost teams groups map to, through
+// MattermostTeamMap and MattermostTeamGroupPrefix, in group order.
+func (s *Server) desiredTeams(groups []string) []string {
+	var teams []string
+	add := func(team string) {
+		if team != "" && !slices.Contains(teams, team) {
+			teams = append(teams, team)
+		}
+	}
+	prefix := s.cfg.MattermostTeamGroupPrefix
+	for _, group := range groups {
+		for _, team := range s.teamMap[group] {
+			add(team)
+		}
+		if prefix != "" && strings.HasPrefix(group, prefix) {
+			add(strings.ToLower(strings.TrimPrefix(group, prefix)))
+		}
+	}
+	return teams
+}
+
+// protectedTeam reports whether team sync must never remove users from
+// team: the protected teams, and the default and guest teams everyone is
+// joined to regardless of groups.
+func (s *Server) protectedTeam(team string) bool {
+	for _, list := range [][]string{s.cfg.MattermostProtectedTeams, s.cfg.MattermostDefaultTeams, s.cfg.MattermostGuestTeams} {
+		for _, protected := range list {
+			if strings.EqualFold(protected, team) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// syncShadowUserTeams syncs a Mattermost user's team memberships with their
+// groups and records the teams the groups put them on. Groups must be
+// known: a nil slice would read as leaving every group.
+func (s *Server) syncShadowUserTeams(ctx context.Context, user shadow.ShadowUser, userID string, groups []string, dryRun bool) teamSyncLine {
+	var recorded []string
+	if raw := user.Attributes[attrMattermostTeams]; raw != "" {
+		recorded = strings.Split(raw, ",")
+	}
+	line, granted := s.syncMattermostTeams(ctx, userID, groups, recorded, dryRun)
+	line.Email = user.Identity.Email
+	if dryRun || granted == nil {
+		return line
+	}
+	if value := strings.Join(granted, ","); value != user.Attributes[attrMattermostTeams] {
+		if _, err := s.upsertShadow(ctx, user.Identity, map[string]string{attrMattermostTeams: value}); err != nil {
+			s.logger.WarnContext(ctx, "failed to record mattermost teams", "email", user.Identity.Email, "err", err)
+		}
+	}
+	return line
+}
+
+// syncMattermostTeams joins the user to the teams their groups map to and,
+// with MattermostTeamRemoval, removes them from teams in recorded, the ones
+// groups put them on earlier, that no group maps to any more. Protected
+// teams are never left. It returns the teams groups now put the user on,
+// which is nil when their teams couldn't be listed. Failures are logged,
+// counted, and reported on the line, and never stop the other changes.
+func (s *Server) syncMattermostTeams(ctx context.Context, userID string, groups, recorded []string, dryRun bool) (teamSyncLine, []string) {
+	line := teamSyncLine{UserID: userID}
+	teams, err := s.mattermost().ListTeamsForUser(ctx, userID)
+	if err != nil {
+		s.recordMattermostFailure(mmOpEnsureUser, err)
+		s.teamSyncChanges.WithLabelValues("list", "failed").Inc()
+		s.logger.WarnContext(ctx, "failed to list mattermost teams", "user_id", userID, "err", err)
+		line.Error = errorText(err)
+		return line, nil
+	}
+	s.recordMattermostSuccess(mmOpEnsureUser)
+	current := map[string]string{} // Team name → ID
+	for _, team := range teams {
+		current[strings.ToLower(team.Name)] = team.ID
+	}
+
+	var errs []error
+	fail := func(action, team string, err error) {
+		s.teamSyncChanges.WithLabelValues(action, "failed").Inc()
+		s.logger.WarnContext(ctx, "mattermost team sync failed", "action", action, "team", team, "user_id", userID, "err", err)
+		errs = append(errs, fmt.Errorf("%s team %s: %w", action, team, err))
+	}
+
+	desired := s.desiredTeams(groups)
+	var granted []string
+	for _, name := range desired {
+		if _, ok := current[name]; ok {
+			granted = append(granted, name)
+			continue
+		}
+		if dryRun {
+			line.Added = append(line.Added, name)
+			continue
+		}
+		team, err := s.mattermost().GetTeamByName(ctx, name)
+		if err == nil {
+			err = s.mattermost().AddUserToTeam(ctx, team.ID, userID)
+		}
+		if errors.Is(err, mattermost.ErrNotFound) {
+			s.logger.WarnContext(ctx, "mapped mattermost team does not exist", "team", name)
+		}
+		if err != nil {
+			s.recordMattermostFailure(mmOpEnsureUser, err)
+			fail("add", name, err)
+			continue
+		}
+		s.teamSyncChanges.WithLabelValues("add", "ok").Inc()
+		s.logger.InfoContext(ctx, "user added to mattermost team", "team", name, "user_id", userID, "source", "groups")
+		line.Added = append(line.Added, name)
+		granted = append(granted, name)
+	}
+
+	for _, name := range recorded {
+		teamID, ok := current[name]
+		if !ok || slices.Contains(desired, name) {
+			continue
+		}
+		if !s.cfg.MattermostTeamRemoval || s.protectedTeam(name) {
+			// Still on the team because of a group, should removal be
+			// turned on or the team's protection lifted later.
+			granted = append(granted, name)
+			continue
+		}
+		if dryRun {
+			line.Removed = append(line.Removed, name)
+			continue
+		}
+		if err := s.mattermost().RemoveUserFromTeam(ctx, teamID, userID); err != nil {
+			s.recordMattermostFailure(mmOpEnsureUser, err)
+			fail("remove", name, err)
+			granted = append(granted, name)
+			continue
+		}
+		s.teamSyncChanges.WithLabelValues("remove", "ok").Inc()
+		s.logger.InfoContext(ctx, "user removed from mattermost team", "team", name, "user_id", userID, "reason", "no mapped group")
+		line.Removed = append(line.Removed, name)
+	}
+	if err := errors.Join(errs...); err != nil {
+		line.Error = errorText(err)
+	}
+	sort.Strings(granted)
+	if granted == nil {
+		granted = []string{}
+	}
+	return line, granted
+}
+
+// handleTeamReconcile syncs the Mattermost teams of every shadow user with a
+// recorded Mattermost ID and groups, like provisioning does for one user.
+// Guests are skipped: they only ever get the guest teams. Progress is
+// streamed as NDJSON, one line per user and a final summary line.
+func (s *Server) handleTeamReconcile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		s.respondError(w, r, apperror.MethodNotAllowed)
+		return
+	}
+	if s.mattermost() == nil {
+		s.respondError(w, r, notConfigured("mattermost not configured"))
+		return
+	}
+	if !s.teamSyncEnabled() {
+		s.respondError(w, r, notConfigured("no groups map to mattermost teams; set AUTH_MANAGER_MATTERMOST_TEAM_MAP or AUTH_MANAGER_MATTERMOST_TEAM_GROUP_PREFIX"))
+		return
+	}
+
+	var payload struct {
+		DryRun bool `json:"dry_run"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil && !errors.Is(err, io.EOF) {
+		s.respondBodyError(w, r, err)
+		return
+	}
+	dryRun := payload.DryRun || s.cfg.DryRun
+
+	ctx, cancel := context.WithTimeout(r.Context(), teamReconcileTimeout)
+	defer cancel()
+
+	users, err := s.shadowStore.List(ctx)
+	if err != nil {
+		s.respondError(w, r, storeUnavailable(err))
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Now().Add(teamReconcileTimeout + 5*time.Second))
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+
+	summary := teamReconcileSummary{DryRun: dryRun}
+	for _, user := range users {
+		userID, groups := user.Attributes["mattermost_user_id"], shadowGroups(user)
+		if userID == "" || groups == nil {
+			continue
+		}
+		if guest, _ := s.guestTier(groups); guest {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			summary.Error = err.Error()
+			break
+		}
+		if !s.mmBreakers.Get(mmOpEnsureUser).Allow() {
+			summary.Error = "mattermost circuit open"
+			break
+		}
+
+		line := s.syncShadowUserTeams(ctx, user, userID, groups, dryRun)
+		summary.Users++
+		summary.Added += len(line.Added)
+		summary.Removed += len(line.Removed)
+		if line.Error != "" {
+			summary.Errors++
+		}
+		_ = enc.Encode(line)
+		_ = rc.Flush()
+	}
+
+	summary.Done = summary.Error == ""
+	s.logger.InfoContext(ctx, "mattermost team reconcile finished",
+		"dry_run", summary.DryRun,
+		"users", summary.Users,
+		"added", summary.Added,
+		"removed", summary.Removed,
+		"errors", summary.Errors,
+		"err", summary.Error,
+	)
+	_ = enc.Encode(summary)
+}
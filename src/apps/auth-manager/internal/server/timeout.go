@@ -0,0 +1,125 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/apperror"
+)
+
+// Default request budgets, for zero Config values.
+const (
+	defaultForwardAuthTimeout = 3 * time.Second
+	defaultWebhookTimeout     = 10 * time.Second
+	defaultRequestTimeout     = 10 * time.Second
+)
+
+// requestBudget is how long a request to the route pattern may take, or 0
+// for the routes that manage their own deadlines. Forward auth is short
+// because Traefik gives up after about 3s anyway.
+func (s *Server) requestBudget(pattern string) time.Duration {
+	orDefault := func(d, fallback time.Duration) time.Duration {
+		if d > 0 {
+			return d
+		}
+		return fallback
+	}
+	switch {
+	case pattern == "/api/v1/reconcile" || pattern == "/api/v1/mattermost/sessions/cleanup" || pattern == "/api/v1/mattermost/teams/reconcile" ||
+		pattern == "/api/v1/admin/remap":
+		return 0
+	case strings.HasPrefix(pattern, "/auth/"):
+		return orDefault(s.cfg.ForwardAuthTimeout, defaultForwardAuthTimeout)
+	case strings.HasPrefix(pattern, "/webhook/"):
+		return orDefault(s.cfg.WebhookTimeout, defaultWebhookTimeout)
+	default:
+		return orDefault(s.cfg.RequestTimeout, defaultRequestTimeout)
+	}
+}
+
+// withTimeout runs next with a context that ends after budget. If next
+// hasn't finished by then its output is dropped and the caller gets a 504
+// deadline_exceeded; next sees its context canceled, so its downstream
+// calls stop too. Like http.TimeoutHandler, the response is buffered, so
+// next can't stream.
+func (s *Server) withTimeout(budget time.Duration, next http.Handler) http.Handler {
+	if budget <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), budget)
+		defer cancel()
+		r = r.WithContext(ctx)
+
+		tw := &timeoutWriter{header: http.Header{}}
+		done := make(chan struct{})
+		panicked := make(chan any, 1)
+		go func() {
+			defer func() {
+				if p := recover(); p != nil {
+					panicked <- p
+				}
+			}()
+			next.ServeHTTP(tw, r)
+			close(done)
+		}()
+
+		select {
+		case p := <-panicked:
+			panic(p)
+		case <-done:
+			tw.mu.Lock()
+			defer tw.mu.Unlock()
+			for k, v := range tw.header {
+				w.Header()[k] = v
+			}
+			if tw.status == 0 {
+				tw.status = http.StatusOK
+			}
+			w.WriteHeader(tw.status)
+			w.Write(tw.buf.Bytes())
+		case <-ctx.Done():
+			tw.mu.Lock()
+			defer tw.mu.Unlock()
+			tw.timedOut = true
+			s.logger.WarnContext(r.Context(), "request budget exceeded", "path", r.URL.Path, "budget", budget)
+			s.respondError(w, r, apperror.Wrap(ctx.Err(), apperror.DeadlineExceeded.Status, apperror.CodeDeadlineExceeded, "request took longer than "+budget.String()))
+		}
+	})
+}
+
+// timeoutWriter buffers a response for withTimeout, and drops writes once
+// the budget has passed.
+type timeoutWriter struct {
+	mu       sync.Mutex
+	header   http.Header
+	buf      bytes.Buffer
+	status   int
+	timedOut bool
+}
+
+func (w *timeoutWriter) Header() http.Header { return w.header }
+
+func (w *timeoutWriter) WriteHeader(status int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.status == 0 && !w.timedOut {
+		w.status = status
+	}
+}
+
+func (w *timeoutWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.buf.Write(b)
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/config"
+	"github.com/rave-org/rave/apps/auth-manager/internal/httpx"
+	"github.com/rave-org/rave/apps/auth-manager/internal/tracing"
+)
+
+// newTracer builds the tracer exporting to cfg.OTLPEndpoint, or returns nil
+// when tracing is off. The exporter's own requests aren't traced.
+func (s *Server) newTracer(cfg config.Config, opts httpx.Options) *tracing.Tracer {
+	if cfg.OTLPEndpoint == "" {
+		return nil
+	}
+	exporter, err := tracing.NewOTLPExporter(cfg.OTLPEndpoint, "auth-manager", httpx.NewClient(opts), s.logger)
+	if err != nil {
+		s.logger.Error("invalid OTLP endpoint, tracing disabled", "err", err)
+		return nil
+	}
+	s.logger.Info("tracing enabled", "otlp_endpoint", cfg.Redacted().OTLPEndpoint)
+	return tracing.New(exporter)
+}
+
+// clientOptions returns base for service's API client: its calls are
+// counted, and traced when tracing is on.
+func (s *Server) clientOptions(base httpx.Options, service string) httpx.Options {
+	base.Record = s.recordDownstream(service)
+	if s.tracer != nil {
+		base.TraceService = service
+	}
+	return base
+}
+
+// trace runs each request in a server span named for its route, continuing
+// the caller's trace when it sent a traceparent. Without a tracer it only
+// checks for one.
+func (s *Server) trace(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.tracer == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx := r.Context()
+		if remote, ok := tracing.ParseTraceparent(r.Header.Get(tracing.TraceparentHeader)); ok {
+			ctx = tracing.WithRemoteParent(ctx, remote)
+		}
+		route := metricsRoute(mux, r)
+		ctx, span := s.tracer.Start(ctx, r.Method+" "+route, tracing.KindServer)
+		defer span.End()
+		span.SetAttribute("http.request.method", r.Method)
+		span.SetAttribute("http.route", route)
+		span.SetAttribute("request_id", httpx.RequestID(ctx))
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		span.SetAttribute("http.response.status_code", rec.status)
+		if rec.status >= http.StatusInternalServerError {
+			span.SetError(httpStatusError(rec.status))
+		}
+	})
+}
+
+// httpStatusError is the error a server span records for a 5xx response.
+type httpStatusError int
+
+func (e httpStatusError) Error() string { return http.StatusText(int(e)) }
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/identity"
+)
+
+// proxySecretHeader carries AUTH_MANAGER_PROXY_SECRET from the proxy.
+const proxySecretHeader = "X-Auth-Manager-Proxy-Secret"
+
+// trustProxies rejects requests that carry identity headers but didn't come
+// through a trusted proxy: anything that can reach the listener directly
+// could otherwise claim any identity.
+func (s *Server) trustProxies(next http.Handler) http.Handler {
+	if len(s.trustedProxies) == 0 && s.cfg.ProxySecret == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if identity.HasHeaders(r) && !s.fromTrustedProxy(r) {
+			s.logger.WarnContext(r.Context(), "identity headers from untrusted source",
+				"remote", r.RemoteAddr, "client", s.clientIP(r), "path", r.URL.Path)
+			http.Error(w, "Forbidden - untrusted proxy", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// fromTrustedProxy reports whether r's immediate peer is a trusted proxy or
+// r carries the proxy secret.
+func (s *Server) fromTrustedProxy(r *http.Request) bool {
+	if s.cfg.ProxySecret != "" {
+		if got := r.Header.Get(proxySecretHeader); got != "" && subtle.ConstantTimeCompare([]byte(got), []byte(s.cfg.ProxySecret)) == 1 {
+			return true
+		}
+	}
+	peer, ok := remoteAddr(r)
+	return ok && s.trustedProxy(peer)
+}
+
+func (s *Server) trustedProxy(addr netip.Addr) bool {
+	for _, prefix := range s.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP is the originating client of r. X-Forwarded-For is only honored
+// as far back as the hops appending to it are trusted proxies; the first
+// untrusted hop is the client.
+func (s *Server) clientIP(r *http.Request) string {
+	peer, ok := remoteAddr(r)
+	if !ok {
+		return r.RemoteAddr
+	}
+	var hops []string
+	for _, value := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(value, ",")...)
+	}
+	client := peer
+	for i := len(hops) - 1; i >= 0 && s.trustedProxy(client); i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		client = hop.Unmap()
+	}
+	return client.String()
+}
+
+func remoteAddr(r *http.Request) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap().WithZone(""), true
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// userFilter reports why the email domain or group lists refuse a user, and
+// which list did ("email_domain" or "group"), or "" when neither does.
+func (s *Server) userFilter(email string, groups []string) (list, reason string) {
+	if reason := s.emailDomainFilter(email); reason != "" {
+		return "email_domain", reason
+	}
+	if reason := s.groupFilter(groups); reason != "" {
+		return "group", reason
+	}
+	return "", ""
+}
+
+// emailDomainFilter reports why email's domain isn't allowed, or "" when it
+// is or no domains are configured.
+func (s *Server) emailDomainFilter(email string) string {
+	if len(s.emailDomains) == 0 {
+		return ""
+	}
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return "email has no domain"
+	}
+	if domainAllowed(email[at+1:], s.emailDomains) {
+		return ""
+	}
+	return fmt.Sprintf("email domain %q not allowed", email[at+1:])
+}
+
+// groupFilter reports why a user's groups keep them from being provisioned
+// or let through forward auth, or "" when they don't. A denied group wins
+// over an allowed one. With an allowlist, unknown groups (nil) are filtered:
+// membership can't be shown.
+func (s *Server) groupFilter(groups []string) string {
+	for _, group := range groups {
+		for _, denied := range s.cfg.DeniedGroups {
+			if strings.EqualFold(group, denied) {
+				return fmt.Sprintf("member of denied group %q", group)
+			}
+		}
+	}
+	if len(s.cfg.AllowedGroups) == 0 {
+		return ""
+	}
+	for _, group := range groups {
+		for _, allowed := range s.cfg.AllowedGroups {
+			if strings.EqualFold(group, allowed) {
+				return ""
+			}
+		}
+	}
+	if groups == nil {
+		return "groups unknown"
+	}
+	return "not a member of an allowed group"
+}
+
+// filterAuthErrors are the X-Rave-Auth-Error values for each userFilter list.
+var filterAuthErrors = map[string]string{
+	"email_domain": "email-domain-not-allowed",
+	"group":        "group-filtered",
+}
+
+// respondFiltered answers a forward-auth request from a filtered user. The
+// body reaches the browser through Traefik, so it's HTML unless the client
+// asked for JSON.
+func (s *Server) respondFiltered(w http.ResponseWriter, r *http.Request, service, list, reason string) {
+	w.Header().Set("X-Rave-Auth-Error", filterAuthErrors[list])
+	if strings.Contains(strings.ToLower(r.Header.Get("Accept")), "json") {
+		s.respondJSON(w, http.StatusForbidden, map[string]string{
+			"error":  "access denied",
+			"reason": reason,
+		})
+		return
+	}
+	s.respondErrorPage(w, r, http.StatusForbidden, errorPage{
+		Title:   "Access denied",
+		Message: fmt.Sprintf("Your account isn't allowed to use %s (%s). Ask an administrator for access.", service, reason),
+		Code:    filterAuthErrors[list],
+	})
+}
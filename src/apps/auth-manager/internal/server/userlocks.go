@@ -0,0 +1,50 @@
+package server
+
+import (
+	"sync"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/accounts"
+)
+
+// userLocks serializes provisioning per identity so concurrent webhooks and
+// forward-auth requests for a brand-new user don't both create it in
+// Mattermost. Locks are reference counted and dropped once released.
+type userLocks struct {
+	mu    sync.Mutex
+	locks map[string]*userLock
+}
+
+type userLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+func newUserLocks() *userLocks {
+	return &userLocks{locks: make(map[string]*userLock)}
+}
+
+// lock blocks until the caller holds the lock for email, which is
+// compared normalized, and returns the matching unlock function.
+func (l *userLocks) lock(email string) (unlock func()) {
+	key := accounts.NormalizeEmail(email)
+
+	l.mu.Lock()
+	ul, ok := l.locks[key]
+	if !ok {
+		ul = &userLock{}
+		l.locks[key] = ul
+	}
+	ul.refs++
+	l.mu.Unlock()
+
+	ul.mu.Lock()
+	return func() {
+		ul.mu.Unlock()
+		l.mu.Lock()
+		ul.refs--
+		if ul.refs == 0 {
+			delete(l.locks, key)
+		}
+		l.mu.Unlock()
+	}
+}
@@ -0,0 +1,263 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/accounts"
+	"github.com/rave-org/rave/apps/auth-manager/internal/apperror"
+	"github.com/rave-org/rave/apps/auth-manager/internal/breaker"
+	"github.com/rave-org/rave/apps/auth-manager/internal/httpx"
+	"github.com/rave-org/rave/apps/auth-manager/internal/mattermost"
+	"github.com/rave-org/rave/apps/auth-manager/internal/n8n"
+	"github.com/rave-org/rave/apps/auth-manager/internal/shadow"
+)
+
+// History event types besides the outbound event types, which are recorded
+// under their own names.
+const (
+	historyFiltered     = "user.filtered"
+	historyDeferred     = "user.deferred"
+	historySignInFailed = "mattermost.sign_in_failed"
+)
+
+const (
+	// historyPerUser is how many events are kept for each user.
+	historyPerUser = 10
+	// maxHistoryUsers bounds the users with history; past it the history is
+	// forgotten and starts over.
+	maxHistoryUsers = 1000
+)
+
+// statusLookupTimeout bounds each downstream lookup of the status endpoint,
+// so one slow service doesn't hold up the rest.
+var statusLookupTimeout = 2 * time.Second
+
+// Lookup statuses of the status endpoint.
+const (
+	lookupFound         = "found"
+	lookupNotFound      = "not_found"
+	lookupNotConfigured = "not_configured"
+	lookupCircuitOpen   = "unknown (circuit open)"
+	lookupTimeout       = "unknown (timeout)"
+	lookupError         = "error"
+)
+
+// historyEvent is something that happened to a user's accounts.
+type historyEvent struct {
+	Time      time.Time `json:"time"`
+	Type      string    `json:"type"`
+	RequestID string    `json:"request_id,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// userHistory keeps each user's most recent events in memory, so support
+// can see what happened to them without searching the logs. It starts empty
+// on every restart.
+type userHistory struct {
+	mu     sync.Mutex
+	events map[string][]historyEvent // By normalized email, oldest first
+}
+
+func newUserHistory() *userHistory {
+	return &userHistory{events: map[string][]historyEvent{}}
+}
+
+func (h *userHistory) record(email string, event historyEvent) {
+	email = accounts.NormalizeEmail(email)
+	if email == "" {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	events, ok := h.events[email]
+	if !ok && len(h.events) >= maxHistoryUsers {
+		clear(h.events)
+	}
+	if len(events) >= historyPerUser {
+		// Copy, so the backing array doesn't grow without bound.
+		events = slices.Clone(events[1:])
+	}
+	h.events[email] = append(events, event)
+}
+
+// recent returns email's events, newest first.
+func (h *userHistory) recent(email string) []historyEvent {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	events := h.events[accounts.NormalizeEmail(email)]
+	recent := make([]historyEvent, 0, len(events))
+	for i := len(events) - 1; i >= 0; i-- {
+		recent = append(recent, events[i])
+	}
+	return recent
+}
+
+// recordUserHistory records an event of eventType to email's history.
+func (s *Server) recordUserHistory(ctx context.Context, email, eventType string, err error) {
+	if s.userHistory == nil {
+		return
+	}
+	s.userHistory.record(email, historyEvent{
+		Time:      time.Now().UTC(),
+		Type:      eventType,
+		RequestID: httpx.RequestID(ctx),
+		Error:     eventError(err),
+	})
+}
+
+// eventError is err as events report it: an API error's caller-safe
+// message, or otherwise the redacted error.
+func eventError(err error) string {
+	if err == nil {
+		return ""
+	}
+	if appErr := (*apperror.Error)(nil); errors.As(err, &appErr) {
+		return appErr.Message
+	}
+	return errorText(err)
+}
+
+// accountStatus is what a live lookup found of a user's account in a
+// downstream service.
+type accountStatus struct {
+	// Status is found, not_found, not_configured, error, "unknown
+	// (circuit open)", or "unknown (timeout)".
+	Status   string `json:"status"`
+	UserID   string `json:"user_id,omitempty"`
+	Username string `json:"username,omitempty"`
+	Roles    string `json:"roles,omitempty"`
+	Active   *bool  `json:"active,omitempty"`
+	Pending  *bool  `json:"pending,omitempty"` // n8n: invited, not signed up
+	Error    string `json:"error,omitempty"`
+}
+
+// userStatus is everything auth-manager knows about one user's provisioning.
+type userStatus struct {
+	Email       string              `json:"email"`
+	ShadowUsers []shadow.ShadowUser `json:"shadow_users"`
+	ShadowError string              `json:"shadow_error,omitempty"`
+	// Filtered is why AUTH_MANAGER_ALLOWED_EMAIL_DOMAINS or the group
+	// filters would refuse the user, by their recorded groups.
+	Filtered   string                  `json:"filtered,omitempty"`
+	Mattermost accountStatus           `json:"mattermost"`
+	N8N        accountStatus           `json:"n8n"`
+	Breakers   map[string]breakerState `json:"breakers"`
+	// BlockedBy names the open breakers refusing provisioning calls.
+	BlockedBy []string       `json:"blocked_by"`
+	History   []historyEvent `json:"history"`
+}
+
+// handleUserStatus serves GET /api/v1/users/{email}/status: the user's
+// shadow records, live lookups of their Mattermost and n8n accounts, the
+// breakers that would block provisioning them, and their recent history.
+// Lookups run in parallel, each under statusLookupTimeout; one that fails
+// or times out is reported as such rather than failing the request.
+func (s *Server) handleUserStatus(w http.ResponseWriter, r *http.Request) {
+	email, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/api/v1/users/"), "/status")
+	if !ok || email == "" || strings.Contains(email, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		s.respondError(w, r, apperror.MethodNotAllowed)
+		return
+	}
+	includeSensitive, err := s.includeSensitive(r)
+	if err != nil {
+		s.respondError(w, r, err)
+		return
+	}
+	ctx := r.Context()
+	status := userStatus{Email: accounts.NormalizeEmail(email), ShadowUsers: []shadow.ShadowUser{}}
+
+	mm, n8nClient := s.mattermost(), s.n8nAPI()
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		status.Mattermost = s.lookupAccount(ctx, s.mmBreakers.Get(mmOpEnsureUser), mm != nil, func(ctx context.Context) (accountStatus, error) {
+			user, err := mm.GetUserByEmail(ctx, status.Email)
+			active := user.DeleteAt == 0
+			return accountStatus{UserID: user.ID, Username: user.Username, Roles: user.Roles, Active: &active}, err
+		})
+	}()
+	go func() {
+		defer wg.Done()
+		status.N8N = s.lookupAccount(ctx, s.n8nBreaker, n8nClient != nil, func(ctx context.Context) (accountStatus, error) {
+			user, err := n8nClient.GetUserByEmail(ctx, status.Email)
+			active := !user.Disabled
+			return accountStatus{UserID: user.ID, Roles: user.Role, Active: &active, Pending: &user.IsPending}, err
+		})
+	}()
+
+	users, err := s.shadowUsersByEmail(ctx, status.Email)
+	if err != nil {
+		s.logger.WarnContext(ctx, "user status: shadow store unavailable", "email", status.Email, "err", err)
+		status.ShadowError = "shadow store unavailable"
+	}
+	for _, user := range users {
+		if !includeSensitive {
+			s.redactSensitive(user.Attributes)
+		}
+		status.ShadowUsers = append(status.ShadowUsers, user)
+	}
+	var groups []string
+	for _, user := range users {
+		if groups = shadowGroups(user); groups != nil {
+			break
+		}
+	}
+	_, status.Filtered = s.userFilter(status.Email, groups)
+
+	status.Breakers = map[string]breakerState{}
+	status.BlockedBy = []string{}
+	for name, b := range s.breakers() {
+		if name == "alerts" {
+			continue // Alerts aren't provisioning
+		}
+		state := breakerStatus(b)
+		status.Breakers[name] = state
+		if b.Snapshot().State == breaker.Open {
+			status.BlockedBy = append(status.BlockedBy, name)
+		}
+	}
+	sort.Strings(status.BlockedBy)
+	status.History = s.userHistory.recent(status.Email)
+
+	wg.Wait()
+	s.respondJSON(w, http.StatusOK, status)
+}
+
+// lookupAccount runs lookup under statusLookupTimeout. It doesn't call an
+// unconfigured service or one whose breaker is open, and leaves the breaker
+// alone: support looking a user up isn't provisioning traffic.
+func (s *Server) lookupAccount(ctx context.Context, b *breaker.Breaker, configured bool, lookup func(context.Context) (accountStatus, error)) accountStatus {
+	if !configured {
+		return accountStatus{Status: lookupNotConfigured}
+	}
+	if b.Snapshot().State == breaker.Open {
+		return accountStatus{Status: lookupCircuitOpen}
+	}
+	ctx, cancel := context.WithTimeout(ctx, statusLookupTimeout)
+	defer cancel()
+	status, err := lookup(ctx)
+	switch {
+	case err == nil:
+		status.Status = lookupFound
+	case errors.Is(err, mattermost.ErrNotFound), errors.Is(err, n8n.ErrNotFound):
+		status = accountStatus{Status: lookupNotFound}
+	case ctx.Err() != nil:
+		status = accountStatus{Status: lookupTimeout}
+	default:
+		status = accountStatus{Status: lookupError, Error: errorText(err)}
+	}
+	return status
+}
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// warmupRetryAfter is the Retry-After sent to forward-auth callers turned
+// away during warm-up, unless the deadline is sooner.
+const warmupRetryAfter = 5 * time.Second
+
+// warmup tracks the dependency probes run at startup. Until every probe has
+// passed or the deadline has passed, /readyz answers 503 and forward auth
+// turns users away with Retry-After, so a slow dependency doesn't count
+// failures against the breakers before it has had a chance to come up.
+type warmup struct {
+	interval     time.Duration // between rounds of probes
+	probeTimeout time.Duration // for a single probe
+
+	mu       sync.Mutex
+	state    string // "disabled", "warming_up", "complete", or "expired"
+	deadline time.Time
+	probes   map[string]probeState
+}
+
+// probeState is a warm-up probe's last result.
+type probeState struct {
+	Status string `json:"status"` // "pending", "ok", or "error"
+	Error  string `json:"error,omitempty"`
+}
+
+// warmupStatus is the warm-up summary reported by /healthz and /readyz.
+type warmupStatus struct {
+	State    string                `json:"state"`
+	Deadline string                `json:"deadline,omitempty"`
+	Probes   map[string]probeState `json:"probes,omitempty"`
+}
+
+func newWarmup() *warmup {
+	return &warmup{interval: time.Second, probeTimeout: 2 * time.Second, state: "disabled"}
+}
+
+// warming reports whether warm-up is still running, and if so how long
+// callers should wait before retrying.
+func (w *warmup) warming() (bool, time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.state != "warming_up" {
+		return false, 0
+	}
+	return true, min(warmupRetryAfter, time.Until(w.deadline))
+}
+
+func (w *warmup) status() warmupStatus {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	status := warmupStatus{State: w.state}
+	if w.state == "disabled" {
+		return status
+	}
+	status.Deadline = w.deadline.UTC().Format(time.RFC3339)
+	status.Probes = make(map[string]probeState, len(w.probes))
+	for name, probe := range w.probes {
+		status.Probes[name] = probe
+	}
+	return status
+}
+
+// warmupProbes are the dependencies warm-up waits for: the shadow store, and
+// Mattermost and n8n when they are configured. The probes call the clients
+// directly, bypassing the breakers.
+func (s *Server) warmupProbes() map[string]func(ctx context.Context) error {
+	probes := map[string]func(ctx context.Context) error{}
+	if s.shadowStore != nil {
+		probes["store"] = s.shadowStore.HealthCheck
+	}
+	if s.mattermost() != nil {
+		probes["mattermost"] = func(ctx context.Context) error { return s.mattermost().Ping(ctx) }
+	}
+	if s.n8nAPI() != nil {
+		// Logs in as the owner when n8n is configured with a password.
+		probes["n8n"] = func(ctx context.Context) error { return s.n8nAPI().Ping(ctx) }
+	}
+	return probes
+}
+
+// startWarmup probes the dependencies until they all pass or timeout
+// elapses. A zero timeout skips warm-up.
+func (s *Server) startWarmup(timeout time.Duration) {
+	probes := s.warmupProbes()
+	if timeout <= 0 || len(probes) == 0 {
+		return
+	}
+	w := s.warm
+	w.mu.Lock()
+	w.state = "warming_up"
+	w.deadline = time.Now().Add(timeout)
+	w.probes = make(map[string]probeState, len(probes))
+	for name := range probes {
+		w.probes[name] = probeState{Status: "pending"}
+	}
+	w.mu.Unlock()
+
+	err := s.lifecycle.goWorker("warmup", func(ctx context.Context) {
+		s.runWarmup(ctx, probes)
+	})
+	if err != nil {
+		w.finish("disabled")
+	}
+}
+
+// runWarmup runs rounds of the probes that haven't passed yet.
+func (s *Server) runWarmup(ctx context.Context, probes map[string]func(ctx context.Context) error) {
+	w := s.warm
+	start := time.Now()
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		if w.probe(ctx, probes) {
+			w.finish("complete")
+			s.logger.Info("warm-up complete", "elapsed", time.Since(start).Round(time.Millisecond))
+			return
+		}
+		if !time.Now().Before(w.deadline) {
+			w.finish("expired")
+			var failing []string
+			for name, probe := range w.status().Probes {
+				if probe.Status != "ok" {
+					failing = append(failing, name+": "+probe.Error)
+				}
+			}
+			sort.Strings(failing)
+			s.logger.Warn("warm-up deadline passed with dependencies still failing; serving anyway", "failing", failing)
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// probe runs each pending or failing probe once, reporting whether they
+// have all passed.
+func (w *warmup) probe(ctx context.Context, probes map[string]func(ctx context.Context) error) bool {
+	names := make([]string, 0, len(probes))
+	for name := range probes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	passed := true
+	for _, name := range names {
+		w.mu.Lock()
+		done := w.probes[name].Status == "ok"
+		w.mu.Unlock()
+		if done {
+			continue
+		}
+		probeCtx, cancel := context.WithTimeout(ctx, w.probeTimeout)
+		err := probes[name](probeCtx)
+		cancel()
+
+		result := probeState{Status: "ok"}
+		if err != nil {
+			result = probeState{Status: "error", Error: errorText(err)}
+			passed = false
+		}
+		w.mu.Lock()
+		w.probes[name] = result
+		w.mu.Unlock()
+	}
+	return passed
+}
+
+func (w *warmup) finish(state string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.state = state
+}
+
+// rejectWarmingUp answers 503 with Retry-After during warm-up, reporting
+// whether it did.
+func (s *Server) rejectWarmingUp(w http.ResponseWriter, r *http.Request, service string) bool {
+	warming, retry := s.warm.warming()
+	if !warming {
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(max(1, int(math.Ceil(retry.Seconds())))))
+	w.Header().Set("X-Rave-Auth-Error", "warming-up")
+	s.logger.DebugContext(r.Context(), "forward auth refused during warm-up", "service", service)
+	s.authError(w, r, "auth-manager is starting up", http.StatusServiceUnavailable)
+	return true
+}
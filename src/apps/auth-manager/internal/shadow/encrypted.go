@@ -0,0 +1,337 @@
+package shadow
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"maps"
+	"strings"
+)
+
+// encryptedPrefix starts every encrypted attribute value, which reads
+// "enc:<key ID>:<base64 of nonce and ciphertext>". The key ID says which
+// key to decrypt with, so keys can be rotated without rewriting every row
+// at once.
+const encryptedPrefix = "enc:"
+
+// KeySize is the length of an attribute encryption key: AES-256.
+const KeySize = 32
+
+// Key is an attribute encryption key and the ID stored with the values it
+// encrypts.
+type Key struct {
+	ID     string
+	Secret []byte
+}
+
+// Rekeyer is implemented by stores that can re-encrypt their attributes
+// under the current key.
+type Rekeyer interface {
+	Rekey(ctx context.Context) (RekeyReport, error)
+}
+
+// RekeyReport is what a Rekey run did.
+type RekeyReport struct {
+	// Rewritten counts the users whose attributes were re-encrypted.
+	Rewritten int
+	// Remaining counts the values still not under the current key because
+	// they won't decrypt. Until it's zero, dropping a retired key loses
+	// them for good.
+	Remaining int
+}
+
+// UnreadableReporter is implemented by stores that leave attribute values
+// they can't decrypt out of List, so one bad row doesn't hide the others.
+type UnreadableReporter interface {
+	// OnUnreadable sets the function told of each value left out, with the
+	// ID of its user. It must be set before the store is used.
+	OnUnreadable(fn func(id, attr string, err error))
+}
+
+// Errors an attribute that won't decrypt is reported with.
+var (
+	ErrUnknownKey    = errors.New("encrypted with unknown key")
+	ErrUndecryptable = errors.New("malformed or tampered ciphertext")
+)
+
+// Encrypted wraps store to encrypt the values of the sensitive attribute
+// keys with AES-256-GCM on write and decrypt them on read, so the backend
+// only ever holds ciphertext. keys[0] encrypts; every key decrypts, so a
+// retired key stays listed until nothing is encrypted with it. Values
+// written before encryption was turned on read as they are and are
+// encrypted when next written. Each ciphertext is bound to its user and
+// attribute key, so one can't be copied into another row.
+func Encrypted(store Store, keys []Key, sensitive []string) (Store, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("shadow: attribute encryption needs a key")
+	}
+	e := &encryptedStore{next: store, sensitive: map[string]bool{}, aeads: map[string]cipher.AEAD{}}
+	for i, key := range keys {
+		if key.ID == "" || strings.Contains(key.ID, ":") {
+			return nil, fmt.Errorf("shadow: invalid attribute key ID %q", key.ID)
+		}
+		if _, dup := e.aeads[key.ID]; dup {
+			return nil, fmt.Errorf("shadow: duplicate attribute key ID %q", key.ID)
+		}
+		if len(key.Secret) != KeySize {
+			return nil, fmt.Errorf("shadow: attribute key %q is %d bytes, want %d", key.ID, len(key.Secret), KeySize)
+		}
+		block, err := aes.NewCipher(key.Secret)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		e.aeads[key.ID] = aead
+		if i == 0 {
+			e.currentID = key.ID
+		}
+	}
+	for _, attr := range sensitive {
+		e.sensitive[attr] = true
+	}
+	return e, nil
+}
+
+type encryptedStore struct {
+	next      Store
+	sensitive map[string]bool
+	currentID string
+	aeads     map[string]cipher.AEAD
+
+	onUnreadable func(id, attr string, err error)
+}
+
+func (e *encryptedStore) OnUnreadable(fn func(id, attr string, err error)) { e.onUnreadable = fn }
+
+func (e *encryptedStore) Upsert(ctx context.Context, ident Identity, attributes map[string]string) (ShadowUser, error) {
+	sealed, err := e.sealAll(ident, attributes)
+	if err != nil {
+		return ShadowUser{}, err
+	}
+	user, err := e.next.Upsert(ctx, ident, sealed)
+	if err != nil {
+		return user, err
+	}
+	// The write went through; a bad value already in the row mustn't make
+	// it look as though it hadn't.
+	return e.openReadable(user), nil
+}
+
+// Rename seals attributes for ident: ciphertexts are bound to their user,
+// so the old ones wouldn't open under the new ID.
+func (e *encryptedStore) Rename(ctx context.Context, id string, ident Identity, attributes map[string]string) (ShadowUser, error) {
+	sealed, err := e.sealAll(ident, attributes)
+	if err != nil {
+		return ShadowUser{}, err
+	}
+	user, err := e.next.Rename(ctx, id, ident, sealed)
+	if err != nil {
+		return user, err
+	}
+	return e.openReadable(user), nil
+}
+
+// sealAll encrypts the sensitive values of attributes for ident.
+func (e *encryptedStore) sealAll(ident Identity, attributes map[string]string) (map[string]string, error) {
+	sealed := make(map[string]string, len(attributes))
+	for k, v := range attributes {
+		// Empty values clear an attribute; there's nothing to hide.
+		if e.sensitive[k] && v != "" {
+			var err error
+			if v, err = e.seal(ident, k, v); err != nil {
+				return nil, err
+			}
+		}
+		sealed[k] = v
+	}
+	return sealed, nil
+}
+
+func (e *encryptedStore) Get(ctx context.Context, provider, subject string) (ShadowUser, error) {
+	user, err := e.next.Get(ctx, provider, subject)
+	if err != nil {
+		return user, err
+	}
+	return e.open(user)
+}
+
+// List leaves out the attribute values it can't decrypt, reporting each to
+// onUnreadable, rather than failing for every user.
+func (e *encryptedStore) List(ctx context.Context) ([]ShadowUser, error) {
+	users, err := e.next.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range users {
+		users[i] = e.openReadable(users[i])
+	}
+	return users, nil
+}
+
+// Each leaves out unreadable values as List does.
+func (e *encryptedStore) Each(ctx context.Context, fn func(ShadowUser) error) error {
+	return e.next.Each(ctx, func(user ShadowUser) error { return fn(e.openReadable(user)) })
+}
+
+// ListByEmail leaves out unreadable values as List does.
+func (e *encryptedStore) ListByEmail(ctx context.Context, email string) ([]ShadowUser, error) {
+	users, err := e.next.ListByEmail(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+	for i := range users {
+		users[i] = e.openReadable(users[i])
+	}
+	return users, nil
+}
+
+func (e *encryptedStore) Delete(ctx context.Context, id string) error { return e.next.Delete(ctx, id) }
+
+func (e *encryptedStore) Close(ctx context.Context) error { return e.next.Close(ctx) }
+
+func (e *encryptedStore) HealthCheck(ctx context.Context) error { return e.next.HealthCheck(ctx) }
+
+// Rekey rewrites the sensitive attributes not encrypted with the current
+// key, whether under a retired key or in plaintext, visiting every user.
+// Once it reports none remaining, keys other than the current one can be
+// dropped. Encrypted attributes no longer marked sensitive are written
+// back in plaintext. Values that won't decrypt are reported as List
+// reports them, left as they are, and counted as remaining.
+func (e *encryptedStore) Rekey(ctx context.Context) (RekeyReport, error) {
+	var report RekeyReport
+	err := e.next.Each(ctx, func(raw ShadowUser) error {
+		stale := map[string]string{}
+		for k, v := range raw.Attributes {
+			if v == "" || (!e.sensitive[k] && !strings.HasPrefix(v, encryptedPrefix)) {
+				continue
+			}
+			if strings.HasPrefix(v, encryptedPrefix+e.currentID+":") {
+				continue
+			}
+			stale[k] = v
+		}
+		if len(stale) == 0 {
+			return nil
+		}
+		user := e.openReadable(ShadowUser{ID: raw.ID, Identity: raw.Identity, Attributes: maps.Clone(stale)})
+		for k := range stale {
+			if _, ok := user.Attributes[k]; !ok {
+				report.Remaining++
+			}
+		}
+		for k, v := range user.Attributes {
+			if !e.sensitive[k] && v == stale[k] {
+				delete(user.Attributes, k) // Plaintext that only looks encrypted
+			}
+		}
+		if len(user.Attributes) == 0 {
+			return nil
+		}
+		if _, err := e.Upsert(ctx, raw.Identity, user.Attributes); err != nil {
+			return err
+		}
+		report.Rewritten++
+		return nil
+	})
+	return report, err
+}
+
+func (e *encryptedStore) seal(ident Identity, attr, value string) (string, error) {
+	aead := e.aeads[e.currentID]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(value)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("shadow: encrypt attribute %q: %w", attr, err)
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(value), additionalData(ident, attr))
+	return encryptedPrefix + e.currentID + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// open decrypts user's encrypted attributes, failing on the first that
+// won't decrypt.
+func (e *encryptedStore) open(user ShadowUser) (ShadowUser, error) {
+	return e.openEach(user, func(attr string, err error) error { return err })
+}
+
+// openReadable decrypts user's encrypted attributes, leaving out and
+// reporting those that won't decrypt.
+func (e *encryptedStore) openReadable(user ShadowUser) ShadowUser {
+	id := user.ID
+	if id == "" {
+		id = identityKey(user.Identity)
+	}
+	user, _ = e.openEach(user, func(attr string, err error) error {
+		delete(user.Attributes, attr)
+		if e.onUnreadable != nil {
+			e.onUnreadable(id, attr, err)
+		}
+		return nil
+	})
+	return user
+}
+
+// openEach decrypts user's sensitive attributes, and any other value in the
+// form seal writes under a known key: an attribute dropped from the
+// sensitive list still reads, and a plaintext value that merely starts
+// with the prefix is left alone. It stops at the first value that won't
+// decrypt for which unreadable returns an error.
+func (e *encryptedStore) openEach(user ShadowUser, unreadable func(attr string, err error) error) (ShadowUser, error) {
+	for k, v := range user.Attributes {
+		if !strings.HasPrefix(v, encryptedPrefix) {
+			continue
+		}
+		plain, sealed, err := e.decrypt(user.Identity, k, v)
+		if err == nil {
+			user.Attributes[k] = plain
+			continue
+		}
+		if !sealed && !e.sensitive[k] {
+			continue
+		}
+		if err := unreadable(k, err); err != nil {
+			return ShadowUser{}, err
+		}
+	}
+	return user, nil
+}
+
+// decrypt opens value, the attribute attr of ident. sealed reports whether
+// value is in the form seal writes under a known key, even if it then
+// fails to decrypt.
+func (e *encryptedStore) decrypt(ident Identity, attr, value string) (plain string, sealed bool, err error) {
+	id, data, ok := strings.Cut(strings.TrimPrefix(value, encryptedPrefix), ":")
+	aead := e.aeads[id]
+	if !ok || aead == nil {
+		return "", false, fmt.Errorf("shadow: attribute %q of %s is %w %q", attr, identityKey(ident), ErrUnknownKey, id)
+	}
+	box, err := base64.RawStdEncoding.DecodeString(data)
+	if err != nil || len(box) < aead.NonceSize() {
+		return "", false, fmt.Errorf("shadow: attribute %q of %s: %w", attr, identityKey(ident), ErrUndecryptable)
+	}
+	out, err := aead.Open(nil, box[:aead.NonceSize()], box[aead.NonceSize():], additionalData(ident, attr))
+	if err != nil && identityKey(ident) != legacyKey(ident) {
+		// Sealed before email subjects were normalized.
+		out, err = aead.Open(nil, box[:aead.NonceSize()], box[aead.NonceSize():], []byte(legacyKey(ident)+"\x00"+attr))
+	}
+	if err != nil {
+		return "", true, fmt.Errorf("shadow: attribute %q of %s: decrypt with key %q: %w", attr, identityKey(ident), id, ErrUndecryptable)
+	}
+	return string(out), true, nil
+}
+
+// legacyKey is identityKey without the subject normalized, as rows written
+// before normalization are keyed.
+func legacyKey(ident Identity) string {
+	return ident.Provider + "::" + ident.Subject
+}
+
+// additionalData binds a ciphertext to the user and attribute it belongs to.
+func additionalData(ident Identity, attr string) []byte {
+	return []byte(identityKey(ident) + "\x00" + attr)
+}
@@ -0,0 +1,206 @@
+package shadow
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func testKey(id string, fill byte) Key {
+	return Key{ID: id, Secret: bytes.Repeat([]byte{fill}, KeySize)}
+}
+
+func TestEncryptedRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	backend := NewMemoryStore()
+	store, err := Encrypted(backend, []Key{testKey("1", 1)}, []string{"n8n_cookie"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ident := Identity{Provider: "authentik", Subject: "7", Email: "dev@example.com"}
+
+	user, err := store.Upsert(ctx, ident, map[string]string{"n8n_cookie": "s3cret", "n8n_user_id": "42"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Attributes["n8n_cookie"] != "s3cret" {
+		t.Errorf("Upsert returned %q, want the plaintext", user.Attributes["n8n_cookie"])
+	}
+	raw, _ := backend.Get(ctx, "authentik", "7")
+	if v := raw.Attributes["n8n_cookie"]; !strings.HasPrefix(v, "enc:1:") || strings.Contains(v, "s3cret") {
+		t.Errorf("stored %q, want it encrypted under key 1", v)
+	}
+	if raw.Attributes["n8n_user_id"] != "42" {
+		t.Errorf("non-sensitive attribute stored as %q", raw.Attributes["n8n_user_id"])
+	}
+
+	got, err := store.Get(ctx, "authentik", "7")
+	if err != nil || got.Attributes["n8n_cookie"] != "s3cret" {
+		t.Errorf("Get = %v, %v", got.Attributes, err)
+	}
+	users, err := store.List(ctx)
+	if err != nil || len(users) != 1 || users[0].Attributes["n8n_cookie"] != "s3cret" {
+		t.Errorf("List = %v, %v", users, err)
+	}
+
+	// A ciphertext copied into another user's row doesn't decrypt.
+	other := Identity{Provider: "authentik", Subject: "8"}
+	backend.Upsert(ctx, other, map[string]string{"n8n_cookie": raw.Attributes["n8n_cookie"]})
+	if _, err := store.Get(ctx, "authentik", "8"); err == nil {
+		t.Error("Get decrypted a ciphertext moved between users")
+	}
+}
+
+func TestEncryptedRotation(t *testing.T) {
+	ctx := context.Background()
+	backend := NewMemoryStore()
+	ident := Identity{Provider: "authentik", Subject: "7"}
+	// Written before encryption was on, then under the old key.
+	backend.Upsert(ctx, ident, map[string]string{"legacy": "plain"})
+	old, err := Encrypted(backend, []Key{testKey("v1", 1)}, []string{"token", "legacy"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := old.Upsert(ctx, ident, map[string]string{"token": "abc"}); err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, err := Encrypted(backend, []Key{testKey("v2", 2), testKey("v1", 1)}, []string{"token", "legacy"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := rotated.Get(ctx, "authentik", "7")
+	if err != nil || got.Attributes["token"] != "abc" || got.Attributes["legacy"] != "plain" {
+		t.Fatalf("Get with the old key still listed = %v, %v", got.Attributes, err)
+	}
+
+	report, err := rotated.(Rekeyer).Rekey(ctx)
+	if err != nil || report != (RekeyReport{Rewritten: 1}) {
+		t.Fatalf("Rekey = %+v, %v; want 1 user rewritten", report, err)
+	}
+	raw, _ := backend.Get(ctx, "authentik", "7")
+	for _, attr := range []string{"token", "legacy"} {
+		if !strings.HasPrefix(raw.Attributes[attr], "enc:v2:") {
+			t.Errorf("%s stored as %q after Rekey, want key v2", attr, raw.Attributes[attr])
+		}
+	}
+	if report, err := rotated.(Rekeyer).Rekey(ctx); err != nil || report != (RekeyReport{}) {
+		t.Errorf("second Rekey = %+v, %v; want nothing left to do", report, err)
+	}
+
+	// With the old key dropped, everything still reads.
+	current, err := Encrypted(backend, []Key{testKey("v2", 2)}, []string{"token", "legacy"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := current.Get(ctx, "authentik", "7"); err != nil || got.Attributes["token"] != "abc" {
+		t.Errorf("Get after dropping v1 = %v, %v", got.Attributes, err)
+	}
+	// Without the key a value was encrypted with, reads fail loudly.
+	if _, err := old.Get(ctx, "authentik", "7"); err == nil || !strings.Contains(err.Error(), `unknown key "v2"`) {
+		t.Errorf("Get without key v2: err = %v", err)
+	}
+}
+
+func TestEncryptedRejectsBadKeys(t *testing.T) {
+	for name, keys := range map[string][]Key{
+		"none":      nil,
+		"short":     {{ID: "1", Secret: []byte("too short")}},
+		"no id":     {testKey("", 1)},
+		"colon":     {testKey("a:b", 1)},
+		"duplicate": {testKey("1", 1), testKey("1", 2)},
+	} {
+		if _, err := Encrypted(NewMemoryStore(), keys, nil); err == nil {
+			t.Errorf("%s: Encrypted succeeded", name)
+		}
+	}
+}
+
+func TestRekeyPastListCap(t *testing.T) {
+	ctx := context.Background()
+	backend := cappedStore{NewMemoryStore()}
+	old, err := Encrypted(backend, []Key{testKey("v1", 1)}, []string{"token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := old.Upsert(ctx, Identity{Provider: "authentik", Subject: "7"}, map[string]string{"token": "abc"}); err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := Encrypted(backend, []Key{testKey("v2", 2), testKey("v1", 1)}, []string{"token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report, err := rotated.(Rekeyer).Rekey(ctx); err != nil || report.Rewritten != 1 {
+		t.Fatalf("Rekey = %+v, %v; want the user List left out rewritten", report, err)
+	}
+	if raw, _ := backend.Get(ctx, "authentik", "7"); !strings.HasPrefix(raw.Attributes["token"], "enc:v2:") {
+		t.Errorf("token stored as %q after Rekey, want key v2", raw.Attributes["token"])
+	}
+}
+
+func TestEncryptedListSkipsUnreadable(t *testing.T) {
+	ctx := context.Background()
+	backend := NewMemoryStore()
+	store, err := Encrypted(backend, []Key{testKey("1", 1)}, []string{"n8n_cookie"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	type report struct{ id, attr string }
+	var reports []report
+	var errs []error
+	store.(UnreadableReporter).OnUnreadable(func(id, attr string, err error) {
+		reports = append(reports, report{id, attr})
+		errs = append(errs, err)
+	})
+
+	store.Upsert(ctx, Identity{Provider: "authentik", Subject: "1"}, map[string]string{"n8n_cookie": "good", "n8n_user_id": "11"})
+	store.Upsert(ctx, Identity{Provider: "authentik", Subject: "3"}, map[string]string{"n8n_cookie": "also good"})
+	// One row with a value under a key that's gone and one corrupted, and a
+	// plaintext attribute that merely looks encrypted.
+	backend.Upsert(ctx, Identity{Provider: "authentik", Subject: "2"}, map[string]string{
+		"n8n_cookie":  "enc:retired:AAAA",
+		"n8n_user_id": "22",
+		"note":        "enc:not:ciphertext",
+	})
+	raw, _ := backend.Get(ctx, "authentik", "3")
+	backend.Upsert(ctx, Identity{Provider: "authentik", Subject: "3"}, map[string]string{"n8n_cookie": raw.Attributes["n8n_cookie"][:len(raw.Attributes["n8n_cookie"])-4] + "AAAA"})
+
+	users, err := store.List(ctx)
+	if err != nil || len(users) != 3 {
+		t.Fatalf("List = %d users, %v; want all 3", len(users), err)
+	}
+	bySubject := map[string]map[string]string{}
+	for _, user := range users {
+		bySubject[user.Identity.Subject] = user.Attributes
+	}
+	if bySubject["1"]["n8n_cookie"] != "good" {
+		t.Errorf("good row = %v", bySubject["1"])
+	}
+	if _, ok := bySubject["2"]["n8n_cookie"]; ok || bySubject["2"]["n8n_user_id"] != "22" || bySubject["2"]["note"] != "enc:not:ciphertext" {
+		t.Errorf("row with an unknown key = %v; want only its unreadable value left out", bySubject["2"])
+	}
+	if _, ok := bySubject["3"]["n8n_cookie"]; ok {
+		t.Errorf("corrupt ciphertext listed as %q", bySubject["3"]["n8n_cookie"])
+	}
+	slices.SortFunc(reports, func(a, b report) int { return strings.Compare(a.id, b.id) })
+	if want := []report{{"authentik::2", "n8n_cookie"}, {"authentik::3", "n8n_cookie"}}; !slices.Equal(reports, want) {
+		t.Errorf("reported %v, want %v", reports, want)
+	}
+	for _, err := range errs {
+		if !errors.Is(err, ErrUnknownKey) && !errors.Is(err, ErrUndecryptable) {
+			t.Errorf("reported error %v isn't one of the sentinels", err)
+		}
+	}
+
+	// Rekey leaves what it can't read alone, and counts the value under the
+	// retired key as remaining.
+	if report, err := store.(Rekeyer).Rekey(ctx); err != nil || report.Remaining != 1 {
+		t.Fatalf("Rekey = %+v, %v; want the value under the retired key remaining", report, err)
+	}
+	if raw, _ := backend.Get(ctx, "authentik", "2"); raw.Attributes["n8n_cookie"] != "enc:retired:AAAA" {
+		t.Errorf("Rekey rewrote an unreadable value to %q", raw.Attributes["n8n_cookie"])
+	}
+}
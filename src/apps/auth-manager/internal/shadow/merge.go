@@ -0,0 +1,93 @@
+package shadow
+
+import (
+	"context"
+	"slices"
+	"sort"
+)
+
+// MergeEmailDuplicates folds shadow users stored under spellings of an
+// email subject that differ only in case or IDN form, left from before
+// subjects were normalized, into one stored under the normalized subject,
+// as fold does. Records whose email alone isn't normalized are rewritten
+// in place. It reports how many duplicate records it removed.
+func MergeEmailDuplicates(ctx context.Context, store Store) (int, error) {
+	groups := map[string][]ShadowUser{}
+	var keys []string
+	err := store.Each(ctx, func(user ShadowUser) error {
+		key := identityKey(user.Identity)
+		if _, seen := groups[key]; !seen {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], user)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, key := range keys {
+		group := groups[key]
+		if len(group) == 1 && group[0].ID == key && group[0].Identity == canonical(group[0].Identity) {
+			continue
+		}
+		if _, err := fold(ctx, store, newest(group).Identity, group, nil); err != nil {
+			return removed, err
+		}
+		for _, user := range group {
+			if user.ID != key {
+				removed++
+			}
+		}
+	}
+	return removed, nil
+}
+
+// fold stores users as one shadow user under ident. The oldest record is
+// renamed, so the user keeps its created_at; the others are deleted. The
+// most recently updated record's attributes win over older ones, with
+// those only older records have kept, and attributes win over them all.
+func fold(ctx context.Context, store Store, ident Identity, users []ShadowUser, attributes map[string]string) (ShadowUser, error) {
+	users = slices.Clone(users)
+	sort.SliceStable(users, func(i, j int) bool { return users[i].UpdatedAt.Before(users[j].UpdatedAt) })
+	merged := map[string]string{}
+	for _, user := range users {
+		for k, v := range user.Attributes {
+			merged[k] = v
+		}
+	}
+	for k, v := range attributes {
+		merged[k] = v
+	}
+	oldest := users[0]
+	for _, user := range users[1:] {
+		if user.CreatedAt.Before(oldest.CreatedAt) {
+			oldest = user
+		}
+	}
+	folded, err := store.Rename(ctx, oldest.ID, ident, merged)
+	if err != nil {
+		return ShadowUser{}, err
+	}
+	for _, user := range users {
+		if user.ID == oldest.ID || user.ID == folded.ID {
+			continue
+		}
+		if err := store.Delete(ctx, user.ID); err != nil {
+			return folded, err
+		}
+	}
+	return folded, nil
+}
+
+// newest returns the most recently updated of users.
+func newest(users []ShadowUser) ShadowUser {
+	latest := users[0]
+	for _, user := range users[1:] {
+		if user.UpdatedAt.After(latest.UpdatedAt) {
+			latest = user
+		}
+	}
+	return latest
+}
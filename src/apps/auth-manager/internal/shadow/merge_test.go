@@ -0,0 +1,100 @@
+package shadow
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+func TestEmailSubjectsNormalized(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	if _, err := store.Upsert(ctx, Identity{Provider: "authentik", Subject: "Jane.Doe@Example.COM", Email: "Jane.Doe@Example.COM"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	user, err := store.Get(ctx, "authentik", "jane.doe@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Identity.Subject != "jane.doe@example.com" || user.Identity.Email != "jane.doe@example.com" {
+		t.Errorf("stored identity = %+v, want it normalized", user.Identity)
+	}
+	// Subjects that aren't emails are kept as they are.
+	store.Upsert(ctx, Identity{Provider: "authentik", Subject: "AbC"}, nil)
+	if _, err := store.Get(ctx, "authentik", "abc"); err == nil {
+		t.Error("a user ID subject was lowercased")
+	}
+}
+
+func TestMergeEmailDuplicates(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	now := time.Now().UTC()
+	// Rows written before normalization, keyed as they were then.
+	legacy := func(subject string, updated time.Time, attrs map[string]string) {
+		ident := Identity{Provider: "authentik", Subject: subject, Email: subject}
+		store.users[legacyKey(ident)] = ShadowUser{ID: legacyKey(ident), Identity: ident, Attributes: attrs, UpdatedAt: updated}
+	}
+	legacy("Jane.Doe@Example.COM", now.Add(-time.Hour), map[string]string{"mattermost_user_id": "old", "n8n_user_id": "n1"})
+	legacy("jane.doe@example.com", now, map[string]string{"mattermost_user_id": "new"})
+	legacy("Solo@Example.com", now, map[string]string{"x": "1"})
+	store.Upsert(ctx, Identity{Provider: "authentik", Subject: "42", Email: "bob@example.com"}, map[string]string{"y": "2"})
+
+	removed, err := MergeEmailDuplicates(ctx, store)
+	if err != nil || removed != 2 {
+		t.Fatalf("MergeEmailDuplicates = %d, %v; want 2 rows removed", removed, err)
+	}
+	users, _ := store.List(ctx)
+	if len(users) != 3 {
+		t.Fatalf("%d users left, want 3: %+v", len(users), users)
+	}
+	jane, err := store.Get(ctx, "authentik", "jane.doe@example.com")
+	if err != nil || jane.Attributes["mattermost_user_id"] != "new" || jane.Attributes["n8n_user_id"] != "n1" {
+		t.Errorf("merged user = %+v, %v; want the newest ID and the older n8n ID", jane, err)
+	}
+	if solo, err := store.Get(ctx, "authentik", "solo@example.com"); err != nil || solo.ID != "authentik::solo@example.com" || solo.Attributes["x"] != "1" {
+		t.Errorf("lone legacy row = %+v, %v; want it rekeyed", solo, err)
+	}
+
+	if removed, err := MergeEmailDuplicates(ctx, store); err != nil || removed != 0 {
+		t.Errorf("second MergeEmailDuplicates = %d, %v; want nothing to do", removed, err)
+	}
+}
+
+func TestMergeEmailDuplicatesPastListCap(t *testing.T) {
+	ctx := context.Background()
+	store := cappedStore{NewMemoryStore()}
+	ident := Identity{Provider: "authentik", Subject: "Jane.Doe@Example.COM", Email: "Jane.Doe@Example.COM"}
+	store.users[legacyKey(ident)] = ShadowUser{ID: legacyKey(ident), Identity: ident, Attributes: map[string]string{}}
+	if removed, err := MergeEmailDuplicates(ctx, store); err != nil || removed != 1 {
+		t.Fatalf("MergeEmailDuplicates = %d, %v; want the record List left out merged", removed, err)
+	}
+	if _, err := store.Get(ctx, "authentik", "jane.doe@example.com"); err != nil {
+		t.Errorf("merged user not under the normalized subject: %v", err)
+	}
+}
+
+func TestMergeEmailDuplicatesEncrypted(t *testing.T) {
+	ctx := context.Background()
+	backend := NewMemoryStore()
+	store, err := Encrypted(backend, []Key{testKey("1", 1)}, []string{"token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Sealed before subjects were normalized, bound to the subject as typed.
+	ident := Identity{Provider: "authentik", Subject: "Jane@Example.com"}
+	aead := store.(*encryptedStore).aeads["1"]
+	nonce := make([]byte, aead.NonceSize())
+	ciphertext := aead.Seal(nonce, nonce, []byte("s3cret"), []byte(legacyKey(ident)+"\x00token"))
+	sealed := "enc:1:" + base64.RawStdEncoding.EncodeToString(ciphertext)
+	backend.users[legacyKey(ident)] = ShadowUser{ID: legacyKey(ident), Identity: ident, Attributes: map[string]string{"token": sealed}}
+
+	if _, err := MergeEmailDuplicates(ctx, store); err != nil {
+		t.Fatal(err)
+	}
+	got, err := store.Get(ctx, "authentik", "jane@example.com")
+	if err != nil || got.Attributes["token"] != "s3cret" {
+		t.Errorf("Get after merging = %v, %v", got.Attributes, err)
+	}
+}
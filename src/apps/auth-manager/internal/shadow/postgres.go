@@ -3,9 +3,13 @@ package shadow
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/accounts"
 )
 
 // PostgresStore persists shadow users in PostgreSQL.
@@ -40,6 +44,7 @@ CREATE TABLE IF NOT EXISTS shadow_users (
     created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
     updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
 );
+CREATE INDEX IF NOT EXISTS shadow_users_email_idx ON shadow_users (email);
 `
 	_, err := p.pool.Exec(ctx, ddl)
 	return err
@@ -62,16 +67,32 @@ ON CONFLICT (id)
 DO UPDATE SET
     email = EXCLUDED.email,
     name = EXCLUDED.name,
-    attributes = EXCLUDED.attributes,
+    attributes = shadow_users.attributes || EXCLUDED.attributes,
     updated_at = NOW()
 RETURNING id, provider, subject, email, name, attributes, created_at, updated_at;
 `
 
+	ident = canonical(ident)
 	key := identityKey(ident)
 	row := p.pool.QueryRow(ctx, upsertSQL, key, ident.Provider, ident.Subject, ident.Email, ident.Name, string(attrJSON))
 	return scanShadowUser(row)
 }
 
+// Get implements the Store interface.
+func (p *PostgresStore) Get(ctx context.Context, provider, subject string) (ShadowUser, error) {
+	const getSQL = `
+SELECT id, provider, subject, email, name, attributes, created_at, updated_at
+FROM shadow_users
+WHERE id = $1;
+`
+	key := identityKey(Identity{Provider: provider, Subject: subject})
+	user, err := scanShadowUser(p.pool.QueryRow(ctx, getSQL, key))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ShadowUser{}, ErrNotFound
+	}
+	return user, err
+}
+
 // List implements the Store interface.
 func (p *PostgresStore) List(ctx context.Context) ([]ShadowUser, error) {
 	const listSQL = `
@@ -80,7 +101,59 @@ FROM shadow_users
 ORDER BY updated_at DESC
 LIMIT 500;
 `
-	rows, err := p.pool.Query(ctx, listSQL)
+	return p.query(ctx, listSQL)
+}
+
+// eachPageSize is how many rows Each fetches at a time.
+const eachPageSize = 500
+
+// Each implements the Store interface. It pages by ID, so rows updated
+// while it runs are neither skipped nor visited twice.
+func (p *PostgresStore) Each(ctx context.Context, fn func(ShadowUser) error) error {
+	const pageSQL = `
+SELECT id, provider, subject, email, name, attributes, created_at, updated_at
+FROM shadow_users
+WHERE id > $1
+ORDER BY id
+LIMIT $2;
+`
+	after := ""
+	for {
+		users, err := p.query(ctx, pageSQL, after, eachPageSize)
+		if err != nil {
+			return err
+		}
+		for _, user := range users {
+			if err := fn(user); err != nil {
+				return err
+			}
+		}
+		if len(users) < eachPageSize {
+			return nil
+		}
+		after = users[len(users)-1].ID
+	}
+}
+
+// ListByEmail implements the Store interface. Emails are stored normalized;
+// MergeEmailDuplicates rewrites rows from before they were.
+func (p *PostgresStore) ListByEmail(ctx context.Context, email string) ([]ShadowUser, error) {
+	const byEmailSQL = `
+SELECT id, provider, subject, email, name, attributes, created_at, updated_at
+FROM shadow_users
+WHERE email = $1
+ORDER BY id;
+`
+	email = accounts.NormalizeEmail(email)
+	if email == "" {
+		return []ShadowUser{}, nil
+	}
+	return p.query(ctx, byEmailSQL, email)
+}
+
+// query runs sql and scans every row it returns.
+func (p *PostgresStore) query(ctx context.Context, sql string, args ...any) ([]ShadowUser, error) {
+	rows, err := p.pool.Query(ctx, sql, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -100,6 +173,52 @@ LIMIT 500;
 	return users, nil
 }
 
+// Delete implements the Store interface.
+func (p *PostgresStore) Delete(ctx context.Context, id string) error {
+	_, err := p.pool.Exec(ctx, `DELETE FROM shadow_users WHERE id = $1;`, id)
+	return err
+}
+
+// Rename implements the Store interface. The user already under ident, if
+// any, is deleted in the same transaction.
+func (p *PostgresStore) Rename(ctx context.Context, id string, ident Identity, attributes map[string]string) (ShadowUser, error) {
+	if attributes == nil {
+		attributes = map[string]string{}
+	}
+	attrJSON, err := json.Marshal(attributes)
+	if err != nil {
+		return ShadowUser{}, err
+	}
+
+	const renameSQL = `
+UPDATE shadow_users
+SET id = $2, provider = $3, subject = $4, email = $5, name = $6, attributes = $7::jsonb, updated_at = NOW()
+WHERE id = $1
+RETURNING id, provider, subject, email, name, attributes, created_at, updated_at;
+`
+
+	ident = canonical(ident)
+	key := identityKey(ident)
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return ShadowUser{}, err
+	}
+	defer tx.Rollback(ctx)
+	if key != id {
+		if _, err := tx.Exec(ctx, `DELETE FROM shadow_users WHERE id = $1;`, key); err != nil {
+			return ShadowUser{}, err
+		}
+	}
+	user, err := scanShadowUser(tx.QueryRow(ctx, renameSQL, id, key, ident.Provider, ident.Subject, ident.Email, ident.Name, string(attrJSON)))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ShadowUser{}, ErrNotFound
+	}
+	if err != nil {
+		return ShadowUser{}, err
+	}
+	return user, tx.Commit(ctx)
+}
+
 // Close releases the underlying connection pool.
 func (p *PostgresStore) Close(ctx context.Context) error {
 	p.pool.Close()
@@ -0,0 +1,176 @@
+package shadow
+
+import (
+	"context"
+	"errors"
+	"sort"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/accounts"
+)
+
+// UpsertByEmail is Store.Upsert for an identity provider whose subjects can
+// change under a user, as Authentik's do when it's reinstalled: when
+// nothing is stored under ident's provider and subject but users are under
+// its provider and email, they're folded into one record under ident, as
+// fold does, instead of a duplicate being inserted beside them. Subjects
+// that are the email itself, the fallback of events without a user ID,
+// never take over a record. It also returns the IDs of the records it
+// folded.
+func UpsertByEmail(ctx context.Context, store Store, ident Identity, attributes map[string]string) (ShadowUser, []string, error) {
+	ident = canonical(ident)
+	if ident.Email == "" || ident.Subject == ident.Email {
+		user, err := store.Upsert(ctx, ident, attributes)
+		return user, nil, err
+	}
+	if _, err := store.Get(ctx, ident.Provider, ident.Subject); !errors.Is(err, ErrNotFound) {
+		if err != nil {
+			return ShadowUser{}, nil, err
+		}
+		user, err := store.Upsert(ctx, ident, attributes)
+		return user, nil, err
+	}
+	users, err := store.ListByEmail(ctx, ident.Email)
+	if err != nil {
+		return ShadowUser{}, nil, err
+	}
+	var stale []ShadowUser
+	var ids []string
+	for _, user := range users {
+		if user.Identity.Provider == ident.Provider {
+			stale = append(stale, user)
+			ids = append(ids, user.ID)
+		}
+	}
+	if len(stale) == 0 {
+		user, err := store.Upsert(ctx, ident, attributes)
+		return user, nil, err
+	}
+	user, err := fold(ctx, store, ident, stale, attributes)
+	if err != nil {
+		return ShadowUser{}, nil, err
+	}
+	return user, ids, nil
+}
+
+// FindByEmail returns provider's most recently updated shadow user with
+// email, preferring those whose subject isn't the email, for callers that
+// know a user's email but not the subject their records are keyed by. It
+// returns ErrNotFound when there's none.
+func FindByEmail(ctx context.Context, store Store, provider, email string) (ShadowUser, error) {
+	email = accounts.NormalizeEmail(email)
+	users, err := store.ListByEmail(ctx, email)
+	if err != nil {
+		return ShadowUser{}, err
+	}
+	var matched []ShadowUser
+	for _, user := range users {
+		if user.Identity.Provider == provider {
+			matched = append(matched, user)
+		}
+	}
+	if len(matched) == 0 {
+		return ShadowUser{}, ErrNotFound
+	}
+	return newest(preferIDSubjects(matched, email)), nil
+}
+
+// RemapReport is what Remap did.
+type RemapReport struct {
+	// Merged counts the records moved to their email's current subject or
+	// folded into the record already there.
+	Merged int `json:"merged"`
+	// Orphaned counts the records left as they were because no current
+	// subject is known for them.
+	Orphaned int `json:"orphaned"`
+}
+
+// Remap moves provider's shadow users to the current subject of their
+// email, folding each email's records into one as fold does. current maps
+// emails to subjects, such as an identity provider's user list;
+// records whose email isn't in it, and isn't another current user's
+// subject, are orphaned. When current is nil, the subject of each email's
+// most recently updated record is taken as current, preferring records
+// whose subject isn't the email, and only records without an email are
+// orphaned. With dryRun set, it only reports what it would do.
+func Remap(ctx context.Context, store Store, provider string, current map[string]string, dryRun bool) (RemapReport, error) {
+	var report RemapReport
+	var users []ShadowUser
+	err := store.Each(ctx, func(user ShadowUser) error {
+		if user.Identity.Provider == provider {
+			users = append(users, user)
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+	var byEmail map[string]string
+	subjects := map[string]bool{}
+	if current != nil {
+		byEmail = make(map[string]string, len(current))
+		for email, subject := range current {
+			byEmail[accounts.NormalizeEmail(email)] = subject
+			subjects[canonicalSubject(subject)] = true
+		}
+	}
+	groups := map[string][]ShadowUser{}
+	for _, user := range users {
+		email := accounts.NormalizeEmail(user.Identity.Email)
+		_, known := byEmail[email]
+		if email == "" || (byEmail != nil && !known) {
+			if !subjects[canonicalSubject(user.Identity.Subject)] {
+				report.Orphaned++
+			}
+			continue
+		}
+		groups[email] = append(groups[email], user)
+	}
+
+	emails := make([]string, 0, len(groups))
+	for email := range groups {
+		emails = append(emails, email)
+	}
+	sort.Strings(emails)
+	for _, email := range emails {
+		group := groups[email]
+		var ident Identity
+		if subject, ok := byEmail[email]; ok {
+			ident = newest(group).Identity
+			ident.Subject = subject
+		} else {
+			ident = newest(preferIDSubjects(group, email)).Identity
+		}
+		key := identityKey(ident)
+		moved := 0
+		for _, user := range group {
+			if user.ID != key {
+				moved++
+			}
+		}
+		if moved == 0 {
+			continue
+		}
+		if !dryRun {
+			if _, err := fold(ctx, store, ident, group, nil); err != nil {
+				return report, err
+			}
+		}
+		report.Merged += moved
+	}
+	return report, nil
+}
+
+// preferIDSubjects returns the users whose subject isn't email, or all of
+// them if there are none.
+func preferIDSubjects(users []ShadowUser, email string) []ShadowUser {
+	var byID []ShadowUser
+	for _, user := range users {
+		if canonicalSubject(user.Identity.Subject) != email {
+			byID = append(byID, user)
+		}
+	}
+	if len(byID) == 0 {
+		return users
+	}
+	return byID
+}
@@ -0,0 +1,134 @@
+package shadow
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestUpsertByEmail(t *testing.T) {
+	ctx := context.Background()
+	backend := NewMemoryStore()
+	store, err := Encrypted(backend, []Key{testKey("1", 1)}, []string{"n8n_cookie"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, err := store.Upsert(ctx, Identity{Provider: "authentik", Subject: "7", Email: "dev@example.com"},
+		map[string]string{"mattermost_user_id": "mm1", "n8n_cookie": "s3cret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Authentik was reinstalled: the same user now has PK 1007.
+	user, moved, err := UpsertByEmail(ctx, store, Identity{Provider: "authentik", Subject: "1007", Email: "Dev@Example.com", Name: "Dev"}, map[string]string{"groups": "devs"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(moved, []string{"authentik::7"}) {
+		t.Errorf("moved = %v, want the old record", moved)
+	}
+	if user.ID != "authentik::1007" || user.Identity.Name != "Dev" || !user.CreatedAt.Equal(old.CreatedAt) {
+		t.Errorf("remapped user = %+v, want it under the new subject with its created_at kept", user)
+	}
+	got, err := store.Get(ctx, "authentik", "1007")
+	if err != nil || got.Attributes["mattermost_user_id"] != "mm1" || got.Attributes["n8n_cookie"] != "s3cret" || got.Attributes["groups"] != "devs" {
+		t.Errorf("Get = %v, %v; want the old attributes, re-encrypted, and the new ones", got.Attributes, err)
+	}
+	if _, err := store.Get(ctx, "authentik", "7"); err != ErrNotFound {
+		t.Errorf("old record still stored: %v", err)
+	}
+
+	// Known subjects are plain upserts.
+	if _, moved, err := UpsertByEmail(ctx, store, Identity{Provider: "authentik", Subject: "1007", Email: "dev@example.com"}, nil); err != nil || moved != nil {
+		t.Errorf("upsert of a known subject moved %v, %v", moved, err)
+	}
+	// So are email subjects and other providers.
+	for _, ident := range []Identity{
+		{Provider: "authentik", Subject: "dev@example.com", Email: "dev@example.com"},
+		{Provider: "other", Subject: "9", Email: "dev@example.com"},
+	} {
+		if _, moved, err := UpsertByEmail(ctx, store, ident, nil); err != nil || moved != nil {
+			t.Errorf("UpsertByEmail(%+v) moved %v, %v", ident, moved, err)
+		}
+	}
+	if users, _ := store.List(ctx); len(users) != 3 {
+		t.Errorf("%d users stored, want 3", len(users))
+	}
+}
+
+func TestRemap(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	now := time.Now().UTC()
+	put := func(subject, email string, age time.Duration, attrs map[string]string) {
+		ident := canonical(Identity{Provider: "authentik", Subject: subject, Email: email})
+		key := identityKey(ident)
+		store.users[key] = ShadowUser{ID: key, Identity: ident, Attributes: attrs, CreatedAt: now.Add(-age), UpdatedAt: now.Add(-age)}
+	}
+	put("7", "dev@example.com", 48*time.Hour, map[string]string{"mattermost_user_id": "mm1"})
+	put("1007", "dev@example.com", time.Hour, map[string]string{"groups": "devs"})
+	put("dev@example.com", "dev@example.com", time.Minute, map[string]string{"n8n_user_id": "n1"})
+	put("8", "ops@example.com", 48*time.Hour, nil)
+	put("9", "", 48*time.Hour, nil)
+
+	report, err := Remap(ctx, store, "authentik", nil, false)
+	if err != nil || report != (RemapReport{Merged: 2, Orphaned: 1}) {
+		t.Fatalf("Remap = %+v, %v; want 2 merged, 1 orphaned", report, err)
+	}
+	dev, err := store.Get(ctx, "authentik", "1007")
+	if err != nil || dev.Attributes["mattermost_user_id"] != "mm1" || dev.Attributes["n8n_user_id"] != "n1" || !dev.CreatedAt.Equal(now.Add(-48*time.Hour)) {
+		t.Errorf("merged user = %+v, %v; want every attribute and the oldest created_at", dev, err)
+	}
+	if users, _ := store.List(ctx); len(users) != 3 {
+		t.Errorf("%d users left, want 3", len(users))
+	}
+
+	// With the identity provider's users: ops has a new PK, and 9 is gone.
+	current := map[string]string{"Dev@example.com": "1007", "ops@example.com": "1008"}
+	if report, err := Remap(ctx, store, "authentik", current, true); err != nil || report.Merged != 1 {
+		t.Fatalf("dry-run Remap = %+v, %v; want 1 merged", report, err)
+	}
+	if _, err := store.Get(ctx, "authentik", "1008"); err != ErrNotFound {
+		t.Fatalf("dry run moved a record: %v", err)
+	}
+	report, err = Remap(ctx, store, "authentik", current, false)
+	if err != nil || report != (RemapReport{Merged: 1, Orphaned: 1}) {
+		t.Fatalf("Remap = %+v, %v; want 1 merged, 1 orphaned", report, err)
+	}
+	if _, err := store.Get(ctx, "authentik", "1008"); err != nil {
+		t.Errorf("ops wasn't moved to its new subject: %v", err)
+	}
+	if report, err := Remap(ctx, store, "authentik", nil, false); err != nil || report.Merged != 0 {
+		t.Errorf("second Remap = %+v, %v; want nothing left to merge", report, err)
+	}
+}
+
+// cappedStore returns no users from List, as a capped List leaves out the
+// users past its limit.
+type cappedStore struct {
+	*MemoryStore
+}
+
+func (cappedStore) List(context.Context) ([]ShadowUser, error) { return []ShadowUser{}, nil }
+
+func TestRemapVisitsEveryUser(t *testing.T) {
+	ctx := context.Background()
+	store := cappedStore{NewMemoryStore()}
+	if _, err := store.Upsert(ctx, Identity{Provider: "authentik", Subject: "7", Email: "dev@example.com"}, map[string]string{"mattermost_user_id": "mm1"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, moved, err := UpsertByEmail(ctx, store, Identity{Provider: "authentik", Subject: "1007", Email: "dev@example.com"}, nil); err != nil || len(moved) != 1 {
+		t.Fatalf("UpsertByEmail moved %v, %v; want the record List left out", moved, err)
+	}
+	if _, err := store.Upsert(ctx, Identity{Provider: "authentik", Subject: "8", Email: "ops@example.com"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	report, err := Remap(ctx, store, "authentik", map[string]string{"ops@example.com": "1008"}, false)
+	if err != nil || report.Merged != 1 {
+		t.Errorf("Remap = %+v, %v; want the record List left out merged", report, err)
+	}
+	if users, _ := store.ListByEmail(ctx, "Dev@Example.com"); len(users) != 1 || users[0].ID != "authentik::1007" {
+		t.Errorf("ListByEmail = %+v, want the remapped user", users)
+	}
+}
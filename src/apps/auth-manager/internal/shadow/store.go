@@ -2,11 +2,19 @@ package shadow
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/accounts"
 )
 
+// ErrNotFound is returned when no shadow user matches the requested key.
+var ErrNotFound = errors.New("shadow user not found")
+
 // Identity represents the upstream identity provider result we care about.
 type Identity struct {
 	Provider string `json:"provider"`
@@ -27,7 +35,23 @@ type ShadowUser struct {
 // Store captures the persistence contract for shadow users.
 type Store interface {
 	Upsert(ctx context.Context, ident Identity, attributes map[string]string) (ShadowUser, error)
+	Get(ctx context.Context, provider, subject string) (ShadowUser, error)
+	// List returns the most recently updated shadow users; backends may cap
+	// how many. Use Each to visit every user.
 	List(ctx context.Context) ([]ShadowUser, error)
+	// Each calls fn with every shadow user, in ID order, fetching them a
+	// page at a time, and stops at the first error fn returns. fn may write
+	// to the store; users written while Each runs may or may not be visited.
+	Each(ctx context.Context, fn func(ShadowUser) error) error
+	// ListByEmail returns every provider's shadow users with the email.
+	ListByEmail(ctx context.Context, email string) ([]ShadowUser, error)
+	// Delete removes the shadow user with the given ID, if there is one.
+	Delete(ctx context.Context, id string) error
+	// Rename stores the shadow user with the given ID under ident instead,
+	// with attributes in place of its own and its created_at kept. A user
+	// already stored under ident is replaced. It returns ErrNotFound when
+	// there's no user with the ID.
+	Rename(ctx context.Context, id string, ident Identity, attributes map[string]string) (ShadowUser, error)
 	Close(ctx context.Context) error
 	HealthCheck(ctx context.Context) error
 }
@@ -45,6 +69,7 @@ func NewMemoryStore() *MemoryStore {
 
 // Upsert inserts or updates a shadow user in-place using provider+subject as the key.
 func (m *MemoryStore) Upsert(ctx context.Context, ident Identity, attributes map[string]string) (ShadowUser, error) {
+	ident = canonical(ident)
 	key := identityKey(ident)
 
 	m.mu.Lock()
@@ -73,7 +98,21 @@ func (m *MemoryStore) Upsert(ctx context.Context, ident Identity, attributes map
 	user.UpdatedAt = now
 	m.users[key] = user
 
-	return user, nil
+	return copyUser(user), nil
+}
+
+// Get returns the shadow user stored under provider+subject.
+func (m *MemoryStore) Get(ctx context.Context, provider, subject string) (ShadowUser, error) {
+	key := identityKey(Identity{Provider: provider, Subject: subject})
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	user, ok := m.users[key]
+	if !ok {
+		return ShadowUser{}, ErrNotFound
+	}
+	return copyUser(user), nil
 }
 
 // List returns a snapshot of existing shadow users.
@@ -83,11 +122,74 @@ func (m *MemoryStore) List(ctx context.Context) ([]ShadowUser, error) {
 
 	out := make([]ShadowUser, 0, len(m.users))
 	for _, user := range m.users {
-		out = append(out, user)
+		out = append(out, copyUser(user))
 	}
 	return out, nil
 }
 
+// Each implements Store. It visits a snapshot taken when it's called.
+func (m *MemoryStore) Each(ctx context.Context, fn func(ShadowUser) error) error {
+	users, err := m.List(ctx)
+	if err != nil {
+		return err
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	for _, user := range users {
+		if err := fn(user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListByEmail implements Store.
+func (m *MemoryStore) ListByEmail(ctx context.Context, email string) ([]ShadowUser, error) {
+	email = accounts.NormalizeEmail(email)
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	out := []ShadowUser{}
+	for _, user := range m.users {
+		if email != "" && accounts.NormalizeEmail(user.Identity.Email) == email {
+			out = append(out, copyUser(user))
+		}
+	}
+	return out, nil
+}
+
+// Delete implements Store.
+func (m *MemoryStore) Delete(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.users, id)
+	return nil
+}
+
+// Rename implements Store.
+func (m *MemoryStore) Rename(ctx context.Context, id string, ident Identity, attributes map[string]string) (ShadowUser, error) {
+	ident = canonical(ident)
+	key := identityKey(ident)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[id]
+	if !ok {
+		return ShadowUser{}, ErrNotFound
+	}
+	delete(m.users, id)
+	user.ID = key
+	user.Identity = ident
+	user.Attributes = map[string]string{}
+	for k, v := range attributes {
+		user.Attributes[k] = v
+	}
+	user.UpdatedAt = time.Now().UTC()
+	m.users[key] = user
+
+	return copyUser(user), nil
+}
+
 // Close implements Store.
 func (m *MemoryStore) Close(ctx context.Context) error {
 	return nil
@@ -98,6 +200,34 @@ func (m *MemoryStore) HealthCheck(ctx context.Context) error {
 	return nil
 }
 
+// copyUser detaches the attribute map so callers can't mutate stored state.
+func copyUser(user ShadowUser) ShadowUser {
+	attrs := make(map[string]string, len(user.Attributes))
+	for k, v := range user.Attributes {
+		attrs[k] = v
+	}
+	user.Attributes = attrs
+	return user
+}
+
+// identityKey is the ID a shadow user is stored under. Subjects that are
+// email addresses, the fallback when an event carries no user ID, are
+// normalized, so every spelling of an address finds the same user.
 func identityKey(ident Identity) string {
-	return fmt.Sprintf("%s::%s", ident.Provider, ident.Subject)
+	return fmt.Sprintf("%s::%s", ident.Provider, canonicalSubject(ident.Subject))
+}
+
+func canonicalSubject(subject string) string {
+	if strings.Contains(subject, "@") {
+		return accounts.NormalizeEmail(subject)
+	}
+	return subject
+}
+
+// canonical returns ident as it's stored: with its email and an email
+// subject normalized.
+func canonical(ident Identity) Identity {
+	ident.Subject = canonicalSubject(ident.Subject)
+	ident.Email = accounts.NormalizeEmail(ident.Email)
+	return ident
 }
@@ -0,0 +1,95 @@
+package shadow
+
+import (
+	"context"
+	"errors"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/tracing"
+)
+
+// Traced wraps store to time each call made within a trace as a span.
+func Traced(store Store) Store {
+	return tracedStore{store}
+}
+
+type tracedStore struct {
+	next Store
+}
+
+func (t tracedStore) Upsert(ctx context.Context, ident Identity, attributes map[string]string) (ShadowUser, error) {
+	ctx, span := tracing.Start(ctx, "shadow.Upsert", tracing.KindInternal)
+	defer span.End()
+	user, err := t.next.Upsert(ctx, ident, attributes)
+	span.SetError(err)
+	return user, err
+}
+
+func (t tracedStore) Get(ctx context.Context, provider, subject string) (ShadowUser, error) {
+	ctx, span := tracing.Start(ctx, "shadow.Get", tracing.KindInternal)
+	defer span.End()
+	user, err := t.next.Get(ctx, provider, subject)
+	// A missing user is an answer, not a failure.
+	if !errors.Is(err, ErrNotFound) {
+		span.SetError(err)
+	}
+	return user, err
+}
+
+func (t tracedStore) List(ctx context.Context) ([]ShadowUser, error) {
+	ctx, span := tracing.Start(ctx, "shadow.List", tracing.KindInternal)
+	defer span.End()
+	users, err := t.next.List(ctx)
+	span.SetAttribute("shadow.users", len(users))
+	span.SetError(err)
+	return users, err
+}
+
+func (t tracedStore) Each(ctx context.Context, fn func(ShadowUser) error) error {
+	ctx, span := tracing.Start(ctx, "shadow.Each", tracing.KindInternal)
+	defer span.End()
+	visited := 0
+	err := t.next.Each(ctx, func(user ShadowUser) error {
+		visited++
+		return fn(user)
+	})
+	span.SetAttribute("shadow.users", visited)
+	span.SetError(err)
+	return err
+}
+
+func (t tracedStore) ListByEmail(ctx context.Context, email string) ([]ShadowUser, error) {
+	ctx, span := tracing.Start(ctx, "shadow.ListByEmail", tracing.KindInternal)
+	defer span.End()
+	users, err := t.next.ListByEmail(ctx, email)
+	span.SetAttribute("shadow.users", len(users))
+	span.SetError(err)
+	return users, err
+}
+
+func (t tracedStore) Delete(ctx context.Context, id string) error {
+	ctx, span := tracing.Start(ctx, "shadow.Delete", tracing.KindInternal)
+	defer span.End()
+	err := t.next.Delete(ctx, id)
+	span.SetError(err)
+	return err
+}
+
+func (t tracedStore) Rename(ctx context.Context, id string, ident Identity, attributes map[string]string) (ShadowUser, error) {
+	ctx, span := tracing.Start(ctx, "shadow.Rename", tracing.KindInternal)
+	defer span.End()
+	user, err := t.next.Rename(ctx, id, ident, attributes)
+	if !errors.Is(err, ErrNotFound) {
+		span.SetError(err)
+	}
+	return user, err
+}
+
+func (t tracedStore) Close(ctx context.Context) error { return t.next.Close(ctx) }
+
+func (t tracedStore) HealthCheck(ctx context.Context) error {
+	ctx, span := tracing.Start(ctx, "shadow.HealthCheck", tracing.KindInternal)
+	defer span.End()
+	err := t.next.HealthCheck(ctx)
+	span.SetError(err)
+	return err
+}
@@ -0,0 +1,247 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// otlpQueueSize bounds the spans waiting to be sent; spans that don't
+	// fit are dropped rather than blocking requests.
+	otlpQueueSize = 2048
+	// otlpBatchSize and otlpFlushInterval bound how long a span waits.
+	otlpBatchSize     = 512
+	otlpFlushInterval = 5 * time.Second
+)
+
+// OTLPExporter sends spans in batches to an OpenTelemetry collector's
+// OTLP/HTTP traces endpoint, JSON-encoded.
+type OTLPExporter struct {
+	endpoint string
+	service  string
+	client   *http.Client
+	logger   *slog.Logger
+
+	queue chan SpanData
+	done  chan struct{}
+
+	mu      sync.Mutex
+	closed  bool
+	dropped int // since the last warning
+}
+
+// NewOTLPExporter starts an exporter for endpoint, the collector's base URL
+// (e.g. http://127.0.0.1:4318, sending to /v1/traces) or its full traces
+// URL, reporting spans as service's.
+func NewOTLPExporter(endpoint, service string, client *http.Client, logger *slog.Logger) (*OTLPExporter, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("OTLP endpoint %q must be an http(s) URL", endpoint)
+	}
+	if strings.TrimRight(u.Path, "/") == "" {
+		u.Path = "/v1/traces"
+	}
+	if logger == nil {
+		logger = slog.Default()
+	}
+	e := &OTLPExporter{
+		endpoint: u.String(),
+		service:  service,
+		client:   client,
+		logger:   logger,
+		queue:    make(chan SpanData, otlpQueueSize),
+		done:     make(chan struct{}),
+	}
+	go e.run()
+	return e, nil
+}
+
+// Export queues span, dropping it when the queue is full.
+func (e *OTLPExporter) Export(span SpanData) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return
+	}
+	select {
+	case e.queue <- span:
+	default:
+		e.dropped++
+	}
+}
+
+// Shutdown stops accepting spans and sends those queued, until ctx ends.
+func (e *OTLPExporter) Shutdown(ctx context.Context) error {
+	e.mu.Lock()
+	if !e.closed {
+		e.closed = true
+		close(e.queue)
+	}
+	e.mu.Unlock()
+	select {
+	case <-e.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("flush spans: %w", ctx.Err())
+	}
+}
+
+func (e *OTLPExporter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(otlpFlushInterval)
+	defer ticker.Stop()
+	batch := make([]SpanData, 0, otlpBatchSize)
+	flush := func() {
+		e.mu.Lock()
+		dropped := e.dropped
+		e.dropped = 0
+		e.mu.Unlock()
+		if dropped > 0 {
+			e.logger.Warn("trace queue full, spans dropped", "dropped", dropped)
+		}
+		if len(batch) == 0 {
+			return
+		}
+		if err := e.send(batch); err != nil {
+			e.logger.Warn("export spans failed", "spans", len(batch), "endpoint", e.endpoint, "err", err)
+		}
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case span, ok := <-e.queue:
+			if !ok {
+				flush()
+				return
+			}
+			if batch = append(batch, span); len(batch) >= otlpBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+func (e *OTLPExporter) send(batch []SpanData) error {
+	body, err := json.Marshal(e.encode(batch))
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector answered %s", resp.Status)
+	}
+	return nil
+}
+
+// The OTLP/HTTP JSON encoding of ExportTraceServiceRequest. IDs are hex and
+// 64-bit integers are strings.
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string          `json:"traceId"`
+		SpanID            string          `json:"spanId"`
+		ParentSpanID      string          `json:"parentSpanId,omitempty"`
+		Name              string          `json:"name"`
+		Kind              Kind            `json:"kind"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+		Attributes        []otlpAttribute `json:"attributes,omitempty"`
+		Status            *otlpStatus     `json:"status,omitempty"`
+	}
+	otlpAttribute struct {
+		Key   string         `json:"key"`
+		Value map[string]any `json:"value"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code"` // 2 is error
+		Message string `json:"message,omitempty"`
+	}
+)
+
+func (e *OTLPExporter) encode(batch []SpanData) otlpRequest {
+	spans := make([]otlpSpan, 0, len(batch))
+	for _, span := range batch {
+		out := otlpSpan{
+			TraceID:           span.Context.TraceID.String(),
+			SpanID:            span.Context.SpanID.String(),
+			Name:              span.Name,
+			Kind:              span.Kind,
+			StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+		}
+		if span.Parent.IsValid() {
+			out.ParentSpanID = span.Parent.String()
+		}
+		for key, value := range span.Attributes {
+			out.Attributes = append(out.Attributes, otlpAttribute{Key: key, Value: otlpValue(value)})
+		}
+		if span.Error != "" {
+			out.Status = &otlpStatus{Code: 2, Message: span.Error}
+		}
+		spans = append(spans, out)
+	}
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpAttribute{{Key: "service.name", Value: otlpValue(e.service)}}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: e.service},
+			Spans: spans,
+		}},
+	}}}
+}
+
+// otlpValue encodes an attribute value as an OTLP AnyValue.
+func otlpValue(value any) map[string]any {
+	switch v := value.(type) {
+	case string:
+		return map[string]any{"stringValue": v}
+	case bool:
+		return map[string]any{"boolValue": v}
+	case int:
+		return map[string]any{"intValue": strconv.Itoa(v)}
+	case int64:
+		return map[string]any{"intValue": strconv.FormatInt(v, 10)}
+	case float64:
+		return map[string]any{"doubleValue": v}
+	}
+	return map[string]any{"stringValue": fmt.Sprint(value)}
+}
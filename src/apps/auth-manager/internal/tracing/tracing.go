@@ -0,0 +1,279 @@
+// Package tracing records request traces and exports them over OTLP/HTTP.
+//
+// A Tracer starts root spans, typically one per inbound request; spans for
+// the work done on its behalf are started from the context with Start and
+// become its children. Without a span in the context Start does nothing, so
+// code can be instrumented unconditionally: with tracing off, the cost is a
+// context lookup. Trace context travels between services in the W3C
+// traceparent header.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceparentHeader carries the W3C trace context.
+const TraceparentHeader = "traceparent"
+
+// TraceID identifies a trace.
+type TraceID [16]byte
+
+// SpanID identifies a span within a trace.
+type SpanID [8]byte
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+func (id SpanID) String() string  { return hex.EncodeToString(id[:]) }
+
+// IsValid reports whether id is non-zero.
+func (id TraceID) IsValid() bool { return id != TraceID{} }
+
+// IsValid reports whether id is non-zero.
+func (id SpanID) IsValid() bool { return id != SpanID{} }
+
+// SpanContext is the part of a span that propagates across services.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+}
+
+// IsValid reports whether sc names a span.
+func (sc SpanContext) IsValid() bool { return sc.TraceID.IsValid() && sc.SpanID.IsValid() }
+
+// Traceparent formats sc as a traceparent header value, always sampled.
+func (sc SpanContext) Traceparent() string {
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-01"
+}
+
+// ParseTraceparent parses a version 00 traceparent header value, or returns
+// false when it isn't one. Later versions are read by their 00 prefix, as
+// the spec asks.
+func ParseTraceparent(value string) (SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return SpanContext{}, false
+	}
+	var sc SpanContext
+	if len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return SpanContext{}, false
+	}
+	if _, err := hex.DecodeString(parts[3]); err != nil || !sc.IsValid() {
+		return SpanContext{}, false
+	}
+	return sc, true
+}
+
+// Kind is a span's role, numbered as in OTLP.
+type Kind int
+
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+	KindClient   Kind = 3
+	KindConsumer Kind = 5
+)
+
+// SpanData is a finished span, as exporters receive it.
+type SpanData struct {
+	Name    string
+	Kind    Kind
+	Context SpanContext
+	Parent  SpanID // zero for root spans
+	Start   time.Time
+	End     time.Time
+	// Attributes hold strings, ints, int64s, bools, and float64s.
+	Attributes map[string]any
+	// Error is the failure the span records, or "".
+	Error string
+}
+
+// Exporter receives finished spans.
+type Exporter interface {
+	// Export takes ownership of span. It must not block.
+	Export(span SpanData)
+	// Shutdown sends what's buffered, until ctx ends.
+	Shutdown(ctx context.Context) error
+}
+
+// Tracer starts root spans and hands finished spans to its exporter. A nil
+// *Tracer starts none.
+type Tracer struct {
+	exporter Exporter
+}
+
+// New returns a Tracer exporting to exporter.
+func New(exporter Exporter) *Tracer {
+	return &Tracer{exporter: exporter}
+}
+
+// Shutdown flushes the exporter.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	return t.exporter.Shutdown(ctx)
+}
+
+type spanKey struct{}
+type remoteKey struct{}
+
+// WithRemoteParent returns ctx carrying sc, a span in another service, as
+// the parent of the next root span Tracer.Start creates from it.
+func WithRemoteParent(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// Start starts a span, the child of ctx's span or remote parent when it has
+// one, and returns ctx carrying it. It does nothing for a nil Tracer.
+func (t *Tracer) Start(ctx context.Context, name string, kind Kind) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	span := &Span{tracer: t, data: SpanData{Name: name, Kind: kind, Start: time.Now()}}
+	if parent := FromContext(ctx); parent != nil {
+		span.data.Context.TraceID, span.data.Parent = parent.data.Context.TraceID, parent.data.Context.SpanID
+	} else if remote, ok := ctx.Value(remoteKey{}).(SpanContext); ok && remote.IsValid() {
+		span.data.Context.TraceID, span.data.Parent = remote.TraceID, remote.SpanID
+	} else {
+		span.data.Context.TraceID = newTraceID()
+	}
+	span.data.Context.SpanID = newSpanID()
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// Start starts a child of ctx's span, or does nothing and returns a nil
+// *Span when ctx has none.
+func Start(ctx context.Context, name string, kind Kind) (context.Context, *Span) {
+	parent := FromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	return parent.tracer.Start(ctx, name, kind)
+}
+
+// FromContext returns ctx's span, or nil.
+func FromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// Inject sets the traceparent header for ctx's span, if it has one.
+func Inject(ctx context.Context, header http.Header) {
+	if span := FromContext(ctx); span != nil {
+		header.Set(TraceparentHeader, span.data.Context.Traceparent())
+	}
+}
+
+// Span is an operation being timed. Its methods do nothing on a nil *Span,
+// so callers needn't check whether Start started one.
+type Span struct {
+	tracer *Tracer
+
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+// Context returns the span's SpanContext, or the zero SpanContext for nil.
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.data.Context
+}
+
+// SetAttribute records key=value on the span.
+func (s *Span) SetAttribute(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data.Attributes == nil {
+		s.data.Attributes = map[string]any{}
+	}
+	s.data.Attributes[key] = value
+}
+
+// SetError marks the span failed with err, when err isn't nil.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Error = err.Error()
+}
+
+// End finishes the span and exports it. Later calls do nothing.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.mu.Unlock()
+	s.tracer.exporter.Export(data)
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	mustRead(id[:])
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	mustRead(id[:])
+	return id
+}
+
+func mustRead(b []byte) {
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Errorf("generate trace ID: %w", err))
+	}
+}
+
+// Recorder is an Exporter that keeps spans in memory, for tests.
+type Recorder struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+// NewRecorder returns an empty Recorder.
+func NewRecorder() *Recorder { return &Recorder{} }
+
+// Export records span.
+func (r *Recorder) Export(span SpanData) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, span)
+}
+
+// Shutdown does nothing.
+func (r *Recorder) Shutdown(context.Context) error { return nil }
+
+// Spans returns the spans recorded so far, in the order they ended.
+func (r *Recorder) Spans() []SpanData {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]SpanData(nil), r.spans...)
+}
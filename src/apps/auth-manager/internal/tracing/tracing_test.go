@@ -0,0 +1,173 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	const valid = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, ok := ParseTraceparent(valid)
+	if !ok || sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanID.String() != "00f067aa0ba902b7" {
+		t.Fatalf("ParseTraceparent(%q) = %v, %v", valid, sc, ok)
+	}
+	if got := sc.Traceparent(); got != valid {
+		t.Errorf("Traceparent() = %q, want %q", got, valid)
+	}
+	if _, ok := ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra"); !ok {
+		t.Error("rejected a later version with extra fields")
+	}
+	for _, bad := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473x-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	} {
+		if _, ok := ParseTraceparent(bad); ok {
+			t.Errorf("ParseTraceparent(%q) accepted", bad)
+		}
+	}
+}
+
+func TestSpanParentage(t *testing.T) {
+	rec := NewRecorder()
+	tracer := New(rec)
+	remote, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	ctx, root := tracer.Start(WithRemoteParent(context.Background(), remote), "GET /webhook", KindServer)
+	_, child := Start(ctx, "store.upsert", KindInternal)
+	child.SetError(context.DeadlineExceeded)
+	child.End()
+	root.End()
+	root.End()
+
+	spans := rec.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("recorded %d spans, want 2", len(spans))
+	}
+	gotChild, gotRoot := spans[0], spans[1]
+	if gotRoot.Context.TraceID != remote.TraceID || gotRoot.Parent != remote.SpanID {
+		t.Errorf("root span %v, want the remote parent's trace and span", gotRoot)
+	}
+	if gotChild.Context.TraceID != remote.TraceID || gotChild.Parent != gotRoot.Context.SpanID {
+		t.Errorf("child span %v, want the root span as parent", gotChild)
+	}
+	if gotChild.Error != context.DeadlineExceeded.Error() {
+		t.Errorf("child error = %q", gotChild.Error)
+	}
+
+	if ctx, span := Start(context.Background(), "orphan", KindInternal); span != nil || ctx != context.Background() {
+		t.Error("Start without a parent span started one")
+	}
+	var nilTracer *Tracer
+	if _, span := nilTracer.Start(context.Background(), "root", KindServer); span != nil {
+		t.Error("a nil Tracer started a span")
+	}
+}
+
+func TestTransport(t *testing.T) {
+	var traceparent string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get(TraceparentHeader)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer backend.Close()
+	client := &http.Client{Transport: Transport("mattermost", nil)}
+
+	rec := NewRecorder()
+	ctx, root := New(rec).Start(context.Background(), "root", KindServer)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, backend.URL+"/api/v4/users/me", nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	root.End()
+
+	spans := rec.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("recorded %d spans, want 2", len(spans))
+	}
+	span := spans[0]
+	if span.Name != "mattermost GET" || span.Kind != KindClient || span.Parent != root.Context().SpanID {
+		t.Errorf("client span = %+v", span)
+	}
+	if span.Attributes["http.response.status_code"] != http.StatusNotFound || span.Error != "HTTP 404" {
+		t.Errorf("client span attributes %v, error %q", span.Attributes, span.Error)
+	}
+	if want := span.Context.Traceparent(); traceparent != want {
+		t.Errorf("backend got traceparent %q, want %q", traceparent, want)
+	}
+	if req.Header.Get(TraceparentHeader) != "" {
+		t.Error("Transport modified the caller's request")
+	}
+
+	traceparent = "unset"
+	req, _ = http.NewRequest(http.MethodGet, backend.URL, nil)
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if traceparent != "" || len(rec.Spans()) != 2 {
+		t.Errorf("untraced request sent traceparent %q or recorded a span", traceparent)
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	bodies := make(chan otlpRequest, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("collector got %s with Content-Type %q", r.URL.Path, r.Header.Get("Content-Type"))
+		}
+		var body otlpRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		bodies <- body
+	}))
+	defer collector.Close()
+
+	if _, err := NewOTLPExporter("collector:4318", "auth-manager", collector.Client(), nil); err == nil {
+		t.Error("accepted an endpoint without a scheme")
+	}
+	exporter, err := NewOTLPExporter(collector.URL, "auth-manager", collector.Client(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, root := New(exporter).Start(context.Background(), "root", KindServer)
+	_, child := Start(ctx, "child", KindInternal)
+	child.SetAttribute("store", "memory")
+	child.SetError(context.Canceled)
+	child.End()
+	root.End()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := exporter.Shutdown(shutdownCtx); err != nil {
+		t.Fatal(err)
+	}
+	body := <-bodies
+	if len(body.ResourceSpans) != 1 || body.ResourceSpans[0].Resource.Attributes[0].Value["stringValue"] != "auth-manager" {
+		t.Fatalf("resource = %+v, want service.name auth-manager", body.ResourceSpans)
+	}
+	spans := body.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("exported %d spans, want 2", len(spans))
+	}
+	if spans[0].ParentSpanID != spans[1].SpanID || spans[1].ParentSpanID != "" || spans[0].TraceID != root.Context().TraceID.String() {
+		t.Errorf("spans = %+v, want the child under the root", spans)
+	}
+	if spans[0].Status == nil || spans[0].Status.Code != 2 || !strings.Contains(spans[0].Status.Message, "canceled") {
+		t.Errorf("child status = %+v, want an error", spans[0].Status)
+	}
+	exporter.Export(SpanData{Name: "late"})
+}
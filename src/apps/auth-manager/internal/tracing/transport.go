@@ -0,0 +1,52 @@
+package tracing
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// Transport wraps next to time each request made within a span as a client
+// span named for service, and to send its traceparent downstream. Requests
+// made outside a span pass through untouched.
+func Transport(service string, next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return transport{service: service, next: next}
+}
+
+type transport struct {
+	service string
+	next    http.RoundTripper
+}
+
+func (t transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := Start(req.Context(), t.service+" "+req.Method, KindClient)
+	if span == nil {
+		return t.next.RoundTrip(req)
+	}
+	defer span.End()
+	span.SetAttribute("peer.service", t.service)
+	span.SetAttribute("http.request.method", req.Method)
+	span.SetAttribute("server.address", req.URL.Host)
+	span.SetAttribute("url.path", req.URL.Path)
+
+	// RoundTrippers mustn't modify the caller's request.
+	req = req.Clone(ctx)
+	Inject(ctx, req.Header)
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		span.SetError(err)
+		return nil, err
+	}
+	span.SetAttribute("http.response.status_code", resp.StatusCode)
+	if resp.StatusCode >= 400 {
+		span.SetError(statusError(resp.StatusCode))
+	}
+	return resp, nil
+}
+
+// statusError is the error recorded for an HTTP error status.
+type statusError int
+
+func (e statusError) Error() string { return "HTTP " + strconv.Itoa(int(e)) }
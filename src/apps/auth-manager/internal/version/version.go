@@ -0,0 +1,84 @@
+// Package version reports which build of auth-manager is running.
+//
+// Release builds set the variables with -ldflags, e.g.
+//
+//	go build -ldflags "-X github.com/rave-org/rave/apps/auth-manager/internal/version.Version=v1.2.3 \
+//	  -X github.com/rave-org/rave/apps/auth-manager/internal/version.Commit=$(git rev-parse HEAD) \
+//	  -X github.com/rave-org/rave/apps/auth-manager/internal/version.Date=$(date -u +%FT%TZ)" ./cmd/auth-manager
+//
+// Unset variables fall back to the module version and VCS stamp Go records
+// in the binary.
+package version
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+// Set at build time with -ldflags -X.
+var (
+	Version string
+	Commit  string
+	Date    string
+)
+
+// Info describes a build.
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	Date      string `json:"date,omitempty"`
+	Modified  bool   `json:"modified,omitempty"` // built from a dirty tree
+	GoVersion string `json:"go_version"`
+}
+
+// Get returns the running build's info.
+func Get() Info {
+	info := Info{Version: Version, Commit: Commit, Date: Date, GoVersion: runtime.Version()}
+	if build, ok := debug.ReadBuildInfo(); ok {
+		fillFromBuildInfo(&info, build)
+	}
+	if info.Version == "" {
+		info.Version = "(devel)"
+	}
+	return info
+}
+
+// fillFromBuildInfo fills the fields the linker flags left empty.
+func fillFromBuildInfo(info *Info, build *debug.BuildInfo) {
+	if info.Version == "" {
+		info.Version = build.Main.Version
+	}
+	for _, s := range build.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			if info.Commit == "" {
+				info.Commit = s.Value
+			}
+		case "vcs.time":
+			if info.Date == "" {
+				info.Date = s.Value
+			}
+		case "vcs.modified":
+			info.Modified = s.Value == "true"
+		}
+	}
+}
+
+// String formats info for --version and logs.
+func (info Info) String() string {
+	var details []string
+	if info.Commit != "" {
+		commit := info.Commit
+		if info.Modified {
+			commit += "-dirty"
+		}
+		details = append(details, commit)
+	}
+	if info.Date != "" {
+		details = append(details, info.Date)
+	}
+	details = append(details, info.GoVersion)
+	return fmt.Sprintf("auth-manager %s (%s)", info.Version, strings.Join(details, ", "))
+}
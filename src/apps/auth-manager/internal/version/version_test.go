@@ -0,0 +1,33 @@
+package version
+
+import (
+	"runtime/debug"
+	"testing"
+)
+
+func TestFillFromBuildInfo(t *testing.T) {
+	build := &debug.BuildInfo{
+		Main: debug.Module{Version: "v0.4.0"},
+		Settings: []debug.BuildSetting{
+			{Key: "vcs.revision", Value: "abc123"},
+			{Key: "vcs.time", Value: "2024-05-01T10:00:00Z"},
+			{Key: "vcs.modified", Value: "true"},
+		},
+	}
+
+	info := Info{GoVersion: "go1.21.0"}
+	fillFromBuildInfo(&info, build)
+	want := Info{Version: "v0.4.0", Commit: "abc123", Date: "2024-05-01T10:00:00Z", Modified: true, GoVersion: "go1.21.0"}
+	if info != want {
+		t.Errorf("info = %+v, want %+v", info, want)
+	}
+	if got := info.String(); got != "auth-manager v0.4.0 (abc123-dirty, 2024-05-01T10:00:00Z, go1.21.0)" {
+		t.Errorf("String = %q", got)
+	}
+
+	info = Info{Version: "v1.0.0", Commit: "release", Date: "2024-06-01"}
+	fillFromBuildInfo(&info, build)
+	if info.Version != "v1.0.0" || info.Commit != "release" || info.Date != "2024-06-01" {
+		t.Errorf("linker flags overridden by build info: %+v", info)
+	}
+}
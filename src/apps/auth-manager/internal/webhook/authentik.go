@@ -6,10 +6,14 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/accounts"
 )
 
 // Event actions from Authentik
@@ -20,21 +24,77 @@ const (
 	ActionLogin        = "login"
 	ActionLogout       = "logout"
 	ActionUserWrite    = "user_write"
+	ActionPasswordSet  = "password_set"
+)
+
+// Notification severities used by Authentik, lowest to highest.
+const (
+	SeverityNotice  = "notice"
+	SeverityWarning = "warning"
+	SeverityAlert   = "alert"
+)
+
+// SeverityRank orders Authentik severities so they can be compared. Unknown
+// severities rank below notice.
+func SeverityRank(severity string) int {
+	switch strings.ToLower(strings.TrimSpace(severity)) {
+	case SeverityNotice:
+		return 1
+	case SeverityWarning:
+		return 2
+	case SeverityAlert:
+		return 3
+	default:
+		return 0
+	}
+}
+
+// MaxPayloadBytes caps the size of a webhook body accepted by ParseRequest.
+const MaxPayloadBytes = 1 << 20 // 1MB
+
+// Errors returned by ParseRequest. Callers should use errors.Is to classify a
+// failure; ErrorClass maps them to stable labels for responses and metrics.
+var (
+	ErrMissingAuth      = errors.New("missing webhook authentication")
+	ErrBadSignature     = errors.New("invalid webhook signature")
+	ErrMalformedPayload = errors.New("malformed webhook payload")
+	ErrPayloadTooLarge  = errors.New("webhook payload too large")
 )
 
+// ErrorClass returns a short, stable label for a ParseRequest error.
+func ErrorClass(err error) string {
+	switch {
+	case errors.Is(err, ErrMissingAuth):
+		return "missing_auth"
+	case errors.Is(err, ErrBadSignature):
+		return "bad_signature"
+	case errors.Is(err, ErrMalformedPayload):
+		return "malformed_payload"
+	case errors.Is(err, ErrPayloadTooLarge):
+		return "payload_too_large"
+	default:
+		return "unknown"
+	}
+}
+
 // AuthentikEvent represents a webhook payload from Authentik's notification system.
 // See: https://docs.goauthentik.io/sys-mgmt/events/transports/
 type AuthentikEvent struct {
 	// Standard webhook fields
-	Body          string `json:"body"`
-	Severity      string `json:"severity"`
-	UserEmail     string `json:"user_email"`
-	UserUsername  string `json:"user_username"`
+	Body              string `json:"body"`
+	Severity          string `json:"severity"`
+	UserEmail         string `json:"user_email"`
+	UserUsername      string `json:"user_username"`
 	EventUserEmail    string `json:"event_user_email"`
 	EventUserUsername string `json:"event_user_username"`
 
 	// Event context (when using custom body mapping)
 	Event *EventContext `json:"event,omitempty"`
+
+	// Warnings lists the fields Parse had to coerce or drop.
+	Warnings []Warning `json:"-"`
+
+	hints *bodyHints // Parsed from Body on first use
 }
 
 // EventContext contains the actual event data when using a custom body mapping.
@@ -46,6 +106,8 @@ type EventContext struct {
 	Context   map[string]interface{} `json:"context"`
 	User      *EventUser             `json:"user"`
 	Created   time.Time              `json:"created"`
+
+	warnings []Warning
 }
 
 // EventUser represents user info in event context.
@@ -54,6 +116,150 @@ type EventUser struct {
 	Email    string `json:"email"`
 	Username string `json:"username"`
 	Name     string `json:"name"`
+
+	warnings []Warning
+}
+
+// Warning is a payload field that had the wrong shape and was coerced or
+// dropped instead of failing the payload, which usually means an Authentik
+// property mapping is misconfigured.
+type Warning struct {
+	Field   string // Path in the payload, such as "event.user.pk"
+	Kind    string // One of the Warning* kinds
+	Message string
+}
+
+// Warning kinds.
+const (
+	WarningStringPK       = "string_pk"        // a pk sent as a string of digits, converted
+	WarningInvalidPK      = "invalid_pk"       // a pk that isn't a whole number, ignored
+	WarningNumberObjectPK = "number_object_pk" // object_pk sent as a number, converted
+	WarningStringContext  = "string_context"   // context sent as a JSON-encoded string, decoded
+	WarningInvalidContext = "invalid_context"  // context that isn't an object, ignored
+)
+
+// UnmarshalJSON decodes the event, accepting object_pk as a number and
+// context as a JSON-encoded string, with a warning for each.
+func (c *EventContext) UnmarshalJSON(data []byte) error {
+	type plain EventContext
+	var wire struct {
+		plain
+		ObjectPK json.RawMessage `json:"object_pk"`
+		Context  json.RawMessage `json:"context"`
+	}
+	if err := json.Unmarshal(data, &wire); err != nil {
+		return err
+	}
+	*c = EventContext(wire.plain)
+	c.warnings = nil
+	c.ObjectPK = c.decodeObjectPK(wire.ObjectPK)
+	c.Context = c.decodeContext(wire.Context)
+	return nil
+}
+
+func (c *EventContext) warn(field, kind, message string) {
+	c.warnings = append(c.warnings, Warning{Field: field, Kind: kind, Message: message})
+}
+
+func (c *EventContext) decodeObjectPK(raw json.RawMessage) string {
+	if isNull(raw) {
+		return ""
+	}
+	var pk string
+	if err := json.Unmarshal(raw, &pk); err == nil {
+		return pk
+	}
+	var n json.Number
+	if err := json.Unmarshal(raw, &n); err == nil {
+		c.warn("event.object_pk", WarningNumberObjectPK, "event.object_pk is a number; converted to a string")
+		return n.String()
+	}
+	c.warn("event.object_pk", WarningInvalidPK, "event.object_pk is "+jsonKind(raw)+"; ignored")
+	return ""
+}
+
+func (c *EventContext) decodeContext(raw json.RawMessage) map[string]interface{} {
+	if isNull(raw) {
+		return nil
+	}
+	var ctx map[string]interface{}
+	if err := json.Unmarshal(raw, &ctx); err != nil {
+		var encoded string
+		if json.Unmarshal(raw, &encoded) != nil || json.Unmarshal([]byte(encoded), &ctx) != nil || ctx == nil {
+			c.warn("event.context", WarningInvalidContext, "event.context is "+jsonKind(raw)+", not an object; ignored")
+			return nil
+		}
+		c.warn("event.context", WarningStringContext, "event.context is a JSON-encoded string; decoded")
+	}
+	if pk, ok := ctx["pk"].(string); ok {
+		if n, err := strconv.Atoi(strings.TrimSpace(pk)); err == nil {
+			ctx["pk"] = float64(n)
+			c.warn("event.context.pk", WarningStringPK, fmt.Sprintf("event.context.pk is the string %q; converted to a number", pk))
+		} else {
+			c.warn("event.context.pk", WarningInvalidPK, "event.context.pk is a string that isn't a whole number; ignored")
+		}
+	}
+	return ctx
+}
+
+// UnmarshalJSON decodes the user, accepting a pk sent as a string of digits
+// with a warning.
+func (u *EventUser) UnmarshalJSON(data []byte) error {
+	type plain EventUser
+	var wire struct {
+		plain
+		PK json.RawMessage `json:"pk"`
+	}
+	if err := json.Unmarshal(data, &wire); err != nil {
+		return err
+	}
+	*u = EventUser(wire.plain)
+	u.warnings = nil
+	u.PK = 0
+	if isNull(wire.PK) {
+		return nil
+	}
+	if err := json.Unmarshal(wire.PK, &u.PK); err == nil {
+		return nil
+	}
+	var pk string
+	if err := json.Unmarshal(wire.PK, &pk); err == nil {
+		if n, err := strconv.Atoi(strings.TrimSpace(pk)); err == nil {
+			u.PK = n
+			u.warnings = append(u.warnings, Warning{Field: "event.user.pk", Kind: WarningStringPK,
+				Message: fmt.Sprintf("event.user.pk is the string %q; converted to a number", pk)})
+			return nil
+		}
+		u.warnings = append(u.warnings, Warning{Field: "event.user.pk", Kind: WarningInvalidPK,
+			Message: "event.user.pk is a string that isn't a whole number; ignored"})
+		return nil
+	}
+	u.warnings = append(u.warnings, Warning{Field: "event.user.pk", Kind: WarningInvalidPK,
+		Message: "event.user.pk is " + jsonKind(wire.PK) + ", not a whole number; ignored"})
+	return nil
+}
+
+func isNull(raw json.RawMessage) bool {
+	return len(raw) == 0 || string(raw) == "null"
+}
+
+// jsonKind names the JSON type of raw, for warnings that mustn't quote
+// values that could be personal data.
+func jsonKind(raw json.RawMessage) string {
+	switch trimmed := strings.TrimSpace(string(raw)); {
+	case trimmed == "":
+		return "empty"
+	case trimmed[0] == '"':
+		return "a string"
+	case trimmed[0] == '{':
+		return "an object"
+	case trimmed[0] == '[':
+		return "an array"
+	case trimmed == "true" || trimmed == "false":
+		return "a boolean"
+	default:
+		return "a number"
+	}
 }
 
 // UserInfo extracts user information from the event, handling both standard
@@ -63,10 +269,47 @@ type UserInfo struct {
 	Username string
 	Name     string
 	Subject  string // Authentik user PK as string
+	Provider string // Shadow store provider; empty means "authentik"
+	// Groups the identity belongs to. nil means the source didn't report
+	// groups, which is distinct from an empty membership.
+	Groups []string
+	// FromRecipient marks a native payload's notification recipient taken
+	// as the user because nothing else named one ("minimal mode"). The
+	// recipient is often an admin on the notified group rather than the
+	// event's user, so it's only safe to provision, never to deprovision
+	// or revoke sessions.
+	FromRecipient bool
+}
+
+// DefaultProvider is the shadow store provider for the default Authentik source.
+const DefaultProvider = "authentik"
+
+// ShadowProvider returns the shadow store provider label for this user.
+func (u *UserInfo) ShadowProvider() string {
+	if u.Provider == "" {
+		return DefaultProvider
+	}
+	return u.Provider
+}
+
+// ShadowSubject returns the shadow store subject, falling back to the email
+// when the event carried no Authentik PK.
+func (u *UserInfo) ShadowSubject() string {
+	if u.Subject == "" {
+		return u.Email
+	}
+	return u.Subject
 }
 
 // ExtractUser pulls user info from various places in the event payload.
+// The email is normalized (see accounts.NormalizeEmail).
 func (e *AuthentikEvent) ExtractUser() *UserInfo {
+	info := e.extractUser()
+	info.Email = accounts.NormalizeEmail(info.Email)
+	return info
+}
+
+func (e *AuthentikEvent) extractUser() *UserInfo {
 	info := &UserInfo{}
 
 	// Try event context first (custom body mapping)
@@ -94,6 +337,21 @@ func (e *AuthentikEvent) ExtractUser() *UserInfo {
 		}
 	}
 
+	if e.Event == nil {
+		h := e.bodyHints()
+		if h.isUserModel() {
+			// For model events on a user, event_user_* describe the actor
+			// (often an admin), not the affected user, so only the model's
+			// pk and name are trusted.
+			info.Subject = h.PK
+			info.Username = h.Name
+			info.Email = h.Email
+			return info
+		}
+		info.Username = h.Username
+		info.Email = h.Email
+	}
+
 	// Fall back to standard webhook fields
 	if info.Email == "" {
 		info.Email = e.EventUserEmail
@@ -102,41 +360,86 @@ func (e *AuthentikEvent) ExtractUser() *UserInfo {
 		info.Username = e.EventUserUsername
 	}
 
+	// Native payloads carrying nothing but the notification user are treated
+	// as being about that user ("minimal mode").
+	if e.Event == nil && info.Email == "" && info.Username == "" && (e.UserEmail != "" || e.UserUsername != "") {
+		info.Email = e.UserEmail
+		info.Username = e.UserUsername
+		info.FromRecipient = true
+	}
+
 	return info
 }
 
-// IsUserEvent returns true if this event is about a user model.
+// IsUserEvent returns true if this event is about a user model. Native
+// payloads qualify when their body describes a user model event.
 func (e *AuthentikEvent) IsUserEvent() bool {
 	if e.Event == nil {
-		return false
+		return e.bodyHints().isUserModel()
 	}
 	return strings.EqualFold(e.Event.ModelName, "user") ||
 		strings.EqualFold(e.Event.App, "authentik_core") && strings.Contains(strings.ToLower(e.Event.ModelName), "user")
 }
 
-// Action returns the event action (model_created, login, etc.)
+// IsCredentialEvent returns true if the event signals a password change or an
+// MFA authenticator being enrolled, changed, or removed. Authentik reports
+// authenticator changes as model events on its *device models (totpdevice,
+// webauthndevice, staticdevice, ...).
+func (e *AuthentikEvent) IsCredentialEvent() bool {
+	var action, app, modelName string
+	if e.Event != nil {
+		action, app, modelName = e.Event.Action, e.Event.App, e.Event.ModelName
+	} else {
+		h := e.bodyHints()
+		action, app, modelName = h.Action, h.App, h.ModelName
+	}
+	switch action {
+	case ActionPasswordSet:
+		return true
+	case ActionModelCreated, ActionModelUpdated, ActionModelDeleted:
+		return isAuthenticatorModel(app, modelName)
+	}
+	return false
+}
+
+func isAuthenticatorModel(app, modelName string) bool {
+	if strings.HasPrefix(strings.ToLower(app), "authentik_stages_authenticator") {
+		return true
+	}
+	return strings.HasSuffix(strings.ToLower(modelName), "device")
+}
+
+// Action returns the event action (model_created, login, etc.), recovered
+// from the body summary for native payloads.
 func (e *AuthentikEvent) Action() string {
 	if e.Event != nil {
 		return e.Event.Action
 	}
-	return ""
+	return e.bodyHints().Action
 }
 
 func intToString(i int) string {
 	if i == 0 {
 		return ""
 	}
-	return strings.TrimSpace(strings.Replace(string(rune(i)), "\x00", "", -1))
+	return strconv.Itoa(i)
 }
 
 // ParseRequest reads and validates a webhook request from Authentik.
 // If secret is non-empty, it validates the X-Authentik-Signature header.
 func ParseRequest(r *http.Request, secret string) (*AuthentikEvent, error) {
-	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20)) // 1MB limit
+	defer r.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(r.Body, MaxPayloadBytes+1))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return nil, fmt.Errorf("%w: exceeds %d bytes", ErrPayloadTooLarge, tooLarge.Limit)
+	}
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: read body: %v", ErrMalformedPayload, err)
+	}
+	if len(body) > MaxPayloadBytes {
+		return nil, fmt.Errorf("%w: exceeds %d bytes", ErrPayloadTooLarge, MaxPayloadBytes)
 	}
-	defer r.Body.Close()
 
 	// Validate signature if secret is configured
 	if secret != "" {
@@ -146,24 +449,41 @@ func ParseRequest(r *http.Request, secret string) (*AuthentikEvent, error) {
 			auth := r.Header.Get("Authorization")
 			if strings.HasPrefix(auth, "Bearer ") {
 				if strings.TrimPrefix(auth, "Bearer ") != secret {
-					return nil, errors.New("invalid bearer token")
+					return nil, fmt.Errorf("%w: invalid bearer token", ErrBadSignature)
 				}
 			} else {
-				return nil, errors.New("missing signature header")
+				return nil, fmt.Errorf("%w: missing signature header", ErrMissingAuth)
 			}
 		} else {
 			// HMAC-SHA256 signature validation
 			if !validateSignature(body, sig, secret) {
-				return nil, errors.New("invalid signature")
+				return nil, ErrBadSignature
 			}
 		}
 	}
 
+	return Parse(body)
+}
+
+// Parse decodes an Authentik notification that arrived by other means than
+// a webhook, such as an event stream, where there's no signature to check.
+// Fields misconfigured mappings get subtly wrong, a pk sent as a string or
+// context as a JSON-encoded string, are coerced rather than failing the
+// payload or being zeroed silently, and listed in the event's Warnings.
+func Parse(body []byte) (*AuthentikEvent, error) {
+	if len(body) > MaxPayloadBytes {
+		return nil, fmt.Errorf("%w: exceeds %d bytes", ErrPayloadTooLarge, MaxPayloadBytes)
+	}
 	var event AuthentikEvent
 	if err := json.Unmarshal(body, &event); err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %v", ErrMalformedPayload, err)
+	}
+	if e := event.Event; e != nil {
+		event.Warnings = append(event.Warnings, e.warnings...)
+		if e.User != nil {
+			event.Warnings = append(event.Warnings, e.User.warnings...)
+		}
 	}
-
 	return &event, nil
 }
 
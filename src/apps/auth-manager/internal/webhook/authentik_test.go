@@ -2,8 +2,10 @@ package webhook
 
 import (
 	"bytes"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
@@ -128,6 +130,17 @@ func TestExtractUser_FromContext(t *testing.T) {
 	}
 }
 
+func TestExtractUser_NormalizesEmail(t *testing.T) {
+	for _, event := range []*AuthentikEvent{
+		{Event: &EventContext{User: &EventUser{PK: 7, Email: " Jane.Doe@Example.COM"}}},
+		{EventUserEmail: "Jane.Doe@EXAMPLE.com"},
+	} {
+		if info := event.ExtractUser(); info.Email != "jane.doe@example.com" {
+			t.Errorf("ExtractUser() email = %q, want it normalized", info.Email)
+		}
+	}
+}
+
 func TestExtractUser_FromEventUser(t *testing.T) {
 	event := &AuthentikEvent{
 		Event: &EventContext{
@@ -203,3 +216,219 @@ func TestIsUserEvent(t *testing.T) {
 		})
 	}
 }
+
+func TestIsCredentialEvent(t *testing.T) {
+	tests := []struct {
+		name     string
+		event    *AuthentikEvent
+		expected bool
+	}{
+		{
+			name:     "nil event",
+			event:    &AuthentikEvent{},
+			expected: false,
+		},
+		{
+			name: "password set",
+			event: &AuthentikEvent{
+				Event: &EventContext{Action: ActionPasswordSet, App: "authentik_events"},
+			},
+			expected: true,
+		},
+		{
+			name: "totp device created",
+			event: &AuthentikEvent{
+				Event: &EventContext{
+					Action:    ActionModelCreated,
+					App:       "authentik_stages_authenticator_totp",
+					ModelName: "totpdevice",
+				},
+			},
+			expected: true,
+		},
+		{
+			name: "webauthn device deleted",
+			event: &AuthentikEvent{
+				Event: &EventContext{Action: ActionModelDeleted, ModelName: "webauthndevice"},
+			},
+			expected: true,
+		},
+		{
+			name: "user model updated",
+			event: &AuthentikEvent{
+				Event: &EventContext{Action: ActionModelUpdated, App: "authentik_core", ModelName: "user"},
+			},
+			expected: false,
+		},
+		{
+			name: "login",
+			event: &AuthentikEvent{
+				Event: &EventContext{Action: ActionLogin, ModelName: "user"},
+			},
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.event.IsCredentialEvent(); got != tt.expected {
+				t.Errorf("IsCredentialEvent() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseRequest_ErrorClasses(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		header map[string]string
+		want   error
+		class  string
+	}{
+		{
+			name:   "invalid bearer token",
+			body:   `{"severity": "notice"}`,
+			header: map[string]string{"Authorization": "Bearer wrong-secret"},
+			want:   ErrBadSignature,
+			class:  "bad_signature",
+		},
+		{
+			name:   "invalid hmac signature",
+			body:   `{"severity": "notice"}`,
+			header: map[string]string{"X-Authentik-Signature": "deadbeef"},
+			want:   ErrBadSignature,
+			class:  "bad_signature",
+		},
+		{
+			name:  "missing auth",
+			body:  `{"severity": "notice"}`,
+			want:  ErrMissingAuth,
+			class: "missing_auth",
+		},
+		{
+			name:   "invalid json with valid bearer",
+			body:   `{"severity": `,
+			header: map[string]string{"Authorization": "Bearer test-secret"},
+			want:   ErrMalformedPayload,
+			class:  "malformed_payload",
+		},
+		{
+			name:   "2MB body",
+			body:   `{"body": "` + strings.Repeat("a", 2<<20) + `"}`,
+			header: map[string]string{"Authorization": "Bearer test-secret"},
+			want:   ErrPayloadTooLarge,
+			class:  "payload_too_large",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/webhook/authentik", bytes.NewBufferString(tt.body))
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+
+			_, err := ParseRequest(req, "test-secret")
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, err)
+			}
+			if got := ErrorClass(err); got != tt.class {
+				t.Errorf("ErrorClass() = %q, want %q", got, tt.class)
+			}
+		})
+	}
+}
+
+func TestParse_CoercesMisShapedFields(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		wantSubject string
+		wantEmail   string
+		wantKinds   []string
+	}{
+		{
+			name:        "well formed",
+			body:        `{"event":{"action":"model_created","model_name":"user","object_pk":"5","context":{"pk":5,"email":"a@example.com"}}}`,
+			wantSubject: "5",
+			wantEmail:   "a@example.com",
+		},
+		{
+			name:        "string user pk",
+			body:        `{"event":{"action":"model_created","model_name":"user","user":{"pk":"42","email":"a@example.com"}}}`,
+			wantSubject: "42",
+			wantEmail:   "a@example.com",
+			wantKinds:   []string{WarningStringPK},
+		},
+		{
+			name:        "string context pk",
+			body:        `{"event":{"action":"model_created","model_name":"user","context":{"pk":"7","email":"a@example.com"}}}`,
+			wantSubject: "7",
+			wantEmail:   "a@example.com",
+			wantKinds:   []string{WarningStringPK},
+		},
+		{
+			name:        "context as encoded string",
+			body:        `{"event":{"action":"model_created","model_name":"user","context":"{\"pk\": 9, \"email\": \"a@example.com\"}"}}`,
+			wantSubject: "9",
+			wantEmail:   "a@example.com",
+			wantKinds:   []string{WarningStringContext},
+		},
+		{
+			name:      "number object pk",
+			body:      `{"event":{"action":"model_created","model_name":"user","object_pk":12,"context":{"email":"a@example.com"}}}`,
+			wantEmail: "a@example.com",
+			wantKinds: []string{WarningNumberObjectPK},
+		},
+		{
+			name:      "invalid pk and context",
+			body:      `{"event":{"action":"model_created","model_name":"user","context":"not json","user":{"pk":"abc","email":"a@example.com"}}}`,
+			wantEmail: "a@example.com",
+			wantKinds: []string{WarningInvalidContext, WarningInvalidPK},
+		},
+		{
+			name:      "fractional pk",
+			body:      `{"event":{"action":"model_created","model_name":"user","user":{"pk":1.5,"email":"a@example.com"}}}`,
+			wantEmail: "a@example.com",
+			wantKinds: []string{WarningInvalidPK},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event, err := Parse([]byte(tt.body))
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			info := event.ExtractUser()
+			if info.Subject != tt.wantSubject || info.Email != tt.wantEmail {
+				t.Errorf("ExtractUser() = subject %q email %q, want %q %q", info.Subject, info.Email, tt.wantSubject, tt.wantEmail)
+			}
+			var kinds []string
+			for _, w := range event.Warnings {
+				kinds = append(kinds, w.Kind)
+				if w.Field == "" || w.Message == "" {
+					t.Errorf("warning %+v is missing its field or message", w)
+				}
+			}
+			if strings.Join(kinds, ",") != strings.Join(tt.wantKinds, ",") {
+				t.Errorf("warning kinds = %v, want %v", kinds, tt.wantKinds)
+			}
+		})
+	}
+}
+
+func TestParse_WarningsOmitValues(t *testing.T) {
+	event, err := Parse([]byte(`{"event":{"action":"model_created","context":["secret@example.com"],"user":{"pk":"secret@example.com"}}}`))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if len(event.Warnings) != 2 {
+		t.Fatalf("Warnings = %+v, want 2", event.Warnings)
+	}
+	for _, w := range event.Warnings {
+		if strings.Contains(w.Message, "secret") {
+			t.Errorf("warning %q quotes the payload", w.Message)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package webhook
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Authentik's default notification transport (no custom body mapping) sends
+// only body/severity/user_* fields. The body is the event summary, which is
+// either a context "message" or "<action>: <python repr of context>", e.g.
+//
+//	model_created: {'model': {'pk': 42, 'app': 'authentik_core', 'name': 'jane', 'model_name': 'user'}, ...}
+//
+// The helpers below recover what they can from that text with tolerant
+// patterns that accept both Python-repr and JSON quoting.
+var (
+	bodyActionRe    = regexp.MustCompile(`^\s*([a-z_]+):\s`)
+	bodyAppRe       = bodyFieldRe("app")
+	bodyModelNameRe = bodyFieldRe("model_name")
+	bodyNameRe      = bodyFieldRe("name")
+	bodyUsernameRe  = bodyFieldRe("username")
+	bodyEmailRe     = bodyFieldRe("email")
+	bodyPKRe        = regexp.MustCompile(`["']pk["']\s*:\s*["']?(\d+)`)
+	bodyAnyEmailRe  = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+)
+
+func bodyFieldRe(key string) *regexp.Regexp {
+	return regexp.MustCompile(`["']` + key + `["']\s*:\s*["']([^"']*)["']`)
+}
+
+// bodyHints is the information recoverable from a native notification body.
+type bodyHints struct {
+	Action    string
+	App       string
+	ModelName string
+	PK        string
+	Name      string
+	Username  string
+	Email     string
+}
+
+// IsNative reports whether the event arrived in Authentik's default
+// notification format rather than a custom body mapping with an event object.
+func (e *AuthentikEvent) IsNative() bool {
+	return e.Event == nil
+}
+
+// bodyHints returns what Body says, parsing it on the first call only.
+func (e *AuthentikEvent) bodyHints() bodyHints {
+	if e.hints == nil {
+		h := parseBodyHints(e.Body)
+		e.hints = &h
+	}
+	return *e.hints
+}
+
+func parseBodyHints(body string) bodyHints {
+	var h bodyHints
+	if strings.TrimSpace(body) == "" {
+		return h
+	}
+	if m := bodyActionRe.FindStringSubmatch(body); m != nil {
+		h.Action = m[1]
+	}
+	h.App = firstSubmatch(bodyAppRe, body)
+	h.ModelName = firstSubmatch(bodyModelNameRe, body)
+	h.PK = firstSubmatch(bodyPKRe, body)
+	h.Name = firstSubmatch(bodyNameRe, body)
+	h.Username = firstSubmatch(bodyUsernameRe, body)
+	h.Email = firstSubmatch(bodyEmailRe, body)
+	if h.Email == "" {
+		h.Email = bodyAnyEmailRe.FindString(body)
+	}
+	return h
+}
+
+// isUserModel reports whether the hints describe a model event on a user.
+func (h bodyHints) isUserModel() bool {
+	return strings.EqualFold(h.ModelName, "user")
+}
+
+func firstSubmatch(re *regexp.Regexp, s string) string {
+	if m := re.FindStringSubmatch(s); m != nil {
+		return strings.TrimSpace(m[1])
+	}
+	return ""
+}
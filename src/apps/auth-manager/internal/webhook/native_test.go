@@ -0,0 +1,98 @@
+package webhook
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func loadFixture(t *testing.T, name string) *AuthentikEvent {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("open fixture: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/webhook/authentik", f)
+	event, err := ParseRequest(req, "")
+	if err != nil {
+		t.Fatalf("parse fixture %s: %v", name, err)
+	}
+	return event
+}
+
+func TestNativePayloads(t *testing.T) {
+	tests := []struct {
+		fixture    string
+		action     string
+		userEvent  bool
+		credential bool
+		want       UserInfo
+	}{
+		{
+			fixture: "native_login.json",
+			action:  ActionLogin,
+			want:    UserInfo{Email: "jane.doe@example.com", Username: "jane"},
+		},
+		{
+			fixture:   "native_model_created_user.json",
+			action:    ActionModelCreated,
+			userEvent: true,
+			// The event user is the admin who created the account and must
+			// not be mistaken for the new user.
+			want: UserInfo{Username: "testuser", Subject: "42"},
+		},
+		{
+			fixture:    "native_password_set.json",
+			action:     ActionPasswordSet,
+			credential: true,
+			want:       UserInfo{Email: "jane.doe@example.com", Username: "jane"},
+		},
+		{
+			fixture: "native_message_only.json",
+			want:    UserInfo{Email: "testuser@example.com", Username: "testuser", FromRecipient: true},
+		},
+		{
+			fixture:    "native_password_set_recipient_only.json",
+			action:     ActionPasswordSet,
+			credential: true,
+			// Only the recipient is named, and it isn't whose password
+			// changed.
+			want: UserInfo{Email: "admin@example.com", Username: "akadmin", FromRecipient: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			event := loadFixture(t, tt.fixture)
+
+			if !event.IsNative() {
+				t.Error("expected IsNative() to return true")
+			}
+			if got := event.Action(); got != tt.action {
+				t.Errorf("Action() = %q, want %q", got, tt.action)
+			}
+			if got := event.IsUserEvent(); got != tt.userEvent {
+				t.Errorf("IsUserEvent() = %v, want %v", got, tt.userEvent)
+			}
+			if got := event.IsCredentialEvent(); got != tt.credential {
+				t.Errorf("IsCredentialEvent() = %v, want %v", got, tt.credential)
+			}
+			if got := *event.ExtractUser(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ExtractUser() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBodyHints_JSONQuoting(t *testing.T) {
+	event := &AuthentikEvent{
+		Body: `model_updated: {"model": {"pk": "7", "app": "authentik_core", "name": "bob", "model_name": "user"}}`,
+	}
+	info := event.ExtractUser()
+	if info.Subject != "7" || info.Username != "bob" {
+		t.Errorf("expected subject 7 and username bob, got %+v", info)
+	}
+}
@@ -0,0 +1,127 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Behavior is what the webhook handler does with an event action.
+type Behavior string
+
+// Supported behaviors.
+const (
+	BehaviorProvision   Behavior = "provision"
+	BehaviorDeprovision Behavior = "deprovision"
+	BehaviorIgnore      Behavior = "ignore"
+)
+
+// Policy maps Authentik event actions to a Behavior.
+type Policy map[string]Behavior
+
+// DefaultPolicy returns the policy used when nothing is configured: create,
+// update, write, and login events provision; deletions deprovision.
+func DefaultPolicy() Policy {
+	return Policy{
+		ActionModelCreated: BehaviorProvision,
+		ActionModelUpdated: BehaviorProvision,
+		ActionUserWrite:    BehaviorProvision,
+		ActionLogin:        BehaviorProvision,
+		ActionModelDeleted: BehaviorDeprovision,
+	}
+}
+
+// Behavior returns the configured behavior for an action. Unmapped actions
+// report BehaviorIgnore with ok=false so callers can count them.
+func (p Policy) Behavior(action string) (b Behavior, ok bool) {
+	b, ok = p[action]
+	if !ok {
+		return BehaviorIgnore, false
+	}
+	return b, true
+}
+
+// String renders the policy as a stable, human-readable list.
+func (p Policy) String() string {
+	actions := make([]string, 0, len(p))
+	for action := range p {
+		actions = append(actions, action)
+	}
+	sort.Strings(actions)
+	parts := make([]string, 0, len(actions))
+	for _, action := range actions {
+		parts = append(parts, action+"="+string(p[action]))
+	}
+	return strings.Join(parts, ",")
+}
+
+// ParsePolicy builds a Policy from either a JSON object mapping actions to
+// behaviors (e.g. {"login":"provision","model_deleted":"ignore"}) or from
+// comma-separated provision/deprovision action lists. The two forms are
+// mutually exclusive; when none is set the DefaultPolicy is returned.
+func ParsePolicy(spec, provisionOn, deprovisionOn string) (Policy, error) {
+	spec = strings.TrimSpace(spec)
+	provisionOn = strings.TrimSpace(provisionOn)
+	deprovisionOn = strings.TrimSpace(deprovisionOn)
+
+	if spec == "" && provisionOn == "" && deprovisionOn == "" {
+		return DefaultPolicy(), nil
+	}
+	if spec != "" && (provisionOn != "" || deprovisionOn != "") {
+		return nil, fmt.Errorf("webhook policy: set either a JSON policy or provision/deprovision lists, not both")
+	}
+
+	policy := Policy{}
+	if spec != "" {
+		var raw map[string]string
+		if err := json.Unmarshal([]byte(spec), &raw); err != nil {
+			return nil, fmt.Errorf("webhook policy: invalid JSON: %w", err)
+		}
+		for action, behavior := range raw {
+			if err := policy.add(action, Behavior(strings.ToLower(strings.TrimSpace(behavior)))); err != nil {
+				return nil, err
+			}
+		}
+		return policy, nil
+	}
+
+	for _, action := range splitList(provisionOn) {
+		if err := policy.add(action, BehaviorProvision); err != nil {
+			return nil, err
+		}
+	}
+	for _, action := range splitList(deprovisionOn) {
+		if err := policy.add(action, BehaviorDeprovision); err != nil {
+			return nil, err
+		}
+	}
+	return policy, nil
+}
+
+func (p Policy) add(action string, behavior Behavior) error {
+	action = strings.TrimSpace(action)
+	if action == "" || strings.ContainsAny(action, " \t\n") {
+		return fmt.Errorf("webhook policy: invalid action %q", action)
+	}
+	switch behavior {
+	case BehaviorProvision, BehaviorDeprovision, BehaviorIgnore:
+	default:
+		return fmt.Errorf("webhook policy: action %q has unknown behavior %q", action, behavior)
+	}
+	if existing, ok := p[action]; ok && existing != behavior {
+		return fmt.Errorf("webhook policy: action %q mapped to both %s and %s", action, existing, behavior)
+	}
+	p[action] = behavior
+	return nil
+}
+
+func splitList(value string) []string {
+	var out []string
+	for _, part := range strings.Split(value, ",") {
+		if trimmed := strings.TrimSpace(part); trimmed != "" {
+			out = append(out, trimmed)
+		}
+	}
+	return out
+}
@@ -0,0 +1,62 @@
+package webhook
+
+import "testing"
+
+func TestParsePolicy_Default(t *testing.T) {
+	policy, err := ParsePolicy("", "", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if b, ok := policy.Behavior(ActionLogin); !ok || b != BehaviorProvision {
+		t.Errorf("expected login to provision, got %q (mapped=%v)", b, ok)
+	}
+	if b, _ := policy.Behavior(ActionModelDeleted); b != BehaviorDeprovision {
+		t.Errorf("expected model_deleted to deprovision, got %q", b)
+	}
+}
+
+func TestParsePolicy_Lists(t *testing.T) {
+	policy, err := ParsePolicy("", "login, user_write", "model_deleted")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if b, _ := policy.Behavior(ActionUserWrite); b != BehaviorProvision {
+		t.Errorf("expected user_write to provision, got %q", b)
+	}
+	if b, ok := policy.Behavior(ActionModelCreated); ok || b != BehaviorIgnore {
+		t.Errorf("expected model_created to be unmapped/ignored, got %q (mapped=%v)", b, ok)
+	}
+	if got, want := policy.String(), "login=provision,model_deleted=deprovision,user_write=provision"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
+
+func TestParsePolicy_JSON(t *testing.T) {
+	policy, err := ParsePolicy(`{"login": "Provision", "model_deleted": "ignore"}`, "", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if b, ok := policy.Behavior(ActionModelDeleted); !ok || b != BehaviorIgnore {
+		t.Errorf("expected model_deleted to be explicitly ignored, got %q (mapped=%v)", b, ok)
+	}
+}
+
+func TestParsePolicy_Invalid(t *testing.T) {
+	tests := []struct {
+		name               string
+		spec, prov, deprov string
+	}{
+		{name: "bad json", spec: `{"login":`},
+		{name: "unknown behavior", spec: `{"login": "explode"}`},
+		{name: "json and lists", spec: `{"login": "provision"}`, prov: "login"},
+		{name: "action in both lists", prov: "login", deprov: "login"},
+		{name: "whitespace in action", prov: "log in"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParsePolicy(tt.spec, tt.prov, tt.deprov); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package mmbridge_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/rave-org/rave/apps/auth-manager/pkg/mmbridge"
+)
+
+// stubMattermost stands in for a Mattermost server, as tests of tools built
+// on mmbridge would. In production, use mmbridge.NewClient.
+type stubMattermost struct{}
+
+func (stubMattermost) EnsureUser(_ context.Context, ident mmbridge.Identity) (mmbridge.User, bool, error) {
+	return mmbridge.User{ID: "user-1", Username: ident.User, Email: ident.Email}, true, nil
+}
+
+func (stubMattermost) CreateSession(_ context.Context, userID string, _ mmbridge.SessionOptions) (mmbridge.Session, error) {
+	return mmbridge.Session{ID: "session-1", Token: "session-token", UserID: userID}, nil
+}
+
+func ExampleBridge_EnsureSession() {
+	bridge := mmbridge.New(stubMattermost{}, mmbridge.Options{
+		PublicURL: "https://chat.example.com",
+		Cookies:   mmbridge.CookieOptions{Domain: "example.com", SameSite: http.SameSiteLaxMode},
+	})
+
+	session, cookies, err := bridge.EnsureSession(context.Background(), mmbridge.CanonicalIdentity{
+		Email:    "dev@example.com",
+		Username: "dev",
+	})
+	if err != nil {
+		fmt.Println("sign-in failed:", err)
+		return
+	}
+	fmt.Println("session", session.ID, "for", session.UserID)
+	for _, cookie := range cookies {
+		fmt.Println(cookie)
+	}
+	// Output:
+	// session session-1 for user-1
+	// MMAUTHTOKEN=session-token; Path=/; Domain=example.com; HttpOnly; Secure; SameSite=Lax
+	// MMUSERID=user-1; Path=/; Domain=example.com; Secure; SameSite=Lax
+}
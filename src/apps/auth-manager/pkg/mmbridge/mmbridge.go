@@ -0,0 +1,230 @@
+// Package mmbridge signs identity-provider users in to Mattermost: it
+// ensures the user's Mattermost account exists, mints a session for them,
+// and builds the cookies Mattermost's web app reads the session from.
+//
+// auth-manager's forward auth is built on it; other tools can sign users in
+// the same way without running the HTTP server. The Mattermost API is an
+// interface, so tests can swap in a fake.
+package mmbridge
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rave-org/rave/apps/auth-manager/internal/mattermost"
+	"github.com/rave-org/rave/apps/auth-manager/internal/provision"
+)
+
+type (
+	// CanonicalIdentity is the user to sign in, as the identity provider
+	// describes them.
+	CanonicalIdentity = provision.CanonicalIdentity
+	// Identity is the user as Mattermost's EnsureUser takes them.
+	Identity = mattermost.Identity
+	// User is a Mattermost user.
+	User = mattermost.User
+	// Session is a Mattermost session.
+	Session = mattermost.Session
+	// SessionOptions are the options a session is created with.
+	SessionOptions = mattermost.SessionOptions
+)
+
+// Cookie names Mattermost's web app reads the session from.
+const (
+	AuthTokenCookie = "MMAUTHTOKEN"
+	UserIDCookie    = "MMUSERID"
+)
+
+// DefaultDevicePrefix prefixes the device ID of sessions when
+// Options.DevicePrefix is empty.
+const DefaultDevicePrefix = "rave-sso"
+
+// MattermostAPI is the part of the Mattermost API a Bridge uses.
+type MattermostAPI interface {
+	// EnsureUser returns the user for ident, creating them when they don't
+	// exist yet, and reports whether they were created.
+	EnsureUser(ctx context.Context, ident Identity) (user User, created bool, err error)
+	// CreateSession mints a session for the user with ID userID.
+	CreateSession(ctx context.Context, userID string, opts SessionOptions) (Session, error)
+}
+
+// Stage is the step of signing a user in that failed.
+type Stage string
+
+const (
+	// StageProvision is ensuring the user's Mattermost account.
+	StageProvision Stage = "provision"
+	// StageSession is minting the session.
+	StageSession Stage = "session"
+)
+
+// Error is a failure to sign a user in, with the step that failed.
+type Error struct {
+	Stage Stage
+	Err   error
+}
+
+func (e *Error) Error() string { return string(e.Stage) + ": " + e.Err.Error() }
+func (e *Error) Unwrap() error { return e.Err }
+
+// ErrorStage returns the step err failed at, or "" when it isn't an Error.
+func ErrorStage(err error) Stage {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Stage
+	}
+	return ""
+}
+
+// NewClient returns a MattermostAPI calling the Mattermost server at
+// baseURL with an admin's personal access token.
+func NewClient(baseURL, adminToken string) MattermostAPI {
+	return mattermost.NewClient(baseURL, adminToken)
+}
+
+// Options configure a Bridge.
+type Options struct {
+	// PublicURL is where browsers reach Mattermost. EnsureSession's cookies
+	// are built for it.
+	PublicURL string
+	// SessionTTL is how long browser sessions last; zero inherits the
+	// Mattermost server default.
+	SessionTTL time.Duration
+	// XHRSessionTTL is how long sessions for API clients last; zero uses
+	// SessionTTL.
+	XHRSessionTTL time.Duration
+	// DevicePrefix prefixes sessions' device IDs, "<prefix>:web" or
+	// "<prefix>:xhr", so they stand out in Mattermost's session list.
+	// Empty uses DefaultDevicePrefix.
+	DevicePrefix string
+	// Cookies configure the session cookies.
+	Cookies CookieOptions
+}
+
+// CookieOptions configure session cookies.
+type CookieOptions struct {
+	// Domain scopes cookies for hosts in it, such as "example.com" for
+	// chat.example.com. Cookies for other hosts are host-only.
+	Domain string
+	// SameSite is the cookies' SameSite attribute.
+	SameSite http.SameSite
+}
+
+// Cookie builds a session cookie for a service at public: Secure when it's
+// served over https, and scoped to Domain when the host is in it.
+// Otherwise it is host-only, so each of several hostnames gets its own.
+func (o CookieOptions) Cookie(name, value string, public *url.URL) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: o.SameSite,
+	}
+	if public == nil {
+		return cookie
+	}
+	cookie.Secure = strings.EqualFold(public.Scheme, "https")
+	if domain := strings.ToLower(strings.TrimPrefix(o.Domain, ".")); domain != "" {
+		if host := strings.ToLower(public.Hostname()); host == domain || strings.HasSuffix(host, "."+domain) {
+			cookie.Domain = domain
+		}
+	}
+	return cookie
+}
+
+// Bridge signs users in to Mattermost.
+type Bridge struct {
+	api  MattermostAPI
+	opts Options
+}
+
+// New returns a Bridge calling api.
+func New(api MattermostAPI, opts Options) *Bridge {
+	return &Bridge{api: api, opts: opts}
+}
+
+// EnsureSession ensures ident's Mattermost user exists and mints a browser
+// session for them, returning it with the cookies that sign the browser in
+// at Options.PublicURL.
+func (b *Bridge) EnsureSession(ctx context.Context, ident CanonicalIdentity) (Session, []*http.Cookie, error) {
+	public, err := url.Parse(b.opts.PublicURL)
+	if err != nil {
+		return Session{}, nil, fmt.Errorf("mattermost public url: %w", err)
+	}
+	session, err := b.CreateSession(ctx, ident, false)
+	if err != nil {
+		return Session{}, nil, err
+	}
+	return session, b.Cookies(session, public), nil
+}
+
+// CreateSession ensures ident's Mattermost user exists and mints a session
+// for them, for an API client when xhr is set and a browser otherwise. The
+// session's UserID is always set. Failures are *Error, saying which step
+// failed.
+func (b *Bridge) CreateSession(ctx context.Context, ident CanonicalIdentity, xhr bool) (Session, error) {
+	user, _, err := b.api.EnsureUser(ctx, Identity{
+		Email: ident.Email,
+		Name:  ident.Name,
+		User:  ident.Username,
+	})
+	if err != nil {
+		return Session{}, &Error{Stage: StageProvision, Err: err}
+	}
+	session, err := b.api.CreateSession(ctx, user.ID, b.SessionOptions(xhr))
+	if err != nil {
+		return Session{}, &Error{Stage: StageSession, Err: err}
+	}
+	if session.UserID == "" {
+		session.UserID = user.ID
+	}
+	return session, nil
+}
+
+// SessionOptions returns the options sessions are created with.
+func (b *Bridge) SessionOptions(xhr bool) SessionOptions {
+	kind, ttl := "web", b.opts.SessionTTL
+	if xhr {
+		kind = "xhr"
+		if b.opts.XHRSessionTTL > 0 {
+			ttl = b.opts.XHRSessionTTL
+		}
+	}
+	prefix := b.opts.DevicePrefix
+	if prefix == "" {
+		prefix = DefaultDevicePrefix
+	}
+	return SessionOptions{
+		TTL:      ttl,
+		DeviceID: prefix + ":" + kind,
+		Props:    map[string]string{"rave_source": "auth-manager"},
+	}
+}
+
+// Cookies returns the cookies signing a browser in to Mattermost at public
+// with session. MMUSERID isn't HttpOnly: Mattermost's web app reads it, and
+// it's no secret.
+func (b *Bridge) Cookies(session Session, public *url.URL) []*http.Cookie {
+	userID := b.opts.Cookies.Cookie(UserIDCookie, session.UserID, public)
+	userID.HttpOnly = false
+	return []*http.Cookie{
+		b.opts.Cookies.Cookie(AuthTokenCookie, session.Token, public),
+		userID,
+	}
+}
+
+// ExpiredCookies returns cookies that sign a browser out of Mattermost at
+// public, replacing the ones Cookies built.
+func (b *Bridge) ExpiredCookies(public *url.URL) []*http.Cookie {
+	cookies := b.Cookies(Session{}, public)
+	for _, cookie := range cookies {
+		cookie.MaxAge = -1
+	}
+	return cookies
+}
@@ -0,0 +1,156 @@
+package mmbridge
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// fakeAPI is a MattermostAPI recording its calls.
+type fakeAPI struct {
+	users      map[string]User // by email
+	ensureErr  error
+	sessionErr error
+
+	ensured []Identity
+	opts    []SessionOptions
+}
+
+func (f *fakeAPI) EnsureUser(_ context.Context, ident Identity) (User, bool, error) {
+	f.ensured = append(f.ensured, ident)
+	if f.ensureErr != nil {
+		return User{}, false, f.ensureErr
+	}
+	if user, ok := f.users[ident.Email]; ok {
+		return user, false, nil
+	}
+	if f.users == nil {
+		f.users = map[string]User{}
+	}
+	user := User{ID: "u" + ident.User, Username: ident.User, Email: ident.Email}
+	f.users[ident.Email] = user
+	return user, true, nil
+}
+
+func (f *fakeAPI) CreateSession(_ context.Context, userID string, opts SessionOptions) (Session, error) {
+	f.opts = append(f.opts, opts)
+	if f.sessionErr != nil {
+		return Session{}, f.sessionErr
+	}
+	return Session{ID: "s1", Token: "token-for-" + userID}, nil
+}
+
+func TestEnsureSession(t *testing.T) {
+	api := &fakeAPI{}
+	bridge := New(api, Options{
+		PublicURL:  "https://chat.example.com",
+		SessionTTL: time.Hour,
+		Cookies:    CookieOptions{Domain: ".example.com", SameSite: http.SameSiteLaxMode},
+	})
+
+	session, cookies, err := bridge.EnsureSession(context.Background(), CanonicalIdentity{
+		Email: "dev@example.com", Name: "Dev", Username: "dev",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Token != "token-for-udev" || session.UserID != "udev" {
+		t.Errorf("session = %+v, want udev's with its user ID filled in", session)
+	}
+	if want := (Identity{Email: "dev@example.com", Name: "Dev", User: "dev"}); len(api.ensured) != 1 || api.ensured[0] != want {
+		t.Errorf("EnsureUser got %+v, want %+v", api.ensured, want)
+	}
+	if opts := api.opts[0]; opts.TTL != time.Hour || opts.DeviceID != "rave-sso:web" {
+		t.Errorf("session options = %+v", opts)
+	}
+
+	if len(cookies) != 2 {
+		t.Fatalf("cookies = %v, want MMAUTHTOKEN and MMUSERID", cookies)
+	}
+	for i, want := range []struct {
+		name, value string
+		httpOnly    bool
+	}{
+		{AuthTokenCookie, "token-for-udev", true},
+		{UserIDCookie, "udev", false},
+	} {
+		c := cookies[i]
+		if c.Name != want.name || c.Value != want.value || c.HttpOnly != want.httpOnly {
+			t.Errorf("cookie %d = %s=%s HttpOnly=%v, want %s=%s HttpOnly=%v", i, c.Name, c.Value, c.HttpOnly, want.name, want.value, want.httpOnly)
+		}
+		if !c.Secure || c.Domain != "example.com" || c.Path != "/" || c.SameSite != http.SameSiteLaxMode {
+			t.Errorf("cookie %s = %+v, want Secure, Domain example.com, Path /, SameSite Lax", c.Name, c)
+		}
+	}
+}
+
+func TestEnsureSessionErrors(t *testing.T) {
+	boom := errors.New("boom")
+	for _, tc := range []struct {
+		name string
+		api  *fakeAPI
+		want Stage
+	}{
+		{"ensure", &fakeAPI{ensureErr: boom}, StageProvision},
+		{"session", &fakeAPI{sessionErr: boom}, StageSession},
+	} {
+		_, cookies, err := New(tc.api, Options{}).EnsureSession(context.Background(), CanonicalIdentity{Email: "dev@example.com"})
+		if !errors.Is(err, boom) || ErrorStage(err) != tc.want {
+			t.Errorf("%s: err = %v (stage %q), want boom at %q", tc.name, err, ErrorStage(err), tc.want)
+		}
+		if cookies != nil {
+			t.Errorf("%s: cookies = %v, want none", tc.name, cookies)
+		}
+	}
+	if stage := ErrorStage(boom); stage != "" {
+		t.Errorf("ErrorStage(boom) = %q, want none", stage)
+	}
+}
+
+func TestSessionOptions(t *testing.T) {
+	bridge := New(nil, Options{SessionTTL: time.Hour, XHRSessionTTL: time.Minute, DevicePrefix: "tools"})
+	if opts := bridge.SessionOptions(true); opts.TTL != time.Minute || opts.DeviceID != "tools:xhr" {
+		t.Errorf("XHR options = %+v", opts)
+	}
+	if opts := bridge.SessionOptions(false); opts.TTL != time.Hour || opts.DeviceID != "tools:web" {
+		t.Errorf("web options = %+v", opts)
+	}
+	if opts := New(nil, Options{SessionTTL: time.Hour}).SessionOptions(true); opts.TTL != time.Hour {
+		t.Errorf("XHR TTL without XHRSessionTTL = %v, want SessionTTL", opts.TTL)
+	}
+}
+
+func TestCookie(t *testing.T) {
+	opts := CookieOptions{Domain: "example.com"}
+	for _, tc := range []struct {
+		public, domain string
+		secure         bool
+	}{
+		{"https://chat.example.com", "example.com", true},
+		{"https://example.com:8443", "example.com", true},
+		{"http://chat.example.org", "", false},
+		{"http://notexample.com", "", false},
+		{"HTTPS://[::1]:8065", "", true},
+	} {
+		public, _ := url.Parse(tc.public)
+		c := opts.Cookie("n", "v", public)
+		if c.Domain != tc.domain || c.Secure != tc.secure {
+			t.Errorf("Cookie at %s: Domain %q Secure %v, want %q %v", tc.public, c.Domain, c.Secure, tc.domain, tc.secure)
+		}
+	}
+	if c := opts.Cookie("n", "v", nil); c.Domain != "" || c.Secure {
+		t.Errorf("Cookie without a URL = %+v, want host-only and not Secure", c)
+	}
+}
+
+func TestExpiredCookies(t *testing.T) {
+	public, _ := url.Parse("https://chat.example.com")
+	for _, c := range New(nil, Options{}).ExpiredCookies(public) {
+		if c.Value != "" || c.MaxAge >= 0 {
+			t.Errorf("expired cookie %s = %+v", c.Name, c)
+		}
+	}
+}